@@ -0,0 +1,26 @@
+#!/bin/bash -e
+# Compares the benchmarks of the working tree with the ones of a baseline git ref (e.g. master)
+# and fails, if the throughput of a benchmark dropped by more than 20%.
+# usage: ./.benchmark_gate.sh <baseline-ref>
+
+baseline_ref=${1:-master}
+bench_args="-run ^$ -bench . -benchtime 1s -count 3"
+
+# runs the benchmarks in the given directory and prints the best ns/op per benchmark
+run_benchmarks() {
+    (cd $1 && go test $bench_args ./... 2>/dev/null) | \
+        awk '/^Benchmark/ && $4 == "ns/op" { name=$1; sub(/-[0-9]+$/, "", name); if (!(name in best) || $3 < best[name]) best[name]=$3 }
+             END { for (name in best) print name, best[name] }' | sort
+}
+
+baseline_dir=$(mktemp -d)
+trap "git worktree remove --force $baseline_dir" EXIT
+git worktree add --detach $baseline_dir $baseline_ref > /dev/null 2>&1
+
+run_benchmarks $baseline_dir > $baseline_dir/bench_baseline.txt
+run_benchmarks . > $baseline_dir/bench_current.txt
+
+# throughput drop of 20% = 1.25 times the time per operation
+join $baseline_dir/bench_baseline.txt $baseline_dir/bench_current.txt | \
+    awk '{ ratio=$3/$2; printf "%-50s %12d ns/op %12d ns/op %+7.1f%%\n", $1, $2, $3, (ratio-1)*100; if (ratio > 1.25) failed=1 }
+         END { if (failed) { print "benchmark regression of more than 20% throughput"; exit 1 } }'
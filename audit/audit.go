@@ -0,0 +1,107 @@
+// Package audit provides the audit trail of the authentication events of loginsrv.
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventType is the kind of an audit event
+type EventType string
+
+// The audit event types
+const (
+	LoginSuccess  EventType = "login_success"
+	LoginFailure  EventType = "login_failure"
+	Logout        EventType = "logout"
+	TokenRefresh  EventType = "token_refresh"
+	ProviderError EventType = "provider_error"
+)
+
+// Event is an authentication event of a user or a client.
+type Event struct {
+	Time          time.Time `json:"time"`
+	Type          EventType `json:"type"`
+	Username      string    `json:"username,omitempty"`
+	Origin        string    `json:"origin,omitempty"`
+	ClientIP      string    `json:"client_ip,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	// Reason describes failures and errors
+	Reason string `json:"reason,omitempty"`
+}
+
+// Sink stores or forwards the audit events.
+type Sink interface {
+	// Write emits the event. Implementations have to be safe for concurrent use.
+	Write(event Event) error
+
+	// Close releases the resources of the sink.
+	Close() error
+}
+
+// NewSink creates the sinks of a comma separated list of specs:
+//
+//	file:/path/to/audit.log  appends the events as JSON lines to the file
+//	syslog[:tag]             sends the events as JSON to the local syslog daemon with the facility authpriv
+//	https://..., http://...  posts every event as JSON to the webhook url
+func NewSink(specs string) (Sink, error) {
+	sinks := MultiSink{}
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		sink, err := newSink(spec)
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sinks, nil
+}
+
+func newSink(spec string) (Sink, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileSink(strings.TrimPrefix(spec, "file:"))
+	case spec == "syslog":
+		return NewSyslogSink(defaultSyslogTag)
+	case strings.HasPrefix(spec, "syslog:"):
+		return NewSyslogSink(strings.TrimPrefix(spec, "syslog:"))
+	case strings.HasPrefix(spec, "https://") || strings.HasPrefix(spec, "http://"):
+		return NewWebhookSink(spec, defaultWebhookTimeout), nil
+	default:
+		return nil, fmt.Errorf("unsupported audit sink %q, expected file:<path>, syslog[:<tag>] or a webhook url", spec)
+	}
+}
+
+// MultiSink writes the events to all of its sinks.
+type MultiSink []Sink
+
+// Write writes the event to every sink, also if one of them fails, and returns the first error.
+func (sinks MultiSink) Write(event Event) error {
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.Write(event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close closes all sinks and returns the first error.
+func (sinks MultiSink) Close() error {
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
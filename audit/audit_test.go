@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+var testEvent = Event{
+	Time:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	Type:     LoginFailure,
+	Username: "bob",
+	Origin:   "htpasswd",
+	ClientIP: "10.0.0.1",
+	Reason:   "invalid credentials",
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "loginsrv-audit")
+	NoError(t, err)
+	return dir
+}
+
+func TestFileSink(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "audit.log")
+
+	sink, err := NewFileSink(filename)
+	NoError(t, err)
+	NoError(t, sink.Write(testEvent))
+	NoError(t, sink.Write(Event{Type: Logout, Username: "alice"}))
+	NoError(t, sink.Close())
+
+	info, err := os.Stat(filename)
+	NoError(t, err)
+	Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// reopening appends
+	sink, err = NewFileSink(filename)
+	NoError(t, err)
+	NoError(t, sink.Write(Event{Type: TokenRefresh, Username: "bob"}))
+	NoError(t, sink.Close())
+
+	file, err := os.Open(filename)
+	NoError(t, err)
+	defer file.Close()
+	events := []Event{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		event := Event{}
+		NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	Equal(t, 3, len(events))
+	Equal(t, testEvent, events[0])
+	Equal(t, Logout, events[1].Type)
+	Equal(t, TokenRefresh, events[2].Type)
+}
+
+func TestFileSink_InvalidPath(t *testing.T) {
+	_, err := NewFileSink("/does/not/exist/audit.log")
+	Error(t, err)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "POST", r.Method)
+		Equal(t, "application/json", r.Header.Get("Content-Type"))
+		NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(202)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, time.Second)
+	NoError(t, sink.Write(testEvent))
+	Equal(t, testEvent, received)
+	NoError(t, sink.Close())
+}
+
+func TestWebhookSink_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	sink := NewWebhookSink(server.URL, time.Second)
+	EqualError(t, sink.Write(testEvent), "audit webhook returned status 500")
+
+	server.Close()
+	Error(t, sink.Write(testEvent))
+}
+
+func TestNewSink(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	sink, err := NewSink("file:" + filepath.Join(dir, "audit.log"))
+	NoError(t, err)
+	IsType(t, &FileSink{}, sink)
+	NoError(t, sink.Close())
+
+	sink, err = NewSink("https://siem.example.com/events")
+	NoError(t, err)
+	IsType(t, &WebhookSink{}, sink)
+
+	sink, err = NewSink(" file:" + filepath.Join(dir, "audit.log") + " , http://siem.example.com/events")
+	NoError(t, err)
+	IsType(t, MultiSink{}, sink)
+	Equal(t, 2, len(sink.(MultiSink)))
+	NoError(t, sink.Close())
+
+	_, err = NewSink("kafka://broker")
+	Error(t, err)
+
+	_, err = NewSink("file:" + filepath.Join(dir, "audit.log") + ",foo")
+	Error(t, err)
+}
+
+type testSink struct {
+	events []Event
+	err    error
+	closed bool
+}
+
+func (s *testSink) Write(event Event) error {
+	s.events = append(s.events, event)
+	return s.err
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return s.err
+}
+
+func TestMultiSink(t *testing.T) {
+	failing := &testSink{err: errors.New("failed")}
+	ok := &testSink{}
+	sinks := MultiSink{failing, ok}
+
+	EqualError(t, sinks.Write(testEvent), "failed")
+	Equal(t, []Event{testEvent}, failing.events)
+	Equal(t, []Event{testEvent}, ok.events)
+
+	EqualError(t, sinks.Close(), "failed")
+	True(t, failing.closed)
+	True(t, ok.closed)
+}
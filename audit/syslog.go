@@ -0,0 +1,43 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+const defaultSyslogTag = "loginsrv"
+
+// SyslogSink sends the events as JSON to the local syslog daemon.
+// The facility is authpriv, which most systems log to a file, that is only readable for root.
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink connects to the local syslog daemon.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	writer, err := syslog.New(syslog.LOG_AUTHPRIV|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+// Write sends the event with the severity notice for failures and errors and info otherwise
+func (s *SyslogSink) Write(event Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.Type == LoginFailure || event.Type == ProviderError {
+		return s.writer.Notice(string(b))
+	}
+	return s.writer.Info(string(b))
+}
+
+// Close closes the connection to the syslog daemon
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
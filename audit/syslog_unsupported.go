@@ -0,0 +1,26 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package audit
+
+import "errors"
+
+const defaultSyslogTag = "loginsrv"
+
+// SyslogSink is not supported on this platform.
+type SyslogSink struct{}
+
+// NewSyslogSink returns an error, because there is no syslog on this platform.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	return nil, errors.New("the syslog audit sink is not supported on this platform")
+}
+
+// Write is never called, because NewSyslogSink fails
+func (s *SyslogSink) Write(event Event) error {
+	return errors.New("syslog is not supported")
+}
+
+// Close does nothing
+func (s *SyslogSink) Close() error {
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/afdecastro879/loginsrv/login"
 	"github.com/caddyserver/caddy/caddyhttp/httpserver"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -39,10 +40,30 @@ func (h *CaddyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, e
 		repl.Set("user", userInfo.Sub)
 	}
 
-	if strings.HasPrefix(r.URL.Path, h.config.LoginPath) {
+	if strings.HasPrefix(r.URL.Path, h.config.LoginPath) ||
+		(r.URL.Path == login.JWKSPath && h.loginHandler.PublishesJWKS()) ||
+		(r.URL.Path == login.OIDCDiscoveryPath && h.loginHandler.ProvidesOIDC()) {
 		h.loginHandler.ServeHTTP(w, r)
 		return 0, nil
 	}
 
+	if valid && r.Method == "GET" && isNavigation(r) && h.loginHandler.ExpiresSoon(userInfo) {
+		// redirect to the login page before the token expires,
+		// so that the user does not get logged out in the middle of an action
+		http.Redirect(w, r, h.reloginURL(r), http.StatusFound)
+		return 0, nil
+	}
+
 	return h.next.ServeHTTP(w, r)
 }
+
+// reloginURL returns the url of the login page with the current request as redirect target
+func (h *CaddyHandler) reloginURL(r *http.Request) string {
+	return h.config.LoginPath + "?" + url.QueryEscape(h.config.RedirectQueryParameter) + "=" + url.QueryEscape(r.URL.RequestURI())
+}
+
+// isNavigation returns true for requests of html pages by the browser,
+// in contrast to requests of assets or api calls which can not follow a login redirect.
+func isNavigation(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
@@ -134,3 +134,64 @@ func Test_ServeHTTP_login(t *testing.T) {
 		t.Errorf("Expected returned status code to be %d, got %d", 0, status)
 	}
 }
+
+//Tests the redirect to the login page, if the token expires within the login expiry buffer
+func Test_ServeHTTP_expiryBuffer(t *testing.T) {
+	configh := login.DefaultConfig()
+	configh.Backends = login.Options{"simple": {"bob": "secret"}}
+	loginh, err := login.NewHandler(configh)
+	if err != nil {
+		t.Errorf("Expected nil error, got: %v", err)
+	}
+
+	h := &CaddyHandler{
+		next: httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			return http.StatusOK, nil
+		}),
+		config:       configh,
+		loginHandler: loginh,
+	}
+
+	for _, test := range []struct {
+		expiry         time.Duration
+		accept         string
+		expectedStatus int
+		expectedURL    string
+	}{
+		{time.Hour, "text/html", 200, ""},
+		{time.Minute, "text/html", 302, "/login?backTo=%2Fsome%2Fpage%3Fa%3Db"},
+		{time.Minute, "application/json", 200, ""},
+	} {
+		userInfo := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(test.expiry).Unix()}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, userInfo).SignedString([]byte(configh.JwtSecret))
+		if err != nil {
+			t.Errorf("Expected nil error, got: %v", err)
+		}
+
+		r, err := http.NewRequest("GET", "/some/page?a=b", nil)
+		if err != nil {
+			t.Fatalf("Unable to create request: %v", err)
+		}
+		r.Header.Set("Accept", test.accept)
+		r.AddCookie(&http.Cookie{Name: "jwt_token", Value: token})
+
+		w := httptest.NewRecorder()
+		status, err := h.ServeHTTP(w, r)
+		if err != nil {
+			t.Errorf("Expected nil error, got: %v", err)
+		}
+
+		if test.expectedURL == "" {
+			if status != test.expectedStatus {
+				t.Errorf("Expected returned status code to be %d, got %d", test.expectedStatus, status)
+			}
+			continue
+		}
+		if w.Code != test.expectedStatus {
+			t.Errorf("Expected response code to be %d, got %d", test.expectedStatus, w.Code)
+		}
+		if w.Header().Get("Location") != test.expectedURL {
+			t.Errorf("Expected redirect to %q, got %q", test.expectedURL, w.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package caddy
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/caddyserver/caddy"
+)
+
+// The environment variables, which caddy-jwt reads for the key of a jwt directive
+// without a secret or publickey parameter.
+const (
+	envSecret    = "JWT_SECRET"
+	envPublicKey = "JWT_PUBLIC_KEY"
+)
+
+func init() {
+	// caddy-jwt is set up after all login directives, so a new loading of the caddyfile starts after this callback.
+	caddy.RegisterParsingCallback("http", "login", func(caddy.Context) error {
+		sharedKeys.reset()
+		return nil
+	})
+}
+
+var sharedKeys = &keySharing{
+	written: map[string]string{},
+	sites:   map[string]string{},
+}
+
+// keySharing publishes the verification key of loginsrv to caddy-jwt.
+type keySharing struct {
+	mutex sync.Mutex
+
+	// written contains the values, which were set by loginsrv,
+	// to distinguish them from variables, which were set outside of caddy
+	written map[string]string
+
+	// sites contains the site, which published the variable in the current loading of the caddyfile
+	sites map[string]string
+}
+
+// lookupEnv returns the environment variable, if it was not set by loginsrv.
+func (s *keySharing) lookupEnv(name string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, exist := os.LookupEnv(name)
+	if !exist || s.written[name] == value {
+		return "", false
+	}
+	return value, true
+}
+
+// share publishes the key of the site, so that caddy-jwt uses the same key without configuration:
+// the secret in JWT_SECRET for the HS algorithms and the public key in JWT_PUBLIC_KEY for the RS and ES algorithms.
+// Variables, which were set outside of caddy, are not changed.
+// caddy-jwt can only read one key from the environment, so only the key of the first site is published.
+func (s *keySharing) share(site string, config *login.Config, handler *login.Handler) {
+	name, other, value := envSecret, envPublicKey, config.JwtSecret
+	if !strings.HasPrefix(config.JwtAlgo, "HS") {
+		if strings.HasPrefix(config.JwtAlgo, "PS") {
+			logging.Logger.Warnf("The key of %v is not shared with caddy-jwt, which does not support the %v algorithm", site, config.JwtAlgo)
+			return
+		}
+		pem, ok := handler.PublicKeyPEM()
+		if !ok {
+			return
+		}
+		name, other, value = envPublicKey, envSecret, pem
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if current, exist := os.LookupEnv(other); exist {
+		if s.written[other] != current {
+			// caddy-jwt was configured by the environment
+			return
+		}
+		if firstSite, published := s.sites[other]; published {
+			logging.Logger.Warnf("The key of %v is not shared with caddy-jwt, because %v uses a different algorithm. "+
+				"Configure the key by the secret or publickey parameter of the jwt directive", site, firstSite)
+			return
+		}
+		// outdated value of a previous loading of the caddyfile
+		os.Unsetenv(other)
+		delete(s.written, other)
+	}
+
+	if current, exist := os.LookupEnv(name); exist {
+		if s.written[name] != current {
+			return
+		}
+		if firstSite, published := s.sites[name]; published {
+			if current != value {
+				logging.Logger.Warnf("The key of %v is not shared with caddy-jwt, because caddy-jwt reads only the key of %v from %v. "+
+					"Configure the key by the secret or publickey parameter of the jwt directive", site, firstSite, name)
+			}
+			return
+		}
+	}
+
+	os.Setenv(name, value)
+	s.written[name] = value
+	s.sites[name] = site
+}
+
+// reset forgets the sites, but not the written values
+func (s *keySharing) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sites = map[string]string{}
+}
@@ -0,0 +1,83 @@
+package caddy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/caddyserver/caddy"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSetup_SharesPublicKey(t *testing.T) {
+	os.Unsetenv(envSecret)
+	os.Unsetenv(envPublicKey)
+	sharedKeys.reset()
+	t.Cleanup(func() {
+		os.Unsetenv(envSecret)
+		os.Unsetenv(envPublicKey)
+		sharedKeys.reset()
+	})
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	f, err := ioutil.TempFile("", "loginsrv_key")
+	NoError(t, err)
+	defer os.Remove(f.Name())
+	NoError(t, pem.Encode(f, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	f.Close()
+
+	NoError(t, setup(caddy.NewTestController("http", `login {
+                                        simple bob=secret
+                                        jwt_algo RS256
+                                        jwt_private_key `+f.Name()+`
+                                }`)))
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(os.Getenv(envPublicKey)))
+	NoError(t, err)
+	Equal(t, &key.PublicKey, publicKey)
+	_, exist := os.LookupEnv(envSecret)
+	False(t, exist)
+
+	// caddy-jwt can only read one key, so the first site wins
+	publishedKey := os.Getenv(envPublicKey)
+	NoError(t, setup(caddy.NewTestController("http", `login {
+                                        simple bob=secret
+                                        jwt_secret xxx
+                                }`)))
+	Equal(t, publishedKey, os.Getenv(envPublicKey))
+	_, exist = os.LookupEnv(envSecret)
+	False(t, exist)
+
+	// a new loading of the caddyfile replaces the keys, which were published by loginsrv
+	sharedKeys.reset()
+	NoError(t, setup(caddy.NewTestController("http", `login {
+                                        simple bob=secret
+                                        jwt_secret xxx
+                                }`)))
+	Equal(t, "xxx", os.Getenv(envSecret))
+	_, exist = os.LookupEnv(envPublicKey)
+	False(t, exist)
+}
+
+func TestSetup_KeepsExternalPublicKey(t *testing.T) {
+	os.Unsetenv(envSecret)
+	os.Setenv(envPublicKey, "external")
+	sharedKeys.reset()
+	t.Cleanup(func() {
+		os.Unsetenv(envPublicKey)
+		sharedKeys.reset()
+	})
+
+	NoError(t, setup(caddy.NewTestController("http", `login {
+                                        simple bob=secret
+                                        jwt_secret xxx
+                                }`)))
+	Equal(t, "external", os.Getenv(envPublicKey))
+	_, exist := os.LookupEnv(envSecret)
+	False(t, exist)
+}
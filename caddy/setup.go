@@ -3,9 +3,9 @@ package caddy
 import (
 	"flag"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/afdecastro879/loginsrv/logging"
@@ -14,10 +14,18 @@ import (
 	"github.com/caddyserver/caddy/caddyhttp/httpserver"
 
 	// Import all backends, packaged with the caddy plugin
+	_ "github.com/afdecastro879/loginsrv/dbbackend"
 	_ "github.com/afdecastro879/loginsrv/htpasswd"
+	_ "github.com/afdecastro879/loginsrv/httpauth"
 	_ "github.com/afdecastro879/loginsrv/httpupstream"
+	_ "github.com/afdecastro879/loginsrv/ldap"
+	_ "github.com/afdecastro879/loginsrv/magiclink"
+	_ "github.com/afdecastro879/loginsrv/mongodb"
 	_ "github.com/afdecastro879/loginsrv/oauth2"
 	_ "github.com/afdecastro879/loginsrv/osiam"
+	_ "github.com/afdecastro879/loginsrv/pam"
+	_ "github.com/afdecastro879/loginsrv/radius"
+	_ "github.com/afdecastro879/loginsrv/spiffe"
 )
 
 func init() {
@@ -52,6 +60,7 @@ func setup(c *caddy.Controller) error {
 		if err != nil {
 			return err
 		}
+		sharedKeys.share(c.Key, config, loginHandler)
 
 		httpserver.GetConfig(c).AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
 			return NewCaddyHandler(next, loginHandler, config)
@@ -71,24 +80,48 @@ func parseConfig(c *caddy.Controller) (*login.Config, error) {
 	cfg.ConfigureFlagSet(fs)
 
 	secretProvidedByConfig := false
+	seen := map[string]bool{}
 	for c.NextBlock() {
 		// caddy prefers '_' in parameter names,
 		// so we map them to the '-' from the command line flags
 		// the replacement supports both, for backwards compatibility
 		name := strings.Replace(c.Val(), "_", "-", -1)
-		args := c.RemainingArgs()
-		if len(args) != 1 {
-			return cfg, fmt.Errorf("Wrong number of arguments for %v: %v (%v:%v)", name, args, c.File(), c.Line())
-		}
-		value := args[0]
-
+		line := c.Line()
 		f := fs.Lookup(name)
 		if f == nil {
-			return cfg, fmt.Errorf("Unknown parameter for login directive: %v (%v:%v)", name, c.File(), c.Line())
+			return cfg, fmt.Errorf("Unknown parameter for login directive: %v (%v:%v)", name, c.File(), line)
 		}
+		// the deprecated backend parameter is used once for every backend
+		if seen[name] && name != "backend" {
+			return cfg, fmt.Errorf("Duplicate parameter for login directive: %v (%v:%v)", name, c.File(), line)
+		}
+		seen[name] = true
+
+		// multiple arguments are joined, e.g. github client_id=.. client_secret=..
+		args := c.RemainingArgs()
+		value := strings.Join(args, ",")
+		if c.NextArg() {
+			// the options of a provider can be written as block with one option per line
+			if len(args) != 0 {
+				return cfg, fmt.Errorf("Unexpected arguments before the block of %v: %v (%v:%v)", name, args, c.File(), line)
+			}
+			opts, err := blockOptions(c, name)
+			if err != nil {
+				return cfg, err
+			}
+			if desc, exist := login.GetProviderDescription(name); exist {
+				if err := desc.Validate(opts); err != nil {
+					return cfg, fmt.Errorf("%v (%v:%v)", err, c.File(), line)
+				}
+			}
+			value = joinOptions(opts)
+		} else if len(args) == 0 {
+			return cfg, fmt.Errorf("Wrong number of arguments for %v: %v (%v:%v)", name, args, c.File(), line)
+		}
+
 		err := f.Value.Set(value)
 		if err != nil {
-			return cfg, fmt.Errorf("Invalid value for parameter %v: %v (%v:%v)", name, value, c.File(), c.Line())
+			return cfg, fmt.Errorf("Invalid value for parameter %v: %v (%v:%v)", name, value, c.File(), line)
 		}
 
 		if name == "jwt-secret" {
@@ -96,14 +129,49 @@ func parseConfig(c *caddy.Controller) (*login.Config, error) {
 		}
 	}
 
-	secretFromEnv, secretFromEnvWasSetBefore := os.LookupEnv("JWT_SECRET")
+	if err := cfg.ResolveSecrets(); err != nil {
+		return cfg, err
+	}
+
+	// the secret is shared with caddy-jwt after the creation of the handler
+	secretFromEnv, secretFromEnvWasSetBefore := sharedKeys.lookupEnv(envSecret)
 	if !secretProvidedByConfig && secretFromEnvWasSetBefore {
 		cfg.JwtSecret = secretFromEnv
 	}
-	if !secretFromEnvWasSetBefore {
-		// populate the secret to caddy.jwt,
-		// but do not change a environment variable, which somebody has set it.
-		os.Setenv("JWT_SECRET", cfg.JwtSecret)
-	}
 	return cfg, nil
 }
+
+// blockOptions reads the options of a block with one option per line in the form: key value
+func blockOptions(c *caddy.Controller, name string) (map[string]string, error) {
+	opts := map[string]string{}
+	for c.Next() {
+		key := c.Val()
+		if key == "}" {
+			return opts, nil
+		}
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, fmt.Errorf("Wrong number of arguments for %v of %v: %v (%v:%v)", key, name, args, c.File(), c.Line())
+		}
+		if _, exist := opts[key]; exist {
+			return nil, fmt.Errorf("Duplicate option %v of %v (%v:%v)", key, name, c.File(), c.Line())
+		}
+		opts[key] = args[0]
+	}
+	return nil, fmt.Errorf("Missing } of the block of %v (%v)", name, c.File())
+}
+
+// joinOptions returns the options in the form key1=value1,key2=.. with escaped commas
+func joinOptions(opts map[string]string) string {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(opts))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+strings.Replace(opts[key], ",", `\,`, -1))
+	}
+	return strings.Join(pairs, ",")
+}
@@ -94,8 +94,40 @@ func TestSetup(t *testing.T) {
 				Equal(t, expectedBackendCfg, cfg.Backends, "The backend config should be set by \"backend provider=\" for backwards compatibility")
 			},
 		},
+		{
+			input: `login {
+                                        github {
+                                                client_id abc
+                                                client_secret "x,y"
+                                        }
+                                        google client_id=def client_secret=uvw
+                                        httpupstream {
+                                                upstream https://auth.example.com
+                                                timeout 2s
+                                        }
+                                        jwt_static_claims {
+                                                tenant example
+                                        }
+                                        redirect_host_whitelist a.example.com b.example.com
+                                }`,
+			shouldErr: false,
+			configCheck: func(t *testing.T, cfg *login.Config) {
+				Equal(t, login.Options{
+					"github": {"client_id": "abc", "client_secret": "x,y"},
+					"google": {"client_id": "def", "client_secret": "uvw"},
+				}, cfg.Oauth)
+				Equal(t, login.Options{"httpupstream": {"upstream": "https://auth.example.com", "timeout": "2s"}}, cfg.Backends)
+				Equal(t, map[string]string{"tenant": "example"}, cfg.JwtStaticClaims)
+				Equal(t, "a.example.com,b.example.com", cfg.RedirectHostWhitelist)
+			},
+		},
 		// error cases
 		{input: "login {\n}", shouldErr: true},
+		{input: "login {\n simple bob=secret \n simple alice=secret \n}", shouldErr: true},
+		{input: "login {\n github {\n client_id \n }\n}", shouldErr: true},
+		{input: "login {\n github {\n client_id a\n client_id b\n }\n}", shouldErr: true},
+		{input: "login {\n github client_id=a {\n client_secret b\n }\n}", shouldErr: true},
+		{input: "login {\n htpasswd {\n fiel users.htpasswd\n }\n}", shouldErr: true},
 		{input: "login xx yy {\n}", shouldErr: true},
 		{input: "login {\n cookie_http_only 42d \n simple bob=secret \n}", shouldErr: true},
 		{input: "login {\n unknown property \n simple bob=secret \n}", shouldErr: true},
@@ -248,3 +280,18 @@ func TestSetup_RelativeFiles(t *testing.T) {
 	Equal(t, filepath.FromSlash(root+"/myTemplate.tpl"), middleware.config.Template)
 	Equal(t, "redirectDomains.txt", middleware.config.RedirectHostFile)
 }
+
+func TestSetup_ErrorLineNumbers(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		err   string
+	}{
+		{"login {\n simple bob=secret\n unknown property\n}", "Unknown parameter for login directive: unknown (Testfile:3)"},
+		{"login {\n simple bob=secret\n cookie_http_only 42d\n}", "Invalid value for parameter cookie-http-only: 42d (Testfile:3)"},
+		{"login {\n simple bob=secret\n\n htpasswd {\n fiel users\n }\n}", `unknown option "fiel" for the htpasswd provider, expected one of: file, files (Testfile:4)`},
+		{"login {\n github {\n client_id a\n client_secret\n }\n}", "Wrong number of arguments for client_secret of github: [] (Testfile:4)"},
+	} {
+		err := setup(caddy.NewTestController("http", test.input))
+		EqualError(t, err, test.err)
+	}
+}
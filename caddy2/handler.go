@@ -0,0 +1,97 @@
+// Package caddy2 is the loginsrv handler module for caddy v2.
+package caddy2
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(Login{})
+}
+
+// Login is the loginsrv handler for caddy v2.
+// It serves the login resources and passes all other requests to the next handler.
+type Login struct {
+	// Options are the loginsrv options by the names of the command line flags,
+	// e.g. "simple": "bob=secret" or "cookie_name": "jwt".
+	Options map[string]string `json:"options,omitempty"`
+
+	config       *login.Config
+	loginHandler *login.Handler
+}
+
+// CaddyModule returns the caddy module information.
+func (Login) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.login",
+		New: func() caddy.Module { return new(Login) },
+	}
+}
+
+// Provision creates the loginsrv handler from the options.
+func (l *Login) Provision(ctx caddy.Context) error {
+	logging.Set("info", true)
+
+	config, err := parseOptions(l.Options)
+	if err != nil {
+		return err
+	}
+	loginHandler, err := login.NewHandler(config)
+	if err != nil {
+		return err
+	}
+	l.config = config
+	l.loginHandler = loginHandler
+	return nil
+}
+
+func (l *Login) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	userInfo, valid := l.loginHandler.GetToken(r)
+	if valid {
+		// provide the user to the following handlers and the access log,
+		// like the authentication handler of caddy does
+		if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+			repl.Set("http.auth.user.id", userInfo.Sub)
+		}
+	}
+
+	if strings.HasPrefix(r.URL.Path, l.config.LoginPath) ||
+		(r.URL.Path == login.JWKSPath && l.loginHandler.PublishesJWKS()) ||
+		(r.URL.Path == login.OIDCDiscoveryPath && l.loginHandler.ProvidesOIDC()) {
+		l.loginHandler.ServeHTTP(w, r)
+		return nil
+	}
+
+	if valid && r.Method == "GET" && isNavigation(r) && l.loginHandler.ExpiresSoon(userInfo) {
+		// redirect to the login page before the token expires,
+		// so that the user does not get logged out in the middle of an action
+		http.Redirect(w, r, l.reloginURL(r), http.StatusFound)
+		return nil
+	}
+
+	return next.ServeHTTP(w, r)
+}
+
+// reloginURL returns the url of the login page with the current request as redirect target
+func (l *Login) reloginURL(r *http.Request) string {
+	return l.config.LoginPath + "?" + url.QueryEscape(l.config.RedirectQueryParameter) + "=" + url.QueryEscape(r.URL.RequestURI())
+}
+
+// isNavigation returns true for requests of html pages by the browser,
+// in contrast to requests of assets or api calls which can not follow a login redirect.
+func isNavigation(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Login)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Login)(nil)
+)
@@ -0,0 +1,90 @@
+package caddy2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+func testLogin(t *testing.T) *Login {
+	l := &Login{Options: map[string]string{"simple": "bob=secret", "jwt_secret": "secret"}}
+	NoError(t, l.Provision(caddy.Context{}))
+	return l
+}
+
+func testRequest(method, path string) (*http.Request, *caddy.Replacer) {
+	r := httptest.NewRequest(method, path, nil)
+	repl := caddy.NewReplacer()
+	return r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl)), repl
+}
+
+func testToken(t *testing.T, sub string, expiry time.Duration) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"sub": sub,
+		"exp": time.Now().Add(expiry).Unix(),
+	}).SignedString([]byte("secret"))
+	NoError(t, err)
+	return token
+}
+
+var nextHandler = caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+	w.WriteHeader(http.StatusTeapot)
+	return nil
+})
+
+func TestServeHTTP_Next(t *testing.T) {
+	l := testLogin(t)
+
+	r, repl := testRequest("GET", "/")
+	r.AddCookie(&http.Cookie{Name: "jwt_token", Value: testToken(t, "bob", time.Hour)})
+	w := httptest.NewRecorder()
+	NoError(t, l.ServeHTTP(w, r, nextHandler))
+	Equal(t, http.StatusTeapot, w.Code)
+	user, _ := repl.Get("http.auth.user.id")
+	Equal(t, "bob", user)
+
+	// without a token
+	r, repl = testRequest("GET", "/")
+	w = httptest.NewRecorder()
+	NoError(t, l.ServeHTTP(w, r, nextHandler))
+	Equal(t, http.StatusTeapot, w.Code)
+	_, exist := repl.Get("http.auth.user.id")
+	False(t, exist)
+}
+
+func TestServeHTTP_Login(t *testing.T) {
+	l := testLogin(t)
+
+	r, _ := testRequest("GET", "/login")
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	NoError(t, l.ServeHTTP(w, r, nextHandler))
+	Equal(t, http.StatusOK, w.Code)
+	Contains(t, w.Body.String(), "form")
+
+	// the jwks is only served for private keys
+	r, _ = testRequest("GET", login.JWKSPath)
+	w = httptest.NewRecorder()
+	NoError(t, l.ServeHTTP(w, r, nextHandler))
+	Equal(t, http.StatusTeapot, w.Code)
+}
+
+func TestServeHTTP_ReloginBeforeExpiry(t *testing.T) {
+	l := testLogin(t)
+
+	r, _ := testRequest("GET", "/private?x=1")
+	r.Header.Set("Accept", "text/html")
+	r.AddCookie(&http.Cookie{Name: "jwt_token", Value: testToken(t, "bob", time.Minute)})
+	w := httptest.NewRecorder()
+	NoError(t, l.ServeHTTP(w, r, nextHandler))
+	Equal(t, http.StatusFound, w.Code)
+	Equal(t, "/login?backTo=%2Fprivate%3Fx%3D1", w.Header().Get("Location"))
+}
@@ -0,0 +1,187 @@
+package caddy2
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+
+	// Import all backends, packaged with the caddy module
+	_ "github.com/afdecastro879/loginsrv/dbbackend"
+	_ "github.com/afdecastro879/loginsrv/htpasswd"
+	_ "github.com/afdecastro879/loginsrv/httpauth"
+	_ "github.com/afdecastro879/loginsrv/httpupstream"
+	_ "github.com/afdecastro879/loginsrv/ldap"
+	_ "github.com/afdecastro879/loginsrv/magiclink"
+	_ "github.com/afdecastro879/loginsrv/mongodb"
+	_ "github.com/afdecastro879/loginsrv/oauth2"
+	_ "github.com/afdecastro879/loginsrv/osiam"
+	_ "github.com/afdecastro879/loginsrv/pam"
+	_ "github.com/afdecastro879/loginsrv/radius"
+	_ "github.com/afdecastro879/loginsrv/spiffe"
+)
+
+func init() {
+	httpcaddyfile.RegisterHandlerDirective("login", parseCaddyfile)
+	// the login has to be handled before the content and the authentication of caddy
+	httpcaddyfile.RegisterDirectiveOrder("login", httpcaddyfile.Before, "basic_auth")
+}
+
+// parseCaddyfile sets up the handler from the caddyfile directive:
+//
+//	login {
+//	    simple bob=secret
+//	    cookie_name jwt
+//	    github {
+//	        client_id abc
+//	        client_secret xyz
+//	    }
+//	}
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	l := new(Login)
+	err := l.UnmarshalCaddyfile(h.Dispenser)
+	return l, err
+}
+
+// UnmarshalCaddyfile reads the options of the login directive.
+// The options of a provider are either given as arguments in the form key=value
+// or as block with one option per line.
+func (l *Login) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	fs := flag.NewFlagSet("loginsrv-config", flag.ContinueOnError)
+	login.DefaultConfig().ConfigureFlagSet(fs)
+
+	l.Options = map[string]string{}
+	seen := map[string]bool{}
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		for d.NextBlock(0) {
+			name := d.Val()
+			line := d.Line()
+			flagName := strings.Replace(name, "_", "-", -1)
+			if fs.Lookup(flagName) == nil {
+				return d.Errf("unknown parameter for login directive: %v", name)
+			}
+			if seen[flagName] {
+				return d.Errf("duplicate parameter for login directive: %v", name)
+			}
+			seen[flagName] = true
+
+			// multiple arguments are joined, e.g. github client_id=.. client_secret=..
+			args := d.RemainingArgs()
+			opts, err := blockOptions(d, name)
+			if err != nil {
+				return err
+			}
+			switch {
+			case len(opts) != 0 && len(args) != 0:
+				return fmt.Errorf("unexpected arguments before the block of %v: %v, at %v:%v", name, args, d.File(), line)
+			case len(opts) != 0:
+				if desc, exist := login.GetProviderDescription(flagName); exist {
+					if err := desc.Validate(opts); err != nil {
+						return fmt.Errorf("%v, at %v:%v", err, d.File(), line)
+					}
+				}
+				l.Options[name] = joinOptions(opts)
+			case len(args) != 0:
+				l.Options[name] = strings.Join(args, ",")
+			default:
+				return fmt.Errorf("wrong number of arguments for %v, at %v:%v", name, d.File(), line)
+			}
+		}
+	}
+	return nil
+}
+
+// blockOptions reads the options of a block with one option per line in the form: key value
+func blockOptions(d *caddyfile.Dispenser, name string) (map[string]string, error) {
+	opts := map[string]string{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := d.Val()
+		var value string
+		if !d.Args(&value) || d.NextArg() {
+			return nil, d.Errf("wrong number of arguments for %v of %v", key, name)
+		}
+		if _, exist := opts[key]; exist {
+			return nil, d.Errf("duplicate option %v of %v", key, name)
+		}
+		opts[key] = value
+	}
+	return opts, nil
+}
+
+// joinOptions returns the options in the form key1=value1,key2=.. with escaped commas
+func joinOptions(opts map[string]string) string {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(opts))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+strings.Replace(opts[key], ",", `\,`, -1))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// parseOptions creates the loginsrv config from the options, which are named like the command line flags.
+func parseOptions(options map[string]string) (*login.Config, error) {
+	cfg := login.DefaultConfig()
+	cfg.Host = ""
+	cfg.Port = ""
+	cfg.LogLevel = ""
+
+	fs := flag.NewFlagSet("loginsrv-config", flag.ContinueOnError)
+	cfg.ConfigureFlagSet(fs)
+
+	// sorted, so that errors are reported in a stable order
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	secretProvidedByConfig := false
+	for _, name := range names {
+		value := options[name]
+		// caddy prefers '_' in parameter names,
+		// so we map them to the '-' from the command line flags
+		flagName := strings.Replace(name, "_", "-", -1)
+		f := fs.Lookup(flagName)
+		if f == nil {
+			return nil, fmt.Errorf("unknown parameter for login handler: %v", name)
+		}
+		if err := f.Value.Set(value); err != nil {
+			return nil, fmt.Errorf("invalid value for parameter %v: %v", name, value)
+		}
+		if flagName == "jwt-secret" {
+			secretProvidedByConfig = true
+		}
+	}
+
+	if err := cfg.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	secretFromEnv, secretFromEnvWasSetBefore := os.LookupEnv("JWT_SECRET")
+	if !secretProvidedByConfig && secretFromEnvWasSetBefore {
+		cfg.JwtSecret = secretFromEnv
+	}
+	if !secretFromEnvWasSetBefore {
+		// populate the secret to other jwt handlers,
+		// but do not change a environment variable, which somebody has set it.
+		os.Setenv("JWT_SECRET", cfg.JwtSecret)
+	}
+	return cfg, nil
+}
+
+// Interface guards
+var _ caddyfile.Unmarshaler = (*Login)(nil)
@@ -0,0 +1,171 @@
+package caddy2
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	for j, test := range []struct {
+		input     string
+		shouldErr bool
+		options   map[string]string
+	}{
+		{
+			input: `login {
+                                        simple bob=secret
+                                        cookie_name jwt
+                                }`,
+			options: map[string]string{"simple": "bob=secret", "cookie_name": "jwt"},
+		},
+		{
+			input:   `login`,
+			options: map[string]string{},
+		},
+		{
+			input:     `login /foo`,
+			shouldErr: true,
+		},
+		{
+			input: `login {
+                                        simple
+                                }`,
+			shouldErr: true,
+		},
+		{
+			input: `login {
+                                        simple bob=secret alice=secret
+                                }`,
+			options: map[string]string{"simple": "bob=secret,alice=secret"},
+		},
+		{
+			input: `login {
+                                        github {
+                                                client_id abc
+                                                client_secret "x,y"
+                                        }
+                                        google client_id=def client_secret=uvw
+                                        jwt_static_claims {
+                                                tenant example
+                                        }
+                                        cookie_name jwt
+                                }`,
+			options: map[string]string{
+				"github":            `client_id=abc,client_secret=x\,y`,
+				"google":            "client_id=def,client_secret=uvw",
+				"jwt_static_claims": "tenant=example",
+				"cookie_name":       "jwt",
+			},
+		},
+		{
+			input: `login {
+                                        github {
+                                                client_id
+                                        }
+                                }`,
+			shouldErr: true,
+		},
+		{
+			input: `login {
+                                        github {
+                                                client_id a
+                                                client_id b
+                                        }
+                                }`,
+			shouldErr: true,
+		},
+		{
+			input: `login {
+                                        github client_id=a {
+                                                client_secret b
+                                        }
+                                }`,
+			shouldErr: true,
+		},
+		{
+			input: `login {
+                                        cookie_name jwt
+                                        cookie-name jwt
+                                }`,
+			shouldErr: true,
+		},
+		{
+			input: `login {
+                                        simple bob=secret
+                                        simple alice=secret
+                                }`,
+			shouldErr: true,
+		},
+	} {
+		l := &Login{}
+		err := l.UnmarshalCaddyfile(caddyfile.NewTestDispenser(test.input))
+		if test.shouldErr {
+			Error(t, err, "test %v", j)
+			continue
+		}
+		NoError(t, err, "test %v", j)
+		Equal(t, test.options, l.Options, "test %v", j)
+	}
+}
+
+func TestUnmarshalCaddyfile_ErrorLineNumbers(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		err   string
+	}{
+		{"login {\n simple bob=secret\n unknown property\n}", "unknown parameter for login directive: unknown, at Testfile:3"},
+		{"login {\n simple bob=secret\n\n htpasswd {\n fiel users\n }\n}", `unknown option "fiel" for the htpasswd provider, expected one of: file, files, at Testfile:4`},
+		{"login {\n github {\n client_id a\n client_secret\n }\n}", "wrong number of arguments for client_secret of github, at Testfile:4"},
+		{"login {\n simple\n}", "wrong number of arguments for simple, at Testfile:2"},
+	} {
+		l := &Login{}
+		err := l.UnmarshalCaddyfile(caddyfile.NewTestDispenser(test.input))
+		EqualError(t, err, test.err)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	os.Setenv("JWT_SECRET", "jwtsecret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	cfg, err := parseOptions(map[string]string{
+		"simple":      "bob=secret",
+		"jwt_expiry":  "42h",
+		"login-path":  "/foo/bar",
+		"cookie_name": "cookiename",
+	})
+	NoError(t, err)
+	Equal(t, login.Options{"simple": map[string]string{"bob": "secret"}}, cfg.Backends)
+	Equal(t, 42*time.Hour, cfg.JwtExpiry)
+	Equal(t, "/foo/bar", cfg.LoginPath)
+	Equal(t, "cookiename", cfg.CookieName)
+	Equal(t, "jwtsecret", cfg.JwtSecret)
+	Equal(t, "", cfg.Host)
+	Equal(t, "", cfg.Port)
+
+	// the secret from the options has priority
+	cfg, err = parseOptions(map[string]string{"jwt_secret": "optionsecret"})
+	NoError(t, err)
+	Equal(t, "optionsecret", cfg.JwtSecret)
+
+	_, err = parseOptions(map[string]string{"foo": "bar"})
+	Error(t, err)
+
+	_, err = parseOptions(map[string]string{"jwt_expiry": "bar"})
+	Error(t, err)
+}
+
+func TestParseOptions_RandomSecret(t *testing.T) {
+	os.Unsetenv("JWT_SECRET")
+	defer os.Unsetenv("JWT_SECRET")
+
+	cfg, err := parseOptions(map[string]string{"simple": "bob=secret"})
+	NoError(t, err)
+	NotEmpty(t, cfg.JwtSecret)
+	Equal(t, cfg.JwtSecret, os.Getenv("JWT_SECRET"))
+}
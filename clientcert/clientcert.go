@@ -0,0 +1,226 @@
+// Package clientcert implements the login by client certificates (mTLS).
+// The certificate is taken from the TLS connection or from a header, which is set by a trusted reverse proxy.
+package clientcert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/trustedproxy"
+)
+
+// ProviderName is the origin of the users, logged in by a client certificate
+const ProviderName = "x509"
+
+const defaultTimeout = 5 * time.Second
+
+// oidEmailAddress is the emailAddress attribute of the subject, which is used by old certificates instead of the SAN
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// Config of the client certificate login
+type Config struct {
+	// CA is the PEM file with the CA certificates, which issue the client certificates
+	CA string
+	// Header contains the client certificate, if it is set by a reverse proxy, which terminates TLS.
+	// It is only accepted from the TrustedProxies.
+	Header         string
+	TrustedProxies []string
+	// Sub is the field of the certificate for the sub claim: cn for the common name or email for the email address
+	Sub string
+	// CRL are the files with the certificate revocation lists of the CAs
+	CRL []string
+	// OCSP enables the check of the certificate by the OCSP responder of the CA
+	OCSP    bool
+	Timeout time.Duration
+}
+
+// NewConfigFromOpts creates the configuration from the options of the x509 flag.
+func NewConfigFromOpts(opts map[string]string) (Config, error) {
+	cfg := Config{
+		CA:      opts["ca"],
+		Header:  opts["header"],
+		Sub:     "cn",
+		Timeout: defaultTimeout,
+	}
+	for key, value := range opts {
+		var err error
+		switch key {
+		case "ca", "header":
+		case "trusted_proxies":
+			cfg.TrustedProxies = strings.Split(value, ";")
+			_, err = trustedproxy.Parse(cfg.TrustedProxies)
+		case "sub":
+			if value != "cn" && value != "email" {
+				err = errors.New(`expected "cn" or "email"`)
+			}
+			cfg.Sub = value
+		case "crl":
+			cfg.CRL = strings.Split(value, ";")
+		case "ocsp":
+			cfg.OCSP, err = strconv.ParseBool(value)
+		case "timeout":
+			cfg.Timeout, err = time.ParseDuration(value)
+		default:
+			return Config{}, fmt.Errorf(`unknown parameter "%v" for x509`, key)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf(`invalid parameter value "%v" in "%v" for x509: %v`, value, key, err)
+		}
+	}
+	if cfg.CA == "" {
+		return Config{}, errors.New(`missing parameter "ca" for x509`)
+	}
+	if cfg.Header != "" && len(cfg.TrustedProxies) == 0 {
+		return Config{}, errors.New(`parameter "header" for x509 requires "trusted_proxies"`)
+	}
+	return cfg, nil
+}
+
+// LoadCertPool reads the CA certificates of a PEM file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %v", file)
+	}
+	return pool, nil
+}
+
+// Service verifies the client certificates of the requests.
+type Service struct {
+	config  Config
+	roots   *x509.CertPool
+	proxies trustedproxy.Networks
+	crls    []*x509.RevocationList
+	client  *http.Client
+}
+
+// NewService loads the CA certificates and the revocation lists.
+func NewService(cfg Config) (*Service, error) {
+	roots, err := LoadCertPool(cfg.CA)
+	if err != nil {
+		return nil, fmt.Errorf("can not load x509 ca: %v", err)
+	}
+	proxies, err := trustedproxy.Parse(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	crls, err := loadCRLs(cfg.CRL)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		config:  cfg,
+		roots:   roots,
+		proxies: proxies,
+		crls:    crls,
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// Authenticate verifies the client certificate of the request and returns the user of the certificate.
+// The bool return parameter is false, if the request has no client certificate.
+func (s *Service) Authenticate(r *http.Request) (model.UserInfo, bool, error) {
+	certs, err := s.certificates(r)
+	if err != nil {
+		return model.UserInfo{}, true, err
+	}
+	if len(certs) == 0 {
+		return model.UserInfo{}, false, nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         s.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return model.UserInfo{}, true, err
+	}
+	if err := s.checkRevocation(chains[0]); err != nil {
+		return model.UserInfo{}, true, err
+	}
+	userInfo, err := s.userInfo(certs[0])
+	return userInfo, true, err
+}
+
+// certificates returns the certificate of the header, if the request is sent by a trusted proxy,
+// or the certificate chain of the TLS connection
+func (s *Service) certificates(r *http.Request) ([]*x509.Certificate, error) {
+	if value := r.Header.Get(s.config.Header); s.config.Header != "" && value != "" {
+		if !s.proxies.Contains(r.RemoteAddr) {
+			return nil, fmt.Errorf("header %v from the untrusted address %v", s.config.Header, r.RemoteAddr)
+		}
+		cert, err := parseHeader(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in header %v: %v", s.config.Header, err)
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+	if r.TLS != nil {
+		return r.TLS.PeerCertificates, nil
+	}
+	return nil, nil
+}
+
+// parseHeader parses the certificate as url escaped PEM, e.g. by nginx, or as base64 encoded DER, e.g. by caddy
+func parseHeader(value string) (*x509.Certificate, error) {
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		value = unescaped
+	}
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// userInfo maps the common name to the name claim and the email address of the SAN or the subject to the email claim
+func (s *Service) userInfo(cert *x509.Certificate) (model.UserInfo, error) {
+	email := ""
+	if len(cert.EmailAddresses) > 0 {
+		email = cert.EmailAddresses[0]
+	} else {
+		for _, attr := range cert.Subject.Names {
+			if value, ok := attr.Value.(string); ok && attr.Type.Equal(oidEmailAddress) {
+				email = value
+				break
+			}
+		}
+	}
+
+	sub := cert.Subject.CommonName
+	if s.config.Sub == "email" {
+		sub = email
+	}
+	if sub == "" {
+		return model.UserInfo{}, fmt.Errorf("the certificate %v has no %v", cert.Subject, s.config.Sub)
+	}
+	return model.UserInfo{
+		Sub:    sub,
+		Name:   cert.Subject.CommonName,
+		Email:  email,
+		Origin: ProviderName,
+	}, nil
+}
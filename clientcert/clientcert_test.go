@@ -0,0 +1,309 @@
+package clientcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	file string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Example CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	NoError(t, err)
+
+	file := filepath.Join(dir, "ca.pem")
+	NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, template *x509.Certificate) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if template.ExtKeyUsage == nil {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	NoError(t, err)
+	return cert
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "loginsrv-x509")
+	NoError(t, err)
+	return dir
+}
+
+func tlsRequest(certs ...*x509.Certificate) *http.Request {
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	return r
+}
+
+func TestNewConfigFromOpts(t *testing.T) {
+	cfg, err := NewConfigFromOpts(map[string]string{"ca": "ca.pem"})
+	NoError(t, err)
+	Equal(t, Config{CA: "ca.pem", Sub: "cn", Timeout: defaultTimeout}, cfg)
+
+	cfg, err = NewConfigFromOpts(map[string]string{
+		"ca":              "ca.pem",
+		"header":          "X-Client-Cert",
+		"trusted_proxies": "127.0.0.1;10.0.0.0/8",
+		"sub":             "email",
+		"crl":             "a.crl;b.crl",
+		"ocsp":            "true",
+		"timeout":         "2s",
+	})
+	NoError(t, err)
+	Equal(t, Config{
+		CA:             "ca.pem",
+		Header:         "X-Client-Cert",
+		TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8"},
+		Sub:            "email",
+		CRL:            []string{"a.crl", "b.crl"},
+		OCSP:           true,
+		Timeout:        2 * time.Second,
+	}, cfg)
+
+	for _, opts := range []map[string]string{
+		{},
+		{"ca": "ca.pem", "header": "X-Client-Cert"},
+		{"ca": "ca.pem", "header": "X-Client-Cert", "trusted_proxies": "localhost"},
+		{"ca": "ca.pem", "sub": "uid"},
+		{"ca": "ca.pem", "ocsp": "maybe"},
+		{"ca": "ca.pem", "timeout": "5"},
+		{"ca": "ca.pem", "foo": "bar"},
+	} {
+		_, err := NewConfigFromOpts(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestNewService_Error(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir)
+
+	_, err := NewService(Config{CA: filepath.Join(dir, "does-not-exist.pem")})
+	Error(t, err)
+
+	_, err = NewService(Config{CA: ca.file, CRL: []string{ca.file}})
+	Error(t, err)
+}
+
+func TestService_Authenticate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir)
+	s, err := NewService(Config{CA: ca.file, Sub: "cn"})
+	NoError(t, err)
+
+	cert := ca.issue(t, 2, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "Bob Builder"},
+		EmailAddresses: []string{"bob@example.com"},
+	})
+	userInfo, present, err := s.Authenticate(tlsRequest(cert))
+	NoError(t, err)
+	True(t, present)
+	Equal(t, model.UserInfo{Sub: "Bob Builder", Name: "Bob Builder", Email: "bob@example.com", Origin: ProviderName}, userInfo)
+
+	// the email address in the subject of old certificates
+	s.config.Sub = "email"
+	cert = ca.issue(t, 3, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Alice", ExtraNames: []pkix.AttributeTypeAndValue{{Type: oidEmailAddress, Value: "alice@example.com"}}},
+	})
+	userInfo, _, err = s.Authenticate(tlsRequest(cert))
+	NoError(t, err)
+	Equal(t, "alice@example.com", userInfo.Sub)
+
+	cert = ca.issue(t, 4, &x509.Certificate{Subject: pkix.Name{CommonName: "Without Email"}})
+	_, _, err = s.Authenticate(tlsRequest(cert))
+	Error(t, err)
+
+	_, present, err = s.Authenticate(httptest.NewRequest("GET", "/login", nil))
+	NoError(t, err)
+	False(t, present)
+
+	_, present, err = s.Authenticate(tlsRequest())
+	NoError(t, err)
+	False(t, present)
+}
+
+func TestService_Authenticate_Invalid(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir)
+	otherCA := newTestCA(t, tempDir(t))
+	defer os.RemoveAll(filepath.Dir(otherCA.file))
+	s, err := NewService(Config{CA: ca.file, Sub: "cn"})
+	NoError(t, err)
+
+	// a certificate of another CA
+	_, present, err := s.Authenticate(tlsRequest(otherCA.issue(t, 2, &x509.Certificate{Subject: pkix.Name{CommonName: "bob"}})))
+	Error(t, err)
+	True(t, present)
+
+	// a server certificate
+	_, _, err = s.Authenticate(tlsRequest(ca.issue(t, 3, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "login.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})))
+	Error(t, err)
+}
+
+func TestService_Authenticate_Header(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir)
+	s, err := NewService(Config{CA: ca.file, Sub: "cn", Header: "X-Client-Cert", TrustedProxies: []string{"192.0.2.1", "10.0.0.0/8"}})
+	NoError(t, err)
+	cert := ca.issue(t, 2, &x509.Certificate{Subject: pkix.Name{CommonName: "bob"}})
+
+	for _, value := range []string{
+		// nginx $ssl_client_escaped_cert
+		url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))),
+		// caddy {http.request.tls.client.certificate_der_base64}
+		base64.StdEncoding.EncodeToString(cert.Raw),
+	} {
+		r := httptest.NewRequest("GET", "/login", nil)
+		r.RemoteAddr = "10.1.2.3:4711"
+		r.Header.Set("X-Client-Cert", value)
+		userInfo, present, err := s.Authenticate(r)
+		NoError(t, err)
+		True(t, present)
+		Equal(t, "bob", userInfo.Sub)
+	}
+
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.RemoteAddr = "192.0.2.2:4711"
+	r.Header.Set("X-Client-Cert", base64.StdEncoding.EncodeToString(cert.Raw))
+	_, present, err := s.Authenticate(r)
+	Error(t, err)
+	True(t, present)
+
+	r = httptest.NewRequest("GET", "/login", nil)
+	r.RemoteAddr = "192.0.2.1:4711"
+	r.Header.Set("X-Client-Cert", "no certificate")
+	_, _, err = s.Authenticate(r)
+	Error(t, err)
+}
+
+func TestService_Authenticate_CRL(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir)
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Hour),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(3), RevocationTime: time.Now()}},
+	}, ca.cert, ca.key)
+	NoError(t, err)
+	crlFile := filepath.Join(dir, "ca.crl")
+	NoError(t, ioutil.WriteFile(crlFile, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600))
+	derFile := filepath.Join(dir, "ca.der.crl")
+	NoError(t, ioutil.WriteFile(derFile, der, 0600))
+
+	for _, file := range []string{crlFile, derFile} {
+		s, err := NewService(Config{CA: ca.file, Sub: "cn", CRL: []string{file}})
+		NoError(t, err)
+
+		_, _, err = s.Authenticate(tlsRequest(ca.issue(t, 2, &x509.Certificate{Subject: pkix.Name{CommonName: "bob"}})))
+		NoError(t, err)
+
+		_, _, err = s.Authenticate(tlsRequest(ca.issue(t, 3, &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}})))
+		Error(t, err)
+	}
+}
+
+func TestService_Authenticate_OCSP(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir)
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(b)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		switch req.SerialNumber.Int64() {
+		case 3:
+			template.Status = ocsp.Revoked
+			template.RevokedAt = time.Now().Add(-time.Minute)
+		case 4:
+			w.WriteHeader(500)
+			return
+		}
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, template, ca.key)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	s, err := NewService(Config{CA: ca.file, Sub: "cn", OCSP: true, Timeout: time.Second})
+	NoError(t, err)
+
+	_, _, err = s.Authenticate(tlsRequest(ca.issue(t, 2, &x509.Certificate{Subject: pkix.Name{CommonName: "bob"}, OCSPServer: []string{responder.URL}})))
+	NoError(t, err)
+
+	_, _, err = s.Authenticate(tlsRequest(ca.issue(t, 3, &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, OCSPServer: []string{responder.URL}})))
+	Error(t, err)
+
+	_, _, err = s.Authenticate(tlsRequest(ca.issue(t, 4, &x509.Certificate{Subject: pkix.Name{CommonName: "carol"}, OCSPServer: []string{responder.URL}})))
+	Error(t, err)
+
+	// certificates without an OCSP responder
+	_, _, err = s.Authenticate(tlsRequest(ca.issue(t, 5, &x509.Certificate{Subject: pkix.Name{CommonName: "dave"}})))
+	NoError(t, err)
+}
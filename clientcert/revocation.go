@@ -0,0 +1,109 @@
+package clientcert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPResponseSize limits the size of the responses of the OCSP responders
+const maxOCSPResponseSize = 1 << 20
+
+// loadCRLs reads the certificate revocation lists of the files in PEM or DER encoding
+func loadCRLs(files []string) ([]*x509.RevocationList, error) {
+	crls := []*x509.RevocationList{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("can not load x509 crl: %v", err)
+		}
+		ders := [][]byte{}
+		for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type == "X509 CRL" {
+				ders = append(ders, block.Bytes)
+			}
+		}
+		if len(ders) == 0 {
+			ders = append(ders, b)
+		}
+		for _, der := range ders {
+			crl, err := x509.ParseRevocationList(der)
+			if err != nil {
+				return nil, fmt.Errorf("invalid x509 crl %v: %v", file, err)
+			}
+			crls = append(crls, crl)
+		}
+	}
+	return crls, nil
+}
+
+// checkRevocation checks the certificates of the verified chain against the revocation lists of their issuers
+// and the client certificate by OCSP, if enabled
+func (s *Service) checkRevocation(chain []*x509.Certificate) error {
+	for i := 0; i < len(chain)-1; i++ {
+		if err := s.checkCRL(chain[i], chain[i+1]); err != nil {
+			return err
+		}
+	}
+	if s.config.OCSP && len(chain) > 1 {
+		return s.checkOCSP(chain[0], chain[1])
+	}
+	return nil
+}
+
+func (s *Service) checkCRL(cert, issuer *x509.Certificate) error {
+	for _, crl := range s.crls {
+		if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("the certificate %v is revoked", cert.Subject)
+			}
+		}
+	}
+	return nil
+}
+
+// checkOCSP asks the OCSP responder of the certificate. Certificates without an OCSP responder are accepted.
+func (s *Service) checkOCSP(cert, issuer *x509.Certificate) error {
+	if len(cert.OCSPServer) == 0 {
+		return nil
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return fmt.Errorf("ocsp request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("ocsp responder %v returned status %v", cert.OCSPServer[0], resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return err
+	}
+	res, err := ocsp.ParseResponseForCert(b, cert, issuer)
+	if err != nil {
+		return fmt.Errorf("invalid ocsp response: %v", err)
+	}
+	if !res.NextUpdate.IsZero() && time.Now().After(res.NextUpdate) {
+		return fmt.Errorf("the ocsp response for %v is outdated", cert.Subject)
+	}
+	switch res.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("the certificate %v is revoked", cert.Subject)
+	}
+	return fmt.Errorf("the certificate %v is unknown to the ocsp responder", cert.Subject)
+}
@@ -0,0 +1,264 @@
+package dbbackend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	"golang.org/x/crypto/bcrypt"
+
+	// the supported database drivers
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+// ProviderName const
+const ProviderName = "db"
+
+const defaultTimeout = 5 * time.Second
+
+// defaultQueries are the queries for a users table, by driver
+var defaultQueries = map[string]string{
+	"postgres": "SELECT password FROM users WHERE username = $1",
+	"mysql":    "SELECT password FROM users WHERE username = ?",
+}
+
+// defaultRegisterQueries insert the registered users into the users table, by driver
+var defaultRegisterQueries = map[string]string{
+	"postgres": "INSERT INTO users (username, password, email) VALUES ($1, $2, $3)",
+	"mysql":    "INSERT INTO users (username, password, email) VALUES (?, ?, ?)",
+}
+
+// defaultPasswordQueries update the password hash of a user in the users table, by driver
+var defaultPasswordQueries = map[string]string{
+	"postgres": "UPDATE users SET password = $1 WHERE username = $2",
+	"mysql":    "UPDATE users SET password = ? WHERE username = ?",
+}
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "SQL database login backend opts: driver=postgres|mysql,dsn=..[,query=..][,register_query=..][,password_query=..][,timeout=..]",
+			Options: []login.OptionDescription{
+				{Name: "driver", Required: true},
+				{Name: "dsn", Required: true},
+				{Name: "query"},
+				{Name: "register_query"},
+				{Name: "password_query"},
+				{Name: "timeout", Type: login.OptionDuration},
+			},
+		},
+		BackendFactory)
+}
+
+// Config of the database backend
+type Config struct {
+	Driver string
+	DSN    string
+
+	// Query selects the password hash and optionally the name and email of the user.
+	// The username is passed as the only query parameter, e.g. $1 for postgres or ? for mysql.
+	Query string
+
+	// RegisterQuery inserts a registered user.
+	// The parameters are the username, the bcrypt hash of the password and the email address.
+	RegisterQuery string
+
+	// PasswordQuery updates the password of a user.
+	// The parameters are the bcrypt hash of the password and the username.
+	PasswordQuery string
+
+	Timeout time.Duration
+}
+
+// BackendFactory creates a database backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg := Config{
+		Driver:        opts["driver"],
+		DSN:           opts["dsn"],
+		Query:         opts["query"],
+		RegisterQuery: opts["register_query"],
+		PasswordQuery: opts["password_query"],
+		Timeout:       defaultTimeout,
+	}
+
+	if cfg.Driver == "" {
+		return nil, errors.New(`missing parameter "driver" for db provider`)
+	}
+	if _, supported := defaultQueries[cfg.Driver]; !supported {
+		return nil, fmt.Errorf(`invalid parameter value "%s" in "driver" for db provider, expected postgres or mysql`, cfg.Driver)
+	}
+	if cfg.DSN == "" {
+		return nil, errors.New(`missing parameter "dsn" for db provider`)
+	}
+	if cfg.Query == "" {
+		cfg.Query = defaultQueries[cfg.Driver]
+	}
+	if cfg.RegisterQuery == "" {
+		cfg.RegisterQuery = defaultRegisterQueries[cfg.Driver]
+	}
+	if cfg.PasswordQuery == "" {
+		cfg.PasswordQuery = defaultPasswordQueries[cfg.Driver]
+	}
+
+	if v, exist := opts["timeout"]; exist {
+		var err error
+		if cfg.Timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "timeout" for db provider: %v`, v, err)
+		}
+	}
+
+	return NewBackend(cfg)
+}
+
+// Backend authenticates the users against the password hashes of a sql database.
+type Backend struct {
+	config Config
+	db     *sql.DB
+}
+
+// NewBackend creates a new database Backend.
+// The connection is established on the first authentication.
+func NewBackend(cfg Config) (*Backend, error) {
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{config: cfg, db: db}, nil
+}
+
+// Close closes the connection pool of the database, e.g. when the backend is replaced by a reload.
+func (b *Backend) Close() error {
+	return b.db.Close()
+}
+
+// Authenticate the user by the password hash of the query
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if username == "" || password == "" {
+		return false, model.UserInfo{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+
+	hash, userInfo, found, err := b.queryUser(ctx, username)
+	if !found || err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	authenticated, err := compareHash(hash, password)
+	if err != nil {
+		return false, model.UserInfo{}, fmt.Errorf("%v for user %q", err, username)
+	}
+	if !authenticated {
+		return false, model.UserInfo{}, nil
+	}
+	return true, userInfo, nil
+}
+
+// queryUser returns the password hash and the user info of the first row of the query.
+func (b *Backend) queryUser(ctx context.Context, username string) (string, model.UserInfo, bool, error) {
+	rows, err := b.db.QueryContext(ctx, b.config.Query, username)
+	if err != nil {
+		return "", model.UserInfo{}, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", model.UserInfo{}, false, rows.Err()
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", model.UserInfo{}, false, err
+	}
+	if len(columns) < 1 || len(columns) > 3 {
+		return "", model.UserInfo{}, false, fmt.Errorf("db query returns %v columns, expected password[, name[, email]]", len(columns))
+	}
+
+	var hash, name, email sql.NullString
+	if err := rows.Scan([]interface{}{&hash, &name, &email}[:len(columns)]...); err != nil {
+		return "", model.UserInfo{}, false, err
+	}
+
+	return hash.String, model.UserInfo{
+		Sub:    username,
+		Name:   name.String,
+		Email:  email.String,
+		Origin: ProviderName,
+	}, hash.Valid, nil
+}
+
+// UserExists returns true, if the query returns a row for the username
+func (b *Backend) UserExists(username string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+
+	rows, err := b.db.QueryContext(ctx, b.config.Query, username)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return true, nil
+	}
+	return false, rows.Err()
+}
+
+// Register inserts the user with a bcrypt hash of the password by the register query
+func (b *Backend) Register(username, password, email string) error {
+	exists, err := b.UserExists(username)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return login.ErrUserExists
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+	_, err = b.db.ExecContext(ctx, b.config.RegisterQuery, username, string(hash), email)
+	return err
+}
+
+// SetPassword updates the password of the user to a bcrypt hash by the password query
+func (b *Backend) SetPassword(username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+	result, err := b.db.ExecContext(ctx, b.config.PasswordQuery, string(hash), username)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return login.ErrUnknownUser
+	}
+	return nil
+}
+
+// UserEmail returns the email address of the third column of the query, if it selects one
+func (b *Backend) UserEmail(username string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+
+	_, userInfo, _, err := b.queryUser(ctx, username)
+	return userInfo.Email, err
+}
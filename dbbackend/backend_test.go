@@ -0,0 +1,191 @@
+package dbbackend
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+// bcrypt hash of 'secret'
+const bcryptSecret = "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6"
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{
+		"driver":  "postgres",
+		"dsn":     "postgres://loginsrv@localhost/users?sslmode=disable",
+		"timeout": "2s",
+	})
+	NoError(t, err)
+	cfg := backend.(*Backend).config
+	Equal(t, "SELECT password FROM users WHERE username = $1", cfg.Query)
+	Equal(t, "INSERT INTO users (username, password, email) VALUES ($1, $2, $3)", cfg.RegisterQuery)
+	Equal(t, "UPDATE users SET password = $1 WHERE username = $2", cfg.PasswordQuery)
+	Equal(t, 2*time.Second, cfg.Timeout)
+
+	backend, err = p(map[string]string{
+		"driver":         "mysql",
+		"dsn":            "loginsrv@tcp(localhost:3306)/users",
+		"query":          "SELECT hash, full_name, mail FROM accounts WHERE login = ? AND active",
+		"register_query": "INSERT INTO accounts (login, hash, mail, active) VALUES (?, ?, ?, 1)",
+	})
+	NoError(t, err)
+	cfg = backend.(*Backend).config
+	Equal(t, "SELECT hash, full_name, mail FROM accounts WHERE login = ? AND active", cfg.Query)
+	Equal(t, "INSERT INTO accounts (login, hash, mail, active) VALUES (?, ?, ?, 1)", cfg.RegisterQuery)
+	Equal(t, defaultTimeout, cfg.Timeout)
+}
+
+func TestSetup_Errors(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"dsn": "postgres://localhost/users"},
+		{"driver": "oracle", "dsn": "oracle://localhost/users"},
+		{"driver": "postgres"},
+		{"driver": "postgres", "dsn": "postgres://localhost/users", "timeout": "foo"},
+	} {
+		_, err := BackendFactory(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	b, mock := testBackend(t, "SELECT password, name, email FROM users WHERE username = $1")
+
+	mock.ExpectQuery("SELECT password, name, email FROM users WHERE username = $1").
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"password", "name", "email"}).AddRow(bcryptSecret, "Bob Builder", nil))
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Sub: "bob", Name: "Bob Builder", Origin: "db"}, userInfo)
+
+	mock.ExpectQuery("SELECT password, name, email FROM users WHERE username = $1").
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"password", "name", "email"}).AddRow(bcryptSecret, "Bob Builder", nil))
+	authenticated, _, err = b.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	mock.ExpectQuery("SELECT password, name, email FROM users WHERE username = $1").
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"password", "name", "email"}))
+	authenticated, _, err = b.Authenticate("alice", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// no query for an empty password
+	authenticated, _, err = b.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+
+	NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuthenticate_PasswordOnly(t *testing.T) {
+	b, mock := testBackend(t, defaultQueries["postgres"])
+
+	mock.ExpectQuery(defaultQueries["postgres"]).
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(argon2idSecret))
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Sub: "bob", Origin: "db"}, userInfo)
+}
+
+func TestAuthenticate_Errors(t *testing.T) {
+	b, mock := testBackend(t, defaultQueries["postgres"])
+
+	mock.ExpectQuery(defaultQueries["postgres"]).
+		WithArgs("bob").
+		WillReturnError(errors.New("connection refused"))
+	_, _, err := b.Authenticate("bob", "secret")
+	EqualError(t, err, "connection refused")
+
+	mock.ExpectQuery(defaultQueries["postgres"]).
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow("plaintext"))
+	_, _, err = b.Authenticate("bob", "secret")
+	EqualError(t, err, `unknown password hash algorithm for user "bob"`)
+
+	mock.ExpectQuery(defaultQueries["postgres"]).
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"password", "name", "email", "groups"}).AddRow(bcryptSecret, "", "", ""))
+	_, _, err = b.Authenticate("bob", "secret")
+	Error(t, err)
+}
+
+func TestClose(t *testing.T) {
+	b, mock := testBackend(t, defaultQueries["postgres"])
+	mock.ExpectClose()
+	NoError(t, b.Close())
+	NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRegister(t *testing.T) {
+	b, mock := testBackend(t, "SELECT password FROM users WHERE username = $1")
+	b.config.RegisterQuery = "INSERT INTO users (username, password, email) VALUES ($1, $2, $3)"
+
+	mock.ExpectQuery("SELECT password FROM users WHERE username = $1").
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}))
+	mock.ExpectExec("INSERT INTO users (username, password, email) VALUES ($1, $2, $3)").
+		WithArgs("alice", sqlmock.AnyArg(), "alice@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	NoError(t, b.Register("alice", "wonderland", "alice@example.com"))
+
+	mock.ExpectQuery("SELECT password FROM users WHERE username = $1").
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(nil))
+	Equal(t, login.ErrUserExists, b.Register("bob", "secret12", "bob@example.com"))
+
+	mock.ExpectQuery("SELECT password FROM users WHERE username = $1").
+		WithArgs("eve").
+		WillReturnError(errors.New("connection refused"))
+	exists, err := b.UserExists("eve")
+	False(t, exists)
+	Error(t, err)
+
+	NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetPassword(t *testing.T) {
+	b, mock := testBackend(t, "SELECT password, name, email FROM users WHERE username = $1")
+	b.config.PasswordQuery = "UPDATE users SET password = $1 WHERE username = $2"
+
+	mock.ExpectExec("UPDATE users SET password = $1 WHERE username = $2").
+		WithArgs(sqlmock.AnyArg(), "bob").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	NoError(t, b.SetPassword("bob", "new secret"))
+
+	mock.ExpectExec("UPDATE users SET password = $1 WHERE username = $2").
+		WithArgs(sqlmock.AnyArg(), "alice").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Equal(t, login.ErrUnknownUser, b.SetPassword("alice", "wonderland"))
+
+	mock.ExpectQuery("SELECT password, name, email FROM users WHERE username = $1").
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"password", "name", "email"}).AddRow(bcryptSecret, "Bob Builder", "bob@example.com"))
+	email, err := b.UserEmail("bob")
+	NoError(t, err)
+	Equal(t, "bob@example.com", email)
+
+	NoError(t, mock.ExpectationsWereMet())
+}
+
+func testBackend(t *testing.T, query string) (*Backend, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	NoError(t, err)
+	return &Backend{
+		config: Config{Query: query, Timeout: defaultTimeout},
+		db:     db,
+	}, mock
+}
@@ -0,0 +1,58 @@
+package dbbackend
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// compareHash verifies the password against a bcrypt or argon2id hash.
+func compareHash(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2a$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, nil
+	case strings.HasPrefix(hash, argon2idPrefix):
+		return compareArgon2id(hash, password)
+	default:
+		return false, errors.New("unknown password hash algorithm")
+	}
+}
+
+// compareArgon2id verifies a password against an argon2id hash in the PHC string format:
+// $argon2id$v=19$m=<memory in KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+func compareArgon2id(hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errors.New("unsupported argon2id version")
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errors.New("invalid argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, errors.New("invalid argon2id key")
+	}
+
+	derived := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, derived) == 1, nil
+}
@@ -0,0 +1,37 @@
+package dbbackend
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// argon2id hash of 'secret' with m=65536,t=3,p=2
+const argon2idSecret = "$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHQ$0e2W0BDMKI7YpMA4qEy2CBjmNuUDLxNhNzI8dS6hcfM"
+
+func TestCompareHash(t *testing.T) {
+	for _, hash := range []string{bcryptSecret, argon2idSecret} {
+		authenticated, err := compareHash(hash, "secret")
+		NoError(t, err)
+		True(t, authenticated, hash)
+
+		authenticated, err = compareHash(hash, "wrong")
+		NoError(t, err)
+		False(t, authenticated, hash)
+	}
+}
+
+func TestCompareHash_Errors(t *testing.T) {
+	for _, hash := range []string{
+		"secret",
+		"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHQ",
+		"$argon2id$v=16$m=65536,t=3,p=2$c29tZXNhbHQ$0e2W0BDMKI7YpMA4qEy2CBjmNuUDLxNhNzI8dS6hcfM",
+		"$argon2id$v=19$m=foo$c29tZXNhbHQ$0e2W0BDMKI7YpMA4qEy2CBjmNuUDLxNhNzI8dS6hcfM",
+		"$argon2id$v=19$m=65536,t=3,p=2$!!!$0e2W0BDMKI7YpMA4qEy2CBjmNuUDLxNhNzI8dS6hcfM",
+		"$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHQ$",
+	} {
+		_, err := compareHash(hash, "secret")
+		Error(t, err, hash)
+	}
+}
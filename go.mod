@@ -4,14 +4,30 @@ go 1.12
 
 require (
 	github.com/BTBurke/caddy-jwt v3.7.0+incompatible
+	github.com/BurntSushi/toml v1.3.2
+	github.com/DATA-DOG/go-sqlmock v1.4.1
 	github.com/abbot/go-http-auth v0.4.0
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/beevik/etree v1.1.0
+	github.com/caddyserver/caddy v1.0.1
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/gorilla/mux v1.7.1
-	github.com/caddyserver/caddy v1.0.1
+	github.com/jcmturner/gofork v1.0.0
+	github.com/jcmturner/gokrb5/v8 v8.4.2
+	github.com/lib/pq v1.3.0
 	github.com/pkg/errors v0.8.1
-	github.com/stretchr/testify v1.3.0
+	github.com/prometheus/client_golang v1.2.1
+	github.com/russellhaering/goxmldsig v1.4.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/stretchr/testify v1.6.1
 	github.com/tarent/lib-compose v0.0.0-20170829113806-69430f91d1d6
 	github.com/tarent/logrus v0.11.5
-	golang.org/x/crypto v0.0.0-20190426145343-a29dc8fdc734
+	go.etcd.io/bbolt v1.3.6
+	go.mongodb.org/mongo-driver v1.17.6
+	golang.org/x/crypto v0.26.0
+	gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
+	gopkg.in/ldap.v3 v3.0.3
 	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/login"
+)
+
+// newGRPCServer returns the server of the gRPC API or nil, if it is disabled.
+// gRPC requires HTTP/2, which is only served with TLS, so a certificate is required.
+// With a client CA, the clients have to authenticate by a certificate.
+func newGRPCServer(config *login.Config, handler http.Handler) (*http.Server, error) {
+	if config.GRPCAddress == "" {
+		return nil, nil
+	}
+
+	certFile, keyFile := config.GRPCTLSCert, config.GRPCTLSKey
+	if certFile == "" && keyFile == "" {
+		certFile, keyFile = config.TLSCert, config.TLSKey
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("grpc-address requires grpc-tls-cert and grpc-tls-key or tls-cert and tls-key")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	}
+
+	if config.GRPCClientCA != "" {
+		b, err := ioutil.ReadFile(config.GRPCClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in grpc-client-ca %v", config.GRPCClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &http.Server{Addr: config.GRPCAddress, Handler: handler, TLSConfig: tlsConfig}, nil
+}
+
+// grpcHandler serves the gRPC API with the latest login handler
+func grpcHandler(rh *reloadableHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rh.serve(func(h *login.Handler) {
+			h.GRPCHandler().ServeHTTP(w, r)
+		})
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/login"
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_NewGRPCServer(t *testing.T) {
+	srv, err := newGRPCServer(login.DefaultConfig(), http.NotFoundHandler())
+	NoError(t, err)
+	Nil(t, srv)
+
+	dir, err := ioutil.TempDir("", "loginsrv-grpc")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	// the certificate of the https server is used by default
+	config := login.DefaultConfig()
+	config.GRPCAddress = ":9443"
+	config.TLSCert = certFile
+	config.TLSKey = keyFile
+	srv, err = newGRPCServer(config, http.NotFoundHandler())
+	NoError(t, err)
+	Equal(t, ":9443", srv.Addr)
+	Equal(t, 1, len(srv.TLSConfig.Certificates))
+	Equal(t, []string{"h2"}, srv.TLSConfig.NextProtos)
+	Equal(t, tls.NoClientCert, srv.TLSConfig.ClientAuth)
+
+	config.GRPCClientCA = certFile
+	srv, err = newGRPCServer(config, http.NotFoundHandler())
+	NoError(t, err)
+	Equal(t, tls.RequireAndVerifyClientCert, srv.TLSConfig.ClientAuth)
+	NotNil(t, srv.TLSConfig.ClientCAs)
+}
+
+func Test_NewGRPCServer_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv-grpc")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	for _, modify := range []func(c *login.Config){
+		func(c *login.Config) {},
+		func(c *login.Config) { c.GRPCTLSCert = certFile },
+		func(c *login.Config) { c.GRPCTLSCert, c.GRPCTLSKey = certFile, filepath.Join(dir, "missing.pem") },
+		func(c *login.Config) { c.GRPCTLSCert, c.GRPCTLSKey, c.GRPCClientCA = certFile, keyFile, keyFile },
+	} {
+		config := login.DefaultConfig()
+		config.GRPCAddress = ":9443"
+		modify(config)
+		_, err := newGRPCServer(config, http.NotFoundHandler())
+		Error(t, err)
+	}
+}
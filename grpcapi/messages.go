@@ -0,0 +1,149 @@
+package grpcapi
+
+// The messages of loginsrv.proto
+
+// AuthenticateRequest contains the credentials for the login backends
+type AuthenticateRequest struct {
+	Username string
+	Password string
+}
+
+// RefreshRequest contains a token or a refresh token
+type RefreshRequest struct {
+	Token        string
+	RefreshToken string
+}
+
+// TokenResponse is the response of Authenticate and Refresh
+type TokenResponse struct {
+	Token        string
+	ExpiresAt    int64
+	RefreshToken string
+}
+
+// ValidateRequest contains the token to validate
+type ValidateRequest struct {
+	Token string
+}
+
+// ValidateResponse contains the result of the validation and the claims of a valid token as json
+type ValidateResponse struct {
+	Valid     bool
+	Reason    string
+	Sub       string
+	ExpiresAt int64
+	Claims    string
+}
+
+// Marshal encodes the message in the protocol buffers wire format
+func (m *AuthenticateRequest) Marshal() []byte {
+	e := encoder{}
+	e.string(1, m.Username)
+	e.string(2, m.Password)
+	return e
+}
+
+// Unmarshal decodes the message from the protocol buffers wire format
+func (m *AuthenticateRequest) Unmarshal(b []byte) error {
+	return decode(b, func(f field) (err error) {
+		switch f.number {
+		case 1:
+			m.Username, err = f.string()
+		case 2:
+			m.Password, err = f.string()
+		}
+		return err
+	})
+}
+
+// Marshal encodes the message in the protocol buffers wire format
+func (m *RefreshRequest) Marshal() []byte {
+	e := encoder{}
+	e.string(1, m.Token)
+	e.string(2, m.RefreshToken)
+	return e
+}
+
+// Unmarshal decodes the message from the protocol buffers wire format
+func (m *RefreshRequest) Unmarshal(b []byte) error {
+	return decode(b, func(f field) (err error) {
+		switch f.number {
+		case 1:
+			m.Token, err = f.string()
+		case 2:
+			m.RefreshToken, err = f.string()
+		}
+		return err
+	})
+}
+
+// Marshal encodes the message in the protocol buffers wire format
+func (m *TokenResponse) Marshal() []byte {
+	e := encoder{}
+	e.string(1, m.Token)
+	e.int64(2, m.ExpiresAt)
+	e.string(3, m.RefreshToken)
+	return e
+}
+
+// Unmarshal decodes the message from the protocol buffers wire format
+func (m *TokenResponse) Unmarshal(b []byte) error {
+	return decode(b, func(f field) (err error) {
+		switch f.number {
+		case 1:
+			m.Token, err = f.string()
+		case 2:
+			m.ExpiresAt, err = f.int64()
+		case 3:
+			m.RefreshToken, err = f.string()
+		}
+		return err
+	})
+}
+
+// Marshal encodes the message in the protocol buffers wire format
+func (m *ValidateRequest) Marshal() []byte {
+	e := encoder{}
+	e.string(1, m.Token)
+	return e
+}
+
+// Unmarshal decodes the message from the protocol buffers wire format
+func (m *ValidateRequest) Unmarshal(b []byte) error {
+	return decode(b, func(f field) (err error) {
+		if f.number == 1 {
+			m.Token, err = f.string()
+		}
+		return err
+	})
+}
+
+// Marshal encodes the message in the protocol buffers wire format
+func (m *ValidateResponse) Marshal() []byte {
+	e := encoder{}
+	e.bool(1, m.Valid)
+	e.string(2, m.Reason)
+	e.string(3, m.Sub)
+	e.int64(4, m.ExpiresAt)
+	e.string(5, m.Claims)
+	return e
+}
+
+// Unmarshal decodes the message from the protocol buffers wire format
+func (m *ValidateResponse) Unmarshal(b []byte) error {
+	return decode(b, func(f field) (err error) {
+		switch f.number {
+		case 1:
+			m.Valid, err = f.bool()
+		case 2:
+			m.Reason, err = f.string()
+		case 3:
+			m.Sub, err = f.string()
+		case 4:
+			m.ExpiresAt, err = f.int64()
+		case 5:
+			m.Claims, err = f.string()
+		}
+		return err
+	})
+}
@@ -0,0 +1,131 @@
+package grpcapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The messages of the API have only a few scalar fields, so they are encoded by hand
+// in the protocol buffers wire format, instead of depending on generated code.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// encoder appends the fields of a message. Fields with the default value are omitted, as in proto3.
+type encoder []byte
+
+func (e *encoder) tag(field, wireType int) {
+	*e = appendUvarint(*e, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *encoder) string(field int, value string) {
+	if value == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	*e = appendUvarint(*e, uint64(len(value)))
+	*e = append(*e, value...)
+}
+
+func (e *encoder) int64(field int, value int64) {
+	if value == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	*e = appendUvarint(*e, uint64(value))
+}
+
+func (e *encoder) bool(field int, value bool) {
+	if !value {
+		return
+	}
+	e.tag(field, wireVarint)
+	*e = append(*e, 1)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+// field is a decoded field. For varints, the value is in varint, otherwise in bytes.
+type field struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+func (f field) string() (string, error) {
+	if f.wireType != wireBytes {
+		return "", fmt.Errorf("field %v: expected a string", f.number)
+	}
+	return string(f.bytes), nil
+}
+
+func (f field) int64() (int64, error) {
+	if f.wireType != wireVarint {
+		return 0, fmt.Errorf("field %v: expected a varint", f.number)
+	}
+	return int64(f.varint), nil
+}
+
+func (f field) bool() (bool, error) {
+	if f.wireType != wireVarint {
+		return false, fmt.Errorf("field %v: expected a varint", f.number)
+	}
+	return f.varint != 0, nil
+}
+
+// decode calls fn for every field of the message. Unknown fields have to be ignored by fn.
+func decode(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		f := field{number: int(key >> 3), wireType: int(key & 7)}
+		if f.number <= 0 {
+			return fmt.Errorf("invalid field number %v", f.number)
+		}
+
+		switch f.wireType {
+		case wireVarint:
+			if f.varint, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if f.wireType == wireFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return errTruncated
+			}
+			f.bytes, b = b[:size], b[size:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errTruncated
+			}
+			b = b[n:]
+			f.bytes, b = b[:length], b[length:]
+		default:
+			return fmt.Errorf("unsupported wire type %v", f.wireType)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Package grpcapi serves the authentication API of loginsrv over gRPC, as defined in loginsrv.proto.
+// It implements the unary calls of the gRPC protocol on top of the HTTP/2 server of net/http,
+// so the server has to be started with TLS.
+package grpcapi
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The full method names of the Auth service
+const (
+	MethodAuthenticate = "/loginsrv.v1.Auth/Authenticate"
+	MethodRefresh      = "/loginsrv.v1.Auth/Refresh"
+	MethodValidate     = "/loginsrv.v1.Auth/Validate"
+)
+
+// maxMessageSize is the default limit of gRPC for received messages
+const maxMessageSize = 4 << 20
+
+// Code is a gRPC status code
+type Code int
+
+// The status codes, which are used by the API
+const (
+	OK                 Code = 0
+	InvalidArgument    Code = 3
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unauthenticated    Code = 16
+)
+
+// Status is an error with a gRPC status code
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("grpc status %v: %v", s.Code, s.Message)
+}
+
+// Errorf returns an error with the status code.
+func Errorf(code Code, format string, a ...interface{}) error {
+	return &Status{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Service is the implementation of the Auth service.
+// The request is the HTTP/2 request of the call, with the metadata as header.
+type Service interface {
+	Authenticate(r *http.Request, req *AuthenticateRequest) (*TokenResponse, error)
+	Refresh(r *http.Request, req *RefreshRequest) (*TokenResponse, error)
+	Validate(r *http.Request, req *ValidateRequest) (*ValidateResponse, error)
+}
+
+type message interface {
+	Marshal() []byte
+}
+
+// Server is the http handler, which dispatches the gRPC calls to the service.
+type Server struct {
+	service Service
+}
+
+// NewServer creates the handler for the service.
+func NewServer(service Service) *Server {
+	return &Server{service: service}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/grpc" && !strings.HasPrefix(contentType, "application/grpc+proto") {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	body, err := readMessage(r.Body)
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+
+	var response message
+	switch r.URL.Path {
+	case MethodAuthenticate:
+		req := &AuthenticateRequest{}
+		if err = req.Unmarshal(body); err == nil {
+			response, err = s.service.Authenticate(r, req)
+		}
+	case MethodRefresh:
+		req := &RefreshRequest{}
+		if err = req.Unmarshal(body); err == nil {
+			response, err = s.service.Refresh(r, req)
+		}
+	case MethodValidate:
+		req := &ValidateRequest{}
+		if err = req.Unmarshal(body); err == nil {
+			response, err = s.service.Validate(r, req)
+		}
+	default:
+		err = Errorf(Unimplemented, "unknown method %v", r.URL.Path)
+	}
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+
+	b := response.Marshal()
+	frame := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	w.Write(append(frame, b...)) // ignore error of writing
+	writeStatus(w, nil)
+}
+
+// readMessage reads the single length prefixed message of a unary call
+func readMessage(body io.Reader) ([]byte, error) {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(body, prefix); err != nil {
+		return nil, Errorf(InvalidArgument, "can't read the request message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > maxMessageSize {
+		return nil, Errorf(ResourceExhausted, "request message larger than %v bytes", maxMessageSize)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(body, b); err != nil {
+		return nil, Errorf(InvalidArgument, "can't read the request message: %v", err)
+	}
+	return b, nil
+}
+
+// writeStatus sets the status of the call in the trailers. Errors without a status are internal errors.
+func writeStatus(w http.ResponseWriter, err error) {
+	status, ok := err.(*Status)
+	switch {
+	case err == nil:
+		status = &Status{Code: OK}
+	case !ok:
+		status = &Status{Code: Internal, Message: err.Error()}
+	case status.Code == OK:
+		status = &Status{Code: Unimplemented, Message: "invalid status"}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(status.Code)))
+	if status.Message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(status.Message))
+	}
+}
+
+// encodeMessage percent encodes the status message, as required by the protocol
+func encodeMessage(message string) string {
+	return strings.Replace(url.PathEscape(message), "%20", " ", -1)
+}
+
+// parseTimeout parses the grpc-timeout header, e.g. 100m for 100 milliseconds
+func parseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, exist := units[value[len(value)-1]]
+	if !exist {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
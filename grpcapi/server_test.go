@@ -0,0 +1,140 @@
+package grpcapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testService struct{}
+
+func (testService) Authenticate(r *http.Request, req *AuthenticateRequest) (*TokenResponse, error) {
+	if req.Username == "bob" && req.Password == "secret" {
+		return &TokenResponse{Token: "token-of-bob", ExpiresAt: 1600000000}, nil
+	}
+	return nil, Errorf(Unauthenticated, "wrong credentials for %v", req.Username)
+}
+
+func (testService) Refresh(r *http.Request, req *RefreshRequest) (*TokenResponse, error) {
+	return nil, errors.New("store not available")
+}
+
+func (testService) Validate(r *http.Request, req *ValidateRequest) (*ValidateResponse, error) {
+	if _, hasDeadline := r.Context().Deadline(); !hasDeadline {
+		return nil, Errorf(InvalidArgument, "missing deadline")
+	}
+	return &ValidateResponse{Valid: true, Sub: "bob", Claims: `{"sub":"bob"}`}, nil
+}
+
+func TestServer(t *testing.T) {
+	server := httptest.NewUnstartedServer(NewServer(testService{}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	response := &TokenResponse{}
+	code, message := call(t, server, MethodAuthenticate, &AuthenticateRequest{Username: "bob", Password: "secret"}, response)
+	assert.Equal(t, OK, code)
+	assert.Equal(t, "", message)
+	assert.Equal(t, &TokenResponse{Token: "token-of-bob", ExpiresAt: 1600000000}, response)
+
+	code, message = call(t, server, MethodAuthenticate, &AuthenticateRequest{Username: "alice"}, &TokenResponse{})
+	assert.Equal(t, Unauthenticated, code)
+	assert.Equal(t, "wrong credentials for alice", message)
+
+	code, _ = call(t, server, MethodRefresh, &RefreshRequest{Token: "xxx"}, &TokenResponse{})
+	assert.Equal(t, Internal, code)
+
+	validateResponse := &ValidateResponse{}
+	code, _ = call(t, server, MethodValidate, &ValidateRequest{Token: "xxx"}, validateResponse, "Grpc-Timeout: 5S")
+	assert.Equal(t, OK, code)
+	assert.Equal(t, &ValidateResponse{Valid: true, Sub: "bob", Claims: `{"sub":"bob"}`}, validateResponse)
+
+	code, _ = call(t, server, "/loginsrv.v1.Auth/Logout", &ValidateRequest{Token: "xxx"}, &ValidateResponse{})
+	assert.Equal(t, Unimplemented, code)
+}
+
+func TestServer_RequiresHTTP2(t *testing.T) {
+	server := httptest.NewServer(NewServer(testService{}))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+MethodValidate, "application/grpc", bytes.NewReader(frame(&ValidateRequest{Token: "xxx"})))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusHTTPVersionNotSupported, resp.StatusCode)
+}
+
+func TestMessages_SkipUnknownFields(t *testing.T) {
+	e := encoder{}
+	e.string(1, "bob")
+	e.int64(7, 42)
+	e.string(8, "unknown")
+	e.tag(9, wireFixed64)
+	e = append(e, 1, 2, 3, 4, 5, 6, 7, 8)
+	e.string(2, "secret")
+
+	req := &AuthenticateRequest{}
+	assert.NoError(t, req.Unmarshal(e))
+	assert.Equal(t, &AuthenticateRequest{Username: "bob", Password: "secret"}, req)
+
+	assert.Error(t, req.Unmarshal(e[:len(e)-1]))
+	assert.Error(t, req.Unmarshal([]byte{1<<3 | wireVarint, 1}))
+}
+
+func TestParseTimeout(t *testing.T) {
+	timeout, ok := parseTimeout("100m")
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, timeout)
+
+	timeout, ok = parseTimeout("2H")
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Hour, timeout)
+
+	for _, value := range []string{"", "5", "5x", "-5S", "1234567890S"} {
+		_, ok = parseTimeout(value)
+		assert.False(t, ok, value)
+	}
+}
+
+func frame(m message) []byte {
+	b := m.Marshal()
+	prefix := make([]byte, 5)
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(b)))
+	return append(prefix, b...)
+}
+
+// call invokes the method and returns the status of the trailers
+func call(t *testing.T, server *httptest.Server, method string, req message, resp interface{ Unmarshal([]byte) error }, header ...string) (Code, string) {
+	r, err := http.NewRequest("POST", server.URL+method, bytes.NewReader(frame(req)))
+	assert.NoError(t, err)
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("Te", "trailers")
+	for _, h := range header {
+		pair := bytes.SplitN([]byte(h), []byte(": "), 2)
+		r.Header.Set(string(pair[0]), string(pair[1]))
+	}
+
+	httpResp, err := server.Client().Do(r)
+	assert.NoError(t, err)
+	defer httpResp.Body.Close()
+	assert.Equal(t, 2, httpResp.ProtoMajor)
+	assert.Equal(t, 200, httpResp.StatusCode)
+	body, err := ioutil.ReadAll(httpResp.Body)
+	assert.NoError(t, err)
+
+	if len(body) > 0 {
+		assert.Equal(t, uint32(len(body)-5), binary.BigEndian.Uint32(body[1:5]))
+		assert.NoError(t, resp.Unmarshal(body[5:]))
+	}
+	code, err := strconv.Atoi(httpResp.Trailer.Get("Grpc-Status"))
+	assert.NoError(t, err)
+	return Code(code), httpResp.Trailer.Get("Grpc-Message")
+}
@@ -0,0 +1,54 @@
+package htpasswd
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id format (PHC string format, as written by the argon2 cli and passlib):
+// $argon2id$v=19$m=<memory in KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+// with base64 encoded salt and key without padding.
+const argon2idPrefix = "$argon2id$"
+
+func isArgon2id(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+// compareArgon2id verifies a password against an argon2id hash.
+// The parameters are taken from the hash, so that hashes with different parameter sets can be verified.
+func compareArgon2id(hashedPassword, password string) bool {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false
+	}
+	if iterations < 1 || parallelism < 1 {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+
+	derived := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	return 1 == subtle.ConstantTimeCompare(key, derived)
+}
@@ -6,12 +6,15 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"github.com/abbot/go-http-auth"
 	"github.com/afdecastro879/loginsrv/logging"
 	"golang.org/x/crypto/bcrypt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -24,11 +27,19 @@ type File struct {
 	modTime time.Time
 }
 
+// ErrUserExists is returned by AddUser, if the username is already in use
+var ErrUserExists = errors.New("user already exists")
+
+// ErrUnknownUser is returned by SetPassword, if no file contains the user
+var ErrUnknownUser = errors.New("unknown user")
+
 // Auth is the htpassword authenticater
 type Auth struct {
 	filenames  []File
 	userHash   map[string]string
 	muUserHash sync.RWMutex
+	// muWrite serializes the changes of the files
+	muWrite sync.Mutex
 }
 
 // NewAuth creates an htpassword authenticater
@@ -44,11 +55,15 @@ func NewAuth(filenames []string) (*Auth, error) {
 	return a, a.parse()
 }
 
+// parse reads all files. The users are replaced at once and only if all files could be parsed,
+// so an authentication never sees a partially written file.
 func (a *Auth) parse() error {
 	tmpUserHash := map[string]string{}
-	tmpFilenames := a.filenames
+	a.muUserHash.RLock()
+	tmpFilenames := append([]File{}, a.filenames...)
+	a.muUserHash.RUnlock()
 
-	for i, filename := range a.filenames {
+	for i, filename := range tmpFilenames {
 		r, err := os.Open(filename.name)
 		if err != nil {
 			return err
@@ -79,7 +94,7 @@ func (a *Auth) parse() error {
 			}
 
 			if _, exist := tmpUserHash[record[0]]; exist {
-				logging.Logger.Warnf("Found duplicate entry for user: (%v)", record[0])
+				logging.Logger.WithField("username", record[0]).Warn("Found duplicate entry for user")
 			}
 			tmpUserHash[record[0]] = record[1]
 		}
@@ -110,14 +125,146 @@ func (a *Auth) Authenticate(username, password string) (bool, error) {
 		if strings.HasPrefix(hash, "$apr1$") {
 			return compareMD5(h, p), nil
 		}
+		if isPbkdf2(hash) {
+			return comparePbkdf2(hash, password), nil
+		}
+		if isArgon2id(hash) {
+			return compareArgon2id(hash, password), nil
+		}
+		if isScrypt(hash) {
+			return compareScrypt(hash, password), nil
+		}
 		return false, fmt.Errorf("unknown algorithm for user %q", username)
 	}
 	return false, nil
 }
 
+// UserExists returns true, if one of the files contains the user
+func (a *Auth) UserExists(username string) bool {
+	reloadIfChanged(a)
+	a.muUserHash.RLock()
+	defer a.muUserHash.RUnlock()
+	_, exist := a.userHash[username]
+	return exist
+}
+
+// AddUser appends the user with the bcrypt hash of the password to the first file.
+func (a *Auth) AddUser(username, password string) error {
+	if username == "" || strings.ContainsAny(username, ":\r\n") {
+		return fmt.Errorf("invalid username %q", username)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	a.muWrite.Lock()
+	defer a.muWrite.Unlock()
+	if a.UserExists(username) {
+		return ErrUserExists
+	}
+
+	a.muUserHash.RLock()
+	filename := a.filenames[0].name
+	a.muUserHash.RUnlock()
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	entry := username + ":" + string(hash) + "\n"
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		entry = "\n" + entry
+	}
+	if _, err := f.WriteString(entry); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return a.parse()
+}
+
+// SetPassword replaces the hash of the user in all files by a bcrypt hash of the password.
+// The files are replaced atomically, so a concurrent reload never sees a partially written file.
+func (a *Auth) SetPassword(username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	a.muWrite.Lock()
+	defer a.muWrite.Unlock()
+
+	a.muUserHash.RLock()
+	files := append([]File{}, a.filenames...)
+	a.muUserHash.RUnlock()
+
+	found := false
+	for _, file := range files {
+		changed, err := replaceHash(file.name, username, string(hash))
+		if err != nil {
+			return err
+		}
+		found = found || changed
+	}
+	if !found {
+		return ErrUnknownUser
+	}
+	return a.parse()
+}
+
+// replaceHash writes the hash to the lines of the user and returns false, if the file does not contain the user.
+func replaceHash(filename, username, hash string) (bool, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	lines := strings.Split(string(content), "\n")
+	changed := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimLeft(line, " \t"), username+":") {
+			lines[i] = username + ":" + hash
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return false, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "\n")); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(tmp.Name(), fileInfo.Mode()); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), filename)
+}
+
 // Reload htpasswd file if it changed during current run
 func reloadIfChanged(a *Auth) {
-	for _, file := range a.filenames {
+	a.muUserHash.RLock()
+	files := append([]File{}, a.filenames...)
+	a.muUserHash.RUnlock()
+
+	for i, file := range files {
 		fileInfo, err := os.Stat(file.name)
 		if err != nil {
 			//On error, retain current file
@@ -125,7 +272,15 @@ func reloadIfChanged(a *Auth) {
 		}
 		currentmodTime := fileInfo.ModTime()
 		if currentmodTime != file.modTime {
-			a.parse()
+			if err := a.parse(); err != nil {
+				// retain the current users until the next change of the file
+				logging.Logger.WithError(err).Warnf("can't reload htpasswd file %v, keeping the previous users", file.name)
+				a.muUserHash.Lock()
+				a.filenames[i].modTime = currentmodTime
+				a.muUserHash.Unlock()
+				return
+			}
+			logging.Logger.Infof("reloaded htpasswd file %v", file.name)
 			return
 		}
 	}
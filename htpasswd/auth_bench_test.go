@@ -0,0 +1,23 @@
+package htpasswd
+
+import (
+	"testing"
+)
+
+func BenchmarkAuth_Authenticate(b *testing.B) {
+	auth, err := NewAuth(writeTmpfile(testfile))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, name := range []string{"bob-md5", "bob-bcrypt", "bob-sha", "bob-pbkdf2-sha256", "bob-argon2id"} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				authenticated, err := auth.Authenticate(name, "secret")
+				if err != nil || !authenticated {
+					b.Fatalf("authentication of %v failed: %v", name, err)
+				}
+			}
+		})
+	}
+}
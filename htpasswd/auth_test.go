@@ -13,6 +13,12 @@ const testfile = `bob-md5:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.
 
 bob-bcrypt:$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6
 bob-sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+bob-pbkdf2:$pbkdf2$1000$MDEyMzQ1Njc4OWFiY2RlZg$21EupWTmSOvnK3Sp99FL7THUyuQ
+bob-pbkdf2-sha256:$pbkdf2-sha256$29000$MDEyMzQ1Njc4OWFiY2RlZg$aQOdhWy9q87h1Y13loIh9irlXgv2WqcdeMcUgrd9rJ4
+bob-pbkdf2-sha512:$pbkdf2-sha512$25000$MDEyMzQ1Njc4OWFiY2RlZg$m28yyWgXn4Qvd2fQ81e1S2A5xmuunG4E1Gf1486hu9kLDwIHy8F3YHrAgxGilxgKS5vWq9EwEkBqsTS2bxygZg
+bob-pkcs5s2:{PKCS5S2}ZmVkY2JhOTg3NjU0MzIxMFQPnRyke0M2LKGJIGQVsaK/uSP8EQrIPfMISjHhf/tL
+bob-argon2id:$argon2id$v=19$m=4096,t=3,p=1$MDEyMzQ1Njc4OWFiY2RlZg$Ptx4flgCVQ73HxyuwRfrtjAMbqGje4Rti1ysNBADgUY
+bob-scrypt:$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$S7FwBvpu.z8K0PUVUagFRTUAB2dAxIZpzVHvLZir.98
 
 # a comment
 bob-foo:{fooo}sdcsdcsdc/BfQ=
@@ -24,7 +30,7 @@ func TestAuth_Hashes(t *testing.T) {
 	auth, err := NewAuth(writeTmpfile(testfile))
 	NoError(t, err)
 
-	testUsers := []string{"bob-md5", "bob-bcrypt", "bob-sha"}
+	testUsers := []string{"bob-md5", "bob-bcrypt", "bob-sha", "bob-pbkdf2", "bob-pbkdf2-sha256", "bob-pbkdf2-sha512", "bob-pkcs5s2", "bob-argon2id", "bob-scrypt"}
 	for _, name := range testUsers {
 		t.Run(name, func(t *testing.T) {
 			authenticated, err := auth.Authenticate(name, "secret")
@@ -38,6 +44,25 @@ func TestAuth_Hashes(t *testing.T) {
 	}
 }
 
+func TestAuth_MalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"$argon2id$v=19$m=4096,t=3,p=1$MDEyMzQ1Njc4OWFiY2RlZg",
+		"$argon2id$v=16$m=4096,t=3,p=1$MDEyMzQ1Njc4OWFiY2RlZg$Ptx4flgCVQ73HxyuwRfrtjAMbqGje4Rti1ysNBADgUY",
+		"$argon2id$v=19$m=4096,t=0,p=1$MDEyMzQ1Njc4OWFiY2RlZg$Ptx4flgCVQ73HxyuwRfrtjAMbqGje4Rti1ysNBADgUY",
+		"$argon2id$v=19$m=4096,t=3,p=1$!!!$Ptx4flgCVQ73HxyuwRfrtjAMbqGje4Rti1ysNBADgUY",
+		"$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg",
+		"$scrypt$ln=99,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$S7FwBvpu.z8K0PUVUagFRTUAB2dAxIZpzVHvLZir.98",
+		"$scrypt$ln=10,r=0,p=1$MDEyMzQ1Njc4OWFiY2RlZg$S7FwBvpu.z8K0PUVUagFRTUAB2dAxIZpzVHvLZir.98",
+		"$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$",
+	} {
+		auth, err := NewAuth(writeTmpfile("bob:" + hash))
+		NoError(t, err)
+		authenticated, err := auth.Authenticate("bob", "secret")
+		NoError(t, err, hash)
+		False(t, authenticated, hash)
+	}
+}
+
 func TestAuth_ReloadFile(t *testing.T) {
 	files := writeTmpfile(`bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`)
 
@@ -67,6 +92,33 @@ func TestAuth_ReloadFile(t *testing.T) {
 	True(t, authenticated)
 }
 
+func TestAuth_ReloadInvalidFile(t *testing.T) {
+	files := writeTmpfile(`bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`)
+
+	auth, err := NewAuth(files)
+	NoError(t, err)
+
+	// a partially written file keeps the previous users
+	NoError(t, ioutil.WriteFile(files[0], []byte("alice"), 0644))
+	NoError(t, os.Chtimes(files[0], time.Now(), time.Now().Add(time.Minute)))
+
+	authenticated, err := auth.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	// the completed file is loaded
+	NoError(t, ioutil.WriteFile(files[0], []byte(`alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`), 0644))
+	NoError(t, os.Chtimes(files[0], time.Now(), time.Now().Add(2*time.Minute)))
+
+	authenticated, err = auth.Authenticate("bob", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, err = auth.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+}
+
 func TestAuth_FromTwoFiles(t *testing.T) {
 	files := writeTmpfile(`bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`, `alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`)
 
@@ -140,6 +192,19 @@ func TestAuth_BadMD5Format(t *testing.T) {
 	False(t, authenticated)
 }
 
+func TestAuth_BadPbkdf2Format(t *testing.T) {
+	a, err := NewAuth(writeTmpfile(`foo:$pbkdf2-sha256$29000$MDEyMzQ1Njc4OWFiY2RlZg
+bar:$pbkdf2-sha256$xxx$MDEyMzQ1Njc4OWFiY2RlZg$aQOdhWy9q87h1Y13loIh9irlXgv2WqcdeMcUgrd9rJ4
+baz:{PKCS5S2}ZmVkY2JhOTg3NjU0MzIxMA==`))
+	NoError(t, err)
+
+	for _, name := range []string{"foo", "bar", "baz"} {
+		authenticated, err := a.Authenticate(name, "secret")
+		NoError(t, err)
+		False(t, authenticated)
+	}
+}
+
 func TestAuth_Hashes_UnknownAlgoError(t *testing.T) {
 	auth, err := NewAuth(writeTmpfile(testfile))
 	NoError(t, err)
@@ -149,6 +214,63 @@ func TestAuth_Hashes_UnknownAlgoError(t *testing.T) {
 	False(t, authenticated)
 }
 
+func TestAuth_AddUser(t *testing.T) {
+	files := writeTmpfile("bob:$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6", testfile)
+	defer os.Remove(files[0])
+	defer os.Remove(files[1])
+	auth, err := NewAuth(files)
+	NoError(t, err)
+
+	False(t, auth.UserExists("alice"))
+	NoError(t, auth.AddUser("alice", "wonderland"))
+	True(t, auth.UserExists("alice"))
+
+	authenticated, err := auth.Authenticate("alice", "wonderland")
+	NoError(t, err)
+	True(t, authenticated)
+	authenticated, err = auth.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	// the user is appended to the first file in a new line
+	content, err := ioutil.ReadFile(files[0])
+	NoError(t, err)
+	Regexp(t, `^bob:\S+\nalice:\$2a\$\S+\n$`, string(content))
+
+	Equal(t, ErrUserExists, auth.AddUser("alice", "other"))
+	Equal(t, ErrUserExists, auth.AddUser("bob-md5", "other"))
+	Error(t, auth.AddUser("eve:x", "secret"))
+	Error(t, auth.AddUser("", "secret"))
+}
+
+func TestAuth_SetPassword(t *testing.T) {
+	files := writeTmpfile("bob:$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6\n# alice\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	defer os.Remove(files[0])
+	NoError(t, os.Chmod(files[0], 0640))
+	auth, err := NewAuth(files)
+	NoError(t, err)
+
+	NoError(t, auth.SetPassword("alice", "wonderland"))
+	authenticated, err := auth.Authenticate("alice", "wonderland")
+	NoError(t, err)
+	True(t, authenticated)
+	authenticated, err = auth.Authenticate("alice", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+	authenticated, err = auth.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	content, err := ioutil.ReadFile(files[0])
+	NoError(t, err)
+	Regexp(t, `^bob:\S+\n# alice\nalice:\$2a\$\S+\n$`, string(content))
+	fileInfo, err := os.Stat(files[0])
+	NoError(t, err)
+	Equal(t, os.FileMode(0640), fileInfo.Mode())
+
+	Equal(t, ErrUnknownUser, auth.SetPassword("eve", "secret"))
+}
+
 func writeTmpfile(contents ...string) []string {
 	var names []string
 	for _, curContent := range contents {
@@ -15,6 +15,10 @@ func init() {
 		&login.ProviderDescription{
 			Name:     ProviderName,
 			HelpText: "Htpasswd login backend opts: files=/path/to/pwdfile,/path/to/additionalfile",
+			Options: []login.OptionDescription{
+				{Name: "file"},
+				{Name: "files"},
+			},
 		},
 		BackendFactory)
 }
@@ -66,3 +70,33 @@ func (sb *Backend) Authenticate(username, password string) (bool, model.UserInfo
 	}
 	return false, model.UserInfo{}, err
 }
+
+// UserExists returns true, if the user is in one of the files
+func (sb *Backend) UserExists(username string) (bool, error) {
+	return sb.auth.UserExists(username), nil
+}
+
+// Register appends the user with a bcrypt hash of the password to the first file.
+// The email address is not stored, because htpasswd files have no field for it.
+func (sb *Backend) Register(username, password, email string) error {
+	err := sb.auth.AddUser(username, password)
+	if err == ErrUserExists {
+		return login.ErrUserExists
+	}
+	return err
+}
+
+// SetPassword replaces the password of the user by a bcrypt hash in the files
+func (sb *Backend) SetPassword(username, password string) error {
+	err := sb.auth.SetPassword(username, password)
+	if err == ErrUnknownUser {
+		return login.ErrUnknownUser
+	}
+	return err
+}
+
+// UserEmail returns an empty string, because htpasswd files have no field for the email address.
+// The email address for the password reset is taken from the user claims instead.
+func (sb *Backend) UserEmail(username string) (string, error) {
+	return "", nil
+}
@@ -99,6 +99,47 @@ func TestSimpleBackend_Authenticate(t *testing.T) {
 	NoError(t, err)
 }
 
+func TestSimpleBackend_Register(t *testing.T) {
+	files := writeTmpfile(testfile)
+	defer os.Remove(files[0])
+	backend, err := NewBackend(files)
+	NoError(t, err)
+
+	exists, err := backend.UserExists("alice")
+	NoError(t, err)
+	False(t, exists)
+
+	NoError(t, backend.Register("alice", "wonderland", "alice@example.com"))
+	exists, err = backend.UserExists("alice")
+	NoError(t, err)
+	True(t, exists)
+
+	authenticated, userInfo, err := backend.Authenticate("alice", "wonderland")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "alice", userInfo.Sub)
+
+	Equal(t, login.ErrUserExists, backend.Register("bob-bcrypt", "secret12", ""))
+}
+
+func TestSimpleBackend_SetPassword(t *testing.T) {
+	files := writeTmpfile(testfile)
+	defer os.Remove(files[0])
+	backend, err := NewBackend(files)
+	NoError(t, err)
+
+	NoError(t, backend.SetPassword("bob-md5", "new secret"))
+	authenticated, _, err := backend.Authenticate("bob-md5", "new secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	Equal(t, login.ErrUnknownUser, backend.SetPassword("alice", "wonderland"))
+
+	email, err := backend.UserEmail("bob-md5")
+	NoError(t, err)
+	Equal(t, "", email)
+}
+
 func modTime(f string) time.Time {
 	fileInfo, err := os.Stat(f)
 	if err != nil {
@@ -0,0 +1,95 @@
+package htpasswd
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// pbkdf2Hashes are the hash functions of the passlib pbkdf2 formats
+var pbkdf2Hashes = map[string]func() hash.Hash{
+	"$pbkdf2$":        sha1.New,
+	"$pbkdf2-sha256$": sha256.New,
+	"$pbkdf2-sha512$": sha512.New,
+}
+
+// atlassian pbkdf2 format: {PKCS5S2}base64(16 byte salt + 32 byte key), using hmac-sha1 with 10000 iterations
+const (
+	pkcs5s2Prefix     = "{PKCS5S2}"
+	pkcs5s2SaltLength = 16
+	pkcs5s2KeyLength  = 32
+	pkcs5s2Iterations = 10000
+)
+
+func isPbkdf2(hash string) bool {
+	if strings.HasPrefix(hash, pkcs5s2Prefix) {
+		return true
+	}
+	_, ok := pbkdf2HashFunc(hash)
+	return ok
+}
+
+func pbkdf2HashFunc(hash string) (func() hash.Hash, bool) {
+	for prefix, hashFunc := range pbkdf2Hashes {
+		if strings.HasPrefix(hash, prefix) {
+			return hashFunc, true
+		}
+	}
+	return nil, false
+}
+
+// comparePbkdf2 verifies a password against a pbkdf2 hash.
+// The parameters are taken from the hash, so that hashes with different parameter sets can be verified.
+func comparePbkdf2(hashedPassword, password string) bool {
+	if strings.HasPrefix(hashedPassword, pkcs5s2Prefix) {
+		return comparePkcs5s2(hashedPassword, password)
+	}
+
+	// passlib format: $pbkdf2-<digest>$<rounds>$<salt>$<checksum>
+	hashFunc, ok := pbkdf2HashFunc(hashedPassword)
+	if !ok {
+		return false
+	}
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 5 {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[2])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := decodeAb64(parts[3])
+	if err != nil {
+		return false
+	}
+	checksum, err := decodeAb64(parts[4])
+	if err != nil || len(checksum) == 0 {
+		return false
+	}
+
+	key := pbkdf2.Key([]byte(password), salt, iterations, len(checksum), hashFunc)
+	return 1 == subtle.ConstantTimeCompare(checksum, key)
+}
+
+func comparePkcs5s2(hashedPassword, password string) bool {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hashedPassword, pkcs5s2Prefix))
+	if err != nil || len(b) != pkcs5s2SaltLength+pkcs5s2KeyLength {
+		return false
+	}
+	salt, checksum := b[:pkcs5s2SaltLength], b[pkcs5s2SaltLength:]
+
+	key := pbkdf2.Key([]byte(password), salt, pkcs5s2Iterations, pkcs5s2KeyLength, sha1.New)
+	return 1 == subtle.ConstantTimeCompare(checksum, key)
+}
+
+// decodeAb64 decodes the adapted base64 of passlib, which uses '.' instead of '+' and no padding
+func decodeAb64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.Replace(s, ".", "+", -1))
+}
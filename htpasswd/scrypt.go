@@ -0,0 +1,50 @@
+package htpasswd
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt format of passlib:
+// $scrypt$ln=<log2 of the cost N>,r=<block size>,p=<parallelism>$<salt>$<checksum>
+// with salt and checksum in the adapted base64 of passlib.
+const scryptPrefix = "$scrypt$"
+
+func isScrypt(hash string) bool {
+	return strings.HasPrefix(hash, scryptPrefix)
+}
+
+// compareScrypt verifies a password against a scrypt hash.
+func compareScrypt(hashedPassword, password string) bool {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 5 {
+		return false
+	}
+
+	var logN uint
+	var r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return false
+	}
+	if logN < 1 || logN > 30 || r < 1 || p < 1 {
+		return false
+	}
+
+	salt, err := decodeAb64(parts[3])
+	if err != nil {
+		return false
+	}
+	checksum, err := decodeAb64(parts[4])
+	if err != nil || len(checksum) == 0 {
+		return false
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, len(checksum))
+	if err != nil {
+		return false
+	}
+	return 1 == subtle.ConstantTimeCompare(checksum, key)
+}
@@ -0,0 +1,186 @@
+package httpauth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// ProviderName const
+const ProviderName = "httpauth"
+
+const defaultTimeout = 5 * time.Second
+
+// maxResponseSize limits the size of the JSON responses of the endpoint
+const maxResponseSize = 1 << 20
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "HTTP credential check login backend opts: url=..[,auth=..][,timeout=..][,skipverify=..]",
+			Options: []login.OptionDescription{
+				{Name: "url", Type: login.OptionURL, Required: true},
+				{Name: "auth"},
+				{Name: "timeout", Type: login.OptionDuration},
+				{Name: "skipverify", Type: login.OptionBool},
+			},
+		},
+		BackendFactory)
+}
+
+// Config of the httpauth backend
+type Config struct {
+	// URL of the endpoint, which checks the credentials
+	URL string
+	// Auth is sent as bearer token to the endpoint (optional)
+	Auth       string
+	Timeout    time.Duration
+	SkipVerify bool
+}
+
+// BackendFactory creates a httpauth backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg := Config{
+		URL:     opts["url"],
+		Auth:    opts["auth"],
+		Timeout: defaultTimeout,
+	}
+
+	if cfg.URL == "" {
+		return nil, errors.New(`missing parameter "url" for httpauth provider`)
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf(`invalid parameter value "%s" in "url" for httpauth provider`, cfg.URL)
+	}
+
+	var err error
+	if v, exist := opts["timeout"]; exist {
+		if cfg.Timeout, err = time.ParseDuration(v); err != nil || cfg.Timeout <= 0 {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "timeout" for httpauth provider`, v)
+		}
+	}
+	if v, exist := opts["skipverify"]; exist {
+		if cfg.SkipVerify, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "skipverify" for httpauth provider`, v)
+		}
+	}
+
+	return NewBackend(cfg), nil
+}
+
+// Backend posts the credentials to an endpoint, which returns 200 for valid credentials.
+type Backend struct {
+	config Config
+	client *http.Client
+}
+
+// NewBackend creates a new httpauth Backend.
+func NewBackend(cfg Config) *Backend {
+	client := &http.Client{Timeout: cfg.Timeout}
+	if cfg.SkipVerify {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	return &Backend{
+		config: cfg,
+		client: client,
+	}
+}
+
+// Authenticate the user by the endpoint.
+// The status 200 accepts the credentials, 401 and 403 reject them and all others are errors.
+// The JSON object of the response is mapped to the claims of the user, e.g. name, email and groups.
+// The sub claim of the response replaces the username, e.g. to normalize the spelling.
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if username == "" || password == "" {
+		return false, model.UserInfo{}, nil
+	}
+	body, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if b.config.Auth != "" {
+		req.Header.Set("Authorization", "Bearer "+b.config.Auth)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, model.UserInfo{}, nil
+	default:
+		return false, model.UserInfo{}, fmt.Errorf("httpauth endpoint returned status %v", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	userInfo, err := parseUserInfo(data)
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	if userInfo.Sub == "" {
+		userInfo.Sub = username
+	}
+	userInfo.Origin = ProviderName
+	return true, userInfo, nil
+}
+
+// parseUserInfo maps the claims of the response to the user info. The whole response is kept as raw user info
+// for the claims mapping. An empty response is accepted.
+func parseUserInfo(body []byte) (model.UserInfo, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return model.UserInfo{}, nil
+	}
+	var claims struct {
+		Sub     string   `json:"sub"`
+		Name    string   `json:"name"`
+		Email   string   `json:"email"`
+		Picture string   `json:"picture"`
+		Domain  string   `json:"domain"`
+		Groups  []string `json:"groups"`
+		Roles   []string `json:"roles"`
+	}
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return model.UserInfo{}, fmt.Errorf("invalid httpauth response: %v", err)
+	}
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return model.UserInfo{}, fmt.Errorf("invalid httpauth response: %v", err)
+	}
+	return model.UserInfo{
+		Sub:     claims.Sub,
+		Name:    claims.Name,
+		Email:   claims.Email,
+		Picture: claims.Picture,
+		Domain:  claims.Domain,
+		Groups:  claims.Groups,
+		Roles:   claims.Roles,
+		Raw:     raw,
+	}, nil
+}
@@ -0,0 +1,121 @@
+package httpauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{
+		"url":        "https://users.example.com/check",
+		"auth":       "s3cr3t",
+		"timeout":    "2s",
+		"skipverify": "true",
+	})
+	NoError(t, err)
+	Equal(t, Config{
+		URL:        "https://users.example.com/check",
+		Auth:       "s3cr3t",
+		Timeout:    2 * time.Second,
+		SkipVerify: true,
+	}, backend.(*Backend).config)
+}
+
+func TestSetup_Error(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{},
+		{"url": "users.example.com/check"},
+		{"url": "https://users.example.com/check", "timeout": "2"},
+		{"url": "https://users.example.com/check", "skipverify": "maybe"},
+	} {
+		_, err := BackendFactory(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+// testEndpoint accepts bob/secret and answers alice/secret with the response
+func testEndpoint(t *testing.T, response string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "POST", r.Method)
+		Equal(t, "application/json", r.Header.Get("Content-Type"))
+		Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+
+		credentials := map[string]string{}
+		NoError(t, json.NewDecoder(r.Body).Decode(&credentials))
+		switch {
+		case credentials["password"] != "secret":
+			w.WriteHeader(401)
+		case credentials["username"] == "bob":
+			w.WriteHeader(200)
+		case credentials["username"] == "alice":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(response))
+		case credentials["username"] == "locked":
+			w.WriteHeader(403)
+		default:
+			w.WriteHeader(500)
+		}
+	}))
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	server := testEndpoint(t, `{"sub": "Alice", "name": "Alice Smith", "email": "alice@example.com", "groups": ["admin", "dev"], "department": "IT"}`)
+	defer server.Close()
+	b := NewBackend(Config{URL: server.URL, Auth: "s3cr3t", Timeout: time.Second})
+
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Sub: "bob", Origin: ProviderName}, userInfo)
+
+	authenticated, userInfo, err = b.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "Alice", userInfo.Sub)
+	Equal(t, "Alice Smith", userInfo.Name)
+	Equal(t, "alice@example.com", userInfo.Email)
+	Equal(t, []string{"admin", "dev"}, userInfo.Groups)
+	Equal(t, ProviderName, userInfo.Origin)
+	Equal(t, "IT", userInfo.Raw["department"])
+
+	authenticated, _, err = b.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("locked", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_Error(t *testing.T) {
+	server := testEndpoint(t, `not json`)
+	b := NewBackend(Config{URL: server.URL, Auth: "s3cr3t", Timeout: time.Second})
+
+	authenticated, _, err := b.Authenticate("carol", "secret")
+	Error(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("alice", "secret")
+	Error(t, err)
+	False(t, authenticated)
+
+	server.Close()
+	authenticated, _, err = b.Authenticate("bob", "secret")
+	Error(t, err)
+	False(t, authenticated)
+}
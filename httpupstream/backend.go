@@ -21,6 +21,11 @@ func init() {
 		&login.ProviderDescription{
 			Name:     ProviderName,
 			HelpText: "Httpupstream login backend opts: upstream=...,skipverify=...,timeout=...",
+			Options: []login.OptionDescription{
+				{Name: "upstream", Type: login.OptionURL, Required: true},
+				{Name: "skipverify", Type: login.OptionBool},
+				{Name: "timeout", Type: login.OptionDuration},
+			},
 		},
 		BackendFactory)
 }
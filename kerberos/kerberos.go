@@ -0,0 +1,135 @@
+// Package kerberos implements the single sign-on of domain-joined browsers by SPNEGO (RFC 4559).
+// The browser sends a Kerberos service ticket in the Authorization header, which is verified with the keytab of the service.
+package kerberos
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// ProviderName is the origin of the users, logged in by kerberos
+const ProviderName = "kerberos"
+
+// negotiatePrefix is the scheme of the Authorization header
+const negotiatePrefix = "Negotiate "
+
+// Config of the kerberos service
+type Config struct {
+	// Keytab is the file with the keys of the service principal
+	Keytab string
+	// ServicePrincipal selects the key of the keytab, e.g. HTTP/login.example.com.
+	// If it is empty, the service principal of the ticket is used.
+	ServicePrincipal string
+	// Realm restricts the logins to the users of the realm, which is removed from the username.
+	// If it is empty, users of all realms are accepted as user@REALM.
+	Realm string
+}
+
+// NewConfigFromOpts creates the configuration from the options of the kerberos flag.
+func NewConfigFromOpts(opts map[string]string) (Config, error) {
+	cfg := Config{
+		Keytab:           opts["keytab"],
+		ServicePrincipal: opts["service_principal"],
+		Realm:            opts["realm"],
+	}
+	if cfg.Keytab == "" {
+		return Config{}, errors.New(`missing parameter "keytab" for kerberos`)
+	}
+	for key := range opts {
+		switch key {
+		case "keytab", "service_principal", "realm":
+		default:
+			return Config{}, fmt.Errorf(`unknown parameter "%v" for kerberos`, key)
+		}
+	}
+	return cfg, nil
+}
+
+// Service verifies the SPNEGO tokens of the browsers.
+type Service struct {
+	config   Config
+	settings *service.Settings
+}
+
+// NewService loads the keytab of the service.
+func NewService(cfg Config) (*Service, error) {
+	kt, err := keytab.Load(cfg.Keytab)
+	if err != nil {
+		return nil, fmt.Errorf("can not load kerberos keytab %v: %v", cfg.Keytab, err)
+	}
+	return newService(cfg, kt), nil
+}
+
+func newService(cfg Config, kt *keytab.Keytab) *Service {
+	settings := []func(*service.Settings){}
+	if cfg.ServicePrincipal != "" {
+		settings = append(settings, service.KeytabPrincipal(cfg.ServicePrincipal))
+	}
+	return &Service{
+		config:   cfg,
+		settings: service.NewSettings(kt, settings...),
+	}
+}
+
+// IsNegotiate returns true, if the Authorization header contains a SPNEGO token.
+func IsNegotiate(authorization string) bool {
+	return strings.HasPrefix(authorization, negotiatePrefix)
+}
+
+// Authenticate verifies the ticket of the Negotiate Authorization header and returns the user of the ticket.
+// The full name of the user is taken from the PAC of Active Directory tickets.
+func (s *Service) Authenticate(authorization string) (model.UserInfo, error) {
+	if !IsNegotiate(authorization) {
+		return model.UserInfo{}, errors.New("no Negotiate authorization")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(authorization, negotiatePrefix)))
+	if err != nil {
+		return model.UserInfo{}, fmt.Errorf("invalid Negotiate token: %v", err)
+	}
+
+	// most browsers wrap the kerberos token in a SPNEGO token, some send it raw
+	mechToken := b
+	var st spnego.SPNEGOToken
+	if err := st.Unmarshal(b); err == nil {
+		if !st.Init {
+			return model.UserInfo{}, errors.New("expected a SPNEGO NegTokenInit")
+		}
+		mechToken = st.NegTokenInit.MechTokenBytes
+	}
+	var kt spnego.KRB5Token
+	if err := kt.Unmarshal(mechToken); err != nil {
+		// e.g. a NTLM token of a browser outside of the domain
+		return model.UserInfo{}, err
+	}
+	if !kt.IsAPReq() {
+		return model.UserInfo{}, errors.New("the kerberos token is no AP_REQ")
+	}
+
+	ok, creds, err := service.VerifyAPREQ(&kt.APReq, s.settings)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	if !ok {
+		return model.UserInfo{}, errors.New("invalid kerberos ticket")
+	}
+
+	sub := creds.UserName() + "@" + creds.Domain()
+	if s.config.Realm != "" {
+		if !strings.EqualFold(creds.Domain(), s.config.Realm) {
+			return model.UserInfo{}, fmt.Errorf("kerberos realm %v of %v is not allowed", creds.Domain(), creds.UserName())
+		}
+		sub = creds.UserName()
+	}
+	return model.UserInfo{
+		Sub:    sub,
+		Name:   creds.GetADCredentials().FullName,
+		Origin: ProviderName,
+	}, nil
+}
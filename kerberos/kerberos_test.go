@@ -0,0 +1,161 @@
+package kerberos
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	. "github.com/stretchr/testify/assert"
+)
+
+const (
+	testRealm = "EXAMPLE.COM"
+	testSPN   = "HTTP/login.example.com"
+)
+
+func TestNewConfigFromOpts(t *testing.T) {
+	cfg, err := NewConfigFromOpts(map[string]string{"keytab": "http.keytab", "service_principal": testSPN, "realm": testRealm})
+	NoError(t, err)
+	Equal(t, Config{Keytab: "http.keytab", ServicePrincipal: testSPN, Realm: testRealm}, cfg)
+
+	_, err = NewConfigFromOpts(map[string]string{"realm": testRealm})
+	Error(t, err)
+
+	_, err = NewConfigFromOpts(map[string]string{"keytab": "http.keytab", "foo": "bar"})
+	Error(t, err)
+}
+
+func TestNewService(t *testing.T) {
+	kt := testKeytab(t)
+	b, err := kt.Marshal()
+	NoError(t, err)
+	f, err := ioutil.TempFile("", "loginsrv-keytab")
+	NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	NoError(t, err)
+	f.Close()
+
+	s, err := NewService(Config{Keytab: f.Name()})
+	NoError(t, err)
+	userInfo, err := s.Authenticate(negotiateHeader(t, kt, "alice", testRealm, true))
+	NoError(t, err)
+	Equal(t, "alice@EXAMPLE.COM", userInfo.Sub)
+
+	_, err = NewService(Config{Keytab: "does-not-exist.keytab"})
+	Error(t, err)
+}
+
+func TestService_Authenticate(t *testing.T) {
+	kt := testKeytab(t)
+	s := newService(Config{Realm: testRealm}, kt)
+
+	userInfo, err := s.Authenticate(negotiateHeader(t, kt, "alice", testRealm, true))
+	NoError(t, err)
+	Equal(t, model.UserInfo{Sub: "alice", Origin: ProviderName}, userInfo)
+
+	// a raw kerberos token without SPNEGO wrapper
+	userInfo, err = s.Authenticate(negotiateHeader(t, kt, "bob", testRealm, false))
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+}
+
+func TestService_Authenticate_Realm(t *testing.T) {
+	kt := testKeytab(t)
+
+	// users of other realms are accepted with their realm
+	s := newService(Config{}, kt)
+	userInfo, err := s.Authenticate(negotiateHeader(t, kt, "alice", "OTHER.EXAMPLE.COM", true))
+	NoError(t, err)
+	Equal(t, "alice@OTHER.EXAMPLE.COM", userInfo.Sub)
+
+	s = newService(Config{Realm: testRealm}, kt)
+	_, err = s.Authenticate(negotiateHeader(t, kt, "alice", "OTHER.EXAMPLE.COM", true))
+	Error(t, err)
+}
+
+func TestService_Authenticate_Invalid(t *testing.T) {
+	kt := testKeytab(t)
+	s := newService(Config{}, kt)
+
+	// a ticket for another service key
+	otherKeytab := keytab.New()
+	NoError(t, otherKeytab.AddEntry(testSPN, testRealm, "other-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	_, err := s.Authenticate(negotiateHeader(t, otherKeytab, "alice", testRealm, true))
+	Error(t, err)
+
+	// a NTLM token of a browser outside of the domain
+	_, err = s.Authenticate("Negotiate TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAGAbEdAAAADw==")
+	Error(t, err)
+
+	_, err = s.Authenticate("Negotiate %%%")
+	Error(t, err)
+
+	_, err = s.Authenticate("Bearer token")
+	Error(t, err)
+}
+
+func TestService_Authenticate_Replay(t *testing.T) {
+	kt := testKeytab(t)
+	s := newService(Config{}, kt)
+
+	header := negotiateHeader(t, kt, "replayed", testRealm, true)
+	_, err := s.Authenticate(header)
+	NoError(t, err)
+	_, err = s.Authenticate(header)
+	Error(t, err)
+}
+
+func TestIsNegotiate(t *testing.T) {
+	True(t, IsNegotiate("Negotiate YIIC"))
+	False(t, IsNegotiate("Bearer token"))
+	False(t, IsNegotiate(""))
+}
+
+func testKeytab(t *testing.T) *keytab.Keytab {
+	kt := keytab.New()
+	NoError(t, kt.AddEntry(testSPN, testRealm, "service-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	return kt
+}
+
+// negotiateHeader returns the Authorization header of a browser with a service ticket, which is issued with the service key of the keytab
+func negotiateHeader(t *testing.T, kt *keytab.Keytab, username, realm string, wrap bool) string {
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, username)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, testSPN)
+	now := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cname, realm, sname, testRealm, types.NewKrbFlags(), kt,
+		etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	NoError(t, err)
+
+	cl := client.NewWithPassword(username, realm, "", config.New())
+	token, err := spnego.NewKRB5TokenAPREQ(cl, tkt, sessionKey, []int{gssapi.ContextFlagInteg}, []int{})
+	NoError(t, err)
+	b, err := token.Marshal()
+	NoError(t, err)
+
+	if wrap {
+		st := spnego.SPNEGOToken{
+			Init: true,
+			NegTokenInit: spnego.NegTokenInit{
+				MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+				MechTokenBytes: b,
+			},
+		}
+		b, err = st.Marshal()
+		NoError(t, err)
+	}
+	return "Negotiate " + base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,289 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	goldap "gopkg.in/ldap.v3"
+)
+
+// ProviderName const
+const ProviderName = "ldap"
+
+const (
+	defaultTimeout             = 5 * time.Second
+	defaultSearchTimeout       = 5 * time.Second
+	defaultSearchRetryAttempts = 2
+	defaultUserFilter          = "(uid={username})"
+	defaultGroupFilter         = "(member={dn})"
+	defaultGroupNameAttribute  = "cn"
+)
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "LDAP login backend opts: url=ldaps://..,bind_dn_template=..|base_dn=..,user_filter=..,bind_dn=..,bind_password=..,group_base_dn=..,group_filter=..,use_member_of=..,start_tls=..",
+			Options: []login.OptionDescription{
+				{Name: "url", Type: login.OptionURL, Required: true},
+				{Name: "bind_dn_template"},
+				{Name: "base_dn"},
+				{Name: "user_filter"},
+				{Name: "bind_dn"},
+				{Name: "bind_password"},
+				{Name: "group_base_dn"},
+				{Name: "group_filter"},
+				{Name: "group_name_attribute"},
+				{Name: "use_member_of", Type: login.OptionBool},
+				{Name: "start_tls", Type: login.OptionBool},
+				{Name: "insecure_skip_verify", Type: login.OptionBool},
+				{Name: "timeout", Type: login.OptionDuration},
+				{Name: "search_timeout", Type: login.OptionDuration},
+				{Name: "search_retry_attempts", Type: login.OptionInt},
+			},
+		},
+		BackendFactory)
+}
+
+// Config of the ldap backend
+type Config struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	// BindDNTemplate is the dn to bind with directly, e.g. uid={username},ou=people,dc=example,dc=org.
+	// If it is empty, the user is searched in BaseDN with the UserFilter first.
+	// If both are set, the user entry is searched after the bind.
+	BindDNTemplate string
+
+	// BindDN and BindPassword of a service account for the user and group search (optional)
+	BindDN       string
+	BindPassword string
+
+	BaseDN     string
+	UserFilter string
+
+	GroupBaseDN        string
+	GroupFilter        string
+	GroupNameAttribute string
+
+	// UseMemberOf takes the groups from the memberOf attribute of the user instead of a group search
+	UseMemberOf bool
+
+	SearchTimeout       time.Duration
+	SearchRetryAttempts int
+}
+
+// BackendFactory creates a ldap backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg := Config{
+		URL:                 opts["url"],
+		BindDNTemplate:      opts["bind_dn_template"],
+		BindDN:              opts["bind_dn"],
+		BindPassword:        opts["bind_password"],
+		BaseDN:              opts["base_dn"],
+		UserFilter:          defaultUserFilter,
+		GroupBaseDN:         opts["group_base_dn"],
+		GroupFilter:         defaultGroupFilter,
+		GroupNameAttribute:  defaultGroupNameAttribute,
+		Timeout:             defaultTimeout,
+		SearchTimeout:       defaultSearchTimeout,
+		SearchRetryAttempts: defaultSearchRetryAttempts,
+	}
+
+	if cfg.URL == "" {
+		return nil, errors.New(`missing parameter "url" for ldap provider`)
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+		return nil, fmt.Errorf(`invalid parameter value "%s" in "url" for ldap provider, expected ldap:// or ldaps://`, cfg.URL)
+	}
+	if cfg.BindDNTemplate == "" && cfg.BaseDN == "" {
+		return nil, errors.New(`missing parameter "bind_dn_template" or "base_dn" for ldap provider`)
+	}
+
+	if v, exist := opts["user_filter"]; exist {
+		cfg.UserFilter = v
+	}
+	if v, exist := opts["group_filter"]; exist {
+		cfg.GroupFilter = v
+	}
+	if v, exist := opts["group_name_attribute"]; exist {
+		cfg.GroupNameAttribute = v
+	}
+
+	for key, target := range map[string]*bool{
+		"start_tls":            &cfg.StartTLS,
+		"insecure_skip_verify": &cfg.InsecureSkipVerify,
+		"use_member_of":        &cfg.UseMemberOf,
+	} {
+		if v, exist := opts[key]; exist {
+			if *target, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf(`invalid parameter value "%s" in "%s" for ldap provider: %v`, v, key, err)
+			}
+		}
+	}
+	if cfg.StartTLS && u.Scheme == "ldaps" {
+		return nil, errors.New(`"start_tls" can not be used with ldaps:// in ldap provider`)
+	}
+
+	for key, target := range map[string]*time.Duration{
+		"timeout":        &cfg.Timeout,
+		"search_timeout": &cfg.SearchTimeout,
+	} {
+		if v, exist := opts[key]; exist {
+			if *target, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf(`invalid parameter value "%s" in "%s" for ldap provider: %v`, v, key, err)
+			}
+		}
+	}
+
+	if v, exist := opts["search_retry_attempts"]; exist {
+		if cfg.SearchRetryAttempts, err = strconv.Atoi(v); err != nil || cfg.SearchRetryAttempts < 0 {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "search_retry_attempts" for ldap provider`, v)
+		}
+	}
+
+	return NewBackend(cfg), nil
+}
+
+// Backend is the ldap authentication backend.
+type Backend struct {
+	config Config
+	dial   func() (conn, error)
+}
+
+// NewBackend creates a new ldap Backend.
+func NewBackend(cfg Config) *Backend {
+	b := &Backend{config: cfg}
+	b.dial = b.dialLDAP
+	return b
+}
+
+// Authenticate the user by a bind with its dn and password
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	// an empty password would result in an anonymous bind, which succeeds on most servers
+	if username == "" || password == "" {
+		return false, model.UserInfo{}, nil
+	}
+
+	c, err := b.dial()
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	defer c.Close()
+
+	entry, authenticated, err := b.bindUser(c, username, password)
+	if !authenticated || err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	groups, err := b.groups(c, entry)
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	name := entry.GetAttributeValue("displayName")
+	if name == "" {
+		name = entry.GetAttributeValue("cn")
+	}
+	return true, model.UserInfo{
+		Sub:    username,
+		Name:   name,
+		Email:  entry.GetAttributeValue("mail"),
+		Groups: groups,
+		Origin: ProviderName,
+	}, nil
+}
+
+// bindUser binds with the credentials of the user and returns the entry of the user.
+func (b *Backend) bindUser(c conn, username, password string) (*goldap.Entry, bool, error) {
+	if b.config.BindDNTemplate != "" {
+		userDN := strings.Replace(b.config.BindDNTemplate, "{username}", escapeDN(username), -1)
+		if authenticated, err := bind(c, userDN, password); !authenticated || err != nil {
+			return nil, false, err
+		}
+		var entry *goldap.Entry
+		var err error
+		if b.config.BaseDN != "" {
+			// the template may not be a dn, e.g. the user principal name of active directory
+			entry, err = b.searchOne(c, b.config.BaseDN, goldap.ScopeWholeSubtree, b.userFilter(username))
+		} else {
+			entry, err = b.searchOne(c, userDN, goldap.ScopeBaseObject, "(objectClass=*)")
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if entry == nil {
+			return nil, false, fmt.Errorf("ldap entry %v not found after bind", userDN)
+		}
+		return entry, true, nil
+	}
+
+	if err := b.bindServiceAccount(c); err != nil {
+		return nil, false, err
+	}
+	entry, err := b.searchOne(c, b.config.BaseDN, goldap.ScopeWholeSubtree, b.userFilter(username))
+	if entry == nil || err != nil {
+		return nil, false, err
+	}
+	if authenticated, err := bind(c, entry.DN, password); !authenticated || err != nil {
+		return nil, false, err
+	}
+	// search the groups with the service account again
+	return entry, true, b.bindServiceAccount(c)
+}
+
+func (b *Backend) userFilter(username string) string {
+	return strings.Replace(b.config.UserFilter, "{username}", goldap.EscapeFilter(username), -1)
+}
+
+func (b *Backend) bindServiceAccount(c conn) error {
+	if b.config.BindDN == "" {
+		return nil
+	}
+	if err := c.Bind(b.config.BindDN, b.config.BindPassword); err != nil {
+		return fmt.Errorf("ldap bind of %v failed: %v", b.config.BindDN, err)
+	}
+	return nil
+}
+
+// bind returns false, if the credentials are invalid and an error for all other failures.
+func bind(c conn, dn, password string) (bool, error) {
+	err := c.Bind(dn, password)
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ldap bind of %v failed: %v", dn, err)
+	}
+	return true, nil
+}
+
+// searchOne returns the single entry matching the filter or nil, if there is none.
+func (b *Backend) searchOne(c conn, baseDN string, scope int, filter string) (*goldap.Entry, error) {
+	req := goldap.NewSearchRequest(baseDN, scope, goldap.NeverDerefAliases, 2, 0, false,
+		filter, []string{"dn", "cn", "displayName", "mail", "memberOf"}, nil)
+	result, err := c.Search(req)
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap search for %v failed: %v", filter, err)
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, nil
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("ldap search for %v returned more than one entry", filter)
+	}
+}
@@ -0,0 +1,24 @@
+package ldap
+
+import (
+	"testing"
+)
+
+func BenchmarkBackend_Authenticate(b *testing.B) {
+	c := newFakeConn()
+	backend := testBackend(c, Config{
+		BindDN:       "cn=admin,dc=example,dc=org",
+		BindPassword: "adminpw",
+		BaseDN:       "dc=example,dc=org",
+		UserFilter:   defaultUserFilter,
+		GroupFilter:  defaultGroupFilter,
+	})
+
+	for i := 0; i < b.N; i++ {
+		c.searches = nil
+		authenticated, _, err := backend.Authenticate("bob", "secret")
+		if err != nil || !authenticated {
+			b.Fatalf("authentication of bob failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package ldap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+	goldap "gopkg.in/ldap.v3"
+)
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{
+		"url":                   "ldaps://ldap.example.com",
+		"base_dn":               "ou=people,dc=example,dc=org",
+		"use_member_of":         "true",
+		"search_timeout":        "2s",
+		"search_retry_attempts": "3",
+	})
+	NoError(t, err)
+	cfg := backend.(*Backend).config
+	Equal(t, "(uid={username})", cfg.UserFilter)
+	Equal(t, defaultTimeout, cfg.Timeout)
+	Equal(t, 2*time.Second, cfg.SearchTimeout)
+	Equal(t, 3, cfg.SearchRetryAttempts)
+	True(t, cfg.UseMemberOf)
+}
+
+func TestSetup_Errors(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"base_dn": "dc=example,dc=org"},
+		{"url": "http://ldap.example.com", "base_dn": "dc=example,dc=org"},
+		{"url": "ldap://ldap.example.com"},
+		{"url": "ldaps://ldap.example.com", "base_dn": "dc=example,dc=org", "start_tls": "true"},
+		{"url": "ldap://ldap.example.com", "base_dn": "dc=example,dc=org", "timeout": "foo"},
+		{"url": "ldap://ldap.example.com", "base_dn": "dc=example,dc=org", "use_member_of": "foo"},
+		{"url": "ldap://ldap.example.com", "base_dn": "dc=example,dc=org", "search_retry_attempts": "-1"},
+	} {
+		_, err := BackendFactory(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestAuthenticate_Search(t *testing.T) {
+	c := newFakeConn()
+	b := testBackend(c, Config{
+		BindDN:       "cn=admin,dc=example,dc=org",
+		BindPassword: "adminpw",
+		BaseDN:       "dc=example,dc=org",
+		UserFilter:   defaultUserFilter,
+		GroupFilter:  defaultGroupFilter,
+	})
+
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{
+		Sub:    "bob",
+		Name:   "Bob Builder",
+		Email:  "bob@example.org",
+		Groups: []string{"admins"},
+		Origin: "ldap",
+	}, userInfo)
+	Equal(t, "(member=uid=bob,ou=people,dc=example,dc=org)", c.searches[1].Filter)
+
+	authenticated, _, err = b.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("alice", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestAuthenticate_FilterInjection(t *testing.T) {
+	c := newFakeConn()
+	b := testBackend(c, Config{BaseDN: "dc=example,dc=org", UserFilter: defaultUserFilter})
+
+	authenticated, _, err := b.Authenticate("*", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+	Equal(t, `(uid=\2a)`, c.searches[0].Filter)
+}
+
+func TestAuthenticate_DirectBindWithMemberOf(t *testing.T) {
+	c := newFakeConn()
+	b := testBackend(c, Config{
+		BindDNTemplate: "uid={username},ou=people,dc=example,dc=org",
+		UseMemberOf:    true,
+	})
+
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"admins", "developers"}, userInfo.Groups)
+	Equal(t, goldap.ScopeBaseObject, c.searches[0].Scope)
+
+	authenticated, _, err = b.Authenticate("bob,ou=admins", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+	Equal(t, `uid=bob\,ou\=admins,ou=people,dc=example,dc=org`, c.lastBind)
+}
+
+func TestAuthenticate_GroupSearchRetry(t *testing.T) {
+	defer func(backoff time.Duration) { searchRetryBackoff = backoff }(searchRetryBackoff)
+	searchRetryBackoff = time.Millisecond
+
+	c := newFakeConn()
+	c.groupErrors = []error{
+		goldap.NewError(goldap.LDAPResultTimeLimitExceeded, errors.New("time limit exceeded")),
+		errors.New("ldap: connection timed out"),
+	}
+	b := testBackend(c, Config{
+		BaseDN:              "dc=example,dc=org",
+		UserFilter:          defaultUserFilter,
+		GroupFilter:         defaultGroupFilter,
+		SearchRetryAttempts: 2,
+	})
+
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"admins"}, userInfo.Groups)
+	Equal(t, "dc=example,dc=org", c.searches[1].BaseDN)
+	Equal(t, "ou=people,dc=example,dc=org", c.searches[2].BaseDN)
+	Equal(t, "ou=people,dc=example,dc=org", c.searches[3].BaseDN)
+
+	c.groupErrors = []error{
+		goldap.NewError(goldap.LDAPResultTimeLimitExceeded, errors.New("time limit exceeded")),
+		goldap.NewError(goldap.LDAPResultTimeLimitExceeded, errors.New("time limit exceeded")),
+		goldap.NewError(goldap.LDAPResultTimeLimitExceeded, errors.New("time limit exceeded")),
+	}
+	_, _, err = b.Authenticate("bob", "secret")
+	Error(t, err)
+}
+
+func TestEscapeDN(t *testing.T) {
+	Equal(t, "bob", escapeDN("bob"))
+	Equal(t, `bob\,ou\=admins`, escapeDN("bob,ou=admins"))
+	Equal(t, `\#bob\+\\\<\>\;\"\ `, escapeDN(`#bob+\<>;" `))
+}
+
+func TestGroupNames(t *testing.T) {
+	Equal(t,
+		[]string{"admins", "dev,ops", "not a dn"},
+		groupNames([]string{"CN=admins,OU=Groups,DC=example,DC=org", `cn=dev\,ops,dc=example,dc=org`, "not a dn"}))
+}
+
+func testBackend(c conn, cfg Config) *Backend {
+	if cfg.GroupNameAttribute == "" {
+		cfg.GroupNameAttribute = defaultGroupNameAttribute
+	}
+	b := NewBackend(cfg)
+	b.dial = func() (conn, error) {
+		return c, nil
+	}
+	return b
+}
+
+// fakeConn is an in memory directory with the user bob and the group admins
+type fakeConn struct {
+	passwords   map[string]string
+	users       map[string]*goldap.Entry
+	groupErrors []error
+	searches    []*goldap.SearchRequest
+	lastBind    string
+}
+
+func newFakeConn() *fakeConn {
+	bobDN := "uid=bob,ou=people,dc=example,dc=org"
+	return &fakeConn{
+		passwords: map[string]string{
+			"cn=admin,dc=example,dc=org": "adminpw",
+			bobDN:                        "secret",
+		},
+		users: map[string]*goldap.Entry{
+			"(uid=bob)": goldap.NewEntry(bobDN, map[string][]string{
+				"cn":          {"bob"},
+				"displayName": {"Bob Builder"},
+				"mail":        {"bob@example.org"},
+				"memberOf":    {"cn=admins,ou=groups,dc=example,dc=org", "cn=developers,ou=groups,dc=example,dc=org"},
+			}),
+		},
+	}
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	c.lastBind = username
+	if pw, exist := c.passwords[username]; !exist || pw != password {
+		return goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (c *fakeConn) Search(req *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	c.searches = append(c.searches, req)
+	result := &goldap.SearchResult{}
+	switch {
+	case req.Scope == goldap.ScopeBaseObject:
+		for _, e := range c.users {
+			if e.DN == req.BaseDN {
+				result.Entries = append(result.Entries, e)
+			}
+		}
+	case c.users[req.Filter] != nil:
+		result.Entries = append(result.Entries, c.users[req.Filter])
+	case req.Filter == "(member=uid=bob,ou=people,dc=example,dc=org)":
+		if len(c.groupErrors) > 0 {
+			err := c.groupErrors[0]
+			c.groupErrors = c.groupErrors[1:]
+			return nil, err
+		}
+		result.Entries = append(result.Entries, goldap.NewEntry("cn=admins,ou=groups,dc=example,dc=org", map[string][]string{"cn": {"admins"}}))
+	}
+	return result, nil
+}
+
+func (c *fakeConn) Close() {
+}
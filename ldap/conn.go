@@ -0,0 +1,87 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strings"
+
+	goldap "gopkg.in/ldap.v3"
+)
+
+// conn is the part of the ldap connection used by the backend
+type conn interface {
+	Bind(username, password string) error
+	Search(searchRequest *goldap.SearchRequest) (*goldap.SearchResult, error)
+	Close()
+}
+
+// dialLDAP opens a connection to the configured ldap server.
+// The configured timeout applies to the connect and to every request.
+func (b *Backend) dialLDAP() (conn, error) {
+	u, err := url.Parse(b.config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "ldaps" {
+			addr = net.JoinHostPort(u.Hostname(), "636")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "389")
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: b.config.InsecureSkipVerify,
+	}
+	dialer := &net.Dialer{Timeout: b.config.Timeout}
+
+	var c *goldap.Conn
+	if u.Scheme == "ldaps" {
+		nc, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		c = goldap.NewConn(nc, true)
+	} else {
+		nc, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		c = goldap.NewConn(nc, false)
+	}
+	c.Start()
+	c.SetTimeout(b.config.Timeout)
+
+	if b.config.StartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// escapeDN escapes a value for the use in a distinguished name, as defined in RFC 4514.
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case r == ',' || r == '+' || r == '"' || r == '\\' || r == '<' || r == '>' || r == ';' || r == '=':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == ' ' && (i == 0 || i == len(value)-1):
+			b.WriteString(`\ `)
+		case r == '#' && i == 0:
+			b.WriteString(`\#`)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package ldap
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	goldap "gopkg.in/ldap.v3"
+)
+
+// searchRetryBackoff is the wait before the first retry of a timed out group search.
+// It is doubled for each further retry.
+var searchRetryBackoff = 500 * time.Millisecond
+
+// groups returns the names of the groups of the user entry.
+func (b *Backend) groups(c conn, entry *goldap.Entry) ([]string, error) {
+	if b.config.UseMemberOf {
+		return groupNames(entry.GetAttributeValues("memberOf")), nil
+	}
+	if b.config.GroupFilter == "" {
+		return nil, nil
+	}
+	return b.searchGroups(c, entry.DN)
+}
+
+// searchGroups searches the groups with the group filter.
+// Searches, which time out are retried with exponential back-off. If no group_base_dn is configured,
+// the retries use the parent of the user entry as base, which is cheaper to search on large directories.
+func (b *Backend) searchGroups(c conn, userDN string) ([]string, error) {
+	baseDN := b.config.GroupBaseDN
+	if baseDN == "" {
+		baseDN = b.config.BaseDN
+	}
+	if baseDN == "" {
+		baseDN = parentDN(userDN)
+	}
+	filter := strings.Replace(b.config.GroupFilter, "{dn}", goldap.EscapeFilter(userDN), -1)
+	timeLimit := int(math.Ceil(b.config.SearchTimeout.Seconds()))
+
+	start := time.Now()
+	backoff := searchRetryBackoff
+	for attempt := 0; ; attempt++ {
+		req := goldap.NewSearchRequest(baseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, timeLimit, false,
+			filter, []string{b.config.GroupNameAttribute}, nil)
+		result, err := c.Search(req)
+		if err == nil {
+			groups := make([]string, 0, len(result.Entries))
+			for _, e := range result.Entries {
+				if name := e.GetAttributeValue(b.config.GroupNameAttribute); name != "" {
+					groups = append(groups, name)
+				}
+			}
+			return groups, nil
+		}
+		if !isTimeout(err) || attempt >= b.config.SearchRetryAttempts {
+			return nil, fmt.Errorf("ldap group search in %v failed: %v", baseDN, err)
+		}
+
+		logging.Logger.
+			WithField("base_dn", baseDN).
+			WithField("attempt", attempt+1).
+			WithField("elapsed", time.Since(start).String()).
+			Warnf("ldap group search timed out, retrying in %v", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if b.config.GroupBaseDN == "" {
+			baseDN = parentDN(userDN)
+		}
+	}
+}
+
+// isTimeout returns true for a time limit exceeded on the server or a timed out request on the client.
+func isTimeout(err error) bool {
+	return goldap.IsErrorWithCode(err, goldap.LDAPResultTimeLimitExceeded) ||
+		strings.Contains(err.Error(), "timed out")
+}
+
+// parentDN returns the dn without its first rdn.
+func parentDN(dn string) string {
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) < 2 {
+		return dn
+	}
+	rdns := make([]string, 0, len(parsed.RDNs)-1)
+	for _, rdn := range parsed.RDNs[1:] {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, a := range rdn.Attributes {
+			attrs = append(attrs, a.Type+"="+escapeDN(a.Value))
+		}
+		rdns = append(rdns, strings.Join(attrs, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
+
+// groupNames maps the group dns of the memberOf attribute to their common name.
+// Values without a cn are taken as they are.
+func groupNames(dns []string) []string {
+	groups := make([]string, 0, len(dns))
+	for _, dn := range dns {
+		groups = append(groups, commonName(dn))
+	}
+	return groups
+}
+
+func commonName(dn string) string {
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return dn
+	}
+	for _, a := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(a.Type, "cn") {
+			return a.Value
+		}
+	}
+	return dn
+}
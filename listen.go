@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/login"
+)
+
+const (
+	unixSocketPrefix = "unix:"
+	// systemdFirstFD is the first file descriptor passed by the systemd socket activation
+	systemdFirstFD = 3
+)
+
+// listen creates the listener of the server: the socket passed by systemd,
+// a unix domain socket for a host unix:/path/to/socket, or tcp on the port otherwise.
+func listen(config *login.Config) (net.Listener, error) {
+	l, err := systemdListener(os.Getenv, systemdFirstFD)
+	if l != nil || err != nil {
+		return l, err
+	}
+
+	if strings.HasPrefix(config.Host, unixSocketPrefix) {
+		return unixListener(strings.TrimPrefix(config.Host, unixSocketPrefix), config.SocketMode)
+	}
+
+	port := config.Port
+	if port != "" {
+		port = fmt.Sprintf(":%s", port)
+	}
+	return net.Listen("tcp", port)
+}
+
+// systemdListener returns the socket of the systemd socket activation or nil,
+// if loginsrv was not started by a systemd socket.
+func systemdListener(getenv func(string) string, firstFD int) (net.Listener, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	// the variables are not inherited by child processes
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	fds, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q of the systemd socket activation", getenv("LISTEN_FDS"))
+	}
+	if fds > 1 {
+		return nil, fmt.Errorf("systemd passed %v sockets, but loginsrv supports only one", fds)
+	}
+
+	f := os.NewFile(uintptr(firstFD), "systemd-socket")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("can't use the systemd socket: %v", err)
+	}
+	return l, nil
+}
+
+// unixListener listens on a unix domain socket with the permissions of the mode.
+// A socket, which is left over from a previous run, is removed.
+func unixListener(path, mode string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("missing path of the unix socket")
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode %q: %v", mode, err)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists and is no socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
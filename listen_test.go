@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/login"
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_Listen_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv-socket")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "loginsrv.sock")
+
+	config := login.DefaultConfig()
+	config.Host = "unix:" + path
+
+	// a left over socket is replaced
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("unix", path)
+		NoError(t, err)
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+
+		l, err = listen(config)
+		NoError(t, err)
+		Equal(t, "unix", l.Addr().Network())
+		info, err := os.Stat(path)
+		NoError(t, err)
+		Equal(t, os.FileMode(0660), info.Mode().Perm())
+
+		go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}))
+		conn, err := net.Dial("unix", path)
+		NoError(t, err)
+		conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		response, err := ioutil.ReadAll(conn)
+		NoError(t, err)
+		Contains(t, string(response), "hello")
+		conn.Close()
+		l.Close()
+	}
+}
+
+func Test_Listen_UnixSocket_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv-socket")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// no regular file is removed
+	file := filepath.Join(dir, "file")
+	NoError(t, ioutil.WriteFile(file, []byte("data"), 0600))
+	_, err = unixListener(file, "0660")
+	Error(t, err)
+	FileExists(t, file)
+
+	_, err = unixListener("", "0660")
+	Error(t, err)
+
+	_, err = unixListener(filepath.Join(dir, "sock"), "rw")
+	Error(t, err)
+}
+
+func Test_Listen_TCP(t *testing.T) {
+	config := login.DefaultConfig()
+	config.Port = "0"
+	l, err := listen(config)
+	NoError(t, err)
+	Equal(t, "tcp", l.Addr().Network())
+	l.Close()
+}
+
+func Test_SystemdListener(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	NoError(t, err)
+	defer tcp.Close()
+	f, err := tcp.(*net.TCPListener).File()
+	NoError(t, err)
+	defer f.Close()
+
+	env := map[string]string{}
+	getenv := func(name string) string {
+		return env[name]
+	}
+
+	// not started by systemd
+	l, err := systemdListener(getenv, int(f.Fd()))
+	NoError(t, err)
+	Nil(t, l)
+
+	// for another process
+	env["LISTEN_PID"] = "1"
+	env["LISTEN_FDS"] = "1"
+	l, err = systemdListener(getenv, int(f.Fd()))
+	NoError(t, err)
+	Nil(t, l)
+
+	env["LISTEN_PID"] = strconv.Itoa(os.Getpid())
+	env["LISTEN_FDS"] = "2"
+	_, err = systemdListener(getenv, int(f.Fd()))
+	Error(t, err)
+
+	env["LISTEN_FDS"] = "x"
+	_, err = systemdListener(getenv, int(f.Fd()))
+	Error(t, err)
+
+	env["LISTEN_FDS"] = "1"
+	l, err = systemdListener(getenv, int(f.Fd()))
+	NoError(t, err)
+	Equal(t, tcp.Addr().String(), l.Addr().String())
+	l.Close()
+}
@@ -14,14 +14,20 @@ func init() {
 }
 
 var CorrelationIdHeader = "X-Correlation-Id"
+
+// RequestIdHeader is used as correlation id, if a proxy in front of loginsrv already set a request id
+var RequestIdHeader = "X-Request-Id"
 var UserCorrelationCookie = ""
 
 // EnsureCorrelationId returns the correlation from of the request.
-// If the request does not have a correlation id, one will be generated and set to the request.
+// If the request does not have a correlation id, the request id or a generated one will be set to the request.
 func EnsureCorrelationId(r *http.Request) string {
 	id := r.Header.Get(CorrelationIdHeader)
 	if id == "" {
-		id = randStringBytes(10)
+		id = r.Header.Get(RequestIdHeader)
+		if id == "" {
+			id = randStringBytes(10)
+		}
 		r.Header.Set(CorrelationIdHeader, id)
 	}
 	return id
@@ -19,7 +19,8 @@ func NewLogMiddleware(next http.Handler) *LogMiddleware {
 }
 
 func (mw *LogMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	EnsureCorrelationId(r)
+	// the correlation id is returned, so clients can refer to the log entries of a request
+	w.Header().Set(CorrelationIdHeader, EnsureCorrelationId(r))
 	start := time.Now()
 
 	defer func() {
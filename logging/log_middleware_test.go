@@ -77,3 +77,28 @@ func Test_LogMiddleware_Log_404(t *testing.T) {
 	a.Equal(404, data.ResponseStatus)
 	a.Equal("warning", data.Level)
 }
+
+func Test_LogMiddleware_CorrelationId(t *testing.T) {
+	a := assert.New(t)
+	Logger.Out = bytes.NewBuffer(nil)
+
+	var correlationId string
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlationId = GetCorrelationId(r.Header)
+	}))
+
+	// a generated id is returned
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	recorder := httptest.NewRecorder()
+	lm.ServeHTTP(recorder, r)
+	a.Len(correlationId, 10)
+	a.Equal(correlationId, recorder.Header().Get(CorrelationIdHeader))
+
+	// the request id of a proxy is used
+	r, _ = http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(RequestIdHeader, "proxy-id")
+	recorder = httptest.NewRecorder()
+	lm.ServeHTTP(recorder, r)
+	a.Equal("proxy-id", correlationId)
+	a.Equal("proxy-id", recorder.Header().Get(CorrelationIdHeader))
+}
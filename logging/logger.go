@@ -17,28 +17,109 @@ var AccessLogCookiesBlacklist = []string{}
 
 var LifecycleEnvVars = []string{"BUILD_NUMBER", "BUILD_HASH", "BUILD_DATE"}
 
+// The log formats
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+	FormatText   = "text"
+)
+
+// The components of the log, which are the types of the log entries.
+// Their levels can be set independently by Options.ComponentLevels.
+var Components = []string{"access", "call", "application", "lifecycle", "cacheinfo"}
+
+// componentLoggers contains the loggers of the components with an own level
+var componentLoggers = map[string]*logrus.Logger{}
+
+// Options of the logger
+type Options struct {
+	// Level is the log level of all components without an own level
+	Level string
+
+	// Format is one of json (logstash compatible), logfmt or text
+	Format string
+
+	// ComponentLevels is a comma separated list of component=level, e.g. access=warn,application=debug
+	ComponentLevels string
+
+	// Redact replaces usernames and email addresses in the log entries
+	Redact bool
+}
+
 func init() {
 	Set("info", false)
 }
 
 // Set creates a new Logger with the matching specification
 func Set(level string, textLogging bool) error {
-	l, err := logrus.ParseLevel(level)
+	format := FormatJSON
+	if textLogging {
+		format = FormatText
+	}
+	return Configure(Options{Level: level, Format: format})
+}
+
+// Configure creates a new Logger with the options
+func Configure(options Options) error {
+	l, err := logrus.ParseLevel(options.Level)
 	if err != nil {
 		return err
 	}
 
-	logger := logrus.New()
-	if textLogging {
-		logger.Formatter = &logrus.TextFormatter{}
-	} else {
-		logger.Formatter = &LogstashFormatter{TimestampFormat: time.RFC3339Nano}
+	var formatter logrus.Formatter
+	switch options.Format {
+	case FormatJSON, "":
+		formatter = &LogstashFormatter{TimestampFormat: time.RFC3339Nano}
+	case FormatLogfmt:
+		formatter = &logrus.TextFormatter{DisableColors: true, TimestampFormat: time.RFC3339Nano, QuoteEmptyFields: true}
+	case FormatText:
+		formatter = &logrus.TextFormatter{}
+	default:
+		return fmt.Errorf("unknown log format %q, expected %v, %v or %v", options.Format, FormatJSON, FormatLogfmt, FormatText)
+	}
+
+	hooks := logrus.LevelHooks{}
+	if options.Redact {
+		hooks.Add(&redactHook{})
 	}
-	logger.Level = l
-	Logger = logger
+
+	newLogger := func(level logrus.Level) *logrus.Logger {
+		logger := logrus.New()
+		logger.Formatter = formatter
+		logger.Hooks = hooks
+		logger.Level = level
+		return logger
+	}
+
+	loggers := map[string]*logrus.Logger{}
+	if options.ComponentLevels != "" {
+		for _, componentLevel := range strings.Split(options.ComponentLevels, ",") {
+			kv := strings.SplitN(strings.TrimSpace(componentLevel), "=", 2)
+			if len(kv) != 2 || !contains(Components, kv[0]) {
+				return fmt.Errorf("invalid component level %q, expected component=level with a component of %v", componentLevel, strings.Join(Components, ", "))
+			}
+			cl, err := logrus.ParseLevel(kv[1])
+			if err != nil {
+				return err
+			}
+			loggers[kv[0]] = newLogger(cl)
+		}
+	}
+
+	Logger = newLogger(l)
+	componentLoggers = loggers
 	return nil
 }
 
+// Component returns the logger of the component.
+// This is the Logger, if the component does not have an own level.
+func Component(name string) *logrus.Logger {
+	if logger, exist := componentLoggers[name]; exist {
+		return logger
+	}
+	return Logger
+}
+
 // Access logs an access entry with call duration and status code
 func Access(r *http.Request, start time.Time, statusCode int) {
 	e := access(r, start, statusCode, nil)
@@ -102,7 +183,7 @@ func access(r *http.Request, start time.Time, statusCode int, err error) *logrus
 		fields["cookies"] = cookies
 	}
 
-	return Logger.WithFields(fields)
+	return Component("access").WithFields(fields)
 }
 
 // Call logs the result of an outgoing call
@@ -125,14 +206,14 @@ func Call(r *http.Request, resp *http.Response, start time.Time, err error) {
 
 	if err != nil {
 		fields[logrus.ErrorKey] = err.Error()
-		Logger.WithFields(fields).Error(err)
+		Component("call").WithFields(fields).Error(err)
 		return
 	}
 
 	if resp != nil {
 		fields["response_status"] = resp.StatusCode
 		fields["content_type"] = resp.Header.Get("Content-Type")
-		e := Logger.WithFields(fields)
+		e := Component("call").WithFields(fields)
 		msg := fmt.Sprintf("%v %v-> %v", resp.StatusCode, r.Method, r.URL.String())
 
 		if resp.StatusCode >= 200 && resp.StatusCode <= 399 {
@@ -145,7 +226,7 @@ func Call(r *http.Request, resp *http.Response, start time.Time, err error) {
 		return
 	}
 
-	Logger.WithFields(fields).Warn("call, but no response given")
+	Component("call").WithFields(fields).Warn("call, but no response given")
 }
 
 // Cacheinfo logs the hit information a accessing a ressource
@@ -156,7 +237,7 @@ func Cacheinfo(url string, hit bool) {
 	} else {
 		msg = fmt.Sprintf("cache miss: %v", url)
 	}
-	Logger.WithFields(
+	Component("cacheinfo").WithFields(
 		logrus.Fields{
 			"type": "cacheinfo",
 			"url":  url,
@@ -172,7 +253,7 @@ func Application(h http.Header) *logrus.Entry {
 		"type": "application",
 	}
 	setCorrelationIds(fields, h)
-	return Logger.WithFields(fields)
+	return Component("application").WithFields(fields)
 }
 
 // LifecycleStart logs the start of an application
@@ -195,7 +276,7 @@ func LifecycleStart(appName string, args interface{}) {
 		}
 	}
 
-	Logger.WithFields(fields).Infof("starting application: %v", appName)
+	Component("lifecycle").WithFields(fields).Infof("starting application: %v", appName)
 }
 
 // LifecycleStop logs the stop of an application
@@ -213,11 +294,11 @@ func LifecycleStop(appName string, signal os.Signal, err error) {
 	}
 
 	if err != nil {
-		Logger.WithFields(fields).
+		Component("lifecycle").WithFields(fields).
 			WithError(err).
 			Errorf("stopping application: %v (%v)", appName, err)
 	} else {
-		Logger.WithFields(fields).Infof("stopping application: %v (%v)", appName, signal)
+		Component("lifecycle").WithFields(fields).Infof("stopping application: %v (%v)", appName, signal)
 	}
 }
 
@@ -232,7 +313,7 @@ func ServerClosed(appName string) {
 		fields["build_number"] = os.Getenv("BUILD_NUMBER")
 	}
 
-	Logger.WithFields(fields).Infof("http server was closed: %v", appName)
+	Component("application").WithFields(fields).Infof("http server was closed: %v", appName)
 }
 
 func getRemoteIp(r *http.Request) string {
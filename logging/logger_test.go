@@ -56,6 +56,52 @@ func Test_Logger_Set_Invalid(t *testing.T) {
 	a.Equal(err.Error(), "not a valid logrus Level: \"foo\"")
 }
 
+func Test_Logger_Configure_Formats(t *testing.T) {
+	a := assert.New(t)
+	defer Set("info", false)
+
+	a.NoError(Configure(Options{Level: "info", Format: FormatLogfmt}))
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+	Logger.WithField("foo", "bar baz").WithField("empty", "").Info("hello")
+	a.Regexp(`^time=".*" level=info msg=hello empty="" foo="bar baz"`, b.String())
+
+	a.NoError(Configure(Options{Level: "info", Format: FormatJSON}))
+	b = bytes.NewBuffer(nil)
+	Logger.Out = b
+	Logger.WithField("foo", "bar").Info("hello")
+	a.Equal("bar", mapFromBuffer(b)["foo"])
+
+	a.EqualError(Configure(Options{Level: "info", Format: "xml"}), `unknown log format "xml", expected json, logfmt or text`)
+}
+
+func Test_Logger_Configure_ComponentLevels(t *testing.T) {
+	a := assert.New(t)
+	defer Set("info", false)
+
+	a.NoError(Configure(Options{Level: "warn", ComponentLevels: "application=debug, access=error"}))
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+	Component("application").Out = b
+	Component("access").Out = b
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	Application(r.Header).Debug("debug of the application")
+	Access(r, time.Now(), 404)
+	Logger.Info("ignored info")
+	Call(r, &http.Response{StatusCode: 500}, time.Now(), nil)
+
+	a.Contains(b.String(), "debug of the application")
+	a.NotContains(b.String(), `"response_status":404`)
+	a.NotContains(b.String(), "ignored info")
+	a.Contains(b.String(), `"response_status":500`)
+	a.Equal(Logger, Component("call"))
+
+	a.Error(Configure(Options{Level: "info", ComponentLevels: "application"}))
+	a.Error(Configure(Options{Level: "info", ComponentLevels: "unknown=debug"}))
+	a.Error(Configure(Options{Level: "info", ComponentLevels: "access=foo"}))
+}
+
 func Test_Logger_Call(t *testing.T) {
 	a := assert.New(t)
 
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/tarent/logrus"
+)
+
+const redacted = "[redacted]"
+
+// RedactedFields are the fields, which contain usernames or email addresses
+var RedactedFields = []string{"username", "user", "email", "sub"}
+
+var emailPattern = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+
+// redactHook removes the usernames and email addresses from the entries,
+// for environments where they must not be stored in the log.
+type redactHook struct{}
+
+func (hook *redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire replaces the data of the entry, which is a copy for this log call,
+// so entries which are logged more than once are not modified.
+func (hook *redactHook) Fire(entry *logrus.Entry) error {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = redactValue(k, v)
+	}
+	entry.Data = data
+	entry.Message = redactString(entry.Message)
+	return nil
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if contains(RedactedFields, key) {
+		return redacted
+	}
+	switch v := value.(type) {
+	case string:
+		return redactString(v)
+	case error:
+		if s := v.Error(); s != redactString(s) {
+			return redactString(s)
+		}
+	}
+	return value
+}
+
+// redactString replaces email addresses and the names of the user keys of the brute-force protection
+func redactString(s string) string {
+	if strings.HasPrefix(s, "user:") {
+		return "user:" + redacted
+	}
+	return emailPattern.ReplaceAllString(s, redacted)
+}
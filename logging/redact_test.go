@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Redact(t *testing.T) {
+	a := assert.New(t)
+	defer Set("info", false)
+
+	a.NoError(Configure(Options{Level: "info", Format: FormatJSON, Redact: true}))
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+
+	entry := Logger.WithField("username", "bob").
+		WithField("key", "user:bob").
+		WithField("url", "/login?email=bob@example.com").
+		WithField("origin", "htpasswd").
+		WithError(errors.New("can not send mail to bob@example.com"))
+	entry.Info("requested login link for bob@example.com")
+
+	data := mapFromBuffer(b)
+	a.Equal("[redacted]", data["username"])
+	a.Equal("user:[redacted]", data["key"])
+	a.Equal("/login?email=[redacted]", data["url"])
+	a.Equal("htpasswd", data["origin"])
+	a.Equal("can not send mail to [redacted]", data["error"])
+	a.Equal("requested login link for [redacted]", data["message"])
+
+	// the entry itself is not modified
+	a.Equal("bob", entry.Data["username"])
+}
+
+func Test_Redact_Disabled(t *testing.T) {
+	a := assert.New(t)
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+
+	Logger.WithField("username", "bob").Info("hello bob@example.com")
+
+	data := mapFromBuffer(b)
+	a.Equal("bob", data["username"])
+	a.Equal("hello bob@example.com", data["message"])
+}
@@ -0,0 +1,107 @@
+package login
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/logging"
+)
+
+const adminPath = "/admin"
+
+type adminLockoutsResponse struct {
+	Lockouts []lockout `json:"lockouts"`
+}
+
+type adminDeleteSessionsResponse struct {
+	Sessions      int `json:"sessions"`
+	RefreshTokens int `json:"refresh_tokens"`
+}
+
+// handleAdmin serves the admin API, which requires the admin token as bearer token:
+//
+//	GET    /admin/lockouts                   lists the locked client ips and usernames
+//	DELETE /admin/lockouts/{key}             unlocks a key, e.g. user:bob or ip:10.0.0.1
+//	DELETE /admin/users/{username}/sessions  deletes the sessions and refresh tokens of the user
+func (h *Handler) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	if h.config.AdminToken == "" {
+		h.respondNotFound(w, r)
+		return
+	}
+	if !h.validAdminToken(r) {
+		logging.Application(r.Header).WithField("client_ip", clientIP(r)).Warn("invalid admin token")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="loginsrv admin"`)
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(401)
+		w.Write([]byte("Unauthorized"))
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, h.config.LoginPath+adminPath)
+	switch {
+	case path == "/lockouts" && r.Method == "GET":
+		h.respondAdminJSON(w, adminLockoutsResponse{Lockouts: h.failureLimiter.Lockouts()})
+
+	case strings.HasPrefix(path, "/lockouts/") && r.Method == "DELETE":
+		key := strings.TrimPrefix(path, "/lockouts/")
+		h.failureLimiter.Reset(key)
+		h.captcha.reset(key)
+		logging.Application(r.Header).WithField("key", key).Info("unlocked by admin")
+		w.WriteHeader(204)
+
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/sessions") && r.Method == "DELETE":
+		username := strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/sessions")
+		if username == "" {
+			h.respondBadRequest(w, r)
+			return
+		}
+		response, err := h.deleteUserSessions(username)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		logging.Application(r.Header).WithField("username", username).Info("sessions deleted by admin")
+		h.respondAdminJSON(w, response)
+
+	default:
+		h.respondNotFound(w, r)
+	}
+}
+
+// validAdminToken compares the bearer token of the request in constant time.
+func (h *Handler) validAdminToken(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.config.AdminToken)) == 1
+}
+
+// deleteUserSessions removes the sessions and the refresh tokens of the user, so that the user has to login again.
+// Stateless tokens stay valid until their expiry.
+func (h *Handler) deleteUserSessions(username string) (adminDeleteSessionsResponse, error) {
+	response := adminDeleteSessionsResponse{}
+	var err error
+	if h.sessions != nil {
+		if response.Sessions, err = h.sessions.DeleteUser(username); err != nil {
+			return response, err
+		}
+	}
+	if h.refreshTokens != nil {
+		if response.RefreshTokens, err = h.refreshTokens.DeleteUser(username); err != nil {
+			return response, err
+		}
+	}
+	return response, nil
+}
+
+func (h *Handler) respondAdminJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(response) // ignore error of encoding
+}
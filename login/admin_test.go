@@ -0,0 +1,120 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+const adminAuth = "Authorization: Bearer admin-secret"
+
+func testAdminHandler() *Handler {
+	h := testHandler()
+	h.config.AdminToken = "admin-secret"
+	h.failureLimiter = newFailureLimiter(2, time.Minute)
+	return h
+}
+
+func TestHandler_Admin_Auth(t *testing.T) {
+	recorder := call(req("GET", "/context/login/admin/lockouts", "", adminAuth))
+	Equal(t, 404, recorder.Code)
+
+	h := testAdminHandler()
+	for _, header := range []string{"Authorization: Bearer wrong", "Authorization: Basic YWRtaW46c2VjcmV0", "X-Foo: bar"} {
+		recorder = httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", "/context/login/admin/lockouts", "", header))
+		Equal(t, 401, recorder.Code, header)
+		Equal(t, `Bearer realm="loginsrv admin"`, recorder.Header().Get("WWW-Authenticate"))
+	}
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/admin/unknown", "", adminAuth))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestHandler_Admin_Lockouts(t *testing.T) {
+	h := testAdminHandler()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/admin/lockouts", "", adminAuth))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	JSONEq(t, `{"lockouts": []}`, recorder.Body.String())
+
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), reqFrom("10.0.0.1", "username=bob&password=wrong"))
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, reqFrom("10.0.0.2", "username=bob&password=secret"))
+	Equal(t, 429, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/admin/lockouts", "", adminAuth))
+	Equal(t, 200, recorder.Code)
+	response := adminLockoutsResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, 2, len(response.Lockouts))
+	Equal(t, "ip:10.0.0.1", response.Lockouts[0].Key)
+	Equal(t, "user:bob", response.Lockouts[1].Key)
+	Equal(t, 1, response.Lockouts[1].Lockouts)
+	True(t, response.Lockouts[1].LockedUntil.After(time.Now()))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login/admin/lockouts/user:bob", "", adminAuth))
+	Equal(t, 204, recorder.Code)
+
+	// bob can login again from other ips
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, reqFrom("10.0.0.2", "username=bob&password=secret"))
+	Equal(t, 200, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/admin/lockouts", "", adminAuth))
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, 1, len(response.Lockouts))
+	Equal(t, "ip:10.0.0.1", response.Lockouts[0].Key)
+}
+
+func reqFrom(ip, body string) *http.Request {
+	r := req("POST", "/context/login", body, TypeForm)
+	r.RemoteAddr = ip + ":1234"
+	return r
+}
+
+func TestHandler_Admin_DeleteSessions(t *testing.T) {
+	h := testAdminHandler()
+	h.sessions = newMemorySessionStore()
+	h.refreshTokens = newMemoryRefreshTokenStore()
+	bob := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()}
+	NoError(t, h.sessions.Save(Session{ID: "a", UserInfo: bob, Expiry: time.Now().Add(time.Hour)}))
+	NoError(t, h.sessions.Save(Session{ID: "b", UserInfo: model.UserInfo{Sub: "alice", Expiry: time.Now().Add(time.Hour).Unix()}, Expiry: time.Now().Add(time.Hour)}))
+	NoError(t, h.refreshTokens.Save("c", bob, time.Now().Add(time.Hour)))
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login/admin/users/bob/sessions", "", adminAuth))
+	Equal(t, 200, recorder.Code)
+	JSONEq(t, `{"sessions": 1, "refresh_tokens": 1}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Cookie: jwt_token=a", "Accept: application/json"))
+	Equal(t, 403, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Cookie: jwt_token=b", "Accept: application/json"))
+	Equal(t, 200, recorder.Code)
+
+	// without stores, nothing is deleted
+	h = testAdminHandler()
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login/admin/users/bob/sessions", "", adminAuth))
+	Equal(t, 200, recorder.Code)
+	JSONEq(t, `{"sessions": 0, "refresh_tokens": 0}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login/admin/users//sessions", "", adminAuth))
+	Equal(t, 400, recorder.Code)
+}
@@ -0,0 +1,31 @@
+package login
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+)
+
+// auditEvent writes an event to the audit sink and calls the hooks of the config.
+// Errors of the sink are logged, but don't fail the request.
+func (h *Handler) auditEvent(r *http.Request, eventType audit.EventType, username, origin, reason string) {
+	h.config.Hooks.call(r, eventType, username, origin, reason)
+	if h.audit == nil {
+		return
+	}
+	event := audit.Event{
+		Time:          time.Now().UTC(),
+		Type:          eventType,
+		Username:      username,
+		Origin:        origin,
+		ClientIP:      clientIP(r),
+		UserAgent:     r.UserAgent(),
+		CorrelationID: logging.GetCorrelationId(r.Header),
+		Reason:        reason,
+	}
+	if err := h.audit.Write(event); err != nil {
+		logging.Application(r.Header).WithError(err).Error("can not write audit event")
+	}
+}
@@ -0,0 +1,122 @@
+package login
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+type testAuditSink struct {
+	mutex  sync.Mutex
+	events []audit.Event
+	err    error
+	closed bool
+}
+
+func (s *testAuditSink) Write(event audit.Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+	return s.err
+}
+
+func (s *testAuditSink) Close() error {
+	s.closed = true
+	return s.err
+}
+
+func (s *testAuditSink) last() audit.Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.events[len(s.events)-1]
+}
+
+func testAuditHandler() (*Handler, *testAuditSink) {
+	h := testHandler()
+	sink := &testAuditSink{}
+	h.audit = sink
+	return h, sink
+}
+
+func TestHandler_Audit_Login(t *testing.T) {
+	h, sink := testAuditHandler()
+
+	r := reqFrom("10.0.0.1", "username=bob&password=secret")
+	r.Header.Set("User-Agent", "test-agent")
+	r.Header.Set("X-Correlation-Id", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	Equal(t, 1, len(sink.events))
+	event := sink.last()
+	Equal(t, audit.LoginSuccess, event.Type)
+	Equal(t, "bob", event.Username)
+	Equal(t, "simple", event.Origin)
+	Equal(t, "10.0.0.1", event.ClientIP)
+	Equal(t, "test-agent", event.UserAgent)
+	Equal(t, "abc", event.CorrelationID)
+	InDelta(t, time.Now().Unix(), event.Time.Unix(), 2)
+
+	h.ServeHTTP(httptest.NewRecorder(), reqFrom("10.0.0.1", "username=bob&password=wrong"))
+	Equal(t, 2, len(sink.events))
+	event = sink.last()
+	Equal(t, audit.LoginFailure, event.Type)
+	Equal(t, "bob", event.Username)
+	Equal(t, "invalid credentials", event.Reason)
+}
+
+func TestHandler_Audit_LogoutAndRefresh(t *testing.T) {
+	h, sink := testAuditHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	cookie := "Cookie: " + h.config.CookieName + "=" + token
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, cookie))
+	Equal(t, 303, recorder.Code)
+	Equal(t, audit.TokenRefresh, sink.last().Type)
+	Equal(t, "bob", sink.last().Username)
+
+	h.ServeHTTP(httptest.NewRecorder(), req("DELETE", "/context/login", "", cookie))
+	Equal(t, audit.Logout, sink.last().Type)
+	Equal(t, "bob", sink.last().Username)
+	Equal(t, "simple", sink.last().Origin)
+
+	// no event for a logout without a session
+	count := len(sink.events)
+	h.ServeHTTP(httptest.NewRecorder(), req("DELETE", "/context/login", ""))
+	Equal(t, count, len(sink.events))
+}
+
+func TestHandler_Audit_SinkErrorDoesNotFailTheLogin(t *testing.T) {
+	h, sink := testAuditHandler()
+	sink.err = errors.New("disk full")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Equal(t, 1, len(sink.events))
+}
+
+func TestHandler_Audit_Disabled(t *testing.T) {
+	h := testHandler()
+	Nil(t, h.audit)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+}
+
+func TestHandler_Close(t *testing.T) {
+	h, sink := testAuditHandler()
+	NoError(t, h.Close())
+	True(t, sink.closed)
+
+	sink.err = errors.New("disk full")
+	EqualError(t, h.Close(), "disk full")
+
+	NoError(t, testHandler().Close())
+}
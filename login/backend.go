@@ -1,6 +1,10 @@
 package login
 
 import (
+	"fmt"
+	"io"
+
+	"github.com/afdecastro879/loginsrv/metrics"
 	"github.com/afdecastro879/loginsrv/model"
 )
 
@@ -12,3 +16,39 @@ type Backend interface {
 	// The error parameter is nil, unless a communication error with the backend occurred.
 	Authenticate(username, password string) (bool, model.UserInfo, error)
 }
+
+// closeBackends closes the backends, which hold connections, e.g. the connection pool of a database.
+func closeBackends(backends []Backend) error {
+	var firstErr error
+	for _, b := range backends {
+		if ib, ok := b.(instrumentedBackend); ok {
+			b = ib.Backend
+		}
+		if closer, ok := b.(io.Closer); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// instrumentedBackend records the login attempts of a backend in the metrics.
+type instrumentedBackend struct {
+	Backend
+	name string
+}
+
+// backendName returns the provider name of an instrumented backend or the type otherwise
+func backendName(b Backend) string {
+	if ib, ok := b.(instrumentedBackend); ok {
+		return ib.name
+	}
+	return fmt.Sprintf("%T", b)
+}
+
+func (b instrumentedBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	authenticated, userInfo, err := b.Backend.Authenticate(username, password)
+	metrics.Login(b.name, authenticated, err)
+	return authenticated, userInfo, err
+}
@@ -0,0 +1,296 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/pkg/errors"
+)
+
+// CaptchaHCaptcha is the value of the captcha option for hCaptcha
+const CaptchaHCaptcha = "hcaptcha"
+
+// CaptchaReCaptcha is the value of the captcha option for Google reCAPTCHA v2
+const CaptchaReCaptcha = "recaptcha"
+
+const captchaVerifyTimeout = 5 * time.Second
+
+// captchaVendor describes the widget and the server side verification of a captcha service
+type captchaVendor struct {
+	verifyURL string
+	scriptURL string
+	// widgetClass is the css class of the div, which is replaced by the widget
+	widgetClass string
+	// responseField is the form field, in which the widget posts the captcha response
+	responseField string
+}
+
+var captchaVendors = map[string]captchaVendor{
+	CaptchaHCaptcha: {
+		verifyURL:     "https://api.hcaptcha.com/siteverify",
+		scriptURL:     "https://js.hcaptcha.com/1/api.js",
+		widgetClass:   "h-captcha",
+		responseField: "h-captcha-response",
+	},
+	CaptchaReCaptcha: {
+		verifyURL:     "https://www.google.com/recaptcha/api/siteverify",
+		scriptURL:     "https://www.google.com/recaptcha/api.js",
+		widgetClass:   "g-recaptcha",
+		responseField: "g-recaptcha-response",
+	},
+}
+
+// captchaVerifier requires a solved captcha for the login of a client ip or username after too many failed logins.
+// The failures are counted in memory, so they are not shared between multiple instances.
+type captchaVerifier struct {
+	vendor        captchaVendor
+	siteKey       string
+	secret        string
+	afterFailures int
+	window        time.Duration
+	httpClient    http.Client
+
+	mu        sync.Mutex
+	failures  map[string]*captchaFailures
+	lastSweep time.Time
+}
+
+type captchaFailures struct {
+	count       int
+	lastFailure time.Time
+}
+
+// captchaFormData holds the data of the captcha widget in the login form
+type captchaFormData struct {
+	ScriptURL   string
+	WidgetClass string
+	SiteKey     string
+	// Failure is set, if the captcha was missing or not solved
+	Failure bool
+}
+
+// captchaVerifyResponse is the response of the siteverify endpoints of hCaptcha and reCAPTCHA
+type captchaVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func newCaptchaVerifier(config *Config) (*captchaVerifier, error) {
+	if config.Captcha == "" {
+		return nil, nil
+	}
+	vendor, exist := captchaVendors[config.Captcha]
+	if !exist {
+		return nil, fmt.Errorf("unsupported captcha %q, expected %q or %q", config.Captcha, CaptchaHCaptcha, CaptchaReCaptcha)
+	}
+	if config.CaptchaSiteKey == "" || config.CaptchaSecret == "" {
+		return nil, errors.New("captcha requires captcha-site-key and captcha-secret")
+	}
+	return &captchaVerifier{
+		vendor:        vendor,
+		siteKey:       config.CaptchaSiteKey,
+		secret:        config.CaptchaSecret,
+		afterFailures: config.CaptchaAfterFailures,
+		window:        config.FailureWindow,
+		httpClient:    http.Client{Timeout: captchaVerifyTimeout},
+		failures:      map[string]*captchaFailures{},
+	}, nil
+}
+
+// required returns true, if one of the keys had too many failed logins within the window.
+func (c *captchaVerifier) required(keys ...string) bool {
+	if c == nil {
+		return false
+	}
+	if c.afterFailures <= 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		if f, exist := c.failures[key]; exist && f.count >= c.afterFailures && now.Sub(f.lastFailure) < c.window {
+			return true
+		}
+	}
+	return false
+}
+
+// fail counts a failed login for the keys.
+func (c *captchaVerifier) fail(keys ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	for _, key := range keys {
+		f, exist := c.failures[key]
+		if !exist || now.Sub(f.lastFailure) >= c.window {
+			f = &captchaFailures{}
+			c.failures[key] = f
+		}
+		f.count++
+		f.lastFailure = now
+	}
+}
+
+// reset forgets the failures of the keys, e.g. after a successful login.
+func (c *captchaVerifier) reset(keys ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.failures, key)
+	}
+}
+
+// sweep removes the expired failures once per window, so that the map does not grow unbounded.
+func (c *captchaVerifier) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.window {
+		return
+	}
+	c.lastSweep = now
+	for key, f := range c.failures {
+		if now.Sub(f.lastFailure) >= c.window {
+			delete(c.failures, key)
+		}
+	}
+}
+
+// verify checks the captcha response of the widget at the captcha service.
+func (c *captchaVerifier) verify(response, remoteIP string) (bool, error) {
+	if response == "" {
+		return false, nil
+	}
+
+	form := url.Values{
+		"secret":   {c.secret},
+		"response": {response},
+		"sitekey":  {c.siteKey},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	resp, err := c.httpClient.PostForm(c.vendor.verifyURL, form)
+	if err != nil {
+		return false, errors.Wrap(err, "error on captcha verification")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("captcha verification returned status %v", resp.StatusCode)
+	}
+
+	result := captchaVerifyResponse{}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return false, errors.Wrap(err, "error parsing the captcha verification")
+	}
+	return result.Success, nil
+}
+
+// formData returns the captcha widget for the login form or nil, if no captcha is required.
+func (c *captchaVerifier) formData(r *http.Request, username string) *captchaFormData {
+	keys := failureKeys(r, username)
+	if username == "" {
+		keys = keys[:1]
+	}
+	if !c.required(keys...) {
+		return nil
+	}
+	return &captchaFormData{
+		ScriptURL:   c.vendor.scriptURL,
+		WidgetClass: c.vendor.widgetClass,
+		SiteKey:     c.siteKey,
+	}
+}
+
+// getCaptchaResponse returns the captcha response of the form or the JSON body of the login.
+func getCaptchaResponse(r *http.Request, field string) string {
+	if r.Header.Get("Content-Type") == contentTypeJSON {
+		m := map[string]string{}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return ""
+		}
+		restoreBody(r, body)
+		json.Unmarshal(body, &m) // a body, which is no JSON, has no captcha response
+		return m[field]
+	}
+	return r.PostForm.Get(field)
+}
+
+// checkCaptcha verifies the captcha, if one is required for the login.
+// It returns false, if the captcha is missing or invalid and the response was written.
+func (h *Handler) checkCaptcha(w http.ResponseWriter, r *http.Request, username string, keys []string) bool {
+	if !h.captcha.required(keys...) {
+		return true
+	}
+
+	solved, err := h.captcha.verify(getCaptchaResponse(r, h.captcha.vendor.responseField), clientIP(r))
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return false
+	}
+	if solved {
+		return true
+	}
+
+	logging.Application(r.Header).
+		WithField("username", username).
+		WithField("client_ip", clientIP(r)).Info("login rejected without solved captcha")
+	h.respondCaptchaRequired(w, r, username)
+	return false
+}
+
+func (h *Handler) respondCaptchaRequired(w http.ResponseWriter, r *http.Request, username string) {
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(403)
+		captcha := h.captcha.formData(r, username)
+		if captcha != nil {
+			captcha.Failure = true
+		}
+		writeLoginForm(w,
+			loginFormData{
+				Lang:      language(r),
+				CSRFToken: csrfFormToken(r),
+				Config:    h.config,
+				UserInfo:  model.UserInfo{Sub: username},
+				Captcha:   captcha,
+			})
+		return
+	}
+
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(403)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":          errorCaptchaRequired,
+			"captcha":        h.config.Captcha,
+			"site_key":       h.captcha.siteKey,
+			"response_field": h.captcha.vendor.responseField,
+		}) // ignore error of encoding
+	} else {
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(403)
+		fmt.Fprint(w, translate(language(r), "Captcha required"))
+	}
+}
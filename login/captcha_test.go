@@ -0,0 +1,148 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func testCaptchaHandler(t *testing.T, verifyServer *httptest.Server) *Handler {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.Captcha = CaptchaHCaptcha
+	cfg.CaptchaSiteKey = "the-site-key"
+	cfg.CaptchaSecret = "the-secret"
+	cfg.CaptchaAfterFailures = 2
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	h.captcha.vendor.verifyURL = verifyServer.URL
+	return h
+}
+
+func TestHandler_Captcha(t *testing.T) {
+	verifications := []url.Values{}
+	verifyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verifications = append(verifications, r.PostForm)
+		json.NewEncoder(w).Encode(captchaVerifyResponse{Success: r.PostForm.Get("response") == "solved"})
+	}))
+	defer verifyServer.Close()
+	h := testCaptchaHandler(t, verifyServer)
+
+	login := func(body string, header ...string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, withCSRF(h, req("POST", "/login", body, append([]string{TypeForm}, header...)...)))
+		return recorder
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login", "", AcceptHTML))
+	NotContains(t, recorder.Body.String(), "h-captcha")
+
+	recorder = login("username=bob&password=wrong", AcceptHTML)
+	Equal(t, 403, recorder.Code)
+	NotContains(t, recorder.Body.String(), "h-captcha")
+
+	// the form shows the widget after the second failure
+	recorder = login("username=bob&password=wrong", AcceptHTML)
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), `<div class="h-captcha" data-sitekey="the-site-key"></div>`)
+	Contains(t, recorder.Body.String(), `<script src="https://js.hcaptcha.com/1/api.js" async defer></script>`)
+
+	// the correct password is rejected without the captcha
+	recorder = login("username=bob&password=secret", AcceptHTML)
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "Please confirm, that you are not a robot")
+	Equal(t, 0, len(verifications))
+
+	recorder = login("username=bob&password=secret&h-captcha-response=wrong", AcceptHTML)
+	Equal(t, 403, recorder.Code)
+	Equal(t, 1, len(verifications))
+	Equal(t, "the-secret", verifications[0].Get("secret"))
+	Equal(t, "the-site-key", verifications[0].Get("sitekey"))
+	Equal(t, "wrong", verifications[0].Get("response"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 403, recorder.Code)
+	JSONEq(t, `{"error": "captcha_required", "captcha": "hcaptcha", "site_key": "the-site-key", "response_field": "h-captcha-response"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", `{"username": "bob", "password": "secret", "h-captcha-response": "solved"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	recorder = login("username=bob&password=secret&h-captcha-response=solved", AcceptHTML)
+	Equal(t, 303, recorder.Code)
+
+	// the failures of the client ip are not reset by the successful login
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), "h-captcha")
+}
+
+func TestHandler_Captcha_VerificationError(t *testing.T) {
+	verifyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer verifyServer.Close()
+	h := testCaptchaHandler(t, verifyServer)
+	h.captcha.afterFailures = 0
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/login", "username=bob&password=secret&h-captcha-response=solved", TypeForm, AcceptHTML)))
+	Equal(t, 500, recorder.Code)
+}
+
+func TestCaptchaVerifier(t *testing.T) {
+	c := &captchaVerifier{afterFailures: 2, window: 50 * time.Millisecond, failures: map[string]*captchaFailures{}}
+
+	c.fail("a", "b")
+	False(t, c.required("a"))
+	c.fail("a")
+	True(t, c.required("a"))
+	True(t, c.required("c", "a"))
+	False(t, c.required("b"))
+
+	c.reset("a")
+	False(t, c.required("a"))
+
+	c.fail("a")
+	c.fail("a")
+	time.Sleep(60 * time.Millisecond)
+	False(t, c.required("a"))
+
+	// the expired failures are removed
+	c.fail("c")
+	Equal(t, 1, len(c.failures))
+
+	var disabled *captchaVerifier
+	False(t, disabled.required("a"))
+	disabled.fail("a")
+	disabled.reset("a")
+}
+
+func Test_newCaptchaVerifier(t *testing.T) {
+	cfg := DefaultConfig()
+	c, err := newCaptchaVerifier(cfg)
+	NoError(t, err)
+	Nil(t, c)
+
+	cfg.Captcha = "foo"
+	_, err = newCaptchaVerifier(cfg)
+	Error(t, err)
+
+	cfg.Captcha = CaptchaReCaptcha
+	_, err = newCaptchaVerifier(cfg)
+	Error(t, err)
+
+	cfg.CaptchaSiteKey = "key"
+	cfg.CaptchaSecret = "secret"
+	c, err = newCaptchaVerifier(cfg)
+	NoError(t, err)
+	Equal(t, "g-recaptcha-response", c.vendor.responseField)
+}
@@ -0,0 +1,174 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/afdecastro879/loginsrv/model"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// reservedClaims are managed by loginsrv and can not be set by the claims mapping or as static claims
+var reservedClaims = map[string]bool{
+	"sub":  true,
+	"exp":  true,
+	"refs": true,
+	"jti":  true,
+	"iss":  true,
+	"aud":  true,
+	"nbf":  true,
+	// the applications trust the auth time for the step-up authentication
+	"auth_time": true,
+}
+
+// claimsMappingFuncs accept missing values of the user or raw user info as empty values
+var claimsMappingFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": func(sep string, v interface{}) string {
+		list, ok := v.([]interface{})
+		if !ok {
+			return mappingString(v)
+		}
+		values := make([]string, 0, len(list))
+		for _, e := range list {
+			values = append(values, mappingString(e))
+		}
+		return strings.Join(values, sep)
+	},
+	"split": func(sep string, v interface{}) []string {
+		if s := mappingString(v); s != "" {
+			return strings.Split(s, sep)
+		}
+		return nil
+	},
+	"lower": func(v interface{}) string {
+		return strings.ToLower(mappingString(v))
+	},
+	"upper": func(v interface{}) string {
+		return strings.ToUpper(mappingString(v))
+	},
+}
+
+func mappingString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// claimMapping is a claim of the token with the template for its value
+type claimMapping struct {
+	claim    string
+	template *template.Template
+}
+
+// claimsMapper sets the claims of the token from go templates.
+// The templates can access the claims of the user as .user and the raw user info of the oauth provider as .raw.
+type claimsMapper struct {
+	mappings []claimMapping
+}
+
+// newClaimsMapper reads the claims mapping file, a YAML map of claim names to templates.
+// It returns nil, if no file is configured.
+func newClaimsMapper(file string) (*claimsMapper, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read claims mapping file %v", file)
+	}
+
+	templates := map[string]string{}
+	if err := yaml.Unmarshal(b, &templates); err != nil {
+		return nil, errors.Wrapf(err, "can't parse claims mapping file %v", file)
+	}
+
+	// the claims are mapped in a stable order, so a failing template is reported the same way on every login
+	claims := make([]string, 0, len(templates))
+	for claim := range templates {
+		claims = append(claims, claim)
+	}
+	sort.Strings(claims)
+
+	m := &claimsMapper{}
+	for _, claim := range claims {
+		if reservedClaims[claim] {
+			return nil, errors.Errorf("claim %q can't be set by the claims mapping file %v", claim, file)
+		}
+		t, err := template.New(claim).Funcs(claimsMappingFuncs).Parse(templates[claim])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid template for claim %q in claims mapping file %v", claim, file)
+		}
+		m.mappings = append(m.mappings, claimMapping{claim: claim, template: t})
+	}
+	return m, nil
+}
+
+// wrap returns claims function, which adds the mapped claims to the claims of the user claims function
+func (m *claimsMapper) wrap(userClaims userClaimsFunc) userClaimsFunc {
+	return func(userInfo model.UserInfo) (jwt.Claims, error) {
+		claims, err := userClaims(userInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		custom, ok := claims.(customClaims)
+		if !ok {
+			custom = customClaims(userInfo.AsMap())
+		}
+		mapped, err := m.claims(userInfo, custom)
+		if err != nil {
+			return nil, err
+		}
+		custom.merge(mapped)
+		return custom, nil
+	}
+}
+
+// claims executes the templates. A template, which renders to an empty string or null, omits the claim.
+// Results, which are valid JSON, e.g. numbers, booleans or lists, are added with their JSON type, all others as string.
+func (m *claimsMapper) claims(userInfo model.UserInfo, userClaims customClaims) (map[string]interface{}, error) {
+	raw := userInfo.Raw
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	data := map[string]interface{}{
+		"user": map[string]interface{}(userClaims),
+		"raw":  raw,
+	}
+
+	mapped := map[string]interface{}{}
+	for _, mapping := range m.mappings {
+		buf := &bytes.Buffer{}
+		if err := mapping.template.Execute(buf, data); err != nil {
+			return nil, errors.Wrapf(err, "can't map claim %q", mapping.claim)
+		}
+		// missing values of the maps are printed as <no value> by text/template
+		value := strings.TrimSpace(strings.Replace(buf.String(), "<no value>", "", -1))
+		if value == "" {
+			continue
+		}
+
+		var jsonValue interface{}
+		if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
+			if jsonValue == nil {
+				continue
+			}
+			mapped[mapping.claim] = jsonValue
+		} else {
+			mapped[mapping.claim] = value
+		}
+	}
+	return mapped, nil
+}
@@ -0,0 +1,136 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+var claimsMappingExample = `
+team: '{{ .raw.team }}'
+tenant: '{{ .user.domain | upper }}'
+admin: '{{ if eq .user.origin "htpasswd" }}true{{ end }}'
+projects: '{{ json .raw.projects }}'
+level: '{{ .raw.level }}'
+display: '{{ .user.name }} ({{ .user.sub }})'
+missing: '{{ .raw.missing }}'
+`
+
+func Test_ClaimsMapper(t *testing.T) {
+	file, cleanup := createClaimsFile(claimsMappingExample)
+	defer cleanup()
+
+	m, err := newClaimsMapper(file)
+	NoError(t, err)
+
+	userClaims := func(userInfo model.UserInfo) (jwt.Claims, error) {
+		return userInfo, nil
+	}
+	claims, err := m.wrap(userClaims)(model.UserInfo{
+		Sub:    "bob",
+		Name:   "Bob",
+		Origin: "github",
+		Domain: "example.com",
+		Raw: map[string]interface{}{
+			"team":     "blue",
+			"projects": []interface{}{"a", "b"},
+			"level":    float64(3),
+		},
+	})
+	NoError(t, err)
+	Equal(t, customClaims{
+		"sub":      "bob",
+		"name":     "Bob",
+		"origin":   "github",
+		"domain":   "example.com",
+		"team":     "blue",
+		"tenant":   "EXAMPLE.COM",
+		"projects": []interface{}{"a", "b"},
+		"level":    float64(3),
+		"display":  "Bob (bob)",
+	}, claims)
+
+	// without raw user info, e.g. from a login backend
+	claims, err = m.wrap(userClaims)(model.UserInfo{Sub: "bob", Origin: "htpasswd"})
+	NoError(t, err)
+	Equal(t, customClaims{
+		"sub":     "bob",
+		"origin":  "htpasswd",
+		"admin":   true,
+		"display": "(bob)",
+	}, claims)
+}
+
+func Test_ClaimsMapper_InvalidFile(t *testing.T) {
+	m, err := newClaimsMapper("")
+	NoError(t, err)
+	Nil(t, m)
+
+	_, err = newClaimsMapper("notfound")
+	Error(t, err)
+
+	for _, content := range []string{
+		"- not a map",
+		"role: '{{ .raw.role'",
+		"exp: '{{ .raw.exp }}'",
+	} {
+		file, cleanup := createClaimsFile(content)
+		_, err = newClaimsMapper(file)
+		Error(t, err)
+		cleanup()
+	}
+}
+
+func TestHandler_ClaimsMapping(t *testing.T) {
+	file, cleanup := createClaimsFile(`role: '{{ if eq .user.sub "bob" }}admin{{ else }}user{{ end }}'`)
+	defer cleanup()
+
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.ClaimsMappingFile = file
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, "admin", claims["role"])
+
+	cfg.ClaimsMappingFile = "notfound"
+	_, err = NewHandler(cfg)
+	Error(t, err)
+}
+
+func Test_ClaimsMapper_Funcs(t *testing.T) {
+	file, cleanup := createClaimsFile(`
+roles: '{{ json (split "," .raw.roles) }}'
+teams: '{{ join " " .raw.teams }}'
+lower: '{{ lower .raw.name }}'
+`)
+	defer cleanup()
+
+	m, err := newClaimsMapper(file)
+	NoError(t, err)
+
+	claims, err := m.claims(model.UserInfo{Raw: map[string]interface{}{
+		"roles": "admin,user",
+		"teams": []interface{}{"blue", "red"},
+		"name":  "Bob",
+	}}, customClaims{})
+	NoError(t, err)
+	Equal(t, map[string]interface{}{
+		"roles": []interface{}{"admin", "user"},
+		"teams": "blue red",
+		"lower": "bob",
+	}, claims)
+
+	claims, err = m.claims(model.UserInfo{}, customClaims{})
+	NoError(t, err)
+	Equal(t, map[string]interface{}{}, claims)
+}
@@ -0,0 +1,46 @@
+package login
+
+import (
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/clientcert"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/metrics"
+)
+
+func newClientCert(config *Config) (*clientcert.Service, error) {
+	if len(config.X509) == 0 {
+		return nil, nil
+	}
+	cfg, err := clientcert.NewConfigFromOpts(config.X509)
+	if err != nil {
+		return nil, err
+	}
+	return clientcert.NewService(cfg)
+}
+
+// loginByCertificate logs in the users with a client certificate, when they GET the login page.
+// Requests without a valid certificate are answered as usual, so that the users can log in by the form.
+// It returns true, if the response was written.
+func (h *Handler) loginByCertificate(w http.ResponseWriter, r *http.Request) bool {
+	userInfo, present, err := h.clientCert.Authenticate(r)
+	if !present {
+		return false
+	}
+	if err != nil {
+		metrics.Login(clientcert.ProviderName, false, nil)
+		logging.Application(r.Header).WithError(err).Info("failed authentication")
+		h.auditEvent(r, audit.LoginFailure, "", clientcert.ProviderName, err.Error())
+		return false
+	}
+
+	if !h.beforeAuth(w, r, userInfo.Sub, clientcert.ProviderName) {
+		return true
+	}
+	metrics.Login(clientcert.ProviderName, true, nil)
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).Info("successfully authenticated")
+	h.completeLogin(w, r, userInfo, clientcert.ProviderName)
+	return true
+}
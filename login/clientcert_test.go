@@ -0,0 +1,108 @@
+package login
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// testClientCertHandler returns a handler with the x509 login and a client certificate for bob
+func testClientCertHandler(t *testing.T) (*Handler, *x509.Certificate) {
+	dir, err := ioutil.TempDir("", "loginsrv-x509")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Example CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &key.PublicKey, key)
+	NoError(t, err)
+	caFile := filepath.Join(dir, "ca.pem")
+	NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600))
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "bob"},
+		EmailAddresses: []string{"bob@example.com"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caTemplate, &key.PublicKey, key)
+	NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	NoError(t, err)
+
+	config := testConfig()
+	config.Backends = Options{}
+	config.X509 = map[string]string{"ca": caFile}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	return h, cert
+}
+
+func TestHandler_ClientCert_Login(t *testing.T) {
+	h, cert := testClientCertHandler(t)
+
+	r := req("GET", "/context/login", "", AcceptHTML)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+
+	claims, err := tokenAsMap(cookieByName(recorder, "jwt_token").Value)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, "bob@example.com", claims["email"])
+	Equal(t, "x509", claims["origin"])
+
+	// api clients get the token directly
+	r = req("GET", "/context/login", "", AcceptJwt)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+}
+
+func TestHandler_ClientCert_Fallback(t *testing.T) {
+	h, cert := testClientCertHandler(t)
+
+	// without a certificate, the form is shown
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Nil(t, cookieByName(recorder, "jwt_token"))
+
+	// an expired certificate
+	cert.NotAfter = time.Now().Add(-time.Minute)
+	r := req("GET", "/context/login", "", AcceptHTML)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	Nil(t, cookieByName(recorder, "jwt_token"))
+}
@@ -23,67 +23,244 @@ func init() {
 // DefaultConfig for the loginsrv handler
 func DefaultConfig() *Config {
 	return &Config{
-		Host:                   "localhost",
-		Port:                   "6789",
-		LogLevel:               "info",
-		JwtSecret:              jwtDefaultSecret,
-		JwtAlgo:                "HS512",
-		JwtExpiry:              24 * time.Hour,
-		JwtRefreshes:           0,
-		SuccessURL:             "/",
-		Redirect:               true,
-		RedirectQueryParameter: "backTo",
-		RedirectCheckReferer:   true,
-		RedirectHostFile:       "",
-		LogoutURL:              "",
-		LoginPath:              "/login",
-		CookieName:             "jwt_token",
-		CookieHTTPOnly:         true,
-		CookieSecure:           true,
-		Backends:               Options{},
-		Oauth:                  Options{},
-		GracePeriod:            5 * time.Second,
-		UserFile:               "",
-		UserEndpoint:           "",
-		UserEndpointToken:      "",
-		UserEndpointTimeout:    5 * time.Second,
-		CallbackURL:            "",
+		ConfigFile:                 "",
+		Tenants:                    "",
+		Host:                       "localhost",
+		Port:                       "6789",
+		SocketMode:                 "0660",
+		LogLevel:                   "info",
+		LogFormat:                  "json",
+		JwtSecret:                  jwtDefaultSecret,
+		JwtAlgo:                    "HS512",
+		JwtPrivateKeyFile:          "",
+		JwtSecondarySecret:         "",
+		JwtSecondaryPrivateKeyFile: "",
+		JwtExpiry:                  24 * time.Hour,
+		JwtRefreshes:               0,
+		JwtIssuer:                  "",
+		JwtAudience:                "",
+		JwtStaticClaims:            nil,
+		JweSecret:                  "",
+		SuccessURL:                 "/",
+		Redirect:                   true,
+		RedirectQueryParameter:     "backTo",
+		RedirectCheckReferer:       true,
+		RedirectHostFile:           "",
+		RedirectHostWhitelist:      "",
+		CSRFProtection:             true,
+		RedirectHostRegex:          "",
+		LogoutURL:                  "",
+		LoginPath:                  "/login",
+		CookieName:                 "jwt_token",
+		CookieHTTPOnly:             true,
+		CookieSecure:               true,
+		CookiePath:                 "/",
+		Backends:                   Options{},
+		Oauth:                      Options{},
+		GracePeriod:                5 * time.Second,
+		ShutdownDelay:              0,
+		TLSCert:                    "",
+		TLSKey:                     "",
+		TLSRedirectAddress:         "",
+		ACMEDomains:                "",
+		ACMEEmail:                  "",
+		ACMECacheDir:               "acme-certs",
+		ACMEDirectory:              "",
+		UserFile:                   "",
+		UserEndpoint:               "",
+		UserEndpointToken:          "",
+		UserEndpointTimeout:        5 * time.Second,
+		UserEndpointMethod:         "GET",
+		ClaimsMappingFile:          "",
+		CallbackURL:                "",
+		LoginPageTitle:             "Login",
+		LoginPageLogoURL:           "",
+		DebugMode:                  false,
+		OauthPrompt:                "",
+		OauthStateStore:            oauth2.StateStoreMemory,
+		ConsentRecordURL:           "",
+		TrackLoginStats:            false,
+		LoginStatsFile:             "loginsrv-stats.db",
+		LoginExpiryBuffer:          5 * time.Minute,
+		TokenValidateLimit:         60,
+		FailureLimit:               0,
+		FailureWindow:              5 * time.Minute,
+		Captcha:                    "",
+		CaptchaSiteKey:             "",
+		CaptchaSecret:              "",
+		CaptchaAfterFailures:       3,
+		MetricsAddress:             "",
+		GRPCAddress:                "",
+		GRPCTLSCert:                "",
+		GRPCTLSKey:                 "",
+		GRPCClientCA:               "",
+		RequireVerifiedAccount:     true,
+		RefreshTokenExpiry:         0,
+		SecondFactor:               "",
+		TOTPSecretsFile:            "loginsrv-totp.db",
+		TOTPIssuer:                 "loginsrv",
+		WebAuthnRPID:               "",
+		WebAuthnOrigin:             "",
+		WebAuthnCredentialsFile:    "loginsrv-webauthn.db",
+		SAML:                       nil,
+		Kerberos:                   nil,
+		X509:                       nil,
+		TrustedHeader:              nil,
+		RevocationStore:            "",
+		SessionStore:               "",
+		SessionLimit:               0,
+		SessionLimitMode:           SessionLimitEvict,
+		DeviceFlow:                 false,
+		PublicURL:                  "",
+		SMTPHost:                   "",
+		SMTPPort:                   587,
+		SMTPUsername:               "",
+		SMTPPassword:               "",
+		SMTPFrom:                   "",
+		Registration:               false,
+		RegistrationBackend:        "",
+		RegistrationInviteCodes:    "",
+		PasswordChange:             false,
+		PasswordReset:              false,
+		PasswordBackend:            "",
+		AdminToken:                 "",
+		AuditLog:                   "",
+		VerifyMode:                 VerifyModeForwardAuth,
+		VerifyHeadersFile:          "",
+		OIDCClients:                "",
+		OIDCIssuer:                 "",
 	}
 }
 
 const envPrefix = "LOGINSRV_"
 
-// Config for the loginsrv handler
+// Config for the loginsrv handler.
+// Applications, which create the handler without flags, should start with the DefaultConfig.
 type Config struct {
-	Host                   string
-	Port                   string
-	LogLevel               string
-	TextLogging            bool
-	JwtSecret              string
-	JwtAlgo                string
-	JwtExpiry              time.Duration
-	JwtRefreshes           int
-	SuccessURL             string
-	Redirect               bool
-	RedirectQueryParameter string
-	RedirectCheckReferer   bool
-	RedirectHostFile       string
-	LogoutURL              string
-	Template               string
-	LoginPath              string
-	CookieName             string
-	CookieExpiry           time.Duration
-	CookieDomain           string
-	CookieHTTPOnly         bool
-	CookieSecure           bool
-	Backends               Options
-	Oauth                  Options
-	GracePeriod            time.Duration
-	UserFile               string
-	UserEndpoint           string
-	UserEndpointToken      string
-	UserEndpointTimeout    time.Duration
-	CallbackURL            string
+	ConfigFile                 string
+	Tenants                    string
+	Host                       string
+	Port                       string
+	SocketMode                 string
+	LogLevel                   string
+	TextLogging                bool
+	LogFormat                  string
+	LogComponentLevels         string
+	LogRedact                  bool
+	JwtSecret                  string
+	JwtAlgo                    string
+	JwtPrivateKeyFile          string
+	JwtSecondarySecret         string
+	JwtSecondaryPrivateKeyFile string
+	JwtExpiry                  time.Duration
+	RememberMeExpiry           time.Duration
+	JwtRefreshes               int
+	JwtIssuer                  string
+	JwtAudience                string
+	JwtStaticClaims            map[string]string
+	JweSecret                  string
+	SuccessURL                 string
+	Redirect                   bool
+	RedirectQueryParameter     string
+	RedirectCheckReferer       bool
+	RedirectHostFile           string
+	RedirectHostWhitelist      string
+	CORSAllowedOrigins         string
+	CSRFProtection             bool
+	RedirectHostRegex          string
+	LogoutURL                  string
+	Template                   string
+	TemplateDir                string
+	LoginPath                  string
+	CookieName                 string
+	CookieExpiry               time.Duration
+	CookieDomain               string
+	CookieHTTPOnly             bool
+	CookieSecure               bool
+	CookieSameSite             string
+	CookiePath                 string
+	CookieMaxAge               time.Duration
+	Backends                   Options
+	Oauth                      Options
+	GracePeriod                time.Duration
+	ShutdownDelay              time.Duration
+	TLSCert                    string
+	TLSKey                     string
+	TLSRedirectAddress         string
+	ACMEDomains                string
+	ACMEEmail                  string
+	ACMECacheDir               string
+	ACMEDirectory              string
+	UserFile                   string
+	UserEndpoint               string
+	UserEndpointToken          string
+	UserEndpointTimeout        time.Duration
+	UserEndpointMethod         string
+	ClaimsMappingFile          string
+	CallbackURL                string
+	LoginPageTitle             string
+	LoginPageLogoURL           string
+	ThemeColor                 string
+	ResponseMode               string
+	DebugMode                  bool
+	OauthPrompt                string
+	OauthStateStore            string
+	ConsentRecordURL           string
+	TrackLoginStats            bool
+	LoginStatsFile             string
+	LoginExpiryBuffer          time.Duration
+	TokenValidateLimit         int
+	FailureLimit               int
+	FailureWindow              time.Duration
+	ConsulAddr                 string
+	ConsulToken                string
+	Captcha                    string
+	CaptchaSiteKey             string
+	CaptchaSecret              string
+	CaptchaAfterFailures       int
+	MetricsAddress             string
+	GRPCAddress                string
+	GRPCTLSCert                string
+	GRPCTLSKey                 string
+	GRPCClientCA               string
+	RequireVerifiedAccount     bool
+	RefreshTokenExpiry         time.Duration
+	SecondFactor               string
+	TOTPSecretsFile            string
+	TOTPIssuer                 string
+	WebAuthnRPID               string
+	WebAuthnOrigin             string
+	WebAuthnCredentialsFile    string
+	SAML                       map[string]string
+	Kerberos                   map[string]string
+	X509                       map[string]string
+	TrustedHeader              map[string]string
+	RevocationStore            string
+	SessionStore               string
+	SessionLimit               int
+	SessionLimitMode           string
+	DeviceFlow                 bool
+	PublicURL                  string
+	SMTPHost                   string
+	SMTPPort                   int
+	SMTPUsername               string
+	SMTPPassword               string
+	SMTPFrom                   string
+	Registration               bool
+	RegistrationBackend        string
+	RegistrationInviteCodes    string
+	PasswordChange             bool
+	PasswordReset              bool
+	PasswordBackend            string
+	AdminToken                 string
+	AuditLog                   string
+	VerifyMode                 string
+	VerifyHeadersFile          string
+	OIDCClients                string
+	OIDCIssuer                 string
+
+	// Hooks are the callbacks of an application, which embeds the handler. They can't be set by flags.
+	Hooks Hooks
 }
 
 // Options is the configuration structure for oauth and backend provider
@@ -114,34 +291,122 @@ func (c *Config) addBackendOpts(providerName, optsKvList string) error {
 
 // ConfigureFlagSet adds all flags to the supplied flag set
 func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
-	f.StringVar(&c.Host, "host", c.Host, "The host to listen on")
+	f.StringVar(&c.ConfigFile, configFileFlag, c.ConfigFile, "A YAML or TOML file with the options, which are overwritten by the environment and the flags")
+	f.StringVar(&c.Tenants, tenantsFlag, c.Tenants, "A YAML or TOML file with the options of the tenants by host name")
+	f.StringVar(&c.Host, "host", c.Host, "The host to listen on or unix:/path/to/socket for a unix domain socket")
 	f.StringVar(&c.Port, "port", c.Port, "The port to listen on")
+	f.StringVar(&c.SocketMode, "socket-mode", c.SocketMode, "The octal file mode of the unix domain socket")
 	f.StringVar(&c.LogLevel, "log-level", c.LogLevel, "The log level")
-	f.BoolVar(&c.TextLogging, "text-logging", c.TextLogging, "Log in text format instead of json")
+	f.BoolVar(&c.TextLogging, "text-logging", c.TextLogging, "DEPRECATED: Log in text format instead of json. Please use -log-format=text")
+	f.StringVar(&c.LogFormat, "log-format", c.LogFormat, "The log format: json, logfmt or text")
+	f.StringVar(&c.LogComponentLevels, "log-levels", c.LogComponentLevels, "Comma separated log levels of the components access, call, application, lifecycle and cacheinfo, e.g. access=warn,application=debug")
+	f.BoolVar(&c.LogRedact, "log-redact", c.LogRedact, "Replace usernames and email addresses in the log")
 	f.StringVar(&c.JwtSecret, "jwt-secret", c.JwtSecret, "The secret to sign the jwt token")
-	f.StringVar(&c.JwtAlgo, "jwt-algo", c.JwtAlgo, "The singing algorithm to use (ES256, ES384, ES512, HS512, HS256, HS384, HS512)")
+	f.StringVar(&c.JwtAlgo, "jwt-algo", c.JwtAlgo, "The singing algorithm to use (ES256, ES384, ES512, RS256, RS384, RS512, PS256, PS384, PS512, HS256, HS384, HS512)")
+	f.StringVar(&c.JwtPrivateKeyFile, "jwt-private-key", c.JwtPrivateKeyFile, "PEM file with the RSA or EC private key for the RS, PS and ES algorithms, instead of the jwt-secret")
+	f.StringVar(&c.JwtSecondarySecret, "jwt-secondary-secret", c.JwtSecondarySecret, "The previous jwt secret, which is still accepted for the verification during a key rotation")
+	f.StringVar(&c.JwtSecondaryPrivateKeyFile, "jwt-secondary-private-key", c.JwtSecondaryPrivateKeyFile, "PEM file with the previous private key, which is still accepted for the verification during a key rotation")
 	f.DurationVar(&c.JwtExpiry, "jwt-expiry", c.JwtExpiry, "The expiry duration for the jwt token, e.g. 2h or 3h30m")
 	f.IntVar(&c.JwtRefreshes, "jwt-refreshes", c.JwtRefreshes, "The maximum amount of jwt refreshes. 0 by Default")
+	f.StringVar(&c.JwtIssuer, "jwt-issuer", c.JwtIssuer, "The iss claim of the jwt token, which is verified on the validation of a token")
+	f.StringVar(&c.JwtAudience, "jwt-audience", c.JwtAudience, "The aud claim of the jwt token, which is verified on the validation of a token")
+	f.StringVar(&c.JweSecret, "jwe-secret", c.JweSecret, "The secret to encrypt the jwt token as JWE (A256GCM), so that the claims are not readable by the browser")
 	f.StringVar(&c.CookieName, "cookie-name", c.CookieName, "The name of the jwt cookie")
 	f.BoolVar(&c.CookieHTTPOnly, "cookie-http-only", c.CookieHTTPOnly, "Set the cookie with the http only flag")
 	f.BoolVar(&c.CookieSecure, "cookie-secure", c.CookieSecure, "Set the cookie with the secure flag")
+	f.DurationVar(&c.RememberMeExpiry, "remember-me-expiry", c.RememberMeExpiry, "Shows a remember me checkbox, which issues the jwt and the cookie with this expiry, e.g. 720h. 0 to disable")
 	f.DurationVar(&c.CookieExpiry, "cookie-expiry", c.CookieExpiry, "The expiry duration for the cookie, e.g. 2h or 3h30m. Default is browser session")
 	f.StringVar(&c.CookieDomain, "cookie-domain", c.CookieDomain, "The optional domain parameter for the cookie")
+	f.StringVar(&c.CookieSameSite, "cookie-same-site", c.CookieSameSite, "The optional SameSite attribute of the cookie: Lax, Strict or None")
+	f.StringVar(&c.CookiePath, "cookie-path", c.CookiePath, "The path attribute of the cookie")
+	f.DurationVar(&c.CookieMaxAge, "cookie-max-age", c.CookieMaxAge, "The optional Max-Age of the cookie, e.g. 8h, independent of the jwt expiry")
 	f.StringVar(&c.SuccessURL, "success-url", c.SuccessURL, "The url to redirect after login")
 	f.BoolVar(&c.Redirect, "redirect", c.Redirect, "Allow dynamic overwriting of the the success by query parameter")
 	f.StringVar(&c.RedirectQueryParameter, "redirect-query-parameter", c.RedirectQueryParameter, "URL parameter for the redirect target")
 	f.BoolVar(&c.RedirectCheckReferer, "redirect-check-referer", c.RedirectCheckReferer, "When redirecting check that the referer is the same domain")
 	f.StringVar(&c.RedirectHostFile, "redirect-host-file", c.RedirectHostFile, "A file containing a list of domains that redirects are allowed to, one domain per line")
+	f.StringVar(&c.RedirectHostWhitelist, "redirect-host-whitelist", c.RedirectHostWhitelist, "A comma separated list of domains that redirects are allowed to, e.g. example.com,*.example.org")
+	f.BoolVar(&c.CSRFProtection, "csrf-protection", c.CSRFProtection, "Require a csrf token for the form logins of browsers and the logout by parameter")
+	f.StringVar(&c.CORSAllowedOrigins, "cors-allowed-origins", c.CORSAllowedOrigins, "A comma separated list of origins, which may use the login by CORS, e.g. https://app.example.com,https://*.example.org. * allows all origins without credentials")
+	f.StringVar(&c.RedirectHostRegex, "redirect-host-regex", c.RedirectHostRegex, "A regular expression, which has to match the whole domain of a redirect to another domain")
 
 	f.StringVar(&c.LogoutURL, "logout-url", c.LogoutURL, "The url or path to redirect after logout")
 	f.StringVar(&c.Template, "template", c.Template, "An alternative template for the login form")
+	f.StringVar(&c.TemplateDir, "template-dir", c.TemplateDir, "A directory with templates, which replace the partials of the login form, and static assets served at /login/static/")
 	f.StringVar(&c.LoginPath, "login-path", c.LoginPath, "The path of the login resource")
+	f.StringVar(&c.RevocationStore, "revocation-store", c.RevocationStore, "Revoke the tokens on logout and store their jti until the expiry: memory or a redis url, e.g. redis://localhost:6379/0. Empty to disable")
+	f.IntVar(&c.SessionLimit, "session-limit", c.SessionLimit, "The maximum number of concurrent sessions per user in the session store. 0 for no limit")
+	f.StringVar(&c.SessionLimitMode, "session-limit-mode", c.SessionLimitMode, "The handling of a login over the session limit: evict (the oldest session) or deny")
+	f.StringVar(&c.SessionStore, "session-store", c.SessionStore, "Keep the user info in a session and only set the session id as cookie: memory or a redis url, e.g. redis://localhost:6379/0. Empty to disable")
+	f.BoolVar(&c.DeviceFlow, "device-flow", c.DeviceFlow, "Enable the device authorization grant at /login/device for CLI tools and devices without browser")
+	f.StringVar(&c.PublicURL, "public-url", c.PublicURL, "The external url of loginsrv, e.g. https://login.example.com, for the links in the mails. The host of the request is never used for them")
+	f.StringVar(&c.SMTPHost, "smtp-host", c.SMTPHost, "The smtp server for the mails of the registration and the password reset")
+	f.IntVar(&c.SMTPPort, "smtp-port", c.SMTPPort, "The port of the smtp server, STARTTLS is used if the server supports it")
+	f.StringVar(&c.SMTPUsername, "smtp-username", c.SMTPUsername, "The username for the smtp authentication")
+	f.StringVar(&c.SMTPPassword, "smtp-password", c.SMTPPassword, "The password for the smtp authentication")
+	f.StringVar(&c.SMTPFrom, "smtp-from", c.SMTPFrom, "The sender address of the mails, e.g. login@example.com")
+	f.BoolVar(&c.Registration, "registration", c.Registration, "Enable the self-service registration at /login/register with email verification")
+	f.StringVar(&c.RegistrationBackend, "registration-backend", c.RegistrationBackend, "The backend, which stores the registered users (htpasswd or db). Only needed, if multiple backends support it")
+	f.BoolVar(&c.PasswordChange, "password-change", c.PasswordChange, "Enable the password change for logged in users at /login/password")
+	f.BoolVar(&c.PasswordReset, "password-reset", c.PasswordReset, "Enable the password reset by email at /login/password/reset")
+	f.StringVar(&c.PasswordBackend, "password-backend", c.PasswordBackend, "The backend, which stores the changed passwords (htpasswd or db). Only needed, if multiple backends support it")
+	f.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "The bearer token of the admin API at /login/admin, which lists and unlocks lockouts and deletes sessions. Empty disables the API")
+	f.StringVar(&c.AuditLog, "audit-log", c.AuditLog, "Comma separated sinks of the audit events: file:/path/to/audit.log, syslog[:tag] or a webhook url. Empty disables the audit log")
+	f.StringVar(&c.VerifyMode, "verify-mode", c.VerifyMode, "The response of /login/verify without a valid token: forward-auth redirects to the login form, auth-request only responds 401, e.g. for nginx auth_request")
+	f.StringVar(&c.VerifyHeadersFile, "verify-headers-file", c.VerifyHeadersFile, "A YAML file, which maps the response headers of /login/verify to the claims of the token")
+	f.StringVar(&c.OIDCClients, "oidc-clients", c.OIDCClients, "A YAML file with the clients, which use loginsrv as OpenID Connect provider. Empty disables the provider")
+	f.StringVar(&c.OIDCIssuer, "oidc-issuer", c.OIDCIssuer, "The issuer url of the OpenID Connect provider (default is the url of the request)")
+	f.StringVar(&c.RegistrationInviteCodes, "registration-invite-codes", c.RegistrationInviteCodes, "A comma separated list of invite codes, one of which is required for the registration. Empty to allow the registration without code")
 	f.DurationVar(&c.GracePeriod, "grace-period", c.GracePeriod, "Graceful shutdown grace period")
+	f.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "PEM file with the certificate chain to serve https")
+	f.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "PEM file with the private key of the tls-cert")
+	f.StringVar(&c.TLSRedirectAddress, "tls-redirect-address", c.TLSRedirectAddress, "Address of a http server, which redirects to https and answers the ACME http-01 challenges, e.g. :80")
+	f.StringVar(&c.ACMEDomains, "acme-domains", c.ACMEDomains, "Comma separated domains to request certificates for by ACME, e.g. from Let's Encrypt")
+	f.StringVar(&c.ACMEEmail, "acme-email", c.ACMEEmail, "Contact email of the ACME account")
+	f.StringVar(&c.ACMECacheDir, "acme-cache-dir", c.ACMECacheDir, "Directory to store the ACME account and certificates")
+	f.StringVar(&c.ACMEDirectory, "acme-directory", c.ACMEDirectory, "Directory url of the ACME server. Default is Let's Encrypt")
+	f.DurationVar(&c.ShutdownDelay, "shutdown-delay", c.ShutdownDelay, "Duration to keep accepting requests after SIGINT/SIGTERM, while /healthz fails, so load balancers can remove the instance")
 	f.StringVar(&c.UserFile, "user-file", c.UserFile, "A YAML file with user specific data for the tokens")
 	f.StringVar(&c.UserEndpoint, "user-endpoint", c.UserEndpoint, "URL of an endpoint providing user specific data for the tokens")
 	f.StringVar(&c.UserEndpointToken, "user-endpoint-token", c.UserEndpointToken, "Authentication token used when communicating with the user endpoint")
 	f.DurationVar(&c.UserEndpointTimeout, "user-endpoint-timeout", c.UserEndpointTimeout, "Timeout used when communicating with the user endpoint")
+	f.StringVar(&c.UserEndpointMethod, "user-endpoint-method", c.UserEndpointMethod, "GET the claims with the user as query parameters or POST the user as JSON to the user endpoint")
+	f.StringVar(&c.ClaimsMappingFile, "claims-mapping-file", c.ClaimsMappingFile, "A YAML file with go templates, which map the user and the raw oauth user info to token claims")
 	f.StringVar(&c.CallbackURL, "callback-url", c.CallbackURL, "Url that gets post after user login")
+	f.StringVar(&c.LoginPageTitle, "login-page-title", c.LoginPageTitle, "The title of the login page")
+	f.StringVar(&c.LoginPageLogoURL, "login-page-logo-url", c.LoginPageLogoURL, "URL of a logo image shown on the login page")
+	f.StringVar(&c.LoginPageLogoURL, "theme-logo-url", c.LoginPageLogoURL, "URL of a logo image shown on the login page, same as -login-page-logo-url")
+	f.StringVar(&c.ThemeColor, "theme-color", c.ThemeColor, "The primary color of the login page, e.g. #0a7d3e")
+	f.StringVar(&c.ResponseMode, "response-mode", c.ResponseMode, "The delivery of the token, if the Accept header and the response_mode parameter do not choose one: cookie, header, body or json (default body)")
+	f.StringVar(&c.OauthPrompt, "oauth2-prompt", c.OauthPrompt, "The prompt parameter for the oauth authorization url (none, login, consent, select_account or a space separated combination)")
+	f.StringVar(&c.OauthStateStore, "oauth2-state-store", c.OauthStateStore, "The store for the state of the oauth flows until the callback: memory or a redis url, e.g. redis://localhost:6379/0")
+	f.StringVar(&c.ConsentRecordURL, "consent-record-url", c.ConsentRecordURL, "URL which gets a POST with the consented scopes after each oauth login")
+	f.BoolVar(&c.TrackLoginStats, "track-login-stats", c.TrackLoginStats, "Add the login_count and last_login_at claims to the token")
+	f.StringVar(&c.LoginStatsFile, "login-stats-file", c.LoginStatsFile, "The BoltDB file for the login statistics")
+	f.DurationVar(&c.LoginExpiryBuffer, "login-expiry-buffer", c.LoginExpiryBuffer, "Show the login page again, when the token expires within this duration, at most half of the jwt-expiry. 0 to disable")
+	f.IntVar(&c.TokenValidateLimit, "token-validate-limit", c.TokenValidateLimit, "The maximum requests per minute and client ip to the token validation endpoint. 0 to disable")
+	f.IntVar(&c.FailureLimit, "failure-limit", c.FailureLimit, "The number of failed logins per client ip and username, after which the client ip is locked and the username is slowed down. 0 to disable")
+	f.StringVar(&c.MetricsAddress, "metrics-address", c.MetricsAddress, "Serve the prometheus metrics at /metrics on this address, e.g. :9090. Empty to disable")
+	f.StringVar(&c.GRPCAddress, "grpc-address", c.GRPCAddress, "Serve the gRPC authentication API on this address, e.g. :9443. Empty to disable")
+	f.StringVar(&c.GRPCTLSCert, "grpc-tls-cert", c.GRPCTLSCert, "PEM file with the certificate chain of the gRPC API (default is the tls-cert)")
+	f.StringVar(&c.GRPCTLSKey, "grpc-tls-key", c.GRPCTLSKey, "PEM file with the private key of the grpc-tls-cert (default is the tls-key)")
+	f.StringVar(&c.GRPCClientCA, "grpc-client-ca", c.GRPCClientCA, "PEM file with the CA certificates of the gRPC clients. If set, the clients have to authenticate by a certificate (mTLS)")
+	f.DurationVar(&c.FailureWindow, "failure-window", c.FailureWindow, "The time window of the failure limit and the duration of the first lockout, which doubles on each further lockout")
+	f.StringVar(&c.ConsulAddr, "consul-addr", c.ConsulAddr, "The address of a consul agent, e.g. http://localhost:8500, which shares the failure limit between multiple instances. Empty to limit per instance")
+	f.StringVar(&c.ConsulToken, "consul-token", c.ConsulToken, "The ACL token of the consul-addr, which needs read and write access to the keys loginsrv/failures/")
+	f.StringVar(&c.Captcha, "captcha", c.Captcha, "Require a captcha after failed logins: hcaptcha or recaptcha. Empty to disable")
+	f.StringVar(&c.CaptchaSiteKey, "captcha-site-key", c.CaptchaSiteKey, "The site key of the captcha widget")
+	f.StringVar(&c.CaptchaSecret, "captcha-secret", c.CaptchaSecret, "The secret for the server side verification of the captcha")
+	f.IntVar(&c.CaptchaAfterFailures, "captcha-after-failures", c.CaptchaAfterFailures, "The number of failed logins per client ip or username within the failure-window, after which a captcha is required. 0 to always require it")
+	f.BoolVar(&c.RequireVerifiedAccount, "require-verified-account", c.RequireVerifiedAccount, "Reject oauth logins of accounts, which are not verified by the provider")
+	f.DurationVar(&c.RefreshTokenExpiry, "refresh-token-expiry", c.RefreshTokenExpiry, "Issue refresh tokens with this lifetime, which can be exchanged at /login/refresh. 0 to disable")
+	f.StringVar(&c.SecondFactor, "2fa", c.SecondFactor, "Require a second factor after the password check of the login backends (totp)")
+	f.StringVar(&c.TOTPSecretsFile, "totp-secrets-file", c.TOTPSecretsFile, "The BoltDB file for the totp secrets of the users")
+	f.StringVar(&c.TOTPIssuer, "totp-issuer", c.TOTPIssuer, "The issuer name, which is shown in the authenticator apps")
+	f.StringVar(&c.WebAuthnRPID, "webauthn-rp-id", c.WebAuthnRPID, "Enable the login with passkeys for this domain, e.g. example.com")
+	f.StringVar(&c.WebAuthnOrigin, "webauthn-origin", c.WebAuthnOrigin, "The origin of the login page for passkeys (default https://<webauthn-rp-id>)")
+	f.StringVar(&c.WebAuthnCredentialsFile, "webauthn-credentials-file", c.WebAuthnCredentialsFile, "The BoltDB file for the passkeys of the users")
+	f.BoolVar(&c.DebugMode, "debug-mode", c.DebugMode, "Enable debugging endpoints, e.g. /login/token-info. Do not enable in production!")
 
 	// the -backends is deprecated, but we support it for backwards compatibility
 	deprecatedBackends := setFunc(func(optsKvList string) error {
@@ -160,6 +425,56 @@ func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
 	})
 	f.Var(deprecatedBackends, "backend", "Deprecated, please use the explicit flags")
 
+	samlSetter := setFunc(func(optsKvList string) error {
+		opts, err := parseOptions(optsKvList)
+		if err != nil {
+			return err
+		}
+		c.SAML = opts
+		return nil
+	})
+	staticClaimsSetter := setFunc(func(optsKvList string) error {
+		claims, err := parseOptions(optsKvList)
+		if err != nil {
+			return err
+		}
+		c.JwtStaticClaims = claims
+		return nil
+	})
+	f.Var(staticClaimsSetter, "jwt-static-claims", "Static claims of every jwt token in the form: key1=value1,key2=..")
+
+	f.Var(samlSetter, "saml", "SAML 2.0 identity provider config in the form: entity_id=..,idp_metadata=..[,acs_url=..][,label=..]")
+
+	kerberosSetter := setFunc(func(optsKvList string) error {
+		opts, err := parseOptions(optsKvList)
+		if err != nil {
+			return err
+		}
+		c.Kerberos = opts
+		return nil
+	})
+	f.Var(kerberosSetter, "kerberos", "Kerberos SPNEGO single sign-on config in the form: keytab=..[,service_principal=..][,realm=..]")
+
+	x509Setter := setFunc(func(optsKvList string) error {
+		opts, err := parseOptions(optsKvList)
+		if err != nil {
+			return err
+		}
+		c.X509 = opts
+		return nil
+	})
+	f.Var(x509Setter, "x509", "Client certificate (mTLS) login config in the form: ca=..[,header=..,trusted_proxies=..][,sub=cn|email][,crl=..][,ocsp=true]")
+
+	trustedHeaderSetter := setFunc(func(optsKvList string) error {
+		opts, err := parseOptions(optsKvList)
+		if err != nil {
+			return err
+		}
+		c.TrustedHeader = opts
+		return nil
+	})
+	f.Var(trustedHeaderSetter, "trusted-header", "Login by the identity headers of an SSO proxy in the form: trusted_proxies=..[,user=..][,email=..][,name=..][,groups=..]")
+
 	// One option for each oauth provider
 	for _, pName := range oauth2.ProviderList() {
 		func(pName string) {
@@ -177,32 +492,59 @@ func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
 				return c.addBackendOpts(pName, optsKvList)
 			})
 			desc, _ := GetProviderDescription(pName)
-			f.Var(setter, pName, desc.HelpText)
+			f.Var(setter, pName, desc.helpText())
 		}(pName)
 	}
 }
 
-// ReadConfig from the commandline args
+// ReadConfig from the config file, the environment and the commandline args
 func ReadConfig() *Config {
-	c, err := readConfig(flag.CommandLine, os.Args[1:])
+	return ReadConfigArgs(os.Args[1:])
+}
+
+// ReadConfigArgs reads the config like ReadConfig with the supplied commandline args.
+// On an invalid config file, the error is printed and the program exits.
+func ReadConfigArgs(args []string) *Config {
+	c, err := readConfig(flag.CommandLine, args)
 	if err != nil {
-		// should never happen, because of flag default policy ExitOnError
-		panic(err)
+		// errors of the flags can not happen, because of flag default policy ExitOnError
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 	return c
 }
 
+var lookupEnv = os.LookupEnv
+
 func readConfig(f *flag.FlagSet, args []string) (*Config, error) {
 	logging.Logger.Info("reading config for login")
 	config := DefaultConfig()
 	config.ConfigureFlagSet(f)
 
-	// fist use the environment settings
-	f.VisitAll(func(f *flag.Flag) {
-		if val, isPresent := os.LookupEnv(envName(f.Name)); isPresent {
-			f.Value.Set(val)
+	// the config file is the base, which is overwritten by the environment and the flags
+	if filename := configFileName(args); filename != "" {
+		if err := loadConfigFile(f, filename); err != nil {
+			return nil, err
+		}
+	}
+
+	// then use the environment settings
+	var envErr error
+	f.VisitAll(func(fl *flag.Flag) {
+		if val, isPresent := lookupEnv(envName(fl.Name)); isPresent {
+			fl.Value.Set(val)
+		}
+		val, isPresent, err := readEnvFile(f, fl.Name)
+		if err != nil && envErr == nil {
+			envErr = err
+		}
+		if isPresent {
+			fl.Value.Set(val)
 		}
 	})
+	if envErr != nil {
+		return nil, envErr
+	}
 
 	// prefer flags over environment settings
 	err := f.Parse(args)
@@ -210,7 +552,10 @@ func readConfig(f *flag.FlagSet, args []string) (*Config, error) {
 		return nil, err
 	}
 
-	return config, err
+	if err := config.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -229,7 +574,7 @@ func envName(flagName string) string {
 
 func parseOptions(b string) (map[string]string, error) {
 	opts := map[string]string{}
-	pairs := strings.Split(b, ",")
+	pairs := splitOptions(b)
 	for _, p := range pairs {
 		pair := strings.SplitN(p, "=", 2)
 		if len(pair) != 2 {
@@ -240,6 +585,26 @@ func parseOptions(b string) (map[string]string, error) {
 	return opts, nil
 }
 
+// splitOptions splits the options at the commas.
+// A comma within a value, e.g. in a ldap dn, can be escaped as '\,'.
+func splitOptions(b string) []string {
+	pairs := []string{}
+	current := strings.Builder{}
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '\\' && i+1 < len(b) && b[i+1] == ',':
+			current.WriteByte(',')
+			i++
+		case b[i] == ',':
+			pairs = append(pairs, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(b[i])
+		}
+	}
+	return append(pairs, current.String())
+}
+
 // Helper type to wrap a function closure with the Value interface
 type setFunc func(optsKvList string) error
 
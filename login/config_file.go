@@ -0,0 +1,166 @@
+package login
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// configFileFlag is the option of the configuration file
+const configFileFlag = "config"
+
+// loadConfigFile sets the flags to the values of a YAML or TOML file.
+// The keys of the file are the names of the options.
+// Provider options can be written as maps, and comma separated options as lists, e.g.
+//
+//	jwt-expiry: 1h
+//	cookie-secure: true
+//	htpasswd:
+//	  file: /etc/loginsrv/users
+//	github:
+//	  client_id: abc
+//	  client_secret: xyz
+//	acme-domains: [login.example.com, auth.example.com]
+func loadConfigFile(f *flag.FlagSet, filename string) error {
+	values, err := readConfigFile(filename)
+	if err != nil {
+		return err
+	}
+	return setFlags(f, values, "config file "+filename, configFileFlag)
+}
+
+// readConfigFile parses the YAML or TOML file
+func readConfigFile(filename string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &values)
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	default:
+		return nil, fmt.Errorf("unsupported config file %v, expected a .yaml, .yml or .toml file", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't parse config file %v: %v", filename, err)
+	}
+	return values, nil
+}
+
+// setFlags sets the flags to the values, the excluded options are not allowed
+func setFlags(f *flag.FlagSet, values map[string]interface{}, source string, excluded ...string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if f.Lookup(name) == nil || contains(excluded, name) {
+			return fmt.Errorf("unknown option %q in %v", name, source)
+		}
+		value, err := flagValue(values[name])
+		if err != nil {
+			return fmt.Errorf("invalid option %q in %v: %v", name, source, err)
+		}
+		if err := f.Set(name, value); err != nil {
+			return fmt.Errorf("invalid option %q in %v: %v", name, source, err)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue converts a value of the config file to the syntax of the command line
+func flagValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		elements := make([]string, 0, len(v))
+		for _, e := range v {
+			s, err := scalarValue(e)
+			if err != nil {
+				return "", err
+			}
+			elements = append(elements, s)
+		}
+		return strings.Join(elements, ","), nil
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = e
+		}
+		return optionsValue(m)
+	case map[string]interface{}:
+		return optionsValue(v)
+	}
+	return scalarValue(value)
+}
+
+// optionsValue returns the options in the form key1=value1,key2=.. with escaped commas
+func optionsValue(m map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(m))
+	for _, k := range keys {
+		s, err := scalarValue(m[k])
+		if err != nil {
+			return "", fmt.Errorf("%v: %v", k, err)
+		}
+		pairs = append(pairs, k+"="+strings.Replace(s, ",", `\,`, -1))
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+func scalarValue(value interface{}) (string, error) {
+	switch value.(type) {
+	case nil:
+		return "", nil
+	case string, bool, int, int64, uint64, float64:
+		return fmt.Sprint(value), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// configFileName returns the config file of the environment or the command line
+func configFileName(args []string) string {
+	name, _ := lookupEnv(envName(configFileFlag))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		for _, prefix := range []string{"-" + configFileFlag, "--" + configFileFlag} {
+			switch {
+			case arg == prefix && i+1 < len(args):
+				name = args[i+1]
+			case strings.HasPrefix(arg, prefix+"="):
+				name = strings.TrimPrefix(arg, prefix+"=")
+			}
+		}
+	}
+	return name
+}
@@ -0,0 +1,139 @@
+package login
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+const yamlConfig = `
+host: 0.0.0.0
+port: 8080
+jwt-expiry: 1h
+cookie-secure: false
+jwt-static-claims:
+  tenant: example
+  groups: a,b
+simple:
+  bob: secret
+github:
+  client_id: abc
+  client_secret: xyz
+audit-log: [file:audit.log, syslog]
+`
+
+const tomlConfig = `
+host = "0.0.0.0"
+port = 8080
+jwt-expiry = "1h"
+cookie-secure = false
+audit-log = ["file:audit.log", "syslog"]
+
+[jwt-static-claims]
+tenant = "example"
+groups = "a,b"
+
+[simple]
+bob = "secret"
+
+[github]
+client_id = "abc"
+client_secret = "xyz"
+`
+
+func TestConfigFile_Formats(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content string
+	}{
+		{"loginsrv.yaml", yamlConfig},
+		{"loginsrv.yml", yamlConfig},
+		{"loginsrv.toml", tomlConfig},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			filename := writeConfigFile(t, test.name, test.content)
+
+			config, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"-config", filename})
+			if !NoError(t, err) {
+				return
+			}
+
+			Equal(t, filename, config.ConfigFile)
+			Equal(t, "0.0.0.0", config.Host)
+			Equal(t, "8080", config.Port)
+			Equal(t, time.Hour, config.JwtExpiry)
+			False(t, config.CookieSecure)
+			Equal(t, map[string]string{"tenant": "example", "groups": "a,b"}, config.JwtStaticClaims)
+			Equal(t, map[string]string{"bob": "secret"}, config.Backends["simple"])
+			Equal(t, map[string]string{"client_id": "abc", "client_secret": "xyz"}, config.Oauth["github"])
+			Equal(t, "file:audit.log,syslog", config.AuditLog)
+		})
+	}
+}
+
+func TestConfigFile_Overrides(t *testing.T) {
+	filename := writeConfigFile(t, "loginsrv.yaml", yamlConfig)
+
+	setTestEnv(t, map[string]string{
+		"LOGINSRV_CONFIG": filename,
+		"LOGINSRV_PORT":   "9090",
+		"LOGINSRV_HOST":   "env-host",
+	})
+
+	config, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--host=flag-host"})
+	NoError(t, err)
+
+	Equal(t, time.Hour, config.JwtExpiry)
+	Equal(t, "9090", config.Port)
+	Equal(t, "flag-host", config.Host)
+}
+
+func TestConfigFile_Errors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown.yaml", "no-such-option: 1", `unknown option "no-such-option"`},
+		{"nested.yaml", "config: other.yaml", `unknown option "config"`},
+		{"invalid.yaml", "jwt-expiry: forever", `invalid option "jwt-expiry"`},
+		{"nested-list.yaml", "simple:\n  bob: [a, b]", `invalid option "simple"`},
+		{"broken.toml", "host = ", "can't parse config file"},
+		{"loginsrv.json", "{}", "unsupported config file"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			filename := writeConfigFile(t, test.name, test.content)
+
+			_, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--config=" + filename})
+			Error(t, err)
+			Contains(t, err.Error(), test.err)
+		})
+	}
+
+	_, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--config", "does-not-exist.yaml"})
+	Error(t, err)
+}
+
+func TestConfigFile_FileName(t *testing.T) {
+	Equal(t, "", configFileName([]string{"-host", "example.com"}))
+	Equal(t, "a.yaml", configFileName([]string{"-config", "a.yaml"}))
+	Equal(t, "a.yaml", configFileName([]string{"--config", "a.yaml"}))
+	Equal(t, "a.yaml", configFileName([]string{"-config=a.yaml"}))
+	Equal(t, "b.yaml", configFileName([]string{"--config=a.yaml", "--config=b.yaml"}))
+	Equal(t, "", configFileName([]string{"--", "-config=a.yaml"}))
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "loginsrv-config")
+	NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, name)
+	NoError(t, ioutil.WriteFile(filename, []byte(content), 0600))
+	return filename
+}
@@ -24,16 +24,27 @@ func TestConfig_ReadConfig(t *testing.T) {
 	input := []string{
 		"--host=host",
 		"--port=port",
+		"--socket-mode=0600",
 		"--log-level=loglevel",
 		"--text-logging=true",
+		"--log-format=logfmt",
+		"--log-levels=access=warn",
+		"--log-redact=true",
 		"--jwt-secret=jwtsecret",
 		"--jwt-algo=algo",
 		"--jwt-expiry=42h42m",
+		"--remember-me-expiry=720h",
+		"--jwt-issuer=https://login.example.com",
+		"--jwt-audience=example-app",
+		"--jwt-static-claims=tenant=example,env=prod",
 		"--success-url=successurl",
 		"--redirect=false",
 		"--redirect-query-parameter=comingFrom",
 		"--redirect-check-referer=false",
 		"--redirect-host-file=File",
+		"--redirect-host-whitelist=example.com,*.example.org",
+		"--csrf-protection=false",
+		"--redirect-host-regex=.*\\.example\\.net",
 		"--logout-url=logouturl",
 		"--template=template",
 		"--login-path=loginpath",
@@ -42,37 +53,125 @@ func TestConfig_ReadConfig(t *testing.T) {
 		"--cookie-domain=*.example.com",
 		"--cookie-http-only=false",
 		"--cookie-secure=false",
+		"--cookie-same-site=Strict",
+		"--cookie-path=/app",
+		"--cookie-max-age=8h",
 		"--backend=provider=simple",
 		"--backend=provider=foo",
 		"--github=client_id=foo,client_secret=bar",
 		"--grace-period=4s",
+		"--shutdown-delay=3s",
+		"--tls-cert=cert.pem",
+		"--tls-key=key.pem",
+		"--tls-redirect-address=:80",
+		"--acme-domains=login.example.com",
+		"--acme-email=admin@example.com",
+		"--acme-cache-dir=/var/lib/loginsrv",
+		"--acme-directory=https://acme.example.com/directory",
 		"--user-file=users.yml",
 		"--user-endpoint=http://test.io/claims",
 		"--user-endpoint-token=token",
 		"--user-endpoint-timeout=1s",
+		"--user-endpoint-method=POST",
+		"--claims-mapping-file=/etc/loginsrv/claims.yml",
+		"--login-page-title=title",
+		"--login-page-logo-url=http://example.com/logo.png",
+		"--theme-color=#0a7d3e",
+		"--debug-mode=true",
+		"--oauth2-prompt=login consent",
+		"--oauth2-state-store=redis://localhost:6379/0",
+		"--consent-record-url=http://example.com/consent",
+		"--track-login-stats=true",
+		"--login-stats-file=stats.db",
+		"--login-expiry-buffer=10m",
+		"--token-validate-limit=10",
+		"--failure-limit=3",
+		"--failure-window=1m",
+		"--consul-addr=http://localhost:8500",
+		"--consul-token=consultoken",
+		"--captcha=hcaptcha",
+		"--captcha-site-key=sitekey",
+		"--captcha-secret=captchasecret",
+		"--captcha-after-failures=5",
+		"--metrics-address=:9090",
+		"--require-verified-account=false",
+		"--refresh-token-expiry=720h",
+		"--jwt-private-key=key.pem",
+		"--jwe-secret=jwesecret",
+		"--jwt-secondary-secret=oldsecret",
+		"--jwt-secondary-private-key=old-key.pem",
+		"--2fa=totp",
+		"--totp-secrets-file=totp.db",
+		"--totp-issuer=example",
+		"--webauthn-rp-id=example.com",
+		"--webauthn-origin=https://login.example.com",
+		"--webauthn-credentials-file=webauthn.db",
+		"--saml=entity_id=https://login.example.com,idp_metadata=idp.xml",
+		"--kerberos=keytab=http.keytab,realm=EXAMPLE.COM",
+		"--x509=ca=ca.pem,sub=email",
+		"--trusted-header=trusted_proxies=10.0.0.1,email=X-Forwarded-Email",
+		"--revocation-store=memory",
+		"--session-store=memory",
+		"--session-limit=3",
+		"--session-limit-mode=deny",
+		"--device-flow",
+		"--smtp-host=mail.example.com",
+		"--smtp-port=25",
+		"--smtp-username=smtpuser",
+		"--smtp-password=smtppassword",
+		"--smtp-from=login@example.com",
+		"--public-url=https://login.example.com",
+		"--registration",
+		"--registration-backend=htpasswd",
+		"--registration-invite-codes=code1,code2",
+		"--password-change",
+		"--password-reset",
+		"--password-backend=db",
+		"--admin-token=admintoken",
+		"--audit-log=syslog",
+		"--verify-mode=auth-request",
+		"--verify-headers-file=headers.yml",
 	}
 
 	expected := &Config{
-		Host:                   "host",
-		Port:                   "port",
-		LogLevel:               "loglevel",
-		TextLogging:            true,
-		JwtSecret:              "jwtsecret",
-		JwtAlgo:                "algo",
-		JwtExpiry:              42*time.Hour + 42*time.Minute,
-		SuccessURL:             "successurl",
-		Redirect:               false,
-		RedirectQueryParameter: "comingFrom",
-		RedirectCheckReferer:   false,
-		RedirectHostFile:       "File",
-		LogoutURL:              "logouturl",
-		Template:               "template",
-		LoginPath:              "loginpath",
-		CookieName:             "cookiename",
-		CookieExpiry:           23 * time.Minute,
-		CookieDomain:           "*.example.com",
-		CookieHTTPOnly:         false,
-		CookieSecure:           false,
+		Host:                       "host",
+		Port:                       "port",
+		SocketMode:                 "0600",
+		LogLevel:                   "loglevel",
+		TextLogging:                true,
+		LogFormat:                  "logfmt",
+		LogComponentLevels:         "access=warn",
+		LogRedact:                  true,
+		JwtSecret:                  "jwtsecret",
+		JwtAlgo:                    "algo",
+		JwtPrivateKeyFile:          "key.pem",
+		JwtSecondarySecret:         "oldsecret",
+		JwtSecondaryPrivateKeyFile: "old-key.pem",
+		JwtExpiry:                  42*time.Hour + 42*time.Minute,
+		RememberMeExpiry:           720 * time.Hour,
+		JwtIssuer:                  "https://login.example.com",
+		JwtAudience:                "example-app",
+		JwtStaticClaims:            map[string]string{"tenant": "example", "env": "prod"},
+		JweSecret:                  "jwesecret",
+		SuccessURL:                 "successurl",
+		Redirect:                   false,
+		RedirectQueryParameter:     "comingFrom",
+		RedirectCheckReferer:       false,
+		RedirectHostFile:           "File",
+		RedirectHostWhitelist:      "example.com,*.example.org",
+		CSRFProtection:             false,
+		RedirectHostRegex:          `.*\.example\.net`,
+		LogoutURL:                  "logouturl",
+		Template:                   "template",
+		LoginPath:                  "loginpath",
+		CookieName:                 "cookiename",
+		CookieExpiry:               23 * time.Minute,
+		CookieDomain:               "*.example.com",
+		CookieHTTPOnly:             false,
+		CookieSecure:               false,
+		CookieSameSite:             "Strict",
+		CookiePath:                 "/app",
+		CookieMaxAge:               8 * time.Hour,
 		Backends: Options{
 			"simple": map[string]string{},
 			"foo":    map[string]string{},
@@ -83,11 +182,86 @@ func TestConfig_ReadConfig(t *testing.T) {
 				"client_secret": "bar",
 			},
 		},
-		GracePeriod:         4 * time.Second,
-		UserFile:            "users.yml",
-		UserEndpoint:        "http://test.io/claims",
-		UserEndpointToken:   "token",
-		UserEndpointTimeout: time.Second,
+		GracePeriod:             4 * time.Second,
+		ShutdownDelay:           3 * time.Second,
+		TLSCert:                 "cert.pem",
+		TLSKey:                  "key.pem",
+		TLSRedirectAddress:      ":80",
+		ACMEDomains:             "login.example.com",
+		ACMEEmail:               "admin@example.com",
+		ACMECacheDir:            "/var/lib/loginsrv",
+		ACMEDirectory:           "https://acme.example.com/directory",
+		UserFile:                "users.yml",
+		UserEndpoint:            "http://test.io/claims",
+		UserEndpointToken:       "token",
+		UserEndpointTimeout:     time.Second,
+		UserEndpointMethod:      "POST",
+		ClaimsMappingFile:       "/etc/loginsrv/claims.yml",
+		LoginPageTitle:          "title",
+		LoginPageLogoURL:        "http://example.com/logo.png",
+		ThemeColor:              "#0a7d3e",
+		DebugMode:               true,
+		OauthPrompt:             "login consent",
+		OauthStateStore:         "redis://localhost:6379/0",
+		ConsentRecordURL:        "http://example.com/consent",
+		TrackLoginStats:         true,
+		LoginStatsFile:          "stats.db",
+		LoginExpiryBuffer:       10 * time.Minute,
+		TokenValidateLimit:      10,
+		FailureLimit:            3,
+		FailureWindow:           time.Minute,
+		ConsulAddr:              "http://localhost:8500",
+		ConsulToken:             "consultoken",
+		Captcha:                 "hcaptcha",
+		CaptchaSiteKey:          "sitekey",
+		CaptchaSecret:           "captchasecret",
+		CaptchaAfterFailures:    5,
+		MetricsAddress:          ":9090",
+		RequireVerifiedAccount:  false,
+		RefreshTokenExpiry:      720 * time.Hour,
+		SecondFactor:            "totp",
+		TOTPSecretsFile:         "totp.db",
+		TOTPIssuer:              "example",
+		WebAuthnRPID:            "example.com",
+		WebAuthnOrigin:          "https://login.example.com",
+		WebAuthnCredentialsFile: "webauthn.db",
+		SAML: map[string]string{
+			"entity_id":    "https://login.example.com",
+			"idp_metadata": "idp.xml",
+		},
+		Kerberos: map[string]string{
+			"keytab": "http.keytab",
+			"realm":  "EXAMPLE.COM",
+		},
+		X509: map[string]string{
+			"ca":  "ca.pem",
+			"sub": "email",
+		},
+		TrustedHeader: map[string]string{
+			"trusted_proxies": "10.0.0.1",
+			"email":           "X-Forwarded-Email",
+		},
+		RevocationStore:         "memory",
+		SessionStore:            "memory",
+		SessionLimit:            3,
+		SessionLimitMode:        "deny",
+		DeviceFlow:              true,
+		SMTPHost:                "mail.example.com",
+		SMTPPort:                25,
+		SMTPUsername:            "smtpuser",
+		SMTPPassword:            "smtppassword",
+		SMTPFrom:                "login@example.com",
+		PublicURL:               "https://login.example.com",
+		Registration:            true,
+		RegistrationBackend:     "htpasswd",
+		RegistrationInviteCodes: "code1,code2",
+		PasswordChange:          true,
+		PasswordReset:           true,
+		PasswordBackend:         "db",
+		AdminToken:              "admintoken",
+		AuditLog:                "syslog",
+		VerifyMode:              "auth-request",
+		VerifyHeadersFile:       "headers.yml",
 	}
 
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), input)
@@ -98,16 +272,31 @@ func TestConfig_ReadConfig(t *testing.T) {
 func TestConfig_ReadConfigFromEnv(t *testing.T) {
 	NoError(t, os.Setenv("LOGINSRV_HOST", "host"))
 	NoError(t, os.Setenv("LOGINSRV_PORT", "port"))
+	NoError(t, os.Setenv("LOGINSRV_SOCKET_MODE", "0600"))
 	NoError(t, os.Setenv("LOGINSRV_LOG_LEVEL", "loglevel"))
 	NoError(t, os.Setenv("LOGINSRV_TEXT_LOGGING", "true"))
+	NoError(t, os.Setenv("LOGINSRV_LOG_FORMAT", "logfmt"))
+	NoError(t, os.Setenv("LOGINSRV_LOG_LEVELS", "access=warn"))
+	NoError(t, os.Setenv("LOGINSRV_LOG_REDACT", "true"))
 	NoError(t, os.Setenv("LOGINSRV_JWT_SECRET", "jwtsecret"))
 	NoError(t, os.Setenv("LOGINSRV_JWT_ALGO", "algo"))
+	NoError(t, os.Setenv("LOGINSRV_JWT_PRIVATE_KEY", "key.pem"))
+	NoError(t, os.Setenv("LOGINSRV_JWT_SECONDARY_SECRET", "oldsecret"))
+	NoError(t, os.Setenv("LOGINSRV_JWT_SECONDARY_PRIVATE_KEY", "old-key.pem"))
 	NoError(t, os.Setenv("LOGINSRV_JWT_EXPIRY", "42h42m"))
+	NoError(t, os.Setenv("LOGINSRV_REMEMBER_ME_EXPIRY", "720h"))
+	NoError(t, os.Setenv("LOGINSRV_JWT_ISSUER", "https://login.example.com"))
+	NoError(t, os.Setenv("LOGINSRV_JWT_AUDIENCE", "example-app"))
+	NoError(t, os.Setenv("LOGINSRV_JWT_STATIC_CLAIMS", "tenant=example,env=prod"))
+	NoError(t, os.Setenv("LOGINSRV_JWE_SECRET", "jwesecret"))
 	NoError(t, os.Setenv("LOGINSRV_SUCCESS_URL", "successurl"))
 	NoError(t, os.Setenv("LOGINSRV_REDIRECT", "false"))
 	NoError(t, os.Setenv("LOGINSRV_REDIRECT_QUERY_PARAMETER", "comingFrom"))
 	NoError(t, os.Setenv("LOGINSRV_REDIRECT_CHECK_REFERER", "false"))
 	NoError(t, os.Setenv("LOGINSRV_REDIRECT_HOST_FILE", "File"))
+	NoError(t, os.Setenv("LOGINSRV_REDIRECT_HOST_WHITELIST", "example.com,*.example.org"))
+	NoError(t, os.Setenv("LOGINSRV_CSRF_PROTECTION", "false"))
+	NoError(t, os.Setenv("LOGINSRV_REDIRECT_HOST_REGEX", `.*\.example\.net`))
 	NoError(t, os.Setenv("LOGINSRV_LOGOUT_URL", "logouturl"))
 	NoError(t, os.Setenv("LOGINSRV_TEMPLATE", "template"))
 	NoError(t, os.Setenv("LOGINSRV_LOGIN_PATH", "loginpath"))
@@ -116,35 +305,118 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 	NoError(t, os.Setenv("LOGINSRV_COOKIE_DOMAIN", "*.example.com"))
 	NoError(t, os.Setenv("LOGINSRV_COOKIE_HTTP_ONLY", "false"))
 	NoError(t, os.Setenv("LOGINSRV_COOKIE_SECURE", "false"))
+	NoError(t, os.Setenv("LOGINSRV_COOKIE_SAME_SITE", "Strict"))
+	NoError(t, os.Setenv("LOGINSRV_COOKIE_PATH", "/app"))
+	NoError(t, os.Setenv("LOGINSRV_COOKIE_MAX_AGE", "8h"))
 	NoError(t, os.Setenv("LOGINSRV_SIMPLE", "foo=bar"))
 	NoError(t, os.Setenv("LOGINSRV_GITHUB", "client_id=foo,client_secret=bar"))
 	NoError(t, os.Setenv("LOGINSRV_GRACE_PERIOD", "4s"))
+	NoError(t, os.Setenv("LOGINSRV_SHUTDOWN_DELAY", "3s"))
+	NoError(t, os.Setenv("LOGINSRV_TLS_CERT", "cert.pem"))
+	NoError(t, os.Setenv("LOGINSRV_TLS_KEY", "key.pem"))
+	NoError(t, os.Setenv("LOGINSRV_TLS_REDIRECT_ADDRESS", ":80"))
+	NoError(t, os.Setenv("LOGINSRV_ACME_DOMAINS", "login.example.com"))
+	NoError(t, os.Setenv("LOGINSRV_ACME_EMAIL", "admin@example.com"))
+	NoError(t, os.Setenv("LOGINSRV_ACME_CACHE_DIR", "/var/lib/loginsrv"))
+	NoError(t, os.Setenv("LOGINSRV_ACME_DIRECTORY", "https://acme.example.com/directory"))
 	NoError(t, os.Setenv("LOGINSRV_USER_FILE", "users.yml"))
 	NoError(t, os.Setenv("LOGINSRV_USER_ENDPOINT", "http://test.io/claims"))
 	NoError(t, os.Setenv("LOGINSRV_USER_ENDPOINT_TOKEN", "token"))
 	NoError(t, os.Setenv("LOGINSRV_USER_ENDPOINT_TIMEOUT", "1s"))
+	NoError(t, os.Setenv("LOGINSRV_USER_ENDPOINT_METHOD", "POST"))
+	NoError(t, os.Setenv("LOGINSRV_CLAIMS_MAPPING_FILE", "/etc/loginsrv/claims.yml"))
+	NoError(t, os.Setenv("LOGINSRV_LOGIN_PAGE_TITLE", "title"))
+	NoError(t, os.Setenv("LOGINSRV_LOGIN_PAGE_LOGO_URL", "http://example.com/logo.png"))
+	NoError(t, os.Setenv("LOGINSRV_DEBUG_MODE", "true"))
+	NoError(t, os.Setenv("LOGINSRV_OAUTH2_PROMPT", "login consent"))
+	NoError(t, os.Setenv("LOGINSRV_OAUTH2_STATE_STORE", "redis://localhost:6379/0"))
+	NoError(t, os.Setenv("LOGINSRV_CONSENT_RECORD_URL", "http://example.com/consent"))
+	NoError(t, os.Setenv("LOGINSRV_TRACK_LOGIN_STATS", "true"))
+	NoError(t, os.Setenv("LOGINSRV_LOGIN_STATS_FILE", "stats.db"))
+	NoError(t, os.Setenv("LOGINSRV_LOGIN_EXPIRY_BUFFER", "10m"))
+	NoError(t, os.Setenv("LOGINSRV_TOKEN_VALIDATE_LIMIT", "10"))
+	NoError(t, os.Setenv("LOGINSRV_FAILURE_LIMIT", "3"))
+	NoError(t, os.Setenv("LOGINSRV_FAILURE_WINDOW", "1m"))
+	NoError(t, os.Setenv("LOGINSRV_CONSUL_ADDR", "http://localhost:8500"))
+	NoError(t, os.Setenv("LOGINSRV_CONSUL_TOKEN", "consultoken"))
+	NoError(t, os.Setenv("LOGINSRV_CAPTCHA", "hcaptcha"))
+	NoError(t, os.Setenv("LOGINSRV_CAPTCHA_SITE_KEY", "sitekey"))
+	NoError(t, os.Setenv("LOGINSRV_CAPTCHA_SECRET", "captchasecret"))
+	NoError(t, os.Setenv("LOGINSRV_CAPTCHA_AFTER_FAILURES", "5"))
+	NoError(t, os.Setenv("LOGINSRV_METRICS_ADDRESS", ":9090"))
+	NoError(t, os.Setenv("LOGINSRV_REQUIRE_VERIFIED_ACCOUNT", "false"))
+	NoError(t, os.Setenv("LOGINSRV_REFRESH_TOKEN_EXPIRY", "720h"))
+	NoError(t, os.Setenv("LOGINSRV_2FA", "totp"))
+	NoError(t, os.Setenv("LOGINSRV_TOTP_SECRETS_FILE", "totp.db"))
+	NoError(t, os.Setenv("LOGINSRV_TOTP_ISSUER", "example"))
+	NoError(t, os.Setenv("LOGINSRV_WEBAUTHN_RP_ID", "example.com"))
+	NoError(t, os.Setenv("LOGINSRV_WEBAUTHN_ORIGIN", "https://login.example.com"))
+	NoError(t, os.Setenv("LOGINSRV_WEBAUTHN_CREDENTIALS_FILE", "webauthn.db"))
+	NoError(t, os.Setenv("LOGINSRV_SAML", "entity_id=https://login.example.com,idp_metadata=idp.xml"))
+	NoError(t, os.Setenv("LOGINSRV_KERBEROS", "keytab=http.keytab,realm=EXAMPLE.COM"))
+	NoError(t, os.Setenv("LOGINSRV_X509", "ca=ca.pem,sub=email"))
+	NoError(t, os.Setenv("LOGINSRV_TRUSTED_HEADER", "trusted_proxies=10.0.0.1,email=X-Forwarded-Email"))
+	NoError(t, os.Setenv("LOGINSRV_REVOCATION_STORE", "memory"))
+	NoError(t, os.Setenv("LOGINSRV_SESSION_STORE", "memory"))
+	NoError(t, os.Setenv("LOGINSRV_SESSION_LIMIT", "3"))
+	NoError(t, os.Setenv("LOGINSRV_SESSION_LIMIT_MODE", "deny"))
+	NoError(t, os.Setenv("LOGINSRV_DEVICE_FLOW", "true"))
+	NoError(t, os.Setenv("LOGINSRV_SMTP_HOST", "mail.example.com"))
+	NoError(t, os.Setenv("LOGINSRV_SMTP_PORT", "25"))
+	NoError(t, os.Setenv("LOGINSRV_SMTP_USERNAME", "smtpuser"))
+	NoError(t, os.Setenv("LOGINSRV_SMTP_PASSWORD", "smtppassword"))
+	NoError(t, os.Setenv("LOGINSRV_SMTP_FROM", "login@example.com"))
+	NoError(t, os.Setenv("LOGINSRV_PUBLIC_URL", "https://login.example.com"))
+	NoError(t, os.Setenv("LOGINSRV_REGISTRATION", "true"))
+	NoError(t, os.Setenv("LOGINSRV_REGISTRATION_BACKEND", "htpasswd"))
+	NoError(t, os.Setenv("LOGINSRV_REGISTRATION_INVITE_CODES", "code1,code2"))
+	NoError(t, os.Setenv("LOGINSRV_PASSWORD_CHANGE", "true"))
+	NoError(t, os.Setenv("LOGINSRV_PASSWORD_RESET", "true"))
+	NoError(t, os.Setenv("LOGINSRV_PASSWORD_BACKEND", "db"))
+	NoError(t, os.Setenv("LOGINSRV_ADMIN_TOKEN", "admintoken"))
+	NoError(t, os.Setenv("LOGINSRV_AUDIT_LOG", "syslog"))
+	NoError(t, os.Setenv("LOGINSRV_VERIFY_MODE", "auth-request"))
+	NoError(t, os.Setenv("LOGINSRV_VERIFY_HEADERS_FILE", "headers.yml"))
 
 	expected := &Config{
-		Host:                   "host",
-		Port:                   "port",
-		LogLevel:               "loglevel",
-		TextLogging:            true,
-		JwtSecret:              "jwtsecret",
-		JwtAlgo:                "algo",
-		JwtExpiry:              42*time.Hour + 42*time.Minute,
-		SuccessURL:             "successurl",
-		Redirect:               false,
-		RedirectQueryParameter: "comingFrom",
-		RedirectCheckReferer:   false,
-		RedirectHostFile:       "File",
-		LogoutURL:              "logouturl",
-		Template:               "template",
-		LoginPath:              "loginpath",
-		CookieName:             "cookiename",
-		CookieExpiry:           23 * time.Minute,
-		CookieDomain:           "*.example.com",
-		CookieHTTPOnly:         false,
-		CookieSecure:           false,
+		Host:                       "host",
+		Port:                       "port",
+		SocketMode:                 "0600",
+		LogLevel:                   "loglevel",
+		TextLogging:                true,
+		LogFormat:                  "logfmt",
+		LogComponentLevels:         "access=warn",
+		LogRedact:                  true,
+		JwtSecret:                  "jwtsecret",
+		JwtAlgo:                    "algo",
+		JwtPrivateKeyFile:          "key.pem",
+		JwtSecondarySecret:         "oldsecret",
+		JwtSecondaryPrivateKeyFile: "old-key.pem",
+		JwtExpiry:                  42*time.Hour + 42*time.Minute,
+		RememberMeExpiry:           720 * time.Hour,
+		JwtIssuer:                  "https://login.example.com",
+		JwtAudience:                "example-app",
+		JwtStaticClaims:            map[string]string{"tenant": "example", "env": "prod"},
+		JweSecret:                  "jwesecret",
+		SuccessURL:                 "successurl",
+		Redirect:                   false,
+		RedirectQueryParameter:     "comingFrom",
+		RedirectCheckReferer:       false,
+		RedirectHostFile:           "File",
+		RedirectHostWhitelist:      "example.com,*.example.org",
+		CSRFProtection:             false,
+		RedirectHostRegex:          `.*\.example\.net`,
+		LogoutURL:                  "logouturl",
+		Template:                   "template",
+		LoginPath:                  "loginpath",
+		CookieName:                 "cookiename",
+		CookieExpiry:               23 * time.Minute,
+		CookieDomain:               "*.example.com",
+		CookieHTTPOnly:             false,
+		CookieSecure:               false,
+		CookieSameSite:             "Strict",
+		CookiePath:                 "/app",
+		CookieMaxAge:               8 * time.Hour,
 		Backends: Options{
 			"simple": map[string]string{
 				"foo": "bar",
@@ -156,14 +428,106 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 				"client_secret": "bar",
 			},
 		},
-		GracePeriod:         4 * time.Second,
-		UserFile:            "users.yml",
-		UserEndpoint:        "http://test.io/claims",
-		UserEndpointToken:   "token",
-		UserEndpointTimeout: time.Second,
+		GracePeriod:             4 * time.Second,
+		ShutdownDelay:           3 * time.Second,
+		TLSCert:                 "cert.pem",
+		TLSKey:                  "key.pem",
+		TLSRedirectAddress:      ":80",
+		ACMEDomains:             "login.example.com",
+		ACMEEmail:               "admin@example.com",
+		ACMECacheDir:            "/var/lib/loginsrv",
+		ACMEDirectory:           "https://acme.example.com/directory",
+		UserFile:                "users.yml",
+		UserEndpoint:            "http://test.io/claims",
+		UserEndpointToken:       "token",
+		UserEndpointTimeout:     time.Second,
+		UserEndpointMethod:      "POST",
+		ClaimsMappingFile:       "/etc/loginsrv/claims.yml",
+		LoginPageTitle:          "title",
+		LoginPageLogoURL:        "http://example.com/logo.png",
+		DebugMode:               true,
+		OauthPrompt:             "login consent",
+		OauthStateStore:         "redis://localhost:6379/0",
+		ConsentRecordURL:        "http://example.com/consent",
+		TrackLoginStats:         true,
+		LoginStatsFile:          "stats.db",
+		LoginExpiryBuffer:       10 * time.Minute,
+		TokenValidateLimit:      10,
+		FailureLimit:            3,
+		FailureWindow:           time.Minute,
+		ConsulAddr:              "http://localhost:8500",
+		ConsulToken:             "consultoken",
+		Captcha:                 "hcaptcha",
+		CaptchaSiteKey:          "sitekey",
+		CaptchaSecret:           "captchasecret",
+		CaptchaAfterFailures:    5,
+		MetricsAddress:          ":9090",
+		RequireVerifiedAccount:  false,
+		RefreshTokenExpiry:      720 * time.Hour,
+		SecondFactor:            "totp",
+		TOTPSecretsFile:         "totp.db",
+		TOTPIssuer:              "example",
+		WebAuthnRPID:            "example.com",
+		WebAuthnOrigin:          "https://login.example.com",
+		WebAuthnCredentialsFile: "webauthn.db",
+		SAML: map[string]string{
+			"entity_id":    "https://login.example.com",
+			"idp_metadata": "idp.xml",
+		},
+		Kerberos: map[string]string{
+			"keytab": "http.keytab",
+			"realm":  "EXAMPLE.COM",
+		},
+		X509: map[string]string{
+			"ca":  "ca.pem",
+			"sub": "email",
+		},
+		TrustedHeader: map[string]string{
+			"trusted_proxies": "10.0.0.1",
+			"email":           "X-Forwarded-Email",
+		},
+		RevocationStore:         "memory",
+		SessionStore:            "memory",
+		SessionLimit:            3,
+		SessionLimitMode:        "deny",
+		DeviceFlow:              true,
+		SMTPHost:                "mail.example.com",
+		SMTPPort:                25,
+		SMTPUsername:            "smtpuser",
+		SMTPPassword:            "smtppassword",
+		SMTPFrom:                "login@example.com",
+		PublicURL:               "https://login.example.com",
+		Registration:            true,
+		RegistrationBackend:     "htpasswd",
+		RegistrationInviteCodes: "code1,code2",
+		PasswordChange:          true,
+		PasswordReset:           true,
+		PasswordBackend:         "db",
+		AdminToken:              "admintoken",
+		AuditLog:                "syslog",
+		VerifyMode:              "auth-request",
+		VerifyHeadersFile:       "headers.yml",
 	}
 
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
 	NoError(t, err)
 	Equal(t, expected, cfg)
 }
+
+func TestConfig_ThemeLogoURL(t *testing.T) {
+	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--theme-logo-url=http://example.com/logo.png"})
+	NoError(t, err)
+	Equal(t, "http://example.com/logo.png", cfg.LoginPageLogoURL)
+}
+
+func TestConfig_ParseOptions(t *testing.T) {
+	opts, err := parseOptions(`url=ldap://localhost,base_dn=ou=people\,dc=example\,dc=org`)
+	NoError(t, err)
+	Equal(t, map[string]string{
+		"url":     "ldap://localhost",
+		"base_dn": "ou=people,dc=example,dc=org",
+	}, opts)
+
+	_, err = parseOptions("foo=bar,baz")
+	Error(t, err)
+}
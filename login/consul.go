@@ -0,0 +1,243 @@
+package login
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// consulFailurePrefix is the prefix of the consul keys of the failure limiter
+const consulFailurePrefix = "loginsrv/failures/"
+
+// consulTimeout is the timeout of the consul requests, so that an unreachable consul does not block the logins
+const consulTimeout = 2 * time.Second
+
+// consulUnhealthyDuration is the time, for which consul is not asked again after an error,
+// so that each login does not wait for the timeout of an unreachable consul
+const consulUnhealthyDuration = 30 * time.Second
+
+// consulUpdateRetries is the number of attempts of an update, which conflicts with the updates of other instances
+const consulUpdateRetries = 10
+
+// consulFailureStore is a failureStore backed by the KV store of consul.
+// The entries are updated by check-and-set, so that the instances don't overwrite the failures of each other.
+type consulFailureStore struct {
+	addr   string
+	token  string
+	client *http.Client
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+}
+
+// newConsulFailureStore creates the store for the consul agent at the address, e.g. http://localhost:8500.
+// Addresses without scheme use http. The token is the ACL token of the requests, empty without ACLs.
+func newConsulFailureStore(addr, token string) (*consulFailureStore, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid consul-addr %q, expected an address like http://localhost:8500", addr)
+	}
+	return &consulFailureStore{
+		addr:   strings.TrimSuffix(u.String(), "/"),
+		token:  token,
+		client: &http.Client{Timeout: consulTimeout},
+	}, nil
+}
+
+// consulKVPair is an entry of the KV API of consul. The value is base64 encoded in the json.
+type consulKVPair struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+}
+
+// consulFailureEntry is the stored json of an entry.
+// The key is part of the value, because it is encoded in the consul key.
+type consulFailureEntry struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	Lockouts    int       `json:"lockouts"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// consulKey returns the consul key of the entry. The key is encoded, because usernames may contain slashes.
+func consulKey(key string) string {
+	return consulFailurePrefix + base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func (s *consulFailureStore) Get(key string) (*failureEntry, error) {
+	e, _, err := s.get(key)
+	return e, err
+}
+
+// get returns the entry and its modify index, which is 0 for a key without entry.
+func (s *consulFailureStore) get(key string) (*failureEntry, uint64, error) {
+	pairs, err := s.read(consulKey(key), false)
+	if err != nil || len(pairs) == 0 {
+		return nil, 0, err
+	}
+	_, e, err := decodeConsulEntry(pairs[0].Value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return e, pairs[0].ModifyIndex, nil
+}
+
+func (s *consulFailureStore) Update(key string, update func(e *failureEntry) *failureEntry) error {
+	for i := 0; i < consulUpdateRetries; i++ {
+		e, index, err := s.get(key)
+		if err != nil {
+			return err
+		}
+		updated := update(e)
+		if e == nil && updated == nil {
+			return nil
+		}
+		var value []byte
+		if updated != nil {
+			if value, err = encodeConsulEntry(key, updated); err != nil {
+				return err
+			}
+		}
+		written, err := s.write(consulKey(key), value, index)
+		if err != nil {
+			return err
+		}
+		if written {
+			return nil
+		}
+	}
+	return errors.New("can not update the failures, because of concurrent changes by other instances")
+}
+
+func (s *consulFailureStore) List() (map[string]*failureEntry, error) {
+	pairs, err := s.read(consulFailurePrefix, true)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]*failureEntry{}
+	for _, pair := range pairs {
+		key, e, err := decodeConsulEntry(pair.Value)
+		if err != nil {
+			return nil, err
+		}
+		entries[key] = e
+	}
+	return entries, nil
+}
+
+// read returns the entry of the key or, with recurse, all entries of the prefix. A missing key is no error.
+func (s *consulFailureStore) read(key string, recurse bool) ([]consulKVPair, error) {
+	u := s.addr + "/v1/kv/" + key
+	if recurse {
+		u += "?recurse=true"
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	pairs := []consulKVPair{}
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, errors.Wrap(err, "can not parse the response of consul")
+	}
+	return pairs, nil
+}
+
+// write stores the value or, if the value is nil, deletes the key.
+// It returns false, if the key was changed since the modify index. The index 0 only creates a new key.
+func (s *consulFailureStore) write(key string, value []byte, index uint64) (bool, error) {
+	method := "PUT"
+	if value == nil {
+		method = "DELETE"
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%v/v1/kv/%v?cas=%v", s.addr, key, index), bytes.NewReader(value))
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.do(req, http.StatusOK)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(b)) == "true", nil
+}
+
+// do sends the request with the ACL token and returns an error for other status codes than the expected ones.
+// After an error, consul is marked as unhealthy and the requests fail with errStoreUnavailable for the consulUnhealthyDuration.
+func (s *consulFailureStore) do(req *http.Request, expectedStatus ...int) (*http.Response, error) {
+	s.mu.Lock()
+	unhealthy := time.Now().Before(s.unhealthyUntil)
+	s.mu.Unlock()
+	if unhealthy {
+		return nil, errStoreUnavailable
+	}
+
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err == nil {
+		for _, status := range expectedStatus {
+			if resp.StatusCode == status {
+				return resp, nil
+			}
+		}
+		resp.Body.Close()
+		err = fmt.Errorf("consul responded with status %v", resp.StatusCode)
+	}
+
+	s.mu.Lock()
+	s.unhealthyUntil = time.Now().Add(consulUnhealthyDuration)
+	s.mu.Unlock()
+	return nil, err
+}
+
+func encodeConsulEntry(key string, e *failureEntry) ([]byte, error) {
+	return json.Marshal(consulFailureEntry{
+		Key:         key,
+		Failures:    e.failures,
+		LastFailure: e.lastFailure,
+		Lockouts:    e.lockouts,
+		LockedUntil: e.lockedUntil,
+	})
+}
+
+func decodeConsulEntry(value []byte) (string, *failureEntry, error) {
+	stored := consulFailureEntry{}
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return "", nil, errors.Wrap(err, "can not parse the failures")
+	}
+	return stored.Key, &failureEntry{
+		failures:    stored.Failures,
+		lastFailure: stored.LastFailure,
+		lockouts:    stored.Lockouts,
+		lockedUntil: stored.LockedUntil,
+	}, nil
+}
@@ -0,0 +1,267 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// fakeConsul implements the check-and-set of the KV API of consul.
+// The conflicts simulate concurrent changes by other instances. If the token is set, the requests need the token.
+type fakeConsul struct {
+	mu        sync.Mutex
+	index     uint64
+	pairs     map[string]consulKVPair
+	conflicts int
+	token     string
+	requests  int
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{pairs: map[string]consulKVPair{}}
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests++
+	if c.token != "" && r.Header.Get("X-Consul-Token") != c.token {
+		w.WriteHeader(403)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	switch r.Method {
+	case "GET":
+		pairs := []consulKVPair{}
+		for k, pair := range c.pairs {
+			if k == key || (r.URL.Query().Get("recurse") != "" && strings.HasPrefix(k, key)) {
+				pairs = append(pairs, pair)
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(404)
+			return
+		}
+		json.NewEncoder(w).Encode(pairs)
+	case "PUT", "DELETE":
+		cas, _ := strconv.ParseUint(r.URL.Query().Get("cas"), 10, 64)
+		if c.conflicts > 0 {
+			c.conflicts--
+			fmt.Fprint(w, "false")
+			return
+		}
+		if c.pairs[key].ModifyIndex != cas {
+			fmt.Fprint(w, "false")
+			return
+		}
+		if r.Method == "DELETE" {
+			delete(c.pairs, key)
+		} else {
+			value, _ := ioutil.ReadAll(r.Body)
+			c.index++
+			c.pairs[key] = consulKVPair{Key: key, Value: value, ModifyIndex: c.index}
+		}
+		fmt.Fprint(w, "true")
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+func TestConsulFailureStore(t *testing.T) {
+	consul := newFakeConsul()
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	s, err := newConsulFailureStore(server.URL, "")
+	NoError(t, err)
+
+	e, err := s.Get("user:bob/admin")
+	NoError(t, err)
+	Nil(t, e)
+
+	lockedUntil := time.Now().Add(time.Minute).Truncate(time.Second).UTC()
+	NoError(t, s.Update("user:bob/admin", func(e *failureEntry) *failureEntry {
+		Nil(t, e)
+		return &failureEntry{failures: 1, lockouts: 2, lockedUntil: lockedUntil}
+	}))
+	e, err = s.Get("user:bob/admin")
+	NoError(t, err)
+	Equal(t, 1, e.failures)
+	Equal(t, 2, e.lockouts)
+	True(t, lockedUntil.Equal(e.lockedUntil))
+
+	// a concurrent change retries the update with the current entry
+	consul.conflicts = 2
+	calls := 0
+	NoError(t, s.Update("user:bob/admin", func(e *failureEntry) *failureEntry {
+		calls++
+		e.failures++
+		return e
+	}))
+	Equal(t, 3, calls)
+	e, _ = s.Get("user:bob/admin")
+	Equal(t, 2, e.failures)
+
+	consul.conflicts = consulUpdateRetries
+	Error(t, s.Update("user:bob/admin", func(e *failureEntry) *failureEntry { return e }))
+
+	NoError(t, s.Update("ip:10.0.0.1", func(e *failureEntry) *failureEntry { return &failureEntry{failures: 1} }))
+	entries, err := s.List()
+	NoError(t, err)
+	Equal(t, 2, len(entries))
+	Equal(t, 1, entries["ip:10.0.0.1"].failures)
+
+	// a nil result deletes the entry
+	NoError(t, s.Update("user:bob/admin", func(e *failureEntry) *failureEntry { return nil }))
+	e, err = s.Get("user:bob/admin")
+	NoError(t, err)
+	Nil(t, e)
+
+	server.Close()
+	_, err = s.Get("ip:10.0.0.1")
+	Error(t, err)
+}
+
+func TestConsulFailureStore_Token(t *testing.T) {
+	consul := newFakeConsul()
+	consul.token = "secret"
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	s, err := newConsulFailureStore(server.URL, "secret")
+	NoError(t, err)
+	NoError(t, s.Update("ip:10.0.0.1", func(e *failureEntry) *failureEntry { return &failureEntry{failures: 1} }))
+	e, err := s.Get("ip:10.0.0.1")
+	NoError(t, err)
+	Equal(t, 1, e.failures)
+
+	s, err = newConsulFailureStore(server.URL, "wrong")
+	NoError(t, err)
+	_, err = s.Get("ip:10.0.0.1")
+	EqualError(t, err, "consul responded with status 403")
+}
+
+func TestConsulFailureStore_Unhealthy(t *testing.T) {
+	consul := newFakeConsul()
+	consul.token = "secret"
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	s, err := newConsulFailureStore(server.URL, "wrong")
+	NoError(t, err)
+	_, err = s.Get("ip:10.0.0.1")
+	Error(t, err)
+	Equal(t, 1, consul.requests)
+
+	// consul is not asked again after the error
+	_, err = s.Get("ip:10.0.0.1")
+	Equal(t, errStoreUnavailable, err)
+	Equal(t, errStoreUnavailable, s.Update("ip:10.0.0.1", func(e *failureEntry) *failureEntry { return e }))
+	_, err = s.List()
+	Equal(t, errStoreUnavailable, err)
+	Equal(t, 1, consul.requests)
+
+	// until the unhealthy duration has passed
+	s.token = "secret"
+	s.unhealthyUntil = time.Now()
+	_, err = s.Get("ip:10.0.0.1")
+	NoError(t, err)
+	Equal(t, 2, consul.requests)
+}
+
+func TestNewConsulFailureStore(t *testing.T) {
+	s, err := newConsulFailureStore("localhost:8500", "")
+	NoError(t, err)
+	Equal(t, "http://localhost:8500", s.addr)
+
+	s, err = newConsulFailureStore("https://consul.example.com/", "")
+	NoError(t, err)
+	Equal(t, "https://consul.example.com", s.addr)
+
+	_, err = newConsulFailureStore("ftp://consul.example.com", "")
+	Error(t, err)
+	_, err = newConsulFailureStore("http://", "")
+	Error(t, err)
+}
+
+func TestFailureLimiter_ConsulStore(t *testing.T) {
+	server := httptest.NewServer(newFakeConsul())
+	defer server.Close()
+
+	// two instances share the failures
+	a := newFailureLimiter(2, time.Minute)
+	b := newFailureLimiter(2, time.Minute)
+	for _, l := range []*failureLimiter{a, b} {
+		store, err := newConsulFailureStore(server.URL, "")
+		NoError(t, err)
+		l.store = store
+	}
+
+	a.Fail("user:bob")
+	b.Fail("user:bob")
+	locked, retryAfter := a.Locked("user:bob")
+	True(t, locked)
+	True(t, retryAfter > 0 && retryAfter <= time.Minute)
+	locked, _ = b.Locked("user:bob")
+	True(t, locked)
+	Equal(t, 1, len(b.Lockouts()))
+	Equal(t, "user:bob", b.Lockouts()[0].Key)
+
+	b.Reset("user:bob")
+	locked, _ = a.Locked("user:bob")
+	False(t, locked)
+
+	// an unavailable consul falls back to the local failures
+	server.Close()
+	a.Fail("user:alice")
+	a.Fail("user:alice")
+	locked, _ = a.Locked("user:alice")
+	True(t, locked)
+	Equal(t, 1, len(a.Lockouts()))
+	locked, _ = b.Locked("user:alice")
+	False(t, locked)
+}
+
+func TestFailureLimiter_ConsulStore_Sweep(t *testing.T) {
+	consul := newFakeConsul()
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	store, err := newConsulFailureStore(server.URL, "")
+	NoError(t, err)
+	l := newFailureLimiter(5, 20*time.Millisecond)
+	l.store = store
+
+	l.Fail("user:bob")
+	Equal(t, 1, len(consul.pairs))
+
+	// the expired entries are removed from consul on the next failure after the window
+	time.Sleep(50 * time.Millisecond)
+	l.Fail("user:alice")
+	Equal(t, 1, len(consul.pairs))
+	_, exist := consul.pairs[consulKey("user:alice")]
+	True(t, exist)
+}
+
+func TestNewHandler_ConsulAddr(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.ConsulAddr = "ftp://localhost"
+	_, err := NewHandler(config)
+	EqualError(t, err, `invalid consul-addr "ftp://localhost", expected an address like http://localhost:8500`)
+
+	config.ConsulAddr = "localhost:8500"
+	h, err := NewHandler(config)
+	NoError(t, err)
+	NotNil(t, h.failureLimiter.store)
+}
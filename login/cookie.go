@@ -0,0 +1,77 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// parseSameSite returns the SameSite mode for the cookie-same-site option.
+// An empty value leaves the attribute out, so the browser default applies.
+func parseSameSite(sameSite string) (http.SameSite, error) {
+	switch strings.ToLower(sameSite) {
+	case "":
+		return 0, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid cookie-same-site %q, expected Lax, Strict or None", sameSite)
+	}
+}
+
+// validateCookieConfig checks the cookie attributes of the configuration.
+func validateCookieConfig(config *Config) error {
+	sameSite, err := parseSameSite(config.CookieSameSite)
+	if err != nil {
+		return err
+	}
+	if sameSite == http.SameSiteNoneMode && !config.CookieSecure {
+		// browsers reject cookies with SameSite=None without the secure flag
+		return fmt.Errorf("cookie-same-site None requires cookie-secure")
+	}
+	if config.CookieMaxAge < 0 {
+		return fmt.Errorf("invalid cookie-max-age %v, it must not be negative", config.CookieMaxAge)
+	}
+	return nil
+}
+
+// tokenCookie returns the jwt cookie with the configured attributes.
+func (h *Handler) tokenCookie(token string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     h.config.CookieName,
+		Value:    token,
+		HttpOnly: h.config.CookieHTTPOnly,
+		Secure:   h.config.CookieSecure,
+		Path:     h.cookiePath(),
+	}
+	if h.config.CookieExpiry != 0 {
+		cookie.Expires = time.Now().Add(h.config.CookieExpiry)
+	}
+	if h.config.CookieMaxAge != 0 {
+		cookie.MaxAge = int(h.config.CookieMaxAge.Seconds())
+	}
+	h.applyCookieAttributes(cookie)
+	return cookie
+}
+
+// applyCookieAttributes sets the configured domain and SameSite attribute,
+// which are shared by all cookies of the login.
+func (h *Handler) applyCookieAttributes(cookie *http.Cookie) {
+	if h.config.CookieDomain != "" {
+		cookie.Domain = h.config.CookieDomain
+	}
+	// the value was checked on creation of the handler
+	cookie.SameSite, _ = parseSameSite(h.config.CookieSameSite)
+}
+
+func (h *Handler) cookiePath() string {
+	if h.config.CookiePath == "" {
+		return "/"
+	}
+	return h.config.CookiePath
+}
@@ -0,0 +1,84 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_CookieAttributes(t *testing.T) {
+	h := testHandler()
+	h.config.CookieSameSite = "Strict"
+	h.config.CookiePath = "/app"
+	h.config.CookieMaxAge = 8 * time.Hour
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+
+	cookie := recorder.Result().Cookies()[0]
+	Equal(t, "jwt_token", cookie.Name)
+	Equal(t, "/app", cookie.Path)
+	Equal(t, "example.com", cookie.Domain)
+	Equal(t, 8*60*60, cookie.MaxAge)
+	Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	True(t, cookie.Secure)
+	True(t, cookie.HttpOnly)
+
+	// the logout deletes the cookie with the same path and domain
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", ""))
+	Contains(t, recorder.Header().Get("Set-Cookie"), "jwt_token=delete; Path=/app; Domain=example.com;")
+	Contains(t, recorder.Header().Get("Set-Cookie"), "SameSite=Strict")
+}
+
+func TestHandler_CookieDefaultAttributes(t *testing.T) {
+	recorder := call(withCSRF(testHandler(), req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+
+	setCookie := recorder.Header().Get("Set-Cookie")
+	Contains(t, setCookie, "Path=/;")
+	NotContains(t, setCookie, "Max-Age")
+	NotContains(t, setCookie, "SameSite")
+}
+
+func TestParseSameSite(t *testing.T) {
+	for value, expected := range map[string]http.SameSite{
+		"":       0,
+		"Lax":    http.SameSiteLaxMode,
+		"strict": http.SameSiteStrictMode,
+		"None":   http.SameSiteNoneMode,
+	} {
+		sameSite, err := parseSameSite(value)
+		NoError(t, err)
+		Equal(t, expected, sameSite)
+	}
+
+	_, err := parseSameSite("sometimes")
+	Error(t, err)
+}
+
+func TestValidateCookieConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.CookieSameSite = "None"
+	NoError(t, validateCookieConfig(cfg))
+
+	cfg.CookieSecure = false
+	EqualError(t, validateCookieConfig(cfg), "cookie-same-site None requires cookie-secure")
+
+	cfg = testConfig()
+	cfg.CookieMaxAge = -time.Second
+	Error(t, validateCookieConfig(cfg))
+
+	cfg = testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	_, err := NewHandler(cfg)
+	NoError(t, err)
+
+	cfg.CookieSameSite = "sometimes"
+	_, err = NewHandler(cfg)
+	EqualError(t, err, `invalid cookie-same-site "sometimes", expected Lax, Strict or None`)
+}
@@ -0,0 +1,108 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, DELETE"
+	corsAllowedHeaders = "Accept, Accept-Language, Authorization, Content-Type"
+	// corsExposedHeaders are the response headers, which are readable by the scripts,
+	// e.g. the token of the header response mode and the refresh token
+	corsExposedHeaders = "Authorization, Retry-After, " + refreshTokenHeader
+	corsMaxAge         = "600"
+)
+
+// corsPolicy allows scripts of other origins to use the login, e.g. single-page apps.
+type corsPolicy struct {
+	// any allows all origins, but without credentials
+	any     bool
+	origins []string
+	// suffixes are the allowed subdomains of origins like https://*.example.com, as "https://" and ".example.com"
+	suffixes [][2]string
+}
+
+// newCORSPolicy parses the comma separated origins of -cors-allowed-origins.
+// It returns nil, if no origin is allowed.
+func newCORSPolicy(allowedOrigins string) (*corsPolicy, error) {
+	if strings.TrimSpace(allowedOrigins) == "" {
+		return nil, nil
+	}
+	p := &corsPolicy{}
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			p.any = true
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid cors-allowed-origins %q, expected an origin like https://app.example.com", origin)
+		}
+		if strings.HasPrefix(u.Host, "*.") {
+			p.suffixes = append(p.suffixes, [2]string{u.Scheme + "://", u.Host[1:]})
+			continue
+		}
+		p.origins = append(p.origins, origin)
+	}
+	return p, nil
+}
+
+// allowed returns true, if the origin is allowed, and whether it may send credentials.
+func (p *corsPolicy) allowed(origin string) (allowed bool, credentials bool) {
+	for _, o := range p.origins {
+		if o == origin {
+			return true, true
+		}
+	}
+	for _, s := range p.suffixes {
+		if strings.HasPrefix(origin, s[0]) && strings.HasSuffix(origin, s[1]) && len(origin) > len(s[0])+len(s[1]) {
+			return true, true
+		}
+	}
+	return p.any, false
+}
+
+// handleCORS sets the CORS headers for allowed origins and answers the preflight requests.
+// It returns true, if the request was answered.
+func (h *Handler) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	if h.cors == nil {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+	allowed, credentials := h.cors.allowed(origin)
+	if !allowed {
+		if preflight {
+			w.WriteHeader(403)
+			return true
+		}
+		// the browser blocks the response without the headers
+		return false
+	}
+
+	if credentials {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	if !preflight {
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+	w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+	w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+	w.WriteHeader(204)
+	return true
+}
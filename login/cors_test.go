@@ -0,0 +1,94 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestNewCORSPolicy(t *testing.T) {
+	p, err := newCORSPolicy("")
+	NoError(t, err)
+	Nil(t, p)
+
+	p, err = newCORSPolicy("https://app.example.com, http://localhost:3000/, https://*.example.org")
+	NoError(t, err)
+	for origin, expected := range map[string]bool{
+		"https://app.example.com":    true,
+		"http://localhost:3000":      true,
+		"https://a.example.org":      true,
+		"https://a.b.example.org":    true,
+		"http://app.example.com":     false,
+		"https://example.org":        false,
+		"http://a.example.org":       false,
+		"https://a.example.org.evil": false,
+		"https://evilexample.org":    false,
+	} {
+		allowed, credentials := p.allowed(origin)
+		Equal(t, expected, allowed, origin)
+		Equal(t, expected, credentials, origin)
+	}
+
+	p, err = newCORSPolicy("*")
+	NoError(t, err)
+	allowed, credentials := p.allowed("https://any.example.com")
+	True(t, allowed)
+	False(t, credentials)
+
+	for _, invalid := range []string{"app.example.com", "ftp://example.com", "https://example.com/path", "https://"} {
+		_, err = newCORSPolicy(invalid)
+		Error(t, err, invalid)
+	}
+}
+
+func TestHandler_CORS(t *testing.T) {
+	h := testHandler()
+	h.cors, _ = newCORSPolicy("https://app.example.com")
+
+	// preflight
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("OPTIONS", "/context/login", "", "Origin: https://app.example.com", "Access-Control-Request-Method: POST"))
+	Equal(t, 204, recorder.Code)
+	Equal(t, "https://app.example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	Equal(t, "true", recorder.Header().Get("Access-Control-Allow-Credentials"))
+	Equal(t, corsAllowedMethods, recorder.Header().Get("Access-Control-Allow-Methods"))
+	Equal(t, corsAllowedHeaders, recorder.Header().Get("Access-Control-Allow-Headers"))
+	Equal(t, "Origin", recorder.Header().Get("Vary"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("OPTIONS", "/context/login", "", "Origin: https://evil.example.com", "Access-Control-Request-Method: POST"))
+	Equal(t, 403, recorder.Code)
+	Equal(t, "", recorder.Header().Get("Access-Control-Allow-Origin"))
+
+	// login
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login?response_mode=header", `{"username": "bob", "password": "secret"}`, TypeJSON, "Origin: https://app.example.com"))
+	Equal(t, 204, recorder.Code)
+	Equal(t, "https://app.example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	Contains(t, recorder.Header().Get("Access-Control-Expose-Headers"), "Authorization")
+	NotEqual(t, "", recorder.Header().Get("Authorization"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Origin: https://evil.example.com"))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "", recorder.Header().Get("Access-Control-Allow-Origin"))
+
+	// without cors, the headers are not set
+	recorder = httptest.NewRecorder()
+	testHandler().ServeHTTP(recorder, req("OPTIONS", "/context/login", "", "Origin: https://app.example.com", "Access-Control-Request-Method: POST"))
+	NotEqual(t, 204, recorder.Code)
+	Equal(t, "", recorder.Header().Get("Access-Control-Allow-Origin"))
+	Equal(t, "", recorder.Header().Get("Vary"))
+}
+
+func TestHandler_CORS_AnyOrigin(t *testing.T) {
+	h := testHandler()
+	h.cors, _ = newCORSPolicy("*")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("OPTIONS", "/context/login", "", "Origin: https://app.example.com", "Access-Control-Request-Method: POST"))
+	Equal(t, 204, recorder.Code)
+	Equal(t, "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+	Equal(t, "", recorder.Header().Get("Access-Control-Allow-Credentials"))
+}
@@ -0,0 +1,139 @@
+package login
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// csrfParameter is the form field of the csrf token
+const csrfParameter = "csrf_token"
+
+type csrfContextKey struct{}
+
+// csrfCookie returns the cookie with the random value, to which the csrf tokens of the forms are bound.
+// It is only sent to the login path.
+func (h *Handler) csrfCookie() *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     h.config.CookieName + "_csrf",
+		HttpOnly: true,
+		Path:     h.config.LoginPath,
+		Secure:   h.config.CookieSecure,
+	}
+	h.applyCookieAttributes(cookie)
+	return cookie
+}
+
+// setCSRFCookie adds the csrf token of the csrf cookie to the context of browser requests,
+// so that the forms can embed it. The cookie is set by the GET requests of the forms, if the browser has none.
+func (h *Handler) setCSRFCookie(w http.ResponseWriter, r *http.Request) *http.Request {
+	if !h.config.CSRFProtection || !wantHTML(r) {
+		return r
+	}
+	if c, err := r.Cookie(h.csrfCookie().Name); err == nil && c.Value != "" {
+		return withCSRFToken(r, h.csrfToken(c.Value))
+	}
+	if r.Method != "GET" {
+		return r
+	}
+	return h.newCSRFCookie(w, r)
+}
+
+// newCSRFCookie sets a csrf cookie with a random value.
+func (h *Handler) newCSRFCookie(w http.ResponseWriter, r *http.Request) *http.Request {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		return r
+	}
+	cookie := h.csrfCookie()
+	cookie.Value = base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, cookie)
+	return withCSRFToken(r, h.csrfToken(cookie.Value))
+}
+
+func withCSRFToken(r *http.Request, token string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token))
+}
+
+// csrfToken signs the value of the csrf cookie with the jwt secret (double submit cookie).
+// A cookie, which an attacker sets for the login path, e.g. from a subdomain, has no valid token.
+func (h *Handler) csrfToken(cookieValue string) string {
+	mac := hmac.New(sha256.New, []byte("csrf|"+h.config.JwtSecret))
+	mac.Write([]byte(cookieValue))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// csrfFormToken returns the csrf token for the forms of the request, see setCSRFCookie.
+func csrfFormToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// validCSRF returns true, if the csrf protection is disabled
+// or the csrf_token parameter matches the csrf cookie of the request.
+func (h *Handler) validCSRF(r *http.Request) bool {
+	if !h.config.CSRFProtection {
+		return true
+	}
+	c, err := r.Cookie(h.csrfCookie().Name)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return hmac.Equal([]byte(r.FormValue(csrfParameter)), []byte(h.csrfToken(c.Value)))
+}
+
+// needsCSRFCheck returns true for the browser form logins, which would set the jwt cookie.
+// JSON requests can't be sent from other sites without CORS and the other response modes don't set a cookie.
+func (h *Handler) needsCSRFCheck(r *http.Request) bool {
+	return h.config.CSRFProtection &&
+		!strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) &&
+		h.responseMode(r) == responseModeCookie
+}
+
+// rejectCSRF responds with an error and returns true, if the form login has no valid csrf token.
+// The refresh of an existing token needs none, because it can't log in a browser as another user.
+func (h *Handler) rejectCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if h.needsCSRFCheck(r) && !h.validCSRF(r) {
+		h.respondCSRFFailure(w, r)
+		return true
+	}
+	return false
+}
+
+func (h *Handler) respondCSRFFailure(w http.ResponseWriter, r *http.Request) {
+	logging.Application(r.Header).WithField("client_ip", clientIP(r)).Warn("rejected form without valid csrf token")
+	if wantHTML(r) {
+		if csrfFormToken(r) == "" {
+			r = h.newCSRFCookie(w, r)
+		}
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(403)
+		username, _, _ := getCredentials(r)
+		writeLoginForm(w,
+			loginFormData{
+				Lang:        language(r),
+				CSRFToken:   csrfFormToken(r),
+				CSRFFailure: true,
+				Config:      h.config,
+				Reauth:      h.isReauth(r),
+				UserInfo:    model.UserInfo{Sub: username},
+			})
+		return
+	}
+	if wantJSON(r) {
+		respondJSONError(w, 403, errorInvalidCSRFToken, "Invalid CSRF token", 0)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(403)
+	fmt.Fprint(w, "Invalid CSRF token")
+}
@@ -0,0 +1,188 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+// withCSRF adds a csrf cookie and the matching csrf token to the form request of a browser.
+func withCSRF(h *Handler, r *http.Request) *http.Request {
+	r.AddCookie(&http.Cookie{Name: h.csrfCookie().Name, Value: "the-csrf-cookie"})
+	q := r.URL.Query()
+	q.Set(csrfParameter, h.csrfToken("the-csrf-cookie"))
+	r.URL.RawQuery = q.Encode()
+	return r
+}
+
+func testCSRFHandler(t *testing.T) *Handler {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	return h
+}
+
+func TestHandler_CSRF_FormContainsToken(t *testing.T) {
+	h := testCSRFHandler(t)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+
+	cookie := cookieByName(recorder, "jwt_token_csrf")
+	NotNil(t, cookie)
+	Equal(t, "/context/login", cookie.Path)
+	True(t, cookie.HttpOnly)
+	Contains(t, recorder.Body.String(), `<input name="csrf_token" type="hidden" value="`+h.csrfToken(cookie.Value)+`">`)
+
+	// an existing cookie is kept
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Cookie: jwt_token_csrf=existing"))
+	Nil(t, cookieByName(recorder, "jwt_token_csrf"))
+	Contains(t, recorder.Body.String(), `value="`+h.csrfToken("existing")+`"`)
+}
+
+func TestHandler_CSRF_Login(t *testing.T) {
+	h := testCSRFHandler(t)
+	token := h.csrfToken("the-csrf-cookie")
+	cookie := "Cookie: jwt_token_csrf=the-csrf-cookie"
+
+	tests := []struct {
+		name   string
+		body   string
+		cookie string
+		code   int
+	}{
+		{"valid token", "username=bob&password=secret&csrf_token=" + token, cookie, 303},
+		{"missing token", "username=bob&password=secret", cookie, 403},
+		{"wrong token", "username=bob&password=secret&csrf_token=wrong", cookie, 403},
+		{"missing cookie", "username=bob&password=secret&csrf_token=" + token, "Cookie: other=cookie", 403},
+		{"other cookie", "username=bob&password=secret&csrf_token=" + token, "Cookie: jwt_token_csrf=other", 403},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login", test.body, TypeForm, AcceptHTML, test.cookie))
+			Equal(t, test.code, recorder.Code)
+			if test.code == 403 {
+				Nil(t, cookieByName(recorder, "jwt_token"))
+				Contains(t, recorder.Body.String(), "The form has expired. Please try again.")
+				Contains(t, recorder.Body.String(), `name="username" value="bob"`)
+			}
+		})
+	}
+}
+
+func TestHandler_CSRF_NotRequired(t *testing.T) {
+	h := testHandler()
+
+	// json requests and responses without cookie
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret&response_mode=header", TypeForm, AcceptHTML))
+	Equal(t, 204, recorder.Code)
+
+	// refresh of an existing token
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, "Cookie: jwt_token="+token))
+	Equal(t, 303, recorder.Code)
+
+	// disabled protection
+	h.config.CSRFProtection = false
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	Nil(t, cookieByName(recorder, "jwt_token_csrf"))
+}
+
+func TestHandler_CSRF_FailureResponses(t *testing.T) {
+	h := testHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret&response_mode=cookie", TypeForm, acceptJSON))
+	Equal(t, 403, recorder.Code)
+	JSONEq(t, `{"error": "invalid_csrf_token", "error_description": "Invalid CSRF token"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret&response_mode=cookie", TypeForm))
+	Equal(t, 403, recorder.Code)
+	Equal(t, "Invalid CSRF token", recorder.Body.String())
+}
+
+func TestHandler_CSRF_Logout(t *testing.T) {
+	h := testCSRFHandler(t)
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	cookie := "Cookie: jwt_token=" + token + "; jwt_token_csrf=the-csrf-cookie"
+
+	// the logout link of the user page contains the token
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, cookie))
+	Contains(t, recorder.Body.String(), `href="/context/login?logout=true&csrf_token=`+h.csrfToken("the-csrf-cookie")+`"`)
+
+	// a logout link of another site redirects to the user page
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login?logout=true", "", AcceptHTML, cookie))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/context/login", recorder.Header().Get("Location"))
+	Nil(t, cookieByName(recorder, "jwt_token"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login?logout=true", "", cookie))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login?logout=true&csrf_token="+h.csrfToken("the-csrf-cookie"), "", AcceptHTML, cookie))
+	Equal(t, 200, recorder.Code)
+	True(t, strings.HasPrefix(cookieByName(recorder, "jwt_token").Value, "delete"))
+
+	// DELETE can't be sent by other sites without CORS
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", "", cookie))
+	Equal(t, 200, recorder.Code)
+}
+
+func cookieByName(recorder *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range recorder.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestHandler_CSRF_MagicLink(t *testing.T) {
+	backend := &testMagicLinkBackend{}
+	h := testMagicLinkHandler(backend)
+
+	// another site can't log the browser in with its own link
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", "token=valid", TypeForm, AcceptHTML))
+	Equal(t, 403, recorder.Code)
+	Nil(t, cookieByName(recorder, h.config.CookieName))
+	False(t, backend.used)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", "token=valid&csrf_token=wrong", TypeForm, AcceptHTML, "Cookie: jwt_token_csrf=the-csrf-cookie"))
+	Equal(t, 403, recorder.Code)
+	False(t, backend.used)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login/magiclink", "token=valid", TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+	NotNil(t, cookieByName(recorder, h.config.CookieName))
+	True(t, backend.used)
+}
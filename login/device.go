@@ -0,0 +1,365 @@
+package login
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+const devicePath = "/device"
+
+const (
+	deviceCodeExpiry = 10 * time.Minute
+	// devicePollInterval is the minimum time between two token requests of a device
+	devicePollInterval = 5 * time.Second
+	deviceGrantType    = "urn:ietf:params:oauth:grant-type:device_code"
+	// the user codes have no vowels, so that they never form words, as recommended in RFC 8628, section 6.1
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// deviceAuthorizationLimit is the maximum number of device authorizations per minute and client ip
+	deviceAuthorizationLimit = 10
+)
+
+// deviceAuthorization is a pending login of a device
+type deviceAuthorization struct {
+	deviceCode string
+	userCode   string
+	expiry     time.Time
+	interval   time.Duration
+	lastPoll   time.Time
+	// approved is set, when the user approved the login in the browser
+	approved bool
+	denied   bool
+	userInfo model.UserInfo
+}
+
+// deviceAuthorizer implements the device authorization grant (RFC 8628).
+// The pending authorizations are kept in memory, so they are not shared between multiple instances.
+type deviceAuthorizer struct {
+	mutex          sync.Mutex
+	byDeviceCode   map[string]*deviceAuthorization
+	byUserCode     map[string]*deviceAuthorization
+	approvalSecret []byte
+	// limiter limits the unauthenticated device authorizations, so that the pending authorizations can't fill the memory
+	limiter *rateLimiter
+}
+
+func newDeviceAuthorizer() (*deviceAuthorizer, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &deviceAuthorizer{
+		byDeviceCode:   map[string]*deviceAuthorization{},
+		byUserCode:     map[string]*deviceAuthorization{},
+		approvalSecret: secret,
+		limiter:        newRateLimiter(deviceAuthorizationLimit, time.Minute),
+	}, nil
+}
+
+// start creates a new pending authorization with a device code and a user code.
+func (d *deviceAuthorizer) start() (*deviceAuthorization, error) {
+	deviceCode, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// remove the expired authorizations, which can't be used anyway
+	now := time.Now()
+	for code, a := range d.byDeviceCode {
+		if now.After(a.expiry) {
+			delete(d.byDeviceCode, code)
+			delete(d.byUserCode, a.userCode)
+		}
+	}
+
+	var userCode string
+	for userCode == "" || d.byUserCode[userCode] != nil {
+		if userCode, err = newUserCode(); err != nil {
+			return nil, err
+		}
+	}
+
+	a := &deviceAuthorization{
+		deviceCode: deviceCode,
+		userCode:   userCode,
+		expiry:     now.Add(deviceCodeExpiry),
+		interval:   devicePollInterval,
+	}
+	d.byDeviceCode[deviceCode] = a
+	d.byUserCode[userCode] = a
+	return a, nil
+}
+
+// pending returns true, if the user code belongs to an authorization, which waits for the approval.
+func (d *deviceAuthorizer) pending(userCode string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	a, exist := d.byUserCode[userCode]
+	return exist && !a.approved && !a.denied && time.Now().Before(a.expiry)
+}
+
+// decide approves the login of the device for the user or denies it.
+// It returns false, if the user code does not belong to a pending authorization.
+func (d *deviceAuthorizer) decide(userCode string, approve bool, userInfo model.UserInfo) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	a, exist := d.byUserCode[userCode]
+	if !exist || a.approved || a.denied || time.Now().After(a.expiry) {
+		return false
+	}
+	if approve {
+		a.approved = true
+		a.userInfo = userInfo
+	} else {
+		a.denied = true
+	}
+	return true
+}
+
+// poll returns the user info of an approved authorization or the error code of the token response.
+// An approved or denied authorization is removed, so the token is only issued once.
+func (d *deviceAuthorizer) poll(deviceCode string) (model.UserInfo, string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	a, exist := d.byDeviceCode[deviceCode]
+	if !exist {
+		return model.UserInfo{}, "invalid_grant"
+	}
+
+	now := time.Now()
+	if now.After(a.expiry) {
+		delete(d.byDeviceCode, a.deviceCode)
+		delete(d.byUserCode, a.userCode)
+		return model.UserInfo{}, "expired_token"
+	}
+	if a.approved || a.denied {
+		delete(d.byDeviceCode, a.deviceCode)
+		delete(d.byUserCode, a.userCode)
+		if a.denied {
+			return model.UserInfo{}, "access_denied"
+		}
+		return a.userInfo, ""
+	}
+
+	if now.Sub(a.lastPoll) < a.interval {
+		// a device, which polls too fast, has to slow down for all further requests
+		a.interval += devicePollInterval
+		a.lastPoll = now
+		return model.UserInfo{}, "slow_down"
+	}
+	a.lastPoll = now
+	return model.UserInfo{}, "authorization_pending"
+}
+
+// approvalToken binds the approval form to the cookie of the logged in user,
+// so that a foreign page can't approve a device login for the user.
+func (d *deviceAuthorizer) approvalToken(cookieValue, userCode string) string {
+	mac := hmac.New(sha256.New, d.approvalSecret)
+	mac.Write([]byte(cookieValue + "|" + userCode))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newUserCode returns a random user code, e.g. BDFG-HJKL
+func newUserCode() (string, error) {
+	b := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(b[:userCodeLength/2]) + "-" + string(b[userCodeLength/2:]), nil
+}
+
+// normalizeUserCode accepts the user code in lower case, with spaces and without dash.
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(userCode))
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// deviceFormData holds the data of the device approval in the login form
+type deviceFormData struct {
+	UserCode      string
+	ApprovalToken string
+	// Result is approved, denied or invalid after the decision of the user
+	Result string
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceTokenResponse struct {
+	AccessToken string `json:"access_token,omitempty"`
+	TokenType   string `json:"token_type,omitempty"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// handleDevice serves the endpoints of the device authorization grant:
+// the device authorization and the token endpoint for the device and the verification page for the browser.
+func (h *Handler) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if h.device == nil {
+		h.respondNotFound(w, r)
+		return
+	}
+
+	subPath := strings.TrimPrefix(r.URL.Path, h.config.LoginPath+devicePath)
+	switch {
+	case subPath == "" && r.Method == "POST":
+		h.handleDeviceAuthorization(w, r)
+	case subPath == "/token" && r.Method == "POST":
+		h.handleDeviceToken(w, r)
+	case subPath == "/verify" || strings.HasPrefix(subPath, "/verify/"):
+		h.handleDeviceVerification(w, r, strings.TrimPrefix(strings.TrimPrefix(subPath, "/verify"), "/"))
+	case subPath == "" || subPath == "/token":
+		h.respondBadRequest(w, r)
+	default:
+		h.respondNotFound(w, r)
+	}
+}
+
+func (h *Handler) handleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if ok, retryAfter := h.device.limiter.Allow(clientIP(r)); !ok {
+		logging.Application(r.Header).WithField("client_ip", clientIP(r)).Warn("rate limit for device authorizations exceeded")
+		w.Header().Set("Retry-After", fmt.Sprintf("%v", retryAfterSeconds(retryAfter)))
+		respondJSONError(w, 429, errorRateLimited, "Too Many Requests", retryAfter)
+		return
+	}
+
+	a, err := h.device.start()
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	verificationURI := h.publicURL(h.config.LoginPath + devicePath + "/verify")
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(deviceAuthorizationResponse{
+		DeviceCode:              a.deviceCode,
+		UserCode:                a.userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "/" + a.userCode,
+		ExpiresIn:               int(deviceCodeExpiry.Seconds()),
+		Interval:                int(devicePollInterval.Seconds()),
+	}) // ignore error of encoding
+}
+
+func (h *Handler) handleDeviceToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	response := deviceTokenResponse{}
+	if r.FormValue("grant_type") != deviceGrantType {
+		response.Error = "unsupported_grant_type"
+	} else {
+		var userInfo model.UserInfo
+		userInfo, response.Error = h.device.poll(r.FormValue("device_code"))
+		if response.Error == "" {
+			userInfo.Expiry = time.Now().Add(h.config.JwtExpiry).Unix()
+			token, err := h.createToken(userInfo)
+			if err != nil {
+				logging.Application(r.Header).WithError(err).Error()
+				h.respondError(w, r)
+				return
+			}
+			logging.Application(r.Header).WithField("username", userInfo.Sub).Info("issued token for device")
+			response = deviceTokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresIn: int(h.config.JwtExpiry.Seconds())}
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Error != "" {
+		w.WriteHeader(400)
+	}
+	json.NewEncoder(w).Encode(response) // ignore error of encoding
+}
+
+// handleDeviceVerification shows the approval of the device login to the logged in user.
+// Users, who are not logged in, are sent to the login form and come back afterwards.
+func (h *Handler) handleDeviceVerification(w http.ResponseWriter, r *http.Request, pathUserCode string) {
+	if r.Method != "GET" && r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	userInfo, valid := h.GetToken(r)
+	if !valid {
+		// the redirect keeps only the path, so the user code is part of the path
+		h.setRedirectCookieTo(w, r.URL.Path)
+		w.Header().Set("Location", h.config.LoginPath)
+		w.WriteHeader(303)
+		return
+	}
+	cookie, _ := r.Cookie(h.config.CookieName)
+
+	r.ParseForm()
+	userCode := normalizeUserCode(r.FormValue("user_code"))
+	if userCode == "" {
+		userCode = normalizeUserCode(pathUserCode)
+	}
+
+	data := &deviceFormData{UserCode: userCode}
+	switch {
+	case r.Method == "POST":
+		expected := h.device.approvalToken(cookie.Value, userCode)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(r.PostFormValue("approval_token"))) != 1 {
+			h.respondBadRequest(w, r)
+			return
+		}
+		approve := r.PostFormValue("action") == "approve"
+		userInfo.ID = ""
+		userInfo.Refreshes = 0
+		switch {
+		case !h.device.decide(userCode, approve, userInfo):
+			data.Result = "invalid"
+		case approve:
+			data.Result = "approved"
+			logging.Application(r.Header).WithField("username", userInfo.Sub).Info("approved device login")
+		default:
+			data.Result = "denied"
+		}
+	case userCode != "" && !h.device.pending(userCode):
+		data.Result = "invalid"
+	}
+	if data.Result == "" && userCode != "" {
+		data.ApprovalToken = h.device.approvalToken(cookie.Value, userCode)
+	}
+
+	writeLoginForm(w,
+		loginFormData{
+			Lang:          language(r),
+			CSRFToken:     csrfFormToken(r),
+			Config:        h.config,
+			Authenticated: true,
+			UserInfo:      userInfo,
+			Device:        data,
+		})
+}
@@ -0,0 +1,187 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var approvalTokenPattern = regexp.MustCompile(`name="approval_token" type="hidden" value="([^"]+)"`)
+
+func testDeviceHandler(t *testing.T) *Handler {
+	h := testHandler()
+	device, err := newDeviceAuthorizer()
+	NoError(t, err)
+	h.device = device
+	h.config.PublicURL = "https://login.example.com"
+	return h
+}
+
+func TestHandler_DeviceFlow(t *testing.T) {
+	h := testDeviceHandler(t)
+
+	// the verification_uri is built by the public-url, not by the host of the request
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/device", "client_id=cli", TypeForm, "X-Forwarded-Proto: http", "X-Forwarded-Host: evil.example"))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	authorization := deviceAuthorizationResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &authorization))
+	NotEmpty(t, authorization.DeviceCode)
+	Regexp(t, "^[A-Z]{4}-[A-Z]{4}$", authorization.UserCode)
+	Equal(t, "https://login.example.com/context/login/device/verify", authorization.VerificationURI)
+	Equal(t, authorization.VerificationURI+"/"+authorization.UserCode, authorization.VerificationURIComplete)
+	Equal(t, 600, authorization.ExpiresIn)
+	Equal(t, 5, authorization.Interval)
+
+	poll := func() (int, deviceTokenResponse) {
+		recorder := httptest.NewRecorder()
+		body := url.Values{"grant_type": {deviceGrantType}, "device_code": {authorization.DeviceCode}}.Encode()
+		h.ServeHTTP(recorder, req("POST", "/context/login/device/token", body, TypeForm))
+		response := deviceTokenResponse{}
+		NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		return recorder.Code, response
+	}
+	code, response := poll()
+	Equal(t, 400, code)
+	Equal(t, "authorization_pending", response.Error)
+	_, response = poll()
+	Equal(t, "slow_down", response.Error)
+
+	// the user, who is not logged in, comes back to the verification after the login
+	// the browser sends the redirect cookie of the verification page to the login
+	verificationPath := "/context/login/device/verify/" + authorization.UserCode
+	browser := newBrowser(t)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", verificationPath, "", AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/context/login", recorder.Header().Get("Location"))
+	browser.store(verificationPath, recorder)
+	Equal(t, verificationPath, browser.cookie("/context/login", "backTo").Value)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML, browser.cookies("/context/login"))))
+	Equal(t, 303, recorder.Code)
+	Equal(t, verificationPath, recorder.Header().Get("Location"))
+	// the redirect cookie is deleted after the login
+	browser.store("/context/login", recorder)
+	Nil(t, browser.cookie("/context/login", "backTo"))
+	cookie := "Cookie: " + recorder.Result().Cookies()[0].Name + "=" + recorder.Result().Cookies()[0].Value
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", verificationPath, "", AcceptHTML, cookie))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), authorization.UserCode)
+	match := approvalTokenPattern.FindStringSubmatch(recorder.Body.String())
+	Equal(t, 2, len(match))
+
+	// the approval is bound to the cookie of the user
+	body := url.Values{"user_code": {authorization.UserCode}, "approval_token": {"foo"}, "action": {"approve"}}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/device/verify", body.Encode(), TypeForm, AcceptHTML, cookie))
+	Equal(t, 400, recorder.Code)
+
+	body.Set("approval_token", match[1])
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/device/verify", body.Encode(), TypeForm, AcceptHTML, cookie))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "The device is signed in")
+
+	code, response = poll()
+	Equal(t, 200, code)
+	Equal(t, "Bearer", response.TokenType)
+	Equal(t, int((24 * time.Hour).Seconds()), response.ExpiresIn)
+	claims, err := tokenAsMap(response.AccessToken)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+
+	// the token is issued only once
+	code, response = poll()
+	Equal(t, 400, code)
+	Equal(t, "invalid_grant", response.Error)
+}
+
+func TestHandler_DeviceFlow_Errors(t *testing.T) {
+	recorder := call(req("POST", "/context/login/device", ""))
+	Equal(t, 404, recorder.Code)
+
+	h := testDeviceHandler(t)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/device", ""))
+	Equal(t, 400, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/device/token", "grant_type=password", TypeForm))
+	Equal(t, 400, recorder.Code)
+	JSONEq(t, `{"error": "unsupported_grant_type"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/device/token", "grant_type="+url.QueryEscape(deviceGrantType)+"&device_code=foo", TypeForm))
+	Equal(t, 400, recorder.Code)
+	JSONEq(t, `{"error": "invalid_grant"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/device/unknown", ""))
+	Equal(t, 404, recorder.Code)
+
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.DeviceFlow = true
+	_, err := NewHandler(cfg)
+	EqualError(t, err, "device-flow requires the public-url for the verification_uri")
+}
+
+func TestDeviceAuthorizer(t *testing.T) {
+	d, err := newDeviceAuthorizer()
+	NoError(t, err)
+
+	a, err := d.start()
+	NoError(t, err)
+	True(t, d.pending(a.userCode))
+	False(t, d.pending("BBBB-CCCC"))
+	False(t, d.decide("BBBB-CCCC", true, model.UserInfo{Sub: "bob"}))
+
+	// a denied login can't be approved later
+	True(t, d.decide(a.userCode, false, model.UserInfo{Sub: "bob"}))
+	False(t, d.decide(a.userCode, true, model.UserInfo{Sub: "bob"}))
+	_, errorCode := d.poll(a.deviceCode)
+	Equal(t, "access_denied", errorCode)
+
+	a, err = d.start()
+	NoError(t, err)
+	a.expiry = time.Now().Add(-time.Second)
+	False(t, d.pending(a.userCode))
+	_, errorCode = d.poll(a.deviceCode)
+	Equal(t, "expired_token", errorCode)
+	Equal(t, 0, len(d.byDeviceCode))
+	Equal(t, 0, len(d.byUserCode))
+}
+
+func Test_normalizeUserCode(t *testing.T) {
+	Equal(t, "BDFG-HJKL", normalizeUserCode("bdfg-hjkl"))
+	Equal(t, "BDFG-HJKL", normalizeUserCode("BDFGHJKL"))
+	Equal(t, "BDFG-HJKL", normalizeUserCode(" bdfg hjkl "))
+	Equal(t, "", normalizeUserCode(""))
+}
+
+func TestHandler_DeviceFlow_RateLimit(t *testing.T) {
+	h := testDeviceHandler(t)
+	for i := 0; i < deviceAuthorizationLimit; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/device", "client_id=cli", TypeForm))
+		Equal(t, 200, recorder.Code)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/device", "client_id=cli", TypeForm))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "60", recorder.Header().Get("Retry-After"))
+	JSONEq(t, `{"error": "rate_limited", "error_description": "Too Many Requests", "retry_after": 60}`, recorder.Body.String())
+	Equal(t, deviceAuthorizationLimit, len(h.device.byDeviceCode))
+}
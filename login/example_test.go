@@ -0,0 +1,31 @@
+package login_test
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/login"
+)
+
+func ExampleNewHandler() {
+	config := login.DefaultConfig()
+	config.JwtSecret = "my-secret"
+	config.CookieSecure = false
+	config.Backends = login.Options{
+		"simple": {"bob": "secret"},
+	}
+	config.Hooks.OnLoginFailure = func(r *http.Request, username, origin, reason string) {
+		log.Printf("failed login of %v: %v", username, reason)
+	}
+
+	h, err := login.NewHandler(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/login", h)
+	mux.Handle("/login/", h)
+	log.Fatal(http.ListenAndServe(":8080", mux))
+}
@@ -0,0 +1,326 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// maxLockout is the upper bound of the exponential lockout duration
+const maxLockout = 24 * time.Hour
+
+// failureKeyUser is the prefix of the failure keys of the usernames
+const failureKeyUser = "user:"
+
+// The usernames are only slowed down by a short backoff, so that nobody can lock the account of another user
+const (
+	userBackoff    = time.Second
+	maxUserBackoff = time.Minute
+)
+
+// errStoreUnavailable is returned by a failure store, which failed shortly before and is not asked again for some time.
+// It is not logged, because the failure was already logged.
+var errStoreUnavailable = errors.New("the failure store is unavailable")
+
+// failureLimiter locks a key for some time after too many failed logins.
+// Each further lockout of the same key takes twice as long as the one before.
+type failureLimiter struct {
+	limit     int
+	window    time.Duration
+	mu        sync.Mutex
+	entries   map[string]*failureEntry
+	lastSweep time.Time
+	// store shares the failures between multiple instances. The local entries are used, if the store is unavailable.
+	store failureStore
+}
+
+type failureEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockouts    int
+	lockedUntil time.Time
+}
+
+// failureStore shares the entries of the failure limiter between multiple instances.
+type failureStore interface {
+	// Get returns the entry of the key or nil, if the key has no entry.
+	Get(key string) (*failureEntry, error)
+
+	// Update replaces the entry of the key by the result of update, which gets nil for a key without entry.
+	// A nil result deletes the entry. The update is atomic, so update is called again on concurrent changes.
+	Update(key string, update func(e *failureEntry) *failureEntry) error
+
+	// List returns the entries by key.
+	List() (map[string]*failureEntry, error)
+}
+
+func newFailureLimiter(limit int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		limit:   limit,
+		window:  window,
+		entries: map[string]*failureEntry{},
+	}
+}
+
+// Locked returns true and the remaining duration, if one of the keys is locked.
+func (l *failureLimiter) Locked(keys ...string) (bool, time.Duration) {
+	if l == nil || l.limit <= 0 {
+		return false, 0
+	}
+
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, key := range keys {
+		if d := l.lockedUntil(key).Sub(now); d > retryAfter {
+			retryAfter = d
+		}
+	}
+	return retryAfter > 0, retryAfter
+}
+
+// lockedUntil returns the end of the lockout of the key in the local entries or in the store.
+func (l *failureLimiter) lockedUntil(key string) time.Time {
+	l.mu.Lock()
+	var until time.Time
+	if e, exist := l.entries[key]; exist {
+		until = e.lockedUntil
+	}
+	l.mu.Unlock()
+
+	if l.store != nil {
+		e, err := l.store.Get(key)
+		if err != nil {
+			storeUnavailable(err)
+		} else if e != nil && e.lockedUntil.After(until) {
+			until = e.lockedUntil
+		}
+	}
+	return until
+}
+
+// Fail counts a failed login for the keys and locks the keys, which reached the limit.
+func (l *failureLimiter) Fail(keys ...string) {
+	if l == nil || l.limit <= 0 {
+		return
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	swept := l.sweep(now)
+	l.mu.Unlock()
+	if swept && l.store != nil {
+		l.sweepStore(now)
+	}
+
+	for _, key := range keys {
+		if l.store != nil {
+			err := l.store.Update(key, func(e *failureEntry) *failureEntry {
+				return l.fail(key, e, now)
+			})
+			if err == nil {
+				continue
+			}
+			storeUnavailable(err)
+		}
+		l.mu.Lock()
+		l.entries[key] = l.fail(key, l.entries[key], now)
+		l.mu.Unlock()
+	}
+}
+
+// fail counts a failed login of the key in the entry, which is nil for a key without failures.
+func (l *failureLimiter) fail(key string, e *failureEntry, now time.Time) *failureEntry {
+	if e == nil || l.expired(e, now) {
+		e = &failureEntry{}
+	} else if now.Sub(e.lastFailure) >= l.window {
+		// the lockouts are kept, but the failures are counted per window
+		e.failures = 0
+	}
+	e.failures++
+	e.lastFailure = now
+	if e.failures >= l.limit {
+		e.failures = 0
+		e.lockouts++
+		e.lockedUntil = now.Add(l.lockoutDuration(key, e.lockouts))
+	}
+	return e
+}
+
+// Reset forgets the failures of the keys, e.g. after a successful login.
+func (l *failureLimiter) Reset(keys ...string) {
+	if l == nil || l.limit <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+	l.mu.Unlock()
+
+	if l.store != nil {
+		for _, key := range keys {
+			err := l.store.Update(key, func(e *failureEntry) *failureEntry {
+				return nil
+			})
+			if err != nil {
+				storeUnavailable(err)
+			}
+		}
+	}
+}
+
+// lockout is a locked key of the failure limiter
+type lockout struct {
+	Key         string    `json:"key"`
+	Lockouts    int       `json:"lockouts"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// Lockouts returns the currently locked keys of the local entries and of the store, sorted by key.
+func (l *failureLimiter) Lockouts() []lockout {
+	lockouts := []lockout{}
+	if l == nil || l.limit <= 0 {
+		return lockouts
+	}
+
+	entries := map[string]*failureEntry{}
+	if l.store != nil {
+		stored, err := l.store.List()
+		if err != nil {
+			storeUnavailable(err)
+		} else {
+			entries = stored
+		}
+	}
+
+	l.mu.Lock()
+	for key, e := range l.entries {
+		if stored, exist := entries[key]; !exist || e.lockedUntil.After(stored.lockedUntil) {
+			entries[key] = e
+		}
+	}
+	l.mu.Unlock()
+
+	now := time.Now()
+	for key, e := range entries {
+		if e.lockedUntil.After(now) {
+			lockouts = append(lockouts, lockout{Key: key, Lockouts: e.lockouts, LockedUntil: e.lockedUntil})
+		}
+	}
+	sort.Slice(lockouts, func(i, j int) bool { return lockouts[i].Key < lockouts[j].Key })
+	return lockouts
+}
+
+// lockoutDuration returns the duration of the lockout of the key, which doubles with each lockout.
+// The usernames get a backoff, which starts at a second and is capped at a minute.
+func (l *failureLimiter) lockoutDuration(key string, lockouts int) time.Duration {
+	base, max := l.window, maxLockout
+	if strings.HasPrefix(key, failureKeyUser) {
+		base, max = userBackoff, maxUserBackoff
+	}
+	d := time.Duration(float64(base) * math.Pow(2, float64(lockouts-1)))
+	if d <= 0 || d > max {
+		return max
+	}
+	return d
+}
+
+// expired returns true, if there was no failure and no lockout for a whole window.
+func (l *failureLimiter) expired(e *failureEntry, now time.Time) bool {
+	return now.Sub(e.lastFailure) >= l.window && now.Sub(e.lockedUntil) >= l.window
+}
+
+// sweep removes the expired entries once per window, so that the map does not grow unbounded.
+// It returns true, if the window has passed since the last sweep.
+func (l *failureLimiter) sweep(now time.Time) bool {
+	if now.Sub(l.lastSweep) < l.window {
+		return false
+	}
+	l.lastSweep = now
+	for key, e := range l.entries {
+		if l.expired(e, now) {
+			delete(l.entries, key)
+		}
+	}
+	return true
+}
+
+// sweepStore removes the expired entries from the store, which has no expiry of its own.
+func (l *failureLimiter) sweepStore(now time.Time) {
+	entries, err := l.store.List()
+	if err != nil {
+		storeUnavailable(err)
+		return
+	}
+	for key, e := range entries {
+		if !l.expired(e, now) {
+			continue
+		}
+		err := l.store.Update(key, func(e *failureEntry) *failureEntry {
+			if e == nil || l.expired(e, now) {
+				return nil
+			}
+			return e
+		})
+		if err != nil {
+			storeUnavailable(err)
+			return
+		}
+	}
+}
+
+// storeUnavailable logs an error of the failure store, the failures are then limited per instance.
+func storeUnavailable(err error) {
+	if err == errStoreUnavailable {
+		return
+	}
+	logging.Logger.WithError(err).Warn("the failure store is unavailable, the failed logins are limited per instance")
+}
+
+// failureKeys returns the keys of a login attempt, which are the client ip and the username.
+func failureKeys(r *http.Request, username string) []string {
+	return []string{"ip:" + clientIP(r), failureKeyUser + username}
+}
+
+func (h *Handler) respondTooManyFailures(w http.ResponseWriter, r *http.Request, username string, retryAfter time.Duration) {
+	logging.Application(r.Header).
+		WithField("username", username).
+		WithField("client_ip", clientIP(r)).Warn("login locked after too many failed attempts")
+
+	w.Header().Set("Retry-After", fmt.Sprintf("%v", retryAfterSeconds(retryAfter)))
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(429)
+		writeLoginForm(w,
+			loginFormData{
+				Lang:      language(r),
+				CSRFToken: csrfFormToken(r),
+				Failure:   true,
+				Config:    h.config,
+				UserInfo:  model.UserInfo{Sub: username},
+			})
+		return
+	}
+
+	if wantJSON(r) {
+		// a locked username is reported as locked account, a locked client ip as rate limit
+		code := errorRateLimited
+		if locked, _ := h.failureLimiter.Locked(failureKeys(r, username)[1]); locked {
+			code = errorAccountLocked
+		}
+		respondJSONError(w, 429, code, "Too many failed login attempts", retryAfter)
+	} else {
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(429)
+		fmt.Fprint(w, translate(language(r), "Too many failed login attempts"))
+	}
+}
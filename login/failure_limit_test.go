@@ -0,0 +1,103 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestFailureLimiter(t *testing.T) {
+	l := newFailureLimiter(2, 50*time.Millisecond)
+
+	l.Fail("a")
+	locked, _ := l.Locked("a")
+	False(t, locked)
+
+	l.Fail("a")
+	locked, retryAfter := l.Locked("a")
+	True(t, locked)
+	True(t, retryAfter > 0 && retryAfter <= 50*time.Millisecond)
+
+	// the lock of one key is enough
+	locked, _ = l.Locked("b", "a")
+	True(t, locked)
+	locked, _ = l.Locked("b")
+	False(t, locked)
+
+	// the second lockout takes twice as long
+	time.Sleep(60 * time.Millisecond)
+	locked, _ = l.Locked("a")
+	False(t, locked)
+	l.Fail("a")
+	l.Fail("a")
+	locked, retryAfter = l.Locked("a")
+	True(t, locked)
+	True(t, retryAfter > 50*time.Millisecond && retryAfter <= 100*time.Millisecond)
+
+	l.Reset("a")
+	locked, _ = l.Locked("a")
+	False(t, locked)
+}
+
+func TestFailureLimiter_LockoutDuration(t *testing.T) {
+	l := newFailureLimiter(1, time.Minute)
+	Equal(t, time.Minute, l.lockoutDuration("ip:10.0.0.1", 1))
+	Equal(t, 4*time.Minute, l.lockoutDuration("ip:10.0.0.1", 3))
+	Equal(t, maxLockout, l.lockoutDuration("ip:10.0.0.1", 20))
+	Equal(t, maxLockout, l.lockoutDuration("ip:10.0.0.1", 200))
+
+	// the usernames are only slowed down
+	Equal(t, time.Second, l.lockoutDuration("user:bob", 1))
+	Equal(t, 4*time.Second, l.lockoutDuration("user:bob", 3))
+	Equal(t, maxUserBackoff, l.lockoutDuration("user:bob", 20))
+	Equal(t, maxUserBackoff, l.lockoutDuration("user:bob", 200))
+}
+
+func TestFailureLimiter_UserBackoff(t *testing.T) {
+	l := newFailureLimiter(2, time.Hour)
+	l.Fail("user:bob")
+	l.Fail("user:bob")
+	locked, retryAfter := l.Locked("user:bob")
+	True(t, locked)
+	True(t, retryAfter > 0 && retryAfter <= userBackoff)
+
+	// the account is usable again after the backoff
+	time.Sleep(userBackoff + 10*time.Millisecond)
+	locked, _ = l.Locked("user:bob")
+	False(t, locked)
+}
+
+func TestFailureLimiter_Disabled(t *testing.T) {
+	var nilLimiter *failureLimiter
+	for _, l := range []*failureLimiter{nilLimiter, newFailureLimiter(0, time.Minute)} {
+		for i := 0; i < 10; i++ {
+			l.Fail("a")
+		}
+		locked, _ := l.Locked("a")
+		False(t, locked)
+	}
+}
+
+func TestHandler_FailureLimit(t *testing.T) {
+	h := testHandler()
+	h.failureLimiter = newFailureLimiter(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+		Equal(t, 403, recorder.Code)
+	}
+
+	// even the right password is rejected now
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "60", recorder.Header().Get("Retry-After"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 429, recorder.Code)
+	Equal(t, contentTypeHTML, recorder.Header().Get("Content-Type"))
+}
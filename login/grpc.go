@@ -0,0 +1,160 @@
+package login
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/grpcapi"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// GRPCHandler returns the handler of the gRPC authentication API, which has to be served with TLS.
+func (h *Handler) GRPCHandler() http.Handler {
+	return grpcapi.NewServer(&grpcService{h: h})
+}
+
+// grpcService implements the gRPC API with the same checks as the login of the http API:
+// the hooks, the failure limit and the audit log. A captcha or a second factor can't be
+// passed by the API, so these logins are rejected.
+type grpcService struct {
+	h *Handler
+}
+
+func (s *grpcService) Authenticate(r *http.Request, req *grpcapi.AuthenticateRequest) (*grpcapi.TokenResponse, error) {
+	h := s.h
+	if req.Username == "" {
+		return nil, grpcapi.Errorf(grpcapi.InvalidArgument, "missing username")
+	}
+	if err := h.callBeforeAuth(r, req.Username, ""); err != nil {
+		return nil, grpcapi.Errorf(grpcapi.PermissionDenied, "login rejected")
+	}
+
+	keys := failureKeys(r, req.Username)
+	if locked, retryAfter := h.failureLimiter.Locked(keys...); locked {
+		h.auditEvent(r, audit.LoginFailure, req.Username, "", "locked after too many failed logins")
+		return nil, grpcapi.Errorf(grpcapi.ResourceExhausted, "too many failed logins, retry after %vs", int(math.Ceil(retryAfter.Seconds())))
+	}
+	if h.captcha.required(keys...) {
+		return nil, grpcapi.Errorf(grpcapi.ResourceExhausted, "too many failed logins, a captcha is required")
+	}
+
+	authenticated, userInfo, err := h.authenticate(r.Context(), req.Username, req.Password)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.auditEvent(r, audit.ProviderError, req.Username, "", err.Error())
+		return nil, grpcapi.Errorf(grpcapi.Internal, "authentication failed")
+	}
+
+	if !authenticated {
+		logging.Application(r.Header).
+			WithField("username", req.Username).Info("failed authentication by grpc")
+		h.auditEvent(r, audit.LoginFailure, req.Username, "", "invalid credentials")
+		h.failureLimiter.Fail(keys...)
+		h.captcha.fail(keys...)
+		return nil, grpcapi.Errorf(grpcapi.Unauthenticated, "wrong credentials")
+	}
+
+	h.failureLimiter.Reset(keys[1])
+	h.captcha.reset(keys[1])
+	if h.totp != nil {
+		return nil, grpcapi.Errorf(grpcapi.FailedPrecondition, "a second factor is required, which is not supported by the grpc api")
+	}
+	if err := h.callAfterSuccess(r, &userInfo, userInfo.Origin); err != nil {
+		return nil, grpcapi.Errorf(grpcapi.PermissionDenied, "login rejected")
+	}
+
+	logging.Application(r.Header).
+		WithField("username", req.Username).Info("successfully authenticated by grpc")
+	return s.tokenResponse(r, userInfo)
+}
+
+func (s *grpcService) Refresh(r *http.Request, req *grpcapi.RefreshRequest) (*grpcapi.TokenResponse, error) {
+	h := s.h
+	var userInfo model.UserInfo
+	switch {
+	case req.RefreshToken != "":
+		if h.refreshTokens == nil {
+			return nil, grpcapi.Errorf(grpcapi.FailedPrecondition, "refresh tokens are not enabled")
+		}
+		var valid bool
+		var err error
+		if userInfo, valid, err = h.refreshTokens.Take(req.RefreshToken); err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			return nil, grpcapi.Errorf(grpcapi.Internal, "refresh failed")
+		} else if !valid {
+			return nil, grpcapi.Errorf(grpcapi.Unauthenticated, "invalid or expired refresh token")
+		}
+
+	case req.Token != "":
+		var valid bool
+		if userInfo, valid = h.parseToken(r, req.Token); !valid {
+			return nil, grpcapi.Errorf(grpcapi.Unauthenticated, "invalid token")
+		}
+		if userInfo.Refreshes >= h.config.JwtRefreshes {
+			return nil, grpcapi.Errorf(grpcapi.PermissionDenied, "max jwt refreshes reached")
+		}
+		userInfo.Refreshes++
+
+	default:
+		return nil, grpcapi.Errorf(grpcapi.InvalidArgument, "missing token or refresh token")
+	}
+
+	logging.Application(r.Header).WithField("username", userInfo.Sub).Info("refreshed jwt by grpc")
+	h.auditEvent(r, audit.TokenRefresh, userInfo.Sub, userInfo.Origin, "")
+	return s.tokenResponse(r, userInfo)
+}
+
+func (s *grpcService) Validate(r *http.Request, req *grpcapi.ValidateRequest) (*grpcapi.ValidateResponse, error) {
+	h := s.h
+	if req.Token == "" {
+		return nil, grpcapi.Errorf(grpcapi.InvalidArgument, "missing token")
+	}
+	result := h.validateToken(r, req.Token)
+	if !result.Valid {
+		return &grpcapi.ValidateResponse{Reason: result.Reason}, nil
+	}
+
+	claims := jwt.MapClaims{}
+	if err := h.parseWithClaims(r, req.Token, claims); err != nil {
+		return &grpcapi.ValidateResponse{Reason: reasonInvalidSignature}, nil
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	response := &grpcapi.ValidateResponse{Valid: true, Claims: string(b)}
+	response.Sub, _ = claims["sub"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		response.ExpiresAt = int64(exp)
+	}
+	return response, nil
+}
+
+// tokenResponse creates the token and, if enabled, a refresh token for the user
+func (s *grpcService) tokenResponse(r *http.Request, userInfo model.UserInfo) (*grpcapi.TokenResponse, error) {
+	h := s.h
+	if h.config.SessionLimit > 0 {
+		// the tokens of the grpc api have no session, so they could not be limited
+		return nil, grpcapi.Errorf(grpcapi.FailedPrecondition, "the session limit only allows logins with a session cookie")
+	}
+	userInfo.Expiry = time.Now().Add(h.config.JwtExpiry).Unix()
+	token, err := h.createToken(userInfo)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		return nil, grpcapi.Errorf(grpcapi.Internal, "can't create the token")
+	}
+	response := &grpcapi.TokenResponse{Token: token, ExpiresAt: userInfo.Expiry}
+
+	if h.refreshTokens != nil {
+		if response.RefreshToken, _, err = h.newRefreshToken(userInfo); err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			return nil, grpcapi.Errorf(grpcapi.Internal, "can't create the refresh token")
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,105 @@
+package login
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/grpcapi"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func grpcCode(err error) grpcapi.Code {
+	if status, ok := err.(*grpcapi.Status); ok {
+		return status.Code
+	}
+	return grpcapi.OK
+}
+
+func TestGRPCService_Authenticate(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.FailureLimit = 2
+	h, err := NewHandler(config)
+	NoError(t, err)
+	defer h.Close()
+	s := &grpcService{h: h}
+
+	response, err := s.Authenticate(req("POST", "/", ""), &grpcapi.AuthenticateRequest{Username: "bob", Password: "secret"})
+	NoError(t, err)
+	userInfo, valid := h.parseToken(req("GET", "/", ""), response.Token)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, userInfo.Expiry, response.ExpiresAt)
+	Equal(t, "", response.RefreshToken)
+
+	_, err = s.Authenticate(req("POST", "/", ""), &grpcapi.AuthenticateRequest{})
+	Equal(t, grpcapi.InvalidArgument, grpcCode(err))
+
+	// the failure limit of the http api applies
+	for i := 0; i < 2; i++ {
+		_, err = s.Authenticate(req("POST", "/", ""), &grpcapi.AuthenticateRequest{Username: "bob", Password: "wrong"})
+		Equal(t, grpcapi.Unauthenticated, grpcCode(err))
+	}
+	_, err = s.Authenticate(req("POST", "/", ""), &grpcapi.AuthenticateRequest{Username: "bob", Password: "secret"})
+	Equal(t, grpcapi.ResourceExhausted, grpcCode(err))
+}
+
+func TestGRPCService_Refresh(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.RefreshTokenExpiry = time.Hour
+	h, err := NewHandler(config)
+	NoError(t, err)
+	defer h.Close()
+	s := &grpcService{h: h}
+
+	response, err := s.Authenticate(req("POST", "/", ""), &grpcapi.AuthenticateRequest{Username: "bob", Password: "secret"})
+	NoError(t, err)
+	NotEqual(t, "", response.RefreshToken)
+
+	// by the token, up to the jwt-refreshes of the config
+	refreshed, err := s.Refresh(req("POST", "/", ""), &grpcapi.RefreshRequest{Token: response.Token})
+	NoError(t, err)
+	userInfo, valid := h.parseToken(req("GET", "/", ""), refreshed.Token)
+	True(t, valid)
+	Equal(t, 1, userInfo.Refreshes)
+	_, err = s.Refresh(req("POST", "/", ""), &grpcapi.RefreshRequest{Token: refreshed.Token})
+	Equal(t, grpcapi.PermissionDenied, grpcCode(err))
+
+	// the refresh token can be used once
+	refreshed, err = s.Refresh(req("POST", "/", ""), &grpcapi.RefreshRequest{RefreshToken: response.RefreshToken})
+	NoError(t, err)
+	NotEqual(t, response.RefreshToken, refreshed.RefreshToken)
+	_, err = s.Refresh(req("POST", "/", ""), &grpcapi.RefreshRequest{RefreshToken: response.RefreshToken})
+	Equal(t, grpcapi.Unauthenticated, grpcCode(err))
+
+	_, err = s.Refresh(req("POST", "/", ""), &grpcapi.RefreshRequest{Token: "invalid"})
+	Equal(t, grpcapi.Unauthenticated, grpcCode(err))
+	_, err = s.Refresh(req("POST", "/", ""), &grpcapi.RefreshRequest{})
+	Equal(t, grpcapi.InvalidArgument, grpcCode(err))
+}
+
+func TestGRPCService_Validate(t *testing.T) {
+	h := testHandler()
+	s := &grpcService{h: h}
+	expiry := time.Now().Add(time.Minute).Unix()
+	token, err := h.createToken(model.UserInfo{Sub: "marvin", Groups: []string{"a"}, Expiry: expiry})
+	NoError(t, err)
+
+	response, err := s.Validate(req("POST", "/", ""), &grpcapi.ValidateRequest{Token: token})
+	NoError(t, err)
+	True(t, response.Valid)
+	Equal(t, "marvin", response.Sub)
+	Equal(t, expiry, response.ExpiresAt)
+	claims := map[string]interface{}{}
+	NoError(t, json.Unmarshal([]byte(response.Claims), &claims))
+	Equal(t, []interface{}{"a"}, claims["groups"])
+
+	expiredToken, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Unix() - 10})
+	NoError(t, err)
+	response, err = s.Validate(req("POST", "/", ""), &grpcapi.ValidateRequest{Token: expiredToken})
+	NoError(t, err)
+	Equal(t, &grpcapi.ValidateResponse{Reason: reasonExpired}, response)
+}
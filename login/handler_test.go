@@ -1,12 +1,20 @@
 package login
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
@@ -178,7 +186,7 @@ func TestHandler_HandleOauth(t *testing.T) {
 		authenticated bool,
 		userInfo model.UserInfo,
 		err error) {
-		return false, true, model.UserInfo{Sub: "marvin"}, nil
+		return false, true, model.UserInfo{Sub: "marvin", Verified: true}, nil
 	}
 	recorder = httptest.NewRecorder()
 	handler.ServeHTTP(recorder, req("GET", "/login/github", ""))
@@ -186,6 +194,29 @@ func TestHandler_HandleOauth(t *testing.T) {
 	token, err := tokenAsMap(recorder.Body.String())
 	NoError(t, err)
 	Equal(t, "marvin", token["sub"])
+	Equal(t, true, token["verified"])
+
+	// test unverified account
+	managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
+		startedFlow bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error) {
+		return false, true, model.UserInfo{Sub: "marvin"}, nil
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req("GET", "/login/github", ""))
+	Equal(t, 403, recorder.Code)
+
+	handler.config.RequireVerifiedAccount = false
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req("GET", "/login/github", ""))
+	Equal(t, 200, recorder.Code)
+	token, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "marvin", token["sub"])
+	Nil(t, token["verified"])
+	handler.config.RequireVerifiedAccount = true
 
 	// test error in oauth
 	managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
@@ -199,6 +230,18 @@ func TestHandler_HandleOauth(t *testing.T) {
 	handler.ServeHTTP(recorder, req("GET", "/login/github", ""))
 	Equal(t, 500, recorder.Code)
 
+	// test access denied by the provider options
+	managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
+		startedFlow bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error) {
+		return false, false, model.UserInfo{}, &oauth2.AccessDeniedError{Provider: "google", Reason: "hosted domain"}
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req("GET", "/login/github", ""))
+	Equal(t, 403, recorder.Code)
+
 	// test failure if no oauth action would be taken, because the url parameters where
 	// missing an action parts
 	managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
@@ -215,7 +258,7 @@ func TestHandler_HandleOauth(t *testing.T) {
 
 func TestHandler_LoginWeb(t *testing.T) {
 	// redirectSuccess
-	recorder := call(req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	recorder := call(withCSRF(testHandler(), req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
 	Equal(t, 303, recorder.Code)
 	Equal(t, "/", recorder.Header().Get("Location"))
 
@@ -237,7 +280,7 @@ func TestHandler_LoginWeb(t *testing.T) {
 	InDelta(t, time.Now().Add(DefaultConfig().JwtExpiry).Unix(), claims["exp"], 2)
 
 	// show the login form again after authentication failed
-	recorder = call(req("POST", "/context/login", "username=bob&password=FOOBAR", TypeForm, AcceptHTML))
+	recorder = call(withCSRF(testHandler(), req("POST", "/context/login", "username=bob&password=FOOBAR", TypeForm, AcceptHTML)))
 	Equal(t, 403, recorder.Code)
 	Contains(t, recorder.Body.String(), `class="container"`)
 	Equal(t, recorder.Header().Get("Set-Cookie"), "")
@@ -263,7 +306,7 @@ func TestHandler_SetSecureCookie(t *testing.T) {
 			cfg.CookieSecure = tt.secure
 			h.config = cfg
 
-			h.respondAuthenticatedHTML(w, r, "RANDOM_TOKEN_VALUE")
+			h.respondAuthenticatedHTML(w, r, h.tokenCookie("RANDOM_TOKEN_VALUE"))
 
 			cc := w.Result().Cookies()
 			foundCookie := false
@@ -369,12 +412,12 @@ func TestHandler_Logout(t *testing.T) {
 	checkDeleteCookei(t, recorder.Header())
 
 	// GET  + param
-	recorder = call(req("GET", "/context/login?logout=true", ""))
+	recorder = call(withCSRF(testHandler(), req("GET", "/context/login?logout=true", "")))
 	Equal(t, 200, recorder.Code)
 	checkDeleteCookei(t, recorder.Header())
 
 	// POST + param
-	recorder = call(req("POST", "/context/login", "logout=true", TypeForm))
+	recorder = call(withCSRF(testHandler(), req("POST", "/context/login", "logout=true", TypeForm)))
 	Equal(t, 200, recorder.Code)
 	checkDeleteCookei(t, recorder.Header())
 
@@ -453,6 +496,9 @@ func TestHandler_getToken_Valid(t *testing.T) {
 	}
 	userInfo, valid := h.GetToken(r)
 	True(t, valid)
+	// the token is valid from its creation
+	InDelta(t, time.Now().Unix(), userInfo.NotBefore, 1)
+	input.NotBefore = userInfo.NotBefore
 	Equal(t, input, userInfo)
 }
 
@@ -478,6 +524,7 @@ func TestHandler_ReturnUserInfoJSON(t *testing.T) {
 	output := model.UserInfo{}
 	json.Unmarshal(recorder.Body.Bytes(), &output)
 
+	input.NotBefore = output.NotBefore
 	Equal(t, input, output)
 }
 
@@ -498,7 +545,76 @@ func TestHandler_ReturnUserInfoJSON_InvalidToken(t *testing.T) {
 	Equal(t, "application/json", recorder.Header().Get("Content-Type"))
 	output := map[string]interface{}{}
 	json.Unmarshal(recorder.Body.Bytes(), &output)
-	Equal(t, map[string]interface{}{"error": "Wrong credentials"}, output)
+	Equal(t, map[string]interface{}{"error": "unauthenticated", "login_page_title": "Login"}, output)
+}
+
+func TestHandler_ReturnUserInfoJSON_Branding(t *testing.T) {
+	h := testHandler()
+	h.config.LoginPageTitle = "Example Corp"
+	h.config.LoginPageLogoURL = "https://example.com/logo.png"
+	h.config.ThemeColor = "#0a7d3e"
+	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()}
+	token, err := h.createToken(input)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Accept: application/json", "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 200, recorder.Code)
+
+	output := map[string]interface{}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &output))
+	Equal(t, "marvin", output["sub"])
+	Equal(t, "Example Corp", output["login_page_title"])
+	Equal(t, "https://example.com/logo.png", output["login_page_logo_url"])
+	Equal(t, "#0a7d3e", output["theme_color"])
+}
+
+func TestHandler_LoginForm_ExpiryBuffer(t *testing.T) {
+	h := testHandler()
+	h.config.LoginExpiryBuffer = 5 * time.Minute
+
+	// token valid for a longer time: the user info is shown
+	token, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Accept: text/html", "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Welcome marvin")
+
+	// token expires within the buffer: the login form is shown again
+	token, err = h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Accept: text/html", "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 200, recorder.Code)
+	NotContains(t, recorder.Body.String(), "Welcome marvin")
+}
+
+func TestHandler_ExpiresSoon(t *testing.T) {
+	h := testHandler()
+	h.config.LoginExpiryBuffer = 5 * time.Minute
+	True(t, h.ExpiresSoon(model.UserInfo{Expiry: time.Now().Add(time.Minute).Unix()}))
+	False(t, h.ExpiresSoon(model.UserInfo{Expiry: time.Now().Add(time.Hour).Unix()}))
+	False(t, h.ExpiresSoon(model.UserInfo{}))
+
+	h.config.LoginExpiryBuffer = 0
+	False(t, h.ExpiresSoon(model.UserInfo{Expiry: time.Now().Add(time.Minute).Unix()}))
+
+	// a buffer greater than the jwt expiry does not mark the fresh tokens
+	h.config.LoginExpiryBuffer = 5 * time.Minute
+	h.config.JwtExpiry = 2 * time.Minute
+	False(t, h.ExpiresSoon(model.UserInfo{Expiry: time.Now().Add(h.config.JwtExpiry).Unix()}))
+	True(t, h.ExpiresSoon(model.UserInfo{Expiry: time.Now().Add(30 * time.Second).Unix()}))
+	h.config.JwtExpiry = 5 * time.Minute
+	False(t, h.ExpiresSoon(model.UserInfo{Expiry: time.Now().Add(h.config.JwtExpiry).Unix()}))
+
+	// with the default buffer of 5m and a short jwt expiry, the fresh tokens are not marked either
+	config := DefaultConfig()
+	Equal(t, 5*time.Minute, config.LoginExpiryBuffer)
+	config.JwtExpiry = time.Minute
+	h = &Handler{config: config}
+	False(t, h.ExpiresSoon(model.UserInfo{Expiry: time.Now().Add(config.JwtExpiry).Unix()}))
+	True(t, h.ExpiresSoon(model.UserInfo{Expiry: time.Now().Add(10 * time.Second).Unix()}))
 }
 
 func TestHandler_signAndVerify_ES256(t *testing.T) {
@@ -513,6 +629,9 @@ func TestHandler_signAndVerify_ES256(t *testing.T) {
 	}
 	userInfo, valid := h.GetToken(r)
 	True(t, valid)
+	// the token is valid from its creation
+	InDelta(t, time.Now().Unix(), userInfo.NotBefore, 1)
+	input.NotBefore = userInfo.NotBefore
 	Equal(t, input, userInfo)
 }
 
@@ -546,6 +665,54 @@ func TestHandler_getToken_InvalidNoToken(t *testing.T) {
 	False(t, valid)
 }
 
+func TestHandler_getToken_AlgNoneRejected(t *testing.T) {
+	h := testHandler()
+	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, input).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	NoError(t, err)
+	r := &http.Request{
+		Header: http.Header{"Cookie": {h.config.CookieName + "=" + token + ";"}},
+	}
+	_, valid := h.GetToken(r)
+	False(t, valid)
+}
+
+func TestHandler_getToken_AlgMismatchRejected(t *testing.T) {
+	h := testHandler()
+	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()}
+
+	// token signed with the right secret, but a different hmac algorithm
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, input).SignedString([]byte(h.config.JwtSecret))
+	NoError(t, err)
+	r := &http.Request{
+		Header: http.Header{"Cookie": {h.config.CookieName + "=" + token + ";"}},
+	}
+	_, valid := h.GetToken(r)
+	False(t, valid)
+}
+
+func TestHandler_getToken_AsymmetricToHMACConfusionRejected(t *testing.T) {
+	h := testHandler()
+	h.config.JwtAlgo = "ES256"
+	h.config.JwtSecret = "MHcCAQEEIJKMecdA9ASkZArOu9b+cPmSiVfQaaeErHcvkqG2gVIOoAoGCCqGSM49AwEHoUQDQgAE1gae9/zJDLHeuFteUkKgVhLrwJPoA43goNacgwldOucBvVUzD0EFAcpCR+0UcOfQ99CxUyKxWtnvr9xpDIXU0w=="
+	_, _, verifyKey, err := h.signingInfo()
+	NoError(t, err)
+
+	// an attacker uses the public key as hmac secret
+	publicKey, err := x509.MarshalPKIXPublicKey(verifyKey)
+	NoError(t, err)
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})
+
+	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, input).SignedString(publicKeyPEM)
+	NoError(t, err)
+	r := &http.Request{
+		Header: http.Header{"Cookie": {h.config.CookieName + "=" + token + ";"}},
+	}
+	_, valid := h.GetToken(r)
+	False(t, valid)
+}
+
 func TestHandler_getToken_WithUserClaims(t *testing.T) {
 	h := testHandler()
 	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()}
@@ -730,3 +897,131 @@ func readCookiesParts(c *http.Cookie, parts []string) {
 		c.Unparsed = append(c.Unparsed, parts[i])
 	}
 }
+
+func TestHandler_signAndVerify_KeyFile(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	NoError(t, err)
+
+	for _, test := range []struct {
+		algo      string
+		block     *pem.Block
+		verifyKey interface{}
+	}{
+		{"RS256", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, rsaKey.Public()},
+		{"PS512", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, rsaKey.Public()},
+		{"ES256", &pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}, ecKey.Public()},
+	} {
+		t.Run(test.algo, func(t *testing.T) {
+			keyFile := writeTestKeyFile(t, test.block)
+			defer os.Remove(keyFile)
+
+			h := testHandler()
+			h.config.JwtAlgo = test.algo
+			h.config.JwtPrivateKeyFile = keyFile
+			input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()}
+			token, err := h.createToken(input)
+			NoError(t, err)
+
+			// the token can be verified with the public key only
+			_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return test.verifyKey, nil })
+			NoError(t, err)
+
+			r := &http.Request{
+				Header: http.Header{"Cookie": {h.config.CookieName + "=" + token + ";"}},
+			}
+			userInfo, valid := h.GetToken(r)
+			True(t, valid)
+			input.NotBefore = userInfo.NotBefore
+			Equal(t, input, userInfo)
+		})
+	}
+}
+
+func TestHandler_NewFromConfig_InvalidKeyFile(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtAlgo = "RS256"
+	cfg.JwtPrivateKeyFile = "/does/not/exist.pem"
+	_, err := NewHandler(cfg)
+	Error(t, err)
+
+	keyFile := writeTestKeyFile(t, &pem.Block{Type: "PRIVATE KEY", Bytes: []byte("no key")})
+	defer os.Remove(keyFile)
+	cfg.JwtPrivateKeyFile = keyFile
+	_, err = NewHandler(cfg)
+	Error(t, err)
+
+	cfg.JwtAlgo = "HS512"
+	_, err = NewHandler(cfg)
+	EqualError(t, err, "a jwt private key file can only be used with the RS, PS and ES algorithms")
+}
+
+func writeTestKeyFile(t *testing.T, block *pem.Block) string {
+	f, err := ioutil.TempFile("", "loginsrv_key")
+	NoError(t, err)
+	defer f.Close()
+	NoError(t, pem.Encode(f, block))
+	return f.Name()
+}
+
+func TestHandler_JwtClaims(t *testing.T) {
+	file, cleanup := createClaimsFile(`env: 'test'`)
+	defer cleanup()
+
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtIssuer = "https://login.example.com"
+	cfg.JwtAudience = "example"
+	cfg.JwtStaticClaims = map[string]string{"tenant": "acme", "env": "prod"}
+	cfg.ClaimsMappingFile = file
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, "https://login.example.com", claims["iss"])
+	Equal(t, "example", claims["aud"])
+	InDelta(t, time.Now().Unix(), claims["nbf"], 1)
+	Equal(t, "acme", claims["tenant"])
+	// the mapped claims take precedence over the static claims
+	Equal(t, "test", claims["env"])
+
+	_, valid := h.parseToken(req("GET", "/context/login", ""), token)
+	True(t, valid)
+
+	// tokens of another issuer are rejected
+	h.config.JwtIssuer = "https://other.example.com"
+	_, valid = h.parseToken(req("GET", "/context/login", ""), token)
+	False(t, valid)
+
+	cfg.JwtStaticClaims = map[string]string{"sub": "admin"}
+	_, err = NewHandler(cfg)
+	EqualError(t, err, `claim "sub" can't be set as static claim`)
+}
+
+func TestHandler_NewFromConfig_PublicURL(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	for _, publicURL := range []string{"", "https://login.example.com", "http://localhost:6789/", "https://example.com/auth"} {
+		cfg.PublicURL = publicURL
+		_, err := NewHandler(cfg)
+		NoError(t, err, publicURL)
+	}
+	for _, publicURL := range []string{"login.example.com", "/login", "ftp://example.com", "https://example.com/?a=b"} {
+		cfg.PublicURL = publicURL
+		_, err := NewHandler(cfg)
+		Error(t, err, publicURL)
+	}
+
+	h := &Handler{config: &Config{PublicURL: "https://example.com/auth/"}}
+	Equal(t, "https://example.com/auth/login/password/reset", h.publicURL("/login/password/reset"))
+}
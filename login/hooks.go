@@ -0,0 +1,125 @@
+package login
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// Hook is an extension of the login, e.g. for custom checks like ip allowlists
+// or side effects like the provisioning of users. Hooks are registered with RegisterHook
+// and are called by all handlers in the order of the registration.
+type Hook interface {
+	// BeforeAuth is called before the credentials of a user are checked.
+	// The username is empty for logins with oauth, saml, passkeys and login links, which are identified by the origin.
+	// For oauth, it is called when the flow is started and on the callback.
+	// An error rejects the login.
+	BeforeAuth(r *http.Request, username, origin string) error
+
+	// AfterSuccess is called after the successful authentication, before the token is created.
+	// An error rejects the login, e.g. if the user can't be provisioned.
+	AfterSuccess(r *http.Request, userInfo model.UserInfo) error
+
+	// AfterFailure is called after a failed or rejected login, also if the provider returned an error.
+	// The username is empty, if it is unknown.
+	AfterFailure(r *http.Request, username, origin, reason string)
+}
+
+var hooks []Hook
+
+// RegisterHook adds a hook to all handlers. It is not safe for concurrent use and
+// should be called in the init function of a package or before the handlers are created.
+func RegisterHook(hook Hook) {
+	hooks = append(hooks, hook)
+}
+
+// beforeAuth calls the registered hooks and responds with a failure, if a hook rejects the login
+func (h *Handler) beforeAuth(w http.ResponseWriter, r *http.Request, username, origin string) bool {
+	if err := h.callBeforeAuth(r, username, origin); err != nil {
+		h.respondAuthFailure(w, r)
+		return false
+	}
+	return true
+}
+
+// callBeforeAuth calls the registered hooks and returns the error of the hook, which rejects the login
+func (h *Handler) callBeforeAuth(r *http.Request, username, origin string) error {
+	for _, hook := range hooks {
+		if err := hook.BeforeAuth(r, username, origin); err != nil {
+			logging.Application(r.Header).
+				WithField("username", username).
+				WithField("origin", origin).
+				WithError(err).Info("login rejected by hook")
+			h.auditEvent(r, audit.LoginFailure, username, origin, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// completeLogin calls the registered hooks and responds with the token of the authenticated user,
+// if no hook rejects the login
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo, origin string) {
+	if err := h.callAfterSuccess(r, &userInfo, origin); err != nil {
+		h.respondAuthFailure(w, r)
+		return
+	}
+	userInfo.AuthTime = time.Now().Unix()
+	h.respondAuthenticated(w, r, userInfo)
+}
+
+// callAfterSuccess calls the registered hooks and returns the error of the hook, which rejects the login.
+// For an accepted login, the login statistics are added to the user info.
+func (h *Handler) callAfterSuccess(r *http.Request, userInfo *model.UserInfo, origin string) error {
+	for _, hook := range hooks {
+		if err := hook.AfterSuccess(r, *userInfo); err != nil {
+			logging.Application(r.Header).
+				WithField("username", userInfo.Sub).
+				WithField("origin", origin).
+				WithError(err).Info("login rejected by hook")
+			h.auditEvent(r, audit.LoginFailure, userInfo.Sub, origin, err.Error())
+			return err
+		}
+	}
+	h.auditEvent(r, audit.LoginSuccess, userInfo.Sub, origin, "")
+	h.applyLoginStats(userInfo)
+	return nil
+}
+
+// Hooks are the callbacks of an application, which embeds the login handler.
+// They are called synchronously within the request, so they should return fast.
+// Nil callbacks are skipped.
+type Hooks struct {
+	// OnLoginSuccess is called after the successful login of a user with any backend or provider
+	OnLoginSuccess func(r *http.Request, username, origin string)
+
+	// OnLoginFailure is called after a failed login, also if the provider returned an error.
+	// The username is empty, if it is unknown, e.g. for a failed oauth flow.
+	OnLoginFailure func(r *http.Request, username, origin, reason string)
+
+	// OnLogout is called, when a logged in user logs out
+	OnLogout func(r *http.Request, username, origin string)
+}
+
+func (callbacks Hooks) call(r *http.Request, eventType audit.EventType, username, origin, reason string) {
+	switch eventType {
+	case audit.LoginSuccess:
+		if callbacks.OnLoginSuccess != nil {
+			callbacks.OnLoginSuccess(r, username, origin)
+		}
+	case audit.LoginFailure, audit.ProviderError:
+		if callbacks.OnLoginFailure != nil {
+			callbacks.OnLoginFailure(r, username, origin, reason)
+		}
+		for _, hook := range hooks {
+			hook.AfterFailure(r, username, origin, reason)
+		}
+	case audit.Logout:
+		if callbacks.OnLogout != nil {
+			callbacks.OnLogout(r, username, origin)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package login
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHooks(t *testing.T) {
+	events := []string{}
+	config := DefaultConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.Hooks = Hooks{
+		OnLoginSuccess: func(r *http.Request, username, origin string) {
+			events = append(events, "success "+username+" "+origin)
+		},
+		OnLoginFailure: func(r *http.Request, username, origin, reason string) {
+			events = append(events, "failure "+username+" "+reason)
+		},
+		OnLogout: func(r *http.Request, username, origin string) {
+			events = append(events, "logout "+username)
+		},
+	}
+	h, err := NewHandler(config)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/login", "", "Cookie: "+config.CookieName+"="+token))
+
+	Equal(t, []string{"success bob simple", "failure bob invalid credentials", "logout bob"}, events)
+}
+
+type testHook struct {
+	rejectBefore  string
+	rejectSuccess string
+	events        []string
+}
+
+func (hook *testHook) BeforeAuth(r *http.Request, username, origin string) error {
+	hook.events = append(hook.events, "before "+username)
+	if username == hook.rejectBefore {
+		return errors.New("not allowed")
+	}
+	return nil
+}
+
+func (hook *testHook) AfterSuccess(r *http.Request, userInfo model.UserInfo) error {
+	hook.events = append(hook.events, "success "+userInfo.Sub)
+	if userInfo.Sub == hook.rejectSuccess {
+		return errors.New("can't provision")
+	}
+	return nil
+}
+
+func (hook *testHook) AfterFailure(r *http.Request, username, origin, reason string) {
+	hook.events = append(hook.events, "failure "+username+" "+reason)
+}
+
+func TestHook(t *testing.T) {
+	hook := &testHook{rejectBefore: "alice", rejectSuccess: "carol"}
+	RegisterHook(hook)
+	defer func() { hooks = nil }()
+
+	config := DefaultConfig()
+	config.Backends = Options{"simple": {"bob": "secret", "alice": "secret", "carol": "secret"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+
+	for _, test := range []struct {
+		username string
+		code     int
+	}{
+		{"bob", 200},
+		{"alice", 403},
+		{"carol", 403},
+		{"dave", 403},
+	} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/login", `{"username": "`+test.username+`", "password": "secret"}`, TypeJSON, AcceptJwt))
+		Equal(t, test.code, recorder.Code, test.username)
+	}
+
+	Equal(t, []string{
+		"before bob", "success bob",
+		"before alice", "failure alice not allowed",
+		"before carol", "success carol", "failure carol can't provision",
+		"before dave", "failure dave invalid credentials",
+	}, hook.events)
+}
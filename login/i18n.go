@@ -0,0 +1,335 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultLanguage = "en"
+
+	// langParameter selects the language of the login form, overriding the Accept-Language header
+	langParameter = "lang"
+	langCookie    = "lang"
+)
+
+// catalogs contains the translations of the login form and the error messages.
+// The english text is the key, so a missing translation falls back to english.
+var catalogs = map[string]map[string]string{
+	"en": {},
+	"de": {
+		"Welcome %v!":     "Willkommen %v!",
+		"Add a passkey":   "Passkey hinzufügen",
+		"Change password": "Passwort ändern",
+		"Logout":          "Abmelden",
+		"Remember me":     "Angemeldet bleiben",
+		"Your sessions":   "Ihre Sitzungen",
+		"Unknown device":  "Unbekanntes Gerät",
+		"This device":     "Dieses Gerät",
+		"Sign out":        "Abmelden",
+		"You are signed in on too many devices. Please sign out on another device.": "Sie sind auf zu vielen Geräten angemeldet. Bitte melden Sie sich auf einem anderen Gerät ab.",
+		"Confirm your identity":                               "Bestätigen Sie Ihre Identität",
+		"Please enter your password again to continue.":       "Bitte geben Sie Ihr Passwort erneut ein, um fortzufahren.",
+		"Sign in on a device":                                 "Auf einem Gerät anmelden",
+		"Invalid or expired code":                             "Ungültiger oder abgelaufener Code",
+		"The device is signed in. You can close this window.": "Das Gerät ist angemeldet. Sie können dieses Fenster schließen.",
+		"The sign in of the device was denied.":               "Die Anmeldung des Geräts wurde abgelehnt.",
+		"A device wants to sign in as %v. Only continue, if the device shows this code:": "Ein Gerät möchte sich als %v anmelden. Fahren Sie nur fort, wenn das Gerät diesen Code anzeigt:",
+		"Approve":                     "Bestätigen",
+		"Deny":                        "Ablehnen",
+		"Code":                        "Code",
+		"Continue":                    "Weiter",
+		"Passkey login failed: ":      "Die Anmeldung mit dem Passkey ist fehlgeschlagen: ",
+		"The passkey was added.":      "Der Passkey wurde hinzugefügt.",
+		"Adding the passkey failed: ": "Das Hinzufügen des Passkeys ist fehlgeschlagen: ",
+		"Two-factor authentication":   "Zwei-Faktor-Authentifizierung",
+		"Invalid code":                "Ungültiger Code",
+		"Scan the QR code with your authenticator app and enter the code to finish the setup.": "Scannen Sie den QR-Code mit Ihrer Authenticator-App und geben Sie den Code ein, um die Einrichtung abzuschließen.",
+		"Verify":             "Prüfen",
+		"Sign in with email": "Mit E-Mail anmelden",
+		"The link is invalid, expired or was already used":                                   "Der Link ist ungültig, abgelaufen oder wurde bereits verwendet",
+		"If the address is allowed to sign in, we have sent you an email with a login link.": "Wenn sich die Adresse anmelden darf, haben wir Ihnen eine E-Mail mit einem Anmeldelink gesendet.",
+		"Continue to sign in":          "Weiter zur Anmeldung",
+		"Email":                        "E-Mail",
+		"Send login link":              "Anmeldelink senden",
+		"Create an account":            "Konto erstellen",
+		"Your account %v was created.": "Ihr Konto %v wurde erstellt.",
+		"Sign in":                      "Anmelden",
+		"We have sent you an email with a link to confirm your address and to choose your password.": "Wir haben Ihnen eine E-Mail mit einem Link gesendet, um Ihre Adresse zu bestätigen und Ihr Passwort zu wählen.",
+		"Password":                   "Passwort",
+		"Confirm password":           "Passwort bestätigen",
+		"Create account":             "Konto erstellen",
+		"Username":                   "Benutzername",
+		"Invite code":                "Einladungscode",
+		"Register":                   "Registrieren",
+		"Reset password":             "Passwort zurücksetzen",
+		"Your password was changed.": "Ihr Passwort wurde geändert.",
+		"If the account has an email address, we have sent you an email with a link to choose a new password.": "Wenn das Konto eine E-Mail-Adresse hat, haben wir Ihnen eine E-Mail mit einem Link gesendet, um ein neues Passwort zu wählen.",
+		"New password":         "Neues Passwort",
+		"Confirm new password": "Neues Passwort bestätigen",
+		"Set password":         "Passwort setzen",
+		"Send reset link":      "Link zum Zurücksetzen senden",
+		"Current password":     "Aktuelles Passwort",
+		"Sign in with %v":      "Mit %v anmelden",
+		"or":                   "oder",
+		"Invalid credentials":  "Ungültige Anmeldedaten",
+		"Please confirm, that you are not a robot": "Bitte bestätigen Sie, dass Sie kein Roboter sind",
+		"Login":                   "Anmelden",
+		"Forgot your password?":   "Passwort vergessen?",
+		"Sign in with a passkey":  "Mit einem Passkey anmelden",
+		"Internal Error.":         "Interner Fehler.",
+		"Please try again later.": "Bitte versuchen Sie es später erneut.",
+		"The password of your account can't be changed here": "Das Passwort Ihres Kontos kann hier nicht geändert werden",
+		"The current password is wrong":                      "Das aktuelle Passwort ist falsch",
+		"The link is invalid or expired":                     "Der Link ist ungültig oder abgelaufen",
+		"Please enter your username":                         "Bitte geben Sie Ihren Benutzernamen ein",
+		"Invalid invite code":                                "Ungültiger Einladungscode",
+		"Invalid username":                                   "Ungültiger Benutzername",
+		"Invalid email address":                              "Ungültige E-Mail-Adresse",
+		"The username is already taken":                      "Der Benutzername ist bereits vergeben",
+		msgPasswordTooShort:                                  fmt.Sprintf("Das Passwort muss mindestens %v Zeichen haben", minPasswordLength),
+		"The passwords do not match":                         "Die Passwörter stimmen nicht überein",
+		"Wrong credentials":                                  "Falsche Anmeldedaten",
+		"Too many failed login attempts":                     "Zu viele fehlgeschlagene Anmeldeversuche",
+		"Captcha required":                                   "Captcha erforderlich",
+		"The form has expired. Please try again.":            "Das Formular ist abgelaufen. Bitte versuchen Sie es erneut.",
+	},
+	"fr": {
+		"Welcome %v!":     "Bienvenue %v !",
+		"Add a passkey":   "Ajouter une clé d'accès",
+		"Change password": "Changer le mot de passe",
+		"Logout":          "Se déconnecter",
+		"Remember me":     "Se souvenir de moi",
+		"Your sessions":   "Vos sessions",
+		"Unknown device":  "Appareil inconnu",
+		"This device":     "Cet appareil",
+		"Sign out":        "Se déconnecter",
+		"You are signed in on too many devices. Please sign out on another device.": "Vous êtes connecté sur trop d'appareils. Veuillez vous déconnecter sur un autre appareil.",
+		"Confirm your identity":                               "Confirmez votre identité",
+		"Please enter your password again to continue.":       "Veuillez saisir à nouveau votre mot de passe pour continuer.",
+		"Sign in on a device":                                 "Se connecter sur un appareil",
+		"Invalid or expired code":                             "Code invalide ou expiré",
+		"The device is signed in. You can close this window.": "L'appareil est connecté. Vous pouvez fermer cette fenêtre.",
+		"The sign in of the device was denied.":               "La connexion de l'appareil a été refusée.",
+		"A device wants to sign in as %v. Only continue, if the device shows this code:": "Un appareil veut se connecter en tant que %v. Continuez uniquement si l'appareil affiche ce code :",
+		"Approve":                     "Approuver",
+		"Deny":                        "Refuser",
+		"Code":                        "Code",
+		"Continue":                    "Continuer",
+		"Passkey login failed: ":      "La connexion avec la clé d'accès a échoué : ",
+		"The passkey was added.":      "La clé d'accès a été ajoutée.",
+		"Adding the passkey failed: ": "L'ajout de la clé d'accès a échoué : ",
+		"Two-factor authentication":   "Authentification à deux facteurs",
+		"Invalid code":                "Code invalide",
+		"Scan the QR code with your authenticator app and enter the code to finish the setup.": "Scannez le code QR avec votre application d'authentification et saisissez le code pour terminer la configuration.",
+		"Verify":             "Vérifier",
+		"Sign in with email": "Se connecter par e-mail",
+		"The link is invalid, expired or was already used":                                   "Le lien est invalide, expiré ou a déjà été utilisé",
+		"If the address is allowed to sign in, we have sent you an email with a login link.": "Si l'adresse est autorisée à se connecter, nous vous avons envoyé un e-mail avec un lien de connexion.",
+		"Continue to sign in":          "Continuer la connexion",
+		"Email":                        "E-mail",
+		"Send login link":              "Envoyer le lien de connexion",
+		"Create an account":            "Créer un compte",
+		"Your account %v was created.": "Votre compte %v a été créé.",
+		"Sign in":                      "Se connecter",
+		"We have sent you an email with a link to confirm your address and to choose your password.": "Nous vous avons envoyé un e-mail avec un lien pour confirmer votre adresse et choisir votre mot de passe.",
+		"Password":                   "Mot de passe",
+		"Confirm password":           "Confirmer le mot de passe",
+		"Create account":             "Créer le compte",
+		"Username":                   "Nom d'utilisateur",
+		"Invite code":                "Code d'invitation",
+		"Register":                   "S'inscrire",
+		"Reset password":             "Réinitialiser le mot de passe",
+		"Your password was changed.": "Votre mot de passe a été changé.",
+		"If the account has an email address, we have sent you an email with a link to choose a new password.": "Si le compte a une adresse e-mail, nous vous avons envoyé un e-mail avec un lien pour choisir un nouveau mot de passe.",
+		"New password":         "Nouveau mot de passe",
+		"Confirm new password": "Confirmer le nouveau mot de passe",
+		"Set password":         "Définir le mot de passe",
+		"Send reset link":      "Envoyer le lien de réinitialisation",
+		"Current password":     "Mot de passe actuel",
+		"Sign in with %v":      "Se connecter avec %v",
+		"or":                   "ou",
+		"Invalid credentials":  "Identifiants invalides",
+		"Please confirm, that you are not a robot": "Veuillez confirmer que vous n'êtes pas un robot",
+		"Login":                   "Connexion",
+		"Forgot your password?":   "Mot de passe oublié ?",
+		"Sign in with a passkey":  "Se connecter avec une clé d'accès",
+		"Internal Error.":         "Erreur interne.",
+		"Please try again later.": "Veuillez réessayer plus tard.",
+		"The password of your account can't be changed here": "Le mot de passe de votre compte ne peut pas être changé ici",
+		"The current password is wrong":                      "Le mot de passe actuel est incorrect",
+		"The link is invalid or expired":                     "Le lien est invalide ou expiré",
+		"Please enter your username":                         "Veuillez saisir votre nom d'utilisateur",
+		"Invalid invite code":                                "Code d'invitation invalide",
+		"Invalid username":                                   "Nom d'utilisateur invalide",
+		"Invalid email address":                              "Adresse e-mail invalide",
+		"The username is already taken":                      "Le nom d'utilisateur est déjà pris",
+		msgPasswordTooShort:                                  fmt.Sprintf("Le mot de passe doit contenir au moins %v caractères", minPasswordLength),
+		"The passwords do not match":                         "Les mots de passe ne correspondent pas",
+		"Wrong credentials":                                  "Identifiants incorrects",
+		"Too many failed login attempts":                     "Trop de tentatives de connexion échouées",
+		"Captcha required":                                   "Captcha requis",
+		"The form has expired. Please try again.":            "Le formulaire a expiré. Veuillez réessayer.",
+	},
+	"es": {
+		"Welcome %v!":     "¡Bienvenido %v!",
+		"Add a passkey":   "Añadir una llave de acceso",
+		"Change password": "Cambiar la contraseña",
+		"Logout":          "Cerrar sesión",
+		"Remember me":     "Recordarme",
+		"Your sessions":   "Sus sesiones",
+		"Unknown device":  "Dispositivo desconocido",
+		"This device":     "Este dispositivo",
+		"Sign out":        "Cerrar sesión",
+		"You are signed in on too many devices. Please sign out on another device.": "Ha iniciado sesión en demasiados dispositivos. Cierre la sesión en otro dispositivo.",
+		"Confirm your identity":                               "Confirme su identidad",
+		"Please enter your password again to continue.":       "Introduzca su contraseña de nuevo para continuar.",
+		"Sign in on a device":                                 "Iniciar sesión en un dispositivo",
+		"Invalid or expired code":                             "Código no válido o caducado",
+		"The device is signed in. You can close this window.": "El dispositivo ha iniciado sesión. Puede cerrar esta ventana.",
+		"The sign in of the device was denied.":               "Se denegó el inicio de sesión del dispositivo.",
+		"A device wants to sign in as %v. Only continue, if the device shows this code:": "Un dispositivo quiere iniciar sesión como %v. Continúe solo si el dispositivo muestra este código:",
+		"Approve":                     "Aprobar",
+		"Deny":                        "Denegar",
+		"Code":                        "Código",
+		"Continue":                    "Continuar",
+		"Passkey login failed: ":      "El inicio de sesión con la llave de acceso falló: ",
+		"The passkey was added.":      "Se añadió la llave de acceso.",
+		"Adding the passkey failed: ": "No se pudo añadir la llave de acceso: ",
+		"Two-factor authentication":   "Autenticación de dos factores",
+		"Invalid code":                "Código no válido",
+		"Scan the QR code with your authenticator app and enter the code to finish the setup.": "Escanee el código QR con su aplicación de autenticación e introduzca el código para finalizar la configuración.",
+		"Verify":             "Verificar",
+		"Sign in with email": "Iniciar sesión con correo electrónico",
+		"The link is invalid, expired or was already used":                                   "El enlace no es válido, ha caducado o ya se utilizó",
+		"If the address is allowed to sign in, we have sent you an email with a login link.": "Si la dirección puede iniciar sesión, le hemos enviado un correo electrónico con un enlace de acceso.",
+		"Continue to sign in":          "Continuar con el inicio de sesión",
+		"Email":                        "Correo electrónico",
+		"Send login link":              "Enviar enlace de acceso",
+		"Create an account":            "Crear una cuenta",
+		"Your account %v was created.": "Se creó su cuenta %v.",
+		"Sign in":                      "Iniciar sesión",
+		"We have sent you an email with a link to confirm your address and to choose your password.": "Le hemos enviado un correo electrónico con un enlace para confirmar su dirección y elegir su contraseña.",
+		"Password":                   "Contraseña",
+		"Confirm password":           "Confirmar la contraseña",
+		"Create account":             "Crear cuenta",
+		"Username":                   "Nombre de usuario",
+		"Invite code":                "Código de invitación",
+		"Register":                   "Registrarse",
+		"Reset password":             "Restablecer la contraseña",
+		"Your password was changed.": "Se cambió su contraseña.",
+		"If the account has an email address, we have sent you an email with a link to choose a new password.": "Si la cuenta tiene una dirección de correo electrónico, le hemos enviado un correo con un enlace para elegir una nueva contraseña.",
+		"New password":         "Nueva contraseña",
+		"Confirm new password": "Confirmar la nueva contraseña",
+		"Set password":         "Establecer contraseña",
+		"Send reset link":      "Enviar enlace de restablecimiento",
+		"Current password":     "Contraseña actual",
+		"Sign in with %v":      "Iniciar sesión con %v",
+		"or":                   "o",
+		"Invalid credentials":  "Credenciales no válidas",
+		"Please confirm, that you are not a robot": "Confirme que no es un robot",
+		"Login":                   "Iniciar sesión",
+		"Forgot your password?":   "¿Olvidó su contraseña?",
+		"Sign in with a passkey":  "Iniciar sesión con una llave de acceso",
+		"Internal Error.":         "Error interno.",
+		"Please try again later.": "Inténtelo de nuevo más tarde.",
+		"The password of your account can't be changed here": "La contraseña de su cuenta no se puede cambiar aquí",
+		"The current password is wrong":                      "La contraseña actual es incorrecta",
+		"The link is invalid or expired":                     "El enlace no es válido o ha caducado",
+		"Please enter your username":                         "Introduzca su nombre de usuario",
+		"Invalid invite code":                                "Código de invitación no válido",
+		"Invalid username":                                   "Nombre de usuario no válido",
+		"Invalid email address":                              "Dirección de correo electrónico no válida",
+		"The username is already taken":                      "El nombre de usuario ya está en uso",
+		msgPasswordTooShort:                                  fmt.Sprintf("La contraseña debe tener al menos %v caracteres", minPasswordLength),
+		"The passwords do not match":                         "Las contraseñas no coinciden",
+		"Wrong credentials":                                  "Credenciales incorrectas",
+		"Too many failed login attempts":                     "Demasiados intentos de inicio de sesión fallidos",
+		"Captcha required":                                   "Captcha requerido",
+		"The form has expired. Please try again.":            "El formulario ha caducado. Inténtelo de nuevo.",
+	},
+}
+
+// translate returns the message in the language, formatted with the args.
+func translate(lang, message string, args ...interface{}) string {
+	if translated, exist := catalogs[lang][message]; exist {
+		message = translated
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// language returns the language of the request: the lang query parameter,
+// the language stored by setLanguageCookie or the best match of the Accept-Language header.
+func language(r *http.Request) string {
+	if lang := r.URL.Query().Get(langParameter); supportedLanguage(lang) {
+		return lang
+	}
+	if c, err := r.Cookie(langCookie); err == nil && supportedLanguage(c.Value) {
+		return c.Value
+	}
+	return acceptedLanguage(r.Header.Get("Accept-Language"))
+}
+
+func supportedLanguage(lang string) bool {
+	_, exist := catalogs[lang]
+	return exist
+}
+
+// acceptedLanguage returns the supported language with the highest quality of the Accept-Language header,
+// e.g. de for "de-CH, fr;q=0.9". Regions are ignored.
+func acceptedLanguage(header string) string {
+	type accepted struct {
+		lang    string
+		quality float64
+	}
+	var languages []accepted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.Index(lang, "-"); i != -1 {
+			lang = lang[:i]
+		}
+		if !supportedLanguage(lang) {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			languages = append(languages, accepted{lang, quality})
+		}
+	}
+	if len(languages) == 0 {
+		return defaultLanguage
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+	return languages[0].lang
+}
+
+// setLanguageCookie stores the language of the lang query parameter,
+// so that it is kept for the following requests of the login flow.
+func (h *Handler) setLanguageCookie(w http.ResponseWriter, r *http.Request) {
+	lang := r.URL.Query().Get(langParameter)
+	if supportedLanguage(lang) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     langCookie,
+			Value:    lang,
+			Path:     h.config.LoginPath,
+			HttpOnly: true,
+		})
+	}
+}
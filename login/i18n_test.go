@@ -0,0 +1,89 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestTranslate(t *testing.T) {
+	Equal(t, "Anmelden", translate("de", "Sign in"))
+	Equal(t, "Willkommen bob!", translate("de", "Welcome %v!", "bob"))
+	Equal(t, "Welcome bob!", translate("en", "Welcome %v!", "bob"))
+	Equal(t, "Das Passwort muss mindestens 8 Zeichen haben", translate("de", msgPasswordTooShort))
+
+	// unknown messages and languages are not translated
+	Equal(t, "Unknown message", translate("de", "Unknown message"))
+	Equal(t, "Sign in", translate("xx", "Sign in"))
+}
+
+func TestCatalogs_Complete(t *testing.T) {
+	for lang, catalog := range catalogs {
+		if lang == defaultLanguage {
+			continue
+		}
+		for message := range catalogs["de"] {
+			Contains(t, catalog, message, lang)
+		}
+		Equal(t, len(catalogs["de"]), len(catalog), lang)
+	}
+}
+
+func TestAcceptedLanguage(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                           "en",
+		"de":                         "de",
+		"de-CH, fr;q=0.9":            "de",
+		"FR-ca":                      "fr",
+		"it, es;q=0.5, de;q=0.8":     "de",
+		"it, ja":                     "en",
+		"de;q=0, es":                 "es",
+		"es;q=0.5, fr;q=0.5, de;q=x": "de",
+		"*":                          "en",
+	} {
+		Equal(t, expected, acceptedLanguage(header), header)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	Equal(t, "en", language(req("GET", "/context/login", "")))
+	Equal(t, "fr", language(req("GET", "/context/login", "", "Accept-Language: fr-FR,fr;q=0.9")))
+	Equal(t, "de", language(req("GET", "/context/login?lang=de", "", "Accept-Language: fr-FR,fr;q=0.9")))
+	Equal(t, "fr", language(req("GET", "/context/login?lang=xx", "", "Accept-Language: fr-FR,fr;q=0.9")))
+	Equal(t, "es", language(req("GET", "/context/login", "", "Cookie: lang=es", "Accept-Language: fr")))
+	Equal(t, "fr", language(req("GET", "/context/login", "", "Cookie: lang=xx", "Accept-Language: fr")))
+}
+
+func TestHandler_LoginFormLanguage(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	defer h.Close()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login?lang=de", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `<html lang="de">`)
+	Contains(t, recorder.Body.String(), `placeholder="Benutzername"`)
+	Contains(t, recorder.Body.String(), `value="Anmelden"`)
+	Contains(t, recorder.Header().Get("Set-Cookie"), "lang=de; Path=/context/login; HttpOnly")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=wrong", TypeForm, AcceptHTML, "Accept-Language: es")))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), `Credenciales no válidas`)
+	Equal(t, "", recorder.Header().Get("Set-Cookie"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=wrong", TypeForm, "Cookie: lang=fr"))
+	Equal(t, 403, recorder.Code)
+	Equal(t, "Identifiants incorrects", recorder.Body.String())
+
+	// the json errors are not translated
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, "Accept: application/json", "Accept-Language: de"))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "Wrong credentials")
+}
@@ -0,0 +1,55 @@
+package login
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+)
+
+// The codes of the json error responses, so that clients can handle the errors without parsing the messages.
+const (
+	errorInvalidCredentials  = "invalid_credentials"
+	errorUnauthenticated     = "unauthenticated"
+	errorAccountLocked       = "account_locked"
+	errorRateLimited         = "rate_limited"
+	errorCaptchaRequired     = "captcha_required"
+	errorTOTPRequired        = "totp_required"
+	errorInvalidCSRFToken    = "invalid_csrf_token"
+	errorSessionLimitReached = "session_limit_reached"
+	errorSessionRequired     = "session_required"
+	errorBackendUnavailable  = "backend_unavailable"
+	errorInternal            = "internal_error"
+)
+
+type jsonError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	RetryAfter  int    `json:"retry_after,omitempty"`
+}
+
+// respondJSONError writes an error response with the code and an english description.
+// A retryAfter greater than 0 is added to the response in seconds.
+func respondJSONError(w http.ResponseWriter, status int, code, description string, retryAfter time.Duration) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(jsonError{
+		Error:       code,
+		Description: description,
+		RetryAfter:  retryAfterSeconds(retryAfter),
+	}) // ignore error of encoding
+}
+
+// retryAfterSeconds rounds up the duration, so that a client does not retry too early.
+func retryAfterSeconds(retryAfter time.Duration) int {
+	return int(math.Ceil(retryAfter.Seconds()))
+}
+
+// respondBackendUnavailable responds to a failed request to a login backend or oauth provider.
+func (h *Handler) respondBackendUnavailable(w http.ResponseWriter, r *http.Request) {
+	if wantJSON(r) && !wantHTML(r) {
+		respondJSONError(w, 503, errorBackendUnavailable, "The login backend is not available", 0)
+		return
+	}
+	h.respondError(w, r)
+}
@@ -0,0 +1,75 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+const acceptJSON = "Accept: application/json"
+
+func TestHandler_JSONError_InvalidCredentials(t *testing.T) {
+	recorder := call(req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, acceptJSON))
+	Equal(t, 403, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	JSONEq(t, `{"error": "invalid_credentials", "error_description": "Wrong credentials"}`, recorder.Body.String())
+}
+
+func TestHandler_JSONError_BackendUnavailable(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	testHandlerWithError().ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, acceptJSON))
+	Equal(t, 503, recorder.Code)
+	JSONEq(t, `{"error": "backend_unavailable", "error_description": "The login backend is not available"}`, recorder.Body.String())
+
+	// the other clients get the error as before
+	recorder = httptest.NewRecorder()
+	testHandlerWithError().ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 500, recorder.Code)
+	Equal(t, "Internal Server Error", recorder.Body.String())
+}
+
+func TestHandler_JSONError_Locked(t *testing.T) {
+	h := testHandler()
+	h.failureLimiter = newFailureLimiter(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, acceptJSON))
+		Equal(t, 403, recorder.Code)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, acceptJSON))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "60", recorder.Header().Get("Retry-After"))
+	JSONEq(t, `{"error": "account_locked", "error_description": "Too many failed login attempts", "retry_after": 60}`, recorder.Body.String())
+
+	// the client ip is locked as well, which is a rate limit for other users
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "alice", "password": "secret"}`, TypeJSON, acceptJSON))
+	Equal(t, 429, recorder.Code)
+	JSONEq(t, `{"error": "rate_limited", "error_description": "Too many failed login attempts", "retry_after": 60}`, recorder.Body.String())
+}
+
+func TestHandler_JSONError_TokenValidateRateLimit(t *testing.T) {
+	h := testHandler()
+	h.validateLimiter = newRateLimiter(1, time.Minute)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token":"foo"}`, acceptJSON))
+	Equal(t, 200, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token":"foo"}`, acceptJSON))
+	Equal(t, 429, recorder.Code)
+	JSONEq(t, `{"error": "rate_limited", "error_description": "Too Many Requests", "retry_after": 60}`, recorder.Body.String())
+}
+
+func TestHandler_JSONError_Internal(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	testHandler().respondError(recorder, req("GET", "/context/login", "", acceptJSON))
+	Equal(t, 500, recorder.Code)
+	JSONEq(t, `{"error": "internal_error", "error_description": "Internal Server Error"}`, recorder.Body.String())
+}
@@ -0,0 +1,99 @@
+package login
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// jweHeader is the protected header of the encrypted tokens.
+// The signed token is encrypted directly (alg dir) with AES-GCM (enc A256GCM), as defined in RFC 7516 and RFC 7518.
+type jweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Cty string `json:"cty"`
+}
+
+var errInvalidJWE = errors.New("invalid encrypted token")
+
+// encryptToken nests the signed token in a JWE, so that the claims are not readable by the browser.
+// Without a configured jwe-secret, the signed token is returned unchanged.
+func (h *Handler) encryptToken(signedToken string) (string, error) {
+	if h.config.JweSecret == "" {
+		return signedToken, nil
+	}
+	gcm, err := h.jweCipher()
+	if err != nil {
+		return "", err
+	}
+
+	header, _ := json.Marshal(jweHeader{Alg: "dir", Enc: "A256GCM", Cty: "JWT"})
+	protected := base64url(header)
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(signedToken), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	// the encrypted key is empty for the direct encryption
+	return strings.Join([]string{protected, "", base64url(iv), base64url(ciphertext), base64url(tag)}, "."), nil
+}
+
+// decryptToken returns the signed token of a JWE.
+// Signed tokens are returned unchanged, so the tokens issued before the encryption was enabled are still accepted.
+func (h *Handler) decryptToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if h.config.JweSecret == "" || len(parts) != 5 {
+		return token, nil
+	}
+
+	header := jweHeader{}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &header) != nil {
+		return "", errInvalidJWE
+	}
+	if header.Alg != "dir" || header.Enc != "A256GCM" || parts[1] != "" {
+		return "", errInvalidJWE
+	}
+	iv, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errInvalidJWE
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", errInvalidJWE
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil {
+		return "", errInvalidJWE
+	}
+
+	gcm, err := h.jweCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return "", errInvalidJWE
+	}
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", errInvalidJWE
+	}
+	return string(plaintext), nil
+}
+
+// jweCipher returns the AES-GCM cipher with the 256 bit key, which is derived from the jwe-secret.
+func (h *Handler) jweCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(h.config.JweSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,81 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_JWE(t *testing.T) {
+	h := testHandler()
+	h.config.JweSecret = "jwe secret"
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+
+	// the claims are not readable without the secret
+	Equal(t, 5, len(strings.Split(token, ".")))
+	_, err := tokenAsMap(token)
+	Error(t, err)
+
+	// the token is decrypted transparently on the userinfo and refresh path
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Cookie: jwt_token="+token, "Accept: application/json"))
+	Equal(t, 200, recorder.Code)
+	userInfo := model.UserInfo{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &userInfo))
+	Equal(t, "bob", userInfo.Sub)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token":"`+token+`"}`, TypeJSON))
+	JSONEq(t, `{"valid": true}`, recorder.Body.String())
+
+	// tokens encrypted with another secret are rejected
+	h.config.JweSecret = "another secret"
+	_, valid := h.parseToken(req("GET", "/context/login", ""), token)
+	False(t, valid)
+}
+
+func TestHandler_JWE_SignedTokenAccepted(t *testing.T) {
+	h := testHandler()
+	signedToken, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	// tokens, which were issued before the encryption was enabled, are still accepted
+	h.config.JweSecret = "jwe secret"
+	userInfo, valid := h.parseToken(req("GET", "/context/login", ""), signedToken)
+	True(t, valid)
+	Equal(t, "marvin", userInfo.Sub)
+}
+
+func TestHandler_decryptToken(t *testing.T) {
+	h := testHandler()
+	h.config.JweSecret = "jwe secret"
+
+	token, err := h.encryptToken("header.payload.signature")
+	NoError(t, err)
+	decrypted, err := h.decryptToken(token)
+	NoError(t, err)
+	Equal(t, "header.payload.signature", decrypted)
+
+	parts := strings.Split(token, ".")
+	for _, invalid := range []string{
+		// modified header
+		base64url([]byte(`{"alg":"dir","enc":"A128GCM","cty":"JWT"}`)) + "." + strings.Join(parts[1:], "."),
+		// encrypted key is not allowed with alg dir
+		parts[0] + ".Zm9v." + strings.Join(parts[2:], "."),
+		// modified ciphertext
+		strings.Join(parts[:3], ".") + "." + base64url([]byte("foo")) + "." + parts[4],
+		"a.b.c.d.e",
+	} {
+		_, err := h.decryptToken(invalid)
+		Error(t, err)
+	}
+}
@@ -0,0 +1,157 @@
+package login
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/logging"
+)
+
+// JWKSPath is the path of the json web key set with the public signing key.
+// It is served outside of the login path, at the well known location.
+const JWKSPath = "/.well-known/jwks.json"
+
+// jsonWebKey is the public part of a signing key as defined in RFC 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// PublishesJWKS returns true, if the tokens are signed with a private key,
+// so that the public key is published at the JWKSPath.
+func (h *Handler) PublishesJWKS() bool {
+	_, ok := h.publicJWK()
+	return ok
+}
+
+// PublicKeyPEM returns the public signing key as PEM, e.g. for the configuration of other jwt middlewares.
+// It returns false for the HMAC algorithms, which have no public key.
+func (h *Handler) PublicKeyPEM() (string, bool) {
+	_, _, verifyKey, err := h.signingInfo()
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+		return "", false
+	}
+	switch verifyKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return "", false
+	}
+	b, err := x509.MarshalPKIXPublicKey(verifyKey)
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+		return "", false
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b})), true
+}
+
+func (h *Handler) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	key, ok := h.publicJWK()
+	if !ok {
+		h.respondNotFound(w, r)
+		return
+	}
+	keys := []jsonWebKey{key}
+
+	// the secondary key is published during a key rotation, so that the tokens signed with it can still be verified
+	secondaryVerifyKey, _, err := h.secondaryKey()
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+	}
+	if secondary, ok := publicJWK(h.config.JwtAlgo, secondaryVerifyKey); ok {
+		keys = append(keys, secondary)
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	// keep the caching short, so that a new key is picked up soon after a key rotation
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(jsonWebKeySet{Keys: keys}) // ignore error of encoding
+}
+
+// publicJWK returns the public key of the signing key.
+// It returns false for the HMAC algorithms, which have no public key.
+func (h *Handler) publicJWK() (jsonWebKey, bool) {
+	_, _, verifyKey, err := h.signingInfo()
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+		return jsonWebKey{}, false
+	}
+	return publicJWK(h.config.JwtAlgo, verifyKey)
+}
+
+// publicJWK returns the verification key as json web key, if it is a public key.
+func publicJWK(algo string, verifyKey interface{}) (jsonWebKey, bool) {
+	key := jsonWebKey{Use: "sig", Alg: algo}
+	switch pub := verifyKey.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64url(pub.N.Bytes())
+		key.E = base64url(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = pub.Curve.Params().Name
+		key.X = base64url(padded(pub.X.Bytes(), size))
+		key.Y = base64url(padded(pub.Y.Bytes(), size))
+	default:
+		return jsonWebKey{}, false
+	}
+	key.Kid = thumbprint(key)
+	return key, true
+}
+
+// thumbprint calculates the key id as JWK thumbprint, defined in RFC 7638.
+// The members are required in lexicographic order, which is the order of the struct fields below.
+func thumbprint(key jsonWebKey) string {
+	var b []byte
+	if key.Kty == "RSA" {
+		b, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{key.E, key.Kty, key.N})
+	} else {
+		b, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{key.Crv, key.Kty, key.X, key.Y})
+	}
+	sum := sha256.Sum256(b)
+	return base64url(sum[:])
+}
+
+func base64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padded returns the bytes with leading zeros up to the size
+func padded(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,118 @@
+package login
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_JWKS_RSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	keyFile := writeTestKeyFile(t, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	defer os.Remove(keyFile)
+
+	h := testHandler()
+	h.config.JwtAlgo = "RS256"
+	h.config.JwtPrivateKeyFile = keyFile
+	True(t, h.PublishesJWKS())
+
+	keySet := getJWKS(t, h)
+	Equal(t, 1, len(keySet.Keys))
+	jwk := keySet.Keys[0]
+	Equal(t, "RSA", jwk.Kty)
+	Equal(t, "RS256", jwk.Alg)
+	Equal(t, "sig", jwk.Use)
+	Equal(t, "AQAB", jwk.E)
+
+	// the published key verifies the tokens, which have its key id
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	NoError(t, err)
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	tokenString, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	token, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) { return publicKey, nil })
+	NoError(t, err)
+	Equal(t, jwk.Kid, token.Header["kid"])
+}
+
+func TestHandler_JWKS_EC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	NoError(t, err)
+	keyFile := writeTestKeyFile(t, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	defer os.Remove(keyFile)
+
+	h := testHandler()
+	h.config.JwtAlgo = "ES384"
+	h.config.JwtPrivateKeyFile = keyFile
+
+	jwk := getJWKS(t, h).Keys[0]
+	Equal(t, "EC", jwk.Kty)
+	Equal(t, "P-384", jwk.Crv)
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	NoError(t, err)
+	Equal(t, 48, len(x))
+	Equal(t, 0, key.X.Cmp(new(big.Int).SetBytes(x)))
+
+	publicKeyPEM, ok := h.PublicKeyPEM()
+	True(t, ok)
+	publicKey, err := jwt.ParseECPublicKeyFromPEM([]byte(publicKeyPEM))
+	NoError(t, err)
+	Equal(t, 0, key.X.Cmp(publicKey.X))
+	Equal(t, 0, key.Y.Cmp(publicKey.Y))
+}
+
+func TestHandler_JWKS_NotFoundForHMAC(t *testing.T) {
+	h := testHandler()
+	False(t, h.PublishesJWKS())
+	_, ok := h.PublicKeyPEM()
+	False(t, ok)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", JWKSPath, ""))
+	Equal(t, 404, recorder.Code)
+
+	token, err := h.createToken(model.UserInfo{Sub: "marvin"})
+	NoError(t, err)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	NoError(t, err)
+	// the key id of a secret is not published, but used for the key rotation
+	Equal(t, keyID("HS512", []byte(h.config.JwtSecret)), parsed.Header["kid"])
+}
+
+func TestThumbprint(t *testing.T) {
+	// example of RFC 7638, section 3.1
+	key := jsonWebKey{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint(key))
+}
+
+func getJWKS(t *testing.T, h *Handler) jsonWebKeySet {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", JWKSPath, ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	keySet := jsonWebKeySet{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &keySet))
+	return keySet
+}
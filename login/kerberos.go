@@ -0,0 +1,51 @@
+package login
+
+import (
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/kerberos"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/metrics"
+)
+
+func newKerberos(config *Config) (*kerberos.Service, error) {
+	if len(config.Kerberos) == 0 {
+		return nil, nil
+	}
+	cfg, err := kerberos.NewConfigFromOpts(config.Kerberos)
+	if err != nil {
+		return nil, err
+	}
+	return kerberos.NewService(cfg)
+}
+
+// negotiate logs in browsers transparently by SPNEGO, when they GET the login form.
+// Without a ticket, the form is sent with status 401 and the Negotiate challenge: browsers of the domain retry
+// with a ticket and the others show the form. It returns true, if the response was written.
+func (h *Handler) negotiate(w http.ResponseWriter, r *http.Request) bool {
+	authorization := r.Header.Get("Authorization")
+	if !kerberos.IsNegotiate(authorization) {
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(401)
+		return false
+	}
+
+	if !h.beforeAuth(w, r, "", kerberos.ProviderName) {
+		return true
+	}
+	userInfo, err := h.kerberos.Authenticate(authorization)
+	if err != nil {
+		// the form is shown without a new challenge, so that the browser does not retry
+		metrics.Login(kerberos.ProviderName, false, nil)
+		logging.Application(r.Header).WithError(err).Info("failed authentication")
+		h.auditEvent(r, audit.LoginFailure, "", kerberos.ProviderName, err.Error())
+		return false
+	}
+	metrics.Login(kerberos.ProviderName, true, nil)
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).Info("successfully authenticated")
+	h.completeLogin(w, r, userInfo, kerberos.ProviderName)
+	return true
+}
@@ -0,0 +1,105 @@
+package login
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	. "github.com/stretchr/testify/assert"
+)
+
+func testKerberosHandler(t *testing.T) (*Handler, *keytab.Keytab) {
+	kt := keytab.New()
+	NoError(t, kt.AddEntry("HTTP/login.example.com", "EXAMPLE.COM", "service-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	b, err := kt.Marshal()
+	NoError(t, err)
+	f, err := ioutil.TempFile("", "loginsrv-keytab")
+	NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	NoError(t, err)
+	f.Close()
+
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.Kerberos = map[string]string{"keytab": f.Name(), "realm": "EXAMPLE.COM"}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	return h, kt
+}
+
+// negotiateHeader returns the Authorization header of a browser with a raw kerberos service ticket
+func negotiateHeader(t *testing.T, kt *keytab.Keytab, username string) string {
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, username)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "HTTP/login.example.com")
+	now := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cname, "EXAMPLE.COM", sname, "EXAMPLE.COM", types.NewKrbFlags(), kt,
+		etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	NoError(t, err)
+	token, err := spnego.NewKRB5TokenAPREQ(client.NewWithPassword(username, "EXAMPLE.COM", "", krbconfig.New()), tkt, sessionKey, []int{}, []int{})
+	NoError(t, err)
+	b, err := token.Marshal()
+	NoError(t, err)
+	return "Authorization: Negotiate " + base64.StdEncoding.EncodeToString(b)
+}
+
+func TestHandler_Kerberos_Challenge(t *testing.T) {
+	h, _ := testKerberosHandler(t)
+
+	// browsers outside of the domain show the form of the challenge response
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Equal(t, 401, recorder.Code)
+	Equal(t, "Negotiate", recorder.Header().Get("WWW-Authenticate"))
+	Contains(t, recorder.Body.String(), `name="password"`)
+
+	// api clients are not challenged
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Accept: application/json"))
+	Equal(t, 403, recorder.Code)
+	Equal(t, "", recorder.Header().Get("WWW-Authenticate"))
+}
+
+func TestHandler_Kerberos_Login(t *testing.T) {
+	h, kt := testKerberosHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, negotiateHeader(t, kt, "alice")))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+
+	claims, err := tokenAsMap(cookieByName(recorder, "jwt_token").Value)
+	NoError(t, err)
+	Equal(t, "alice", claims["sub"])
+	Equal(t, "kerberos", claims["origin"])
+
+	// a logged in user is not challenged again
+	token := cookieByName(recorder, "jwt_token").Value
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Cookie: jwt_token="+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "", recorder.Header().Get("WWW-Authenticate"))
+}
+
+func TestHandler_Kerberos_Failure(t *testing.T) {
+	h, _ := testKerberosHandler(t)
+
+	// a NTLM token of a browser outside of the domain falls back to the form
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Authorization: Negotiate TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAGAbEdAAAADw=="))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "", recorder.Header().Get("WWW-Authenticate"))
+	Contains(t, recorder.Body.String(), `name="password"`)
+	Nil(t, cookieByName(recorder, "jwt_token"))
+}
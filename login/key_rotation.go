@@ -0,0 +1,73 @@
+package login
+
+import (
+	"crypto/sha256"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// hasSecondaryKey returns true, if a secondary secret or private key is configured for a key rotation.
+func (h *Handler) hasSecondaryKey() bool {
+	return h.config.JwtSecondarySecret != "" || h.config.JwtSecondaryPrivateKeyFile != ""
+}
+
+// secondaryKey returns the verification key and the key id of the secondary key.
+// The secondary key is the previous key of a key rotation: tokens signed with it are still accepted,
+// but new tokens are always signed with the primary key. It returns nil, if no secondary key is configured.
+func (h *Handler) secondaryKey() (verifyKey interface{}, kid string, err error) {
+	if !h.hasSecondaryKey() {
+		return nil, "", nil
+	}
+	if h.secondaryVerifyKey == nil {
+		_, verifyKey, err := parseSigningKey(h.config.JwtAlgo, h.config.JwtSecondarySecret, h.config.JwtSecondaryPrivateKeyFile)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "invalid secondary jwt key")
+		}
+		h.secondaryVerifyKey = verifyKey
+	}
+	return h.secondaryVerifyKey, keyID(h.config.JwtAlgo, h.secondaryVerifyKey), nil
+}
+
+// keyID returns the kid header for the tokens of a key: the JWK thumbprint of public keys
+// and a short hash of the secret for the HMAC algorithms.
+func keyID(algo string, verifyKey interface{}) string {
+	if jwk, ok := publicJWK(algo, verifyKey); ok {
+		return jwk.Kid
+	}
+	secret, _ := verifyKey.([]byte)
+	sum := sha256.Sum256(secret)
+	return base64url(sum[:8])
+}
+
+// parseWithClaims decrypts and verifies the token and parses the claims of it.
+// Tokens without key id, which were issued before the key id was added, are verified with the primary key
+// and, if the signature does not match, with the secondary key.
+func (h *Handler) parseWithClaims(r *http.Request, tokenString string, claims jwt.Claims) error {
+	tokenString, err := h.decryptToken(tokenString)
+	if err != nil {
+		return err
+	}
+
+	parser := &jwt.Parser{ValidMethods: []string{h.config.JwtAlgo}}
+	token, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return h.verifyKey(r, token)
+	})
+
+	validationErr, ok := err.(*jwt.ValidationError)
+	if !ok || validationErr.Errors&jwt.ValidationErrorSignatureInvalid == 0 || !h.hasSecondaryKey() {
+		return err
+	}
+	if _, hasKid := token.Header["kid"]; hasKid {
+		return err
+	}
+	_, err = parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, err := h.verifyKey(r, token); err != nil {
+			return nil, err
+		}
+		verifyKey, _, err := h.secondaryKey()
+		return verifyKey, err
+	})
+	return err
+}
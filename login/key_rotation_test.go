@@ -0,0 +1,111 @@
+package login
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_KeyRotation_Secret(t *testing.T) {
+	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()}
+	oldHandler := testHandler()
+	oldHandler.config.JwtSecret = "old secret"
+	oldToken, err := oldHandler.createToken(input)
+	NoError(t, err)
+	// tokens of older versions have no key id
+	oldTokenWithoutKid, err := jwt.NewWithClaims(jwt.SigningMethodHS512, input).SignedString([]byte("old secret"))
+	NoError(t, err)
+	foreignToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, input).SignedString([]byte("another secret"))
+	NoError(t, err)
+
+	h := testHandler()
+	h.config.JwtSecret = "new secret"
+	h.config.JwtSecondarySecret = "old secret"
+	newToken, err := h.createToken(input)
+	NoError(t, err)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(newToken, jwt.MapClaims{})
+	NoError(t, err)
+	Equal(t, keyID("HS512", []byte("new secret")), parsed.Header["kid"])
+
+	for _, test := range []struct {
+		token string
+		valid bool
+	}{
+		{newToken, true},
+		{oldToken, true},
+		{oldTokenWithoutKid, true},
+		{foreignToken, false},
+	} {
+		userInfo, valid := h.parseToken(req("GET", "/context/login", ""), test.token)
+		Equal(t, test.valid, valid)
+		if test.valid {
+			Equal(t, "marvin", userInfo.Sub)
+		}
+
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token":"`+test.token+`"}`, TypeJSON))
+		response := tokenValidateResponse{}
+		NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		Equal(t, test.valid, response.Valid)
+	}
+
+	// after the rotation, the old tokens are rejected
+	h = testHandler()
+	h.config.JwtSecret = "new secret"
+	_, valid := h.parseToken(req("GET", "/context/login", ""), oldToken)
+	False(t, valid)
+}
+
+func TestHandler_KeyRotation_PrivateKey(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	oldDER, err := x509.MarshalECPrivateKey(oldKey)
+	NoError(t, err)
+	oldKeyFile := writeTestKeyFile(t, &pem.Block{Type: "EC PRIVATE KEY", Bytes: oldDER})
+	defer os.Remove(oldKeyFile)
+
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	newDER, err := x509.MarshalECPrivateKey(newKey)
+	NoError(t, err)
+	newKeyFile := writeTestKeyFile(t, &pem.Block{Type: "EC PRIVATE KEY", Bytes: newDER})
+	defer os.Remove(newKeyFile)
+
+	oldHandler := testHandler()
+	oldHandler.config.JwtAlgo = "ES256"
+	oldHandler.config.JwtPrivateKeyFile = oldKeyFile
+	oldToken, err := oldHandler.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtAlgo = "ES256"
+	cfg.JwtPrivateKeyFile = newKeyFile
+	cfg.JwtSecondaryPrivateKeyFile = oldKeyFile
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	_, valid := h.parseToken(req("GET", "/context/login", ""), oldToken)
+	True(t, valid)
+
+	// both public keys are published, the primary key first
+	keySet := getJWKS(t, h)
+	Equal(t, 2, len(keySet.Keys))
+	Equal(t, keyID("ES256", newKey.Public()), keySet.Keys[0].Kid)
+	Equal(t, keyID("ES256", oldKey.Public()), keySet.Keys[1].Kid)
+
+	cfg.JwtSecondaryPrivateKeyFile = "/does/not/exist.pem"
+	_, err = NewHandler(cfg)
+	Error(t, err)
+}
@@ -2,9 +2,12 @@ package login
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/afdecastro879/loginsrv/logging"
@@ -13,11 +16,76 @@ import (
 
 const partials = `
 
+{{define "header"}}{{end}}
+
+{{define "footer"}}{{end}}
+
+{{define "csrf"}}{{if .CSRFToken}}<input name="csrf_token" type="hidden" value="{{.CSRFToken}}">{{end}}{{end}}
+
 {{define "styles"}}
     <link uic-remove rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.5/css/bootstrap.min.css">
     <link uic-remove rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/bootstrap-social/5.1.1/bootstrap-social.min.css">
     <link uic-remove rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/font-awesome/4.7.0/css/font-awesome.css">
     <style>
+     :root {
+       color-scheme: light dark;
+       --login-primary: {{ or .Config.ThemeColor "#337ab7" }};
+       --login-background: #f5f6f8;
+       --login-surface: #ffffff;
+       --login-text: #333333;
+       --login-muted: #6a737c;
+       --login-border: #e4e6e8;
+     }
+     @media (prefers-color-scheme: dark) {
+       :root {
+         --login-background: #1b1d21;
+         --login-surface: #26292e;
+         --login-text: #e3e5e8;
+         --login-muted: #9aa0a8;
+         --login-border: #3a3e45;
+       }
+     }
+     body {
+       background-color: var(--login-background);
+       color: var(--login-text);
+     }
+     a, a:hover, a:focus {
+       color: var(--login-primary);
+     }
+     .panel {
+       background-color: var(--login-surface);
+       border-color: var(--login-border);
+       border-radius: 8px;
+       box-shadow: 0 2px 8px rgba(0, 0, 0, 0.08);
+     }
+     .panel-default > .panel-heading {
+       background-color: transparent;
+       border-color: var(--login-border);
+       color: var(--login-text);
+     }
+     .form-control {
+       background-color: var(--login-surface);
+       border-color: var(--login-border);
+       color: var(--login-text);
+     }
+     .form-control:focus {
+       border-color: var(--login-primary);
+       box-shadow: none;
+     }
+     .btn-success, .btn-primary {
+       background-color: var(--login-primary);
+       border-color: var(--login-primary);
+     }
+     .btn-success:hover, .btn-success:focus, .btn-primary:hover, .btn-primary:focus {
+       background-color: var(--login-primary);
+       border-color: var(--login-primary);
+       filter: brightness(90%);
+     }
+     .btn-default {
+       background-color: var(--login-surface);
+       border-color: var(--login-border);
+       color: var(--login-text);
+     }
      .vertical-offset-100{
        padding-top:100px;
      }
@@ -26,7 +94,7 @@ const partials = `
        margin: 0;
        margin-bottom: 10px;
        clear: both;
-       color: #6a737c;
+       color: var(--login-muted);
        font-variant: small-caps;
      }
      .login-or-hr {
@@ -35,94 +103,493 @@ const partials = `
        top: 28px;
        height: 0;
        border: 0;
-       border-top: 1px solid #e4e6e8;
+       border-top: 1px solid var(--login-border);
      }
      .login-or {
        display: inline-block;
        position: relative;
        padding: 10px;
-       background-color: #FFF;
+       background-color: var(--login-background);
      }
      .login-picture {
        height: 120px;
        border-radius: 3px;
        margin-bottom: 10px;
      }
+     .login-logo {
+       display: block;
+       max-width: 100%;
+       max-height: 80px;
+       margin: 0 auto 20px auto;
+     }
     </style>
 {{end}}
 
 {{define "userInfo"}}
               {{with .UserInfo}}
-                <h1>Welcome {{.Sub}}!</h1>
+                <h1>{{t "Welcome %v!" .Sub}}</h1>
                 <br/>
                 {{if .Picture}}<img class="login-picture" src="{{.Picture}}?s=120">{{end}}
                 {{if .Name}}<h3>{{.Name}}</h3>{{end}}
               {{end}}
               <br/>
-              <a class="btn btn-md btn-primary" href="{{ .Config.LoginPath }}?logout=true">Logout</a>
+              {{if .Device}}
+                {{template "device" . }}
+              {{else}}
+              {{if .Config.WebAuthnRPID}}
+                <button class="btn btn-md btn-default" type="button" onclick="loginsrvRegisterPasskey()">{{t "Add a passkey"}}</button>
+                {{template "webauthn" . }}
+              {{end}}
+              {{if .Config.PasswordChange}}
+                <a class="btn btn-md btn-default" href="{{ .Config.LoginPath }}/password">{{t "Change password"}}</a>
+              {{end}}
+              <a class="btn btn-md btn-primary" href="{{ .Config.LoginPath }}?logout=true{{if .CSRFToken}}&csrf_token={{.CSRFToken}}{{end}}">{{t "Logout"}}</a>
+              {{if .Sessions}}
+                {{template "sessions" . }}
+              {{end}}
+              {{end}}
+{{end}}
+
+{{define "sessions"}}
+                <h4>{{t "Your sessions"}}</h4>
+                <table class="table table-condensed">
+                  {{range .Sessions}}
+                  <tr>
+                    <td>{{if .UserAgent}}{{.UserAgent}}{{else}}{{t "Unknown device"}}{{end}}<br/><small>{{.ClientIP}} {{.SignedIn}}</small></td>
+                    <td class="text-right">
+                      {{if .Current}}
+                        <span class="label label-success">{{t "This device"}}</span>
+                      {{else}}
+                        <form accept-charset="UTF-8" method="POST" action="{{$.Config.LoginPath}}/sessions">
+                          <input name="session" type="hidden" value="{{.ID}}">
+                          {{template "csrf" $ }}
+                          <input class="btn btn-xs btn-default" type="submit" value="{{t "Sign out"}}">
+                        </form>
+                      {{end}}
+                    </td>
+                  </tr>
+                  {{end}}
+                </table>
+{{end}}
+
+{{define "device"}}
+                <div class="panel panel-default">
+  	          <div class="panel-heading">
+  		    <div class="panel-title">
+  		      <h4>{{t "Sign in on a device"}}</h4>
+                      {{if eq .Device.Result "invalid"}}<div class="alert alert-warning" role="alert">{{t "Invalid or expired code"}}</div>{{end}}
+		    </div>
+	          </div>
+	          <div class="panel-body">
+                    {{if eq .Device.Result "approved"}}
+                      <p>{{t "The device is signed in. You can close this window."}}</p>
+                    {{else if eq .Device.Result "denied"}}
+                      <p>{{t "The sign in of the device was denied."}}</p>
+                    {{else if .Device.ApprovalToken}}
+                      <p>{{t "A device wants to sign in as %v. Only continue, if the device shows this code:" .UserInfo.Sub}}</p>
+                      <h3 class="text-center">{{.Device.UserCode}}</h3>
+		      <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}/device/verify">
+                        <fieldset>
+                          <input name="user_code" type="hidden" value="{{.Device.UserCode}}">
+                          <input name="approval_token" type="hidden" value="{{.Device.ApprovalToken}}">
+		          <button class="btn btn-lg btn-success btn-block" type="submit" name="action" value="approve">{{t "Approve"}}</button>
+		          <button class="btn btn-lg btn-default btn-block" type="submit" name="action" value="deny">{{t "Deny"}}</button>
+		        </fieldset>
+		      </form>
+                    {{else}}
+		      <form accept-charset="UTF-8" role="form" method="GET" action="{{.Config.LoginPath}}/device/verify">
+                        <fieldset>
+		          <div class="form-group">
+		            <input class="form-control" placeholder="{{t "Code"}}" name="user_code" type="text" autocomplete="off" autofocus>
+		          </div>
+		          <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Continue"}}">
+		        </fieldset>
+		      </form>
+                    {{end}}
+	          </div>
+	        </div>
+{{end}}
+
+{{define "webauthn"}}
+    <script>
+      var loginsrvWebAuthnPath = {{.Config.LoginPath}} + '/webauthn/';
+      function loginsrvDecode(s) {
+        s = s.replace(/-/g, '+').replace(/_/g, '/');
+        return Uint8Array.from(atob(s), function(c) { return c.charCodeAt(0); }).buffer;
+      }
+      function loginsrvEncode(b) {
+        return btoa(String.fromCharCode.apply(null, new Uint8Array(b))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+      }
+      function loginsrvCredential(c) {
+        var r = {id: c.id, rawId: loginsrvEncode(c.rawId), type: c.type, response: {}};
+        ['clientDataJSON', 'attestationObject', 'authenticatorData', 'signature', 'userHandle'].forEach(function(k) {
+          if (c.response[k]) { r.response[k] = loginsrvEncode(c.response[k]); }
+        });
+        return r;
+      }
+      function loginsrvPost(path, body, accept) {
+        return fetch(loginsrvWebAuthnPath + path, {
+          method: 'POST', credentials: 'same-origin',
+          headers: {'Content-Type': 'application/json', 'Accept': accept || 'application/json'},
+          body: JSON.stringify(body || {})
+        });
+      }
+      function loginsrvOptions(path) {
+        return loginsrvPost(path).then(function(r) {
+          if (!r.ok) { throw new Error(r.statusText); }
+          return r.json();
+        });
+      }
+      function loginsrvLoginWithPasskey() {
+        loginsrvOptions('login/begin').then(function(o) {
+          o.publicKey.challenge = loginsrvDecode(o.publicKey.challenge);
+          return navigator.credentials.get(o);
+        }).then(function(c) {
+          return loginsrvPost('login/finish', loginsrvCredential(c), 'text/html');
+        }).then(function(r) {
+          if (!r.redirected) { throw new Error(r.statusText); }
+          window.location = r.url;
+        }).catch(function(e) { alert({{t "Passkey login failed: "}} + e.message); });
+      }
+      function loginsrvRegisterPasskey() {
+        loginsrvOptions('register/begin').then(function(o) {
+          o.publicKey.challenge = loginsrvDecode(o.publicKey.challenge);
+          o.publicKey.user.id = loginsrvDecode(o.publicKey.user.id);
+          (o.publicKey.excludeCredentials || []).forEach(function(d) { d.id = loginsrvDecode(d.id); });
+          return navigator.credentials.create(o);
+        }).then(function(c) {
+          return loginsrvPost('register/finish', loginsrvCredential(c));
+        }).then(function(r) {
+          if (!r.ok) { throw new Error(r.statusText); }
+          alert({{t "The passkey was added."}});
+        }).catch(function(e) { alert({{t "Adding the passkey failed: "}} + e.message); });
+      }
+    </script>
+{{end}}
+
+{{define "totp"}}
+                <div class="panel panel-default">
+  	          <div class="panel-heading">
+  		    <div class="panel-title">
+  		      <h4>{{t "Two-factor authentication"}}</h4>
+                      {{ if .Failure}}<div class="alert alert-warning" role="alert">{{t "Invalid code"}}</div>{{end}}
+		    </div>
+	          </div>
+	          <div class="panel-body">
+                    {{if .TOTP.Enroll}}
+                      <p>{{t "Scan the QR code with your authenticator app and enter the code to finish the setup."}}</p>
+                      <img class="center-block" src="{{.TOTP.QRCode}}" alt="TOTP QR code">
+                      <p class="text-center"><small>{{.TOTP.Secret}}</small></p>
+                    {{end}}
+		    <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}">
+                      <fieldset>
+                        <input name="totp_token" type="hidden" value="{{.TOTP.Token}}">
+                        {{template "csrf" . }}
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Code"}}" name="totp" type="text" inputmode="numeric" autocomplete="one-time-code" autofocus>
+		        </div>
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Verify"}}">
+		      </fieldset>
+		    </form>
+	          </div>
+	        </div>
+{{end}}
+
+{{define "magiclink"}}
+                <div class="panel panel-default">
+  	          <div class="panel-heading">
+  		    <div class="panel-title">
+  		      <h4>{{t "Sign in with email"}}</h4>
+                      {{if and .MagicLink .MagicLink.Failure}}<div class="alert alert-warning" role="alert">{{t "The link is invalid, expired or was already used"}}</div>{{end}}
+		    </div>
+	          </div>
+	          <div class="panel-body">
+                    {{if and .MagicLink .MagicLink.Sent}}
+                      <p>{{t "If the address is allowed to sign in, we have sent you an email with a login link."}}</p>
+                    {{else if and .MagicLink .MagicLink.Token}}
+		      <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}/magiclink">
+		        <input name="token" type="hidden" value="{{.MagicLink.Token}}">
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Continue to sign in"}}">
+		      </form>
+                    {{else}}
+		      <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}/magiclink">
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Email"}}" name="email" type="email">
+		        </div>
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Send login link"}}">
+		      </form>
+                    {{end}}
+	          </div>
+	        </div>
+{{end}}
+
+{{define "register"}}
+                <div class="panel panel-default">
+  	          <div class="panel-heading">
+  		    <div class="panel-title">
+  		      <h4>{{t "Create an account"}}</h4>
+                      {{if .Registration.Error}}<div class="alert alert-warning" role="alert">{{t .Registration.Error}}</div>{{end}}
+		    </div>
+	          </div>
+	          <div class="panel-body">
+                    {{if .Registration.Created}}
+                      <p>{{t "Your account %v was created." .Registration.Username}}</p>
+                      <a class="btn btn-lg btn-success btn-block" href="{{.Config.LoginPath}}">{{t "Sign in"}}</a>
+                    {{else if .Registration.Sent}}
+                      <p>{{t "We have sent you an email with a link to confirm your address and to choose your password."}}</p>
+                    {{else if .Registration.Token}}
+		      <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}/register">
+		        <input name="token" type="hidden" value="{{.Registration.Token}}">
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Password"}}" name="password" type="password" value="">
+		        </div>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Confirm password"}}" name="password_confirmation" type="password" value="">
+		        </div>
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Create account"}}">
+		      </form>
+                    {{else}}
+		      <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}/register">
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Username"}}" name="username" value="{{.Registration.Username}}" type="text">
+		        </div>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Email"}}" name="email" value="{{.Registration.Email}}" type="email">
+		        </div>
+                        {{if .Registration.InviteCodeRequired}}
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Invite code"}}" name="invite_code" type="text">
+		        </div>
+                        {{end}}
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Register"}}">
+		      </form>
+                    {{end}}
+	          </div>
+	        </div>
+{{end}}
+
+{{define "password"}}
+                <div class="panel panel-default">
+  	          <div class="panel-heading">
+  		    <div class="panel-title">
+  		      <h4>{{if .Password.Reset}}{{t "Reset password"}}{{else}}{{t "Change password"}}{{end}}</h4>
+                      {{if .Password.Error}}<div class="alert alert-warning" role="alert">{{t .Password.Error}}</div>{{end}}
+		    </div>
+	          </div>
+	          <div class="panel-body">
+                    {{if .Password.Changed}}
+                      <p>{{t "Your password was changed."}}</p>
+                      {{if .Password.Reset}}<a class="btn btn-lg btn-success btn-block" href="{{.Config.LoginPath}}">{{t "Sign in"}}</a>{{end}}
+                    {{else if .Password.Sent}}
+                      <p>{{t "If the account has an email address, we have sent you an email with a link to choose a new password."}}</p>
+                    {{else if .Password.Reset}}
+		      <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}/password/reset">
+                        {{if .Password.Token}}
+		        <input name="token" type="hidden" value="{{.Password.Token}}">
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "New password"}}" name="password" type="password" value="">
+		        </div>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Confirm new password"}}" name="password_confirmation" type="password" value="">
+		        </div>
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Set password"}}">
+                        {{else}}
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Username"}}" name="username" type="text">
+		        </div>
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Send reset link"}}">
+                        {{end}}
+		      </form>
+                    {{else}}
+		      <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}/password">
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Current password"}}" name="current_password" type="password" value="">
+		        </div>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "New password"}}" name="password" type="password" value="">
+		        </div>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Confirm new password"}}" name="password_confirmation" type="password" value="">
+		        </div>
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Change password"}}">
+		      </form>
+                    {{end}}
+	          </div>
+	        </div>
+{{end}}
+
+{{define "reauth"}}
+                <div class="panel panel-default">
+  	          <div class="panel-heading">
+  		    <div class="panel-title">
+  		      <h4>{{t "Confirm your identity"}}</h4>
+                      {{ if .Failure}}<div class="alert alert-warning" role="alert">{{t "Invalid credentials"}}</div>{{end}}
+                      {{ if .CSRFFailure}}<div class="alert alert-warning" role="alert">{{t "The form has expired. Please try again."}}</div>{{end}}
+		    </div>
+	          </div>
+	          <div class="panel-body">
+                    <p>{{t "Please enter your password again to continue."}}</p>
+		    <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}/reauth">
+                      <fieldset>
+                        {{template "csrf" . }}
+		        <div class="form-group">
+                          {{if .UserInfo.Sub}}
+		          <input class="form-control" name="username" value="{{.UserInfo.Sub}}" type="text" readonly>
+                          {{else}}
+		          <input class="form-control" placeholder="{{t "Username"}}" name="username" value="" type="text">
+                          {{end}}
+		        </div>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="{{t "Password"}}" name="password" type="password" value="" autofocus>
+		        </div>
+                        {{if .Captcha}}
+		        <div class="form-group">
+                          {{if .Captcha.Failure}}<div class="alert alert-warning" role="alert">{{t "Please confirm, that you are not a robot"}}</div>{{end}}
+		          <div class="{{.Captcha.WidgetClass}}" data-sitekey="{{.Captcha.SiteKey}}"></div>
+		          <script src="{{.Captcha.ScriptURL}}" async defer></script>
+		        </div>
+                        {{end}}
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Continue"}}">
+		      </fieldset>
+		    </form>
+	          </div>
+	        </div>
 {{end}}
 
 {{define "login"}}
               {{ range $providerName, $opts := .Config.Oauth }}
                 <a class="btn btn-block btn-lg btn-social btn-{{ $providerName }}" href="{{ $.Config.LoginPath }}/{{ $providerName }}">
-                  <span class="fa fa-{{ $providerName }}"></span> Sign in with {{ $providerName | ucfirst }}
+                  <span class="fa fa-{{ $providerName }}"></span> {{ t "Sign in with %v" ($providerName | ucfirst) }}
+                </a>
+              {{end}}
+              {{if .Config.SAML}}
+                <a class="btn btn-block btn-lg btn-social btn-default" href="{{ .Config.LoginPath }}/saml">
+                  <span class="fa fa-sign-in"></span> {{ t "Sign in with %v" (or .Config.SAML.label "SAML") }}
                 </a>
               {{end}}
 
-              {{if and (not (eq (len .Config.Backends) 0)) (not (eq (len .Config.Oauth) 0))}}
+              {{if and (not (eq (len .Config.Backends) 0)) (or (not (eq (len .Config.Oauth) 0)) .Config.SAML)}}
                 <div class="login-or-container">
                   <hr class="login-or-hr">
-                  <div class="login-or lead">or</div>
+                  <div class="login-or lead">{{t "or"}}</div>
                 </div>
               {{end}}
 
-              {{if not (eq (len .Config.Backends) 0) }}
+              {{if .TOTP}}
+                {{template "totp" . }}
+              {{else if .MagicLink}}
+                {{template "magiclink" . }}
+              {{else if not (eq (len .Config.Backends) 0) }}
+                {{if .Config.Backends.magiclink}}
+                  {{template "magiclink" . }}
+                {{end}}
+                {{if not (and .Config.Backends.magiclink (eq (len .Config.Backends) 1))}}
                 <div class="panel panel-default">
   	          <div class="panel-heading">  
   		    <div class="panel-title">
-  		      <h4>Sign in</h4>
-                      {{ if .Failure}}<div class="alert alert-warning" role="alert">Invalid credentials</div>{{end}} 
+  		      <h4>{{t "Sign in"}}</h4>
+                      {{ if .Failure}}<div class="alert alert-warning" role="alert">{{t "Invalid credentials"}}</div>{{end}}
+                      {{ if .CSRFFailure}}<div class="alert alert-warning" role="alert">{{t "The form has expired. Please try again."}}</div>{{end}}
+                      {{ if .SessionLimitFailed}}<div class="alert alert-warning" role="alert">{{t "You are signed in on too many devices. Please sign out on another device."}}</div>{{end}}
 		    </div>
 	          </div>
 	          <div class="panel-body">
 		    <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}">
                       <fieldset>
+                        {{template "csrf" . }}
 		        <div class="form-group">
-		          <input class="form-control" placeholder="Username" name="username" value="{{.UserInfo.Sub}}" type="text">
+		          <input class="form-control" placeholder="{{t "Username"}}" name="username" value="{{.UserInfo.Sub}}" type="text">
 		        </div>
 		        <div class="form-group">
-		          <input class="form-control" placeholder="Password" name="password" type="password" value="">
+		          <input class="form-control" placeholder="{{t "Password"}}" name="password" type="password" value="">
 		        </div>
-		        <input class="btn btn-lg btn-success btn-block" type="submit" value="Login">
+                        {{if .Config.RememberMeExpiry}}
+		        <div class="checkbox">
+		          <label><input name="remember_me" type="checkbox" value="true"> {{t "Remember me"}}</label>
+		        </div>
+                        {{end}}
+                        {{if .Captcha}}
+		        <div class="form-group">
+                          {{if .Captcha.Failure}}<div class="alert alert-warning" role="alert">{{t "Please confirm, that you are not a robot"}}</div>{{end}}
+		          <div class="{{.Captcha.WidgetClass}}" data-sitekey="{{.Captcha.SiteKey}}"></div>
+		          <script src="{{.Captcha.ScriptURL}}" async defer></script>
+		        </div>
+                        {{end}}
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="{{t "Login"}}">
 		      </fieldset>
 		    </form>
 	          </div>
 	        </div>
+                {{end}}
+                {{if .Config.PasswordReset}}
+                  <p class="text-center"><a href="{{.Config.LoginPath}}/password/reset">{{t "Forgot your password?"}}</a></p>
+                {{end}}
+                {{if .Config.Registration}}
+                  <p class="text-center"><a href="{{.Config.LoginPath}}/register">{{t "Create an account"}}</a></p>
+                {{end}}
+              {{end}}
+
+              {{if and .Config.WebAuthnRPID (not .TOTP)}}
+                <button class="btn btn-block btn-lg btn-default" type="button" onclick="loginsrvLoginWithPasskey()">{{t "Sign in with a passkey"}}</button>
+                {{template "webauthn" . }}
               {{end}}
 {{end}}`
 
+// themeColorPattern matches hex colors and color names
+var themeColorPattern = regexp.MustCompile(`^(#([0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})|[a-zA-Z]+)$`)
+
+// validateThemeColor checks the theme color, because it is placed into the stylesheet of the login page.
+func validateThemeColor(config *Config) error {
+	if config.ThemeColor != "" && !themeColorPattern.MatchString(config.ThemeColor) {
+		return fmt.Errorf("invalid theme-color %q, use a css color like #0a7d3e", config.ThemeColor)
+	}
+	return nil
+}
+
+// layoutFile is the name of the layout in the template dir
+const layoutFile = "layout.html"
+
 var layout = `<!DOCTYPE html>
-<html>
+<html lang="{{ .Lang }}">
   <head>
     <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="color-scheme" content="light dark">
+    <title>{{ .Config.LoginPageTitle }}</title>
     {{ template "styles" . }}
   </head>
   <body>
     <uic-fragment name="content">
+      {{ template "header" . }}
       <div class="container">
         <div class="row vertical-offset-100">
     	  <div class="col-md-4 col-md-offset-4">
 
+            {{ if .Config.LoginPageLogoURL }}
+              <img class="login-logo" src="{{ .Config.LoginPageLogoURL }}" alt="{{ .Config.LoginPageTitle }}">
+            {{end}}
+
             {{ if .Error}}
               <div class="alert alert-danger" role="alert">
-                <strong>Internal Error. </strong> Please try again later.
+                <strong>{{t "Internal Error."}} </strong> {{t "Please try again later."}}
               </div>
             {{end}}
 
-            {{if .Authenticated}}
+            {{if .Reauth}}
+
+              {{template "reauth" . }}
+
+            {{else if .Authenticated}}
 
               {{template "userInfo" . }}
 
+            {{else if .Registration}}
+
+              {{template "register" . }}
+
+            {{else if .Password}}
+
+              {{template "password" . }}
+
             {{else}}
 
               {{template "login" . }}
@@ -131,21 +598,50 @@ var layout = `<!DOCTYPE html>
 	  </div>
 	</div>
       </div>
+      {{ template "footer" . }}
     </uic-fragment>
   </body>
 </html>`
 
 type loginFormData struct {
-	Error         bool
-	Failure       bool
-	Config        *Config
-	Authenticated bool
-	UserInfo      model.UserInfo
+	// Lang is the language of the form, see language()
+	Lang string
+	// CSRFToken is the token for the forms, which post to the login path, see setCSRFCookie
+	CSRFToken   string
+	CSRFFailure bool
+	// Reauth shows the form of the reauthentication, see handleReauth
+	Reauth bool
+	// Sessions are the sessions of the authenticated user in the session store
+	Sessions           []sessionInfo
+	SessionLimitFailed bool
+	Error              bool
+	Failure            bool
+	Config             *Config
+	Authenticated      bool
+	UserInfo           model.UserInfo
+	TOTP               *totpFormData
+	Device             *deviceFormData
+	Captcha            *captchaFormData
+	MagicLink          *magicLinkFormData
+	Registration       *registrationFormData
+	Password           *passwordFormData
 }
 
 func writeLoginForm(w http.ResponseWriter, params loginFormData) {
+	if params.Lang == "" {
+		params.Lang = defaultLanguage
+	}
 	funcMap := template.FuncMap{
 		"ucfirst": ucfirst,
+		"t": func(message string, args ...interface{}) string {
+			return translate(params.Lang, message, args...)
+		},
+		"static": func(name string) string {
+			if params.Config == nil {
+				return staticPath + "/" + name
+			}
+			return params.Config.LoginPath + staticPath + "/" + name
+		},
 	}
 	templateName := "loginForm"
 	if params.Config != nil && params.Config.Template != "" {
@@ -153,6 +649,15 @@ func writeLoginForm(w http.ResponseWriter, params loginFormData) {
 	}
 	t := template.New(templateName).Funcs(funcMap)
 	t = template.Must(t.Parse(partials))
+	if params.Config != nil && params.Config.TemplateDir != "" {
+		// the login has to stay usable, so a broken template dir falls back to the embedded templates
+		custom, err := parseTemplateDir(t, params.Config.TemplateDir)
+		if err != nil {
+			logging.Logger.WithError(err).Error("can't parse the template dir, using the default templates")
+		} else {
+			t = custom
+		}
+	}
 	if params.Config != nil && params.Config.Template != "" {
 		customTemplate, err := ioutil.ReadFile(params.Config.Template)
 		if err != nil {
@@ -169,6 +674,8 @@ func writeLoginForm(w http.ResponseWriter, params loginFormData) {
 			w.Write([]byte(`Internal Server Error`))
 			return
 		}
+	} else if t.Lookup(layoutFile) != nil {
+		t = t.Lookup(layoutFile)
 	} else {
 		t = template.Must(t.Parse(layout))
 	}
@@ -191,6 +698,30 @@ func writeLoginForm(w http.ResponseWriter, params loginFormData) {
 	w.Write(b.Bytes())
 }
 
+// parseTemplateDir adds the templates of the dir to a copy of the template.
+// The partials of the dir replace the embedded partials of the same name
+// and a layout.html replaces the embedded layout.
+func parseTemplateDir(t *template.Template, dir string) (*template.Template, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	custom, err := t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := custom.New(filepath.Base(file)).Parse(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	return custom, nil
+}
+
 func ucfirst(in string) string {
 	if in == "" {
 		return ""
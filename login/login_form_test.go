@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -81,6 +83,30 @@ func Test_form(t *testing.T) {
 	NotContains(t, recorder.Body.String(), `Error`)
 }
 
+func Test_form_branding(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{
+		Config: &Config{
+			LoginPath:        "/login",
+			Backends:         Options{"simple": {}},
+			LoginPageTitle:   "Example Corp",
+			LoginPageLogoURL: "https://example.com/logo.png",
+		},
+	})
+	Contains(t, recorder.Body.String(), `<title>Example Corp</title>`)
+	Contains(t, recorder.Body.String(), `<img class="login-logo" src="https://example.com/logo.png"`)
+
+	// no logo configured
+	recorder = httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{
+		Config: &Config{
+			LoginPath: "/login",
+			Backends:  Options{"simple": {}},
+		},
+	})
+	NotContains(t, recorder.Body.String(), `login-logo"`)
+}
+
 func Test_form_executeError(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	writeLoginForm(recorder, loginFormData{})
@@ -139,6 +165,57 @@ func Test_form_customTemplate_MissingFile(t *testing.T) {
 	Equal(t, 500, recorder.Code)
 }
 
+func Test_form_templateDir(t *testing.T) {
+	dir := filepath.Dir(writeConfigFile(t, "header.html", `{{define "header"}}<header>ACME Corp</header>{{end}}`+
+		`{{define "styles"}}<link rel="stylesheet" href="{{ static "acme.css" }}">{{end}}`))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "footer.html"), []byte(`{{define "footer"}}<footer>Imprint</footer>{{end}}`), 0600))
+
+	config := &Config{LoginPath: "/login", Backends: Options{"simple": {}}, TemplateDir: dir}
+	recorder := httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config})
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `<header>ACME Corp</header>`)
+	Contains(t, recorder.Body.String(), `<footer>Imprint</footer>`)
+	Contains(t, recorder.Body.String(), `<link rel="stylesheet" href="/login/static/acme.css">`)
+	NotContains(t, recorder.Body.String(), `bootstrap`)
+	Contains(t, recorder.Body.String(), `<form`)
+
+	// the layout can be replaced as well
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "layout.html"), []byte(`<main>{{template "header" .}}{{template "login" .}}</main>`), 0600))
+	recorder = httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config})
+	True(t, strings.HasPrefix(recorder.Body.String(), `<main><header>ACME Corp</header>`))
+	Contains(t, recorder.Body.String(), `<form`)
+
+	// a broken template falls back to the default templates
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "footer.html"), []byte(`{{define "footer"}}`), 0600))
+	recorder = httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config})
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `bootstrap`)
+	NotContains(t, recorder.Body.String(), `ACME Corp`)
+	Contains(t, recorder.Body.String(), `<form`)
+}
+
+func Test_form_theme(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: &Config{LoginPath: "/login", Backends: Options{"simple": {}}}})
+	Contains(t, recorder.Body.String(), `--login-primary: #337ab7;`)
+	Contains(t, recorder.Body.String(), `@media (prefers-color-scheme: dark)`)
+
+	for _, color := range []string{"#0a7d3e", "#0a7d3e80", "rebeccapurple"} {
+		config := &Config{LoginPath: "/login", Backends: Options{"simple": {}}, ThemeColor: color}
+		NoError(t, validateThemeColor(config))
+		recorder = httptest.NewRecorder()
+		writeLoginForm(recorder, loginFormData{Config: config})
+		Contains(t, recorder.Body.String(), `--login-primary: `+color+`;`)
+	}
+
+	for _, color := range []string{"red; } body { display: none", "rgb(10, 125, 62)", "#12"} {
+		Error(t, validateThemeColor(&Config{ThemeColor: color}), color)
+	}
+}
+
 func Test_ucfirst(t *testing.T) {
 	Equal(t, "", ucfirst(""))
 	Equal(t, "A", ucfirst("a"))
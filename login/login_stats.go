@@ -0,0 +1,115 @@
+package login
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var loginStatsBucket = []byte("login_stats")
+
+// LoginStats holds the login statistics of one user.
+type LoginStats struct {
+	Count       int   `json:"count"`
+	LastLoginAt int64 `json:"last_login_at"`
+}
+
+// LoginStatsStore persists the login statistics per user.
+type LoginStatsStore interface {
+	// Get returns the stored statistics of the user or empty statistics, if there are none.
+	Get(key string) (LoginStats, error)
+
+	// Record increments the login counter of the user and sets the time of the last login.
+	Record(key string, at time.Time) error
+}
+
+// boltLoginStatsStore is a LoginStatsStore backed by a BoltDB file.
+type boltLoginStatsStore struct {
+	db *bolt.DB
+}
+
+func newBoltLoginStatsStore(file string) (*boltLoginStatsStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open login stats file %v", file)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(loginStatsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "can't initialize login stats file %v", file)
+	}
+	return &boltLoginStatsStore{db: db}, nil
+}
+
+// Close closes the BoltDB file
+func (s *boltLoginStatsStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltLoginStatsStore) Get(key string) (LoginStats, error) {
+	stats := LoginStats{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return readLoginStats(tx, key, &stats)
+	})
+	return stats, err
+}
+
+func (s *boltLoginStatsStore) Record(key string, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stats := LoginStats{}
+		if err := readLoginStats(tx, key, &stats); err != nil {
+			return err
+		}
+		stats.Count++
+		stats.LastLoginAt = at.Unix()
+		b, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(loginStatsBucket).Put([]byte(key), b)
+	})
+}
+
+func readLoginStats(tx *bolt.Tx, key string, stats *LoginStats) error {
+	v := tx.Bucket(loginStatsBucket).Get([]byte(key))
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(v, stats)
+}
+
+func loginStatsKey(userInfo model.UserInfo) string {
+	return userInfo.Origin + ":" + userInfo.Sub
+}
+
+// applyLoginStats sets the login statistics of the current login to the user info.
+// The store is updated asynchronously, to not delay the token issuance. Close waits for the update.
+func (h *Handler) applyLoginStats(userInfo *model.UserInfo) {
+	if h.loginStats == nil {
+		return
+	}
+	now := time.Now()
+	key := loginStatsKey(*userInfo)
+
+	stats, err := h.loginStats.Get(key)
+	if err != nil {
+		logging.Logger.WithError(err).Warn("can't read login stats")
+	}
+	userInfo.LoginCount = stats.Count + 1
+	userInfo.LastLoginAt = now.Unix()
+
+	h.loginStatsWrites.Add(1)
+	go func() {
+		defer h.loginStatsWrites.Done()
+		if err := h.loginStats.Record(key, now); err != nil {
+			logging.Logger.WithError(err).Warn("can't record login stats")
+		}
+	}()
+}
@@ -0,0 +1,71 @@
+package login
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestLoginStats_BoltStore(t *testing.T) {
+	store, cleanup := tmpLoginStatsStore(t)
+	defer cleanup()
+
+	stats, err := store.Get("simple:bob")
+	NoError(t, err)
+	Equal(t, LoginStats{}, stats)
+
+	first := time.Unix(1546300800, 0)
+	NoError(t, store.Record("simple:bob", first))
+	NoError(t, store.Record("simple:bob", first.Add(time.Hour)))
+
+	stats, err = store.Get("simple:bob")
+	NoError(t, err)
+	Equal(t, LoginStats{Count: 2, LastLoginAt: first.Add(time.Hour).Unix()}, stats)
+
+	stats, err = store.Get("github:bob")
+	NoError(t, err)
+	Equal(t, 0, stats.Count)
+}
+
+func TestHandler_LoginStats(t *testing.T) {
+	store, cleanup := tmpLoginStatsStore(t)
+	defer cleanup()
+	h := testHandler()
+	h.loginStats = store
+	h.loginStatsWrites = &sync.WaitGroup{}
+
+	for i := 1; i <= 2; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+		Equal(t, 200, recorder.Code)
+
+		claims, err := tokenAsMap(recorder.Body.String())
+		NoError(t, err)
+		Equal(t, float64(i), claims["login_count"])
+		InDelta(t, time.Now().Unix(), claims["last_login_at"], 2)
+
+		// the writes are tracked, so that Close waits for them
+		h.loginStatsWrites.Wait()
+		stats, err := store.Get(loginStatsKey(model.UserInfo{Sub: "bob", Origin: SimpleProviderName}))
+		NoError(t, err)
+		Equal(t, i, stats.Count)
+	}
+}
+
+func tmpLoginStatsStore(t *testing.T) (*boltLoginStatsStore, func()) {
+	dir, err := ioutil.TempDir("", "loginsrv-stats")
+	NoError(t, err)
+	store, err := newBoltLoginStatsStore(filepath.Join(dir, "stats.db"))
+	NoError(t, err)
+	return store, func() {
+		store.db.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,177 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/metrics"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+const magicLinkPath = "/magiclink"
+
+// magicLinkRequestLimit is the maximum number of requested links per hour and email address or client ip,
+// so that the login form can't be used to flood the mailboxes
+const magicLinkRequestLimit = 10
+
+// ErrInvalidEmail is returned by a MagicLinkBackend for a malformed email address.
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// MagicLinkBackend is a Backend, which sends one-time login links by email instead of checking a password.
+// The token of the link is passed as password to Authenticate.
+type MagicLinkBackend interface {
+	Backend
+
+	// SendLink sends a link to the email address, which consists of the linkURL and the token as query parameter token.
+	// It should not return an error for unknown addresses, so that the existence of an address is not revealed.
+	SendLink(email, linkURL string) error
+}
+
+// instrumentedMagicLinkBackend records the logins by link in the metrics.
+type instrumentedMagicLinkBackend struct {
+	MagicLinkBackend
+	name string
+}
+
+func (b instrumentedMagicLinkBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	authenticated, userInfo, err := b.MagicLinkBackend.Authenticate(username, password)
+	metrics.Login(b.name, authenticated, err)
+	return authenticated, userInfo, err
+}
+
+// magicLinkFormData holds the state of the magic link login in the login form
+type magicLinkFormData struct {
+	// Token is set on the confirmation page of a link
+	Token string
+	Sent  bool
+	// Failure is set, if the link was invalid, expired or already used
+	Failure bool
+}
+
+// handleMagicLink serves the magic link login:
+// a POST with email sends the link, a GET with token shows the confirmation and a POST with token logs the user in.
+// The confirmation is needed, because mail scanners open the links and would use up the token otherwise.
+func (h *Handler) handleMagicLink(w http.ResponseWriter, r *http.Request) {
+	if h.magicLink == nil {
+		h.respondNotFound(w, r)
+		return
+	}
+
+	r.ParseForm()
+	switch {
+	case r.Method == "GET" && r.FormValue("token") != "":
+		writeLoginForm(w,
+			loginFormData{
+				Lang:      language(r),
+				CSRFToken: csrfFormToken(r),
+				Config:    h.config,
+				MagicLink: &magicLinkFormData{Token: r.FormValue("token")},
+			})
+	case r.Method == "POST" && r.PostFormValue("token") != "":
+		h.handleMagicLinkLogin(w, r, r.PostFormValue("token"))
+	case r.Method == "POST" && r.PostFormValue("email") != "":
+		h.handleMagicLinkRequest(w, r, r.PostFormValue("email"))
+	default:
+		h.respondBadRequest(w, r)
+	}
+}
+
+func (h *Handler) handleMagicLinkRequest(w http.ResponseWriter, r *http.Request, email string) {
+	emailAllowed, emailRetryAfter := h.magicLinkLimiter.Allow("email:" + strings.ToLower(strings.TrimSpace(email)))
+	ipAllowed, ipRetryAfter := h.magicLinkLimiter.Allow("ip:" + clientIP(r))
+	if !emailAllowed || !ipAllowed {
+		logging.Application(r.Header).
+			WithField("email", email).
+			WithField("client_ip", clientIP(r)).Warn("rate limit for login links exceeded")
+		retryAfter := emailRetryAfter
+		if ipRetryAfter > retryAfter {
+			retryAfter = ipRetryAfter
+		}
+		w.Header().Set("Retry-After", fmt.Sprintf("%v", retryAfterSeconds(retryAfter)))
+		if wantJSON(r) {
+			respondJSONError(w, 429, errorRateLimited, "Too Many Requests", retryAfter)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(429)
+		fmt.Fprint(w, translate(language(r), "Please try again later."))
+		return
+	}
+
+	err := h.magicLink.SendLink(email, h.publicURL(h.config.LoginPath+magicLinkPath))
+	if err == ErrInvalidEmail {
+		h.respondBadRequest(w, r)
+		return
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	logging.Application(r.Header).WithField("email", email).Info("requested login link")
+
+	// the response is the same for unknown addresses
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		writeLoginForm(w,
+			loginFormData{
+				Lang:      language(r),
+				CSRFToken: csrfFormToken(r),
+				Config:    h.config,
+				MagicLink: &magicLinkFormData{Sent: true},
+			})
+		return
+	}
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(202)
+	fmt.Fprintf(w, "Login link sent")
+}
+
+func (h *Handler) handleMagicLinkLogin(w http.ResponseWriter, r *http.Request, token string) {
+	// the csrf token is checked before the link is used up, so that other sites can't log a browser in with their own link
+	if h.rejectCSRF(w, r) {
+		return
+	}
+	if !h.beforeAuth(w, r, "", "magiclink") {
+		return
+	}
+	authenticated, userInfo, err := h.magicLink.Authenticate("", token)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.auditEvent(r, audit.ProviderError, "", "magiclink", err.Error())
+		h.respondError(w, r)
+		return
+	}
+	if !authenticated {
+		logging.Application(r.Header).Info("failed authentication with invalid login link")
+		h.auditEvent(r, audit.LoginFailure, "", "magiclink", "invalid login link")
+		if wantHTML(r) {
+			w.Header().Set("Content-Type", contentTypeHTML)
+			w.WriteHeader(403)
+			writeLoginForm(w,
+				loginFormData{
+					Lang:      language(r),
+					CSRFToken: csrfFormToken(r),
+					Config:    h.config,
+					MagicLink: &magicLinkFormData{Failure: true},
+				})
+			return
+		}
+		h.respondAuthFailure(w, r)
+		return
+	}
+
+	// the email address is verified by the link
+	userInfo.Verified = true
+	if h.totp != nil {
+		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("login link accepted, waiting for totp")
+		h.startTOTP(w, r, userInfo)
+		return
+	}
+	logging.Application(r.Header).WithField("username", userInfo.Sub).Info("successfully authenticated by login link")
+	h.completeLogin(w, r, userInfo, userInfo.Origin)
+}
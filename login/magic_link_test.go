@@ -0,0 +1,178 @@
+package login
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+// testMagicLinkBackend accepts the token "valid" once for bob@example.com
+type testMagicLinkBackend struct {
+	links []string
+	used  bool
+	err   error
+}
+
+func (b *testMagicLinkBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if password != "valid" || b.used {
+		return false, model.UserInfo{}, b.err
+	}
+	b.used = true
+	return true, model.UserInfo{Sub: "bob@example.com", Email: "bob@example.com", Origin: "magiclink"}, nil
+}
+
+func (b *testMagicLinkBackend) SendLink(email, linkURL string) error {
+	if email == "invalid" {
+		return ErrInvalidEmail
+	}
+	if b.err != nil {
+		return b.err
+	}
+	b.links = append(b.links, email+" "+linkURL)
+	return nil
+}
+
+func testMagicLinkHandler(backend *testMagicLinkBackend) *Handler {
+	h := testHandler()
+	h.config.Backends = Options{"magiclink": {"smtp_host": "mail.example.com"}}
+	h.config.PublicURL = "https://login.example.com"
+	h.magicLink = instrumentedMagicLinkBackend{MagicLinkBackend: backend, name: "magiclink"}
+	h.magicLinkLimiter = newRateLimiter(magicLinkRequestLimit, time.Hour)
+	return h
+}
+
+func TestHandler_MagicLink(t *testing.T) {
+	backend := &testMagicLinkBackend{}
+	h := testMagicLinkHandler(backend)
+
+	// the login form only has the email form
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), `name="email"`)
+	NotContains(t, recorder.Body.String(), `name="password"`)
+
+	recorder = httptest.NewRecorder()
+	// the link is built by the public-url, not by the host of the request
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", "email=bob@example.com", TypeForm, AcceptHTML, "X-Forwarded-Proto: http", "X-Forwarded-Host: evil.example"))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "we have sent you an email with a login link")
+	Equal(t, []string{"bob@example.com https://login.example.com/context/login/magiclink"}, backend.links)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", "email=bob@example.com", TypeForm))
+	Equal(t, 202, recorder.Code)
+
+	// the link shows a confirmation, so that mail scanners don't use up the token
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/magiclink?token=valid", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `<input name="token" type="hidden" value="valid">`)
+	False(t, backend.used)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login/magiclink", "token=valid", TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+	cookie := recorder.Result().Cookies()[0]
+	Equal(t, h.config.CookieName, cookie.Name)
+	claims, err := tokenAsMap(cookie.Value)
+	NoError(t, err)
+	Equal(t, "bob@example.com", claims["sub"])
+	Equal(t, true, claims["verified"])
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login/magiclink", "token=valid", TypeForm, AcceptHTML)))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "The link is invalid, expired or was already used")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", "token=valid", TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	// with other backends, the login form has the email and the password form
+	h.config.Backends["simple"] = map[string]string{"bob": "secret"}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), `name="email"`)
+	Contains(t, recorder.Body.String(), `name="password"`)
+}
+
+func TestHandler_MagicLink_Errors(t *testing.T) {
+	recorder := call(req("POST", "/context/login/magiclink", "email=bob@example.com", TypeForm))
+	Equal(t, 404, recorder.Code)
+
+	backend := &testMagicLinkBackend{}
+	h := testMagicLinkHandler(backend)
+	for _, r := range []struct {
+		method string
+		body   string
+	}{
+		{"GET", ""},
+		{"POST", ""},
+		{"POST", "email=invalid"},
+		{"DELETE", "token=valid"},
+	} {
+		recorder = httptest.NewRecorder()
+		h.ServeHTTP(recorder, req(r.method, "/context/login/magiclink", r.body, TypeForm))
+		Equal(t, 400, recorder.Code, "%v", r)
+	}
+
+	backend.err = errors.New("smtp error")
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", "email=bob@example.com", TypeForm))
+	Equal(t, 500, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login/magiclink", "token=foo", TypeForm)))
+	Equal(t, 500, recorder.Code)
+}
+
+func TestNewHandler_MagicLinkRequiresPublicURL(t *testing.T) {
+	desc := &ProviderDescription{Name: "testmagiclink"}
+	RegisterProvider(desc, func(config map[string]string) (Backend, error) {
+		return &testMagicLinkBackend{}, nil
+	})
+	defer func() {
+		delete(provider, desc.Name)
+		delete(providerDescription, desc.Name)
+	}()
+
+	config := DefaultConfig()
+	config.Backends = Options{"testmagiclink": {}}
+	_, err := NewHandler(config)
+	EqualError(t, err, "the magiclink backend requires the public-url for the login links")
+
+	config.PublicURL = "https://login.example.com"
+	_, err = NewHandler(config)
+	NoError(t, err)
+}
+
+func TestHandler_MagicLink_RateLimit(t *testing.T) {
+	backend := &testMagicLinkBackend{}
+	h := testMagicLinkHandler(backend)
+	for i := 0; i < magicLinkRequestLimit; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", "email=bob@example.com", TypeForm))
+		Equal(t, 202, recorder.Code)
+	}
+
+	// the links of the address are limited from any client ip, the case of the address does not matter
+	r := req("POST", "/context/login/magiclink", "email=Bob@Example.com", TypeForm, acceptJSON)
+	r.RemoteAddr = "10.0.0.1:1234"
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 429, recorder.Code)
+	NotEqual(t, "", recorder.Header().Get("Retry-After"))
+	Contains(t, recorder.Body.String(), `"error":"rate_limited"`)
+	Equal(t, magicLinkRequestLimit, len(backend.links))
+
+	// the links of the client ip are limited for any address
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", "email=alice@example.com", TypeForm))
+	Equal(t, 429, recorder.Code)
+	Equal(t, magicLinkRequestLimit, len(backend.links))
+}
@@ -0,0 +1,347 @@
+package login
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/oidcserver"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// OIDCDiscoveryPath is the path of the OpenID Connect discovery document.
+// It is served outside of the login path, at the well known location.
+const OIDCDiscoveryPath = "/.well-known/openid-configuration"
+
+const oidcPath = "/oidc"
+
+// oidcAccessTokenType is the typ claim of the access tokens for the clients.
+// They are bound to the client by the aud claim and are not accepted as login token.
+const oidcAccessTokenType = "oidc_access_token"
+
+// the endpoints of the OpenID Connect provider below the oidcPath
+const (
+	oidcAuthorizePath = "/authorize"
+	oidcTokenPath     = "/token"
+	oidcUserInfoPath  = "/userinfo"
+)
+
+// oidcDiscovery is the provider metadata of OpenID Connect Discovery 1.0
+type oidcDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// newOIDCServer creates the OpenID Connect provider for the clients of the config.
+// The id tokens have to be verifiable by the clients with the published key set,
+// so the provider requires a private key.
+func newOIDCServer(config *Config) (*oidcserver.Server, error) {
+	if config.OIDCClients == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(config.JwtAlgo, "HS") || config.JwtPrivateKeyFile == "" {
+		return nil, fmt.Errorf("the oidc provider requires a private key for an RS, PS or ES algorithm, not %v", config.JwtAlgo)
+	}
+	// the issuer and the endpoints must not be built by the host of the request, which can be set by any client
+	if config.OIDCIssuer == "" && config.PublicURL == "" {
+		return nil, errors.New("the oidc provider requires the oidc-issuer or the public-url")
+	}
+	clients, err := oidcserver.ReadClients(config.OIDCClients)
+	if err != nil {
+		return nil, err
+	}
+	return oidcserver.NewServer(clients)
+}
+
+// ProvidesOIDC returns true, if loginsrv is an OpenID Connect provider,
+// so that the discovery document is served at the OIDCDiscoveryPath.
+func (h *Handler) ProvidesOIDC() bool {
+	return h.oidc != nil
+}
+
+func (h *Handler) handleOIDC(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		h.respondNotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, h.config.LoginPath+oidcPath)
+	switch {
+	case path == oidcAuthorizePath:
+		h.handleOIDCAuthorize(w, r, r.URL.Query())
+	case strings.HasPrefix(path, oidcAuthorizePath+"/"):
+		// the authorization request after the login, see handleOIDCAuthorize
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(path, oidcAuthorizePath+"/"))
+		if err != nil {
+			h.respondBadRequest(w, r)
+			return
+		}
+		query, err := url.ParseQuery(string(b))
+		if err != nil {
+			h.respondBadRequest(w, r)
+			return
+		}
+		h.handleOIDCAuthorize(w, r, query)
+	case path == oidcTokenPath:
+		h.handleOIDCToken(w, r)
+	case path == oidcUserInfoPath:
+		h.handleOIDCUserInfo(w, r)
+	default:
+		h.respondNotFound(w, r)
+	}
+}
+
+// handleOIDCAuthorize issues a code for the logged in user and redirects back to the client.
+// Users, who are not logged in, are sent to the login form and come back afterwards.
+func (h *Handler) handleOIDCAuthorize(w http.ResponseWriter, r *http.Request, query url.Values) {
+	if r.Method != "GET" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	authRequest, oidcErr := h.oidc.ParseAuthorizationRequest(query)
+	if authRequest == nil {
+		// the user must not be redirected to a redirect uri, which is not registered
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Bad Request: %v", oidcErr)
+		return
+	}
+	if oidcErr != nil {
+		http.Redirect(w, r, authRequest.ErrorURL(oidcErr), 303)
+		return
+	}
+
+	userInfo, valid := h.GetToken(r)
+	if !valid {
+		if query.Get("prompt") == "none" {
+			http.Redirect(w, r, authRequest.ErrorURL(&oidcserver.Error{Code: "login_required"}), 303)
+			return
+		}
+		// the redirect keeps only the path, so the authorization request is part of the path
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(query.Encode()))
+		h.setRedirectCookieTo(w, h.config.LoginPath+oidcPath+oidcAuthorizePath+"/"+encoded)
+		w.Header().Set("Location", h.config.LoginPath)
+		w.WriteHeader(303)
+		return
+	}
+
+	// the tokens of the client are independent of the token of the login
+	userInfo.ID = ""
+	userInfo.Refreshes = 0
+	redirectURL, err := h.oidc.Authorize(authRequest, userInfo)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	logging.Application(r.Header).WithField("client", authRequest.ClientID).Infof("issued oidc code for %v", userInfo.Sub)
+	http.Redirect(w, r, redirectURL, 303)
+}
+
+// handleOIDCToken redeems a code for an access token and an id token.
+func (h *Handler) handleOIDCToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+
+	grant, oidcErr := h.oidc.Exchange(r)
+	if oidcErr != nil {
+		if oidcErr.Code == "invalid_client" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="loginsrv"`)
+			w.WriteHeader(401)
+		} else {
+			w.WriteHeader(400)
+		}
+		json.NewEncoder(w).Encode(oidcErr) // ignore error of encoding
+		return
+	}
+
+	expiry := time.Now().Add(h.config.JwtExpiry)
+	accessToken, err := h.createOIDCAccessToken(r, grant, expiry)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(oidcserver.Error{Code: "server_error"})
+		return
+	}
+	idToken, err := h.createIDToken(r, grant, expiry)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(oidcserver.Error{Code: "server_error"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(oidcTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(h.config.JwtExpiry.Seconds()),
+		IDToken:     idToken,
+		Scope:       grant.Scope,
+	})
+}
+
+// createOIDCAccessToken creates the access token for the userinfo endpoint. It is issued for the client
+// and has the oidcAccessTokenType, so that it can't be used as login token of the user.
+func (h *Handler) createOIDCAccessToken(r *http.Request, grant oidcserver.Grant, expiry time.Time) (string, error) {
+	userInfo := grant.UserInfo
+	userInfo.Issuer = h.oidcIssuer()
+	userInfo.Audience = grant.ClientID
+	userInfo.TokenType = oidcAccessTokenType
+	userInfo.Expiry = expiry.Unix()
+	userInfo.NotBefore = time.Now().Unix()
+	return h.signOIDCToken(userInfo)
+}
+
+// createIDToken creates the id token for the client. The claims of the user depend on the requested scopes.
+func (h *Handler) createIDToken(r *http.Request, grant oidcserver.Grant, expiry time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"iss": h.oidcIssuer(),
+		"sub": grant.UserInfo.Sub,
+		"aud": grant.ClientID,
+		"exp": expiry.Unix(),
+		"iat": time.Now().Unix(),
+	}
+	if grant.Nonce != "" {
+		claims["nonce"] = grant.Nonce
+	}
+	if grant.HasScope("profile") {
+		if grant.UserInfo.Name != "" {
+			claims["name"] = grant.UserInfo.Name
+		}
+		if grant.UserInfo.Picture != "" {
+			claims["picture"] = grant.UserInfo.Picture
+		}
+	}
+	if grant.HasScope("email") && grant.UserInfo.Email != "" {
+		claims["email"] = grant.UserInfo.Email
+	}
+	if grant.HasScope("groups") && len(grant.UserInfo.Groups) > 0 {
+		claims["groups"] = grant.UserInfo.Groups
+	}
+
+	return h.signOIDCToken(claims)
+}
+
+func (h *Handler) signOIDCToken(claims jwt.Claims) (string, error) {
+	signingMethod, key, verifyKey, err := h.signingInfo()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(signingMethod, claims)
+	token.Header["kid"] = keyID(h.config.JwtAlgo, verifyKey)
+	return token.SignedString(key)
+}
+
+// handleOIDCUserInfo returns the claims of the user for an access token of the token endpoint.
+func (h *Handler) handleOIDCUserInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	var userInfo model.UserInfo
+	valid := false
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		userInfo, valid = h.parseOIDCAccessToken(r, strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer ")))
+	}
+	if !valid {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(401)
+		return
+	}
+
+	claims := userInfo.AsMap()
+	for _, claim := range []string{"exp", "refs", "jti", "iss", "aud", "nbf", "typ"} {
+		delete(claims, claim)
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(claims) // ignore error of encoding
+}
+
+// parseOIDCAccessToken verifies an access token of the token endpoint. Login tokens are not accepted.
+func (h *Handler) parseOIDCAccessToken(r *http.Request, tokenString string) (model.UserInfo, bool) {
+	u := model.UserInfo{}
+	if err := h.parseWithClaims(r, tokenString, &u); err != nil {
+		return model.UserInfo{}, false
+	}
+	return u, u.Valid() == nil && u.TokenType == oidcAccessTokenType
+}
+
+func (h *Handler) handleOIDCDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	if h.oidc == nil {
+		h.respondNotFound(w, r)
+		return
+	}
+
+	endpoint := func(path string) string {
+		return h.oidcURL(h.config.LoginPath + oidcPath + path)
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(oidcDiscovery{
+		Issuer:                            h.oidcIssuer(),
+		AuthorizationEndpoint:             endpoint(oidcAuthorizePath),
+		TokenEndpoint:                     endpoint(oidcTokenPath),
+		UserInfoEndpoint:                  endpoint(oidcUserInfoPath),
+		JWKSURI:                           h.oidcURL(JWKSPath),
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{h.config.JwtAlgo},
+		ScopesSupported:                   []string{oidcserver.ScopeOpenID, "profile", "email", "groups"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "name", "picture", "email", "groups"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	}) // ignore error of encoding
+}
+
+// oidcIssuer returns the configured issuer or the public-url.
+func (h *Handler) oidcIssuer() string {
+	if h.config.OIDCIssuer != "" {
+		return h.config.OIDCIssuer
+	}
+	return strings.TrimSuffix(h.config.PublicURL, "/")
+}
+
+// oidcURL returns the absolute url of the path by the public-url or, without it, by the issuer.
+func (h *Handler) oidcURL(path string) string {
+	if h.config.PublicURL != "" {
+		return h.publicURL(path)
+	}
+	return strings.TrimSuffix(h.config.OIDCIssuer, "/") + path
+}
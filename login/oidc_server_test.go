@@ -0,0 +1,280 @@
+package login
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/oidcserver"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+func testOIDCHandler(t *testing.T) (*Handler, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.JwtAlgo = "RS256"
+	config.JwtPrivateKeyFile = writeTestKeyFile(t, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	config.PublicURL = "https://login.example.com"
+	config.OIDCClients = writeConfigFile(t, "clients.yml", `
+- id: wiki
+  secret: s3cr3t
+  redirect_uris:
+    - https://wiki.example.com/callback
+- id: cli
+  redirect_uris:
+    - http://localhost:8000/callback
+`)
+	h, err := NewHandler(config)
+	NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h, key
+}
+
+func TestHandler_OIDC(t *testing.T) {
+	h, key := testOIDCHandler(t)
+	True(t, h.ProvidesOIDC())
+
+	authorizeURL := "http://login.example.com/context/login/oidc/authorize?" + url.Values{
+		"response_type": {"code"},
+		"client_id":     {"wiki"},
+		"redirect_uri":  {"https://wiki.example.com/callback"},
+		"scope":         {"openid profile email"},
+		"state":         {"xyz"},
+		"nonce":         {"n-0S6"},
+	}.Encode()
+
+	// not logged in: the authorization request is continued after the login
+	browser := newBrowser(t)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", authorizeURL, "", AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/context/login", recorder.Header().Get("Location"))
+	browser.store("/context/login/oidc/authorize", recorder)
+	// the browser sends the redirect cookie to the login
+	redirectCookie := browser.cookie("/context/login", "backTo")
+	NotNil(t, redirectCookie)
+	True(t, strings.HasPrefix(redirectCookie.Value, "/context/login/oidc/authorize/"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret",
+		TypeForm, AcceptHTML, browser.cookies("/context/login"))))
+	Equal(t, 303, recorder.Code)
+	Equal(t, redirectCookie.Value, recorder.Header().Get("Location"))
+	token := recorder.Result().Cookies()[0].Value
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "http://login.example.com"+redirectCookie.Value, "", AcceptHTML, "Cookie: jwt_token="+token))
+	Equal(t, 303, recorder.Code)
+	callback, err := url.Parse(recorder.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, "wiki.example.com", callback.Host)
+	Equal(t, "xyz", callback.Query().Get("state"))
+	code := callback.Query().Get("code")
+	NotEqual(t, "", code)
+
+	// token endpoint
+	r := req("POST", "http://login.example.com/context/login/oidc/token", url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {"https://wiki.example.com/callback"},
+	}.Encode(), TypeForm, "X-Forwarded-Proto: https")
+	r.SetBasicAuth("wiki", "s3cr3t")
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	response := oidcTokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, "Bearer", response.TokenType)
+	Equal(t, "openid profile email", response.Scope)
+
+	idToken := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(response.IDToken, idToken, func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+	NoError(t, err)
+	Equal(t, "https://login.example.com", idToken["iss"])
+	Equal(t, "bob", idToken["sub"])
+	Equal(t, "wiki", idToken["aud"])
+	Equal(t, "n-0S6", idToken["nonce"])
+
+	// the code can only be used once
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), `"error":"invalid_grant"`)
+
+	// userinfo endpoint
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/userinfo", "", "Authorization: Bearer "+response.AccessToken))
+	Equal(t, 200, recorder.Code)
+	userInfo := map[string]interface{}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &userInfo))
+	Equal(t, "bob", userInfo["sub"])
+	NotContains(t, userInfo, "exp")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/userinfo", "", "Authorization: Bearer "+response.IDToken+"x"))
+	Equal(t, 401, recorder.Code)
+	Equal(t, `Bearer error="invalid_token"`, recorder.Header().Get("WWW-Authenticate"))
+
+	// the login token is no access token
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/userinfo", "", "Authorization: Bearer "+token))
+	Equal(t, 401, recorder.Code)
+}
+
+func TestHandler_OIDC_AccessTokenIsNoLoginToken(t *testing.T) {
+	h, key := testOIDCHandler(t)
+	grant := oidcserver.Grant{UserInfo: model.UserInfo{Sub: "bob"}, ClientID: "wiki", Scope: "openid"}
+	r := req("POST", "http://login.example.com/context/login/oidc/token", "")
+	accessToken, err := h.createOIDCAccessToken(r, grant, time.Now().Add(time.Minute))
+	NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(accessToken, claims, func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+	NoError(t, err)
+	Equal(t, "wiki", claims["aud"])
+	Equal(t, "oidc_access_token", claims["typ"])
+
+	_, valid := h.parseToken(r, accessToken)
+	False(t, valid)
+	_, valid = h.GetToken(req("GET", "/context/login", "", "Cookie: jwt_token="+accessToken))
+	False(t, valid)
+	Equal(t, tokenValidateResponse{Reason: "invalid_type"}, h.validateToken(r, accessToken))
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/verify", "", "Authorization: Bearer "+accessToken))
+	Equal(t, 401, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/userinfo", "", "Authorization: Bearer "+accessToken))
+	Equal(t, 200, recorder.Code)
+}
+
+func TestHandler_OIDC_PublicClient(t *testing.T) {
+	h, _ := testOIDCHandler(t)
+	token, err := h.createToken(model.UserInfo{Sub: "marvin", Email: "marvin@example.com", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"cli"},
+		"redirect_uri":          {"http://localhost:8000/callback"},
+		"scope":                 {"openid email"},
+		"code_challenge":        {base64url(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/authorize?"+query.Encode(), "", "Cookie: jwt_token="+token))
+	Equal(t, 303, recorder.Code)
+	callback, err := url.Parse(recorder.Header().Get("Location"))
+	NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "http://login.example.com/context/login/oidc/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {callback.Query().Get("code")},
+		"redirect_uri":  {"http://localhost:8000/callback"},
+		"client_id":     {"cli"},
+		"code_verifier": {verifier},
+	}.Encode(), TypeForm))
+	Equal(t, 200, recorder.Code)
+	response := oidcTokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	idToken := jwt.MapClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(response.IDToken, idToken)
+	NoError(t, err)
+	Equal(t, "marvin@example.com", idToken["email"])
+}
+
+func TestHandler_OIDC_AuthorizeErrors(t *testing.T) {
+	h, _ := testOIDCHandler(t)
+
+	// unknown redirect uri: no redirect
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/authorize?client_id=wiki&redirect_uri=https://evil.example.com/", ""))
+	Equal(t, 400, recorder.Code)
+	Equal(t, "", recorder.Header().Get("Location"))
+
+	// errors are sent to the client
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/authorize?client_id=wiki&redirect_uri=https://wiki.example.com/callback&response_type=code&scope=email&state=xyz", ""))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "https://wiki.example.com/callback?error=invalid_scope&error_description=the+scope+openid+is+required&state=xyz", recorder.Header().Get("Location"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/authorize?client_id=wiki&redirect_uri=https://wiki.example.com/callback&response_type=code&scope=openid&prompt=none", ""))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "https://wiki.example.com/callback?error=login_required", recorder.Header().Get("Location"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/oidc/token", "grant_type=authorization_code&code=xxx&client_id=wiki&client_secret=wrong", TypeForm))
+	Equal(t, 401, recorder.Code)
+	Contains(t, recorder.Body.String(), `"error":"invalid_client"`)
+}
+
+func TestHandler_OIDC_Discovery(t *testing.T) {
+	h, _ := testOIDCHandler(t)
+	recorder := httptest.NewRecorder()
+	// the host of the request does not change the issuer and the endpoints
+	h.ServeHTTP(recorder, req("GET", "http://login.example.com/.well-known/openid-configuration", "", "X-Forwarded-Proto: http", "X-Forwarded-Host: evil.example"))
+	Equal(t, 200, recorder.Code)
+	discovery := oidcDiscovery{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &discovery))
+	Equal(t, "https://login.example.com", discovery.Issuer)
+	Equal(t, "https://login.example.com/context/login/oidc/authorize", discovery.AuthorizationEndpoint)
+	Equal(t, "https://login.example.com/context/login/oidc/token", discovery.TokenEndpoint)
+	Equal(t, "https://login.example.com/.well-known/jwks.json", discovery.JWKSURI)
+	Equal(t, []string{"RS256"}, discovery.IDTokenSigningAlgValuesSupported)
+
+	// without public-url, the endpoints are built by the issuer
+	h.config.PublicURL = ""
+	h.config.OIDCIssuer = "https://id.example.com/"
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "http://evil.example/.well-known/openid-configuration", ""))
+	discovery = oidcDiscovery{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &discovery))
+	Equal(t, "https://id.example.com/", discovery.Issuer)
+	Equal(t, "https://id.example.com/context/login/oidc/token", discovery.TokenEndpoint)
+	Equal(t, "https://id.example.com/.well-known/jwks.json", discovery.JWKSURI)
+
+	// not served without clients
+	h = testHandler()
+	False(t, h.ProvidesOIDC())
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/.well-known/openid-configuration", ""))
+	Equal(t, 404, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/oidc/authorize", ""))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestNewOIDCServer_Errors(t *testing.T) {
+	config := testConfig()
+	config.OIDCClients = writeConfigFile(t, "clients.yml", "- id: wiki\n  redirect_uris: [https://wiki.example.com/callback]\n")
+	_, err := newOIDCServer(config)
+	Error(t, err)
+
+	config.JwtAlgo = "RS256"
+	config.JwtPrivateKeyFile = "key.pem"
+	_, err = newOIDCServer(config)
+	EqualError(t, err, "the oidc provider requires the oidc-issuer or the public-url")
+
+	config.PublicURL = "https://login.example.com"
+	config.OIDCClients = writeConfigFile(t, "clients.yml", "- id: wiki\n")
+	_, err = newOIDCServer(config)
+	Error(t, err)
+}
@@ -0,0 +1,433 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/mailer"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+const (
+	passwordPath      = "/password"
+	passwordResetPath = "/password/reset"
+)
+
+const passwordResetExpiry = time.Hour
+
+// passwordResetLimit is the maximum number of reset requests per hour and username or client ip,
+// so that the reset can't be used to flood the mailboxes of the users
+const passwordResetLimit = 5
+
+// ErrUnknownUser is returned by a PasswordWriter, if the user does not exist.
+var ErrUnknownUser = errors.New("unknown user")
+
+// PasswordWriter is a Backend, which can change the passwords of its users.
+type PasswordWriter interface {
+	Backend
+
+	// SetPassword replaces the password of the user.
+	// It returns ErrUnknownUser, if the user does not exist.
+	SetPassword(username, password string) error
+
+	// UserEmail returns the email address for the reset link, or an empty string, if the backend does not know it.
+	UserEmail(username string) (string, error)
+}
+
+// pendingReset is a requested password reset, which waits for the new password
+type pendingReset struct {
+	username string
+	expiry   time.Time
+}
+
+// passwordManager implements the password change and the password reset by email.
+// The pending resets are kept in memory, so the links only work on the same instance until a restart.
+type passwordManager struct {
+	// name is the provider name of the writer, which is the origin of its users
+	name   string
+	writer PasswordWriter
+	mailer *mailer.Mailer
+
+	// the pending resets are shared with the password manager of a reloaded handler
+	mutex   *sync.Mutex
+	pending map[string]*pendingReset
+	limiter *rateLimiter
+}
+
+// passwordFormData holds the state of the password change or reset in the login form
+type passwordFormData struct {
+	Reset bool
+	// Token is set, when the user chooses the new password after following the reset link
+	Token   string
+	Sent    bool
+	Changed bool
+	Error   string
+}
+
+func newPasswordManager(config *Config, writers map[string]PasswordWriter) (*passwordManager, error) {
+	pm := &passwordManager{
+		mutex:   &sync.Mutex{},
+		pending: map[string]*pendingReset{},
+		limiter: newRateLimiter(passwordResetLimit, time.Hour),
+	}
+	switch {
+	case config.PasswordBackend != "":
+		var exist bool
+		if pm.writer, exist = writers[config.PasswordBackend]; !exist {
+			return nil, fmt.Errorf("password-backend %q is not configured or does not support changing passwords", config.PasswordBackend)
+		}
+		pm.name = config.PasswordBackend
+	case len(writers) == 1:
+		for name, w := range writers {
+			pm.name, pm.writer = name, w
+		}
+	case len(writers) == 0:
+		return nil, errors.New("password change and reset require a htpasswd or db backend")
+	default:
+		return nil, errors.New("password change and reset require the password-backend, because multiple backends support changing passwords")
+	}
+
+	if config.PasswordReset {
+		if config.PublicURL == "" {
+			return nil, errors.New("password reset requires the public-url for the reset links")
+		}
+		var err error
+		if pm.mailer, err = newSMTPMailer(config); err != nil {
+			return nil, fmt.Errorf("password reset requires smtp-host and smtp-from for the reset mails: %v", err)
+		}
+	}
+	return pm, nil
+}
+
+// reload creates the password manager with the writer of the reloaded backends.
+// The pending resets and the rate limit are kept, so that the sent reset links stay valid.
+func (pm *passwordManager) reload(config *Config, writers map[string]PasswordWriter) (*passwordManager, error) {
+	reloaded, err := newPasswordManager(config, writers)
+	if err != nil {
+		return nil, err
+	}
+	reloaded.mutex, reloaded.pending, reloaded.limiter = pm.mutex, pm.pending, pm.limiter
+	return reloaded, nil
+}
+
+// start creates a pending reset and returns the token for the reset link.
+func (pm *passwordManager) start(username string) (string, error) {
+	token, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	// remove the resets, which were never used
+	now := time.Now()
+	for t, p := range pm.pending {
+		if now.After(p.expiry) {
+			delete(pm.pending, t)
+		}
+	}
+	pm.pending[token] = &pendingReset{username: username, expiry: now.Add(passwordResetExpiry)}
+	return token, nil
+}
+
+// lookup returns the username of the reset token, if it has not expired.
+func (pm *passwordManager) lookup(token string) (string, bool) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	p, exist := pm.pending[token]
+	if !exist || time.Now().After(p.expiry) {
+		return "", false
+	}
+	return p.username, true
+}
+
+// finish removes all pending resets of the user, so that older links can't be used after the reset.
+func (pm *passwordManager) finish(username string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	for t, p := range pm.pending {
+		if p.username == username {
+			delete(pm.pending, t)
+		}
+	}
+}
+
+// handlePassword serves the password change for logged in users and the password reset by email.
+func (h *Handler) handlePassword(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == h.config.LoginPath+passwordResetPath {
+		if h.passwords == nil || !h.config.PasswordReset {
+			h.respondNotFound(w, r)
+			return
+		}
+		h.handlePasswordReset(w, r)
+		return
+	}
+
+	if h.passwords == nil || !h.config.PasswordChange {
+		h.respondNotFound(w, r)
+		return
+	}
+	h.handlePasswordChange(w, r)
+}
+
+func (h *Handler) handlePasswordChange(w http.ResponseWriter, r *http.Request) {
+	userInfo, valid := h.GetToken(r)
+	if !valid {
+		if wantHTML(r) {
+			http.Redirect(w, r, h.config.LoginPath, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(401)
+		return
+	}
+
+	data := &passwordFormData{}
+	if userInfo.Origin != h.passwords.name {
+		data.Error = "The password of your account can't be changed here"
+		h.writePasswordForm(w, r, 403, data)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		h.writePasswordForm(w, r, 200, data)
+		return
+	case "POST":
+	default:
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	keys := failureKeys(r, userInfo.Sub)
+	if locked, retryAfter := h.failureLimiter.Locked(keys...); locked {
+		h.respondTooManyFailures(w, r, userInfo.Sub, retryAfter)
+		return
+	}
+
+	r.ParseForm()
+	authenticated, _, err := h.passwords.writer.Authenticate(userInfo.Sub, r.PostFormValue("current_password"))
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	if !authenticated {
+		h.failureLimiter.Fail(keys...)
+		data.Error = "The current password is wrong"
+		h.writePasswordForm(w, r, 403, data)
+		return
+	}
+
+	if data.Error = validatePassword(r.PostFormValue("password"), r.PostFormValue("password_confirmation")); data.Error != "" {
+		h.writePasswordForm(w, r, 400, data)
+		return
+	}
+	if err := h.passwords.writer.SetPassword(userInfo.Sub, r.PostFormValue("password")); err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	h.failureLimiter.Reset(keys[1])
+	// the other sessions may be used by someone, who knows the old password
+	h.deleteOtherSessions(r, userInfo.Sub)
+	logging.Application(r.Header).WithField("username", userInfo.Sub).Info("changed password")
+	h.writePasswordForm(w, r, 200, &passwordFormData{Changed: true})
+}
+
+func (h *Handler) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	data := &passwordFormData{Reset: true}
+	token := r.FormValue("token")
+	switch {
+	case r.Method == "GET" && token == "":
+		h.writePasswordForm(w, r, 200, data)
+	case r.Method == "GET":
+		if _, valid := h.passwords.lookup(token); !valid {
+			data.Error = "The link is invalid or expired"
+			h.writePasswordForm(w, r, 403, data)
+			return
+		}
+		data.Token = token
+		h.writePasswordForm(w, r, 200, data)
+	case r.Method == "POST" && token == "":
+		h.handlePasswordResetRequest(w, r, data)
+	case r.Method == "POST":
+		h.handlePasswordResetConfirmation(w, r, data, token)
+	default:
+		h.respondBadRequest(w, r)
+	}
+}
+
+// handlePasswordResetRequest sends the reset link. The response is the same for unknown users,
+// so that it can't be used to find out, which accounts exist.
+func (h *Handler) handlePasswordResetRequest(w http.ResponseWriter, r *http.Request, data *passwordFormData) {
+	username := strings.TrimSpace(r.PostFormValue("username"))
+	if username == "" {
+		data.Error = "Please enter your username"
+		h.writePasswordForm(w, r, 400, data)
+		return
+	}
+
+	// both keys are counted, so that neither a client nor the requests for a user can flood the mailbox
+	userAllowed, userRetryAfter := h.passwords.limiter.Allow(failureKeyUser + username)
+	ipAllowed, ipRetryAfter := h.passwords.limiter.Allow("ip:" + clientIP(r))
+	if !userAllowed || !ipAllowed {
+		logging.Application(r.Header).
+			WithField("username", username).
+			WithField("client_ip", clientIP(r)).Warn("rate limit for password resets exceeded")
+		retryAfter := userRetryAfter
+		if ipRetryAfter > retryAfter {
+			retryAfter = ipRetryAfter
+		}
+		w.Header().Set("Retry-After", fmt.Sprintf("%v", retryAfterSeconds(retryAfter)))
+		data.Error = "Please try again later."
+		h.writePasswordForm(w, r, 429, data)
+		return
+	}
+
+	email, err := h.passwordResetEmail(username)
+	if err == nil && email != "" {
+		var token string
+		if token, err = h.passwords.start(username); err == nil {
+			link := h.publicURL(h.config.LoginPath+passwordResetPath) + "?token=" + token
+			body := fmt.Sprintf("Hello %v,\n\nyou can choose a new password with the following link:\n\n%v\n\n"+
+				"The link expires in %v. If you did not request a new password, you can ignore this email.\n", username, link, passwordResetExpiry)
+			err = h.passwords.mailer.Send(email, "Reset your password", body)
+		}
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	logging.Application(r.Header).WithField("username", username).Info("requested password reset")
+	data.Sent = true
+	h.writePasswordForm(w, r, 200, data)
+}
+
+// passwordResetEmail returns the email address of the backend or the email claim of the user claims,
+// e.g. of the user file for htpasswd users.
+func (h *Handler) passwordResetEmail(username string) (string, error) {
+	if exists, err := h.passwordUserExists(username); err != nil || !exists {
+		return "", err
+	}
+	email, err := h.passwords.writer.UserEmail(username)
+	if err != nil || email != "" {
+		return email, err
+	}
+	if h.userClaims == nil {
+		return "", nil
+	}
+	claims, err := h.userClaims(model.UserInfo{Sub: username, Origin: h.passwords.name})
+	if err != nil {
+		return "", err
+	}
+	if custom, ok := claims.(customClaims); ok {
+		email, _ = custom["email"].(string)
+	}
+	return email, nil
+}
+
+// passwordUserExists checks the user by the UserExists of backends, which support the registration as well.
+func (h *Handler) passwordUserExists(username string) (bool, error) {
+	if registrar, ok := h.passwords.writer.(UserRegistrar); ok {
+		return registrar.UserExists(username)
+	}
+	return true, nil
+}
+
+func (h *Handler) handlePasswordResetConfirmation(w http.ResponseWriter, r *http.Request, data *passwordFormData, token string) {
+	username, valid := h.passwords.lookup(token)
+	if !valid {
+		data.Error = "The link is invalid or expired"
+		h.writePasswordForm(w, r, 403, data)
+		return
+	}
+
+	data.Token = token
+	if data.Error = validatePassword(r.PostFormValue("password"), r.PostFormValue("password_confirmation")); data.Error != "" {
+		h.writePasswordForm(w, r, 400, data)
+		return
+	}
+
+	err := h.passwords.writer.SetPassword(username, r.PostFormValue("password"))
+	if err == ErrUnknownUser {
+		h.passwords.finish(username)
+		data.Token = ""
+		data.Error = "The link is invalid or expired"
+		h.writePasswordForm(w, r, 403, data)
+		return
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	h.passwords.finish(username)
+	// the sessions may be used by someone, who knows the old password
+	if _, err := h.deleteUserSessions(username); err != nil {
+		logging.Application(r.Header).WithError(err).Error("can not delete the sessions after the password reset")
+	}
+	// the user proved the access to the email address, so the lockout of the account is lifted
+	h.failureLimiter.Reset(failureKeys(r, username)[1])
+	logging.Application(r.Header).WithField("username", username).Info("reset password")
+	h.writePasswordForm(w, r, 200, &passwordFormData{Reset: true, Changed: true})
+}
+
+// deleteOtherSessions removes the sessions and the refresh tokens of the user after a password change,
+// except the session and the refresh token of the request, so that the user stays logged in.
+func (h *Handler) deleteOtherSessions(r *http.Request, username string) {
+	var keep []string
+	if token := h.refreshTokenFromRequest(r); token != "" {
+		keep = append(keep, token)
+	}
+	if h.sessions != nil {
+		current := ""
+		if c, err := r.Cookie(h.config.CookieName); err == nil {
+			current = c.Value
+		}
+		sessions, err := h.sessions.List(username)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error("can not delete the sessions after the password change")
+			return
+		}
+		for _, session := range sessions {
+			if session.ID == current {
+				if session.RefreshToken != "" {
+					keep = append(keep, session.RefreshToken)
+				}
+				continue
+			}
+			if err := h.sessions.Delete(session.ID); err != nil {
+				logging.Application(r.Header).WithError(err).Error("can not delete the sessions after the password change")
+				return
+			}
+		}
+	}
+	if h.refreshTokens != nil {
+		if _, err := h.refreshTokens.DeleteUser(username, keep...); err != nil {
+			logging.Application(r.Header).WithError(err).Error("can not delete the refresh tokens after the password change")
+		}
+	}
+}
+
+func (h *Handler) writePasswordForm(w http.ResponseWriter, r *http.Request, status int, data *passwordFormData) {
+	w.Header().Set("Content-Type", contentTypeHTML)
+	w.WriteHeader(status)
+	writeLoginForm(w,
+		loginFormData{
+			Lang:      language(r),
+			CSRFToken: csrfFormToken(r),
+			Config:    h.config,
+			Password:  data,
+		})
+}
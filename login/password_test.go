@@ -0,0 +1,396 @@
+package login
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/smtp"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+// testPasswordWriter stores the passwords and email addresses in memory
+type testPasswordWriter struct {
+	passwords map[string]string
+	emails    map[string]string
+	err       error
+}
+
+func (pw *testPasswordWriter) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if p, exist := pw.passwords[username]; exist && p == password {
+		return true, model.UserInfo{Sub: username, Origin: "test"}, nil
+	}
+	return false, model.UserInfo{}, nil
+}
+
+func (pw *testPasswordWriter) SetPassword(username, password string) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if _, exist := pw.passwords[username]; !exist {
+		return ErrUnknownUser
+	}
+	pw.passwords[username] = password
+	return nil
+}
+
+func (pw *testPasswordWriter) UserEmail(username string) (string, error) {
+	return pw.emails[username], pw.err
+}
+
+var passwordResetLinkPattern = regexp.MustCompile(`https://login.example.com/context/login/password/reset\?token=(\S+)`)
+
+func testPasswordHandler(t *testing.T, writer *testPasswordWriter) (*Handler, *[]string) {
+	h := testHandler()
+	h.backends = []Backend{writer}
+	h.config.Backends = Options{"test": {}}
+	h.config.PasswordChange = true
+	h.config.PasswordReset = true
+	h.config.SMTPHost = "mail.example.com"
+	h.config.SMTPFrom = "login@example.com"
+	h.config.PublicURL = "https://login.example.com"
+
+	var err error
+	h.passwords, err = newPasswordManager(h.config, map[string]PasswordWriter{"test": writer})
+	NoError(t, err)
+
+	mails := &[]string{}
+	h.passwords.mailer.SendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		*mails = append(*mails, string(msg))
+		return nil
+	}
+	return h, mails
+}
+
+func testPasswordWriterWithBob() *testPasswordWriter {
+	return &testPasswordWriter{
+		passwords: map[string]string{"bob": "secret"},
+		emails:    map[string]string{"bob": "bob@example.com"},
+	}
+}
+
+func loginCookie(t *testing.T, h *Handler, username, password string) string {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username="+username+"&password="+password, TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+	cookie := recorder.Result().Cookies()[0]
+	return "Cookie: " + cookie.Name + "=" + cookie.Value
+}
+
+func TestHandler_PasswordChange(t *testing.T) {
+	writer := testPasswordWriterWithBob()
+	h, _ := testPasswordHandler(t, writer)
+
+	// not logged in
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password", "", AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/context/login", recorder.Header().Get("Location"))
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", "current_password=secret&password=new+secret&password_confirmation=new+secret", TypeForm))
+	Equal(t, 401, recorder.Code)
+
+	cookie := loginCookie(t, h, "bob", "secret")
+
+	// the user info links to the password change
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, cookie))
+	Contains(t, recorder.Body.String(), `href="/context/login/password"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password", "", AcceptHTML, cookie))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="current_password"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", "current_password=wrong&password=new+secret&password_confirmation=new+secret", TypeForm, cookie))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "The current password is wrong")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", "current_password=secret&password=new&password_confirmation=new", TypeForm, cookie))
+	Equal(t, 400, recorder.Code)
+	Equal(t, "secret", writer.passwords["bob"])
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", "current_password=secret&password=new+secret&password_confirmation=new+secret", TypeForm, cookie))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Your password was changed")
+	Equal(t, "new secret", writer.passwords["bob"])
+
+	writer.err = errors.New("connection refused")
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", "current_password=new+secret&password=newer+secret&password_confirmation=newer+secret", TypeForm, cookie))
+	Equal(t, 500, recorder.Code)
+}
+
+func TestHandler_PasswordChange_OtherOrigin(t *testing.T) {
+	h, _ := testPasswordHandler(t, testPasswordWriterWithBob())
+	h.backends = []Backend{NewSimpleBackend(map[string]string{"alice": "secret"})}
+	cookie := loginCookie(t, h, "alice", "secret")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password", "", AcceptHTML, cookie))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "can&#39;t be changed here")
+}
+
+func TestHandler_PasswordReset(t *testing.T) {
+	writer := testPasswordWriterWithBob()
+	h, mails := testPasswordHandler(t, writer)
+
+	// the login form links to the reset
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), `href="/context/login/password/reset"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password/reset", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="username"`)
+
+	// unknown users get the same response, but no mail
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=alice", TypeForm))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "we have sent you an email")
+	Equal(t, 0, len(*mails))
+
+	recorder = httptest.NewRecorder()
+	// the link is built by the public-url, not by the host of the request
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=bob", TypeForm, "X-Forwarded-Proto: http", "X-Forwarded-Host: evil.example"))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "we have sent you an email")
+	Equal(t, 1, len(*mails))
+	Contains(t, (*mails)[0], "To: <bob@example.com>")
+	NotContains(t, (*mails)[0], "evil.example")
+	match := passwordResetLinkPattern.FindStringSubmatch((*mails)[0])
+	Equal(t, 2, len(match))
+	token := match[1]
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password/reset?token="+token, "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="password_confirmation"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "token="+token+"&password=new+secret&password_confirmation=other", TypeForm))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), "The passwords do not match")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "token="+token+"&password=new+secret&password_confirmation=new+secret", TypeForm))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Your password was changed")
+	Equal(t, "new secret", writer.passwords["bob"])
+
+	// the link can only be used once
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "token="+token+"&password=newer+secret&password_confirmation=newer+secret", TypeForm))
+	Equal(t, 403, recorder.Code)
+	Equal(t, "new secret", writer.passwords["bob"])
+}
+
+func TestHandler_PasswordReset_EmailFromUserClaims(t *testing.T) {
+	writer := &testPasswordWriter{passwords: map[string]string{"bob": "secret"}}
+	h, mails := testPasswordHandler(t, writer)
+	h.userClaims = func(userInfo model.UserInfo) (jwt.Claims, error) {
+		Equal(t, model.UserInfo{Sub: "bob", Origin: "test"}, userInfo)
+		return customClaims{"sub": "bob", "email": "bob@example.org"}, nil
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=bob", TypeForm))
+	Equal(t, 200, recorder.Code)
+	Equal(t, 1, len(*mails))
+	Contains(t, (*mails)[0], "To: <bob@example.org>")
+}
+
+func TestHandler_PasswordReset_Errors(t *testing.T) {
+	writer := testPasswordWriterWithBob()
+	h, _ := testPasswordHandler(t, writer)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=", TypeForm))
+	Equal(t, 400, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password/reset?token=unknown", "", AcceptHTML))
+	Equal(t, 403, recorder.Code)
+
+	h.passwords.mailer.SendMail = func(string, smtp.Auth, string, []string, []byte) error {
+		return errors.New("connection refused")
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=bob", TypeForm))
+	Equal(t, 500, recorder.Code)
+
+	writer.err = errors.New("connection refused")
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=bob", TypeForm))
+	Equal(t, 500, recorder.Code)
+}
+
+func TestHandler_Password_Disabled(t *testing.T) {
+	h := testHandler()
+	h.config.Backends = Options{"simple": {"bob": "secret"}}
+
+	for _, path := range []string{"/context/login/password", "/context/login/password/reset"} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", path, "", AcceptHTML))
+		Equal(t, 404, recorder.Code, path)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	NotContains(t, recorder.Body.String(), "/context/login/password")
+
+	// only the reset is enabled
+	h, _ = testPasswordHandler(t, testPasswordWriterWithBob())
+	h.config.PasswordChange = false
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password", "", AcceptHTML))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestNewPasswordManager(t *testing.T) {
+	cfg := testConfig()
+	cfg.PasswordChange = true
+	htpasswd := &testPasswordWriter{}
+	db := &testPasswordWriter{}
+
+	pm, err := newPasswordManager(cfg, map[string]PasswordWriter{"htpasswd": htpasswd})
+	NoError(t, err)
+	Equal(t, "htpasswd", pm.name)
+	Nil(t, pm.mailer)
+
+	_, err = newPasswordManager(cfg, map[string]PasswordWriter{})
+	Error(t, err)
+
+	_, err = newPasswordManager(cfg, map[string]PasswordWriter{"htpasswd": htpasswd, "db": db})
+	Error(t, err)
+
+	cfg.PasswordBackend = "db"
+	pm, err = newPasswordManager(cfg, map[string]PasswordWriter{"htpasswd": htpasswd, "db": db})
+	NoError(t, err)
+	Equal(t, "db", pm.name)
+	True(t, pm.writer == db)
+
+	cfg.PasswordBackend = "ldap"
+	_, err = newPasswordManager(cfg, map[string]PasswordWriter{"htpasswd": htpasswd})
+	Error(t, err)
+
+	// the reset requires the public-url and the smtp settings
+	cfg.PasswordBackend = ""
+	cfg.PasswordReset = true
+	cfg.SMTPHost = "mail.example.com"
+	cfg.SMTPFrom = "login@example.com"
+	_, err = newPasswordManager(cfg, map[string]PasswordWriter{"htpasswd": htpasswd})
+	Error(t, err)
+
+	cfg.PublicURL = "https://login.example.com"
+	pm, err = newPasswordManager(cfg, map[string]PasswordWriter{"htpasswd": htpasswd})
+	NoError(t, err)
+	NotNil(t, pm.mailer)
+
+	cfg.SMTPHost = ""
+	_, err = newPasswordManager(cfg, map[string]PasswordWriter{"htpasswd": htpasswd})
+	Error(t, err)
+}
+
+func TestHandler_PasswordChange_DeletesOtherSessions(t *testing.T) {
+	h, _ := testPasswordHandler(t, testPasswordWriterWithBob())
+	h.sessions = newMemorySessionStore()
+	h.refreshTokens = newMemoryRefreshTokenStore()
+	saveTestSessions(t, h)
+
+	cookie := "Cookie: " + h.config.CookieName + "=current; " + h.refreshTokenCookie().Name + "=current-refresh"
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", "current_password=secret&password=new+secret&password_confirmation=new+secret", TypeForm, cookie))
+	Equal(t, 200, recorder.Code)
+
+	sessions, err := h.sessions.List("bob")
+	NoError(t, err)
+	Equal(t, 1, len(sessions))
+	Equal(t, "current", sessions[0].ID)
+	_, valid, _ := h.refreshTokens.Take("current-refresh")
+	True(t, valid)
+	_, valid, _ = h.refreshTokens.Take("current-session-refresh")
+	True(t, valid)
+	_, valid, _ = h.refreshTokens.Take("other-refresh")
+	False(t, valid)
+	_, valid, _ = h.refreshTokens.Take("alice-refresh")
+	True(t, valid)
+}
+
+func TestHandler_PasswordReset_DeletesSessions(t *testing.T) {
+	h, mails := testPasswordHandler(t, testPasswordWriterWithBob())
+	h.sessions = newMemorySessionStore()
+	h.refreshTokens = newMemoryRefreshTokenStore()
+	saveTestSessions(t, h)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=bob", TypeForm))
+	Equal(t, 200, recorder.Code)
+	token := passwordResetLinkPattern.FindStringSubmatch((*mails)[0])[1]
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "token="+token+"&password=new+secret&password_confirmation=new+secret", TypeForm))
+	Equal(t, 200, recorder.Code)
+
+	sessions, err := h.sessions.List("bob")
+	NoError(t, err)
+	Equal(t, 0, len(sessions))
+	for _, token := range []string{"current-refresh", "current-session-refresh", "other-refresh"} {
+		_, valid, _ := h.refreshTokens.Take(token)
+		False(t, valid, token)
+	}
+	_, valid, _ := h.refreshTokens.Take("alice-refresh")
+	True(t, valid)
+}
+
+func TestHandler_PasswordReset_RateLimit(t *testing.T) {
+	h, mails := testPasswordHandler(t, testPasswordWriterWithBob())
+	for i := 0; i < passwordResetLimit; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=bob", TypeForm))
+		Equal(t, 200, recorder.Code)
+	}
+	Equal(t, passwordResetLimit, len(*mails))
+
+	// the requests for the user are limited from any client ip
+	r := req("POST", "/context/login/password/reset", "username=bob", TypeForm)
+	r.RemoteAddr = "10.0.0.1:1234"
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 429, recorder.Code)
+	NotEqual(t, "", recorder.Header().Get("Retry-After"))
+	Contains(t, recorder.Body.String(), "Please try again later.")
+	Equal(t, passwordResetLimit, len(*mails))
+
+	// the requests of the client ip are limited for any user
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password/reset", "username=alice", TypeForm))
+	Equal(t, 429, recorder.Code)
+}
+
+// saveTestSessions stores the sessions and refresh tokens of bob and alice.
+// The session current with the refresh token current-session-refresh is the session of the request.
+func saveTestSessions(t *testing.T, h *Handler) {
+	expiry := time.Now().Add(time.Hour)
+	bob := model.UserInfo{Sub: "bob", Origin: "test", Expiry: expiry.Unix()}
+	NoError(t, h.sessions.Save(Session{ID: "current", UserInfo: bob, Expiry: expiry, RefreshToken: "current-session-refresh"}))
+	NoError(t, h.sessions.Save(Session{ID: "other", UserInfo: bob, Expiry: expiry, RefreshToken: "other-refresh"}))
+	for token, sub := range map[string]string{
+		"current-refresh":         "bob",
+		"current-session-refresh": "bob",
+		"other-refresh":           "bob",
+		"alice-refresh":           "alice",
+	} {
+		NoError(t, h.refreshTokens.Save(token, model.UserInfo{Sub: sub}, expiry))
+	}
+}
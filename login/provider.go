@@ -1,13 +1,35 @@
 package login
 
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/afdecastro879/loginsrv/oauth2"
+)
+
 // Provider is a factory method for creation of login backends.
 type Provider func(config map[string]string) (Backend, error)
 
 var provider = map[string]Provider{}
 var providerDescription = map[string]*ProviderDescription{}
 
+// providerNamePattern restricts the names to valid flag names
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 // RegisterProvider registers a factory method by the provider name.
+// It should be called in the init function of the package of the backend.
+// It panics, if the name is invalid or already registered, also as oauth provider,
+// because both are configured by an option with the name.
 func RegisterProvider(desc *ProviderDescription, factoryMethod Provider) {
+	if !providerNamePattern.MatchString(desc.Name) {
+		panic(fmt.Sprintf("login: invalid provider name %q, expected lower case letters, digits, '-' and '_'", desc.Name))
+	}
+	if _, exist := provider[desc.Name]; exist {
+		panic(fmt.Sprintf("login: provider %v is registered twice", desc.Name))
+	}
+	if _, exist := oauth2.GetProvider(desc.Name); exist {
+		panic(fmt.Sprintf("login: provider %v is already registered as oauth provider", desc.Name))
+	}
 	provider[desc.Name] = factoryMethod
 	providerDescription[desc.Name] = desc
 }
@@ -1,10 +1,136 @@
 package login
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // ProviderDescription holds the provider metadata for the help message.
 type ProviderDescription struct {
 	// the name of the provider
 	Name string
 
-	// the text for the commandline option
+	// the text for the commandline option, it is generated out of the Options, if it is empty
 	HelpText string
+
+	// Options is the schema of the provider options. If it is set, the options are validated
+	// before the provider is created and unknown options are rejected.
+	// Providers with arbitrary options, like the simple provider, leave it empty.
+	Options []OptionDescription
+}
+
+// OptionType is the type of the value of a provider option
+type OptionType int
+
+// The types of the provider options
+const (
+	OptionString OptionType = iota
+	OptionBool
+	OptionInt
+	OptionDuration
+	OptionURL
+)
+
+// OptionDescription describes an option of a provider
+type OptionDescription struct {
+	// Name is the key of the option, e.g. timeout
+	Name string
+
+	// Type of the value, a string by default
+	Type OptionType
+
+	// Required options have to be set
+	Required bool
+}
+
+// Validate checks the options against the schema of the provider.
+// The errors name the provider and the expected options, so they can be shown to the user.
+func (desc *ProviderDescription) Validate(opts map[string]string) error {
+	if len(desc.Options) == 0 {
+		return nil
+	}
+
+	schema := map[string]OptionDescription{}
+	for _, o := range desc.Options {
+		schema[o.Name] = o
+	}
+
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		o, known := schema[key]
+		if !known {
+			return fmt.Errorf("unknown option %q for the %v provider, expected one of: %v", key, desc.Name, desc.optionNames())
+		}
+		if err := o.validate(opts[key]); err != nil {
+			return fmt.Errorf("invalid value %q of option %q for the %v provider: %v", opts[key], key, desc.Name, err)
+		}
+	}
+
+	for _, o := range desc.Options {
+		if _, exist := opts[o.Name]; o.Required && !exist {
+			return fmt.Errorf("missing option %q for the %v provider", o.Name, desc.Name)
+		}
+	}
+	return nil
+}
+
+func (o OptionDescription) validate(value string) error {
+	switch o.Type {
+	case OptionBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return errors.New("expected true or false")
+		}
+	case OptionInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return errors.New("expected a number")
+		}
+	case OptionDuration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return errors.New("expected a duration, e.g. 5s")
+		}
+	case OptionURL:
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.New("expected an absolute url")
+		}
+	}
+	return nil
+}
+
+func (desc *ProviderDescription) optionNames() string {
+	names := make([]string, 0, len(desc.Options))
+	for _, o := range desc.Options {
+		names = append(names, o.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// helpText returns the HelpText or the text generated out of the options
+func (desc *ProviderDescription) helpText() string {
+	if desc.HelpText != "" || len(desc.Options) == 0 {
+		return desc.HelpText
+	}
+	required := []string{}
+	optional := []string{}
+	for _, o := range desc.Options {
+		if o.Required {
+			required = append(required, o.Name+"=..")
+		} else {
+			optional = append(optional, "[,"+o.Name+"=..]")
+		}
+	}
+	text := strings.Join(required, ",") + strings.Join(optional, "")
+	if len(required) == 0 {
+		text = "[" + strings.TrimPrefix(text, "[,")
+	}
+	return fmt.Sprintf("%v login backend opts: %v", desc.Name, text)
 }
@@ -0,0 +1,95 @@
+package login
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRegisterProvider_OauthCollision(t *testing.T) {
+	Panics(t, func() {
+		RegisterProvider(&ProviderDescription{Name: "github"}, func(config map[string]string) (Backend, error) {
+			return nil, nil
+		})
+	})
+	_, exist := GetProvider("github")
+	False(t, exist)
+}
+
+func TestRegisterProvider_Validation(t *testing.T) {
+	factory := func(config map[string]string) (Backend, error) {
+		return nil, nil
+	}
+	Panics(t, func() { RegisterProvider(&ProviderDescription{Name: "simple"}, factory) })
+	Panics(t, func() { RegisterProvider(&ProviderDescription{Name: ""}, factory) })
+	Panics(t, func() { RegisterProvider(&ProviderDescription{Name: "My Backend"}, factory) })
+}
+
+func TestProviderDescription_Validate(t *testing.T) {
+	desc := &ProviderDescription{
+		Name: "mything",
+		Options: []OptionDescription{
+			{Name: "url", Type: OptionURL, Required: true},
+			{Name: "insecure", Type: OptionBool},
+			{Name: "retries", Type: OptionInt},
+			{Name: "timeout", Type: OptionDuration},
+			{Name: "label"},
+		},
+	}
+
+	NoError(t, desc.Validate(map[string]string{"url": "https://example.com"}))
+	NoError(t, desc.Validate(map[string]string{"url": "https://example.com", "insecure": "true", "retries": "3", "timeout": "5s", "label": "x"}))
+
+	for _, test := range []struct {
+		opts map[string]string
+		err  string
+	}{
+		{map[string]string{"url": "https://example.com", "foo": "bar"}, `unknown option "foo" for the mything provider, expected one of: url, insecure, retries, timeout, label`},
+		{map[string]string{}, `missing option "url" for the mything provider`},
+		{map[string]string{"url": "example.com"}, `invalid value "example.com" of option "url" for the mything provider: expected an absolute url`},
+		{map[string]string{"url": "https://example.com", "insecure": "yes please"}, `expected true or false`},
+		{map[string]string{"url": "https://example.com", "retries": "many"}, `expected a number`},
+		{map[string]string{"url": "https://example.com", "timeout": "5"}, `expected a duration`},
+	} {
+		err := desc.Validate(test.opts)
+		if Error(t, err) {
+			Contains(t, err.Error(), test.err)
+		}
+	}
+
+	// providers without schema accept all options
+	NoError(t, (&ProviderDescription{Name: "simple"}).Validate(map[string]string{"bob": "secret"}))
+}
+
+func TestProviderDescription_HelpText(t *testing.T) {
+	Equal(t, "the help", (&ProviderDescription{Name: "a", HelpText: "the help"}).helpText())
+	Equal(t, "", (&ProviderDescription{Name: "a"}).helpText())
+	Equal(t, "a login backend opts: url=..[,timeout=..]", (&ProviderDescription{
+		Name:    "a",
+		Options: []OptionDescription{{Name: "url", Required: true}, {Name: "timeout"}},
+	}).helpText())
+	Equal(t, "a login backend opts: [timeout=..][,retries=..]", (&ProviderDescription{
+		Name:    "a",
+		Options: []OptionDescription{{Name: "timeout"}, {Name: "retries"}},
+	}).helpText())
+}
+
+func TestNewHandler_ValidatesBackendOptions(t *testing.T) {
+	desc := &ProviderDescription{Name: "mything", Options: []OptionDescription{{Name: "foo"}}}
+	RegisterProvider(desc, func(config map[string]string) (Backend, error) {
+		return NewSimpleBackend(map[string]string{}), nil
+	})
+	defer func() {
+		delete(provider, desc.Name)
+		delete(providerDescription, desc.Name)
+	}()
+
+	config := DefaultConfig()
+	config.Backends = Options{"mything": {"foo": "bar"}}
+	_, err := NewHandler(config)
+	NoError(t, err)
+
+	config.Backends = Options{"mything": {"fo": "bar"}}
+	_, err = NewHandler(config)
+	EqualError(t, err, `unknown option "fo" for the mything provider, expected one of: foo`)
+}
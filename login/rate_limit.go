@@ -0,0 +1,57 @@
+package login
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter counts the requests per key within a fixed time window.
+type rateLimiter struct {
+	limit       int
+	window      time.Duration
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		counts: map[string]int{},
+	}
+}
+
+// Allow counts a request for the key and returns false, if the limit of the current window is exceeded.
+// In that case the duration until the next window starts is returned.
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.windowStart) >= l.window {
+		l.windowStart = now
+		l.counts = map[string]int{}
+	}
+	l.counts[key]++
+	if l.counts[key] > l.limit {
+		return false, l.windowStart.Add(l.window).Sub(now)
+	}
+	return true, 0
+}
+
+// clientIP returns the ip of the connected client.
+// Forwarding headers are ignored, because they can be set by the client to bypass the limits.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,50 @@
+package login
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 50*time.Millisecond)
+
+	allowed, _ := l.Allow("a")
+	True(t, allowed)
+	allowed, _ = l.Allow("a")
+	True(t, allowed)
+	allowed, retryAfter := l.Allow("a")
+	False(t, allowed)
+	True(t, retryAfter > 0 && retryAfter <= 50*time.Millisecond)
+
+	// other keys are counted separately
+	allowed, _ = l.Allow("b")
+	True(t, allowed)
+
+	// a new window resets the counts
+	time.Sleep(60 * time.Millisecond)
+	allowed, _ = l.Allow("a")
+	True(t, allowed)
+}
+
+func TestRateLimiter_Disabled(t *testing.T) {
+	var nilLimiter *rateLimiter
+	for _, l := range []*rateLimiter{nilLimiter, newRateLimiter(0, time.Minute)} {
+		for i := 0; i < 10; i++ {
+			allowed, _ := l.Allow("a")
+			True(t, allowed)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	Equal(t, "192.168.0.1", clientIP(&http.Request{RemoteAddr: "192.168.0.1:1234"}))
+	Equal(t, "::1", clientIP(&http.Request{RemoteAddr: "[::1]:1234"}))
+	Equal(t, "192.168.0.1", clientIP(&http.Request{RemoteAddr: "192.168.0.1"}))
+	Equal(t, "192.168.0.1", clientIP(&http.Request{
+		RemoteAddr: "192.168.0.1:1234",
+		Header:     http.Header{"X-Real-Ip": {"10.0.0.1"}},
+	}))
+}
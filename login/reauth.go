@@ -0,0 +1,67 @@
+package login
+
+import (
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+const reauthPath = "/reauth"
+
+// handleReauth asks for the password again, also if the user has a valid token (step-up authentication).
+// A successful login issues a new token with a fresh auth_time claim, so applications can require
+// a recent authentication for sensitive actions by a redirect to /login/reauth?backTo=...
+func (h *Handler) handleReauth(w http.ResponseWriter, r *http.Request) {
+	if len(h.backends) == 0 {
+		h.respondNotFound(w, r)
+		return
+	}
+
+	userInfo, valid := h.GetToken(r)
+	switch r.Method {
+	case "GET":
+		if !valid {
+			userInfo = model.UserInfo{}
+		}
+		w.Header().Set("Content-Type", contentTypeHTML)
+		writeLoginForm(w,
+			loginFormData{
+				Lang:      language(r),
+				CSRFToken: csrfFormToken(r),
+				Config:    h.config,
+				Reauth:    true,
+				UserInfo:  model.UserInfo{Sub: userInfo.Sub},
+			})
+	case "POST":
+		r.ParseForm()
+		username, password, err := getCredentials(r)
+		if err != nil {
+			h.respondBadRequest(w, r)
+			return
+		}
+		if username == "" {
+			h.respondAuthFailure(w, r)
+			return
+		}
+		if valid && username != userInfo.Sub {
+			// the reauthentication confirms the current user, it is no switch of the account
+			logging.Application(r.Header).
+				WithField("username", username).
+				WithField("current_user", userInfo.Sub).Info("reauthentication as another user")
+			h.respondAuthFailure(w, r)
+			return
+		}
+		if h.rejectCSRF(w, r) {
+			return
+		}
+		h.handleAuthentication(w, r, username, password)
+	default:
+		h.respondBadRequest(w, r)
+	}
+}
+
+// isReauth returns true for the requests of the reauthentication, which get the reauthentication form on errors.
+func (h *Handler) isReauth(r *http.Request) bool {
+	return r.URL.Path == h.config.LoginPath+reauthPath
+}
@@ -0,0 +1,104 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func testReauthHandler(t *testing.T) (*Handler, string) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret", "alice": "secret"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	authTime := time.Now().Add(-time.Hour).Unix()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", AuthTime: authTime, Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	return h, "Cookie: jwt_token=" + token
+}
+
+func TestHandler_Reauth_Form(t *testing.T) {
+	h, cookie := testReauthHandler(t)
+
+	// the form is shown, also with a valid token
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/reauth", "", AcceptHTML, cookie))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Confirm your identity")
+	Contains(t, recorder.Body.String(), `action="/context/login/reauth"`)
+	Contains(t, recorder.Body.String(), `name="username" value="bob" type="text" readonly`)
+	NotContains(t, recorder.Body.String(), "Logout")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/reauth", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `placeholder="Username" name="username" value=""`)
+}
+
+func TestHandler_Reauth_Login(t *testing.T) {
+	h, cookie := testReauthHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login/reauth", "username=bob&password=secret", TypeForm, AcceptHTML, cookie)))
+	Equal(t, 303, recorder.Code)
+
+	claims, err := tokenAsMap(cookieByName(recorder, "jwt_token").Value)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	InDelta(t, time.Now().Unix(), claims["auth_time"], 2)
+
+	// the refresh keeps the auth time
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, cookie))
+	Equal(t, 303, recorder.Code)
+	claims, err = tokenAsMap(cookieByName(recorder, "jwt_token").Value)
+	NoError(t, err)
+	InDelta(t, time.Now().Add(-time.Hour).Unix(), claims["auth_time"], 2)
+}
+
+func TestHandler_Reauth_Failures(t *testing.T) {
+	h, cookie := testReauthHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login/reauth", "username=bob&password=wrong", TypeForm, AcceptHTML, cookie)))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "Confirm your identity")
+	Contains(t, recorder.Body.String(), "Invalid credentials")
+
+	// the reauthentication can't switch the user
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login/reauth", "username=alice&password=secret", TypeForm, AcceptHTML, cookie)))
+	Equal(t, 403, recorder.Code)
+	Nil(t, cookieByName(recorder, "jwt_token"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/reauth", "username=bob&password=secret", TypeForm, AcceptHTML, cookie))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "The form has expired. Please try again.")
+	Contains(t, recorder.Body.String(), "Confirm your identity")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login/reauth", "", cookie))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_Reauth_JSON(t *testing.T) {
+	h, cookie := testReauthHandler(t)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/reauth", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt, cookie))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	InDelta(t, time.Now().Unix(), claims["auth_time"], 2)
+}
+
+func TestHandler_Reauth_WithoutBackends(t *testing.T) {
+	h := testHandler()
+	h.backends = nil
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/reauth", "", AcceptHTML))
+	Equal(t, 404, recorder.Code)
+}
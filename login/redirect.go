@@ -5,22 +5,54 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/pkg/errors"
 )
 
+// compileRedirectHostRegex compiles the redirect-host-regex, which has to match the whole domain.
+func compileRedirectHostRegex(config *Config) (*regexp.Regexp, error) {
+	if config.RedirectHostRegex == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile("^(?:" + config.RedirectHostRegex + ")$")
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid redirect-host-regex %v", config.RedirectHostRegex)
+	}
+	return re, nil
+}
+
 func (h *Handler) setRedirectCookie(w http.ResponseWriter, r *http.Request) {
 	redirectTo := r.URL.Query().Get(h.config.RedirectQueryParameter)
 	if redirectTo != "" && h.allowRedirect(r) && r.Method != "POST" {
-		cookie := http.Cookie{
-			Name:  h.config.RedirectQueryParameter,
-			Value: redirectTo,
-		}
-		http.SetCookie(w, &cookie)
+		h.setRedirectCookieTo(w, redirectTo)
+	}
+}
+
+// setRedirectCookieTo stores the redirect target of the login. The cookie is set for all paths,
+// because it is also set by the pages below the login path, which send the user to the login.
+func (h *Handler) setRedirectCookieTo(w http.ResponseWriter, redirectTo string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:  h.config.RedirectQueryParameter,
+		Value: redirectTo,
+		Path:  "/",
+	})
+}
+
+// redirectTarget returns the redirect target of the login: the query parameter,
+// which is stored as cookie by setRedirectCookie, or the value of the cookie.
+func (h *Handler) redirectTarget(r *http.Request) string {
+	redirectTo := r.URL.Query().Get(h.config.RedirectQueryParameter)
+	if redirectTo != "" && h.allowRedirect(r) && r.Method != "POST" {
+		return redirectTo
+	}
+	if cookie, err := r.Cookie(h.config.RedirectQueryParameter); err == nil {
+		return cookie.Value
 	}
+	return ""
 }
 
 func (h *Handler) deleteRedirectCookie(w http.ResponseWriter, r *http.Request) {
@@ -29,6 +61,7 @@ func (h *Handler) deleteRedirectCookie(w http.ResponseWriter, r *http.Request) {
 		cookie := http.Cookie{
 			Name:    h.config.RedirectQueryParameter,
 			Value:   "delete",
+			Path:    "/",
 			Expires: time.Unix(0, 0),
 		}
 		http.SetCookie(w, &cookie)
@@ -61,9 +94,15 @@ func (h *Handler) redirectURL(r *http.Request, w http.ResponseWriter) string {
 	if foundTarget && h.config.Redirect {
 		sameHost := targetURL.Host == "" || r.Host == targetURL.Host
 		if sameHost && targetURL.Path != "" {
+			// browsers treat //host and /\host as url of another host
+			if strings.HasPrefix(targetURL.Path, "//") || strings.Contains(targetURL.Path, "\\") {
+				logging.Application(r.Header).Warnf("redirect attempt to path '%s', which would leave the domain", targetURL.Path)
+				return h.config.SuccessURL
+			}
 			return targetURL.Path
 		}
-		if !sameHost && h.isRedirectDomainWhitelisted(r, targetURL.Host) {
+		isHTTP := targetURL.Scheme == "http" || targetURL.Scheme == "https"
+		if !sameHost && isHTTP && h.isRedirectDomainWhitelisted(r, targetURL.Host) {
 			return targetURL.String()
 		}
 	}
@@ -95,11 +134,44 @@ func (h *Handler) getRedirectTarget(r *http.Request) (*url.URL, bool) {
 }
 
 func (h *Handler) isRedirectDomainWhitelisted(r *http.Request, host string) bool {
-	if h.config.RedirectHostFile == "" {
-		logging.Application(r.Header).Warnf("redirect attempt to '%s', but no whitelist domain file given", host)
+	if h.config.RedirectHostFile == "" && h.config.RedirectHostWhitelist == "" && h.redirectHostRegex == nil {
+		logging.Application(r.Header).Warnf("redirect attempt to '%s', but no redirect whitelist given", host)
 		return false
 	}
 
+	if isHostInWhitelist(host, h.config.RedirectHostWhitelist) {
+		return true
+	}
+	if h.redirectHostRegex != nil && h.redirectHostRegex.MatchString(strings.ToLower(host)) {
+		return true
+	}
+	if h.config.RedirectHostFile != "" && h.isHostInRedirectHostFile(r, host) {
+		return true
+	}
+	logging.Application(r.Header).Warnf("redirect attempt to '%s', but not in redirect whitelist", host)
+	return false
+}
+
+// isHostInWhitelist checks the host against the comma separated list of domains.
+// An entry like *.example.com matches all subdomains of example.com, but not example.com itself.
+func isHostInWhitelist(host, whitelist string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(whitelist, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, entry[1:]) && len(host) > len(entry)-1 {
+				return true
+			}
+		case host == entry:
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) isHostInRedirectHostFile(r *http.Request, host string) bool {
 	f, err := os.Open(h.config.RedirectHostFile)
 	if err != nil {
 		logging.Application(r.Header).Warnf("can't open redirect whitelist domains file '%s'", h.config.RedirectHostFile)
@@ -113,6 +185,5 @@ func (h *Handler) isRedirectDomainWhitelisted(r *http.Request, host string) bool
 			return true
 		}
 	}
-	logging.Application(r.Header).Warnf("redirect attempt to '%s', but not in redirect whitelist", host)
 	return false
 }
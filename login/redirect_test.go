@@ -1,8 +1,12 @@
 package login
 
 import (
+	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/afdecastro879/loginsrv/oauth2"
@@ -12,17 +16,55 @@ import (
 
 const BadReferer = "Referer: http://evildomain.com"
 
+// browser keeps the cookies of the responses by the path rules of a browser
+type browser struct {
+	jar *cookiejar.Jar
+}
+
+func newBrowser(t *testing.T) *browser {
+	jar, err := cookiejar.New(nil)
+	NoError(t, err)
+	return &browser{jar: jar}
+}
+
+func browserURL(path string) *url.URL {
+	u, _ := url.Parse("http://example.com" + path)
+	return u
+}
+
+// store keeps the cookies, which the response to the request of the path sets
+func (b *browser) store(path string, recorder *httptest.ResponseRecorder) {
+	b.jar.SetCookies(browserURL(path), recorder.Result().Cookies())
+}
+
+// cookies returns the cookie header, which the browser sends to the path
+func (b *browser) cookies(path string) string {
+	values := []string{}
+	for _, c := range b.jar.Cookies(browserURL(path)) {
+		values = append(values, c.Name+"="+c.Value)
+	}
+	return "Cookie: " + strings.Join(values, "; ")
+}
+
+// cookie returns the cookie, which the browser sends to the path
+func (b *browser) cookie(path, name string) *http.Cookie {
+	for _, c := range b.jar.Cookies(browserURL(path)) {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
 func TestRedirect(t *testing.T) {
 	// by default set redirect_cookie
 	recorder := call(req("GET", "/context/login?backTo=/website", "", TypeForm, AcceptHTML))
-	setCookieList := readSetCookies(recorder.Header())
-	Equal(t, 1, len(setCookieList))
-	cookie := setCookieList[0]
-	Equal(t, "backTo", cookie.Name)
+	cookie := cookieByName(recorder, "backTo")
+	NotNil(t, cookie)
 	Equal(t, "/website", cookie.Value)
 
 	// by default allowed redirects
-	recorder = call(req("POST", "/context/login?backTo=/website", "username=bob&password=secret", TypeForm, AcceptHTML))
+	recorder = call(withCSRF(testHandler(), req("POST", "/context/login?backTo=/website", "username=bob&password=secret", TypeForm, AcceptHTML)))
 	Equal(t, 303, recorder.Code)
 	Equal(t, "/website", recorder.Header().Get("Location"))
 }
@@ -39,7 +81,7 @@ func TestRedirect_NotAllowed(t *testing.T) {
 		config: cfg,
 	}
 	recorder := httptest.NewRecorder()
-	h.ServeHTTP(recorder, req("POST", "/login?backTo=/website", "username=bob&password=secret", TypeForm, AcceptHTML))
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/login?backTo=/website", "username=bob&password=secret", TypeForm, AcceptHTML)))
 	Equal(t, 303, recorder.Code)
 	Equal(t, "/", recorder.Header().Get("Location"))
 }
@@ -47,13 +89,11 @@ func TestRedirect_NotAllowed(t *testing.T) {
 func TestRedirect_NonMatchingReferrer(t *testing.T) {
 	// by default don't set redirect cookie if Referer doesn't match origin
 	recorder := call(req("GET", "/context/login?backTo=/website", "", TypeForm, AcceptHTML, BadReferer))
-	setCookieList := readSetCookies(recorder.Header())
-	Equal(t, 0, len(setCookieList))
+	Nil(t, cookieByName(recorder, "backTo"))
 
 	// don't set redirect cookie if referrer is malformed
 	recorder = call(req("GET", "/context/login?backTo=/website", "", TypeForm, AcceptHTML, "Referer: :notvalid"))
-	setCookieList = readSetCookies(recorder.Header())
-	Equal(t, 0, len(setCookieList))
+	Nil(t, cookieByName(recorder, "backTo"))
 
 	// set redirect cookie with mismatch referer if RedirectCheckReferer is false
 	cfg := DefaultConfig()
@@ -67,21 +107,19 @@ func TestRedirect_NonMatchingReferrer(t *testing.T) {
 	}
 	recorder = httptest.NewRecorder()
 	h.ServeHTTP(recorder, req("GET", "/login?backTo=/website", "", TypeForm, AcceptHTML, BadReferer))
-	setCookieList = readSetCookies(recorder.Header())
-	Equal(t, 1, len(setCookieList))
-	cookie := setCookieList[0]
-	Equal(t, "backTo", cookie.Name)
+	cookie := cookieByName(recorder, "backTo")
+	NotNil(t, cookie)
 	Equal(t, "/website", cookie.Value)
 }
 
 func TestRedirect_PreventExternal(t *testing.T) {
 	// by default prevent redirect to external site
-	recorder := call(req("POST", "/context/login?backTo=//evildomain.com/phishing.html", "username=bob&password=secret", TypeForm, AcceptHTML))
+	recorder := call(withCSRF(testHandler(), req("POST", "/context/login?backTo=//evildomain.com/phishing.html", "username=bob&password=secret", TypeForm, AcceptHTML)))
 	Equal(t, 303, recorder.Code)
 	Equal(t, "/", recorder.Header().Get("Location"))
 
 	// by default if the parsed path is empty redirect to SuccessURL
-	recorder = call(req("POST", "/context/login?backTo=https://evildomain.com", "username=bob&password=secret", TypeForm, AcceptHTML))
+	recorder = call(withCSRF(testHandler(), req("POST", "/context/login?backTo=https://evildomain.com", "username=bob&password=secret", TypeForm, AcceptHTML)))
 	Equal(t, 303, recorder.Code)
 	Equal(t, "/", recorder.Header().Get("Location"))
 }
@@ -102,7 +140,7 @@ func TestRedirect_Whitelisting(t *testing.T) {
 		config: cfg,
 	}
 	recorder := httptest.NewRecorder()
-	h.ServeHTTP(recorder, req("POST", "/login?backTo=https://gooddomain.com/website", "username=bob&password=secret", TypeForm, AcceptHTML, BadReferer))
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/login?backTo=https://gooddomain.com/website", "username=bob&password=secret", TypeForm, AcceptHTML, BadReferer)))
 	Equal(t, 303, recorder.Code)
 	Equal(t, "/", recorder.Header().Get("Location"))
 
@@ -113,14 +151,62 @@ func TestRedirect_Whitelisting(t *testing.T) {
 
 	// allow redirect to domains on whitelist
 	recorder = httptest.NewRecorder()
-	h.ServeHTTP(recorder, req("POST", "/login?backTo=https://gooddomain.com/website", "username=bob&password=secret", TypeForm, AcceptHTML, BadReferer))
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/login?backTo=https://gooddomain.com/website", "username=bob&password=secret", TypeForm, AcceptHTML, BadReferer)))
 	Equal(t, 303, recorder.Code)
 	Equal(t, "https://gooddomain.com/website", recorder.Header().Get("Location"))
 
 	// still permit access to domains which are not in the whitelist
 	recorder = httptest.NewRecorder()
-	h.ServeHTTP(recorder, req("POST", "/login?backTo=https://evildomain.com/website", "username=bob&password=secret", TypeForm, AcceptHTML, BadReferer))
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/login?backTo=https://evildomain.com/website", "username=bob&password=secret", TypeForm, AcceptHTML, BadReferer)))
 	Equal(t, 303, recorder.Code)
 	Equal(t, "/", recorder.Header().Get("Location"))
 
 }
+
+func TestRedirect_HostWhitelistAndRegex(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.RedirectHostWhitelist = "gooddomain.com, *.example.org"
+	cfg.RedirectHostRegex = `app-[0-9]+\.example\.net`
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	for _, test := range []struct {
+		backTo   string
+		location string
+	}{
+		{"https://gooddomain.com/website", "https://gooddomain.com/website"},
+		{"https://GoodDomain.com/website", "https://GoodDomain.com/website"},
+		{"https://sub.example.org/website", "https://sub.example.org/website"},
+		{"https://example.org/website", "/"},
+		{"https://evilexample.org/website", "/"},
+		{"https://app-42.example.net/website", "https://app-42.example.net/website"},
+		{"https://app-42.example.net.evildomain.com/website", "/"},
+		{"https://evildomain.com/website", "/"},
+		{"javascript://gooddomain.com/%0Aalert(1)", "/"},
+		{"/%5Cevildomain.com/website", "/"},
+	} {
+		t.Run(test.backTo, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, withCSRF(h, req("POST", "/login?backTo="+url.QueryEscape(test.backTo), "username=bob&password=secret", TypeForm, AcceptHTML, BadReferer)))
+			Equal(t, 303, recorder.Code)
+			Equal(t, test.location, recorder.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRedirect_InvalidHostRegex(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.RedirectHostRegex = "(example.com"
+	_, err := NewHandler(cfg)
+	Error(t, err)
+}
+
+func Test_isHostInWhitelist(t *testing.T) {
+	True(t, isHostInWhitelist("example.com", "foo.com,example.com"))
+	True(t, isHostInWhitelist("a.b.example.com", "*.example.com"))
+	False(t, isHostInWhitelist("example.com", "*.example.com"))
+	False(t, isHostInWhitelist("example.com", ""))
+	False(t, isHostInWhitelist("example.com:8080", "example.com"))
+}
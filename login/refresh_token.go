@@ -0,0 +1,222 @@
+package login
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/metrics"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+const refreshPath = "/refresh"
+
+const refreshTokenHeader = "X-Refresh-Token"
+
+// RefreshTokenStore holds the issued refresh tokens.
+// The default implementation is in memory, so the tokens are lost on restart
+// and are not shared between multiple instances.
+type RefreshTokenStore interface {
+	// Save stores the user info for the refresh token until the expiry.
+	Save(token string, userInfo model.UserInfo, expiry time.Time) error
+
+	// Take returns the user info of the token and removes the token, so that every refresh token can be used once.
+	// It returns false, if the token does not exist or is expired.
+	Take(token string) (model.UserInfo, bool, error)
+
+	// Delete removes the token.
+	Delete(token string) error
+
+	// DeleteUser removes all tokens of the user, except the given tokens, and returns their number.
+	DeleteUser(sub string, except ...string) (int, error)
+}
+
+type refreshTokenEntry struct {
+	userInfo model.UserInfo
+	expiry   time.Time
+}
+
+// memoryRefreshTokenStore is a RefreshTokenStore backed by a map.
+type memoryRefreshTokenStore struct {
+	mutex  sync.Mutex
+	tokens map[string]refreshTokenEntry
+}
+
+func newMemoryRefreshTokenStore() *memoryRefreshTokenStore {
+	return &memoryRefreshTokenStore{tokens: map[string]refreshTokenEntry{}}
+}
+
+func (s *memoryRefreshTokenStore) Save(token string, userInfo model.UserInfo, expiry time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// remove the expired tokens, so that the map does not grow with tokens, which are never used
+	now := time.Now()
+	for t, entry := range s.tokens {
+		if now.After(entry.expiry) {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token] = refreshTokenEntry{userInfo: userInfo, expiry: expiry}
+	metrics.SetRefreshTokens(len(s.tokens))
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) Take(token string) (model.UserInfo, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, exist := s.tokens[token]
+	if !exist {
+		return model.UserInfo{}, false, nil
+	}
+	delete(s.tokens, token)
+	metrics.SetRefreshTokens(len(s.tokens))
+	if time.Now().After(entry.expiry) {
+		return model.UserInfo{}, false, nil
+	}
+	return entry.userInfo, true, nil
+}
+
+func (s *memoryRefreshTokenStore) Delete(token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tokens, token)
+	metrics.SetRefreshTokens(len(s.tokens))
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) DeleteUser(sub string, except ...string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keep := map[string]bool{}
+	for _, t := range except {
+		keep[t] = true
+	}
+	deleted := 0
+	for t, entry := range s.tokens {
+		if entry.userInfo.Sub == sub && !keep[t] {
+			delete(s.tokens, t)
+			deleted++
+		}
+	}
+	metrics.SetRefreshTokens(len(s.tokens))
+	return deleted, nil
+}
+
+// handleRefreshToken exchanges a refresh token for a new jwt and a new refresh token.
+func (h *Handler) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	if h.refreshTokens == nil || r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	token := h.refreshTokenFromRequest(r)
+	if token == "" {
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(400)
+		fmt.Fprint(w, "Bad Request: Expected a refresh_token")
+		return
+	}
+
+	userInfo, valid, err := h.refreshTokens.Take(token)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	if !valid {
+		logging.Application(r.Header).Info("invalid refresh token")
+		h.deleteRefreshToken(w, r)
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(401)
+		fmt.Fprint(w, "Invalid or expired refresh token")
+		return
+	}
+
+	logging.Application(r.Header).WithField("username", userInfo.Sub).Info("refreshed jwt by refresh token")
+	h.auditEvent(r, audit.TokenRefresh, userInfo.Sub, userInfo.Origin, "")
+	h.respondAuthenticated(w, r, userInfo)
+}
+
+// setRefreshToken sets a new refresh token on the response.
+// In the cookie mode, it is a cookie, which is only sent to the login path. The json mode returns it
+// in the body, all others by the X-Refresh-Token header.
+func (h *Handler) setRefreshToken(w http.ResponseWriter, mode, token string, expiry time.Time) {
+	if token == "" {
+		return
+	}
+
+	switch mode {
+	case responseModeCookie:
+		cookie := h.refreshTokenCookie()
+		cookie.Value = token
+		cookie.Expires = expiry
+		http.SetCookie(w, cookie)
+	case responseModeJSON:
+		// the token is part of the json response
+	default:
+		w.Header().Set(refreshTokenHeader, token)
+	}
+}
+
+// newRefreshToken creates and saves a new refresh token for the user.
+func (h *Handler) newRefreshToken(userInfo model.UserInfo) (token string, expiry time.Time, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+
+	userInfo.Expiry = 0
+	expiry = time.Now().Add(h.config.RefreshTokenExpiry)
+	if err := h.refreshTokens.Save(token, userInfo, expiry); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiry, nil
+}
+
+// deleteRefreshToken revokes the refresh token of the request and deletes its cookie.
+func (h *Handler) deleteRefreshToken(w http.ResponseWriter, r *http.Request) {
+	if h.refreshTokens == nil {
+		return
+	}
+	if token := h.refreshTokenFromRequest(r); token != "" {
+		if err := h.refreshTokens.Delete(token); err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+		}
+	}
+	if _, err := r.Cookie(h.refreshTokenCookie().Name); err == nil {
+		cookie := h.refreshTokenCookie()
+		cookie.Value = "delete"
+		cookie.Expires = time.Unix(0, 0)
+		http.SetCookie(w, cookie)
+	}
+}
+
+func (h *Handler) refreshTokenFromRequest(r *http.Request) string {
+	if token := r.PostFormValue("refresh_token"); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(h.refreshTokenCookie().Name); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func (h *Handler) refreshTokenCookie() *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     h.config.CookieName + "_refresh",
+		HttpOnly: true,
+		Path:     h.config.LoginPath,
+		Secure:   h.config.CookieSecure,
+	}
+	h.applyCookieAttributes(cookie)
+	return cookie
+}
@@ -0,0 +1,105 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_RefreshToken(t *testing.T) {
+	h := testHandler()
+	h.config.RefreshTokenExpiry = time.Hour
+	h.refreshTokens = newMemoryRefreshTokenStore()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	refreshToken := recorder.Header().Get("X-Refresh-Token")
+	NotEmpty(t, refreshToken)
+
+	// exchange the refresh token
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/refresh", "refresh_token="+refreshToken, TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "application/jwt", recorder.Header().Get("Content-Type"))
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+
+	// the token is rotated
+	newRefreshToken := recorder.Header().Get("X-Refresh-Token")
+	NotEmpty(t, newRefreshToken)
+	NotEqual(t, refreshToken, newRefreshToken)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/refresh", "refresh_token="+refreshToken, TypeForm, AcceptJwt))
+	Equal(t, 401, recorder.Code)
+}
+
+func TestHandler_RefreshToken_Cookie(t *testing.T) {
+	h := testHandler()
+	h.config.RefreshTokenExpiry = time.Hour
+	h.refreshTokens = newMemoryRefreshTokenStore()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+
+	var refreshCookie string
+	for _, c := range readSetCookies(recorder.Header()) {
+		if c.Name == "jwt_token_refresh" {
+			refreshCookie = c.Value
+			Equal(t, "/context/login", c.Path)
+			True(t, c.HttpOnly)
+		}
+	}
+	NotEmpty(t, refreshCookie)
+
+	// logout revokes the refresh token
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", "", "Cookie: jwt_token_refresh="+refreshCookie))
+	_, valid, _ := h.refreshTokens.Take(refreshCookie)
+	False(t, valid)
+}
+
+func TestHandler_RefreshToken_Disabled(t *testing.T) {
+	recorder := call(req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Empty(t, recorder.Header().Get("X-Refresh-Token"))
+
+	recorder = call(req("POST", "/context/login/refresh", "refresh_token=foo", TypeForm))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestMemoryRefreshTokenStore(t *testing.T) {
+	s := newMemoryRefreshTokenStore()
+	NoError(t, s.Save("valid", model.UserInfo{Sub: "bob"}, time.Now().Add(time.Minute)))
+	NoError(t, s.Save("expired", model.UserInfo{Sub: "alice"}, time.Now().Add(-time.Minute)))
+
+	userInfo, valid, err := s.Take("valid")
+	NoError(t, err)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	_, valid, _ = s.Take("valid")
+	False(t, valid)
+	_, valid, _ = s.Take("expired")
+	False(t, valid)
+	_, valid, _ = s.Take("unknown")
+	False(t, valid)
+
+	NoError(t, s.Save("a", model.UserInfo{Sub: "bob"}, time.Now().Add(time.Minute)))
+	NoError(t, s.Save("b", model.UserInfo{Sub: "bob"}, time.Now().Add(time.Minute)))
+	NoError(t, s.Save("c", model.UserInfo{Sub: "alice"}, time.Now().Add(time.Minute)))
+	NoError(t, s.Save("d", model.UserInfo{Sub: "bob"}, time.Now().Add(time.Minute)))
+	deleted, err := s.DeleteUser("bob", "d")
+	NoError(t, err)
+	Equal(t, 2, deleted)
+	_, valid, _ = s.Take("c")
+	True(t, valid)
+	_, valid, _ = s.Take("d")
+	True(t, valid)
+}
@@ -0,0 +1,337 @@
+package login
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/mailer"
+)
+
+const registerPath = "/register"
+
+const (
+	registrationExpiry = 24 * time.Hour
+	// minPasswordLength is the minimum length of the passwords, which are chosen by the users
+	minPasswordLength = 8
+	maxUsernameLength = 64
+)
+
+// ErrUserExists is returned by a UserRegistrar, if the username is already taken.
+var ErrUserExists = errors.New("user already exists")
+
+// UserRegistrar is a Backend, which can store new users.
+type UserRegistrar interface {
+	Backend
+
+	// UserExists returns true, if the username is already taken.
+	UserExists(username string) (bool, error)
+
+	// Register stores the new user with the password.
+	// It returns ErrUserExists, if the username is already taken.
+	Register(username, password, email string) error
+}
+
+// pendingRegistration is a registration, which waits for the verification of the email address
+type pendingRegistration struct {
+	username string
+	email    string
+	expiry   time.Time
+}
+
+// userRegistration implements the self-service registration with the verification of the email address.
+// The pending registrations are kept in memory, so the links only work on the same instance until a restart.
+type userRegistration struct {
+	registrar   UserRegistrar
+	mailer      *mailer.Mailer
+	inviteCodes []string
+
+	// the pending registrations are shared with the registration of a reloaded handler
+	mutex   *sync.Mutex
+	pending map[string]*pendingRegistration
+}
+
+// registrationFormData holds the state of the registration in the login form
+type registrationFormData struct {
+	InviteCodeRequired bool
+	// Token is set, when the user chooses the password after the verification of the email address
+	Token    string
+	Username string
+	Email    string
+	Sent     bool
+	Created  bool
+	Error    string
+}
+
+// newSMTPMailer creates the mailer for the mails of loginsrv itself.
+func newSMTPMailer(config *Config) (*mailer.Mailer, error) {
+	return mailer.NewMailer(mailer.Config{
+		Host:     config.SMTPHost,
+		Port:     config.SMTPPort,
+		Username: config.SMTPUsername,
+		Password: config.SMTPPassword,
+		From:     config.SMTPFrom,
+	})
+}
+
+func newUserRegistration(config *Config, registrars map[string]UserRegistrar) (*userRegistration, error) {
+	var registrar UserRegistrar
+	switch {
+	case config.RegistrationBackend != "":
+		var exist bool
+		if registrar, exist = registrars[config.RegistrationBackend]; !exist {
+			return nil, fmt.Errorf("registration-backend %q is not configured or does not support the registration", config.RegistrationBackend)
+		}
+	case len(registrars) == 1:
+		for _, r := range registrars {
+			registrar = r
+		}
+	case len(registrars) == 0:
+		return nil, errors.New("registration requires a htpasswd or db backend")
+	default:
+		return nil, errors.New("registration requires the registration-backend, because multiple backends support the registration")
+	}
+
+	if config.PublicURL == "" {
+		return nil, errors.New("registration requires the public-url for the verification links")
+	}
+
+	m, err := newSMTPMailer(config)
+	if err != nil {
+		return nil, fmt.Errorf("registration requires smtp-host and smtp-from for the verification mails: %v", err)
+	}
+
+	var inviteCodes []string
+	for _, code := range strings.Split(config.RegistrationInviteCodes, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			inviteCodes = append(inviteCodes, code)
+		}
+	}
+
+	return &userRegistration{
+		registrar:   registrar,
+		mailer:      m,
+		inviteCodes: inviteCodes,
+		mutex:       &sync.Mutex{},
+		pending:     map[string]*pendingRegistration{},
+	}, nil
+}
+
+// reload creates the registration with the registrar of the reloaded backends.
+// The pending registrations are kept, so that the sent verification links stay valid.
+func (reg *userRegistration) reload(config *Config, registrars map[string]UserRegistrar) (*userRegistration, error) {
+	reloaded, err := newUserRegistration(config, registrars)
+	if err != nil {
+		return nil, err
+	}
+	reloaded.mutex, reloaded.pending = reg.mutex, reg.pending
+	return reloaded, nil
+}
+
+func (reg *userRegistration) validInviteCode(code string) bool {
+	if len(reg.inviteCodes) == 0 {
+		return true
+	}
+	valid := false
+	for _, c := range reg.inviteCodes {
+		if subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// start creates a pending registration and returns the token for the verification link.
+func (reg *userRegistration) start(username, email string) (string, error) {
+	token, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	// remove the registrations, which were never verified
+	now := time.Now()
+	for t, p := range reg.pending {
+		if now.After(p.expiry) {
+			delete(reg.pending, t)
+		}
+	}
+	reg.pending[token] = &pendingRegistration{username: username, email: email, expiry: now.Add(registrationExpiry)}
+	return token, nil
+}
+
+// lookup returns the pending registration of the token, if it has not expired.
+func (reg *userRegistration) lookup(token string) (pendingRegistration, bool) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	p, exist := reg.pending[token]
+	if !exist || time.Now().After(p.expiry) {
+		return pendingRegistration{}, false
+	}
+	return *p, true
+}
+
+func (reg *userRegistration) finish(token string) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	delete(reg.pending, token)
+}
+
+// validUsername accepts the usernames, which can be stored in all backends, e.g. without colon for htpasswd.
+func validUsername(username string) bool {
+	if username == "" || len(username) > maxUsernameLength || strings.Contains(username, ":") {
+		return false
+	}
+	for _, c := range username {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// msgPasswordTooShort is the message of validatePassword for too short passwords
+var msgPasswordTooShort = fmt.Sprintf("The password must have at least %v characters", minPasswordLength)
+
+// validatePassword returns a message for the user, if the password can't be used.
+func validatePassword(password, confirmation string) string {
+	if len(password) < minPasswordLength {
+		return msgPasswordTooShort
+	}
+	if password != confirmation {
+		return "The passwords do not match"
+	}
+	return ""
+}
+
+// handleRegistration serves the registration form, sends the verification mail
+// and creates the user after the verification with the chosen password.
+func (h *Handler) handleRegistration(w http.ResponseWriter, r *http.Request) {
+	if h.registration == nil {
+		h.respondNotFound(w, r)
+		return
+	}
+
+	r.ParseForm()
+	data := &registrationFormData{InviteCodeRequired: len(h.registration.inviteCodes) > 0}
+	token := r.FormValue("token")
+	switch {
+	case r.Method == "GET" && token == "":
+		h.writeRegistrationForm(w, r, 200, data)
+	case r.Method == "GET":
+		if _, valid := h.registration.lookup(token); !valid {
+			data.Error = "The link is invalid or expired"
+			h.writeRegistrationForm(w, r, 403, data)
+			return
+		}
+		data.Token = token
+		h.writeRegistrationForm(w, r, 200, data)
+	case r.Method == "POST" && token == "":
+		h.handleRegistrationRequest(w, r, data)
+	case r.Method == "POST":
+		h.handleRegistrationPassword(w, r, data, token)
+	default:
+		h.respondBadRequest(w, r)
+	}
+}
+
+func (h *Handler) handleRegistrationRequest(w http.ResponseWriter, r *http.Request, data *registrationFormData) {
+	data.Username = strings.TrimSpace(r.PostFormValue("username"))
+	data.Email = strings.TrimSpace(r.PostFormValue("email"))
+	address, err := mail.ParseAddress(data.Email)
+	switch {
+	case !h.registration.validInviteCode(r.PostFormValue("invite_code")):
+		data.Error = "Invalid invite code"
+	case !validUsername(data.Username):
+		data.Error = "Invalid username"
+	case err != nil:
+		data.Error = "Invalid email address"
+	}
+	if data.Error != "" {
+		h.writeRegistrationForm(w, r, 400, data)
+		return
+	}
+
+	exists, err := h.registration.registrar.UserExists(data.Username)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	if exists {
+		data.Error = "The username is already taken"
+		h.writeRegistrationForm(w, r, 409, data)
+		return
+	}
+
+	token, err := h.registration.start(data.Username, address.Address)
+	if err == nil {
+		link := h.publicURL(h.config.LoginPath+registerPath) + "?token=" + token
+		body := fmt.Sprintf("Hello %v,\n\nplease confirm your email address and choose your password to complete the registration:\n\n%v\n\n"+
+			"The link expires in %v. If you did not register, you can ignore this email.\n", data.Username, link, registrationExpiry)
+		err = h.registration.mailer.Send(address.Address, "Complete your registration", body)
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	logging.Application(r.Header).WithField("username", data.Username).Info("sent registration verification mail")
+	data.Sent = true
+	h.writeRegistrationForm(w, r, 200, data)
+}
+
+func (h *Handler) handleRegistrationPassword(w http.ResponseWriter, r *http.Request, data *registrationFormData, token string) {
+	pending, valid := h.registration.lookup(token)
+	if !valid {
+		data.Error = "The link is invalid or expired"
+		h.writeRegistrationForm(w, r, 403, data)
+		return
+	}
+
+	data.Token = token
+	if data.Error = validatePassword(r.PostFormValue("password"), r.PostFormValue("password_confirmation")); data.Error != "" {
+		h.writeRegistrationForm(w, r, 400, data)
+		return
+	}
+
+	err := h.registration.registrar.Register(pending.username, r.PostFormValue("password"), pending.email)
+	if err == ErrUserExists {
+		h.registration.finish(token)
+		data.Token = ""
+		data.Error = "The username is already taken"
+		h.writeRegistrationForm(w, r, 409, data)
+		return
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	h.registration.finish(token)
+	logging.Application(r.Header).WithField("username", pending.username).Info("registered user")
+	h.writeRegistrationForm(w, r, 200, &registrationFormData{Created: true, Username: pending.username})
+}
+
+func (h *Handler) writeRegistrationForm(w http.ResponseWriter, r *http.Request, status int, data *registrationFormData) {
+	w.Header().Set("Content-Type", contentTypeHTML)
+	w.WriteHeader(status)
+	writeLoginForm(w,
+		loginFormData{
+			Lang:         language(r),
+			CSRFToken:    csrfFormToken(r),
+			Config:       h.config,
+			Registration: data,
+		})
+}
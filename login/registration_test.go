@@ -0,0 +1,234 @@
+package login
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/smtp"
+	"regexp"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+// testRegistrar stores the registered users in memory
+type testRegistrar struct {
+	users map[string]string
+	err   error
+}
+
+func (r *testRegistrar) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if p, exist := r.users[username]; exist && p == password {
+		return true, model.UserInfo{Sub: username}, nil
+	}
+	return false, model.UserInfo{}, nil
+}
+
+func (r *testRegistrar) UserExists(username string) (bool, error) {
+	_, exist := r.users[username]
+	return exist, r.err
+}
+
+func (r *testRegistrar) Register(username, password, email string) error {
+	if r.err != nil {
+		return r.err
+	}
+	if _, exist := r.users[username]; exist {
+		return ErrUserExists
+	}
+	r.users[username] = password
+	return nil
+}
+
+var registrationLinkPattern = regexp.MustCompile(`https://login.example.com/context/login/register\?token=(\S+)`)
+
+func testRegistrationHandler(t *testing.T, registrar *testRegistrar, inviteCodes string) (*Handler, *[]string) {
+	h := testHandler()
+	h.config.Backends = Options{"simple": {"bob": "secret"}}
+	h.config.Registration = true
+	h.config.RegistrationInviteCodes = inviteCodes
+	h.config.SMTPHost = "mail.example.com"
+	h.config.SMTPFrom = "login@example.com"
+	h.config.PublicURL = "https://login.example.com"
+
+	var err error
+	h.registration, err = newUserRegistration(h.config, map[string]UserRegistrar{"test": registrar})
+	NoError(t, err)
+
+	mails := &[]string{}
+	h.registration.mailer.SendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		*mails = append(*mails, string(msg))
+		return nil
+	}
+	return h, mails
+}
+
+func TestHandler_Registration(t *testing.T) {
+	registrar := &testRegistrar{users: map[string]string{"bob": "secret"}}
+	h, mails := testRegistrationHandler(t, registrar, "")
+
+	// the login form links to the registration
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), `href="/context/login/register"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/register", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="email"`)
+	NotContains(t, recorder.Body.String(), `name="invite_code"`)
+
+	recorder = httptest.NewRecorder()
+	// the link is built by the public-url, not by the host of the request
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "username=alice&email=alice@example.com", TypeForm, "X-Forwarded-Proto: http", "X-Forwarded-Host: evil.example"))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "We have sent you an email")
+	Equal(t, 1, len(*mails))
+	Contains(t, (*mails)[0], "To: <alice@example.com>")
+	NotContains(t, (*mails)[0], "evil.example")
+	match := registrationLinkPattern.FindStringSubmatch((*mails)[0])
+	Equal(t, 2, len(match))
+	token := match[1]
+
+	// the link shows the password form, the user is created after submitting it
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/register?token="+token, "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="password_confirmation"`)
+	NotContains(t, registrar.users, "alice")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "token="+token+"&password=short&password_confirmation=short", TypeForm))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), "at least 8 characters")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "token="+token+"&password=wonderland&password_confirmation=wonderlant", TypeForm))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), "The passwords do not match")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "token="+token+"&password=wonderland&password_confirmation=wonderland", TypeForm))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Your account alice was created")
+	Equal(t, "wonderland", registrar.users["alice"])
+
+	// the link can only be used once
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "token="+token+"&password=wonderland&password_confirmation=wonderland", TypeForm))
+	Equal(t, 403, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/register?token="+token, "", AcceptHTML))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_Registration_InvalidRequests(t *testing.T) {
+	registrar := &testRegistrar{users: map[string]string{"bob": "secret"}}
+	h, mails := testRegistrationHandler(t, registrar, "")
+
+	for _, test := range []struct {
+		body    string
+		code    int
+		message string
+	}{
+		{"username=&email=alice@example.com", 400, "Invalid username"},
+		{"username=al:ice&email=alice@example.com", 400, "Invalid username"},
+		{"username=al+ice&email=alice@example.com", 400, "Invalid username"},
+		{"username=alice&email=alice", 400, "Invalid email address"},
+		{"username=bob&email=bob@example.com", 409, "The username is already taken"},
+	} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/register", test.body, TypeForm))
+		Equal(t, test.code, recorder.Code, test.body)
+		Contains(t, recorder.Body.String(), test.message, test.body)
+	}
+	Equal(t, 0, len(*mails))
+
+	registrar.err = errors.New("connection refused")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "username=alice&email=alice@example.com", TypeForm))
+	Equal(t, 500, recorder.Code)
+
+	registrar.err = nil
+	h.registration.mailer.SendMail = func(string, smtp.Auth, string, []string, []byte) error {
+		return errors.New("connection refused")
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "username=alice&email=alice@example.com", TypeForm))
+	Equal(t, 500, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "token=unknown&password=wonderland&password_confirmation=wonderland", TypeForm))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_Registration_InviteCode(t *testing.T) {
+	h, mails := testRegistrationHandler(t, &testRegistrar{users: map[string]string{}}, "abc, def")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/register", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), `name="invite_code"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "username=alice&email=alice@example.com&invite_code=xyz", TypeForm))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), "Invalid invite code")
+	Equal(t, 0, len(*mails))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/register", "username=alice&email=alice@example.com&invite_code=def", TypeForm))
+	Equal(t, 200, recorder.Code)
+	Equal(t, 1, len(*mails))
+}
+
+func TestHandler_Registration_Disabled(t *testing.T) {
+	h := testHandler()
+	h.config.Backends = Options{"simple": {"bob": "secret"}}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/register", "", AcceptHTML))
+	Equal(t, 404, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), `name="password"`)
+	NotContains(t, recorder.Body.String(), "/context/login/register")
+}
+
+func TestNewUserRegistration(t *testing.T) {
+	cfg := testConfig()
+	cfg.SMTPHost = "mail.example.com"
+	cfg.SMTPFrom = "login@example.com"
+	cfg.PublicURL = "https://login.example.com"
+	htpasswd := &testRegistrar{}
+	db := &testRegistrar{}
+
+	reg, err := newUserRegistration(cfg, map[string]UserRegistrar{"htpasswd": htpasswd})
+	NoError(t, err)
+	True(t, reg.registrar == htpasswd)
+
+	_, err = newUserRegistration(cfg, map[string]UserRegistrar{})
+	Error(t, err)
+
+	_, err = newUserRegistration(cfg, map[string]UserRegistrar{"htpasswd": htpasswd, "db": db})
+	Error(t, err)
+
+	cfg.RegistrationBackend = "db"
+	reg, err = newUserRegistration(cfg, map[string]UserRegistrar{"htpasswd": htpasswd, "db": db})
+	NoError(t, err)
+	True(t, reg.registrar == db)
+
+	cfg.RegistrationBackend = "ldap"
+	_, err = newUserRegistration(cfg, map[string]UserRegistrar{"htpasswd": htpasswd})
+	Error(t, err)
+
+	cfg.RegistrationBackend = ""
+	cfg.PublicURL = ""
+	_, err = newUserRegistration(cfg, map[string]UserRegistrar{"htpasswd": htpasswd})
+	Error(t, err)
+
+	cfg.PublicURL = "https://login.example.com"
+	cfg.SMTPHost = ""
+	_, err = newUserRegistration(cfg, map[string]UserRegistrar{"htpasswd": htpasswd})
+	Error(t, err)
+}
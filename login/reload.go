@@ -0,0 +1,113 @@
+package login
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+
+	"github.com/afdecastro879/loginsrv/oauth2"
+)
+
+// ParseConfig reads the config like ReadConfig, but with a new flag set and without exiting on errors,
+// e.g. to read the changed config file for a reload.
+func ParseConfig(args []string) (*Config, error) {
+	f := flag.NewFlagSet("loginsrv", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	return readConfig(f, args)
+}
+
+// Reload returns a copy of the handler with the login backends, the oauth providers, the user file,
+// the claims mapping and the template of the config and reloads the tenants. All other options are kept.
+// The new handler shares the stores with this handler, so the sessions, the lockouts, the started oauth flows
+// and the pending registrations and password resets stay valid. The registration and the password change use
+// the reloaded backends. After the new handler replaced this one and the requests of this handler are finished,
+// CloseReplaced has to be called. The handler must not be closed, only the last handler has to be closed
+// after the requests are finished.
+func (h *Handler) Reload(config *Config) (reloaded *Handler, err error) {
+	if len(config.Backends) == 0 && len(config.Oauth) == 0 && len(h.config.SAML) == 0 && len(h.config.Kerberos) == 0 &&
+		len(h.config.X509) == 0 && len(h.config.TrustedHeader) == 0 && h.config.Tenants == "" {
+		return nil, errors.New("No login backends, oauth or saml provider configured")
+	}
+
+	newConfig := *h.config
+	newConfig.Backends = config.Backends
+	newConfig.Oauth = config.Oauth
+	newConfig.UserFile = config.UserFile
+	newConfig.ClaimsMappingFile = config.ClaimsMappingFile
+	newConfig.Template = config.Template
+
+	backends, magicLink, registrars, passwordWriters, err := newBackends(&newConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			closeBackends(backends) // ignore error, the backends were never used
+		}
+	}()
+
+	registration := h.registration
+	if registration != nil {
+		if registration, err = registration.reload(&newConfig, registrars); err != nil {
+			return nil, err
+		}
+	}
+	passwords := h.passwords
+	if passwords != nil {
+		if passwords, err = passwords.reload(&newConfig, passwordWriters); err != nil {
+			return nil, err
+		}
+	}
+
+	// the state store is kept, so that the callbacks of the started flows are accepted
+	var stateStore oauth2.StateStore
+	if manager, ok := h.oauth.(*oauth2.Manager); ok {
+		stateStore = manager.StateStore
+	} else if stateStore, err = oauth2.NewStateStore(newConfig.OauthStateStore); err != nil {
+		return nil, err
+	}
+	oauth, err := newOauthManager(&newConfig, stateStore)
+	if err != nil {
+		return nil, err
+	}
+
+	claimsFunc, err := newClaimsFunc(&newConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := reloadTenants(h.tenants, &newConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := *h
+	handler.tenants = tenants
+	handler.config = &newConfig
+	handler.backends = backends
+	handler.magicLink = magicLink
+	handler.registration = registration
+	handler.passwords = passwords
+	handler.oauth = oauth
+	handler.userClaims = claimsFunc
+	return &handler, nil
+}
+
+// CloseReplaced closes the login backends of this handler and the tenants, which were removed by the reload,
+// after the reloaded handler has replaced this handler and the requests of this handler are finished,
+// because the closed backends can't serve the requests any more. The stores are kept, because they are shared with the reloaded handler.
+func (h *Handler) CloseReplaced(reloaded *Handler) error {
+	firstErr := closeBackends(h.backends)
+	for host, tenant := range h.tenants {
+		var err error
+		if reloadedTenant, exist := reloaded.tenants[host]; exist {
+			err = tenant.CloseReplaced(reloadedTenant)
+		} else {
+			err = tenant.Close()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
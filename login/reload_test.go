@@ -0,0 +1,149 @@
+package login
+
+import (
+	"context"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/oauth2"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Reload(t *testing.T) {
+	config := DefaultConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.Oauth = Options{"github": {"client_id": "abc", "client_secret": "old"}}
+	config.JwtExpiry = 42
+	h, err := NewHandler(config)
+	NoError(t, err)
+
+	newConfig := DefaultConfig()
+	newConfig.Backends = Options{"simple": {"alice": "secret"}}
+	newConfig.Oauth = Options{"github": {"client_id": "abc", "client_secret": "new"}}
+	newConfig.Template = "login.html"
+	reloaded, err := h.Reload(newConfig)
+	NoError(t, err)
+
+	authenticated, _, err := reloaded.authenticate(context.Background(), "alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	authenticated, _, err = reloaded.authenticate(context.Background(), "bob", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	Equal(t, "new", reloaded.oauth.(*oauth2.Manager).GetConfigs()["github"].ClientSecret)
+	Same(t, h.oauth.(*oauth2.Manager).StateStore, reloaded.oauth.(*oauth2.Manager).StateStore)
+	Same(t, h.failureLimiter, reloaded.failureLimiter)
+	Equal(t, "login.html", reloaded.config.Template)
+	// the other options are not reloaded
+	EqualValues(t, 42, reloaded.config.JwtExpiry)
+
+	// the current handler is not changed
+	authenticated, _, err = h.authenticate(context.Background(), "bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "old", h.oauth.(*oauth2.Manager).GetConfigs()["github"].ClientSecret)
+}
+
+func TestHandler_ReloadErrors(t *testing.T) {
+	config := DefaultConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+
+	_, err = h.Reload(DefaultConfig())
+	Error(t, err)
+
+	newConfig := DefaultConfig()
+	newConfig.Backends = Options{"no-such-provider": {}}
+	_, err = h.Reload(newConfig)
+	Error(t, err)
+
+	newConfig = DefaultConfig()
+	newConfig.Oauth = Options{"github": {}}
+	_, err = h.Reload(newConfig)
+	Error(t, err)
+
+	newConfig = DefaultConfig()
+	newConfig.Backends = Options{"simple": {"bob": "secret"}}
+	newConfig.UserFile = "does-not-exist.yml"
+	_, err = h.Reload(newConfig)
+	Error(t, err)
+}
+
+// closingBackend is a registrar and password writer, which records its close
+type closingBackend struct {
+	testPasswordWriter
+	closed bool
+}
+
+func (b *closingBackend) UserExists(username string) (bool, error) {
+	_, exist := b.passwords[username]
+	return exist, nil
+}
+
+func (b *closingBackend) Register(username, password, email string) error {
+	b.passwords[username] = password
+	return nil
+}
+
+func (b *closingBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestHandler_Reload_Backends(t *testing.T) {
+	created := []*closingBackend{}
+	desc := &ProviderDescription{Name: "testclosing"}
+	RegisterProvider(desc, func(config map[string]string) (Backend, error) {
+		b := &closingBackend{testPasswordWriter: testPasswordWriter{passwords: map[string]string{}, emails: map[string]string{}}}
+		created = append(created, b)
+		return b, nil
+	})
+	defer func() {
+		delete(provider, desc.Name)
+		delete(providerDescription, desc.Name)
+	}()
+
+	config := DefaultConfig()
+	config.Backends = Options{"testclosing": {}}
+	config.Registration = true
+	config.PasswordChange = true
+	config.PasswordReset = true
+	config.SMTPHost = "mail.example.com"
+	config.SMTPFrom = "login@example.com"
+	config.PublicURL = "https://login.example.com"
+	h, err := NewHandler(config)
+	NoError(t, err)
+	token, err := h.passwords.start("bob")
+	NoError(t, err)
+
+	reloaded, err := h.Reload(config)
+	NoError(t, err)
+	Equal(t, 2, len(created))
+
+	// the registration and the password change use the reloaded backend
+	True(t, reloaded.registration.registrar == created[1])
+	True(t, reloaded.passwords.writer == created[1])
+	True(t, h.registration.registrar == created[0])
+	// the sent reset links stay valid
+	username, valid := reloaded.passwords.lookup(token)
+	True(t, valid)
+	Equal(t, "bob", username)
+
+	// the replaced backend is closed after the swap
+	False(t, created[0].closed)
+	NoError(t, h.CloseReplaced(reloaded))
+	True(t, created[0].closed)
+	False(t, created[1].closed)
+
+	// the backends of a failed reload are closed
+	invalid := *config
+	invalid.ClaimsMappingFile = "/does/not/exist"
+	_, err = reloaded.Reload(&invalid)
+	Error(t, err)
+	Equal(t, 3, len(created))
+	True(t, created[2].closed)
+
+	NoError(t, reloaded.Close())
+	True(t, created[1].closed)
+}
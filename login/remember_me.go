@@ -0,0 +1,35 @@
+package login
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// rememberMeParameter is the checkbox of the login form for a long-lived login
+const rememberMeParameter = "remember_me"
+
+// rememberMe returns true, if the user checked remember me and it is enabled by -remember-me-expiry.
+func (h *Handler) rememberMe(r *http.Request) bool {
+	return h.config.RememberMeExpiry > 0 && r.FormValue(rememberMeParameter) == "true"
+}
+
+// tokenExpiry returns the lifetime of the jwt, which is longer for a login with remember me.
+// The flag is kept on refresh, so the refreshed tokens are long-lived, too.
+func (h *Handler) tokenExpiry(userInfo model.UserInfo) time.Duration {
+	if userInfo.RememberMe && h.config.RememberMeExpiry > 0 {
+		return h.config.RememberMeExpiry
+	}
+	return h.config.JwtExpiry
+}
+
+// rememberMeCookie makes the jwt cookie persistent for the remember me expiry,
+// also if the cookie is a session cookie by default.
+func (h *Handler) rememberMeCookie(cookie *http.Cookie) {
+	if h.config.RememberMeExpiry == 0 {
+		return
+	}
+	cookie.Expires = time.Now().Add(h.config.RememberMeExpiry)
+	cookie.MaxAge = int(h.config.RememberMeExpiry.Seconds())
+}
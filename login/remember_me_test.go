@@ -0,0 +1,81 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func testRememberMeHandler(t *testing.T) *Handler {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.RememberMeExpiry = 30 * 24 * time.Hour
+	h, err := NewHandler(config)
+	NoError(t, err)
+	return h
+}
+
+func TestHandler_RememberMe_Form(t *testing.T) {
+	h := testRememberMeHandler(t)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), `<input name="remember_me" type="checkbox" value="true"> Remember me`)
+
+	h.config.RememberMeExpiry = 0
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	NotContains(t, recorder.Body.String(), "remember_me")
+}
+
+func TestHandler_RememberMe_Login(t *testing.T) {
+	h := testRememberMeHandler(t)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret&remember_me=true", TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+
+	cookie := cookieByName(recorder, "jwt_token")
+	Equal(t, 30*24*60*60, cookie.MaxAge)
+	InDelta(t, time.Now().Add(30*24*time.Hour).Unix(), cookie.Expires.Unix(), 2)
+	claims, err := tokenAsMap(cookie.Value)
+	NoError(t, err)
+	Equal(t, true, claims["remember_me"])
+	InDelta(t, time.Now().Add(30*24*time.Hour).Unix(), claims["exp"], 2)
+
+	// the refresh keeps the long expiry
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, "Cookie: jwt_token="+cookie.Value))
+	Equal(t, 303, recorder.Code)
+	claims, err = tokenAsMap(cookieByName(recorder, "jwt_token").Value)
+	NoError(t, err)
+	Equal(t, true, claims["remember_me"])
+	InDelta(t, time.Now().Add(30*24*time.Hour).Unix(), claims["exp"], 2)
+}
+
+func TestHandler_RememberMe_NotChecked(t *testing.T) {
+	h := testRememberMeHandler(t)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+
+	cookie := cookieByName(recorder, "jwt_token")
+	Equal(t, 0, cookie.MaxAge)
+	claims, err := tokenAsMap(cookie.Value)
+	NoError(t, err)
+	Nil(t, claims["remember_me"])
+	InDelta(t, time.Now().Add(h.config.JwtExpiry).Unix(), claims["exp"], 2)
+}
+
+func TestHandler_RememberMe_Disabled(t *testing.T) {
+	h := testRememberMeHandler(t)
+	h.config.RememberMeExpiry = 0
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login?remember_me=true", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Nil(t, claims["remember_me"])
+	InDelta(t, time.Now().Add(h.config.JwtExpiry).Unix(), claims["exp"], 2)
+}
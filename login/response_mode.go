@@ -0,0 +1,104 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// responseModeParameter selects the delivery of the token per request, overriding the Accept header
+const responseModeParameter = "response_mode"
+
+// The response modes deliver the token of a successful login
+const (
+	// responseModeCookie sets the token as cookie and redirects browsers to the success url
+	responseModeCookie = "cookie"
+	// responseModeHeader returns the token in the Authorization header of the response
+	responseModeHeader = "header"
+	// responseModeBody returns the plain token as application/jwt
+	responseModeBody = "body"
+	// responseModeJSON returns the token in a json object
+	responseModeJSON = "json"
+)
+
+var responseModes = []string{responseModeCookie, responseModeHeader, responseModeBody, responseModeJSON}
+
+func validResponseMode(mode string) bool {
+	for _, m := range responseModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func validateResponseMode(config *Config) error {
+	if config.ResponseMode != "" && !validResponseMode(config.ResponseMode) {
+		return fmt.Errorf("invalid response-mode %q, supported are %v", config.ResponseMode, strings.Join(responseModes, ", "))
+	}
+	return nil
+}
+
+// responseMode returns the delivery of the token: the response_mode parameter of the request,
+// the mode of the Accept header or the configured response mode.
+func (h *Handler) responseMode(r *http.Request) string {
+	if mode := r.FormValue(responseModeParameter); validResponseMode(mode) {
+		return mode
+	}
+	switch {
+	case wantHTML(r):
+		return responseModeCookie
+	case wantJSON(r):
+		return responseModeJSON
+	case strings.Contains(r.Header.Get("Accept"), contentTypeJWT):
+		return responseModeBody
+	case h.config.ResponseMode != "":
+		return h.config.ResponseMode
+	}
+	return responseModeBody
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresAt    int64  `json:"expires_at"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// respondToken delivers the token of a successful login in the response mode.
+// The refresh token is only part of the json response, the other modes set it by issueRefreshToken.
+func (h *Handler) respondToken(w http.ResponseWriter, r *http.Request, mode, token string, userInfo model.UserInfo, refreshToken string) {
+	switch mode {
+	case responseModeCookie:
+		cookie := h.tokenCookie(token)
+		if userInfo.RememberMe {
+			h.rememberMeCookie(cookie)
+		}
+		if wantHTML(r) {
+			h.respondAuthenticatedHTML(w, r, cookie)
+			return
+		}
+		http.SetCookie(w, cookie)
+		w.WriteHeader(204)
+	case responseModeHeader:
+		w.Header().Set("Authorization", "Bearer "+token)
+		w.WriteHeader(204)
+	case responseModeJSON:
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(tokenResponse{
+			Token:        token,
+			TokenType:    "Bearer",
+			ExpiresAt:    userInfo.Expiry,
+			RefreshToken: refreshToken,
+		}) // ignore error of encoding
+	default:
+		w.Header().Set("Content-Type", contentTypeJWT)
+		w.WriteHeader(200)
+		fmt.Fprint(w, token)
+	}
+}
@@ -0,0 +1,96 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_ResponseMode(t *testing.T) {
+	login := func(h *Handler, url string, header ...string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", url, `{"username": "bob", "password": "secret"}`, append(header, TypeJSON)...))
+		return recorder
+	}
+	h := testHandler()
+
+	// the accept header chooses the mode
+	recorder := login(h, "/context/login", AcceptJwt)
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+	_, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+
+	recorder = login(h, "/context/login", "Accept: application/json")
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	response := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, "Bearer", response.TokenType)
+	InDelta(t, time.Now().Add(DefaultConfig().JwtExpiry).Unix(), response.ExpiresAt, 2)
+	claims, err := tokenAsMap(response.Token)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+
+	recorder = login(h, "/context/login", AcceptHTML)
+	Equal(t, 303, recorder.Code)
+	True(t, strings.HasPrefix(recorder.Header().Get("Set-Cookie"), "jwt_token="))
+
+	// the parameter overrides the accept header
+	recorder = login(h, "/context/login?response_mode=header", "Accept: application/json")
+	Equal(t, 204, recorder.Code)
+	True(t, strings.HasPrefix(recorder.Header().Get("Authorization"), "Bearer "))
+	_, err = tokenAsMap(strings.TrimPrefix(recorder.Header().Get("Authorization"), "Bearer "))
+	NoError(t, err)
+	Equal(t, "", recorder.Header().Get("Set-Cookie"))
+
+	recorder = login(h, "/context/login?response_mode=cookie", "Accept: application/json")
+	Equal(t, 204, recorder.Code)
+	True(t, strings.HasPrefix(recorder.Header().Get("Set-Cookie"), "jwt_token="))
+	Equal(t, "", recorder.Header().Get("Location"))
+
+	recorder = login(h, "/context/login?response_mode=unknown", "Accept: application/json")
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+
+	// the configured mode is used, if the client does not choose one
+	recorder = login(h, "/context/login")
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+	h.config.ResponseMode = responseModeJSON
+	recorder = login(h, "/context/login", "Accept: */*")
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+}
+
+func TestHandler_ResponseMode_RefreshToken(t *testing.T) {
+	h := testHandler()
+	h.config.RefreshTokenExpiry = time.Hour
+	h.refreshTokens = newMemoryRefreshTokenStore()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 200, recorder.Code)
+	response := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	NotEqual(t, "", response.RefreshToken)
+	Equal(t, "", recorder.Header().Get(refreshTokenHeader))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login?response_mode=header", `{"username": "bob", "password": "secret"}`, TypeJSON))
+	Equal(t, 204, recorder.Code)
+	NotEqual(t, "", recorder.Header().Get(refreshTokenHeader))
+}
+
+func TestValidateResponseMode(t *testing.T) {
+	config := testConfig()
+	NoError(t, validateResponseMode(config))
+	for _, mode := range responseModes {
+		config.ResponseMode = mode
+		NoError(t, validateResponseMode(config))
+	}
+	config.ResponseMode = "query"
+	Error(t, validateResponseMode(config))
+}
@@ -0,0 +1,186 @@
+package login
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const revokedPath = "/revoked/"
+
+// RevocationStoreMemory is the value of the revocation-store option for the in memory store
+const RevocationStoreMemory = "memory"
+
+const redisRevocationPrefix = "loginsrv:revoked:"
+
+// RevocationStore holds the ids (jti) of the logged out tokens until their expiry.
+type RevocationStore interface {
+	// Revoke marks the token id as revoked until the expiry of the token.
+	Revoke(jti string, expiry time.Time) error
+
+	// IsRevoked returns true, if the token id was revoked.
+	IsRevoked(jti string) (bool, error)
+}
+
+// newRevocationStore creates the store for the revocation-store option:
+// memory or a redis url like redis://localhost:6379/0. It returns nil, if the option is empty.
+func newRevocationStore(store string) (RevocationStore, error) {
+	switch {
+	case store == "":
+		return nil, nil
+	case store == RevocationStoreMemory:
+		return newMemoryRevocationStore(), nil
+	case strings.HasPrefix(store, "redis://"):
+		return newRedisRevocationStore(store)
+	default:
+		return nil, fmt.Errorf("unsupported revocation store %q, expected %q or a redis:// url", store, RevocationStoreMemory)
+	}
+}
+
+// memoryRevocationStore is a RevocationStore backed by a map.
+// The revocations are lost on restart and are not shared between multiple instances.
+type memoryRevocationStore struct {
+	mutex   sync.Mutex
+	revoked map[string]time.Time
+}
+
+func newMemoryRevocationStore() *memoryRevocationStore {
+	return &memoryRevocationStore{revoked: map[string]time.Time{}}
+}
+
+func (s *memoryRevocationStore) Revoke(jti string, expiry time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// remove the entries of expired tokens, which are rejected anyway
+	now := time.Now()
+	for id, e := range s.revoked {
+		if now.After(e) {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[jti] = expiry
+	return nil
+}
+
+func (s *memoryRevocationStore) IsRevoked(jti string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	expiry, exist := s.revoked[jti]
+	return exist && time.Now().Before(expiry), nil
+}
+
+// redisRevocationStore is a RevocationStore backed by redis.
+// The entries expire together with the tokens, so the store is shared between multiple instances.
+type redisRevocationStore struct {
+	client *redis.Client
+}
+
+func newRedisRevocationStore(redisURL string) (*redisRevocationStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid redis url for the revocation store")
+	}
+	return &redisRevocationStore{client: redis.NewClient(opts)}, nil
+}
+
+func (s *redisRevocationStore) Revoke(jti string, expiry time.Time) error {
+	ttl := time.Until(expiry)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(redisRevocationPrefix+jti, "1", ttl).Err()
+}
+
+func (s *redisRevocationStore) IsRevoked(jti string) (bool, error) {
+	n, err := s.client.Exists(redisRevocationPrefix + jti).Result()
+	return n > 0, err
+}
+
+// revokeToken revokes the tokens of the logout request, from the cookie and from the Authorization header.
+func (h *Handler) revokeToken(r *http.Request) {
+	if h.revocations == nil {
+		return
+	}
+
+	var tokens []string
+	if c, err := r.Cookie(h.config.CookieName); err == nil {
+		tokens = append(tokens, c.Value)
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		tokens = append(tokens, strings.TrimPrefix(auth, "Bearer "))
+	}
+
+	for _, token := range tokens {
+		userInfo, valid := h.parseToken(r, token)
+		if !valid || userInfo.ID == "" {
+			continue
+		}
+		if err := h.revocations.Revoke(userInfo.ID, time.Unix(userInfo.Expiry, 0)); err != nil {
+			logging.Application(r.Header).WithError(err).Error("can not revoke token")
+			continue
+		}
+		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("revoked token")
+	}
+}
+
+// isRevoked returns true, if the token was revoked by a logout.
+// Errors of the store are logged and the token is treated as revoked.
+func (h *Handler) isRevoked(r *http.Request, userInfo model.UserInfo) bool {
+	if h.revocations == nil || userInfo.ID == "" {
+		return false
+	}
+	revoked, err := h.revocations.IsRevoked(userInfo.ID)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error("can not check the revocation of the token")
+		return true
+	}
+	return revoked
+}
+
+type revokedResponse struct {
+	Revoked bool `json:"revoked"`
+}
+
+// handleRevoked tells downstream verifiers, whether the token id of the path was revoked.
+func (h *Handler) handleRevoked(w http.ResponseWriter, r *http.Request) {
+	jti := strings.TrimPrefix(r.URL.Path, h.config.LoginPath+revokedPath)
+	if h.revocations == nil || jti == "" {
+		h.respondNotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	revoked, err := h.revocations.IsRevoked(jti)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(revokedResponse{Revoked: revoked}) // ignore error of encoding
+}
+
+// newTokenID returns a random id for the jti claim
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,145 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_RevokeOnLogout(t *testing.T) {
+	h := testHandler()
+	h.revocations = newMemoryRevocationStore()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	jti, _ := claims["jti"].(string)
+	NotEmpty(t, jti)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/revoked/"+jti, ""))
+	Equal(t, 200, recorder.Code)
+	JSONEq(t, `{"revoked": false}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", "", "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/revoked/"+jti, ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	JSONEq(t, `{"revoked": true}`, recorder.Body.String())
+
+	// the revoked token is not accepted any more
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Cookie: jwt_token="+token, "Accept: application/json"))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token": "`+token+`"}`, TypeJSON))
+	response := tokenValidateResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, tokenValidateResponse{Reason: reasonRevoked}, response)
+}
+
+func TestHandler_RevokeCookieOnLogout(t *testing.T) {
+	h := testHandler()
+	h.revocations = newMemoryRevocationStore()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	token := recorder.Body.String()
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("GET", "/context/login?logout=true", "", "Cookie: jwt_token="+token)))
+	Equal(t, 200, recorder.Code)
+
+	_, valid := h.parseToken(req("GET", "/context/login", ""), token)
+	False(t, valid)
+}
+
+func TestHandler_RevokedDisabled(t *testing.T) {
+	recorder := call(req("GET", "/context/login/revoked/foo", ""))
+	Equal(t, 404, recorder.Code)
+
+	// without revocation store, the tokens have no jti
+	recorder = call(req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	NotContains(t, claims, "jti")
+}
+
+func TestMemoryRevocationStore(t *testing.T) {
+	s := newMemoryRevocationStore()
+
+	NoError(t, s.Revoke("a", time.Now().Add(time.Hour)))
+	NoError(t, s.Revoke("b", time.Now().Add(-time.Second)))
+
+	revoked, err := s.IsRevoked("a")
+	NoError(t, err)
+	True(t, revoked)
+
+	revoked, _ = s.IsRevoked("b")
+	False(t, revoked)
+	revoked, _ = s.IsRevoked("c")
+	False(t, revoked)
+
+	// expired entries are removed
+	NoError(t, s.Revoke("c", time.Now().Add(time.Hour)))
+	Equal(t, 2, len(s.revoked))
+}
+
+func TestRedisRevocationStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	NoError(t, err)
+	defer mr.Close()
+
+	store, err := newRevocationStore("redis://" + mr.Addr() + "/0")
+	NoError(t, err)
+	s := store.(*redisRevocationStore)
+
+	NoError(t, s.Revoke("a", time.Now().Add(time.Hour)))
+	NoError(t, s.Revoke("b", time.Now().Add(-time.Second)))
+	InDelta(t, time.Hour.Seconds(), mr.TTL("loginsrv:revoked:a").Seconds(), 2)
+	False(t, mr.Exists("loginsrv:revoked:b"))
+
+	revoked, err := s.IsRevoked("a")
+	NoError(t, err)
+	True(t, revoked)
+
+	revoked, err = s.IsRevoked("b")
+	NoError(t, err)
+	False(t, revoked)
+
+	mr.FastForward(time.Hour)
+	revoked, _ = s.IsRevoked("a")
+	False(t, revoked)
+
+	mr.Close()
+	_, err = s.IsRevoked("a")
+	Error(t, err)
+}
+
+func TestNewRevocationStore(t *testing.T) {
+	s, err := newRevocationStore("")
+	NoError(t, err)
+	Nil(t, s)
+
+	s, err = newRevocationStore("memory")
+	NoError(t, err)
+	IsType(t, &memoryRevocationStore{}, s)
+
+	_, err = newRevocationStore("redis://localhost:6379/foo")
+	Error(t, err)
+
+	_, err = newRevocationStore("file")
+	Error(t, err)
+}
@@ -0,0 +1,98 @@
+package login
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/metrics"
+	"github.com/afdecastro879/loginsrv/saml"
+)
+
+const samlPath = "/saml"
+
+func newSAML(config *Config) (*saml.ServiceProvider, error) {
+	if len(config.SAML) == 0 {
+		return nil, nil
+	}
+	cfg, err := saml.NewConfigFromOpts(config.SAML)
+	if err != nil {
+		return nil, err
+	}
+	return saml.NewServiceProvider(cfg), nil
+}
+
+// handleSAML serves the SP-initiated login with the identity provider:
+// saml redirects to the identity provider, saml/acs consumes the response and saml/metadata describes loginsrv as service provider.
+func (h *Handler) handleSAML(w http.ResponseWriter, r *http.Request) {
+	if h.saml == nil {
+		h.respondNotFound(w, r)
+		return
+	}
+
+	acsURL := h.samlACSURL(r)
+	switch strings.TrimPrefix(r.URL.Path, h.config.LoginPath+samlPath) {
+	case "", "/":
+		if r.Method != "GET" {
+			h.respondBadRequest(w, r)
+			return
+		}
+		authnURL, err := h.saml.AuthnRequestURL(acsURL)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		w.Header().Set("Location", authnURL)
+		w.WriteHeader(303)
+	case "/acs":
+		if r.Method != "POST" {
+			h.respondBadRequest(w, r)
+			return
+		}
+		if !h.beforeAuth(w, r, "", saml.ProviderName) {
+			return
+		}
+		r.ParseForm()
+		userInfo, err := h.saml.ParseResponse(r.PostForm.Get("SAMLResponse"), acsURL)
+		if err != nil {
+			if _, isVerificationError := err.(*saml.VerificationError); !isVerificationError {
+				metrics.Login(saml.ProviderName, false, err)
+				logging.Application(r.Header).WithError(err).Error()
+				h.auditEvent(r, audit.ProviderError, "", saml.ProviderName, err.Error())
+				h.respondError(w, r)
+				return
+			}
+			metrics.Login(saml.ProviderName, false, nil)
+			logging.Application(r.Header).WithError(err).Info("failed authentication")
+			h.auditEvent(r, audit.LoginFailure, "", saml.ProviderName, err.Error())
+			h.respondAuthFailure(w, r)
+			return
+		}
+		metrics.Login(saml.ProviderName, true, nil)
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).Info("successfully authenticated")
+		h.completeLogin(w, r, userInfo, saml.ProviderName)
+	case "/metadata":
+		if r.Method != "GET" {
+			h.respondBadRequest(w, r)
+			return
+		}
+		metadata, err := h.saml.Metadata(acsURL)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		w.Write(metadata)
+	default:
+		h.respondNotFound(w, r)
+	}
+}
+
+// samlACSURL returns the url of the assertion consumer service, as seen by the browser.
+func (h *Handler) samlACSURL(r *http.Request) string {
+	return externalURL(r, h.config.LoginPath+samlPath+"/acs")
+}
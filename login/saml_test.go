@@ -0,0 +1,75 @@
+package login
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	dsig "github.com/russellhaering/goxmldsig"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_SAML(t *testing.T) {
+	_, certDER, err := dsig.RandomKeyStoreForTest().GetKeyPair()
+	NoError(t, err)
+	certFile, err := ioutil.TempFile("", "loginsrv-saml")
+	NoError(t, err)
+	defer os.Remove(certFile.Name())
+	pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	certFile.Close()
+
+	cfg := testConfig()
+	cfg.SAML = map[string]string{
+		"entity_id":       "https://login.example.com",
+		"idp_sso_url":     "https://idp.example.com/sso",
+		"idp_certificate": certFile.Name(),
+		"label":           "Example IdP",
+	}
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `href="/context/login/saml"`)
+	Contains(t, recorder.Body.String(), "Sign in with Example IdP")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/saml", "", AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	True(t, strings.HasPrefix(recorder.Header().Get("Location"), "https://idp.example.com/sso?SAMLRequest="))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/saml/metadata", "", "X-Forwarded-Host: login.example.com", "X-Forwarded-Proto: https"))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "application/samlmetadata+xml", recorder.Header().Get("Content-Type"))
+	Contains(t, recorder.Body.String(), `entityID="https://login.example.com"`)
+	Contains(t, recorder.Body.String(), `Location="https://login.example.com/context/login/saml/acs"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/saml/acs", "SAMLResponse=Zm9v", TypeForm, AcceptHTML))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/saml/acs", "", AcceptHTML))
+	Equal(t, 400, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/saml/unknown", "", AcceptHTML))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestHandler_SAMLDisabled(t *testing.T) {
+	recorder := call(req("GET", "/context/login/saml", "", AcceptHTML))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestHandler_SAMLInvalidConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.SAML = map[string]string{"entity_id": "https://login.example.com"}
+	_, err := NewHandler(cfg)
+	Error(t, err)
+}
@@ -0,0 +1,216 @@
+package login
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// envFileSuffix marks the environment variables, which contain the name of a file with the value,
+// e.g. LOGINSRV_JWT_SECRET_FILE=/run/secrets/jwt_secret
+const envFileSuffix = "_FILE"
+
+// vaultPrefix marks secrets, which are read from HashiCorp Vault,
+// e.g. vault:secret/data/loginsrv#jwt_secret
+const vaultPrefix = "vault:"
+
+// secretKeys are the options of the oauth and backend providers, which contain secrets.
+// They can be read from a file with the key and the suffix _file, e.g. client_secret_file=/run/secrets/github.
+var secretKeys = []string{"client_secret", "bind_password", "password", "dsn"}
+
+// readEnvFile returns the content of the file of LOGINSRV_<OPTION>_FILE
+func readEnvFile(f *flag.FlagSet, flagName string) (string, bool, error) {
+	if f.Lookup(flagName+"-file") != nil {
+		// LOGINSRV_<OPTION>_FILE is the variable of the option <option>-file
+		return "", false, nil
+	}
+	name := envName(flagName) + envFileSuffix
+	filename, isPresent := lookupEnv(name)
+	if !isPresent {
+		return "", false, nil
+	}
+	if _, isPresent := lookupEnv(envName(flagName)); isPresent {
+		return "", false, fmt.Errorf("only one of %v and %v can be set", envName(flagName), name)
+	}
+	value, err := readSecretFile(filename)
+	if err != nil {
+		return "", false, fmt.Errorf("can't read %v: %v", name, err)
+	}
+	return value, true, nil
+}
+
+// readSecretFile returns the content of the file without the trailing line break
+func readSecretFile(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// secrets returns the secret options of the config
+func (c *Config) secrets() map[string]*string {
+	return map[string]*string{
+		"jwt-secret":           &c.JwtSecret,
+		"jwt-secondary-secret": &c.JwtSecondarySecret,
+		"jwe-secret":           &c.JweSecret,
+		"captcha-secret":       &c.CaptchaSecret,
+		"smtp-password":        &c.SMTPPassword,
+		"admin-token":          &c.AdminToken,
+		"user-endpoint-token":  &c.UserEndpointToken,
+	}
+}
+
+// ResolveSecrets reads the <key>_file options of the providers and replaces the vault: references
+// of the secret options by the values of HashiCorp Vault.
+func (c *Config) ResolveSecrets() error {
+	vault := &vaultClient{}
+	for name, value := range c.secrets() {
+		if err := vault.resolve(value); err != nil {
+			return fmt.Errorf("can't read %v: %v", name, err)
+		}
+	}
+	for _, providers := range []Options{c.Oauth, c.Backends} {
+		for pName, opts := range providers {
+			if err := resolveSecretOpts(vault, opts); err != nil {
+				return fmt.Errorf("can't read the secrets of %v: %v", pName, err)
+			}
+		}
+	}
+	return nil
+}
+
+func resolveSecretOpts(vault *vaultClient, opts map[string]string) error {
+	for _, key := range secretKeys {
+		if filename, exist := opts[key+"_file"]; exist {
+			if _, exist := opts[key]; exist {
+				return fmt.Errorf("only one of %v and %v_file can be set", key, key)
+			}
+			value, err := readSecretFile(filename)
+			if err != nil {
+				return err
+			}
+			opts[key] = value
+			delete(opts, key+"_file")
+		}
+		if value, exist := opts[key]; exist {
+			if err := vault.resolve(&value); err != nil {
+				return fmt.Errorf("%v: %v", key, err)
+			}
+			opts[key] = value
+		}
+	}
+	return nil
+}
+
+// vaultClient reads secrets of the KV secrets engine of HashiCorp Vault.
+// The address and the token are taken from VAULT_ADDR and VAULT_TOKEN or VAULT_TOKEN_FILE,
+// like the vault cli does. The client is only configured if there are vault: references.
+type vaultClient struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+	cache     map[string]map[string]interface{}
+}
+
+// resolve replaces a reference vault:<path>#<field> by the value of the secret
+func (v *vaultClient) resolve(value *string) error {
+	if !strings.HasPrefix(*value, vaultPrefix) {
+		return nil
+	}
+	ref := strings.TrimPrefix(*value, vaultPrefix)
+	i := strings.LastIndex(ref, "#")
+	if i < 1 || i == len(ref)-1 {
+		return fmt.Errorf("invalid vault reference %q, expected vault:<path>#<field>", *value)
+	}
+	path, field := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	data, err := v.read(path)
+	if err != nil {
+		return err
+	}
+	secret, exist := data[field]
+	if !exist {
+		return fmt.Errorf("field %q not found in vault secret %v", field, path)
+	}
+	s, ok := secret.(string)
+	if !ok {
+		return fmt.Errorf("field %q of vault secret %v is not a string", field, path)
+	}
+	*value = s
+	return nil
+}
+
+func (v *vaultClient) read(path string) (map[string]interface{}, error) {
+	if err := v.init(); err != nil {
+		return nil, err
+	}
+	if data, exist := v.cache[path]; exist {
+		return data, nil
+	}
+
+	req, err := http.NewRequest("GET", v.addr+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("vault returned status %v for %v", resp.StatusCode, path)
+	}
+
+	secret := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("can't decode vault secret %v: %v", path, err)
+	}
+	data := secret.Data
+	// version 2 of the KV engine wraps the data with its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+	v.cache[path] = data
+	return data, nil
+}
+
+func (v *vaultClient) init() error {
+	if v.client != nil {
+		return nil
+	}
+	v.addr, _ = lookupEnv("VAULT_ADDR")
+	if v.addr == "" {
+		return errors.New("VAULT_ADDR is not set")
+	}
+	v.addr = strings.TrimRight(v.addr, "/")
+	v.token, _ = lookupEnv("VAULT_TOKEN")
+	if filename, isPresent := lookupEnv("VAULT_TOKEN_FILE"); isPresent && v.token == "" {
+		token, err := readSecretFile(filename)
+		if err != nil {
+			return fmt.Errorf("can't read VAULT_TOKEN_FILE: %v", err)
+		}
+		v.token = token
+	}
+	if v.token == "" {
+		return errors.New("VAULT_TOKEN or VAULT_TOKEN_FILE is not set")
+	}
+	v.namespace, _ = lookupEnv("VAULT_NAMESPACE")
+	v.client = &http.Client{Timeout: 10 * time.Second}
+	v.cache = map[string]map[string]interface{}{}
+	return nil
+}
@@ -0,0 +1,127 @@
+package login
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSecrets_EnvFile(t *testing.T) {
+	secretFile := writeConfigFile(t, "jwt_secret", "file-secret\n")
+	githubFile := writeConfigFile(t, "github", "client_id=abc,client_secret=xyz")
+	setTestEnv(t, map[string]string{
+		"LOGINSRV_JWT_SECRET_FILE": secretFile,
+		"LOGINSRV_GITHUB_FILE":     githubFile,
+		"LOGINSRV_USER_FILE":       "users.yml",
+	})
+
+	config, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
+	NoError(t, err)
+	Equal(t, "file-secret", config.JwtSecret)
+	Equal(t, map[string]string{"client_id": "abc", "client_secret": "xyz"}, config.Oauth["github"])
+	Equal(t, "users.yml", config.UserFile)
+}
+
+func TestSecrets_EnvFileErrors(t *testing.T) {
+	setTestEnv(t, map[string]string{
+		"LOGINSRV_JWT_SECRET_FILE": "does-not-exist",
+	})
+	_, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
+	Error(t, err)
+	Contains(t, err.Error(), "LOGINSRV_JWT_SECRET_FILE")
+
+	setTestEnv(t, map[string]string{
+		"LOGINSRV_JWT_SECRET_FILE": writeConfigFile(t, "jwt_secret", "file-secret"),
+		"LOGINSRV_JWT_SECRET":      "env-secret",
+	})
+	_, err = readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
+	Error(t, err)
+	Contains(t, err.Error(), "only one of")
+}
+
+func TestSecrets_OptionFile(t *testing.T) {
+	secretFile := writeConfigFile(t, "client_secret", "xyz\r\n")
+	setTestEnv(t, map[string]string{})
+
+	config, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{
+		"--github=client_id=abc,client_secret_file=" + secretFile,
+	})
+	NoError(t, err)
+	Equal(t, map[string]string{"client_id": "abc", "client_secret": "xyz"}, config.Oauth["github"])
+
+	_, err = readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{
+		"--github=client_id=abc,client_secret=xyz,client_secret_file=" + secretFile,
+	})
+	Error(t, err)
+
+	_, err = readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{
+		"--github=client_id=abc,client_secret_file=does-not-exist",
+	})
+	Error(t, err)
+}
+
+func TestSecrets_Vault(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(403)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/loginsrv":
+			fmt.Fprint(w, `{"data": {"data": {"jwt_secret": "vault-secret", "github": "xyz"}, "metadata": {"version": 3}}}`)
+		case "/v1/kv/loginsrv":
+			fmt.Fprint(w, `{"data": {"smtp_password": "smtp-secret", "port": 25}}`)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	tokenFile := writeConfigFile(t, "token", "vault-token\n")
+	setTestEnv(t, map[string]string{
+		"VAULT_ADDR":       server.URL + "/",
+		"VAULT_TOKEN_FILE": tokenFile,
+	})
+
+	config, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{
+		"--jwt-secret=vault:secret/data/loginsrv#jwt_secret",
+		"--smtp-password=vault:/kv/loginsrv#smtp_password",
+		"--github=client_id=abc,client_secret=vault:secret/data/loginsrv#github",
+	})
+	NoError(t, err)
+	Equal(t, "vault-secret", config.JwtSecret)
+	Equal(t, "smtp-secret", config.SMTPPassword)
+	Equal(t, "xyz", config.Oauth["github"]["client_secret"])
+	Equal(t, 2, requests, "every secret should only be read once")
+
+	for _, ref := range []string{
+		"vault:secret/data/loginsrv",
+		"vault:secret/data/loginsrv#unknown",
+		"vault:kv/loginsrv#port",
+		"vault:kv/unknown#field",
+	} {
+		_, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--jwt-secret=" + ref})
+		Error(t, err, ref)
+	}
+
+	setTestEnv(t, map[string]string{})
+	_, err = readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--jwt-secret=vault:secret/data/loginsrv#jwt_secret"})
+	Error(t, err)
+	Contains(t, err.Error(), "VAULT_ADDR")
+}
+
+// setTestEnv replaces the environment of the config by env for the rest of the test
+func setTestEnv(t *testing.T, env map[string]string) {
+	lookupEnv = func(key string) (string, bool) {
+		v, exist := env[key]
+		return v, exist
+	}
+	t.Cleanup(func() { lookupEnv = os.LookupEnv })
+}
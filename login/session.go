@@ -0,0 +1,525 @@
+package login
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// SessionStoreMemory is the value of the session-store option for the in memory store
+const SessionStoreMemory = "memory"
+
+const (
+	redisSessionPrefix = "loginsrv:session:"
+	// redisUserSessionsPrefix is the set of the session ids of a user
+	redisUserSessionsPrefix = "loginsrv:user-sessions:"
+)
+
+// redisSessionLimitRetries is the number of attempts of a limited save, which conflicts with concurrent logins of the user
+const redisSessionLimitRetries = 10
+
+// Session is a session of a logged in user.
+type Session struct {
+	ID       string
+	UserInfo model.UserInfo
+	Expiry   time.Time
+	// Created orders the sessions of a user for the session limit
+	Created time.Time
+	// UserAgent and ClientIP describe the device of the session in the session list of the user
+	UserAgent string
+	ClientIP  string
+	// RefreshToken is the refresh token, which was issued with the session. It is revoked together with the session.
+	RefreshToken string
+}
+
+// SessionStore holds the user info of the logged in users in the stateful session mode.
+// The cookie then only contains the opaque session id.
+type SessionStore interface {
+	// Save stores the session until its expiry.
+	Save(session Session) error
+
+	// SaveLimited stores the session, if the user has less than limit sessions. Otherwise, it deletes the oldest
+	// sessions of the user and returns them with evict, or returns errSessionLimitReached.
+	// The check and the save are atomic, so that concurrent logins can't exceed the limit.
+	SaveLimited(session Session, limit int, evict bool) ([]Session, error)
+
+	// Get returns the user info of the session. It returns false, if the session does not exist or has expired.
+	Get(id string) (model.UserInfo, bool, error)
+
+	// List returns the sessions of the user, which have not expired.
+	List(sub string) ([]Session, error)
+
+	// Delete removes the session.
+	Delete(id string) error
+
+	// DeleteUser removes all sessions of the user and returns their number.
+	DeleteUser(sub string) (int, error)
+}
+
+// newSessionStore creates the store for the session-store option:
+// memory or a redis url like redis://localhost:6379/0. It returns nil, if the option is empty.
+func newSessionStore(store string) (SessionStore, error) {
+	switch {
+	case store == "":
+		return nil, nil
+	case store == SessionStoreMemory:
+		return newMemorySessionStore(), nil
+	case strings.HasPrefix(store, "redis://"):
+		return newRedisSessionStore(store)
+	default:
+		return nil, fmt.Errorf("unsupported session store %q, expected %q or a redis:// url", store, SessionStoreMemory)
+	}
+}
+
+// memorySessionStore is a SessionStore backed by a map.
+// The sessions are lost on restart and are not shared between multiple instances.
+type memorySessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: map[string]Session{}}
+}
+
+func (s *memorySessionStore) Save(session Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.removeExpired()
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *memorySessionStore) SaveLimited(session Session, limit int, evict bool) ([]Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.removeExpired()
+
+	sessions := []Session{}
+	for _, stored := range s.sessions {
+		if stored.UserInfo.Sub == session.UserInfo.Sub {
+			sessions = append(sessions, stored)
+		}
+	}
+	evicted, err := sessionsToEvict(sessions, limit, evict)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range evicted {
+		delete(s.sessions, e.ID)
+	}
+	s.sessions[session.ID] = session
+	return evicted, nil
+}
+
+// removeExpired deletes the expired sessions, the caller has to hold the lock.
+func (s *memorySessionStore) removeExpired() {
+	now := time.Now()
+	for sid, stored := range s.sessions {
+		if now.After(stored.Expiry) {
+			delete(s.sessions, sid)
+		}
+	}
+}
+
+func (s *memorySessionStore) Get(id string) (model.UserInfo, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, exist := s.sessions[id]
+	if !exist || time.Now().After(session.Expiry) {
+		return model.UserInfo{}, false, nil
+	}
+	return session.UserInfo, true, nil
+}
+
+func (s *memorySessionStore) List(sub string) ([]Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := []Session{}
+	now := time.Now()
+	for _, session := range s.sessions {
+		if session.UserInfo.Sub == sub && !now.After(session.Expiry) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *memorySessionStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memorySessionStore) DeleteUser(sub string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deleted := 0
+	for id, session := range s.sessions {
+		if session.UserInfo.Sub == sub {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// redisSessionStore is a SessionStore backed by redis.
+// The user info is stored as json and expires together with the session.
+// The session ids of a user are indexed by a set, which expires with the last session.
+type redisSessionStore struct {
+	client *redis.Client
+}
+
+func newRedisSessionStore(redisURL string) (*redisSessionStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid redis url for the session store")
+	}
+	return &redisSessionStore{client: redis.NewClient(opts)}, nil
+}
+
+// redisSession is the stored json of a session. The user info is embedded,
+// so that the sessions of older versions, which only stored the user info, can still be read.
+type redisSession struct {
+	model.UserInfo
+	SessionExpiry  int64  `json:"session_exp,omitempty"`
+	SessionCreated int64  `json:"session_created,omitempty"`
+	UserAgent      string `json:"session_user_agent,omitempty"`
+	ClientIP       string `json:"session_client_ip,omitempty"`
+	RefreshToken   string `json:"session_refresh_token,omitempty"`
+}
+
+func (s *redisSessionStore) Save(session Session) error {
+	ttl := time.Until(session.Expiry)
+	if ttl <= 0 {
+		return nil
+	}
+	b, err := encodeRedisSession(session)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(redisSessionPrefix+session.ID, b, ttl).Err(); err != nil {
+		return err
+	}
+
+	index := redisUserSessionsPrefix + session.UserInfo.Sub
+	if err := s.client.SAdd(index, session.ID).Err(); err != nil {
+		return err
+	}
+	if indexTTL, err := s.client.TTL(index).Result(); err != nil || indexTTL < ttl {
+		return s.client.Expire(index, ttl).Err()
+	}
+	return nil
+}
+
+// SaveLimited watches the index of the user, so that the transaction fails and is retried,
+// if another login or logout of the user changes the sessions in the meantime.
+func (s *redisSessionStore) SaveLimited(session Session, limit int, evict bool) ([]Session, error) {
+	ttl := time.Until(session.Expiry)
+	if ttl <= 0 {
+		return nil, nil
+	}
+	b, err := encodeRedisSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	index := redisUserSessionsPrefix + session.UserInfo.Sub
+	for i := 0; i < redisSessionLimitRetries; i++ {
+		var evicted []Session
+		err := s.client.Watch(func(tx *redis.Tx) error {
+			sessions, expired, err := listRedisSessions(tx, session.UserInfo.Sub)
+			if err != nil {
+				return err
+			}
+			if evicted, err = sessionsToEvict(sessions, limit, evict); err != nil {
+				return err
+			}
+			indexTTL, err := tx.TTL(index).Result()
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+				for _, id := range expired {
+					pipe.SRem(index, id)
+				}
+				for _, e := range evicted {
+					pipe.Del(redisSessionPrefix + e.ID)
+					pipe.SRem(index, e.ID)
+				}
+				pipe.Set(redisSessionPrefix+session.ID, b, ttl)
+				pipe.SAdd(index, session.ID)
+				if indexTTL < ttl {
+					pipe.Expire(index, ttl)
+				}
+				return nil
+			})
+			return err
+		}, index)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return evicted, nil
+	}
+	return nil, errors.New("can not save the session, because of concurrent changes to the sessions of the user")
+}
+
+func encodeRedisSession(session Session) ([]byte, error) {
+	created := int64(0)
+	if !session.Created.IsZero() {
+		created = session.Created.UnixNano()
+	}
+	return json.Marshal(redisSession{
+		UserInfo:       session.UserInfo,
+		SessionExpiry:  session.Expiry.Unix(),
+		SessionCreated: created,
+		UserAgent:      session.UserAgent,
+		ClientIP:       session.ClientIP,
+		RefreshToken:   session.RefreshToken,
+	})
+}
+
+func (s *redisSessionStore) get(id string) (redisSession, bool, error) {
+	return getRedisSession(s.client, id)
+}
+
+func getRedisSession(client redis.Cmdable, id string) (redisSession, bool, error) {
+	b, err := client.Get(redisSessionPrefix + id).Bytes()
+	if err == redis.Nil {
+		return redisSession{}, false, nil
+	}
+	if err != nil {
+		return redisSession{}, false, err
+	}
+	session := redisSession{}
+	if err := json.Unmarshal(b, &session); err != nil {
+		return redisSession{}, false, errors.Wrap(err, "can not parse the session")
+	}
+	return session, true, nil
+}
+
+func (s *redisSessionStore) Get(id string) (model.UserInfo, bool, error) {
+	session, exist, err := s.get(id)
+	return session.UserInfo, exist, err
+}
+
+// List removes the ids of the expired sessions from the index of the user.
+func (s *redisSessionStore) List(sub string) ([]Session, error) {
+	sessions, expired, err := listRedisSessions(s.client, sub)
+	for _, id := range expired {
+		s.client.SRem(redisUserSessionsPrefix+sub, id)
+	}
+	return sessions, err
+}
+
+// listRedisSessions returns the sessions of the index of the user and the ids of the expired sessions in the index.
+func listRedisSessions(client redis.Cmdable, sub string) ([]Session, []string, error) {
+	ids, err := client.SMembers(redisUserSessionsPrefix + sub).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	sessions := []Session{}
+	expired := []string{}
+	for _, id := range ids {
+		stored, exist, err := getRedisSession(client, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !exist {
+			expired = append(expired, id)
+			continue
+		}
+		session := Session{
+			ID:           id,
+			UserInfo:     stored.UserInfo,
+			Expiry:       time.Unix(stored.SessionExpiry, 0),
+			UserAgent:    stored.UserAgent,
+			ClientIP:     stored.ClientIP,
+			RefreshToken: stored.RefreshToken,
+		}
+		if stored.SessionCreated != 0 {
+			session.Created = time.Unix(0, stored.SessionCreated)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, expired, nil
+}
+
+func (s *redisSessionStore) Delete(id string) error {
+	session, exist, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	if exist {
+		s.client.SRem(redisUserSessionsPrefix+session.Sub, id)
+	}
+	return s.client.Del(redisSessionPrefix + id).Err()
+}
+
+// DeleteUser scans all sessions, because they are only indexed by their id.
+func (s *redisSessionStore) DeleteUser(sub string) (int, error) {
+	deleted := 0
+	iter := s.client.Scan(0, redisSessionPrefix+"*", 100).Iterator()
+	for iter.Next() {
+		key := iter.Val()
+		b, err := s.client.Get(key).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		userInfo := model.UserInfo{}
+		if err := json.Unmarshal(b, &userInfo); err != nil || userInfo.Sub != sub {
+			continue
+		}
+		if err := s.client.Del(key).Err(); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+	return deleted, s.client.Del(redisUserSessionsPrefix + sub).Err()
+}
+
+// createSession stores the user info in a new session and returns the session id for the cookie.
+// A previous session of the request is deleted, so that the session id changes on every login and refresh.
+// The refresh token of the login is bound to the session, which then lasts until the refresh token expires,
+// so that it counts for the session limit.
+// It returns errSessionLimitReached, if the user has too many sessions and the limit denies new ones.
+func (h *Handler) createSession(r *http.Request, userInfo model.UserInfo, refreshToken string, refreshExpiry time.Time) (string, error) {
+	h.deleteSession(r)
+	if token := h.refreshTokenFromRequest(r); token != "" && h.refreshTokens != nil {
+		// the refresh token of the replaced session is replaced as well
+		if err := h.refreshTokens.Delete(token); err != nil {
+			return "", err
+		}
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+	session := Session{
+		ID:           id,
+		UserInfo:     userInfo,
+		Expiry:       time.Unix(userInfo.Expiry, 0),
+		Created:      time.Now(),
+		UserAgent:    truncate(r.UserAgent(), maxSessionUserAgentLength),
+		ClientIP:     clientIP(r),
+		RefreshToken: refreshToken,
+	}
+	if refreshToken != "" && refreshExpiry.After(session.Expiry) {
+		session.Expiry = refreshExpiry
+	}
+
+	if h.config.SessionLimit <= 0 {
+		if err := h.sessions.Save(session); err != nil {
+			return "", errors.Wrap(err, "can not save the session")
+		}
+		return id, nil
+	}
+	evicted, err := h.sessions.SaveLimited(session, h.config.SessionLimit, h.config.SessionLimitMode != SessionLimitDeny)
+	if err == errSessionLimitReached {
+		return "", err
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "can not save the session")
+	}
+	for _, e := range evicted {
+		h.deleteSessionRefreshToken(r, e)
+		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("evicted the oldest session")
+	}
+	return id, nil
+}
+
+// deleteSessionRefreshToken revokes the refresh token, which was issued with the session.
+func (h *Handler) deleteSessionRefreshToken(r *http.Request, session Session) {
+	if session.RefreshToken == "" || h.refreshTokens == nil {
+		return
+	}
+	if err := h.refreshTokens.Delete(session.RefreshToken); err != nil {
+		logging.Application(r.Header).WithError(err).Error("can not delete the refresh token of the session")
+	}
+}
+
+// sessionsToEvict returns the oldest sessions, which have to be deleted for a new session within the limit,
+// or errSessionLimitReached, if the sessions must not be evicted.
+func sessionsToEvict(sessions []Session, limit int, evict bool) ([]Session, error) {
+	if len(sessions) < limit {
+		return nil, nil
+	}
+	if !evict {
+		return nil, errSessionLimitReached
+	}
+	sortSessions(sessions)
+	return sessions[:len(sessions)-limit+1], nil
+}
+
+// sortSessions sorts the sessions by their creation, the oldest first.
+// Sessions of older versions without creation time are sorted by their login time.
+func sortSessions(sessions []Session) {
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if !sessions[i].Created.Equal(sessions[j].Created) {
+			return sessions[i].Created.Before(sessions[j].Created)
+		}
+		if sessions[i].UserInfo.AuthTime != sessions[j].UserInfo.AuthTime {
+			return sessions[i].UserInfo.AuthTime < sessions[j].UserInfo.AuthTime
+		}
+		return sessions[i].Expiry.Before(sessions[j].Expiry)
+	})
+}
+
+// getSession returns the user info of the session id.
+// Errors of the store are logged and the session is treated as invalid.
+func (h *Handler) getSession(r *http.Request, id string) (model.UserInfo, bool) {
+	userInfo, exist, err := h.sessions.Get(id)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error("can not read the session")
+		return model.UserInfo{}, false
+	}
+	return userInfo, exist && userInfo.Valid() == nil
+}
+
+// deleteSession removes the session of the cookie from the store.
+func (h *Handler) deleteSession(r *http.Request) {
+	if h.sessions == nil {
+		return
+	}
+	c, err := r.Cookie(h.config.CookieName)
+	if err != nil {
+		return
+	}
+	if err := h.sessions.Delete(c.Value); err != nil {
+		logging.Application(r.Header).WithError(err).Error("can not delete the session")
+	}
+}
+
+// newSessionID returns a random, unguessable session id
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
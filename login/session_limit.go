@@ -0,0 +1,236 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/pkg/errors"
+)
+
+const sessionsPath = "/sessions"
+
+// The modes of the session limit, if a user with the maximum number of sessions logs in
+const (
+	// SessionLimitEvict deletes the oldest sessions of the user
+	SessionLimitEvict = "evict"
+	// SessionLimitDeny rejects the new login
+	SessionLimitDeny = "deny"
+)
+
+// sessionParameter is the id of the session to revoke at /login/sessions
+const sessionParameter = "session"
+
+const maxSessionUserAgentLength = 200
+
+var errSessionLimitReached = errors.New("session limit reached")
+
+// sessionInfo describes a session in the session list of the user.
+// The id is a hash of the session id, because the session id is the secret of the cookie.
+type sessionInfo struct {
+	ID         string `json:"id"`
+	SignedInAt int64  `json:"signed_in_at,omitempty"`
+	ExpiresAt  int64  `json:"expires_at"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	ClientIP   string `json:"client_ip,omitempty"`
+	Current    bool   `json:"current,omitempty"`
+}
+
+// SignedIn returns the login time for the session list of the user page.
+func (s sessionInfo) SignedIn() string {
+	if s.SignedInAt == 0 {
+		return ""
+	}
+	return time.Unix(s.SignedInAt, 0).UTC().Format("2006-01-02 15:04 UTC")
+}
+
+func validateSessionLimit(config *Config) error {
+	if config.SessionLimitMode != "" && config.SessionLimitMode != SessionLimitEvict && config.SessionLimitMode != SessionLimitDeny {
+		return fmt.Errorf("invalid session-limit-mode %q, supported are %v and %v", config.SessionLimitMode, SessionLimitEvict, SessionLimitDeny)
+	}
+	if config.SessionLimit > 0 && config.SessionStore == "" {
+		return errors.New("session-limit requires a session-store")
+	}
+	if config.SessionLimit > 0 && config.DeviceFlow {
+		return errors.New("session-limit can not be combined with the device-flow, because the device tokens have no session")
+	}
+	return nil
+}
+
+// sessionHandle returns the public id of a session.
+func sessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// userSessions returns the sessions of the user, the current session of the request is marked.
+func (h *Handler) userSessions(r *http.Request, sub string) ([]sessionInfo, error) {
+	sessions, err := h.sessions.List(sub)
+	if err != nil {
+		return nil, err
+	}
+	sortSessions(sessions)
+
+	current := ""
+	if c, err := r.Cookie(h.config.CookieName); err == nil {
+		current = c.Value
+	}
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, sessionInfo{
+			ID:         sessionHandle(session.ID),
+			SignedInAt: session.UserInfo.AuthTime,
+			ExpiresAt:  session.Expiry.Unix(),
+			UserAgent:  session.UserAgent,
+			ClientIP:   session.ClientIP,
+			Current:    session.ID == current,
+		})
+	}
+	return infos, nil
+}
+
+// handleSessions lists the sessions of the logged in user and revokes the other sessions:
+//
+//	GET  /login/sessions                  lists the sessions as json
+//	POST /login/sessions session={id}     revokes another session of the user
+func (h *Handler) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if h.sessions == nil {
+		h.respondNotFound(w, r)
+		return
+	}
+	userInfo, valid := h.GetToken(r)
+	if !valid {
+		if wantHTML(r) {
+			http.Redirect(w, r, h.config.LoginPath, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(401)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if wantHTML(r) {
+			// the sessions are listed on the page of the user
+			http.Redirect(w, r, h.config.LoginPath, http.StatusSeeOther)
+			return
+		}
+		sessions, err := h.userSessions(r, userInfo.Sub)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(sessions) // ignore error of encoding
+	case "POST":
+		if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) {
+			m := map[string]string{}
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				h.respondBadRequest(w, r)
+				return
+			}
+			h.revokeSession(w, r, userInfo, m[sessionParameter])
+			return
+		}
+		if !h.validCSRF(r) {
+			if wantHTML(r) {
+				http.Redirect(w, r, h.config.LoginPath, http.StatusSeeOther)
+				return
+			}
+			h.respondCSRFFailure(w, r)
+			return
+		}
+		h.revokeSession(w, r, userInfo, r.FormValue(sessionParameter))
+	default:
+		h.respondBadRequest(w, r)
+	}
+}
+
+// revokeSession deletes another session of the user by its public id.
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo, handle string) {
+	sessions, err := h.sessions.List(userInfo.Sub)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	current := ""
+	if c, err := r.Cookie(h.config.CookieName); err == nil {
+		current = c.Value
+	}
+	for _, session := range sessions {
+		if handle == "" || sessionHandle(session.ID) != handle || session.ID == current {
+			continue
+		}
+		if err := h.sessions.Delete(session.ID); err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		h.deleteSessionRefreshToken(r, session)
+		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("revoked another session")
+		if wantHTML(r) {
+			http.Redirect(w, r, h.config.LoginPath, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(204)
+		return
+	}
+	h.respondNotFound(w, r)
+}
+
+// respondSessionLimitReached responds to a login, which is denied by the session limit.
+func (h *Handler) respondSessionLimitReached(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo) {
+	logging.Application(r.Header).WithField("username", userInfo.Sub).Info("login denied by the session limit")
+	h.auditEvent(r, audit.LoginFailure, userInfo.Sub, userInfo.Origin, "session limit reached")
+	switch {
+	case wantHTML(r):
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(403)
+		writeLoginForm(w,
+			loginFormData{
+				Lang:               language(r),
+				CSRFToken:          csrfFormToken(r),
+				Config:             h.config,
+				SessionLimitFailed: true,
+				UserInfo:           model.UserInfo{Sub: userInfo.Sub},
+			})
+	case wantJSON(r):
+		respondJSONError(w, 403, errorSessionLimitReached, "Too many sessions", 0)
+	default:
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(403)
+		fmt.Fprint(w, "Too many sessions")
+	}
+}
+
+// respondSessionRequired responds to a login in a response mode without session cookie,
+// because only the tokens with a session can be limited.
+func (h *Handler) respondSessionRequired(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo) {
+	logging.Application(r.Header).WithField("username", userInfo.Sub).Info("login without session denied by the session limit")
+	h.auditEvent(r, audit.LoginFailure, userInfo.Sub, userInfo.Origin, "session required")
+	if wantJSON(r) {
+		respondJSONError(w, 400, errorSessionRequired, "The session limit only allows logins with the cookie response mode", 0)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(400)
+	fmt.Fprint(w, "Bad Request: The session limit only allows logins with the cookie response mode")
+}
+
+// truncate shortens the string to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,252 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/grpcapi"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func testSessionLimitHandler(t *testing.T, limit int, mode string) *Handler {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.SessionStore = "memory"
+	config.SessionLimit = limit
+	config.SessionLimitMode = mode
+	h, err := NewHandler(config)
+	NoError(t, err)
+	return h
+}
+
+// loginSession logs in bob with a browser and returns the session cookie
+func loginSession(t *testing.T, h *Handler, userAgent string) string {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML, "User-Agent: "+userAgent)))
+	Equal(t, 303, recorder.Code)
+	return "Cookie: jwt_token=" + cookieByName(recorder, "jwt_token").Value
+}
+
+func TestHandler_SessionLimit_Evict(t *testing.T) {
+	h := testSessionLimitHandler(t, 2, SessionLimitEvict)
+
+	first := loginSession(t, h, "first")
+	second := loginSession(t, h, "second")
+	third := loginSession(t, h, "third")
+
+	_, valid := h.GetToken(req("GET", "/context/login", "", first))
+	False(t, valid)
+	_, valid = h.GetToken(req("GET", "/context/login", "", second))
+	True(t, valid)
+	_, valid = h.GetToken(req("GET", "/context/login", "", third))
+	True(t, valid)
+
+	// a refresh replaces the session and evicts no other
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, third))
+	Equal(t, 303, recorder.Code)
+	_, valid = h.GetToken(req("GET", "/context/login", "", second))
+	True(t, valid)
+}
+
+func TestHandler_SessionLimit_Deny(t *testing.T) {
+	h := testSessionLimitHandler(t, 1, SessionLimitDeny)
+
+	first := loginSession(t, h, "first")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "You are signed in on too many devices")
+	Nil(t, cookieByName(recorder, "jwt_token"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login?response_mode=cookie", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), `"error":"session_limit_reached"`)
+
+	// the existing session is still valid and can be refreshed
+	_, valid := h.GetToken(req("GET", "/context/login", "", first))
+	True(t, valid)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, first))
+	Equal(t, 303, recorder.Code)
+
+	// logins without a session could not be limited, so they are rejected
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 400, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), `"error":"session_required"`)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login?response_mode=header", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 400, recorder.Code)
+	Equal(t, "", recorder.Header().Get("Authorization"))
+
+	_, err := (&grpcService{h: h}).Authenticate(req("POST", "/", ""), &grpcapi.AuthenticateRequest{Username: "bob", Password: "secret"})
+	Equal(t, grpcapi.FailedPrecondition, grpcCode(err))
+}
+
+func TestHandler_SessionLimit_RefreshToken(t *testing.T) {
+	h := testSessionLimitHandler(t, 1, SessionLimitEvict)
+	h.config.RefreshTokenExpiry = 48 * time.Hour
+	h.refreshTokens = newMemoryRefreshTokenStore()
+
+	login := func() (string, string) {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+		Equal(t, 303, recorder.Code)
+		return "Cookie: jwt_token=" + cookieByName(recorder, "jwt_token").Value, cookieByName(recorder, "jwt_token_refresh").Value
+	}
+	first, firstRefreshToken := login()
+
+	// the session lasts as long as its refresh token, so that the refresh token counts for the limit
+	sessions, err := h.sessions.List("bob")
+	NoError(t, err)
+	Equal(t, 1, len(sessions))
+	Equal(t, firstRefreshToken, sessions[0].RefreshToken)
+	InDelta(t, time.Now().Add(48*time.Hour).Unix(), sessions[0].Expiry.Unix(), 2)
+
+	// the eviction of the session revokes its refresh token
+	second, _ := login()
+	_, valid := h.GetToken(req("GET", "/context/login", "", first))
+	False(t, valid)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/refresh", "refresh_token="+firstRefreshToken, TypeForm, AcceptHTML))
+	Equal(t, 401, recorder.Code)
+	_, valid = h.GetToken(req("GET", "/context/login", "", second))
+	True(t, valid)
+
+	// refresh tokens are only issued with a session
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 400, recorder.Code)
+	Equal(t, "", recorder.Header().Get("X-Refresh-Token"))
+	Equal(t, 1, len(h.refreshTokens.(*memoryRefreshTokenStore).tokens))
+}
+
+func TestHandler_SessionLimit_DenyKeepsRefreshTokens(t *testing.T) {
+	h := testSessionLimitHandler(t, 1, SessionLimitDeny)
+	h.config.RefreshTokenExpiry = time.Hour
+	h.refreshTokens = newMemoryRefreshTokenStore()
+
+	loginSession(t, h, "first")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 403, recorder.Code)
+	Nil(t, cookieByName(recorder, "jwt_token_refresh"))
+	// no refresh token is left over from the denied login
+	Equal(t, 1, len(h.refreshTokens.(*memoryRefreshTokenStore).tokens))
+}
+
+func TestHandler_Sessions_List(t *testing.T) {
+	h := testSessionLimitHandler(t, 0, SessionLimitEvict)
+
+	first := loginSession(t, h, "first browser")
+	loginSession(t, h, "second browser")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/sessions", "", first, "Accept: application/json"))
+	Equal(t, 200, recorder.Code)
+	var sessions []sessionInfo
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &sessions))
+	Equal(t, 2, len(sessions))
+	Equal(t, "first browser", sessions[0].UserAgent)
+	True(t, sessions[0].Current)
+	Equal(t, "second browser", sessions[1].UserAgent)
+	False(t, sessions[1].Current)
+	// the list does not reveal the session ids
+	NotContains(t, first, sessions[0].ID)
+
+	// the user page lists the sessions
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", first, AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "This device")
+	Contains(t, recorder.Body.String(), "second browser")
+	Contains(t, recorder.Body.String(), `name="session" type="hidden" value="`+sessions[1].ID+`"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/sessions", "", "Accept: application/json"))
+	Equal(t, 401, recorder.Code)
+}
+
+func TestHandler_Sessions_Revoke(t *testing.T) {
+	h := testSessionLimitHandler(t, 0, SessionLimitEvict)
+
+	first := loginSession(t, h, "first")
+	second := loginSession(t, h, "second")
+	sessions, err := h.userSessions(req("GET", "/context/login", "", first), "bob")
+	NoError(t, err)
+
+	// the form needs a csrf token
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/sessions", "session="+sessions[1].ID, TypeForm, AcceptHTML, first))
+	Equal(t, 303, recorder.Code)
+	_, valid := h.GetToken(req("GET", "/context/login", "", second))
+	True(t, valid)
+
+	// the current session can't be revoked
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/sessions", `{"session": "`+sessions[0].ID+`"}`, TypeJSON, first))
+	Equal(t, 404, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login/sessions", "session="+sessions[1].ID, TypeForm, AcceptHTML, first)))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/context/login", recorder.Header().Get("Location"))
+	_, valid = h.GetToken(req("GET", "/context/login", "", second))
+	False(t, valid)
+	_, valid = h.GetToken(req("GET", "/context/login", "", first))
+	True(t, valid)
+}
+
+func TestHandler_Sessions_NoStore(t *testing.T) {
+	h := testHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/sessions", "", "Accept: application/json"))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestValidateSessionLimit(t *testing.T) {
+	config := DefaultConfig()
+	NoError(t, validateSessionLimit(config))
+
+	config.SessionLimit = 2
+	Error(t, validateSessionLimit(config))
+
+	config.SessionStore = "memory"
+	NoError(t, validateSessionLimit(config))
+
+	config.SessionLimitMode = "oldest"
+	Error(t, validateSessionLimit(config))
+
+	config.SessionLimitMode = SessionLimitEvict
+	config.DeviceFlow = true
+	Error(t, validateSessionLimit(config))
+}
+
+func TestSessionStore_SaveLimited_Concurrent(t *testing.T) {
+	s := newMemorySessionStore()
+	userInfo := model.UserInfo{Sub: "bob"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.SaveLimited(Session{ID: strings.Repeat("x", i+1), UserInfo: userInfo, Expiry: time.Now().Add(time.Hour), Created: time.Now()}, 3, false)
+		}(i)
+	}
+	wg.Wait()
+	sessions, err := s.List("bob")
+	NoError(t, err)
+	Equal(t, 3, len(sessions))
+}
@@ -0,0 +1,230 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Session(t *testing.T) {
+	h := testHandler()
+	h.sessions = newMemorySessionStore()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 303, recorder.Code)
+	sessionID := recorder.Result().Cookies()[0].Value
+
+	// the cookie only contains the session id
+	_, err := tokenAsMap(sessionID)
+	Error(t, err)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Cookie: jwt_token="+sessionID, "Accept: application/json"))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `"sub":"bob"`)
+
+	// a refresh replaces the session
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", "Cookie: jwt_token="+sessionID, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	refreshedID := recorder.Result().Cookies()[0].Value
+	NotEqual(t, sessionID, refreshedID)
+	_, valid := h.GetToken(req("GET", "/context/login", "", "Cookie: jwt_token="+sessionID))
+	False(t, valid)
+
+	// the logout ends the session immediately
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", "", "Cookie: jwt_token="+refreshedID))
+	Equal(t, 200, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", "Cookie: jwt_token="+refreshedID, "Accept: application/json"))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_SessionAPILogin(t *testing.T) {
+	h := testHandler()
+	h.sessions = newMemorySessionStore()
+
+	// clients asking for a jwt still get a token
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	s := newMemorySessionStore()
+	userInfo := model.UserInfo{Sub: "bob", Groups: []string{"admin"}}
+
+	NoError(t, s.Save(Session{ID: "a", UserInfo: userInfo, Expiry: time.Now().Add(time.Hour)}))
+	NoError(t, s.Save(Session{ID: "b", UserInfo: userInfo, Expiry: time.Now().Add(-time.Second)}))
+
+	u, exist, err := s.Get("a")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, userInfo, u)
+
+	_, exist, _ = s.Get("b")
+	False(t, exist)
+
+	NoError(t, s.Delete("a"))
+	_, exist, _ = s.Get("a")
+	False(t, exist)
+
+	// expired sessions are removed
+	NoError(t, s.Save(Session{ID: "c", UserInfo: userInfo, Expiry: time.Now().Add(time.Hour)}))
+	Equal(t, 1, len(s.sessions))
+
+	NoError(t, s.Save(Session{ID: "d", UserInfo: userInfo, Expiry: time.Now().Add(time.Hour)}))
+	NoError(t, s.Save(Session{ID: "e", UserInfo: model.UserInfo{Sub: "alice"}, Expiry: time.Now().Add(time.Hour), UserAgent: "curl"}))
+	sessions, err := s.List("alice")
+	NoError(t, err)
+	Equal(t, 1, len(sessions))
+	Equal(t, "e", sessions[0].ID)
+	Equal(t, "curl", sessions[0].UserAgent)
+
+	deleted, err := s.DeleteUser("bob")
+	NoError(t, err)
+	Equal(t, 2, deleted)
+	_, exist, _ = s.Get("e")
+	True(t, exist)
+}
+
+func TestMemorySessionStore_SaveLimited(t *testing.T) {
+	s := newMemorySessionStore()
+	userInfo := model.UserInfo{Sub: "bob"}
+	now := time.Now()
+	session := func(id string, created time.Time) Session {
+		return Session{ID: id, UserInfo: userInfo, Expiry: now.Add(time.Hour), Created: created}
+	}
+
+	// the sessions are ordered by their creation, even within the same second
+	NoError(t, s.Save(session("b", now.Add(time.Millisecond))))
+	NoError(t, s.Save(session("a", now)))
+	NoError(t, s.Save(Session{ID: "other", UserInfo: model.UserInfo{Sub: "alice"}, Expiry: now.Add(time.Hour)}))
+
+	_, err := s.SaveLimited(session("c", now.Add(2*time.Millisecond)), 2, false)
+	Equal(t, errSessionLimitReached, err)
+	_, exist, _ := s.Get("c")
+	False(t, exist)
+
+	evicted, err := s.SaveLimited(session("c", now.Add(2*time.Millisecond)), 2, true)
+	NoError(t, err)
+	Equal(t, 1, len(evicted))
+	Equal(t, "a", evicted[0].ID)
+	_, exist, _ = s.Get("a")
+	False(t, exist)
+	_, exist, _ = s.Get("c")
+	True(t, exist)
+	_, exist, _ = s.Get("other")
+	True(t, exist)
+}
+
+func TestRedisSessionStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	NoError(t, err)
+	defer mr.Close()
+
+	store, err := newSessionStore("redis://" + mr.Addr() + "/0")
+	NoError(t, err)
+	s := store.(*redisSessionStore)
+	userInfo := model.UserInfo{Sub: "bob", Origin: "simple", Groups: []string{"admin"}, Expiry: time.Now().Add(time.Hour).Unix()}
+
+	NoError(t, s.Save(Session{ID: "a", UserInfo: userInfo, Expiry: time.Now().Add(time.Hour)}))
+	NoError(t, s.Save(Session{ID: "b", UserInfo: userInfo, Expiry: time.Now().Add(-time.Second)}))
+	InDelta(t, time.Hour.Seconds(), mr.TTL("loginsrv:session:a").Seconds(), 2)
+	False(t, mr.Exists("loginsrv:session:b"))
+
+	u, exist, err := s.Get("a")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, userInfo, u)
+
+	_, exist, err = s.Get("b")
+	NoError(t, err)
+	False(t, exist)
+
+	NoError(t, s.Delete("a"))
+	_, exist, _ = s.Get("a")
+	False(t, exist)
+
+	NoError(t, s.Save(Session{ID: "c", UserInfo: userInfo, Expiry: time.Now().Add(time.Hour)}))
+	NoError(t, s.Save(Session{ID: "d", UserInfo: userInfo, Expiry: time.Now().Add(time.Hour)}))
+	NoError(t, s.Save(Session{ID: "e", UserInfo: model.UserInfo{Sub: "alice"}, Expiry: time.Now().Add(time.Hour), UserAgent: "curl", ClientIP: "10.0.0.1"}))
+	sessions, err := s.List("bob")
+	NoError(t, err)
+	Equal(t, 2, len(sessions))
+	sessions, err = s.List("alice")
+	NoError(t, err)
+	Equal(t, 1, len(sessions))
+	Equal(t, "curl", sessions[0].UserAgent)
+	Equal(t, "10.0.0.1", sessions[0].ClientIP)
+	Equal(t, "alice", sessions[0].UserInfo.Sub)
+
+	// sessions, which expired in the meantime, are removed from the index
+	mr.Del("loginsrv:session:e")
+	sessions, err = s.List("alice")
+	NoError(t, err)
+	Equal(t, 0, len(sessions))
+	False(t, mr.Exists("loginsrv:user-sessions:alice"))
+
+	deleted, err := s.DeleteUser("bob")
+	NoError(t, err)
+	Equal(t, 2, deleted)
+	False(t, mr.Exists("loginsrv:session:c"))
+	False(t, mr.Exists("loginsrv:user-sessions:bob"))
+
+	// the limited save evicts the oldest sessions and removes the expired ones from the index
+	now := time.Now()
+	NoError(t, s.Save(Session{ID: "f", UserInfo: userInfo, Expiry: now.Add(time.Hour), Created: now.Add(time.Millisecond), RefreshToken: "rt"}))
+	NoError(t, s.Save(Session{ID: "g", UserInfo: userInfo, Expiry: now.Add(time.Hour), Created: now}))
+	NoError(t, s.Save(Session{ID: "h", UserInfo: userInfo, Expiry: now.Add(time.Hour), Created: now.Add(-time.Millisecond)}))
+	mr.Del("loginsrv:session:h")
+	_, err = s.SaveLimited(Session{ID: "i", UserInfo: userInfo, Expiry: now.Add(time.Hour), Created: now.Add(2 * time.Millisecond)}, 2, false)
+	Equal(t, errSessionLimitReached, err)
+	False(t, mr.Exists("loginsrv:session:i"))
+
+	evicted, err := s.SaveLimited(Session{ID: "i", UserInfo: userInfo, Expiry: now.Add(time.Hour), Created: now.Add(2 * time.Millisecond)}, 2, true)
+	NoError(t, err)
+	Equal(t, 1, len(evicted))
+	Equal(t, "g", evicted[0].ID)
+	sessions, err = s.List("bob")
+	NoError(t, err)
+	sortSessions(sessions)
+	Equal(t, 2, len(sessions))
+	Equal(t, "f", sessions[0].ID)
+	Equal(t, "rt", sessions[0].RefreshToken)
+	Equal(t, now.UnixNano()+int64(time.Millisecond), sessions[0].Created.UnixNano())
+	Equal(t, "i", sessions[1].ID)
+	members, err := mr.SMembers("loginsrv:user-sessions:bob")
+	NoError(t, err)
+	ElementsMatch(t, []string{"f", "i"}, members)
+
+	mr.Close()
+	_, _, err = s.Get("a")
+	Error(t, err)
+}
+
+func TestNewSessionStore(t *testing.T) {
+	s, err := newSessionStore("")
+	NoError(t, err)
+	Nil(t, s)
+
+	s, err = newSessionStore("memory")
+	NoError(t, err)
+	IsType(t, &memorySessionStore{}, s)
+
+	_, err = newSessionStore("redis://localhost:6379/foo")
+	Error(t, err)
+
+	_, err = newSessionStore("file")
+	Error(t, err)
+}
@@ -0,0 +1,56 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const staticPath = "/static"
+
+// staticDir is the directory of the static assets within the template dir
+const staticDir = "static"
+
+// validateTemplateDir checks, that the template dir exists.
+// The templates are parsed for every request, so they can be changed without a restart.
+func validateTemplateDir(config *Config) error {
+	if config.TemplateDir == "" {
+		return nil
+	}
+	info, err := os.Stat(config.TemplateDir)
+	if err != nil {
+		return fmt.Errorf("can't read template dir: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("template dir %v is not a directory", config.TemplateDir)
+	}
+	return nil
+}
+
+// handleStatic serves the files of the static directory within the template dir,
+// e.g. the stylesheets and images of a custom login form.
+func (h *Handler) handleStatic(w http.ResponseWriter, r *http.Request) {
+	if h.config.TemplateDir == "" || (r.Method != "GET" && r.Method != "HEAD") {
+		h.respondNotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, h.config.LoginPath+staticPath)
+	// http.Dir does not allow paths outside of the directory
+	f, err := http.Dir(filepath.Join(h.config.TemplateDir, staticDir)).Open(name)
+	if err != nil {
+		h.respondNotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		// no directory listings
+		h.respondNotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,61 @@
+package login
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Static(t *testing.T) {
+	dir := filepath.Dir(writeConfigFile(t, "header.html", `{{define "header"}}ACME{{end}}`))
+	NoError(t, os.MkdirAll(filepath.Join(dir, "static", "img"), 0700))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "static", "acme.css"), []byte("body { color: red }"), 0600))
+
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.TemplateDir = dir
+	h, err := NewHandler(config)
+	NoError(t, err)
+	defer h.Close()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/static/acme.css", ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "body { color: red }", recorder.Body.String())
+	Contains(t, recorder.Header().Get("Content-Type"), "text/css")
+
+	for _, path := range []string{
+		"/context/login/static/missing.css",
+		"/context/login/static/img/",
+		"/context/login/static/../header.html",
+		"/context/login/static/%2e%2e/header.html",
+	} {
+		recorder = httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", path, ""))
+		Equal(t, 404, recorder.Code, path)
+	}
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/static/acme.css", ""))
+	Equal(t, 404, recorder.Code)
+
+	// without template dir, the path is not served
+	recorder = httptest.NewRecorder()
+	testHandler().ServeHTTP(recorder, req("GET", "/context/login/static/acme.css", ""))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestValidateTemplateDir(t *testing.T) {
+	config := testConfig()
+	NoError(t, validateTemplateDir(config))
+
+	config.TemplateDir = "/does/not/exist"
+	Error(t, validateTemplateDir(config))
+
+	config.TemplateDir = writeConfigFile(t, "header.html", "")
+	Error(t, validateTemplateDir(config))
+}
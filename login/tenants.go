@@ -0,0 +1,171 @@
+package login
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// tenantsFlag is the option of the tenants file
+const tenantsFlag = "tenants"
+
+// readTenantConfigs reads the tenants file, which has the options of every tenant by the host name, e.g.
+//
+//	login.a.example.com:
+//	  jwt-secret: ...
+//	  cookie-domain: a.example.com
+//	  htpasswd:
+//	    file: /etc/loginsrv/a.htpasswd
+//	login.b.example.com:
+//	  template: /etc/loginsrv/b.html
+//	  github:
+//	    client_id: ...
+//
+// A tenant has the options of the config, but none of its providers.
+func readTenantConfigs(config *Config) (map[string]*Config, error) {
+	values, err := readConfigFile(config.Tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(values))
+	for host := range values {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	tenants := map[string]*Config{}
+	for _, host := range hosts {
+		source := "tenant " + host + " of " + config.Tenants
+		tenantValues, err := optionsMap(values[host])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v: %v", source, err)
+		}
+
+		tenant := *config
+		tenant.Tenants = ""
+		tenant.Backends = Options{}
+		tenant.Oauth = Options{}
+		tenant.SAML = nil
+		tenant.Kerberos = nil
+		tenant.X509 = nil
+		tenant.TrustedHeader = nil
+		f := flag.NewFlagSet(host, flag.ContinueOnError)
+		tenant.ConfigureFlagSet(f)
+		if err := setFlags(f, tenantValues, source, configFileFlag, tenantsFlag); err != nil {
+			return nil, err
+		}
+		if err := tenant.ResolveSecrets(); err != nil {
+			return nil, fmt.Errorf("%v: %v", source, err)
+		}
+		tenants[strings.ToLower(host)] = &tenant
+	}
+	return tenants, nil
+}
+
+// optionsMap returns the options of a tenant of the YAML or TOML file
+func optionsMap(value interface{}) (map[string]interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = e
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("expected the options, but got %v", value)
+}
+
+// newTenantHandlers creates a handler for every tenant
+func newTenantHandlers(config *Config) (map[string]*Handler, error) {
+	if config.Tenants == "" {
+		return nil, nil
+	}
+	configs, err := readTenantConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+	tenants := map[string]*Handler{}
+	for host, tenantConfig := range configs {
+		h, err := NewHandler(tenantConfig)
+		if err != nil {
+			closeTenants(tenants)
+			return nil, fmt.Errorf("tenant %v: %v", host, err)
+		}
+		tenants[host] = h
+	}
+	return tenants, nil
+}
+
+// reloadTenants reloads the handlers of the tenants, which exist before and after the reload,
+// and creates the handlers of the new tenants
+func reloadTenants(current map[string]*Handler, config *Config) (map[string]*Handler, error) {
+	if config.Tenants == "" {
+		return nil, nil
+	}
+	configs, err := readTenantConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+	tenants := map[string]*Handler{}
+	for host, tenantConfig := range configs {
+		var h *Handler
+		if currentHandler, exist := current[host]; exist {
+			h, err = currentHandler.Reload(tenantConfig)
+		} else {
+			h, err = NewHandler(tenantConfig)
+		}
+		if err != nil {
+			discardTenants(current, tenants)
+			return nil, fmt.Errorf("tenant %v: %v", host, err)
+		}
+		tenants[host] = h
+	}
+	return tenants, nil
+}
+
+// discardTenants closes the handlers of a failed reload. The reloaded handlers share the stores
+// with the current handlers, so only their backends are closed.
+func discardTenants(current, tenants map[string]*Handler) {
+	for host, h := range tenants {
+		if _, exist := current[host]; exist {
+			closeBackends(h.backends) // ignore error, the backends were never used
+		} else {
+			h.Close()
+		}
+	}
+}
+
+func closeTenants(tenants map[string]*Handler) error {
+	var firstErr error
+	for _, h := range tenants {
+		if err := h.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// tenant returns the handler for the host of the request
+func (h *Handler) tenant(r *http.Request) (*Handler, bool) {
+	if len(h.tenants) == 0 {
+		return nil, false
+	}
+	host := r.Host
+	if hostName, _, err := net.SplitHostPort(host); err == nil {
+		host = hostName
+	}
+	tenant, exist := h.tenants[strings.ToLower(host)]
+	return tenant, exist
+}
+
+// hasProviders returns true, if the config has any login backend, oauth, saml, kerberos, x509 or trusted header provider
+func (c *Config) hasProviders() bool {
+	return len(c.Backends) != 0 || len(c.Oauth) != 0 || len(c.SAML) != 0 || len(c.Kerberos) != 0 || len(c.X509) != 0 ||
+		len(c.TrustedHeader) != 0
+}
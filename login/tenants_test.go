@@ -0,0 +1,127 @@
+package login
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+const tenantsConfig = `
+login.a.example.com:
+  jwt-secret: secret-a
+  cookie-domain: a.example.com
+  simple:
+    alice: secret
+Login.B.example.com:
+  jwt-secret: secret-b
+  simple:
+    bob: secret
+`
+
+func TestTenants_ServeHTTP(t *testing.T) {
+	config := DefaultConfig()
+	config.JwtSecret = "secret-default"
+	config.Backends = Options{"simple": {"carol": "secret"}}
+	config.Tenants = writeConfigFile(t, "tenants.yaml", tenantsConfig)
+	h, err := NewHandler(config)
+	NoError(t, err)
+	defer h.Close()
+
+	// every host has its own backends and secret
+	for _, test := range []struct {
+		host     string
+		username string
+		secret   string
+	}{
+		{"login.a.example.com", "alice", "secret-a"},
+		{"login.b.example.com:8080", "bob", "secret-b"},
+		{"other.example.com", "carol", "secret-default"},
+	} {
+		t.Run(test.host, func(t *testing.T) {
+			for _, username := range []string{"alice", "bob", "carol"} {
+				recorder := tenantLogin(h, test.host, username)
+				if username != test.username {
+					Equal(t, 403, recorder.Code, username)
+					continue
+				}
+				Equal(t, 200, recorder.Code)
+				token, err := jwt.Parse(recorder.Body.String(), func(*jwt.Token) (interface{}, error) {
+					return []byte(test.secret), nil
+				})
+				NoError(t, err)
+				Equal(t, username, token.Claims.(jwt.MapClaims)["sub"])
+			}
+		})
+	}
+
+	// the other options are inherited
+	Equal(t, "a.example.com", h.tenants["login.a.example.com"].config.CookieDomain)
+	Equal(t, "", h.tenants["login.b.example.com"].config.CookieDomain)
+	Equal(t, config.JwtExpiry, h.tenants["login.b.example.com"].config.JwtExpiry)
+}
+
+func TestTenants_OnlyTenants(t *testing.T) {
+	config := DefaultConfig()
+	config.Tenants = writeConfigFile(t, "tenants.yaml", tenantsConfig)
+	h, err := NewHandler(config)
+	NoError(t, err)
+	defer h.Close()
+
+	Equal(t, 200, tenantLogin(h, "login.a.example.com", "alice").Code)
+	Equal(t, 404, tenantLogin(h, "other.example.com", "alice").Code)
+}
+
+func TestTenants_Reload(t *testing.T) {
+	config := DefaultConfig()
+	config.Tenants = writeConfigFile(t, "tenants.yaml", tenantsConfig)
+	h, err := NewHandler(config)
+	NoError(t, err)
+
+	NoError(t, ioutil.WriteFile(config.Tenants, []byte(`
+login.a.example.com:
+  simple:
+    alice: new-secret
+login.c.example.com:
+  simple:
+    carol: secret
+`), 0600))
+	reloaded, err := h.Reload(DefaultConfig())
+	NoError(t, err)
+	defer reloaded.Close()
+
+	Equal(t, 403, tenantLogin(reloaded, "login.a.example.com", "alice").Code)
+	Equal(t, 200, tenantLogin(reloaded, "login.c.example.com", "carol").Code)
+	Equal(t, 404, tenantLogin(reloaded, "login.b.example.com", "bob").Code)
+	// the options of the tenants are not reloaded
+	Equal(t, "secret-a", reloaded.tenants["login.a.example.com"].config.JwtSecret)
+}
+
+func TestTenants_Errors(t *testing.T) {
+	for _, content := range []string{
+		"login.a.example.com: nonsense",
+		"login.a.example.com: {tenants: other.yaml}",
+		"login.a.example.com: {config: other.yaml}",
+		"login.a.example.com: {no-such-option: 1}",
+		"login.a.example.com: {jwt-secret: secret}",
+	} {
+		config := DefaultConfig()
+		config.Tenants = writeConfigFile(t, "tenants.yaml", content)
+		_, err := NewHandler(config)
+		Error(t, err, content)
+	}
+}
+
+func tenantLogin(h http.Handler, host, username string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username": "`+username+`", "password": "secret"}`))
+	r.Host = host
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept", "application/jwt")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,66 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const tokenInfoPath = "/token-info"
+
+// handleTokenInfo returns the decoded claims of the token from the cookie or authorization header.
+// The signature is NOT verified, so this must only be used for debugging.
+func (h *Handler) handleTokenInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Warning", `199 loginsrv "WARNING: DO NOT ENABLE IN PRODUCTION"`)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	if r.Method != "GET" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	tokenString := h.tokenFromRequest(r)
+	if tokenString == "" {
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(404)
+		w.Write([]byte("No token found"))
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	tokenString, err := h.decryptToken(tokenString)
+	if err == nil {
+		_, _, err = new(jwt.Parser).ParseUnverified(tokenString, claims)
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Info("can not decode token for token info")
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(400)
+		w.Write([]byte("Bad Request: Token can not be decoded"))
+		return
+	}
+
+	b, err := json.MarshalIndent(claims, "", "  ")
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(b)
+}
+
+// tokenFromRequest returns the raw token from the cookie or the bearer authorization header.
+func (h *Handler) tokenFromRequest(r *http.Request) string {
+	if c, err := r.Cookie(h.config.CookieName); err == nil && c.Value != "" {
+		return c.Value
+	}
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	}
+	return ""
+}
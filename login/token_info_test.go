@@ -0,0 +1,61 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_TokenInfo(t *testing.T) {
+	h := testHandler()
+	h.config.DebugMode = true
+	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()}
+	token, err := h.createToken(input)
+	NoError(t, err)
+
+	// token from cookie
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/token-info", "", "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	Contains(t, recorder.Header().Get("Warning"), "DO NOT ENABLE IN PRODUCTION")
+	Contains(t, recorder.Body.String(), "\n  \"sub\": \"marvin\"")
+
+	claims := map[string]interface{}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &claims))
+	Equal(t, "marvin", claims["sub"])
+
+	// token from authorization header, signature is not checked
+	h.config.JwtSecret = "another secret"
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/token-info", "", "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &claims))
+	Equal(t, "marvin", claims["sub"])
+
+	// no token
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/token-info", ""))
+	Equal(t, 404, recorder.Code)
+
+	// garbage token
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/token-info", "", "Authorization: Bearer foo.bar"))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_TokenInfo_DisabledByDefault(t *testing.T) {
+	h := testHandler()
+	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()}
+	token, err := h.createToken(input)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/token-info", "", "Accept: application/json", "Authorization: Bearer "+token))
+	Empty(t, recorder.Header().Get("Warning"))
+	Equal(t, 403, recorder.Code)
+}
@@ -0,0 +1,101 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const tokenValidatePath = "/token/validate"
+
+// The reasons, why a token is not valid
+const (
+	reasonExpired          = "expired"
+	reasonInvalidSignature = "invalid_signature"
+	reasonNotYetValid      = "not_yet_valid"
+	reasonRevoked          = "revoked"
+	reasonInvalidIssuer    = "invalid_issuer"
+	reasonInvalidAudience  = "invalid_audience"
+	reasonInvalidType      = "invalid_type"
+)
+
+type tokenValidateRequest struct {
+	Token string `json:"token"`
+}
+
+type tokenValidateResponse struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// handleTokenValidate checks a token and only returns, whether it is valid.
+// It needs no credentials, so the requests are limited per client ip.
+func (h *Handler) handleTokenValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	if ok, retryAfter := h.validateLimiter.Allow(clientIP(r)); !ok {
+		logging.Application(r.Header).WithField("client_ip", clientIP(r)).Warn("rate limit for token validation exceeded")
+		w.Header().Set("Retry-After", fmt.Sprintf("%v", retryAfterSeconds(retryAfter)))
+		if wantJSON(r) {
+			respondJSONError(w, 429, errorRateLimited, "Too Many Requests", retryAfter)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(429)
+		fmt.Fprint(w, "Too Many Requests")
+		return
+	}
+
+	req := tokenValidateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(400)
+		fmt.Fprint(w, "Bad Request: Expected a json body with a token")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.validateToken(r, req.Token)) // ignore error of encoding
+}
+
+// validateToken verifies the token the same way as GetToken does, but reports the reason of a failure.
+func (h *Handler) validateToken(r *http.Request, tokenString string) tokenValidateResponse {
+	claims := jwt.MapClaims{}
+	if err := h.parseWithClaims(r, tokenString, claims); err != nil {
+		validationErr, ok := err.(*jwt.ValidationError)
+		switch {
+		case ok && validationErr.Errors&jwt.ValidationErrorExpired != 0:
+			return tokenValidateResponse{Reason: reasonExpired}
+		case ok && validationErr.Errors&jwt.ValidationErrorNotValidYet != 0:
+			return tokenValidateResponse{Reason: reasonNotYetValid}
+		default:
+			return tokenValidateResponse{Reason: reasonInvalidSignature}
+		}
+	}
+
+	// tokens without expiry are never accepted by loginsrv
+	if _, hasExpiry := claims["exp"]; !hasExpiry {
+		return tokenValidateResponse{Reason: reasonExpired}
+	}
+	if h.config.JwtIssuer != "" && !claims.VerifyIssuer(h.config.JwtIssuer, true) {
+		return tokenValidateResponse{Reason: reasonInvalidIssuer}
+	}
+	if h.config.JwtAudience != "" && !claims.VerifyAudience(h.config.JwtAudience, true) {
+		return tokenValidateResponse{Reason: reasonInvalidAudience}
+	}
+	if typ, _ := claims["typ"].(string); typ != "" {
+		return tokenValidateResponse{Reason: reasonInvalidType}
+	}
+	if jti, _ := claims["jti"].(string); h.isRevoked(r, model.UserInfo{ID: jti}) {
+		return tokenValidateResponse{Reason: reasonRevoked}
+	}
+	return tokenValidateResponse{Valid: true}
+}
@@ -0,0 +1,112 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_TokenValidate(t *testing.T) {
+	h := testHandler()
+	validToken, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	expiredToken, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Unix() - 1})
+	NoError(t, err)
+	foreignToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()}).
+		SignedString([]byte("another secret"))
+	NoError(t, err)
+	notYetValidToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"sub": "marvin",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"nbf": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte(h.config.JwtSecret))
+	NoError(t, err)
+	noExpiryToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{"sub": "marvin"}).
+		SignedString([]byte(h.config.JwtSecret))
+	NoError(t, err)
+
+	for _, test := range []struct {
+		token    string
+		expected tokenValidateResponse
+	}{
+		{validToken, tokenValidateResponse{Valid: true}},
+		{expiredToken, tokenValidateResponse{Reason: "expired"}},
+		{foreignToken, tokenValidateResponse{Reason: "invalid_signature"}},
+		{notYetValidToken, tokenValidateResponse{Reason: "not_yet_valid"}},
+		{noExpiryToken, tokenValidateResponse{Reason: "expired"}},
+		{"foo.bar.baz", tokenValidateResponse{Reason: "invalid_signature"}},
+	} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token":"`+test.token+`"}`, "Content-Type: application/json"))
+		Equal(t, 200, recorder.Code)
+		Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+		response := tokenValidateResponse{}
+		NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		Equal(t, test.expected, response)
+	}
+}
+
+func TestHandler_TokenValidate_IssuerAndAudience(t *testing.T) {
+	h := testHandler()
+	h.config.JwtIssuer = "https://login.example.com"
+	h.config.JwtAudience = "example"
+	token, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	for _, test := range []struct {
+		issuer   string
+		audience string
+		expected tokenValidateResponse
+	}{
+		{"https://login.example.com", "example", tokenValidateResponse{Valid: true}},
+		{"", "", tokenValidateResponse{Valid: true}},
+		{"https://other.example.com", "example", tokenValidateResponse{Reason: "invalid_issuer"}},
+		{"https://login.example.com", "other", tokenValidateResponse{Reason: "invalid_audience"}},
+	} {
+		h.config.JwtIssuer = test.issuer
+		h.config.JwtAudience = test.audience
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token":"`+token+`"}`, "Content-Type: application/json"))
+		response := tokenValidateResponse{}
+		NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		Equal(t, test.expected, response)
+	}
+}
+
+func TestHandler_TokenValidate_BadRequest(t *testing.T) {
+	h := testHandler()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/token/validate", ""))
+	Equal(t, 400, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{}`, "Content-Type: application/json"))
+	Equal(t, 400, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `no json`, "Content-Type: application/json"))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_TokenValidate_RateLimit(t *testing.T) {
+	h := testHandler()
+	h.validateLimiter = newRateLimiter(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token":"foo"}`))
+		Equal(t, 200, recorder.Code)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token/validate", `{"token":"foo"}`))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "60", recorder.Header().Get("Retry-After"))
+}
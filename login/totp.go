@@ -0,0 +1,359 @@
+package login
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	qrcode "github.com/skip2/go-qrcode"
+	bolt "go.etcd.io/bbolt"
+)
+
+// SecondFactorTOTP is the value of the 2fa option for time-based one-time passwords (RFC 6238)
+const SecondFactorTOTP = "totp"
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSecretLength  = 20
+	totpPendingExpiry = 5 * time.Minute
+	// the number of time steps, a code may be off due to clock drift
+	totpSkew = 1
+	// the number of codes, which can be tried for a user within the pending expiry
+	totpAttempts = 5
+)
+
+var totpSecretsBucket = []byte("totp_secrets")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPSecretStore persists the TOTP secrets per user.
+type TOTPSecretStore interface {
+	// Get returns the base32 encoded secret of the user and false, if the user is not enrolled.
+	Get(key string) (string, bool, error)
+
+	// Save stores the base32 encoded secret of the user.
+	Save(key string, secret string) error
+}
+
+// boltTOTPSecretStore is a TOTPSecretStore backed by a BoltDB file.
+type boltTOTPSecretStore struct {
+	db *bolt.DB
+}
+
+func newBoltTOTPSecretStore(file string) (*boltTOTPSecretStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open totp secrets file %v", file)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(totpSecretsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "can't initialize totp secrets file %v", file)
+	}
+	return &boltTOTPSecretStore{db: db}, nil
+}
+
+// Close closes the BoltDB file
+func (s *boltTOTPSecretStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltTOTPSecretStore) Get(key string) (secret string, exist bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(totpSecretsBucket).Get([]byte(key))
+		secret, exist = string(v), v != nil
+		return nil
+	})
+	return secret, exist, err
+}
+
+func (s *boltTOTPSecretStore) Save(key string, secret string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(totpSecretsBucket).Put([]byte(key), []byte(secret))
+	})
+}
+
+// totpPendingClaims are the claims of the token, which is issued after the password check
+// and has to be presented together with the TOTP code.
+type totpPendingClaims struct {
+	jwt.StandardClaims
+	UserInfo model.UserInfo `json:"user"`
+	// EnrollSecret is the new secret of a user, who is not enrolled yet.
+	EnrollSecret string `json:"enroll_secret,omitempty"`
+}
+
+// totpAuthenticator does the second step of the login for the password backends.
+type totpAuthenticator struct {
+	secrets TOTPSecretStore
+	issuer  string
+	// signingKey of the pending tokens, which is different from the jwt key,
+	// so that a pending token can never be used as login token.
+	signingKey []byte
+	limiter    *rateLimiter
+
+	mutex sync.Mutex
+	// usedSteps holds the time step of the last accepted code per user, so that a code can not be replayed
+	usedSteps map[string]int64
+}
+
+func newTOTPAuthenticator(secrets TOTPSecretStore, issuer string) (*totpAuthenticator, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &totpAuthenticator{
+		secrets:    secrets,
+		issuer:     issuer,
+		signingKey: key,
+		limiter:    newRateLimiter(totpAttempts, totpPendingExpiry),
+		usedSteps:  map[string]int64{},
+	}, nil
+}
+
+func totpKey(userInfo model.UserInfo) string {
+	return userInfo.Origin + ":" + userInfo.Sub
+}
+
+// totpFormData holds the data of the TOTP step of the login form
+type totpFormData struct {
+	Token  string
+	Enroll bool
+	Secret string
+	QRCode template.URL
+}
+
+type totpResponse struct {
+	Error        string `json:"error"`
+	TOTPToken    string `json:"totp_token"`
+	EnrollSecret string `json:"enroll_secret,omitempty"`
+	OtpauthURL   string `json:"otpauth_url,omitempty"`
+}
+
+// startTOTP asks for the TOTP code after a successful password check.
+// Users without a secret get a new secret for enrollment, which is stored after the first valid code.
+func (h *Handler) startTOTP(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo) {
+	claims := totpPendingClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(totpPendingExpiry).Unix()},
+		UserInfo:       userInfo,
+	}
+
+	_, enrolled, err := h.totp.secrets.Get(totpKey(userInfo))
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	if !enrolled {
+		secret := make([]byte, totpSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		claims.EnrollSecret = totpEncoding.EncodeToString(secret)
+	}
+
+	h.respondTOTPRequired(w, r, claims, false)
+}
+
+// handleTOTP checks the TOTP code for the pending token and issues the jwt.
+func (h *Handler) handleTOTP(w http.ResponseWriter, r *http.Request, pendingToken, code string) {
+	claims := totpPendingClaims{}
+	_, err := jwt.ParseWithClaims(pendingToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected totp token algorithm %v", token.Header["alg"])
+		}
+		return h.totp.signingKey, nil
+	})
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Info("invalid totp token")
+		h.respondAuthFailure(w, r)
+		return
+	}
+
+	userInfo := claims.UserInfo
+	key := totpKey(userInfo)
+	if ok, _ := h.totp.limiter.Allow(key); !ok {
+		logging.Application(r.Header).WithField("username", userInfo.Sub).Warn("too many totp attempts")
+		h.respondAuthFailure(w, r)
+		return
+	}
+
+	secret := claims.EnrollSecret
+	if secret == "" {
+		var enrolled bool
+		secret, enrolled, err = h.totp.secrets.Get(key)
+		if err == nil && !enrolled {
+			err = fmt.Errorf("no totp secret for %v", key)
+		}
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+	}
+
+	if !h.totp.verify(key, secret, code, time.Now()) {
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).Info("failed totp authentication")
+		h.auditEvent(r, audit.LoginFailure, userInfo.Sub, userInfo.Origin, "invalid totp code")
+		h.respondTOTPRequired(w, r, claims, true)
+		return
+	}
+
+	if claims.EnrollSecret != "" {
+		if err := h.totp.secrets.Save(key, claims.EnrollSecret); err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("enrolled totp")
+	}
+
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).Info("successfully authenticated")
+	h.completeLogin(w, r, userInfo, userInfo.Origin)
+}
+
+// respondTOTPRequired shows the TOTP step of the login form for html clients.
+// All other clients get status 401 with the pending token, which has to be posted together with the code.
+func (h *Handler) respondTOTPRequired(w http.ResponseWriter, r *http.Request, claims totpPendingClaims, failure bool) {
+	pendingToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.totp.signingKey)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	var otpauthURL string
+	if claims.EnrollSecret != "" {
+		otpauthURL = h.totp.otpauthURL(claims.UserInfo.Sub, claims.EnrollSecret)
+	}
+
+	if wantHTML(r) {
+		data := &totpFormData{
+			Token:  pendingToken,
+			Enroll: claims.EnrollSecret != "",
+			Secret: claims.EnrollSecret,
+		}
+		if data.Enroll {
+			png, err := qrcode.Encode(otpauthURL, qrcode.Medium, 256)
+			if err != nil {
+				logging.Application(r.Header).WithError(err).Error()
+				h.respondError(w, r)
+				return
+			}
+			data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		}
+		if failure {
+			w.Header().Set("Content-Type", contentTypeHTML)
+			w.WriteHeader(403)
+		}
+		writeLoginForm(w,
+			loginFormData{
+				Lang:      language(r),
+				CSRFToken: csrfFormToken(r),
+				Failure:   failure,
+				Config:    h.config,
+				UserInfo:  claims.UserInfo,
+				TOTP:      data,
+			})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(401)
+	json.NewEncoder(w).Encode(totpResponse{
+		Error:        errorTOTPRequired,
+		TOTPToken:    pendingToken,
+		EnrollSecret: claims.EnrollSecret,
+		OtpauthURL:   otpauthURL,
+	}) // ignore error of encoding
+}
+
+// verify checks the code against the codes of the current time step and the adjacent ones.
+// A code is only accepted once.
+func (a *totpAuthenticator) verify(key, secret, code string, now time.Time) bool {
+	secretBytes, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= a.usedSteps[key] {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secretBytes, step)), []byte(code)) == 1 {
+			a.usedSteps[key] = step
+			return true
+		}
+	}
+	return false
+}
+
+// otpauthURL returns the provisioning uri for authenticator apps
+func (a *totpAuthenticator) otpauthURL(username, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", a.issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%v", totpDigits))
+	v.Set("period", fmt.Sprintf("%v", totpPeriod))
+	label := url.PathEscape(a.issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// totpCode calculates the code for the time step, as defined in RFC 6238 and RFC 4226.
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// getTOTPCredentials returns the pending token and the code of the TOTP step.
+func getTOTPCredentials(r *http.Request) (string, string) {
+	if r.Header.Get("Content-Type") == contentTypeJSON {
+		m := map[string]string{}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", ""
+		}
+		restoreBody(r, body)
+		json.Unmarshal(body, &m) // an invalid body was already rejected by getCredentials
+		return m["totp_token"], m["totp"]
+	}
+	return r.PostForm.Get("totp_token"), strings.TrimSpace(r.PostForm.Get("totp"))
+}
@@ -0,0 +1,141 @@
+package login
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors of RFC 6238, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	Equal(t, "287082", totpCode(secret, 59/totpPeriod))
+	Equal(t, "081804", totpCode(secret, 1111111109/totpPeriod))
+	Equal(t, "005924", totpCode(secret, 1234567890/totpPeriod))
+}
+
+func TestTOTPAuthenticator_Verify(t *testing.T) {
+	a, err := newTOTPAuthenticator(newMemoryTOTPSecretStore(), "loginsrv")
+	NoError(t, err)
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	False(t, a.verify("bob", secret, "000000", now))
+	False(t, a.verify("bob", secret, "81804", now))
+
+	// a code of the previous time step is accepted because of clock drift
+	True(t, a.verify("bob", secret, totpCode([]byte("12345678901234567890"), now.Unix()/totpPeriod-1), now))
+	True(t, a.verify("bob", secret, "081804", now))
+
+	// a code can not be used twice
+	False(t, a.verify("bob", secret, "081804", now))
+}
+
+func TestHandler_TOTP_EnrollAndLogin(t *testing.T) {
+	h := testHandler()
+	secrets := newMemoryTOTPSecretStore()
+	h.totp, _ = newTOTPAuthenticator(secrets, "loginsrv")
+
+	// the password check requires the totp step
+	response := postTOTPLogin(t, h, `{"username": "bob", "password": "secret"}`, 401)
+	NotEmpty(t, response.TOTPToken)
+	NotEmpty(t, response.EnrollSecret)
+	Contains(t, response.OtpauthURL, "otpauth://totp/loginsrv:bob?")
+
+	// a wrong code keeps the user unenrolled
+	postTOTPLogin(t, h, `{"totp_token": "`+response.TOTPToken+`", "totp": "000000"}`, 401)
+	_, enrolled, _ := secrets.Get("simple:bob")
+	False(t, enrolled)
+
+	// the valid code issues the token and enrolls the user
+	postTOTPLogin(t, h, `{"totp_token": "`+response.TOTPToken+`", "totp": "`+currentTOTPCode(response.EnrollSecret, 0)+`"}`, 200)
+	secret, enrolled, _ := secrets.Get("simple:bob")
+	True(t, enrolled)
+	Equal(t, response.EnrollSecret, secret)
+
+	// the next login uses the stored secret
+	response = postTOTPLogin(t, h, `{"username": "bob", "password": "secret"}`, 401)
+	Empty(t, response.EnrollSecret)
+	postTOTPLogin(t, h, `{"totp_token": "`+response.TOTPToken+`", "totp": "`+currentTOTPCode(secret, 1)+`"}`, 200)
+
+	// wrong passwords do not reach the totp step
+	postTOTPLogin(t, h, `{"username": "bob", "password": "wrong"}`, 403)
+	// invalid pending tokens are rejected
+	postTOTPLogin(t, h, `{"totp_token": "foo", "totp": "123456"}`, 403)
+}
+
+func TestHandler_TOTP_LoginForm(t *testing.T) {
+	h := testHandler()
+	h.totp, _ = newTOTPAuthenticator(newMemoryTOTPSecretStore(), "loginsrv")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withCSRF(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML)))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="totp_token"`)
+	Contains(t, recorder.Body.String(), `src="data:image/png;base64,`)
+	Empty(t, recorder.Header().Get("Set-Cookie"))
+}
+
+func TestHandler_TOTP_Config(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.SecondFactor = "sms"
+	_, err := NewHandler(cfg)
+	EqualError(t, err, `unsupported second factor "sms", only "totp" is supported`)
+}
+
+func TestBoltTOTPSecretStore(t *testing.T) {
+	f, err := ioutil.TempFile("", "loginsrv_totp")
+	NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	s, err := newBoltTOTPSecretStore(f.Name())
+	NoError(t, err)
+	_, enrolled, err := s.Get("simple:bob")
+	NoError(t, err)
+	False(t, enrolled)
+
+	NoError(t, s.Save("simple:bob", "SECRET"))
+	secret, enrolled, err := s.Get("simple:bob")
+	NoError(t, err)
+	True(t, enrolled)
+	Equal(t, "SECRET", secret)
+}
+
+func postTOTPLogin(t *testing.T, h *Handler, body string, expectedCode int) totpResponse {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", body, TypeJSON, AcceptJwt))
+	Equal(t, expectedCode, recorder.Code, recorder.Body.String())
+	response := totpResponse{}
+	if expectedCode == 401 {
+		NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	}
+	return response
+}
+
+func currentTOTPCode(secret string, stepOffset int64) string {
+	b, _ := totpEncoding.DecodeString(secret)
+	return totpCode(b, time.Now().Unix()/totpPeriod+stepOffset)
+}
+
+type memoryTOTPSecretStore map[string]string
+
+func newMemoryTOTPSecretStore() memoryTOTPSecretStore {
+	return memoryTOTPSecretStore{}
+}
+
+func (s memoryTOTPSecretStore) Get(key string) (string, bool, error) {
+	secret, exist := s[key]
+	return secret, exist, nil
+}
+
+func (s memoryTOTPSecretStore) Save(key string, secret string) error {
+	s[key] = secret
+	return nil
+}
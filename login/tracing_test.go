@@ -0,0 +1,84 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/tracing"
+	. "github.com/stretchr/testify/assert"
+)
+
+type testSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+			BoolValue   bool   `json:"boolValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+}
+
+func TestHandler_Tracing(t *testing.T) {
+	var mutex sync.Mutex
+	spans := []testSpan{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []testSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}{}
+		NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		mutex.Lock()
+		defer mutex.Unlock()
+		spans = append(spans, request.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}))
+	defer collector.Close()
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	tracer, err := tracing.FromEnv("loginsrv")
+	NoError(t, err)
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(nil)
+
+	r := req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h := testHandler()
+	h.backends = []Backend{instrumentedBackend{Backend: h.backends[0], name: "simple"}}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	tracer.Shutdown()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	Equal(t, 2, len(spans))
+	backend, server := spans[0], spans[1]
+
+	Equal(t, "POST /context/login", server.Name)
+	Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceID)
+	Equal(t, "00f067aa0ba902b7", server.ParentSpanID)
+
+	Equal(t, "backend authenticate", backend.Name)
+	Equal(t, server.TraceID, backend.TraceID)
+	Equal(t, server.SpanID, backend.ParentSpanID)
+	attributes := map[string]interface{}{}
+	for _, a := range backend.Attributes {
+		if a.Value.StringValue != "" {
+			attributes[a.Key] = a.Value.StringValue
+		} else {
+			attributes[a.Key] = a.Value.BoolValue
+		}
+	}
+	Equal(t, map[string]interface{}{"loginsrv.backend": "simple", "loginsrv.authenticated": true}, attributes)
+}
@@ -0,0 +1,50 @@
+package login
+
+import (
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/metrics"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/trustedheader"
+)
+
+func newTrustedHeader(config *Config) (*trustedheader.Service, error) {
+	if len(config.TrustedHeader) == 0 {
+		return nil, nil
+	}
+	cfg, err := trustedheader.NewConfigFromOpts(config.TrustedHeader)
+	if err != nil {
+		return nil, err
+	}
+	return trustedheader.NewService(cfg)
+}
+
+// loginByHeader logs in the user of the identity headers of a trusted SSO proxy, when the login page is requested.
+// The token of the user is kept, if it is still valid. A token of another user is replaced,
+// because the user may have changed at the proxy. It returns true, if the response was written.
+func (h *Handler) loginByHeader(w http.ResponseWriter, r *http.Request, current model.UserInfo, valid bool) bool {
+	userInfo, present, err := h.trustedHeader.Authenticate(r)
+	if !present {
+		return false
+	}
+	if err != nil {
+		metrics.Login(trustedheader.ProviderName, false, nil)
+		logging.Application(r.Header).WithError(err).Warn("failed authentication")
+		h.auditEvent(r, audit.LoginFailure, "", trustedheader.ProviderName, err.Error())
+		return false
+	}
+	if valid && !h.ExpiresSoon(current) && current.Sub == userInfo.Sub {
+		return false
+	}
+
+	if !h.beforeAuth(w, r, userInfo.Sub, trustedheader.ProviderName) {
+		return true
+	}
+	metrics.Login(trustedheader.ProviderName, true, nil)
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).Info("successfully authenticated")
+	h.completeLogin(w, r, userInfo, trustedheader.ProviderName)
+	return true
+}
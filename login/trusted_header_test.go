@@ -0,0 +1,64 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func testTrustedHeaderHandler(t *testing.T) *Handler {
+	config := testConfig()
+	config.Backends = Options{}
+	config.TrustedHeader = map[string]string{"trusted_proxies": "192.0.2.1", "email": "X-Forwarded-Email"}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	return h
+}
+
+func TestHandler_TrustedHeader_Login(t *testing.T) {
+	h := testTrustedHeaderHandler(t)
+
+	r := req("GET", "/context/login", "", AcceptHTML, "X-Forwarded-User: bob", "X-Forwarded-Email: bob@example.com")
+	r.RemoteAddr = "192.0.2.1:4711"
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+
+	token := cookieByName(recorder, "jwt_token").Value
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, "bob@example.com", claims["email"])
+	Equal(t, "trustedheader", claims["origin"])
+
+	// the token of the same user is kept
+	r = req("GET", "/context/login", "", AcceptHTML, "X-Forwarded-User: bob", "Cookie: jwt_token="+token)
+	r.RemoteAddr = "192.0.2.1:4711"
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	Nil(t, cookieByName(recorder, "jwt_token"))
+
+	// the token of another user is replaced
+	r = req("GET", "/context/login", "", AcceptHTML, "X-Forwarded-User: alice", "Cookie: jwt_token="+token)
+	r.RemoteAddr = "192.0.2.1:4711"
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 303, recorder.Code)
+	claims, err = tokenAsMap(cookieByName(recorder, "jwt_token").Value)
+	NoError(t, err)
+	Equal(t, "alice", claims["sub"])
+}
+
+func TestHandler_TrustedHeader_Untrusted(t *testing.T) {
+	h := testTrustedHeaderHandler(t)
+
+	r := req("GET", "/context/login", "", AcceptHTML, "X-Forwarded-User: bob")
+	r.RemoteAddr = "192.0.2.2:4711"
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	Nil(t, cookieByName(recorder, "jwt_token"))
+}
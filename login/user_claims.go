@@ -1,6 +1,7 @@
 package login
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/afdecastro879/loginsrv/model"
@@ -33,7 +34,14 @@ type UserClaims interface {
 
 func NewUserClaims(config *Config) (UserClaims, error) {
 	if config.UserEndpoint != "" {
-		return newUserClaimsProvider(config.UserEndpoint, config.UserEndpointToken, config.UserEndpointTimeout)
+		switch config.UserEndpointMethod {
+		case "", http.MethodGet:
+			return newUserClaimsProvider(config.UserEndpoint, config.UserEndpointToken, config.UserEndpointTimeout)
+		case http.MethodPost:
+			return newUserClaimsWebhook(config.UserEndpoint, config.UserEndpointToken, config.UserEndpointTimeout)
+		default:
+			return nil, errors.Errorf("unsupported user endpoint method %q, expected GET or POST", config.UserEndpointMethod)
+		}
 	}
 	return newUserClaimsFile(config.UserFile)
 }
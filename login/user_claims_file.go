@@ -2,7 +2,14 @@ package login
 
 import (
 	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/afdecastro879/loginsrv/logging"
 	"github.com/afdecastro879/loginsrv/model"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
@@ -16,11 +23,62 @@ type userFileEntry struct {
 	Domain string                 `yaml:"domain"`
 	Groups []string               `yaml:"groups"`
 	Claims map[string]interface{} `yaml:"claims"`
+
+	// regexps holds the compiled regular expressions of the selectors
+	regexps map[string]*regexp.Regexp
+}
+
+// compile checks the patterns of the selectors and compiles the regular expressions.
+// A selector in slashes, e.g. /.*@example\.com/, is a regular expression, which has to match the whole value.
+// A selector with *, ? or [ is a glob pattern. All other selectors have to be equal to the value.
+func (entry *userFileEntry) compile() error {
+	entry.regexps = map[string]*regexp.Regexp{}
+	selectors := append([]string{entry.Sub, entry.Origin, entry.Email, entry.Domain}, entry.Groups...)
+	for _, selector := range selectors {
+		switch {
+		case isRegexSelector(selector):
+			re, err := regexp.Compile("^(?:" + selector[1:len(selector)-1] + ")$")
+			if err != nil {
+				return errors.Wrapf(err, "invalid regular expression %v", selector)
+			}
+			entry.regexps[selector] = re
+		case isGlobSelector(selector):
+			if _, err := path.Match(selector, ""); err != nil {
+				return errors.Wrapf(err, "invalid glob pattern %v", selector)
+			}
+		}
+	}
+	return nil
+}
+
+// matches returns true, if the value matches the selector of the entry
+func (entry userFileEntry) matches(selector, value string) bool {
+	switch {
+	case isRegexSelector(selector):
+		re, compiled := entry.regexps[selector]
+		return compiled && re.MatchString(value)
+	case isGlobSelector(selector):
+		matched, _ := path.Match(selector, value)
+		return matched
+	default:
+		return selector == value
+	}
+}
+
+func isRegexSelector(selector string) bool {
+	return len(selector) >= 2 && strings.HasPrefix(selector, "/") && strings.HasSuffix(selector, "/")
+}
+
+func isGlobSelector(selector string) bool {
+	return strings.ContainsAny(selector, "*?[")
 }
 
 type userClaimsFile struct {
 	userFile        string
 	userFileEntries []userFileEntry
+	// modTime of the parsed user file, used to reload the file on changes
+	modTime time.Time
+	mutex   sync.RWMutex
 }
 
 func newUserClaimsFile(file string) (*userClaimsFile, error) {
@@ -36,21 +94,72 @@ func (c *userClaimsFile) parseUserFile() error {
 	if c.userFile == "" {
 		return nil
 	}
+	fileInfo, err := os.Stat(c.userFile)
+	if err != nil {
+		return errors.Wrapf(err, "can't read user file %v", c.userFile)
+	}
 	b, err := ioutil.ReadFile(c.userFile)
 	if err != nil {
 		return errors.Wrapf(err, "can't read user file %v", c.userFile)
 	}
 
-	err = yaml.Unmarshal(b, &c.userFileEntries)
+	entries := []userFileEntry{}
+	err = yaml.Unmarshal(b, &entries)
 	if err != nil {
 		return errors.Wrapf(err, "can't parse user file %v", c.userFile)
 	}
+	for i := range entries {
+		if err := entries[i].compile(); err != nil {
+			return errors.Wrapf(err, "can't parse user file %v", c.userFile)
+		}
+	}
+
+	// the entries are replaced at once, so a claims lookup never sees a partially parsed file
+	c.mutex.Lock()
+	c.userFileEntries = entries
+	c.modTime = fileInfo.ModTime()
+	c.mutex.Unlock()
 	return nil
 }
 
+// reloadIfChanged parses the user file again, if it was modified since the last parse.
+// If the file can't be parsed, e.g. while it is written, the previous entries are kept until the next change.
+func (c *userClaimsFile) reloadIfChanged() {
+	if c.userFile == "" {
+		return
+	}
+	fileInfo, err := os.Stat(c.userFile)
+	if err != nil {
+		// keep the current entries, if the file was removed
+		return
+	}
+
+	c.mutex.RLock()
+	changed := !fileInfo.ModTime().Equal(c.modTime)
+	c.mutex.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := c.parseUserFile(); err != nil {
+		logging.Logger.WithError(err).Warn("can't reload user file, keeping the previous entries")
+		c.mutex.Lock()
+		c.modTime = fileInfo.ModTime()
+		c.mutex.Unlock()
+		return
+	}
+	logging.Logger.Infof("reloaded user file %v", c.userFile)
+}
+
 // Claims returns a map of the token claims for a user.
 func (c *userClaimsFile) Claims(userInfo model.UserInfo) (jwt.Claims, error) {
-	for _, entry := range c.userFileEntries {
+	c.reloadIfChanged()
+
+	c.mutex.RLock()
+	entries := c.userFileEntries
+	c.mutex.RUnlock()
+
+	for _, entry := range entries {
 		if match(userInfo, entry) {
 			claims := customClaims(userInfo.AsMap())
 			claims.merge(entry.Claims)
@@ -61,23 +170,23 @@ func (c *userClaimsFile) Claims(userInfo model.UserInfo) (jwt.Claims, error) {
 }
 
 func match(userInfo model.UserInfo, entry userFileEntry) bool {
-	if entry.Sub != "" && entry.Sub != userInfo.Sub {
+	if entry.Sub != "" && !entry.matches(entry.Sub, userInfo.Sub) {
 		return false
 	}
-	if entry.Domain != "" && entry.Domain != userInfo.Domain {
+	if entry.Domain != "" && !entry.matches(entry.Domain, userInfo.Domain) {
 		return false
 	}
-	if entry.Email != "" && entry.Email != userInfo.Email {
+	if entry.Email != "" && !entry.matches(entry.Email, userInfo.Email) {
 		return false
 	}
-	if entry.Origin != "" && entry.Origin != userInfo.Origin {
+	if entry.Origin != "" && !entry.matches(entry.Origin, userInfo.Origin) {
 		return false
 	}
 	if len(entry.Groups) > 0 {
 		eligible := false
 		for _, entryGroup := range entry.Groups {
 			for _, userGroup := range userInfo.Groups {
-				if entryGroup == userGroup {
+				if entry.matches(entryGroup, userGroup) {
 					eligible = true
 					break
 				}
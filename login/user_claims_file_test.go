@@ -2,10 +2,14 @@ package login
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/oauth2"
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -136,6 +140,154 @@ func Test_userClaimsFile_NoMatch(t *testing.T) {
 	Equal(t, model.UserInfo{Sub: "bob", Groups: []string{"group"}}, claims)
 }
 
+func Test_userClaimsFile_Patterns(t *testing.T) {
+	userFile, cleanup := createClaimsFile(`
+- email: /.*@(example|example-corp)\.com/
+  origin: google
+  claims:
+    role: employee
+
+- sub: "admin-*"
+  claims:
+    role: admin
+
+- groups:
+    - "example/*"
+  claims:
+    role: developer
+
+- origin: "/git(hub|lab)/"
+  claims:
+    role: external
+`)
+	defer cleanup()
+
+	c, err := NewUserClaims(&Config{UserFile: userFile})
+	NoError(t, err)
+
+	claims, _ := c.Claims(model.UserInfo{Sub: "bob", Email: "bob@example-corp.com", Origin: "google"})
+	Equal(t, "employee", claims.(customClaims)["role"])
+
+	// the regular expression has to match the whole value
+	claims, _ = c.Claims(model.UserInfo{Sub: "bob", Email: "bob@example.com.evil.org", Origin: "google"})
+	Equal(t, model.UserInfo{Sub: "bob", Email: "bob@example.com.evil.org", Origin: "google"}, claims)
+
+	claims, _ = c.Claims(model.UserInfo{Sub: "admin-bob", Origin: "htpasswd"})
+	Equal(t, "admin", claims.(customClaims)["role"])
+
+	claims, _ = c.Claims(model.UserInfo{Sub: "bob", Origin: "gitlab", Groups: []string{"other", "example/backend"}})
+	Equal(t, "developer", claims.(customClaims)["role"])
+
+	claims, _ = c.Claims(model.UserInfo{Sub: "bob", Origin: "github"})
+	Equal(t, "external", claims.(customClaims)["role"])
+
+	claims, _ = c.Claims(model.UserInfo{Sub: "bob", Origin: "gitlab", Groups: []string{"example/backend/sub"}})
+	Equal(t, "external", claims.(customClaims)["role"])
+}
+
+func Test_userClaimsFile_InvalidPatterns(t *testing.T) {
+	for _, content := range []string{
+		"- sub: /(bob/\n  claims:\n    role: admin",
+		"- email: \"[a-\"\n  claims:\n    role: admin",
+	} {
+		userFile, cleanup := createClaimsFile(content)
+		_, err := newUserClaimsFile(userFile)
+		Error(t, err)
+		cleanup()
+	}
+}
+
+type groupsTestBackend []string
+
+func (groups groupsTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return true, model.UserInfo{Sub: username, Origin: "ldap", Groups: groups}, nil
+}
+
+func TestHandler_UserFileGroups(t *testing.T) {
+	userFile, cleanup := createClaimsFile(`
+- groups:
+    - admins
+  claims:
+    role: admin
+
+- origin: github
+  groups:
+    - "example/*"
+  claims:
+    role: developer
+`)
+	defer cleanup()
+
+	userClaims, err := NewUserClaims(&Config{UserFile: userFile})
+	NoError(t, err)
+
+	// groups of a login backend, e.g. ldap
+	h := testHandler()
+	h.userClaims = userClaims.Claims
+	h.backends = []Backend{groupsTestBackend{"users", "admins"}}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "admin", claims["role"])
+	Equal(t, []interface{}{"users", "admins"}, claims["groups"])
+
+	// groups of an oauth provider, e.g. github teams
+	h.oauth = &oauth2ManagerMock{
+		_GetConfigFromRequest: func(r *http.Request) (oauth2.Config, error) {
+			return oauth2.Config{}, nil
+		},
+		_Handle: func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+			return false, true, model.UserInfo{Sub: "octocat", Origin: "github", Verified: true, Groups: []string{"example/backend"}}, nil
+		},
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/github", "", AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "developer", claims["role"])
+}
+
+func Test_userClaimsFile_Reload(t *testing.T) {
+	userFile, cleanup := createClaimsFile(`
+- sub: bob
+  claims:
+    role: user
+`)
+	defer cleanup()
+
+	c, err := NewUserClaims(&Config{UserFile: userFile})
+	NoError(t, err)
+
+	claims, _ := c.Claims(model.UserInfo{Sub: "bob"})
+	Equal(t, customClaims{"sub": "bob", "role": "user"}, claims)
+
+	NoError(t, ioutil.WriteFile(userFile, []byte(`
+- sub: bob
+  claims:
+    role: admin
+`), 0644))
+	NoError(t, os.Chtimes(userFile, time.Now(), time.Now().Add(time.Minute)))
+
+	claims, _ = c.Claims(model.UserInfo{Sub: "bob"})
+	Equal(t, customClaims{"sub": "bob", "role": "admin"}, claims)
+
+	// an invalid file keeps the previous entries
+	NoError(t, ioutil.WriteFile(userFile, []byte(invalidClaimsExample), 0644))
+	NoError(t, os.Chtimes(userFile, time.Now(), time.Now().Add(2*time.Minute)))
+
+	claims, _ = c.Claims(model.UserInfo{Sub: "bob"})
+	Equal(t, customClaims{"sub": "bob", "role": "admin"}, claims)
+
+	// a removed file keeps the previous entries
+	os.Remove(userFile)
+	claims, _ = c.Claims(model.UserInfo{Sub: "bob"})
+	Equal(t, customClaims{"sub": "bob", "role": "admin"}, claims)
+}
+
 func createClaimsFile(claims string) (string, func()) {
 	f, _ := ioutil.TempFile("", "")
 	f.WriteString(claims)
@@ -33,11 +33,17 @@ func newUserClaimsProvider(url, auth string, timeout time.Duration) (*userClaims
 func (provider *userClaimsProvider) Claims(userInfo model.UserInfo) (jwt.Claims, error) {
 	claimsURL := provider.buildURL(userInfo)
 	req, _ := http.NewRequest(http.MethodGet, claimsURL, nil)
-	if provider.auth != "" {
-		req.Header.Add("Authorization", "Bearer "+provider.auth)
+	return requestClaims(&provider.httpClient, req, provider.auth, userInfo)
+}
+
+// requestClaims does the request to the claims endpoint and merges the returned claims into the claims of the user.
+// If the endpoint responds with 404, the token contains the claims of the user only.
+func requestClaims(httpClient *http.Client, req *http.Request, auth string, userInfo model.UserInfo) (jwt.Claims, error) {
+	if auth != "" {
+		req.Header.Add("Authorization", "Bearer "+auth)
 	}
 
-	resp, err := provider.httpClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -40,6 +40,24 @@ func Test_NewUserClaims_Provider(t *testing.T) {
 	True(t, ok)
 }
 
+func Test_NewUserClaims_Webhook(t *testing.T) {
+	config := &Config{
+		UserEndpoint:        "https://test.io/something",
+		UserEndpointMethod:  "POST",
+		UserEndpointTimeout: time.Minute,
+	}
+
+	claims, err := NewUserClaims(config)
+
+	require.NoError(t, err)
+	_, ok := claims.(*userClaimsWebhook)
+	True(t, ok)
+
+	config.UserEndpointMethod = "PUT"
+	_, err = NewUserClaims(config)
+	Error(t, err)
+}
+
 func Test_customClaims_Valid(t *testing.T) {
 	cc := customClaims{
 		"exp": time.Now().Unix() + 3600,
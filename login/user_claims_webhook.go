@@ -0,0 +1,44 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// userClaimsWebhook posts the claims of the authenticated user as JSON to the endpoint
+// and merges the JSON response into the claims of the token.
+type userClaimsWebhook struct {
+	url        string
+	auth       string
+	httpClient http.Client
+}
+
+func newUserClaimsWebhook(url, auth string, timeout time.Duration) (*userClaimsWebhook, error) {
+	if err := validateURL(url); err != nil {
+		return nil, err
+	}
+
+	return &userClaimsWebhook{
+		url:        url,
+		auth:       auth,
+		httpClient: http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (webhook *userClaimsWebhook) Claims(userInfo model.UserInfo) (jwt.Claims, error) {
+	body, err := json.Marshal(userInfo.AsMap())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	return requestClaims(&webhook.httpClient, req, webhook.auth, userInfo)
+}
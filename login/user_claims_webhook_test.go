@@ -0,0 +1,81 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_userClaimsWebhook_Claims(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer "+token, r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+		w.Write([]byte(`{"roles": ["admin"], "domain": "other.example.com"}`))
+	}))
+	defer server.Close()
+
+	webhook, err := newUserClaimsWebhook(server.URL, token, time.Minute)
+	require.NoError(t, err)
+
+	claims, err := webhook.Claims(model.UserInfo{
+		Sub:    "test@example.com",
+		Origin: "origin",
+		Domain: "example.com",
+		Groups: []string{"users"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		map[string]interface{}{
+			"sub":    "test@example.com",
+			"origin": "origin",
+			"domain": "example.com",
+			"groups": []interface{}{"users"},
+		},
+		received,
+	)
+	assert.Equal(t,
+		customClaims{
+			"sub":    "test@example.com",
+			"origin": "origin",
+			"domain": "other.example.com",
+			"groups": []string{"users"},
+			"roles":  []interface{}{"admin"},
+		},
+		claims,
+	)
+}
+
+func Test_userClaimsWebhook_Claims_NotFound(t *testing.T) {
+	mock := createMockServer(mockResponse{url: endpointPath, status: http.StatusNotFound})
+	defer mock.Close()
+
+	webhook, err := newUserClaimsWebhook(mock.URL+endpointPath, "", time.Minute)
+	require.NoError(t, err)
+
+	claims, err := webhook.Claims(aUserInfo)
+	require.NoError(t, err)
+	assert.Equal(t, customClaims(aUserInfo.AsMap()), claims)
+	assert.Equal(t, "", mock.requests[0].Header.Get("Authorization"))
+}
+
+func Test_userClaimsWebhook_Claims_Error(t *testing.T) {
+	mock := createMockServer(mockResponse{url: endpointPath, status: http.StatusInternalServerError})
+	defer mock.Close()
+
+	webhook, err := newUserClaimsWebhook(mock.URL+endpointPath, token, time.Minute)
+	require.NoError(t, err)
+
+	_, err = webhook.Claims(aUserInfo)
+	assert.Error(t, err)
+}
@@ -0,0 +1,173 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const verifyPath = "/verify"
+
+// The modes of the verify endpoint
+const (
+	// VerifyModeForwardAuth responds with the url of the login form, e.g. for the ForwardAuth middleware of traefik
+	VerifyModeForwardAuth = "forward-auth"
+	// VerifyModeAuthRequest responds only with the status code, as required by auth_request of nginx
+	VerifyModeAuthRequest = "auth-request"
+)
+
+// defaultVerifyHeaders are the response headers without a verify headers file
+var defaultVerifyHeaders = []verifyHeader{
+	{header: "X-Auth-User", claim: "sub"},
+	{header: "X-Auth-Email", claim: "email"},
+}
+
+// verifyHeader is a response header with the value of a claim
+type verifyHeader struct {
+	header string
+	claim  string
+}
+
+// newVerifyHeaders reads the mapping of the response headers to the claims from the verify headers file.
+// It returns nil without a file, so the default headers are used.
+func newVerifyHeaders(config *Config) ([]verifyHeader, error) {
+	switch config.VerifyMode {
+	case "", VerifyModeForwardAuth, VerifyModeAuthRequest:
+	default:
+		return nil, fmt.Errorf("unsupported verify mode %q, expected %q or %q", config.VerifyMode, VerifyModeForwardAuth, VerifyModeAuthRequest)
+	}
+	if config.VerifyHeadersFile == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(config.VerifyHeadersFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read verify headers file %v", config.VerifyHeadersFile)
+	}
+	mapping := map[string]string{}
+	if err := yaml.Unmarshal(b, &mapping); err != nil {
+		return nil, errors.Wrapf(err, "can't parse verify headers file %v", config.VerifyHeadersFile)
+	}
+
+	headers := make([]verifyHeader, 0, len(mapping))
+	for header, claim := range mapping {
+		if claim == "" {
+			return nil, errors.Errorf("missing claim of header %q in verify headers file %v", header, config.VerifyHeadersFile)
+		}
+		headers = append(headers, verifyHeader{header: http.CanonicalHeaderKey(header), claim: claim})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].header < headers[j].header })
+	return headers, nil
+}
+
+// handleVerify is the endpoint for the forward authentication of reverse proxies,
+// like the ForwardAuth middleware of traefik or auth_request of nginx.
+// A valid token is answered with 200 and the claims in the headers of the verify headers,
+// by default the user in X-Auth-User and X-Auth-Email.
+// Otherwise the response is 401, in the forward-auth mode with the url of the login form in the Location header.
+func (h *Handler) handleVerify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if claims, valid := h.verifyRequest(r); valid {
+		headers := h.verifyHeaders
+		if headers == nil {
+			headers = defaultVerifyHeaders
+		}
+		for _, header := range headers {
+			if value, exist := claims[header.claim]; exist {
+				w.Header().Set(header.header, headerValue(value))
+			}
+		}
+		w.WriteHeader(200)
+		return
+	}
+
+	if h.config.VerifyMode == VerifyModeAuthRequest {
+		w.WriteHeader(401)
+		return
+	}
+
+	loginURL := h.verifyLoginURL(r)
+	w.Header().Set("Location", loginURL)
+	if wantHTML(r) {
+		// browsers don't follow the location of a 401, but traefik passes the body to the browser
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(401)
+		fmt.Fprintf(w, `<!DOCTYPE html><html><head><meta http-equiv="refresh" content="0; url=%v"></head>`+
+			`<body><a href="%v">Login</a></body></html>`, html.EscapeString(loginURL), html.EscapeString(loginURL))
+		return
+	}
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(401)
+	fmt.Fprint(w, "Unauthorized")
+}
+
+// verifyRequest checks the token of the cookie or the bearer authorization header and returns its claims.
+func (h *Handler) verifyRequest(r *http.Request) (map[string]interface{}, bool) {
+	tokenString := h.tokenFromRequest(r)
+	if tokenString == "" {
+		return nil, false
+	}
+	if h.sessions != nil {
+		userInfo, valid := h.getSession(r, tokenString)
+		return userInfo.AsMap(), valid
+	}
+	if _, valid := h.parseToken(r, tokenString); !valid {
+		return nil, false
+	}
+
+	// the token is parsed again for the custom claims, which are not part of the user info
+	claims := jwt.MapClaims{}
+	if err := h.parseWithClaims(r, tokenString, claims); err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		return nil, false
+	}
+	return claims, true
+}
+
+// headerValue formats the value of a claim: lists are comma separated, objects as json.
+func headerValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			values = append(values, headerValue(e))
+		}
+		return strings.Join(values, ",")
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// verifyLoginURL returns the url of the login form on the host of the proxy.
+// The original uri is passed as redirect query parameter, so the user gets back after the login.
+// Traefik sends it in X-Forwarded-Uri, for nginx it has to be set as X-Original-URI.
+func (h *Handler) verifyLoginURL(r *http.Request) string {
+	loginURL := externalURL(r, h.config.LoginPath)
+	originalURI := r.Header.Get("X-Forwarded-Uri")
+	if originalURI == "" {
+		originalURI = r.Header.Get("X-Original-URI")
+	}
+	if originalURI == "" || !h.config.Redirect {
+		return loginURL
+	}
+	return loginURL + "?" + url.Values{h.config.RedirectQueryParameter: {originalURI}}.Encode()
+}
@@ -0,0 +1,119 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Verify(t *testing.T) {
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "marvin", Email: "marvin@example.com", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	// cookie
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/verify", "", "Cookie: jwt_token="+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "marvin", recorder.Header().Get("X-Auth-User"))
+	Equal(t, "marvin@example.com", recorder.Header().Get("X-Auth-Email"))
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+
+	// bearer authorization header
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/verify", "", "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "marvin", recorder.Header().Get("X-Auth-User"))
+}
+
+func TestHandler_Verify_Unauthorized(t *testing.T) {
+	h := testHandler()
+	expiredToken, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Unix() - 1})
+	NoError(t, err)
+
+	// without token
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "http://example.com/context/login/verify", ""))
+	Equal(t, 401, recorder.Code)
+	Equal(t, "http://example.com/context/login", recorder.Header().Get("Location"))
+	Equal(t, "", recorder.Header().Get("X-Auth-User"))
+
+	// the original uri of traefik is passed to the login
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "http://auth.internal/context/login/verify", "",
+		"Cookie: jwt_token="+expiredToken,
+		"X-Forwarded-Proto: https",
+		"X-Forwarded-Host: app.example.com",
+		"X-Forwarded-Uri: /private?page=1",
+		AcceptHTML))
+	Equal(t, 401, recorder.Code)
+	loginURL := "https://app.example.com/context/login?backTo=%2Fprivate%3Fpage%3D1"
+	Equal(t, loginURL, recorder.Header().Get("Location"))
+	Contains(t, recorder.Body.String(), `content="0; url=`+loginURL+`"`)
+
+	// nginx
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "http://app.example.com/context/login/verify", "", "X-Original-URI: /private"))
+	Equal(t, 401, recorder.Code)
+	Equal(t, "http://app.example.com/context/login?backTo=%2Fprivate", recorder.Header().Get("Location"))
+	Equal(t, "Unauthorized", recorder.Body.String())
+}
+
+func TestHandler_Verify_AuthRequest(t *testing.T) {
+	headersFile := writeConfigFile(t, "headers.yml", "x-user: sub\nX-Groups: groups\nX-Tenant: tenant\nX-Missing: phone\n")
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.JwtStaticClaims = map[string]string{"tenant": "example"}
+	config.VerifyMode = VerifyModeAuthRequest
+	config.VerifyHeadersFile = headersFile
+	h, err := NewHandler(config)
+	NoError(t, err)
+	defer h.Close()
+
+	token, err := h.createToken(model.UserInfo{Sub: "marvin", Groups: []string{"a", "b"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/verify", "", "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "marvin", recorder.Header().Get("X-User"))
+	Equal(t, "a,b", recorder.Header().Get("X-Groups"))
+	Equal(t, "example", recorder.Header().Get("X-Tenant"))
+	_, exist := recorder.Header()["X-Missing"]
+	False(t, exist)
+	Equal(t, "", recorder.Header().Get("X-Auth-User"))
+
+	// no redirect for nginx
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/verify", "", "X-Original-URI: /private", AcceptHTML))
+	Equal(t, 401, recorder.Code)
+	Equal(t, "", recorder.Header().Get("Location"))
+	Equal(t, "", recorder.Body.String())
+}
+
+func TestNewVerifyHeaders_Errors(t *testing.T) {
+	config := testConfig()
+	config.VerifyMode = "redirect"
+	_, err := newVerifyHeaders(config)
+	Error(t, err)
+
+	config = testConfig()
+	config.VerifyHeadersFile = writeConfigFile(t, "headers.yml", "X-User: \n")
+	_, err = newVerifyHeaders(config)
+	Error(t, err)
+
+	config.VerifyHeadersFile = "/does/not/exist"
+	_, err = newVerifyHeaders(config)
+	Error(t, err)
+}
+
+func TestHeaderValue(t *testing.T) {
+	Equal(t, "bob", headerValue("bob"))
+	Equal(t, "1600000000", headerValue(float64(1600000000)))
+	Equal(t, "a,b", headerValue([]interface{}{"a", "b"}))
+	Equal(t, "true", headerValue(true))
+	Equal(t, `{"a":"b"}`, headerValue(map[string]interface{}{"a": "b"}))
+}
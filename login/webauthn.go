@@ -0,0 +1,144 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/audit"
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/metrics"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/webauthn"
+)
+
+const webauthnPath = "/webauthn/"
+
+// maxWebAuthnBody limits the size of the credential responses
+const maxWebAuthnBody = 64 * 1024
+
+func newWebAuthn(config *Config) (*webauthn.RelyingParty, error) {
+	if config.WebAuthnRPID == "" {
+		return nil, nil
+	}
+	origin := config.WebAuthnOrigin
+	if origin == "" {
+		origin = "https://" + config.WebAuthnRPID
+	}
+	store, err := webauthn.NewBoltCredentialStore(config.WebAuthnCredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	return webauthn.NewRelyingParty(webauthn.Config{
+		RPID:   config.WebAuthnRPID,
+		RPName: config.LoginPageTitle,
+		Origin: origin,
+	}, store), nil
+}
+
+// handleWebAuthn serves the registration and the login with passkeys:
+// register/begin and register/finish need a valid token, login/begin and login/finish issue a new token.
+func (h *Handler) handleWebAuthn(w http.ResponseWriter, r *http.Request) {
+	if h.webauthn == nil {
+		h.respondNotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, h.config.LoginPath+webauthnPath) {
+	case "register/begin":
+		userInfo, valid := h.GetToken(r)
+		if !valid {
+			h.respondWebAuthnFailure(w, r, 403, "Not logged in")
+			return
+		}
+		options, err := h.webauthn.BeginRegistration(credentialUserInfo(userInfo))
+		h.respondWebAuthnJSON(w, r, options, err)
+	case "register/finish":
+		userInfo, valid := h.GetToken(r)
+		if !valid {
+			h.respondWebAuthnFailure(w, r, 403, "Not logged in")
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebAuthnBody))
+		if err != nil {
+			h.respondWebAuthnFailure(w, r, 400, "Bad Request: Expected a json body")
+			return
+		}
+		cred, err := h.webauthn.FinishRegistration(body)
+		if err == nil && (cred.UserInfo.Sub != userInfo.Sub || cred.UserInfo.Origin != userInfo.Origin) {
+			err = &webauthn.VerificationError{Reason: "the registration was started by another user"}
+		}
+		if err == nil {
+			logging.Application(r.Header).WithField("username", userInfo.Sub).Info("registered webauthn credential")
+		}
+		h.respondWebAuthnJSON(w, r, map[string]interface{}{"registered": true}, err)
+	case "login/begin":
+		options, err := h.webauthn.BeginLogin()
+		h.respondWebAuthnJSON(w, r, options, err)
+	case "login/finish":
+		if !h.beforeAuth(w, r, "", "webauthn") {
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebAuthnBody))
+		if err != nil {
+			h.respondWebAuthnFailure(w, r, 400, "Bad Request: Expected a json body")
+			return
+		}
+		userInfo, err := h.webauthn.FinishLogin(body)
+		if _, isVerificationError := err.(*webauthn.VerificationError); isVerificationError {
+			metrics.Login("webauthn", false, nil)
+		} else {
+			metrics.Login("webauthn", err == nil, err)
+		}
+		if err != nil {
+			h.auditEvent(r, audit.LoginFailure, "", "webauthn", err.Error())
+			h.respondWebAuthnJSON(w, r, nil, err)
+			return
+		}
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).
+			WithField("method", "webauthn").Info("successfully authenticated")
+		h.completeLogin(w, r, userInfo, "webauthn")
+	default:
+		h.respondNotFound(w, r)
+	}
+}
+
+func (h *Handler) respondWebAuthnJSON(w http.ResponseWriter, r *http.Request, body interface{}, err error) {
+	if _, isVerificationError := err.(*webauthn.VerificationError); isVerificationError {
+		logging.Application(r.Header).WithError(err).Info("failed webauthn authentication")
+		h.respondWebAuthnFailure(w, r, 403, "Wrong credentials")
+		return
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondWebAuthnFailure(w, r, 500, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(body) // ignore error of encoding
+}
+
+func (h *Handler) respondWebAuthnFailure(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(status)
+	fmt.Fprint(w, message)
+}
+
+// credentialUserInfo removes the claims of the current token, which must not be stored with the credential.
+func credentialUserInfo(userInfo model.UserInfo) model.UserInfo {
+	userInfo.Expiry = 0
+	userInfo.Refreshes = 0
+	userInfo.LoginCount = 0
+	userInfo.LastLoginAt = 0
+	userInfo.ID = ""
+	return userInfo
+}
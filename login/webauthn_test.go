@@ -0,0 +1,61 @@
+package login
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_WebAuthn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv-webauthn")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.WebAuthnRPID = "example.com"
+	cfg.WebAuthnCredentialsFile = filepath.Join(dir, "webauthn.db")
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Sign in with a passkey")
+	Contains(t, recorder.Body.String(), `var loginsrvWebAuthnPath = "/context/login" + '/webauthn/';`)
+
+	// registration needs a login
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/register/begin", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/webauthn/login/begin", "", AcceptJwt))
+	Equal(t, 400, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/begin", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	options := map[string]map[string]interface{}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &options))
+	Equal(t, "example.com", options["publicKey"]["rpId"])
+	NotEmpty(t, options["publicKey"]["challenge"])
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/finish", `{"id": "foo"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/unknown", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestHandler_WebAuthnDisabled(t *testing.T) {
+	recorder := call(req("POST", "/context/login/webauthn/login/begin", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 404, recorder.Code)
+}
@@ -0,0 +1,246 @@
+package magiclink
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/mailer"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// ProviderName const
+const ProviderName = "magiclink"
+
+const defaultTTL = 15 * time.Minute
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "Magic link login backend opts: smtp_host=..,from=..[,smtp_port=..][,smtp_username=..][,smtp_password=..][,subject=..][,template=..][,ttl=..][,secret=..][,domains=..]",
+			Options: []login.OptionDescription{
+				{Name: "smtp_host", Required: true},
+				{Name: "from", Required: true},
+				{Name: "smtp_port", Type: login.OptionInt},
+				{Name: "smtp_username"},
+				{Name: "smtp_password"},
+				{Name: "subject"},
+				{Name: "template"},
+				{Name: "ttl", Type: login.OptionDuration},
+				{Name: "secret"},
+				{Name: "domains"},
+			},
+		},
+		BackendFactory)
+}
+
+// BackendFactory creates a magic link backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg := mailer.Config{
+		Host:     opts["smtp_host"],
+		Port:     mailer.DefaultPort,
+		Username: opts["smtp_username"],
+		Password: opts["smtp_password"],
+		From:     opts["from"],
+	}
+	if cfg.Host == "" {
+		return nil, errors.New(`missing parameter "smtp_host" for magiclink provider`)
+	}
+	if cfg.From == "" {
+		return nil, errors.New(`missing parameter "from" for magiclink provider`)
+	}
+	if v, exist := opts["smtp_port"]; exist {
+		var err error
+		if cfg.Port, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "smtp_port" for magiclink provider: %v`, v, err)
+		}
+	}
+	smtpMailer, err := mailer.NewMailer(cfg)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid parameter value "%s" in "from" for magiclink provider: %v`, cfg.From, err)
+	}
+
+	ttl := defaultTTL
+	if v, exist := opts["ttl"]; exist {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "ttl" for magiclink provider, expected a positive duration`, v)
+		}
+	}
+
+	linkMailer, err := NewMailer(smtpMailer, opts["subject"], opts["template"])
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []string
+	if v := opts["domains"]; v != "" {
+		domains = strings.Split(strings.ToLower(v), ";")
+	}
+
+	secret := []byte(opts["secret"])
+	if len(secret) == 0 {
+		// the links of a random secret are invalid after a restart and on other instances,
+		// so multiple instances need the secret option
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewBackend(linkMailer, secret, ttl, domains), nil
+}
+
+// Backend authenticates the users by signed one-time links, which are sent to their email address.
+// The email address is expected as username and the token of the link as password.
+type Backend struct {
+	mailer  *Mailer
+	secret  []byte
+	ttl     time.Duration
+	domains []string
+
+	mu sync.Mutex
+	// used holds the nonces of the used tokens until their expiry, so that every link can only be used once.
+	// It is kept per process, so with multiple instances, a link can be used once per instance.
+	used map[string]time.Time
+}
+
+// NewBackend creates a new magic link Backend.
+// The domains restrict the email addresses, which can login. All addresses are allowed, if no domain is given.
+func NewBackend(mailer *Mailer, secret []byte, ttl time.Duration, domains []string) *Backend {
+	return &Backend{
+		mailer:  mailer,
+		secret:  secret,
+		ttl:     ttl,
+		domains: domains,
+		used:    map[string]time.Time{},
+	}
+}
+
+// SendLink sends a link with a one-time token to the email address.
+// The token is appended to the linkURL as query parameter token.
+// Nothing is sent to addresses of other domains than the allowed ones.
+func (b *Backend) SendLink(email, linkURL string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return login.ErrInvalidEmail
+	}
+	email = strings.ToLower(address.Address)
+	if !b.allowed(email) {
+		return nil
+	}
+
+	token, err := b.newToken(email, time.Now().Add(b.ttl))
+	if err != nil {
+		return err
+	}
+	separator := "?"
+	if strings.Contains(linkURL, "?") {
+		separator = "&"
+	}
+	return b.mailer.Send(email, MailData{
+		Email: email,
+		Link:  linkURL + separator + "token=" + token,
+		TTL:   b.ttl,
+	})
+}
+
+// Authenticate the user by the token of the link.
+// The username may be empty, because the token contains the email address.
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	email, nonce, expiry, valid := b.parseToken(password)
+	if !valid || (username != "" && !strings.EqualFold(username, email)) || !b.allowed(email) {
+		return false, model.UserInfo{}, nil
+	}
+	if !b.use(nonce, expiry) {
+		return false, model.UserInfo{}, nil
+	}
+	return true, model.UserInfo{
+		Origin: ProviderName,
+		Sub:    email,
+		Email:  email,
+	}, nil
+}
+
+func (b *Backend) allowed(email string) bool {
+	if len(b.domains) == 0 {
+		return true
+	}
+	for _, domain := range b.domains {
+		if strings.HasSuffix(email, "@"+strings.TrimSpace(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// use marks the nonce as used and returns false, if it was used before.
+func (b *Backend) use(nonce string, expiry time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// forget the nonces of the expired tokens, which can't be used anyway
+	now := time.Now()
+	for n, e := range b.used {
+		if now.After(e) {
+			delete(b.used, n)
+		}
+	}
+	if _, used := b.used[nonce]; used {
+		return false
+	}
+	b.used[nonce] = expiry
+	return true
+}
+
+// newToken returns the signed token of the form base64(email|expiry|nonce).base64(hmac)
+func (b *Backend) newToken(email string, expiry time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	payload := email + "|" + strconv.FormatInt(expiry.Unix(), 10) + "|" + base64.RawURLEncoding.EncodeToString(nonce)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + b.sign(payload), nil
+}
+
+// parseToken verifies the signature and the expiry of the token.
+func (b *Backend) parseToken(token string) (email, nonce string, expiry time.Time, valid bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || !hmac.Equal([]byte(b.sign(string(payload))), []byte(parts[1])) {
+		return "", "", time.Time{}, false
+	}
+
+	fields := strings.Split(string(payload), "|")
+	if len(fields) != 3 {
+		return "", "", time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, false
+	}
+	expiry = time.Unix(unix, 0)
+	if time.Now().After(expiry) {
+		return "", "", time.Time{}, false
+	}
+	return fields[0], fields[2], expiry, true
+}
+
+func (b *Backend) sign(payload string) string {
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
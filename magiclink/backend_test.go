@@ -0,0 +1,179 @@
+package magiclink
+
+import (
+	"io/ioutil"
+	"net/smtp"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/mailer"
+	. "github.com/stretchr/testify/assert"
+)
+
+type sentMail struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+	msg  string
+}
+
+var linkPattern = regexp.MustCompile(`https://login.example.com/login/magiclink\?token=[^\s]+`)
+
+func testBackend(t *testing.T, opts map[string]string) (*Backend, *[]sentMail) {
+	if opts == nil {
+		opts = map[string]string{}
+	}
+	opts["smtp_host"] = "mail.example.com"
+	opts["from"] = "Login <login@example.com>"
+	b, err := BackendFactory(opts)
+	NoError(t, err)
+	mails := &[]sentMail{}
+	backend := b.(*Backend)
+	backend.mailer.mailer.SendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		*mails = append(*mails, sentMail{addr, a, from, to, string(msg)})
+		return nil
+	}
+	return backend, mails
+}
+
+func tokenOfLink(t *testing.T, mail sentMail) string {
+	link := linkPattern.FindString(mail.msg)
+	NotEmpty(t, link)
+	u, err := url.Parse(link)
+	NoError(t, err)
+	return u.Query().Get("token")
+}
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	b, err := p(map[string]string{
+		"smtp_host":     "mail.example.com",
+		"smtp_port":     "2525",
+		"smtp_username": "user",
+		"smtp_password": "password",
+		"from":          "login@example.com",
+		"subject":       "Sign in",
+		"ttl":           "5m",
+		"secret":        "secret",
+		"domains":       "example.com;Example.org",
+	})
+	NoError(t, err)
+	backend := b.(*Backend)
+	Equal(t, mailer.Config{Host: "mail.example.com", Port: 2525, Username: "user", Password: "password", From: "login@example.com"}, backend.mailer.mailer.Config())
+	Equal(t, "Sign in", backend.mailer.subject)
+	Equal(t, 5*time.Minute, backend.ttl)
+	Equal(t, []byte("secret"), backend.secret)
+	Equal(t, []string{"example.com", "example.org"}, backend.domains)
+}
+
+func TestSetup_Errors(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"from": "login@example.com"},
+		{"smtp_host": "mail.example.com"},
+		{"smtp_host": "mail.example.com", "from": "no address"},
+		{"smtp_host": "mail.example.com", "from": "login@example.com", "smtp_port": "foo"},
+		{"smtp_host": "mail.example.com", "from": "login@example.com", "ttl": "foo"},
+		{"smtp_host": "mail.example.com", "from": "login@example.com", "ttl": "-1m"},
+		{"smtp_host": "mail.example.com", "from": "login@example.com", "template": "/does/not/exist"},
+	} {
+		_, err := BackendFactory(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestBackend_SendLinkAndAuthenticate(t *testing.T) {
+	b, mails := testBackend(t, map[string]string{"smtp_username": "user", "smtp_password": "password"})
+
+	NoError(t, b.SendLink("Bob <Bob@Example.com>", "https://login.example.com/login/magiclink"))
+	Equal(t, 1, len(*mails))
+	mail := (*mails)[0]
+	Equal(t, "mail.example.com:587", mail.addr)
+	NotNil(t, mail.auth)
+	Equal(t, "login@example.com", mail.from)
+	Equal(t, []string{"bob@example.com"}, mail.to)
+	Contains(t, mail.msg, "From: \"Login\" <login@example.com>\r\n")
+	Contains(t, mail.msg, "To: <bob@example.com>\r\n")
+	Contains(t, mail.msg, "Subject: Your login link\r\n")
+	Contains(t, mail.msg, "expires in 15m0s")
+
+	token := tokenOfLink(t, mail)
+	authenticated, userInfo, err := b.Authenticate("", token)
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "bob@example.com", userInfo.Sub)
+	Equal(t, "bob@example.com", userInfo.Email)
+	Equal(t, ProviderName, userInfo.Origin)
+
+	// the link can only be used once
+	authenticated, _, err = b.Authenticate("", token)
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_Invalid(t *testing.T) {
+	b, _ := testBackend(t, nil)
+
+	token, err := b.newToken("bob@example.com", time.Now().Add(time.Minute))
+	NoError(t, err)
+
+	for _, invalid := range []string{"", "foo", "foo.bar", token + "x", strings.Replace(token, ".", "x.", 1)} {
+		authenticated, _, err := b.Authenticate("", invalid)
+		NoError(t, err)
+		False(t, authenticated, invalid)
+	}
+
+	authenticated, _, _ := b.Authenticate("alice@example.com", token)
+	False(t, authenticated)
+	authenticated, _, _ = b.Authenticate("BOB@example.com", token)
+	True(t, authenticated)
+
+	expired, err := b.newToken("bob@example.com", time.Now().Add(-time.Second))
+	NoError(t, err)
+	authenticated, _, _ = b.Authenticate("", expired)
+	False(t, authenticated)
+
+	// a token of another secret is rejected
+	other, _ := testBackend(t, nil)
+	token, _ = other.newToken("bob@example.com", time.Now().Add(time.Minute))
+	authenticated, _, _ = b.Authenticate("", token)
+	False(t, authenticated)
+}
+
+func TestBackend_Domains(t *testing.T) {
+	b, mails := testBackend(t, map[string]string{"domains": "example.com"})
+
+	NoError(t, b.SendLink("bob@example.org", "https://login.example.com/login/magiclink"))
+	Equal(t, 0, len(*mails))
+
+	NoError(t, b.SendLink("bob@example.com", "https://login.example.com/login/magiclink"))
+	Equal(t, 1, len(*mails))
+
+	Equal(t, login.ErrInvalidEmail, b.SendLink("no address", "https://login.example.com/login/magiclink"))
+
+	token, _ := b.newToken("bob@example.org", time.Now().Add(time.Minute))
+	authenticated, _, _ := b.Authenticate("", token)
+	False(t, authenticated)
+}
+
+func TestBackend_Template(t *testing.T) {
+	f, err := ioutil.TempFile("", "loginsrv_magiclink_template")
+	NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("Hi {{.Email}}, sign in at {{.Link}}")
+	f.Close()
+
+	b, mails := testBackend(t, map[string]string{"template": f.Name(), "subject": "Anmeldung für loginsrv"})
+	NoError(t, b.SendLink("bob@example.com", "https://login.example.com/login/magiclink?lang=de"))
+	Contains(t, (*mails)[0].msg, "Subject: =?utf-8?q?Anmeldung_f=C3=BCr_loginsrv?=\r\n")
+	Regexp(t, `\r\n\r\nHi bob@example.com, sign in at https://login.example.com/login/magiclink\?lang=de&token=\S+$`, (*mails)[0].msg)
+	Nil(t, (*mails)[0].auth)
+}
@@ -0,0 +1,69 @@
+package magiclink
+
+import (
+	"bytes"
+	"io/ioutil"
+	"text/template"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/mailer"
+	"github.com/pkg/errors"
+)
+
+const defaultSubject = "Your login link"
+
+const defaultTemplate = `Hello,
+
+please use the following link to sign in:
+
+{{.Link}}
+
+The link can be used once and expires in {{.TTL}}.
+If you did not request the link, you can ignore this email.
+`
+
+// MailData is passed to the template of the mail body.
+type MailData struct {
+	Email string
+	Link  string
+	TTL   time.Duration
+}
+
+// Mailer renders and sends the mails with the login links.
+type Mailer struct {
+	mailer   *mailer.Mailer
+	subject  string
+	template *template.Template
+}
+
+// NewMailer creates a Mailer with the body template of the file or the default template, if the file is empty.
+func NewMailer(m *mailer.Mailer, subject, templateFile string) (*Mailer, error) {
+	text := defaultTemplate
+	if templateFile != "" {
+		b, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't read the magiclink mail template")
+		}
+		text = string(b)
+	}
+	t, err := template.New("mail").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid magiclink mail template")
+	}
+	if subject == "" {
+		subject = defaultSubject
+	}
+	return &Mailer{mailer: m, subject: subject, template: t}, nil
+}
+
+// Send renders the template and sends the mail to the address.
+func (m *Mailer) Send(to string, data MailData) error {
+	body := &bytes.Buffer{}
+	if err := m.template.Execute(body, data); err != nil {
+		return errors.Wrap(err, "error rendering the magiclink mail")
+	}
+	if err := m.mailer.Send(to, m.subject, body.String()); err != nil {
+		return errors.Wrap(err, "error sending the magiclink mail")
+	}
+	return nil
+}
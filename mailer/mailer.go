@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// DefaultPort is the smtp submission port
+const DefaultPort = 587
+
+// Config holds the smtp settings.
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Mailer sends plain text mails by smtp.
+// The connection is upgraded with STARTTLS, if the server supports it.
+type Mailer struct {
+	config Config
+	from   *mail.Address
+	// SendMail is smtp.SendMail by default and can be replaced, e.g. in tests
+	SendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewMailer creates a Mailer and verifies the settings.
+func NewMailer(config Config) (*Mailer, error) {
+	if config.Host == "" {
+		return nil, errors.New("missing smtp host")
+	}
+	if config.From == "" {
+		return nil, errors.New("missing sender address")
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %v", config.From, err)
+	}
+	if config.Port == 0 {
+		config.Port = DefaultPort
+	}
+	return &Mailer{config: config, from: from, SendMail: smtp.SendMail}, nil
+}
+
+// Config returns the smtp settings of the mailer.
+func (m *Mailer) Config() Config {
+	return m.config
+}
+
+// Send sends a plain text mail to the address.
+func (m *Mailer) Send(to, subject, body string) error {
+	address, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address %q: %v", to, err)
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(msg, "To: %s\r\n", address.String())
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(msg, "\r\n")
+	msg.WriteString(body)
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	return m.SendMail(addr, auth, m.from.Address, []string{address.Address}, msg.Bytes())
+}
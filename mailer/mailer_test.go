@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"errors"
+	"net/smtp"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestMailer_Send(t *testing.T) {
+	m, err := NewMailer(Config{Host: "mail.example.com", Username: "user", Password: "secret", From: "Login <login@example.com>"})
+	NoError(t, err)
+	Equal(t, DefaultPort, m.Config().Port)
+
+	var addr, from, msg string
+	var to []string
+	var auth smtp.Auth
+	m.SendMail = func(a string, au smtp.Auth, f string, t []string, m []byte) error {
+		addr, auth, from, to, msg = a, au, f, t, string(m)
+		return nil
+	}
+
+	NoError(t, m.Send("Bob <bob@example.com>", "Grüße", "Hello Bob"))
+	Equal(t, "mail.example.com:587", addr)
+	NotNil(t, auth)
+	Equal(t, "login@example.com", from)
+	Equal(t, []string{"bob@example.com"}, to)
+	Contains(t, msg, "From: \"Login\" <login@example.com>\r\n")
+	Contains(t, msg, "To: \"Bob\" <bob@example.com>\r\n")
+	Contains(t, msg, "Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n")
+	Contains(t, msg, "Content-Type: text/plain; charset=utf-8\r\n\r\nHello Bob")
+
+	Error(t, m.Send("no address", "subject", "body"))
+
+	m.SendMail = func(string, smtp.Auth, string, []string, []byte) error {
+		return errors.New("connection refused")
+	}
+	EqualError(t, m.Send("bob@example.com", "subject", "body"), "connection refused")
+}
+
+func TestMailer_NoAuth(t *testing.T) {
+	m, err := NewMailer(Config{Host: "mail.example.com", Port: 25, From: "login@example.com"})
+	NoError(t, err)
+	m.SendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		Equal(t, "mail.example.com:25", addr)
+		Nil(t, a)
+		return nil
+	}
+	NoError(t, m.Send("bob@example.com", "subject", "body"))
+}
+
+func TestNewMailer_Errors(t *testing.T) {
+	for _, cfg := range []Config{
+		{From: "login@example.com"},
+		{Host: "mail.example.com"},
+		{Host: "mail.example.com", From: "no address"},
+	} {
+		_, err := NewMailer(cfg)
+		Error(t, err, "%v", cfg)
+	}
+}
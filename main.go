@@ -1,9 +1,17 @@
 package main
 
 import (
+	_ "github.com/afdecastro879/loginsrv/dbbackend"
 	_ "github.com/afdecastro879/loginsrv/htpasswd"
+	_ "github.com/afdecastro879/loginsrv/httpauth"
 	_ "github.com/afdecastro879/loginsrv/httpupstream"
+	_ "github.com/afdecastro879/loginsrv/ldap"
+	_ "github.com/afdecastro879/loginsrv/magiclink"
+	_ "github.com/afdecastro879/loginsrv/mongodb"
 	_ "github.com/afdecastro879/loginsrv/osiam"
+	_ "github.com/afdecastro879/loginsrv/pam"
+	_ "github.com/afdecastro879/loginsrv/radius"
+	_ "github.com/afdecastro879/loginsrv/spiffe"
 
 	"github.com/afdecastro879/loginsrv/login"
 
@@ -12,43 +20,96 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/metrics"
+	"github.com/afdecastro879/loginsrv/tracing"
 )
 
 const applicationName = "loginsrv"
 
+const healthPath = "/healthz"
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == validateConfigCommand {
+		os.Exit(runValidateConfig(os.Args[2:]))
+	}
+
 	config := login.ReadConfig()
-	if err := logging.Set(config.LogLevel, config.TextLogging); err != nil {
+	logFormat := config.LogFormat
+	if config.TextLogging {
+		logFormat = logging.FormatText
+	}
+	if err := logging.Configure(logging.Options{
+		Level:           config.LogLevel,
+		Format:          logFormat,
+		ComponentLevels: config.LogComponentLevels,
+		Redact:          config.LogRedact,
+	}); err != nil {
 		exit(nil, err)
 	}
 	logging.AccessLogCookiesBlacklist = append(logging.AccessLogCookiesBlacklist, config.CookieName)
 
 	configToLog := *config
 	configToLog.JwtSecret = "..."
+	configToLog.JwtSecondarySecret = "..."
+	configToLog.JweSecret = "..."
+	configToLog.CaptchaSecret = "..."
+	configToLog.SMTPPassword = "..."
+	configToLog.AdminToken = "..."
 	logging.LifecycleStart(applicationName, configToLog)
 
+	tracer, err := tracing.FromEnv(applicationName)
+	if err != nil {
+		exit(nil, err)
+	}
+	tracing.SetTracer(tracer)
+
 	h, err := login.NewHandler(config)
 	if err != nil {
 		exit(nil, err)
 	}
 
-	handlerChain := logging.NewLogMiddleware(h)
+	// the backends, the providers and the user file are reloaded on SIGHUP
+	rh := newReloadableHandler(h, config.GracePeriod)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go reloadOnSignal(rh, reload, os.Args[1:])
+
+	// draining is set on shutdown, so the health check fails while the requests are finished
+	var draining int32
+	handlerChain := healthCheck(logging.NewLogMiddleware(rh), &draining)
 
 	stop := make(chan os.Signal)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	port := config.Port
-	if port != "" {
-		port = fmt.Sprintf(":%s", port)
+	tlsConfig, redirectHandler, err := newTLSConfig(config)
+	if err != nil {
+		exit(nil, err)
+	}
+
+	grpcSrv, err := newGRPCServer(config, logging.NewLogMiddleware(grpcHandler(rh)))
+	if err != nil {
+		exit(nil, err)
 	}
 
-	httpSrv := &http.Server{Addr: port, Handler: handlerChain}
+	listener, err := listen(config)
+	if err != nil {
+		exit(nil, err)
+	}
+	httpSrv := &http.Server{Handler: handlerChain, TLSConfig: tlsConfig}
 
 	go func() {
-		if err := httpSrv.ListenAndServe(); err != nil {
+		var err error
+		if tlsConfig != nil {
+			err = httpSrv.ServeTLS(listener, "", "")
+		} else {
+			err = httpSrv.Serve(listener)
+		}
+		if err != nil {
 			if err == http.ErrServerClosed {
 				logging.ServerClosed(applicationName)
 			} else {
@@ -56,12 +117,87 @@ func main() {
 			}
 		}
 	}()
+
+	var redirectSrv *http.Server
+	if config.TLSRedirectAddress != "" {
+		redirectSrv = &http.Server{Addr: config.TLSRedirectAddress, Handler: redirectHandler}
+		go func() {
+			if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				exit(nil, err)
+			}
+		}()
+	}
+
+	if grpcSrv != nil {
+		go func() {
+			if err := grpcSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				exit(nil, err)
+			}
+		}()
+	}
+
+	var metricsSrv *http.Server
+	if config.MetricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		metricsSrv = &http.Server{Addr: config.MetricsAddress, Handler: mux}
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				exit(nil, err)
+			}
+		}()
+	}
 	logging.LifecycleStop(applicationName, <-stop, nil)
 
+	atomic.StoreInt32(&draining, 1)
+	if config.ShutdownDelay > 0 {
+		// the load balancers remove the instance because of the failing health check,
+		// while new requests are still accepted. A second signal ends the delay.
+		select {
+		case <-time.After(config.ShutdownDelay):
+		case <-stop:
+		}
+	}
+
 	ctx, ctxCancel := context.WithTimeout(context.Background(), config.GracePeriod)
+	defer ctxCancel()
+
+	if err := httpSrv.Shutdown(ctx); err != nil {
+		logging.Logger.WithError(err).Warn("grace period exceeded, closing the remaining connections")
+		httpSrv.Close()
+	}
+	if grpcSrv != nil {
+		grpcSrv.Shutdown(ctx)
+	}
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(ctx)
+	}
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(ctx)
+	}
+	signal.Stop(reload)
+	if err := rh.current().Close(); err != nil {
+		logging.Logger.WithError(err).Error("can't close the handler")
+	}
+	tracer.Shutdown()
+}
 
-	httpSrv.Shutdown(ctx)
-	ctxCancel()
+// healthCheck answers the requests to /healthz, which fail while the server is draining
+func healthCheck(next http.Handler, draining *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != healthPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "no-store")
+		if atomic.LoadInt32(draining) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "shutting down")
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
 }
 
 var exit = func(signal os.Signal, err error) {
@@ -5,6 +5,7 @@ import (
 	. "github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -48,3 +49,31 @@ func Test_BasicEndToEnd(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_HealthCheck(t *testing.T) {
+	var draining int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	})
+	h := healthCheck(next, &draining)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "ok\n", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/login", nil))
+	Equal(t, 204, recorder.Code)
+
+	draining = 1
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	Equal(t, 503, recorder.Code)
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+
+	// requests are still served while draining
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/login", nil))
+	Equal(t, 204, recorder.Code)
+}
@@ -0,0 +1,84 @@
+// Package metrics provides the prometheus metrics of loginsrv.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "loginsrv"
+
+var (
+	loginAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_attempts_total",
+		Help:      "Number of login attempts per backend or oauth provider.",
+	}, []string{"provider"})
+
+	loginSuccesses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_successes_total",
+		Help:      "Number of successful logins per backend or oauth provider.",
+	}, []string{"provider"})
+
+	loginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Number of logins with wrong credentials per backend or oauth provider.",
+	}, []string{"provider"})
+
+	loginErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_errors_total",
+		Help:      "Number of logins, which failed due to an error of the backend or oauth provider.",
+	}, []string{"provider"})
+
+	oauth2Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "oauth2_request_duration_seconds",
+		Help:      "Duration of the token exchange and user info requests to the oauth provider.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	refreshTokens = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "refresh_tokens",
+		Help:      "Number of active refresh tokens.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(loginAttempts, loginSuccesses, loginFailures, loginErrors, oauth2Duration, refreshTokens)
+}
+
+// Login counts a login attempt and its result for the backend or oauth provider.
+// A login with an error is neither counted as success nor as failure.
+func Login(provider string, authenticated bool, err error) {
+	loginAttempts.WithLabelValues(provider).Inc()
+	switch {
+	case err != nil:
+		loginErrors.WithLabelValues(provider).Inc()
+	case authenticated:
+		loginSuccesses.WithLabelValues(provider).Inc()
+	default:
+		loginFailures.WithLabelValues(provider).Inc()
+	}
+}
+
+// ObserveOAuth2Request records the duration of the requests to the oauth provider after the redirect back to loginsrv.
+func ObserveOAuth2Request(provider string, duration time.Duration) {
+	oauth2Duration.WithLabelValues(provider).Observe(duration.Seconds())
+}
+
+// SetRefreshTokens sets the number of active refresh tokens.
+func SetRefreshTokens(count int) {
+	refreshTokens.Set(float64(count))
+}
+
+// Handler returns the http handler, which serves the metrics in the prometheus format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
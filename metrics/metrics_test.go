@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestLogin(t *testing.T) {
+	Login("test", true, nil)
+	Login("test", false, nil)
+	Login("test", false, nil)
+	Login("test", false, errors.New("test error"))
+
+	Equal(t, 4.0, testutil.ToFloat64(loginAttempts.WithLabelValues("test")))
+	Equal(t, 1.0, testutil.ToFloat64(loginSuccesses.WithLabelValues("test")))
+	Equal(t, 2.0, testutil.ToFloat64(loginFailures.WithLabelValues("test")))
+	Equal(t, 1.0, testutil.ToFloat64(loginErrors.WithLabelValues("test")))
+}
+
+func TestHandler(t *testing.T) {
+	Login("github", true, nil)
+	ObserveOAuth2Request("github", 200*time.Millisecond)
+	SetRefreshTokens(3)
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	Equal(t, 200, recorder.Code)
+
+	body, _ := ioutil.ReadAll(recorder.Body)
+	Contains(t, string(body), `loginsrv_login_successes_total{provider="github"} 1`)
+	Contains(t, string(body), `loginsrv_oauth2_request_duration_seconds_count{provider="github"} 1`)
+	Contains(t, string(body), `loginsrv_refresh_tokens 3`)
+}
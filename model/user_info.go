@@ -8,23 +8,47 @@ import (
 // UserInfo holds the parameters returned by the backends.
 // This information will be serialized to build the JWT token contents.
 type UserInfo struct {
-	Sub       string   `json:"sub"`
-	Picture   string   `json:"picture,omitempty"`
-	Name      string   `json:"name,omitempty"`
-	Email     string   `json:"email,omitempty"`
-	Origin    string   `json:"origin,omitempty"`
-	Expiry    int64    `json:"exp,omitempty"`
-	Refreshes int      `json:"refs,omitempty"`
-	Domain    string   `json:"domain,omitempty"`
-	Groups    []string `json:"groups,omitempty"`
+	Sub         string   `json:"sub"`
+	Picture     string   `json:"picture,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Origin      string   `json:"origin,omitempty"`
+	Expiry      int64    `json:"exp,omitempty"`
+	Refreshes   int      `json:"refs,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	LoginCount  int      `json:"login_count,omitempty"`
+	LastLoginAt int64    `json:"last_login_at,omitempty"`
+	Verified    bool     `json:"verified,omitempty"`
+	ID          string   `json:"jti,omitempty"`
+	Issuer      string   `json:"iss,omitempty"`
+	Audience    string   `json:"aud,omitempty"`
+	NotBefore   int64    `json:"nbf,omitempty"`
+	// RememberMe marks the long-lived tokens of a login with "remember me",
+	// so that applications can require a fresh login for sensitive actions.
+	RememberMe bool `json:"remember_me,omitempty"`
+	// AuthTime is the time of the login with credentials, which is kept on refresh.
+	AuthTime int64 `json:"auth_time,omitempty"`
+	// TokenType marks the tokens, which are no login tokens, e.g. the access tokens for the clients of the
+	// OpenID Connect provider, so that they are not accepted as login token.
+	TokenType string `json:"typ,omitempty"`
+
+	// Raw is the user info response of the oauth provider. It is only set during the login
+	// for the claims mapping and is not part of the token.
+	Raw map[string]interface{} `json:"-"`
 }
 
 // Valid lets us use the user info as Claim for jwt-go.
-// It checks the token expiry.
+// It checks the token expiry and the not before time.
 func (u UserInfo) Valid() error {
-	if u.Expiry < time.Now().Unix() {
+	now := time.Now().Unix()
+	if u.Expiry < now {
 		return errors.New("token expired")
 	}
+	if u.NotBefore > now {
+		return errors.New("token not valid yet")
+	}
 	return nil
 }
 
@@ -56,5 +80,38 @@ func (u UserInfo) AsMap() map[string]interface{} {
 	if len(u.Groups) > 0 {
 		m["groups"] = u.Groups
 	}
+	if len(u.Roles) > 0 {
+		m["roles"] = u.Roles
+	}
+	if u.LoginCount != 0 {
+		m["login_count"] = u.LoginCount
+	}
+	if u.LastLoginAt != 0 {
+		m["last_login_at"] = u.LastLoginAt
+	}
+	if u.Verified {
+		m["verified"] = u.Verified
+	}
+	if u.ID != "" {
+		m["jti"] = u.ID
+	}
+	if u.Issuer != "" {
+		m["iss"] = u.Issuer
+	}
+	if u.Audience != "" {
+		m["aud"] = u.Audience
+	}
+	if u.NotBefore != 0 {
+		m["nbf"] = u.NotBefore
+	}
+	if u.RememberMe {
+		m["remember_me"] = u.RememberMe
+	}
+	if u.AuthTime != 0 {
+		m["auth_time"] = u.AuthTime
+	}
+	if u.TokenType != "" {
+		m["typ"] = u.TokenType
+	}
 	return m
 }
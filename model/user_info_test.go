@@ -12,19 +12,32 @@ func Test_UserInfo_Valid(t *testing.T) {
 	Error(t, UserInfo{Expiry: 0}.Valid())
 	Error(t, UserInfo{Expiry: time.Now().Add(-1 * time.Second).Unix()}.Valid())
 	NoError(t, UserInfo{Expiry: time.Now().Add(time.Second).Unix()}.Valid())
+	NoError(t, UserInfo{Expiry: time.Now().Add(time.Second).Unix(), NotBefore: time.Now().Unix()}.Valid())
+	Error(t, UserInfo{Expiry: time.Now().Add(time.Hour).Unix(), NotBefore: time.Now().Add(time.Minute).Unix()}.Valid())
 }
 
 func Test_UserInfo_AsMap(t *testing.T) {
 	u := UserInfo{
-		Sub:       `json:"sub"`,
-		Picture:   `json:"picture,omitempty"`,
-		Name:      `json:"name,omitempty"`,
-		Email:     `json:"email,omitempty"`,
-		Origin:    `json:"origin,omitempty"`,
-		Expiry:    23,
-		Refreshes: 42,
-		Domain:    `json:"domain,omitempty"`,
-		Groups:    []string{`json:"groups,omitempty"`},
+		Sub:         `json:"sub"`,
+		Picture:     `json:"picture,omitempty"`,
+		Name:        `json:"name,omitempty"`,
+		Email:       `json:"email,omitempty"`,
+		Origin:      `json:"origin,omitempty"`,
+		Expiry:      23,
+		Refreshes:   42,
+		Domain:      `json:"domain,omitempty"`,
+		Groups:      []string{`json:"groups,omitempty"`},
+		Roles:       []string{`json:"roles,omitempty"`},
+		LoginCount:  3,
+		LastLoginAt: 1546300800,
+		Verified:    true,
+		ID:          `json:"jti,omitempty"`,
+		Issuer:      `json:"iss,omitempty"`,
+		Audience:    `json:"aud,omitempty"`,
+		NotBefore:   1546300800,
+		RememberMe:  true,
+		AuthTime:    1546300800,
+		TokenType:   `json:"typ,omitempty"`,
 	}
 
 	givenJson, _ := json.Marshal(u.AsMap())
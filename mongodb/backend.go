@@ -0,0 +1,234 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ProviderName const
+const ProviderName = "mongodb"
+
+const defaultTimeout = 5 * time.Second
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "MongoDB login backend opts: uri=mongodb://..[,database=..][,collection=..][,username_field=..][,password_field=..][,name_field=..][,email_field=..][,roles_field=..][,timeout=..]",
+			Options: []login.OptionDescription{
+				{Name: "uri", Required: true},
+				{Name: "database"},
+				{Name: "collection"},
+				{Name: "username_field"},
+				{Name: "password_field"},
+				{Name: "name_field"},
+				{Name: "email_field"},
+				{Name: "roles_field"},
+				{Name: "timeout", Type: login.OptionDuration},
+			},
+		},
+		BackendFactory)
+}
+
+// Config of the mongodb backend
+type Config struct {
+	URI string
+	// Database is the database of the collection, by default the database of the URI
+	Database   string
+	Collection string
+
+	// The fields of the user documents. Nested fields are separated by dots, e.g. profile.email.
+	// The name, email and roles fields are optional in the documents.
+	UsernameField string
+	// PasswordField contains the bcrypt hash of the password
+	PasswordField string
+	NameField     string
+	EmailField    string
+	// RolesField is an array of strings, which is mapped to the groups claim. It is not read, if empty.
+	RolesField string
+
+	Timeout time.Duration
+}
+
+// BackendFactory creates a mongodb backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg := Config{
+		URI:           opts["uri"],
+		Database:      opts["database"],
+		Collection:    "users",
+		UsernameField: "username",
+		PasswordField: "password",
+		NameField:     "name",
+		EmailField:    "email",
+		RolesField:    opts["roles_field"],
+		Timeout:       defaultTimeout,
+	}
+
+	if cfg.URI == "" {
+		return nil, errors.New(`missing parameter "uri" for mongodb provider`)
+	}
+	cs, err := connstring.ParseAndValidate(cfg.URI)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid parameter value in "uri" for mongodb provider: %v`, err)
+	}
+	if cfg.Database == "" {
+		cfg.Database = cs.Database
+	}
+	if cfg.Database == "" {
+		return nil, errors.New(`missing parameter "database" for mongodb provider, it is also taken from the uri`)
+	}
+
+	for name, field := range map[string]*string{
+		"collection":     &cfg.Collection,
+		"username_field": &cfg.UsernameField,
+		"password_field": &cfg.PasswordField,
+		"name_field":     &cfg.NameField,
+		"email_field":    &cfg.EmailField,
+	} {
+		if v, exist := opts[name]; exist {
+			if v == "" && (name == "collection" || name == "username_field" || name == "password_field") {
+				return nil, fmt.Errorf(`empty parameter "%s" for mongodb provider`, name)
+			}
+			*field = v
+		}
+	}
+
+	if v, exist := opts["timeout"]; exist {
+		if cfg.Timeout, err = time.ParseDuration(v); err != nil || cfg.Timeout <= 0 {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "timeout" for mongodb provider`, v)
+		}
+	}
+
+	return NewBackend(cfg)
+}
+
+// Backend authenticates the users against the password hashes of the documents of a mongodb collection.
+type Backend struct {
+	config Config
+	// findUser returns the document of the user or nil, if there is none
+	findUser func(ctx context.Context, username string) (bson.M, error)
+	client   *mongo.Client
+}
+
+// NewBackend creates a new mongodb Backend.
+// The connection is established in the background, so the server does not need to be available on start.
+func NewBackend(cfg Config) (*Backend, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI(cfg.URI).
+		SetServerSelectionTimeout(cfg.Timeout))
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database(cfg.Database).Collection(cfg.Collection)
+
+	return &Backend{
+		config: cfg,
+		findUser: func(ctx context.Context, username string) (bson.M, error) {
+			doc := bson.M{}
+			err := collection.FindOne(ctx, bson.D{{Key: cfg.UsernameField, Value: username}}).Decode(&doc)
+			if err == mongo.ErrNoDocuments {
+				return nil, nil
+			}
+			return doc, err
+		},
+		client: client,
+	}, nil
+}
+
+// Close disconnects the client, e.g. when the backend is replaced by a reload.
+func (b *Backend) Close() error {
+	if b.client == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+	return b.client.Disconnect(ctx)
+}
+
+// Authenticate the user by the bcrypt hash of the password field
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if username == "" || password == "" {
+		return false, model.UserInfo{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+
+	doc, err := b.findUser(ctx, username)
+	if doc == nil || err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	hash, _ := field(doc, b.config.PasswordField).(string)
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, model.UserInfo{}, nil
+	}
+	if err != nil {
+		return false, model.UserInfo{}, fmt.Errorf("invalid password hash for user %q: %v", username, err)
+	}
+
+	userInfo := model.UserInfo{
+		Sub:    username,
+		Origin: ProviderName,
+	}
+	userInfo.Name, _ = field(doc, b.config.NameField).(string)
+	userInfo.Email, _ = field(doc, b.config.EmailField).(string)
+	if b.config.RolesField != "" {
+		userInfo.Groups = roles(field(doc, b.config.RolesField))
+	}
+	return true, userInfo, nil
+}
+
+// field returns the value of a field of the document. The names of nested fields are separated by dots.
+func field(doc bson.M, name string) interface{} {
+	if name == "" {
+		return nil
+	}
+	var value interface{} = doc
+	for _, key := range strings.Split(name, ".") {
+		switch v := value.(type) {
+		case bson.M:
+			value = v[key]
+		case bson.D:
+			value = nil
+			for _, e := range v {
+				if e.Key == key {
+					value = e.Value
+					break
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// roles returns the strings of an array or a single string
+func roles(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case bson.A:
+		list := []string{}
+		for _, role := range v {
+			if s, ok := role.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{"uri": "mongodb://localhost:27017/app"})
+	NoError(t, err)
+	Equal(t, Config{
+		URI:           "mongodb://localhost:27017/app",
+		Database:      "app",
+		Collection:    "users",
+		UsernameField: "username",
+		PasswordField: "password",
+		NameField:     "name",
+		EmailField:    "email",
+		Timeout:       defaultTimeout,
+	}, backend.(*Backend).config)
+
+	backend, err = p(map[string]string{
+		"uri":            "mongodb://localhost:27017",
+		"database":       "auth",
+		"collection":     "accounts",
+		"username_field": "login",
+		"password_field": "credentials.bcrypt",
+		"name_field":     "",
+		"email_field":    "profile.email",
+		"roles_field":    "roles",
+		"timeout":        "2s",
+	})
+	NoError(t, err)
+	Equal(t, Config{
+		URI:           "mongodb://localhost:27017",
+		Database:      "auth",
+		Collection:    "accounts",
+		UsernameField: "login",
+		PasswordField: "credentials.bcrypt",
+		NameField:     "",
+		EmailField:    "profile.email",
+		RolesField:    "roles",
+		Timeout:       2 * time.Second,
+	}, backend.(*Backend).config)
+}
+
+func TestSetup_Error(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{},
+		{"uri": "localhost:27017/app"},
+		{"uri": "mongodb://localhost:27017"},
+		{"uri": "mongodb://localhost:27017/app", "username_field": ""},
+		{"uri": "mongodb://localhost:27017/app", "timeout": "2"},
+	} {
+		_, err := BackendFactory(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func testBackend(t *testing.T, cfg Config) *Backend {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	NoError(t, err)
+	users := map[string]bson.M{
+		"bob": {
+			"username": "bob",
+			"password": string(hash),
+			"name":     "Bob Builder",
+			"profile":  bson.M{"email": "bob@example.com"},
+			"roles":    bson.A{"admin", "dev", 42},
+		},
+		"alice":   {"username": "alice", "password": string(hash), "roles": "dev"},
+		"invalid": {"username": "invalid", "password": "plaintext"},
+	}
+	cfg.Timeout = time.Second
+	return &Backend{
+		config: cfg,
+		findUser: func(ctx context.Context, username string) (bson.M, error) {
+			if username == "error" {
+				return nil, errors.New("connection refused")
+			}
+			return users[username], nil
+		},
+	}
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	b := testBackend(t, Config{PasswordField: "password", NameField: "name", EmailField: "profile.email", RolesField: "roles"})
+
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{
+		Sub:    "bob",
+		Name:   "Bob Builder",
+		Email:  "bob@example.com",
+		Groups: []string{"admin", "dev"},
+		Origin: ProviderName,
+	}, userInfo)
+
+	authenticated, userInfo, err = b.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Sub: "alice", Groups: []string{"dev"}, Origin: ProviderName}, userInfo)
+
+	authenticated, _, err = b.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("unknown", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_WithoutRoles(t *testing.T) {
+	b := testBackend(t, Config{PasswordField: "password", NameField: "name"})
+
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Sub: "bob", Name: "Bob Builder", Origin: ProviderName}, userInfo)
+}
+
+func TestBackend_Authenticate_Error(t *testing.T) {
+	b := testBackend(t, Config{PasswordField: "password"})
+
+	authenticated, _, err := b.Authenticate("invalid", "secret")
+	Error(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("error", "secret")
+	Error(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_Unavailable(t *testing.T) {
+	b, err := NewBackend(Config{
+		URI:           "mongodb://127.0.0.1:1/app",
+		Database:      "app",
+		Collection:    "users",
+		UsernameField: "username",
+		PasswordField: "password",
+		Timeout:       100 * time.Millisecond,
+	})
+	NoError(t, err)
+
+	authenticated, _, err := b.Authenticate("bob", "secret")
+	Error(t, err)
+	False(t, authenticated)
+
+	NoError(t, b.Close())
+	_, _, err = b.Authenticate("bob", "secret")
+	Error(t, err)
+}
+
+func TestField(t *testing.T) {
+	doc := bson.M{
+		"name":    "bob",
+		"profile": bson.M{"email": "bob@example.com"},
+		"address": bson.D{{Key: "city", Value: "Berlin"}},
+	}
+	Equal(t, "bob", field(doc, "name"))
+	Equal(t, "bob@example.com", field(doc, "profile.email"))
+	Equal(t, "Berlin", field(doc, "address.city"))
+	Nil(t, field(doc, "name.first"))
+	Nil(t, field(doc, "missing"))
+	Nil(t, field(doc, ""))
+}
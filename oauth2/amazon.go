@@ -0,0 +1,74 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+var amazonProfileEndpoint = "https://api.amazon.com/user/profile"
+
+func init() {
+	RegisterProvider(providerAmazon)
+}
+
+// amazonUser is used for parsing the login with amazon profile response
+type amazonUser struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+var providerAmazon = Provider{
+	Name:          "amazon",
+	AuthURL:       "https://www.amazon.com/ap/oa",
+	TokenURL:      "https://api.amazon.com/auth/o2/token",
+	DefaultScopes: "profile",
+	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+		au := amazonUser{}
+
+		req, _ := http.NewRequest("GET", amazonProfileEndpoint, nil)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		req.Header.Set("Accept", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+		defer resp.Body.Close()
+
+		if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on amazon get user info: %v", resp.Header.Get("Content-Type"))
+		}
+
+		if resp.StatusCode != 200 {
+			return model.UserInfo{}, "", fmt.Errorf("got http status %v on amazon get user info", resp.StatusCode)
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("error reading amazon get user info: %v", err)
+		}
+
+		err = json.Unmarshal(b, &au)
+		if err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("error parsing amazon get user info: %v", err)
+		}
+
+		if au.UserID == "" {
+			return model.UserInfo{}, "", &MissingFieldError{Provider: "amazon", Field: "user_id"}
+		}
+
+		return model.UserInfo{
+			Sub:    au.UserID,
+			Name:   au.Name,
+			Email:  au.Email,
+			Origin: "amazon",
+			// amazon does not return a verification status of the account
+			Verified: true,
+		}, string(b), nil
+	},
+}
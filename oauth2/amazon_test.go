@@ -0,0 +1,62 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var amazonTestUserResponse = `{
+  "user_id": "amzn1.account.K2LI23KL2LK2",
+  "email": "mhashimoto-04@plaxo.com",
+  "name": "Mork Hashimoto",
+  "postal_code": "98052"
+}`
+
+func Test_Amazon_getUserInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Write([]byte(amazonTestUserResponse))
+	}))
+	defer server.Close()
+	amazonProfileEndpoint = server.URL
+
+	u, rawJSON, err := providerAmazon.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:      "amzn1.account.K2LI23KL2LK2",
+		Name:     "Mork Hashimoto",
+		Email:    "mhashimoto-04@plaxo.com",
+		Origin:   "amazon",
+		Verified: true,
+	}, u)
+	Equal(t, amazonTestUserResponse, rawJSON)
+}
+
+func Test_Amazon_getUserInfo_Errors(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		status      int
+		body        string
+	}{
+		{"text/html", 200, amazonTestUserResponse},
+		{"application/json", 400, `{"error": "invalid_token"}`},
+		{"application/json", 200, `{"name": "Mork Hashimoto"}`},
+		{"application/json", 200, `not json`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		amazonProfileEndpoint = server.URL
+
+		_, _, err := providerAmazon.GetUserInfo(TokenInfo{AccessToken: "secret"})
+		Error(t, err)
+		server.Close()
+	}
+}
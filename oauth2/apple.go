@@ -0,0 +1,141 @@
+package oauth2
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+)
+
+var appleIssuer = "https://appleid.apple.com"
+
+func init() {
+	RegisterProvider(providerApple)
+}
+
+// AppleIDToken holds the claims of the id token returned by apple.
+// Apple only includes the email, if the user has granted the email scope.
+type AppleIDToken struct {
+	jwt.StandardClaims
+	Email          string    `json:"email,omitempty"`
+	EmailVerified  appleBool `json:"email_verified,omitempty"`
+	IsPrivateEmail appleBool `json:"is_private_email,omitempty"`
+}
+
+// appleBool is a boolean, which apple sends as json boolean or as string.
+type appleBool bool
+
+func (b *appleBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `true`, `"true"`:
+		*b = true
+	case `false`, `"false"`, `null`:
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean value %s", data)
+	}
+	return nil
+}
+
+var providerApple = Provider{
+	Name:          "apple",
+	AuthURL:       "https://appleid.apple.com/auth/authorize",
+	TokenURL:      "https://appleid.apple.com/auth/token",
+	DefaultScopes: "name email",
+	ResponseMode:  "form_post",
+	ClientSecret:  appleClientSecret,
+	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+		if token.IDToken == "" {
+			return model.UserInfo{}, "", &MissingFieldError{Provider: "apple", Field: "id_token"}
+		}
+
+		// The id token is received directly from the apple token endpoint over tls,
+		// so the tls server validation is used instead of checking the token signature.
+		claims := AppleIDToken{}
+		_, _, err := new(jwt.Parser).ParseUnverified(token.IDToken, &claims)
+		if err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("error parsing apple id_token: %v", err)
+		}
+		if !claims.VerifyIssuer(appleIssuer, true) {
+			return model.UserInfo{}, "", fmt.Errorf("invalid apple id_token: unexpected issuer %q", claims.Issuer)
+		}
+		if err := claims.Valid(); err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("invalid apple id_token: %v", err)
+		}
+		if claims.Subject == "" {
+			return model.UserInfo{}, "", &MissingFieldError{Provider: "apple", Field: "sub"}
+		}
+
+		raw, err := json.Marshal(claims)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+
+		// The email is not returned on every login,
+		// so the stable apple user id is used as subject.
+		userInfo := model.UserInfo{
+			Sub:    claims.Subject,
+			Origin: "apple",
+			// apple ids are verified by apple
+			Verified: true,
+		}
+		if claims.EmailVerified {
+			userInfo.Email = claims.Email
+		}
+		return userInfo, string(raw), nil
+	},
+}
+
+// appleClientSecret creates the client secret for apple, which is a jwt signed with the private key
+// from the apple developer portal.
+func appleClientSecret(opts map[string]string) (string, error) {
+	for _, p := range []string{"team_id", "key_id", "private_key_file"} {
+		if opts[p] == "" {
+			return "", fmt.Errorf("missing parameter %v for apple provider", p)
+		}
+	}
+
+	b, err := ioutil.ReadFile(opts["private_key_file"])
+	if err != nil {
+		return "", fmt.Errorf("can not read apple private key: %v", err)
+	}
+	key, err := parseApplePrivateKey(b)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.StandardClaims{
+		Issuer:    opts["team_id"],
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(5 * time.Minute).Unix(),
+		Audience:  appleIssuer,
+		Subject:   opts["client_id"],
+	})
+	token.Header["kid"] = opts["key_id"]
+	return token.SignedString(key)
+}
+
+// parseApplePrivateKey parses the PKCS8 encoded EC key of a .p8 file.
+func parseApplePrivateKey(b []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid apple private key: no PEM data found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apple private key: %v", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid apple private key: not an EC key")
+	}
+	return ecKey, nil
+}
@@ -0,0 +1,183 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_Apple_ClientSecret(t *testing.T) {
+	key, keyFile := writeAppleTestKey(t)
+	defer os.Remove(keyFile)
+
+	secret, err := appleClientSecret(map[string]string{
+		"client_id":        "com.example.app",
+		"team_id":          "TEAM123456",
+		"key_id":           "KEY1234567",
+		"private_key_file": keyFile,
+	})
+	NoError(t, err)
+
+	claims := jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(secret, &claims, func(token *jwt.Token) (interface{}, error) {
+		return key.Public(), nil
+	})
+	NoError(t, err)
+	Equal(t, "ES256", token.Method.Alg())
+	Equal(t, "KEY1234567", token.Header["kid"])
+	Equal(t, "TEAM123456", claims.Issuer)
+	Equal(t, "com.example.app", claims.Subject)
+	Equal(t, "https://appleid.apple.com", claims.Audience)
+	True(t, claims.ExpiresAt > time.Now().Unix())
+}
+
+func Test_Apple_ClientSecret_Errors(t *testing.T) {
+	_, err := appleClientSecret(map[string]string{"key_id": "KEY1234567", "private_key_file": "foo.p8"})
+	EqualError(t, err, "missing parameter team_id for apple provider")
+
+	_, err = appleClientSecret(map[string]string{"team_id": "TEAM123456", "key_id": "KEY1234567", "private_key_file": "/does/not/exist.p8"})
+	Error(t, err)
+
+	f, _ := ioutil.TempFile("", "loginsrv_apple_key")
+	f.WriteString("no key")
+	f.Close()
+	defer os.Remove(f.Name())
+	_, err = appleClientSecret(map[string]string{"team_id": "TEAM123456", "key_id": "KEY1234567", "private_key_file": f.Name()})
+	EqualError(t, err, "invalid apple private key: no PEM data found")
+}
+
+func Test_Apple_getUserInfo(t *testing.T) {
+	idToken := appleTestIDToken(t, jwt.MapClaims{
+		"iss":            "https://appleid.apple.com",
+		"aud":            "com.example.app",
+		"sub":            "001234.abcdef.1234",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"email":          "john@example.com",
+		"email_verified": "true",
+	})
+
+	u, rawJSON, err := providerApple.GetUserInfo(TokenInfo{AccessToken: "secret", IDToken: idToken})
+	NoError(t, err)
+	Equal(t, model.UserInfo{Sub: "001234.abcdef.1234", Email: "john@example.com", Origin: "apple", Verified: true}, u)
+	Contains(t, rawJSON, `"email":"john@example.com"`)
+}
+
+func Test_Apple_getUserInfo_WithoutEmail(t *testing.T) {
+	// apple only returns the email on the first login
+	idToken := appleTestIDToken(t, jwt.MapClaims{
+		"iss": "https://appleid.apple.com",
+		"sub": "001234.abcdef.1234",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+
+	u, _, err := providerApple.GetUserInfo(TokenInfo{AccessToken: "secret", IDToken: idToken})
+	NoError(t, err)
+	Equal(t, model.UserInfo{Sub: "001234.abcdef.1234", Origin: "apple", Verified: true}, u)
+}
+
+func Test_Apple_getUserInfo_Errors(t *testing.T) {
+	for _, idToken := range []string{
+		"",
+		"foo",
+		appleTestIDToken(t, jwt.MapClaims{"iss": "https://example.com", "sub": "1234", "exp": time.Now().Add(time.Minute).Unix()}),
+		appleTestIDToken(t, jwt.MapClaims{"iss": "https://appleid.apple.com", "sub": "1234", "exp": time.Now().Add(-time.Minute).Unix()}),
+		appleTestIDToken(t, jwt.MapClaims{"iss": "https://appleid.apple.com", "exp": time.Now().Add(time.Minute).Unix()}),
+		appleTestIDToken(t, jwt.MapClaims{"iss": "https://appleid.apple.com", "sub": "1234", "email_verified": "maybe"}),
+	} {
+		_, _, err := providerApple.GetUserInfo(TokenInfo{AccessToken: "secret", IDToken: idToken})
+		Error(t, err)
+	}
+}
+
+func Test_Apple_StartFlow(t *testing.T) {
+	resp := httptest.NewRecorder()
+	cfg := testConfig
+	cfg.Provider = providerApple
+	StartFlow(cfg, resp)
+
+	location, err := url.Parse(resp.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, "form_post", location.Query().Get("response_mode"))
+
+	cookie := (&http.Response{Header: resp.Header()}).Cookies()[0]
+	Equal(t, stateCookieName, cookie.Name)
+	Equal(t, http.SameSiteNoneMode, cookie.SameSite)
+	True(t, cookie.Secure)
+}
+
+func Test_Apple_TokenExchange(t *testing.T) {
+	_, keyFile := writeAppleTestKey(t)
+	defer os.Remove(keyFile)
+
+	// mock a server for token exchange, which checks the generated client secret
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		Equal(t, "com.example.app", r.Form.Get("client_id"))
+		claims := jwt.StandardClaims{}
+		_, _, err := new(jwt.Parser).ParseUnverified(r.Form.Get("client_secret"), &claims)
+		NoError(t, err)
+		Equal(t, "TEAM123456", claims.Issuer)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"the-token","token_type":"bearer","id_token":"the-id-token"}`))
+	}))
+	defer server.Close()
+
+	m := NewManager()
+	err := m.AddConfig("apple", map[string]string{
+		"client_id":        "com.example.app",
+		"team_id":          "TEAM123456",
+		"key_id":           "KEY1234567",
+		"private_key_file": keyFile,
+	})
+	NoError(t, err)
+
+	cfg := m.GetConfigs()["apple"]
+	Equal(t, "", cfg.ClientSecret)
+	cfg.TokenURL = server.URL
+
+	tokenInfo, err := getAccessToken(context.Background(), cfg, "theState", "theCode", "")
+	NoError(t, err)
+	Equal(t, "the-id-token", tokenInfo.IDToken)
+}
+
+func Test_Apple_AddConfig_Error(t *testing.T) {
+	err := NewManager().AddConfig("apple", map[string]string{
+		"client_id":     "com.example.app",
+		"client_secret": "secret",
+	})
+	EqualError(t, err, "missing parameter team_id for apple provider")
+}
+
+func writeAppleTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	NoError(t, err)
+
+	f, err := ioutil.TempFile("", "loginsrv_apple_key")
+	NoError(t, err)
+	pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	f.Close()
+	return key, f.Name()
+}
+
+func appleTestIDToken(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	NoError(t, err)
+	return token
+}
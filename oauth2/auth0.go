@@ -0,0 +1,108 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// an auth0 domain is the tenant domain, e.g. example.eu.auth0.com, or a custom domain
+var auth0DomainPattern = regexp.MustCompile(`^[a-zA-Z0-9.-]+(:[0-9]+)?$`)
+
+func init() {
+	providerAuth0.NewFromOpts = newAuth0Provider
+	RegisterProvider(providerAuth0)
+}
+
+// auth0User is used for parsing the auth0 userinfo response
+type auth0User struct {
+	Sub           string `json:"sub"`
+	Name          string `json:"name"`
+	Nickname      string `json:"nickname"`
+	Picture       string `json:"picture"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// providerAuth0 has no urls, because every auth0 tenant has its own domain.
+// They are set by newAuth0Provider from the domain option.
+var providerAuth0 = Provider{
+	Name:          "auth0",
+	DefaultScopes: "openid profile email",
+}
+
+// newAuth0Provider creates an auth0 provider for the tenant or custom domain from the options.
+func newAuth0Provider(opts map[string]string) (Provider, error) {
+	domain, exist := opts["domain"]
+	if !exist {
+		return Provider{}, fmt.Errorf("missing parameter domain for auth0 provider")
+	}
+	if !auth0DomainPattern.MatchString(domain) {
+		return Provider{}, fmt.Errorf("invalid parameter value %q in \"domain\" for auth0 provider", domain)
+	}
+
+	baseURL := "https://" + domain
+	p := providerAuth0
+	p.AuthURL = baseURL + "/authorize"
+	p.TokenURL = baseURL + "/oauth/token"
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		return getAuth0UserInfo(baseURL+"/userinfo", token)
+	}
+	return p, nil
+}
+
+// getAuth0UserInfo maps the standard claims of the userinfo response.
+// Custom claims, added by rules or actions with a https:// namespace, are kept in the returned raw json.
+func getAuth0UserInfo(userinfoURL string, token TokenInfo) (model.UserInfo, string, error) {
+	au := auth0User{}
+
+	req, _ := http.NewRequest("GET", userinfoURL, nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return model.UserInfo{}, "", fmt.Errorf("wrong content-type on auth0 get user info: %v", resp.Header.Get("Content-Type"))
+	}
+
+	if resp.StatusCode != 200 {
+		return model.UserInfo{}, "", fmt.Errorf("got http status %v on auth0 get user info", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error reading auth0 get user info: %v", err)
+	}
+
+	err = json.Unmarshal(b, &au)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error parsing auth0 get user info: %v", err)
+	}
+
+	if au.Sub == "" {
+		return model.UserInfo{}, "", &MissingFieldError{Provider: "auth0", Field: "sub"}
+	}
+
+	name := au.Name
+	if name == "" {
+		name = au.Nickname
+	}
+
+	return model.UserInfo{
+		Sub:      au.Sub,
+		Picture:  au.Picture,
+		Name:     name,
+		Email:    au.Email,
+		Origin:   "auth0",
+		Verified: au.EmailVerified,
+	}, string(b), nil
+}
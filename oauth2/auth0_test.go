@@ -0,0 +1,97 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var auth0TestUserResponse = `{
+  "sub": "auth0|5f7c8ec7c33c6c004bbafe82",
+  "nickname": "jdoe",
+  "name": "Jane Doe",
+  "picture": "https://s.gravatar.com/avatar/jdoe.png",
+  "updated_at": "2020-10-06T15:33:48.920Z",
+  "email": "jane.doe@example.com",
+  "email_verified": true,
+  "https://example.com/roles": ["admin", "editor"]
+}`
+
+func Test_Auth0_getUserInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "/userinfo", r.URL.Path)
+		Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(auth0TestUserResponse))
+	}))
+	defer server.Close()
+
+	u, rawJSON, err := getAuth0UserInfo(server.URL+"/userinfo", TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:      "auth0|5f7c8ec7c33c6c004bbafe82",
+		Picture:  "https://s.gravatar.com/avatar/jdoe.png",
+		Name:     "Jane Doe",
+		Email:    "jane.doe@example.com",
+		Origin:   "auth0",
+		Verified: true,
+	}, u)
+
+	// the namespaced custom claims are passed through
+	raw := map[string]interface{}{}
+	NoError(t, json.Unmarshal([]byte(rawJSON), &raw))
+	Equal(t, []interface{}{"admin", "editor"}, raw["https://example.com/roles"])
+}
+
+func Test_Auth0_getUserInfo_Errors(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		status      int
+		body        string
+	}{
+		{"text/html", 200, auth0TestUserResponse},
+		{"application/json", 401, `{"error": "invalid_token"}`},
+		{"application/json", 200, `{"name": "Jane Doe"}`},
+		{"application/json", 200, `not json`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		_, _, err := getAuth0UserInfo(server.URL, TokenInfo{AccessToken: "secret"})
+		Error(t, err)
+		server.Close()
+	}
+}
+
+func Test_Auth0_Domain(t *testing.T) {
+	m := NewManager()
+	NoError(t, m.AddConfig("auth0", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"domain":        "example.eu.auth0.com",
+	}))
+	cfg := m.GetConfigs()["auth0"]
+	Equal(t, "https://example.eu.auth0.com/authorize", cfg.AuthURL)
+	Equal(t, "https://example.eu.auth0.com/oauth/token", cfg.TokenURL)
+	Equal(t, "openid profile email", cfg.Scope)
+
+	err := m.AddConfig("auth0", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+	})
+	EqualError(t, err, "missing parameter domain for auth0 provider")
+
+	err = m.AddConfig("auth0", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"domain":        "https://login.example.com",
+	})
+	EqualError(t, err, `invalid parameter value "https://login.example.com" in "domain" for auth0 provider`)
+}
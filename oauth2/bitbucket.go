@@ -16,6 +16,7 @@ var bitbucketAPI = "https://api.bitbucket.org/2.0"
 var bitbucketAvatarURL = "https://bitbucket.org/account/%v/avatar/128/"
 
 func init() {
+	providerBitbucket.NewFromOpts = newBitbucketProvider
 	RegisterProvider(providerBitbucket)
 }
 
@@ -57,6 +58,16 @@ func (e *emails) getPrimaryEmailAddress() string {
 	return ""
 }
 
+// isPrimaryEmailConfirmed returns true, if the primary email address of the user is confirmed
+func (e *emails) isPrimaryEmailConfirmed() bool {
+	for _, val := range e.Values {
+		if val.IsPrimary {
+			return val.IsConfirmed
+		}
+	}
+	return false
+}
+
 // getBitbucketEmails Retrieves bitbucket user emails from the Bitbucket API emails service
 func getBitbucketEmails(token TokenInfo) (emails, error) {
 	emailUrl := fmt.Sprintf("%v/user/emails?access_token=%v", bitbucketAPI, token.AccessToken)
@@ -90,6 +101,47 @@ func getBitbucketEmails(token TokenInfo) (emails, error) {
 	return userEmails, nil
 }
 
+// workspaces is used to parse the workspaces of the user
+type workspaces struct {
+	Values []struct {
+		Slug string `json:"slug"`
+	} `json:"values"`
+}
+
+// getBitbucketWorkspaces retrieves the slugs of the workspaces, the user is a member of
+func getBitbucketWorkspaces(token TokenInfo) ([]string, error) {
+	workspacesURL := fmt.Sprintf("%v/workspaces?pagelen=100&access_token=%v", bitbucketAPI, token.AccessToken)
+	userWorkspaces := workspaces{}
+	resp, err := http.Get(workspacesURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, fmt.Errorf("wrong content-type on bitbucket get user workspaces: %v", resp.Header.Get("Content-Type"))
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("got http status %v on bitbucket get user workspaces", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bitbucket get user workspaces: %v", err)
+	}
+
+	if err := json.Unmarshal(b, &userWorkspaces); err != nil {
+		return nil, fmt.Errorf("error parsing bitbucket get user workspaces: %v", err)
+	}
+
+	slugs := make([]string, 0, len(userWorkspaces.Values))
+	for _, w := range userWorkspaces.Values {
+		slugs = append(slugs, w.Slug)
+	}
+	return slugs, nil
+}
+
 var providerBitbucket = Provider{
 	Name:     "bitbucket",
 	AuthURL:  "https://bitbucket.org/site/oauth2/authorize",
@@ -122,14 +174,67 @@ var providerBitbucket = Provider{
 			return model.UserInfo{}, "", fmt.Errorf("error parsing bitbucket get user info: %v", err)
 		}
 
+		if gu.Username == "" {
+			return model.UserInfo{}, "", &MissingFieldError{Provider: "bitbucket", Field: "username"}
+		}
+
 		userEmails, err := getBitbucketEmails(token)
 
 		return model.UserInfo{
-			Sub:     gu.Username,
-			Picture: fmt.Sprintf(bitbucketAvatarURL, gu.Username),
-			Name:    gu.DisplayName,
-			Email:   userEmails.getPrimaryEmailAddress(),
-			Origin:  "bitbucket",
+			Sub:      gu.Username,
+			Picture:  fmt.Sprintf(bitbucketAvatarURL, gu.Username),
+			Name:     gu.DisplayName,
+			Email:    userEmails.getPrimaryEmailAddress(),
+			Origin:   "bitbucket",
+			Verified: userEmails.isPrimaryEmailConfirmed(),
 		}, string(b), nil
 	},
 }
+
+// newBitbucketProvider creates a bitbucket provider, which only accepts members of the workspaces from the options.
+// The workspaces are separated by ';'. The slugs of all workspaces of the user are returned as groups.
+// Without a workspace option, all bitbucket users are accepted.
+func newBitbucketProvider(opts map[string]string) (Provider, error) {
+	workspaceOpt, exist := opts["workspace"]
+	if !exist {
+		return providerBitbucket, nil
+	}
+
+	allowed := map[string]bool{}
+	for _, workspace := range strings.Split(workspaceOpt, ";") {
+		if workspace == "" {
+			return Provider{}, fmt.Errorf("invalid parameter value %q in \"workspace\" for bitbucket provider", workspaceOpt)
+		}
+		allowed[strings.ToLower(workspace)] = true
+	}
+
+	p := providerBitbucket
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		u, rawJSON, err := providerBitbucket.GetUserInfo(token)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+
+		slugs, err := getBitbucketWorkspaces(token)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+		member := false
+		for _, slug := range slugs {
+			if allowed[strings.ToLower(slug)] {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return model.UserInfo{}, "", &AccessDeniedError{
+				Provider: "bitbucket",
+				Reason:   fmt.Sprintf("%v is not a member of an allowed workspace", u.Sub),
+			}
+		}
+
+		u.Groups = slugs
+		return u, rawJSON, nil
+	}
+	return p, nil
+}
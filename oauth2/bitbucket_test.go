@@ -76,6 +76,24 @@ var bitbucketTestUserEmailResponse = `{
   ]
 }`
 
+var bitbucketTestWorkspacesResponse = `{
+  "page": 1,
+  "pagelen": 100,
+  "size": 2,
+  "values": [
+    {
+      "slug": "tutorials",
+      "name": "tutorials",
+      "type": "workspace"
+    },
+    {
+      "slug": "atlassian-team",
+      "name": "Atlassian Team",
+      "type": "workspace"
+    }
+  ]
+}`
+
 // BitbucketTestSuite Model for the bitbucket test suite
 type BitbucketTestSuite struct {
 	suite.Suite
@@ -98,8 +116,15 @@ func (suite *BitbucketTestSuite) SetupTest() {
 		w.Write([]byte(bitbucketTestUserEmailResponse))
 	})
 
+	workspacesHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("secret", r.FormValue("access_token"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(bitbucketTestWorkspacesResponse))
+	})
+
 	r.HandleFunc("/user", userHandler)
 	r.HandleFunc("/user/emails", emailHandler)
+	r.HandleFunc("/workspaces", workspacesHandler)
 
 	suite.Server = httptest.NewServer(r)
 }
@@ -114,9 +139,31 @@ func (suite *BitbucketTestSuite) Test_Bitbucket_getUserInfo() {
 	suite.Equal("tutorials", u.Sub)
 	suite.Equal("tutorials@bitbucket.com", u.Email)
 	suite.Equal("tutorials account", u.Name)
+	suite.True(u.Verified)
 	suite.Equal(bitbucketTestUserResponse, rawJSON)
 }
 
+// Test_Bitbucket_Workspace Tests the workspace option restricts the login and returns the workspaces as groups
+func (suite *BitbucketTestSuite) Test_Bitbucket_Workspace() {
+	bitbucketAPI = suite.Server.URL
+
+	p, err := newBitbucketProvider(map[string]string{"workspace": "other;Atlassian-Team"})
+	suite.NoError(err)
+	u, _, err := p.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	suite.NoError(err)
+	suite.Equal("tutorials", u.Sub)
+	suite.Equal([]string{"tutorials", "atlassian-team"}, u.Groups)
+
+	p, err = newBitbucketProvider(map[string]string{"workspace": "other"})
+	suite.NoError(err)
+	_, _, err = p.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	suite.IsType(&AccessDeniedError{}, err)
+	suite.EqualError(err, "access denied by bitbucket provider options: tutorials is not a member of an allowed workspace")
+
+	_, err = newBitbucketProvider(map[string]string{"workspace": "other;"})
+	suite.Error(err)
+}
+
 // Test_Bitbucket_getPrimaryEmailAddress Tests the returned primary email is the expected email
 func (suite *BitbucketTestSuite) Test_Bitbucket_getPrimaryEmailAddress()  {
 	userEmails := emails{}
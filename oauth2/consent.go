@@ -0,0 +1,61 @@
+package oauth2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/tracing"
+)
+
+// ConsentRecord is sent to the consent record url after each successful oauth callback.
+// It documents which scopes the user has consented to and when.
+type ConsentRecord struct {
+	Sub         string `json:"sub"`
+	Origin      string `json:"origin"`
+	Scope       string `json:"scope"`
+	Prompt      string `json:"prompt,omitempty"`
+	ConsentedAt int64  `json:"consented_at"`
+}
+
+var consentRecordClient = &http.Client{Timeout: defaultTimeout, Transport: &tracing.Transport{}}
+
+// recordConsent posts the consent of the user to the consent record url.
+// The scopes granted by the provider are preferred over the requested ones.
+func recordConsent(ctx context.Context, recordURL string, cfg Config, userInfo model.UserInfo, tokenInfo TokenInfo) error {
+	scope := tokenInfo.Scope
+	if scope == "" {
+		scope = cfg.Scope
+	}
+	record := ConsentRecord{
+		Sub:         userInfo.Sub,
+		Origin:      userInfo.Origin,
+		Scope:       scope,
+		Prompt:      cfg.Prompt,
+		ConsentedAt: time.Now().Unix(),
+	}
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest("POST", recordURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error recording oauth consent: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := consentRecordClient.Do(r.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error recording oauth consent: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error recording oauth consent: got http status %v", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package oauth2_test
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/oauth2"
+)
+
+// The provider of an internal identity provider is registered in the init function of its package.
+// The package is imported by a custom build of loginsrv and the provider is configured with
+// -myidp client_id=..,client_secret=..
+func ExampleRegisterProvider() {
+	oauth2.RegisterProvider(oauth2.Provider{
+		Name:          "myidp",
+		AuthURL:       "https://idp.example.com/oauth/authorize",
+		TokenURL:      "https://idp.example.com/oauth/token",
+		DefaultScopes: "openid profile email",
+		GetUserInfo: func(token oauth2.TokenInfo) (model.UserInfo, string, error) {
+			req, err := http.NewRequest("GET", "https://idp.example.com/userinfo", nil)
+			if err != nil {
+				return model.UserInfo{}, "", err
+			}
+			req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return model.UserInfo{}, "", err
+			}
+			defer resp.Body.Close()
+
+			raw := map[string]interface{}{}
+			if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+				return model.UserInfo{}, "", err
+			}
+			rawJSON, _ := json.Marshal(raw)
+			username, _ := raw["preferred_username"].(string)
+			email, _ := raw["email"].(string)
+			return model.UserInfo{
+				Sub:    username,
+				Email:  email,
+				Origin: "myidp",
+			}, string(rawJSON), nil
+		},
+	})
+}
@@ -1,6 +1,9 @@
 package oauth2
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -13,6 +16,7 @@ import (
 var facebookAPI = "https://graph.facebook.com/v2.12"
 
 func init() {
+	providerfacebook.NewFromOpts = newFacebookProvider
 	RegisterProvider(providerfacebook)
 }
 
@@ -34,46 +38,84 @@ var providerfacebook = Provider{
 	TokenURL:      "https://graph.facebook.com/v2.12/oauth/access_token",
 	DefaultScopes: "email",
 	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
-		fu := facebookUser{}
-
-		url := fmt.Sprintf("%v/me?access_token=%v&fields=name,email,id,picture", facebookAPI, token.AccessToken)
-
-		// For facebook return an application/json Content-type the Accept header should be set as 'application/json'
-		client := &http.Client{}
-		contentType := "application/json"
-		req, _ := http.NewRequest("GET", url, nil)
-		req.Header.Set("Accept", contentType)
-		resp, err := client.Do(req)
-
-		if err != nil {
-			return model.UserInfo{}, "", err
-		}
-		defer resp.Body.Close()
-
-		if !strings.Contains(resp.Header.Get("Content-Type"), contentType) {
-			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on facebook get user info: %v", resp.Header.Get("Content-Type"))
-		}
-
-		if resp.StatusCode != 200 {
-			return model.UserInfo{}, "", fmt.Errorf("got http status %v on facebook get user info", resp.StatusCode)
-		}
-
-		b, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return model.UserInfo{}, "", fmt.Errorf("error reading facebook get user info: %v", err)
-		}
-
-		err = json.Unmarshal(b, &fu)
-		if err != nil {
-			return model.UserInfo{}, "", fmt.Errorf("error parsing facebook get user info: %v", err)
-		}
-
-		return model.UserInfo{
-			Sub:     fu.UserID,
-			Picture: fu.Picture.Data.URL,
-			Name:    fu.Name,
-			Email:   fu.Email,
-			Origin:  "facebook",
-		}, string(b), nil
+		return getFacebookUserInfo(token, "")
 	},
 }
+
+// newFacebookProvider creates a facebook provider, which signs the graph api calls
+// with an appsecret_proof of the client_secret. This is required, if the app has
+// "Require App Secret" enabled in its advanced settings.
+func newFacebookProvider(opts map[string]string) (Provider, error) {
+	appSecret, exist := opts["client_secret"]
+	if !exist {
+		return providerfacebook, nil
+	}
+
+	p := providerfacebook
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		return getFacebookUserInfo(token, appSecret)
+	}
+	return p, nil
+}
+
+// appSecretProof returns the hex encoded HMAC-SHA256 of the access token, keyed with the app secret
+func appSecretProof(accessToken, appSecret string) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write([]byte(accessToken))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getFacebookUserInfo reads the profile from the graph api.
+// If the app secret is not empty, the request contains the appsecret_proof.
+func getFacebookUserInfo(token TokenInfo, appSecret string) (model.UserInfo, string, error) {
+	fu := facebookUser{}
+
+	url := fmt.Sprintf("%v/me?access_token=%v&fields=name,email,id,picture", facebookAPI, token.AccessToken)
+	if appSecret != "" {
+		url += "&appsecret_proof=" + appSecretProof(token.AccessToken, appSecret)
+	}
+
+	// For facebook return an application/json Content-type the Accept header should be set as 'application/json'
+	client := &http.Client{}
+	contentType := "application/json"
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept", contentType)
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), contentType) {
+		return model.UserInfo{}, "", fmt.Errorf("wrong content-type on facebook get user info: %v", resp.Header.Get("Content-Type"))
+	}
+
+	if resp.StatusCode != 200 {
+		return model.UserInfo{}, "", fmt.Errorf("got http status %v on facebook get user info", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error reading facebook get user info: %v", err)
+	}
+
+	err = json.Unmarshal(b, &fu)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error parsing facebook get user info: %v", err)
+	}
+
+	if fu.UserID == "" {
+		return model.UserInfo{}, "", &MissingFieldError{Provider: "facebook", Field: "id"}
+	}
+
+	return model.UserInfo{
+		Sub:     fu.UserID,
+		Picture: fu.Picture.Data.URL,
+		Name:    fu.Name,
+		Email:   fu.Email,
+		Origin:  "facebook",
+		// facebook does not return a verification status of the account
+		Verified: true,
+	}, string(b), nil
+}
@@ -66,3 +66,24 @@ func Test_Facebook_getUserInfo_WrongStatus(t *testing.T) {
 	_, _, err := providerfacebook.GetUserInfo(TokenInfo{AccessToken: "secret"})
 	Error(t, err)
 }
+
+func Test_Facebook_AppSecretProof(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "secret", r.FormValue("access_token"))
+		Equal(t, "45cd0a7fe0fefdb8ffcc268e6fe09eeffc78620a029ba6308ea76de4cea379a6", r.FormValue("appsecret_proof"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(facebookTestUserResponse))
+	}))
+	defer server.Close()
+
+	facebookAPI = server.URL
+
+	m := NewManager()
+	NoError(t, m.AddConfig("facebook", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "appsecret",
+	}))
+	u, _, err := m.GetConfigs()["facebook"].Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "23456789012345678", u.Sub)
+}
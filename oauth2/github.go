@@ -13,6 +13,7 @@ import (
 var githubAPI = "https://api.github.com"
 
 func init() {
+	providerGithub.NewFromOpts = newGithubProvider
 	RegisterProvider(providerGithub)
 }
 
@@ -24,6 +25,17 @@ type GithubUser struct {
 	Email     string `json:"email,omitempty"`
 }
 
+// githubOrg is used for parsing the organizations of the github user
+type githubOrg struct {
+	Login string `json:"login"`
+}
+
+// githubTeam is used for parsing the teams of the github user
+type githubTeam struct {
+	Slug         string    `json:"slug"`
+	Organization githubOrg `json:"organization"`
+}
+
 var providerGithub = Provider{
 	Name:     "github",
 	AuthURL:  "https://github.com/login/oauth/authorize",
@@ -55,12 +67,106 @@ var providerGithub = Provider{
 			return model.UserInfo{}, "", fmt.Errorf("error parsing github get user info: %v", err)
 		}
 
+		if gu.Login == "" {
+			return model.UserInfo{}, "", &MissingFieldError{Provider: "github", Field: "login"}
+		}
+
 		return model.UserInfo{
 			Sub:     gu.Login,
 			Picture: gu.AvatarURL,
 			Name:    gu.Name,
 			Email:   gu.Email,
 			Origin:  "github",
+			// github does not return a verification status of the account
+			Verified: true,
 		}, string(b), nil
 	},
 }
+
+// newGithubProvider creates a github provider, which only accepts members of the organizations from the options.
+// The organizations are separated by ';'. The teams of the user in these organizations
+// are returned as groups in the form org/team-slug. Without an org option, all github users are accepted.
+func newGithubProvider(opts map[string]string) (Provider, error) {
+	orgOpt, exist := opts["org"]
+	if !exist {
+		return providerGithub, nil
+	}
+
+	orgs := map[string]bool{}
+	for _, org := range strings.Split(orgOpt, ";") {
+		if org == "" {
+			return Provider{}, fmt.Errorf("invalid parameter value %q in \"org\" for github provider", orgOpt)
+		}
+		orgs[strings.ToLower(org)] = true
+	}
+
+	p := providerGithub
+	// the private memberships of the user are only visible with the read:org scope
+	p.DefaultScopes = "read:org"
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		u, rawJSON, err := providerGithub.GetUserInfo(token)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+
+		userOrgs := []githubOrg{}
+		if err := getGithubList("/user/orgs", token, &userOrgs); err != nil {
+			return model.UserInfo{}, "", err
+		}
+		member := false
+		for _, org := range userOrgs {
+			if orgs[strings.ToLower(org.Login)] {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return model.UserInfo{}, "", &AccessDeniedError{
+				Provider: "github",
+				Reason:   fmt.Sprintf("%v is not a member of an allowed organization", u.Sub),
+			}
+		}
+
+		teams := []githubTeam{}
+		if err := getGithubList("/user/teams", token, &teams); err != nil {
+			return model.UserInfo{}, "", err
+		}
+		for _, team := range teams {
+			if orgs[strings.ToLower(team.Organization.Login)] {
+				u.Groups = append(u.Groups, team.Organization.Login+"/"+team.Slug)
+			}
+		}
+		return u, rawJSON, nil
+	}
+	return p, nil
+}
+
+// getGithubList fetches the first 100 entries of a list resource of the authenticated user
+func getGithubList(path string, token TokenInfo, v interface{}) error {
+	req, _ := http.NewRequest("GET", githubAPI+path+"?per_page=100", nil)
+	req.Header.Set("Authorization", "token "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return fmt.Errorf("wrong content-type on github get %v: %v", path, resp.Header.Get("Content-Type"))
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got http status %v on github get %v", resp.StatusCode, path)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading github get %v: %v", path, err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("error parsing github get %v: %v", path, err)
+	}
+	return nil
+}
@@ -57,3 +57,103 @@ func Test_Github_getUserInfo(t *testing.T) {
 	Equal(t, "monalisa octocat", u.Name)
 	Equal(t, githubTestUserResponse, rawJSON)
 }
+
+func Test_Github_getUserInfo_MissingLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"id": 1, "name": "monalisa octocat"}`))
+	}))
+	defer server.Close()
+
+	githubAPI = server.URL
+
+	_, _, err := providerGithub.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	Equal(t, &MissingFieldError{Provider: "github", Field: "login"}, err)
+	EqualError(t, err, `invalid github response: missing field "login"`)
+}
+
+func Test_Github_Org(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(githubTestUserResponse))
+		case "/user/orgs":
+			Equal(t, "token secret", r.Header.Get("Authorization"))
+			w.Write([]byte(`[{"login": "github"}, {"login": "octo-org"}]`))
+		case "/user/teams":
+			Equal(t, "token secret", r.Header.Get("Authorization"))
+			w.Write([]byte(`[
+			  {"slug": "justice-league", "organization": {"login": "github"}},
+			  {"slug": "admins", "organization": {"login": "other-org"}}
+			]`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+	githubAPI = server.URL
+
+	m := NewManager()
+	NoError(t, m.AddConfig("github", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"org":           "GitHub",
+	}))
+	cfg := m.GetConfigs()["github"]
+	Equal(t, "read:org", cfg.Scope)
+
+	u, rawJSON, err := cfg.Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "octocat", u.Sub)
+	Equal(t, []string{"github/justice-league"}, u.Groups)
+	Equal(t, githubTestUserResponse, rawJSON)
+
+	NoError(t, m.AddConfig("github", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"org":           "acme;example",
+	}))
+	cfg = m.GetConfigs()["github"]
+
+	_, _, err = cfg.Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	IsType(t, &AccessDeniedError{}, err)
+	EqualError(t, err, `access denied by github provider options: octocat is not a member of an allowed organization`)
+
+	err = m.AddConfig("github", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"org":           "acme;",
+	})
+	Error(t, err)
+}
+
+func Test_Github_Org_Errors(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		status      int
+		body        string
+	}{
+		{"text/html", 200, `[]`},
+		{"application/json", 403, `{"message": "Forbidden"}`},
+		{"application/json", 200, `not json`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/user" {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(githubTestUserResponse))
+				return
+			}
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		githubAPI = server.URL
+
+		p, err := newGithubProvider(map[string]string{"org": "github"})
+		NoError(t, err)
+		_, _, err = p.GetUserInfo(TokenInfo{AccessToken: "secret"})
+		Error(t, err)
+		server.Close()
+	}
+}
@@ -13,6 +13,7 @@ import (
 var gitlabAPI = "https://gitlab.com/api/v4"
 
 func init() {
+	providerGitlab.NewFromOpts = newGitlabProvider
 	RegisterProvider(providerGitlab)
 }
 
@@ -33,74 +34,106 @@ var providerGitlab = Provider{
 	AuthURL:  "https://gitlab.com/oauth/authorize",
 	TokenURL: "https://gitlab.com/oauth/token",
 	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
-		gu := GitlabUser{}
-		url := fmt.Sprintf("%v/user?access_token=%v", gitlabAPI, token.AccessToken)
-
-		var respUser *http.Response
-		respUser, err := http.Get(url)
-		if err != nil {
-			return model.UserInfo{}, "", err
-		}
-		defer respUser.Body.Close()
-
-		if !strings.Contains(respUser.Header.Get("Content-Type"), "application/json") {
-			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on gitlab get user info: %v", respUser.Header.Get("Content-Type"))
-		}
-
-		if respUser.StatusCode != 200 {
-			return model.UserInfo{}, "", fmt.Errorf("got http status %v on gitlab get user info", respUser.StatusCode)
-		}
-
-		b, err := ioutil.ReadAll(respUser.Body)
-		if err != nil {
-			return model.UserInfo{}, "", fmt.Errorf("error reading gitlab get user info: %v", err)
-		}
-
-		err = json.Unmarshal(b, &gu)
-		if err != nil {
-			return model.UserInfo{}, "", fmt.Errorf("error parsing gitlab get user info: %v", err)
-		}
-
-		gg := []*GitlabGroup{}
-		url = fmt.Sprintf("%v/groups?access_token=%v", gitlabAPI, token.AccessToken)
-
-		var respGroup *http.Response
-		respGroup, err = http.Get(url)
-		if err != nil {
-			return model.UserInfo{}, "", err
-		}
-		defer respGroup.Body.Close()
-
-		if !strings.Contains(respGroup.Header.Get("Content-Type"), "application/json") {
-			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on gitlab get groups info: %v", respGroup.Header.Get("Content-Type"))
-		}
-
-		if respGroup.StatusCode != 200 {
-			return model.UserInfo{}, "", fmt.Errorf("got http status %v on gitlab get groups info", respGroup.StatusCode)
-		}
-
-		g, err := ioutil.ReadAll(respGroup.Body)
-		if err != nil {
-			return model.UserInfo{}, "", fmt.Errorf("error reading gitlab get groups info: %v", err)
-		}
+		return getGitlabUserInfo(gitlabAPI, token)
+	},
+}
 
-		err = json.Unmarshal(g, &gg)
-		if err != nil {
-			return model.UserInfo{}, "", fmt.Errorf("error parsing gitlab get groups info: %v", err)
-		}
+// newGitlabProvider creates a gitlab provider for a self-hosted instance, if the base_url option is set.
+func newGitlabProvider(opts map[string]string) (Provider, error) {
+	baseURL, exist := opts["base_url"]
+	if !exist {
+		return providerGitlab, nil
+	}
+	if !strings.HasPrefix(baseURL, "https://") && !strings.HasPrefix(baseURL, "http://") {
+		return Provider{}, fmt.Errorf("invalid parameter value %q in \"base_url\" for gitlab provider", baseURL)
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	p := providerGitlab
+	p.AuthURL = baseURL + "/oauth/authorize"
+	p.TokenURL = baseURL + "/oauth/token"
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		return getGitlabUserInfo(baseURL+"/api/v4", token)
+	}
+	return p, nil
+}
 
-		groups := make([]string, len(gg))
-		for i := 0; i < len(gg); i++ {
-			groups[i] = gg[i].FullPath
+func getGitlabUserInfo(apiURL string, token TokenInfo) (model.UserInfo, string, error) {
+	gu := GitlabUser{}
+	url := fmt.Sprintf("%v/user?access_token=%v", apiURL, token.AccessToken)
+
+	var respUser *http.Response
+	respUser, err := http.Get(url)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	defer respUser.Body.Close()
+
+	if !strings.Contains(respUser.Header.Get("Content-Type"), "application/json") {
+		return model.UserInfo{}, "", fmt.Errorf("wrong content-type on gitlab get user info: %v", respUser.Header.Get("Content-Type"))
+	}
+
+	if respUser.StatusCode != 200 {
+		return model.UserInfo{}, "", fmt.Errorf("got http status %v on gitlab get user info", respUser.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(respUser.Body)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error reading gitlab get user info: %v", err)
+	}
+
+	err = json.Unmarshal(b, &gu)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error parsing gitlab get user info: %v", err)
+	}
+
+	if gu.Username == "" {
+		return model.UserInfo{}, "", &MissingFieldError{Provider: "gitlab", Field: "username"}
+	}
+
+	gg := []GitlabGroup{}
+	url = fmt.Sprintf("%v/groups?access_token=%v", apiURL, token.AccessToken)
+
+	var respGroup *http.Response
+	respGroup, err = http.Get(url)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	defer respGroup.Body.Close()
+
+	if !strings.Contains(respGroup.Header.Get("Content-Type"), "application/json") {
+		return model.UserInfo{}, "", fmt.Errorf("wrong content-type on gitlab get groups info: %v", respGroup.Header.Get("Content-Type"))
+	}
+
+	if respGroup.StatusCode != 200 {
+		return model.UserInfo{}, "", fmt.Errorf("got http status %v on gitlab get groups info", respGroup.StatusCode)
+	}
+
+	g, err := ioutil.ReadAll(respGroup.Body)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error reading gitlab get groups info: %v", err)
+	}
+
+	err = json.Unmarshal(g, &gg)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error parsing gitlab get groups info: %v", err)
+	}
+
+	groups := make([]string, 0, len(gg))
+	for _, group := range gg {
+		if group.FullPath != "" {
+			groups = append(groups, group.FullPath)
 		}
-
-		return model.UserInfo{
-			Sub:     gu.Username,
-			Picture: gu.AvatarURL,
-			Name:    gu.Name,
-			Email:   gu.Email,
-			Groups:  groups,
-			Origin:  "gitlab",
-		}, `{"user":` + string(b) + `,"groups":` + string(g) + `}`, nil
-	},
+	}
+
+	return model.UserInfo{
+		Sub:     gu.Username,
+		Picture: gu.AvatarURL,
+		Name:    gu.Name,
+		Email:   gu.Email,
+		Groups:  groups,
+		Origin:  "gitlab",
+		// gitlab does not return a verification status of the account
+		Verified: true,
+	}, `{"user":` + string(b) + `,"groups":` + string(g) + `}`, nil
 }
@@ -255,3 +255,76 @@ func Test_Gitlab_getUserInfo_GroupsJSONNegative(t *testing.T) {
 	Error(t, err)
 	Regexp(t, regexp.MustCompile(`^error parsing gitlab get groups info`), err.Error())
 }
+
+func Test_Gitlab_getUserInfo_NullGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if r.URL.Path == "/user" {
+			w.Write([]byte(gitlabTestUserResponse))
+		} else if r.URL.Path == "/groups" {
+			w.Write([]byte(`[null, {"full_path": "example"}]`))
+		}
+	}))
+	defer server.Close()
+
+	gitlabAPI = server.URL
+
+	u, _, err := providerGitlab.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, []string{"example"}, u.Groups)
+}
+
+func Test_Gitlab_getUserInfo_MissingUsername(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer server.Close()
+
+	gitlabAPI = server.URL
+
+	_, _, err := providerGitlab.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	Equal(t, &MissingFieldError{Provider: "gitlab", Field: "username"}, err)
+}
+
+func Test_Gitlab_SelfHosted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if r.URL.Path == "/api/v4/user" {
+			w.Write([]byte(gitlabTestUserResponse))
+		} else if r.URL.Path == "/api/v4/groups" {
+			w.Write([]byte(gitlabTestGroupsResponse))
+		}
+	}))
+	defer server.Close()
+
+	m := NewManager()
+	err := m.AddConfig("gitlab", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"base_url":      server.URL + "/",
+	})
+	NoError(t, err)
+
+	cfg := m.GetConfigs()["gitlab"]
+	Equal(t, server.URL+"/oauth/authorize", cfg.AuthURL)
+	Equal(t, server.URL+"/oauth/token", cfg.TokenURL)
+
+	u, _, err := cfg.Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "john_smith", u.Sub)
+	Equal(t, "john@example.com", u.Email)
+	Equal(t, []string{"example", "example/subgroup"}, u.Groups)
+
+	// the default provider is not changed
+	Equal(t, "https://gitlab.com/oauth/authorize", providerGitlab.AuthURL)
+}
+
+func Test_Gitlab_SelfHosted_InvalidURL(t *testing.T) {
+	err := NewManager().AddConfig("gitlab", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"base_url":      "gitlab.example.com",
+	})
+	EqualError(t, err, `invalid parameter value "gitlab.example.com" in "base_url" for gitlab provider`)
+}
@@ -13,6 +13,7 @@ import (
 var googleUserinfoEndpoint = "https://www.googleapis.com/oauth2/v3/userinfo"
 
 func init() {
+	providerGoogle.NewFromOpts = newGoogleProvider
 	RegisterProvider(providerGoogle)
 }
 
@@ -58,20 +59,55 @@ var providerGoogle = Provider{
 		}
 
 		if len(gu.Email) == 0 {
-			return model.UserInfo{}, "", fmt.Errorf("invalid google response: no email address returned.")
-		}
-
-		if !gu.EmailVerified {
-			return model.UserInfo{}, "", fmt.Errorf("invalid google response: users email address not verified by google.")
+			return model.UserInfo{}, "", &MissingFieldError{Provider: "google", Field: "email"}
 		}
 
 		return model.UserInfo{
-			Sub:     gu.Email,
-			Picture: gu.Picture,
-			Name:    gu.Name,
-			Email:   gu.Email,
-			Origin:  "google",
-			Domain:  gu.HostedGsuiteDomain,
+			Sub:      gu.Email,
+			Picture:  gu.Picture,
+			Name:     gu.Name,
+			Email:    gu.Email,
+			Origin:   "google",
+			Verified: gu.EmailVerified,
+			Domain:   gu.HostedGsuiteDomain,
 		}, string(b), nil
 	},
 }
+
+// newGoogleProvider creates a google provider, which only accepts users of the hosted domains from the options.
+// The domains are separated by ';'. Without a domain option, all google accounts are accepted.
+func newGoogleProvider(opts map[string]string) (Provider, error) {
+	domainOpt, exist := opts["domain"]
+	if !exist {
+		return providerGoogle, nil
+	}
+
+	domains := map[string]bool{}
+	for _, domain := range strings.Split(domainOpt, ";") {
+		if domain == "" {
+			return Provider{}, fmt.Errorf("invalid parameter value %q in \"domain\" for google provider", domainOpt)
+		}
+		domains[strings.ToLower(domain)] = true
+	}
+
+	p := providerGoogle
+	// the hd parameter only preselects the accounts, the domain is verified with the user info
+	p.AuthParams = map[string]string{"hd": "*"}
+	if len(domains) == 1 {
+		p.AuthParams["hd"] = strings.ToLower(domainOpt)
+	}
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		u, rawJSON, err := providerGoogle.GetUserInfo(token)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+		if !domains[strings.ToLower(u.Domain)] {
+			return model.UserInfo{}, "", &AccessDeniedError{
+				Provider: "google",
+				Reason:   fmt.Sprintf("hosted domain %q of %v is not allowed", u.Domain, u.Sub),
+			}
+		}
+		return u, rawJSON, nil
+	}
+	return p, nil
+}
@@ -39,5 +39,76 @@ func Test_Google_getUserInfo(t *testing.T) {
 	Equal(t, "https://lh6.googleusercontent.com/-alknmlknzT_YQ/AAAAAAAAAAI/AAAAAAAAABU/4gNvDUeED14/photo.jpg", u.Picture)
 	Equal(t, "Testy Test", u.Name)
 	Equal(t, "example.com", u.Domain)
+	True(t, u.Verified)
 	Equal(t, googleTestUserResponse, rawJSON)
 }
+
+func Test_Google_getUserInfo_Unverified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"email": "test@example.com", "email_verified": false}`))
+	}))
+	defer server.Close()
+
+	googleUserinfoEndpoint = server.URL
+
+	u, _, err := providerGoogle.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "test@example.com", u.Sub)
+	False(t, u.Verified)
+}
+
+func Test_Google_Domain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(googleTestUserResponse))
+	}))
+	defer server.Close()
+	googleUserinfoEndpoint = server.URL
+
+	m := NewManager()
+	NoError(t, m.AddConfig("google", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"domain":        "Example.com",
+	}))
+	cfg := m.GetConfigs()["google"]
+	Equal(t, map[string]string{"hd": "example.com"}, cfg.Provider.AuthParams)
+
+	u, _, err := cfg.Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "example.com", u.Domain)
+
+	NoError(t, m.AddConfig("google", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"domain":        "example.org;example.net",
+	}))
+	cfg = m.GetConfigs()["google"]
+	Equal(t, map[string]string{"hd": "*"}, cfg.Provider.AuthParams)
+
+	_, _, err = cfg.Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	IsType(t, &AccessDeniedError{}, err)
+	EqualError(t, err, `access denied by google provider options: hosted domain "example.com" of test@example.com is not allowed`)
+
+	err = m.AddConfig("google", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"domain":        "example.org;",
+	})
+	Error(t, err)
+}
+
+func Test_Google_Domain_ConsumerAccount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"email": "test@gmail.com", "email_verified": true}`))
+	}))
+	defer server.Close()
+	googleUserinfoEndpoint = server.URL
+
+	p, err := newGoogleProvider(map[string]string{"domain": "example.com"})
+	NoError(t, err)
+	_, _, err = p.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	IsType(t, &AccessDeniedError{}, err)
+}
@@ -0,0 +1,172 @@
+package oauth2
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// a keycloak realm name is used as path segment of the realm urls
+var keycloakRealmPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+func init() {
+	providerKeycloak.NewFromOpts = newKeycloakProvider
+	RegisterProvider(providerKeycloak)
+}
+
+// keycloakRoles holds the role claims of keycloak, which are contained in the access token
+// and, if the role mappers are configured for it, in the userinfo response.
+type keycloakRoles struct {
+	RealmAccess struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+	ResourceAccess map[string]struct {
+		Roles []string `json:"roles"`
+	} `json:"resource_access"`
+}
+
+// keycloakUser is used for parsing the keycloak userinfo response
+type keycloakUser struct {
+	keycloakRoles
+	Sub               string `json:"sub"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+}
+
+// providerKeycloak has no urls, because every keycloak server and realm has its own urls.
+// They are set by newKeycloakProvider from the base_url and realm options.
+var providerKeycloak = Provider{
+	Name:          "keycloak",
+	DefaultScopes: "openid profile email",
+}
+
+// newKeycloakProvider creates a keycloak provider for the realm of the server from the options.
+// The base_url has to contain the /auth prefix for keycloak versions before 17.
+func newKeycloakProvider(opts map[string]string) (Provider, error) {
+	baseURL, exist := opts["base_url"]
+	if !exist {
+		return Provider{}, fmt.Errorf("missing parameter base_url for keycloak provider")
+	}
+	if !strings.HasPrefix(baseURL, "https://") && !strings.HasPrefix(baseURL, "http://") {
+		return Provider{}, fmt.Errorf("invalid parameter value %q in \"base_url\" for keycloak provider", baseURL)
+	}
+
+	realm, exist := opts["realm"]
+	if !exist {
+		return Provider{}, fmt.Errorf("missing parameter realm for keycloak provider")
+	}
+	if !keycloakRealmPattern.MatchString(realm) {
+		return Provider{}, fmt.Errorf("invalid parameter value %q in \"realm\" for keycloak provider", realm)
+	}
+
+	realmURL := strings.TrimSuffix(baseURL, "/") + "/realms/" + realm + "/protocol/openid-connect"
+	p := providerKeycloak
+	p.AuthURL = realmURL + "/auth"
+	p.TokenURL = realmURL + "/token"
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		return getKeycloakUserInfo(realmURL+"/userinfo", token)
+	}
+	return p, nil
+}
+
+// getKeycloakUserInfo maps the userinfo response. The realm roles are returned as groups and
+// together with the client roles, in the form client:role, as roles.
+// If the userinfo contains no roles, they are taken from the access token.
+func getKeycloakUserInfo(userinfoURL string, token TokenInfo) (model.UserInfo, string, error) {
+	ku := keycloakUser{}
+
+	req, _ := http.NewRequest("GET", userinfoURL, nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return model.UserInfo{}, "", fmt.Errorf("wrong content-type on keycloak get user info: %v", resp.Header.Get("Content-Type"))
+	}
+
+	if resp.StatusCode != 200 {
+		return model.UserInfo{}, "", fmt.Errorf("got http status %v on keycloak get user info", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error reading keycloak get user info: %v", err)
+	}
+
+	err = json.Unmarshal(b, &ku)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error parsing keycloak get user info: %v", err)
+	}
+
+	if ku.PreferredUsername == "" {
+		return model.UserInfo{}, "", &MissingFieldError{Provider: "keycloak", Field: "preferred_username"}
+	}
+
+	roles := ku.keycloakRoles
+	if len(roles.RealmAccess.Roles) == 0 && len(roles.ResourceAccess) == 0 {
+		roles = keycloakAccessTokenRoles(token.AccessToken)
+	}
+
+	return model.UserInfo{
+		Sub:      ku.PreferredUsername,
+		Picture:  ku.Picture,
+		Name:     ku.Name,
+		Email:    ku.Email,
+		Origin:   "keycloak",
+		Verified: ku.EmailVerified,
+		Groups:   roles.RealmAccess.Roles,
+		Roles:    roles.list(),
+	}, string(b), nil
+}
+
+// keycloakAccessTokenRoles reads the role claims from the payload of the access token.
+// The signature is not verified, because the token was received directly from the token endpoint of keycloak.
+// An access token, which is not a jwt, has no roles.
+func keycloakAccessTokenRoles(accessToken string) keycloakRoles {
+	roles := keycloakRoles{}
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return roles
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return roles
+	}
+	json.Unmarshal(payload, &roles) // a payload without role claims leaves the roles empty
+	return roles
+}
+
+// list returns the realm roles and the client roles in the form client:role
+func (r keycloakRoles) list() []string {
+	roles := append([]string{}, r.RealmAccess.Roles...)
+
+	clients := make([]string, 0, len(r.ResourceAccess))
+	for client := range r.ResourceAccess {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	for _, client := range clients {
+		for _, role := range r.ResourceAccess[client].Roles {
+			roles = append(roles, client+":"+role)
+		}
+	}
+
+	if len(roles) == 0 {
+		return nil
+	}
+	return roles
+}
@@ -0,0 +1,132 @@
+package oauth2
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var keycloakTestUserResponse = `{
+  "sub": "248289761001",
+  "name": "Jane Doe",
+  "preferred_username": "jane",
+  "email": "jane@example.com",
+  "email_verified": true,
+  "realm_access": {"roles": ["admin", "offline_access"]},
+  "resource_access": {
+    "shop": {"roles": ["buyer"]},
+    "account": {"roles": ["manage-account", "view-profile"]}
+  }
+}`
+
+func Test_Keycloak_getUserInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "/realms/example/protocol/openid-connect/userinfo", r.URL.Path)
+		Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(keycloakTestUserResponse))
+	}))
+	defer server.Close()
+
+	u, rawJSON, err := getKeycloakUserInfo(server.URL+"/realms/example/protocol/openid-connect/userinfo", TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:      "jane",
+		Name:     "Jane Doe",
+		Email:    "jane@example.com",
+		Origin:   "keycloak",
+		Verified: true,
+		Groups:   []string{"admin", "offline_access"},
+		Roles:    []string{"admin", "offline_access", "account:manage-account", "account:view-profile", "shop:buyer"},
+	}, u)
+	Equal(t, keycloakTestUserResponse, rawJSON)
+}
+
+func Test_Keycloak_getUserInfo_RolesFromAccessToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"sub": "248289761001", "preferred_username": "jane"}`))
+	}))
+	defer server.Close()
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"realm_access": {"roles": ["user"]}, "resource_access": {"shop": {"roles": ["buyer"]}}}`))
+	accessToken := "eyJhbGciOiJSUzI1NiJ9." + payload + ".c2lnbmF0dXJl"
+
+	u, _, err := getKeycloakUserInfo(server.URL, TokenInfo{AccessToken: accessToken})
+	NoError(t, err)
+	Equal(t, []string{"user"}, u.Groups)
+	Equal(t, []string{"user", "shop:buyer"}, u.Roles)
+
+	// opaque access tokens have no roles
+	u, _, err = getKeycloakUserInfo(server.URL, TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Nil(t, u.Groups)
+	Nil(t, u.Roles)
+}
+
+func Test_Keycloak_getUserInfo_Errors(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		status      int
+		body        string
+	}{
+		{"text/html", 200, keycloakTestUserResponse},
+		{"application/json", 401, `{"error": "invalid_token"}`},
+		{"application/json", 200, `{"sub": "248289761001"}`},
+		{"application/json", 200, `not json`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		_, _, err := getKeycloakUserInfo(server.URL, TokenInfo{AccessToken: "secret"})
+		Error(t, err)
+		server.Close()
+	}
+}
+
+func Test_Keycloak_Realm(t *testing.T) {
+	m := NewManager()
+	NoError(t, m.AddConfig("keycloak", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"base_url":      "https://keycloak.example.com/",
+		"realm":         "example",
+	}))
+	cfg := m.GetConfigs()["keycloak"]
+	Equal(t, "https://keycloak.example.com/realms/example/protocol/openid-connect/auth", cfg.AuthURL)
+	Equal(t, "https://keycloak.example.com/realms/example/protocol/openid-connect/token", cfg.TokenURL)
+	Equal(t, "openid profile email", cfg.Scope)
+
+	err := m.AddConfig("keycloak", map[string]string{
+		"client_id": "client42",
+		"realm":     "example",
+	})
+	EqualError(t, err, "missing parameter base_url for keycloak provider")
+
+	err = m.AddConfig("keycloak", map[string]string{
+		"client_id": "client42",
+		"base_url":  "keycloak.example.com",
+		"realm":     "example",
+	})
+	EqualError(t, err, `invalid parameter value "keycloak.example.com" in "base_url" for keycloak provider`)
+
+	err = m.AddConfig("keycloak", map[string]string{
+		"client_id": "client42",
+		"base_url":  "https://keycloak.example.com",
+	})
+	EqualError(t, err, "missing parameter realm for keycloak provider")
+
+	err = m.AddConfig("keycloak", map[string]string{
+		"client_id": "client42",
+		"base_url":  "https://keycloak.example.com",
+		"realm":     "../master",
+	})
+	EqualError(t, err, `invalid parameter value "../master" in "realm" for keycloak provider`)
+}
@@ -4,17 +4,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/afdecastro879/loginsrv/metrics"
 	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/tracing"
 	"github.com/davecgh/go-spew/spew"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Manager has the responsibility to handle the user user requests in an oauth flow.
 // It has to pick the right configuration and start the oauth redirecting.
 type Manager struct {
-	CallbackURL  string
+	CallbackURL      string
+	Prompt           string
+	ConsentRecordURL string
+	// StateStore holds the state parameters of the started flows, an in memory store by default
+	StateStore   StateStore
 	configs      map[string]Config
 	startFlow    func(cfg Config, w http.ResponseWriter)
 	authenticate func(cfg Config, r *http.Request) (TokenInfo, error)
@@ -47,6 +55,7 @@ var AuthCallback = func(userInfo model.UserInfo, token TokenInfo, callbackURL st
 // NewManager creates a new Manager
 func NewManager() *Manager {
 	return &Manager{
+		StateStore:   newMemoryStateStore(),
 		configs:      map[string]Config{},
 		startFlow:    StartFlow,
 		authenticate: Authenticate,
@@ -77,19 +86,45 @@ func (manager *Manager) Handle(w http.ResponseWriter, r *http.Request) (
 	}
 
 	if r.FormValue("code") != "" {
-		tokenInfo, err := manager.authenticate(cfg, r)
+		if err := manager.verifyFlowState(&cfg, r); err != nil {
+			return false, false, model.UserInfo{}, err
+		}
+
+		start := time.Now()
+		ctx, span := tracing.Start(r.Context(), "oauth2 token exchange", tracing.KindInternal)
+		span.SetAttribute("oauth2.provider", cfg.Provider.Name)
+		tokenInfo, err := manager.authenticate(cfg, r.WithContext(ctx))
+		span.SetError(err)
+		span.End()
 		if err != nil {
+			metrics.ObserveOAuth2Request(cfg.Provider.Name, time.Since(start))
 			return false, false, model.UserInfo{}, err
 		}
 
-		userInfo, _, err := cfg.Provider.GetUserInfo(tokenInfo)
+		// the provider calls are not traced individually, because the providers don't have a context
+		_, span = tracing.Start(r.Context(), "oauth2 userinfo", tracing.KindClient)
+		span.SetAttribute("oauth2.provider", cfg.Provider.Name)
+		userInfo, rawUserJSON, err := cfg.Provider.GetUserInfo(tokenInfo)
+		span.SetError(err)
+		span.End()
+		metrics.ObserveOAuth2Request(cfg.Provider.Name, time.Since(start))
 		if err != nil {
 			return false, false, model.UserInfo{}, err
 		}
+		// the raw user info stays nil, if it is not a json object
+		json.Unmarshal([]byte(rawUserJSON), &userInfo.Raw)
+		if manager.ConsentRecordURL != "" {
+			if err := recordConsent(r.Context(), manager.ConsentRecordURL, cfg, userInfo, tokenInfo); err != nil {
+				return false, false, model.UserInfo{}, err
+			}
+		}
 		AuthCallback(userInfo, tokenInfo, manager.CallbackURL)
 		return false, true, userInfo, err
 	}
 
+	if err = manager.newFlowState(&cfg, r); err != nil {
+		return false, false, model.UserInfo{}, err
+	}
 	manager.startFlow(cfg, w)
 	return true, false, model.UserInfo{}, nil
 }
@@ -107,6 +142,10 @@ func (manager *Manager) GetConfigFromRequest(r *http.Request) (Config, error) {
 		cfg.RedirectURI = redirectURIFromRequest(r)
 	}
 
+	if cfg.Prompt == "" {
+		cfg.Prompt = manager.Prompt
+	}
+
 	return cfg, nil
 }
 
@@ -123,10 +162,38 @@ func (manager *Manager) AddConfig(providerName string, opts map[string]string) e
 		return fmt.Errorf("no provider for name %v", providerName)
 	}
 
+	if p.NewFromOpts != nil {
+		var err error
+		p, err = p.NewFromOpts(opts)
+		if err != nil {
+			return err
+		}
+	}
+
 	cfg := Config{
 		Provider: p,
 		AuthURL:  p.AuthURL,
 		TokenURL: p.TokenURL,
+		PKCE:     true,
+	}
+
+	if pkce, exist := opts["pkce"]; exist {
+		enabled, err := strconv.ParseBool(pkce)
+		if err != nil {
+			return fmt.Errorf("invalid value for parameter pkce: %v", pkce)
+		}
+		cfg.PKCE = enabled
+	}
+	publicClient := false
+	if value, exist := opts["public_client"]; exist {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for parameter public_client: %v", value)
+		}
+		publicClient = enabled
+	}
+	if publicClient && !cfg.PKCE {
+		return fmt.Errorf("parameter public_client requires pkce")
 	}
 
 	clientID, exist := opts["client_id"]
@@ -135,11 +202,20 @@ func (manager *Manager) AddConfig(providerName string, opts map[string]string) e
 	}
 	cfg.ClientID = clientID
 
-	clientSecret, exist := opts["client_secret"]
-	if !exist {
-		return fmt.Errorf("missing parameter client_secret")
+	if p.ClientSecret != nil {
+		// check the options by generating a client secret once
+		if _, err := p.ClientSecret(opts); err != nil {
+			return err
+		}
+		cfg.Opts = opts
+	} else {
+		clientSecret, exist := opts["client_secret"]
+		if !exist && !publicClient {
+			// only the explicitly declared public clients, which use PKCE, have no client secret
+			return fmt.Errorf("missing parameter client_secret")
+		}
+		cfg.ClientSecret = clientSecret
 	}
-	cfg.ClientSecret = clientSecret
 
 	if scope, exist := opts["scope"]; exist {
 		cfg.Scope = scope
@@ -151,6 +227,13 @@ func (manager *Manager) AddConfig(providerName string, opts map[string]string) e
 		cfg.RedirectURI = redirectURI
 	}
 
+	if prompt, exist := opts["prompt"]; exist {
+		if err := ValidatePrompt(prompt); err != nil {
+			return err
+		}
+		cfg.Prompt = prompt
+	}
+
 	manager.configs[providerName] = cfg
 	return nil
 }
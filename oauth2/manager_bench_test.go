@@ -0,0 +1,57 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// BenchmarkManager_Callback measures the oauth callback including the code exchange with a mocked provider.
+func BenchmarkManager_Callback(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"e72e16c7e42f292c6912e7710c838347ae178b4a", "scope":"repo gist", "token_type":"bearer"}`))
+	}))
+	defer server.Close()
+
+	benchProvider := Provider{
+		Name:     "bench",
+		AuthURL:  server.URL + "/authorize",
+		TokenURL: server.URL + "/token",
+		GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+			return model.UserInfo{Sub: "the-username"}, "", nil
+		},
+	}
+	RegisterProvider(benchProvider)
+	defer UnRegisterProvider(benchProvider.Name)
+
+	// the auth callback is not part of the benchmark
+	defer func(callback func(model.UserInfo, TokenInfo, string)) { AuthCallback = callback }(AuthCallback)
+	AuthCallback = func(model.UserInfo, TokenInfo, string) {}
+
+	m := NewManager()
+	err := m.AddConfig(benchProvider.Name, map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"redirect_uri":  "http://localhost/login/bench",
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, _ := http.NewRequest("GET", "http://localhost/login/bench?code=theCode&state=theState", nil)
+		r.Header.Set("Cookie", "oauthState=theState")
+		// every state can only be used once
+		m.StateStore.Save("theState", FlowState{Provider: benchProvider.Name, UserAgent: userAgentHash(r), CodeVerifier: "theVerifier"}, time.Now().Add(time.Minute))
+
+		_, authenticated, _, err := m.Handle(httptest.NewRecorder(), r)
+		if err != nil || !authenticated {
+			b.Fatalf("oauth callback failed: %v", err)
+		}
+	}
+}
@@ -2,12 +2,14 @@ package oauth2
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"github.com/afdecastro879/loginsrv/model"
 	. "github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func Test_Manager_Positive_Flow(t *testing.T) {
@@ -38,6 +40,7 @@ func Test_Manager_Positive_Flow(t *testing.T) {
 		RedirectURI:  "http://localhost",
 		Scope:        "email other",
 		Provider:     exampleProvider,
+		PKCE:         true,
 	}
 
 	m := NewManager()
@@ -74,7 +77,8 @@ func Test_Manager_Positive_Flow(t *testing.T) {
 	assertEqualConfig(t, expectedConfig, startFlowReceivedConfig)
 
 	// callback
-	r, _ = http.NewRequest("GET", "http://example.com/login/"+exampleProvider.Name+"?code=xyz", nil)
+	NotEmpty(t, startFlowReceivedConfig.State)
+	r, _ = http.NewRequest("GET", "http://example.com/login/"+exampleProvider.Name+"?code=xyz&state="+startFlowReceivedConfig.State, nil)
 
 	startedFlow, authenticated, userInfo, err = m.Handle(httptest.NewRecorder(), r)
 	NoError(t, err)
@@ -87,6 +91,29 @@ func Test_Manager_Positive_Flow(t *testing.T) {
 	True(t, getUserInfoCalled)
 }
 
+func Test_Manager_RawUserInfo(t *testing.T) {
+	exampleProvider := Provider{
+		Name: "example",
+		GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+			return model.UserInfo{Sub: "the-username"}, `{"login": "the-username", "team": "blue"}`, nil
+		},
+	}
+	RegisterProvider(exampleProvider)
+	defer UnRegisterProvider(exampleProvider.Name)
+
+	m := NewManager()
+	NoError(t, m.AddConfig(exampleProvider.Name, map[string]string{"client_id": "client42", "client_secret": "secret"}))
+	m.authenticate = func(cfg Config, r *http.Request) (TokenInfo, error) {
+		return TokenInfo{AccessToken: "secret"}, nil
+	}
+
+	r, _ := http.NewRequest("GET", "http://example.com/login/"+exampleProvider.Name+"?code=xyz&state="+testFlowState(t, m, exampleProvider.Name), nil)
+	_, authenticated, userInfo, err := m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, map[string]interface{}{"login": "the-username", "team": "blue"}, userInfo.Raw)
+}
+
 func Test_Manager_NoAauthOnWrongCode(t *testing.T) {
 	var authenticateCalled, getUserInfoCalled bool
 
@@ -114,7 +141,7 @@ func Test_Manager_NoAauthOnWrongCode(t *testing.T) {
 	}
 
 	// callback
-	r, _ := http.NewRequest("GET", "http://example.com/login/"+exampleProvider.Name+"?code=xyz", nil)
+	r, _ := http.NewRequest("GET", "http://example.com/login/"+exampleProvider.Name+"?code=xyz&state="+testFlowState(t, m, exampleProvider.Name), nil)
 
 	startedFlow, authenticated, userInfo, err := m.Handle(httptest.NewRecorder(), r)
 	EqualError(t, err, "code not valid")
@@ -169,6 +196,40 @@ func Test_Manager_AddConfig_ErrorCases(t *testing.T) {
 		"missing parameter client_secret",
 	)
 
+	// a public client authenticates by PKCE only
+	NoError(t,
+		m.AddConfig("github", map[string]string{
+			"client_id":     "foo",
+			"public_client": "true",
+		}),
+	)
+
+	EqualError(t,
+		m.AddConfig("github", map[string]string{
+			"client_id":     "foo",
+			"public_client": "true",
+			"pkce":          "false",
+		}),
+		"parameter public_client requires pkce",
+	)
+
+	EqualError(t,
+		m.AddConfig("github", map[string]string{
+			"client_id":     "foo",
+			"public_client": "maybe",
+		}),
+		"invalid value for parameter public_client: maybe",
+	)
+
+	EqualError(t,
+		m.AddConfig("github", map[string]string{
+			"client_id":     "foo",
+			"client_secret": "bar",
+			"pkce":          "maybe",
+		}),
+		"invalid value for parameter pkce: maybe",
+	)
+
 }
 
 func Test_Manager_redirectUriFromRequest(t *testing.T) {
@@ -245,6 +306,14 @@ func Test_Manager_RedirectURI_Generation(t *testing.T) {
 	Equal(t, callURL, startFlowReceivedConfig.RedirectURI)
 }
 
+// testFlowState stores the state of a started flow for a callback without user agent
+func testFlowState(t *testing.T, m *Manager, provider string) string {
+	state := "state-" + provider
+	r, _ := http.NewRequest("GET", "http://example.com/login/"+provider, nil)
+	NoError(t, m.StateStore.Save(state, FlowState{Provider: provider, UserAgent: userAgentHash(r)}, time.Now().Add(time.Minute)))
+	return state
+}
+
 func assertEqualConfig(t *testing.T, c1, c2 Config) {
 	Equal(t, c1.AuthURL, c2.AuthURL)
 	Equal(t, c1.ClientID, c2.ClientID)
@@ -253,4 +322,89 @@ func assertEqualConfig(t *testing.T, c1, c2 Config) {
 	Equal(t, c1.RedirectURI, c2.RedirectURI)
 	Equal(t, c1.TokenURL, c2.TokenURL)
 	Equal(t, c1.Provider.Name, c2.Provider.Name)
+	Equal(t, c1.PKCE, c2.PKCE)
+}
+
+func Test_Manager_Prompt(t *testing.T) {
+	var startFlowReceivedConfig Config
+
+	m := NewManager()
+	m.Prompt = "consent"
+	NoError(t, m.AddConfig("github", map[string]string{
+		"client_id":     "foo",
+		"client_secret": "bar",
+	}))
+	NoError(t, m.AddConfig("google", map[string]string{
+		"client_id":     "foo",
+		"client_secret": "bar",
+		"prompt":        "select_account",
+	}))
+	Error(t, m.AddConfig("gitlab", map[string]string{
+		"client_id":     "foo",
+		"client_secret": "bar",
+		"prompt":        "always",
+	}))
+
+	m.startFlow = func(cfg Config, w http.ResponseWriter) {
+		startFlowReceivedConfig = cfg
+	}
+
+	r, _ := http.NewRequest("GET", "http://example.com/login/github", nil)
+	_, _, _, err := m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	Equal(t, "consent", startFlowReceivedConfig.Prompt)
+
+	r, _ = http.NewRequest("GET", "http://example.com/login/google", nil)
+	_, _, _, err = m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	Equal(t, "select_account", startFlowReceivedConfig.Prompt)
+}
+
+func Test_Manager_ConsentRecord(t *testing.T) {
+	var record ConsentRecord
+	recordStatus := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "POST", r.Method)
+		Equal(t, "application/json", r.Header.Get("Content-Type"))
+		NoError(t, json.NewDecoder(r.Body).Decode(&record))
+		w.WriteHeader(recordStatus)
+	}))
+	defer server.Close()
+
+	exampleProvider := Provider{
+		Name: "example",
+		GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+			return model.UserInfo{Sub: "the-username", Origin: "example"}, "", nil
+		},
+	}
+	RegisterProvider(exampleProvider)
+	defer UnRegisterProvider(exampleProvider.Name)
+
+	m := NewManager()
+	m.Prompt = "consent"
+	m.ConsentRecordURL = server.URL
+	NoError(t, m.AddConfig(exampleProvider.Name, map[string]string{
+		"client_id":     "foo",
+		"client_secret": "bar",
+		"scope":         "email",
+	}))
+	m.authenticate = func(cfg Config, r *http.Request) (TokenInfo, error) {
+		return TokenInfo{AccessToken: "the-access-token"}, nil
+	}
+
+	r, _ := http.NewRequest("GET", "http://example.com/login/example?code=xyz&state="+testFlowState(t, m, "example"), nil)
+	_, authenticated, _, err := m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "the-username", record.Sub)
+	Equal(t, "example", record.Origin)
+	Equal(t, "email", record.Scope)
+	Equal(t, "consent", record.Prompt)
+	InDelta(t, time.Now().Unix(), record.ConsentedAt, 2)
+
+	// a failing consent record fails the login
+	recordStatus = 500
+	_, authenticated, _, err = m.Handle(httptest.NewRecorder(), r)
+	Error(t, err)
+	False(t, authenticated)
 }
@@ -0,0 +1,101 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+var microsoftGraphAPI = "https://graph.microsoft.com/v1.0"
+
+var microsoftLoginURL = "https://login.microsoftonline.com"
+
+// a tenant is 'common', 'organizations', 'consumers', a tenant id or a domain name
+var microsoftTenantPattern = regexp.MustCompile(`^[a-zA-Z0-9.-]+$`)
+
+func init() {
+	providerMicrosoft.NewFromOpts = newMicrosoftProvider
+	RegisterProvider(providerMicrosoft)
+}
+
+// microsoftUser is used for parsing the microsoft graph response
+type microsoftUser struct {
+	ID                string `json:"id,omitempty"`
+	DisplayName       string `json:"displayName,omitempty"`
+	Mail              string `json:"mail,omitempty"`
+	UserPrincipalName string `json:"userPrincipalName,omitempty"`
+}
+
+var providerMicrosoft = Provider{
+	Name:          "microsoft",
+	AuthURL:       microsoftLoginURL + "/common/oauth2/v2.0/authorize",
+	TokenURL:      microsoftLoginURL + "/common/oauth2/v2.0/token",
+	DefaultScopes: "openid profile email User.Read",
+	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+		mu := microsoftUser{}
+
+		req, _ := http.NewRequest("GET", microsoftGraphAPI+"/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		req.Header.Set("Accept", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+		defer resp.Body.Close()
+
+		if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on microsoft get user info: %v", resp.Header.Get("Content-Type"))
+		}
+
+		if resp.StatusCode != 200 {
+			return model.UserInfo{}, "", fmt.Errorf("got http status %v on microsoft get user info", resp.StatusCode)
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("error reading microsoft get user info: %v", err)
+		}
+
+		err = json.Unmarshal(b, &mu)
+		if err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("error parsing microsoft get user info: %v", err)
+		}
+
+		if mu.UserPrincipalName == "" {
+			return model.UserInfo{}, "", &MissingFieldError{Provider: "microsoft", Field: "userPrincipalName"}
+		}
+
+		// The mail attribute can be changed by the user or admin of any tenant,
+		// so the user principal name is used as subject.
+		return model.UserInfo{
+			Sub:    mu.UserPrincipalName,
+			Name:   mu.DisplayName,
+			Email:  mu.Mail,
+			Origin: "microsoft",
+			// microsoft does not return a verification status of the account
+			Verified: true,
+		}, string(b), nil
+	},
+}
+
+// newMicrosoftProvider creates a microsoft provider for the tenant from the options.
+// Without a tenant option, the 'common' endpoint is used, which accepts all accounts.
+func newMicrosoftProvider(opts map[string]string) (Provider, error) {
+	tenant, exist := opts["tenant"]
+	if !exist {
+		return providerMicrosoft, nil
+	}
+	if !microsoftTenantPattern.MatchString(tenant) {
+		return Provider{}, fmt.Errorf("invalid parameter value %q in \"tenant\" for microsoft provider", tenant)
+	}
+
+	p := providerMicrosoft
+	p.AuthURL = fmt.Sprintf("%v/%v/oauth2/v2.0/authorize", microsoftLoginURL, tenant)
+	p.TokenURL = fmt.Sprintf("%v/%v/oauth2/v2.0/token", microsoftLoginURL, tenant)
+	return p, nil
+}
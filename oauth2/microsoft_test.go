@@ -0,0 +1,98 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var microsoftTestUserResponse = `{
+  "@odata.context": "https://graph.microsoft.com/v1.0/$metadata#users/$entity",
+  "businessPhones": [],
+  "displayName": "Adele Vance",
+  "givenName": "Adele",
+  "jobTitle": "Retail Manager",
+  "mail": "AdeleV@contoso.onmicrosoft.com",
+  "mobilePhone": null,
+  "officeLocation": "18/2111",
+  "preferredLanguage": "en-US",
+  "surname": "Vance",
+  "userPrincipalName": "AdeleV@contoso.onmicrosoft.com",
+  "id": "87d349ed-44d7-43e1-9a83-5f2406dee5bd"
+}`
+
+func Test_Microsoft_getUserInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "/me", r.URL.Path)
+		Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json; odata.metadata=minimal")
+		w.Write([]byte(microsoftTestUserResponse))
+	}))
+	defer server.Close()
+
+	microsoftGraphAPI = server.URL
+
+	u, rawJSON, err := providerMicrosoft.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:      "AdeleV@contoso.onmicrosoft.com",
+		Name:     "Adele Vance",
+		Email:    "AdeleV@contoso.onmicrosoft.com",
+		Origin:   "microsoft",
+		Verified: true,
+	}, u)
+	Equal(t, microsoftTestUserResponse, rawJSON)
+}
+
+func Test_Microsoft_getUserInfo_Errors(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		status      int
+		body        string
+	}{
+		{"text/html", 200, microsoftTestUserResponse},
+		{"application/json", 401, `{"error": {"code": "InvalidAuthenticationToken"}}`},
+		{"application/json", 200, `{"displayName": "Adele Vance", "mail": null}`},
+		{"application/json", 200, `not json`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		microsoftGraphAPI = server.URL
+		_, _, err := providerMicrosoft.GetUserInfo(TokenInfo{AccessToken: "secret"})
+		Error(t, err)
+		server.Close()
+	}
+}
+
+func Test_Microsoft_Tenant(t *testing.T) {
+	m := NewManager()
+	NoError(t, m.AddConfig("microsoft", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+	}))
+	Equal(t, "https://login.microsoftonline.com/common/oauth2/v2.0/authorize", m.GetConfigs()["microsoft"].AuthURL)
+
+	NoError(t, m.AddConfig("microsoft", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"tenant":        "contoso.onmicrosoft.com",
+	}))
+	cfg := m.GetConfigs()["microsoft"]
+	Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/authorize", cfg.AuthURL)
+	Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/token", cfg.TokenURL)
+	Equal(t, "openid profile email User.Read", cfg.Scope)
+
+	err := m.AddConfig("microsoft", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"tenant":        "../evil",
+	})
+	EqualError(t, err, `invalid parameter value "../evil" in "tenant" for microsoft provider`)
+}
@@ -2,6 +2,9 @@ package oauth2
 
 import (
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -10,6 +13,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/afdecastro879/loginsrv/tracing"
 )
 
 func init() {
@@ -38,8 +43,24 @@ type Config struct {
 	// Scope specifies optional requested permissions, this is a *space* separated list.
 	Scope string
 
+	// Prompt is passed as prompt parameter to the authorization url, e.g. 'consent' or 'login consent'.
+	Prompt string
+
 	// The oauth provider
 	Provider Provider
+
+	// Opts are the configured options of the provider, used to generate the client secret.
+	Opts map[string]string
+
+	// PKCE enables the code challenge (RFC 7636) for the authorization request and the token exchange.
+	PKCE bool
+
+	// CodeVerifier is the PKCE code verifier of the flow, which is stored by the manager together with the state.
+	CodeVerifier string
+
+	// State is the state parameter of the flow, which is stored by the manager.
+	// A random state is used, if it is empty.
+	State string
 }
 
 // TokenInfo represents the credentials used to authorize
@@ -55,6 +76,9 @@ type TokenInfo struct {
 
 	// The scopes for this tolen
 	Scope string `json:"scope,omitempty"`
+
+	// IDToken is the OpenID Connect id token, if returned by the provider.
+	IDToken string `json:"id_token,omitempty"`
 }
 
 // JSONError represents an oauth error response in json form.
@@ -65,23 +89,45 @@ type JSONError struct {
 const stateCookieName = "oauthState"
 const defaultTimeout = 5 * time.Second
 
+// tokenClient is used for the token exchange, which is traced as part of the callback request
+var tokenClient = &http.Client{Transport: &tracing.Transport{}}
+
 // StartFlow by redirecting the user to the login provider.
-// A state parameter to protect against cross-site request forgery attacks is randomly generated and stored in a cookie
+// A state parameter to protect against cross-site request forgery attacks is randomly generated and stored in a cookie.
+// With PKCE, only the challenge of the code verifier is sent to the provider.
 func StartFlow(cfg Config, w http.ResponseWriter) {
 	values := make(url.Values)
 	values.Set("client_id", cfg.ClientID)
 	values.Set("scope", cfg.Scope)
 	values.Set("redirect_uri", cfg.RedirectURI)
 	values.Set("response_type", "code")
+	if cfg.Prompt != "" {
+		values.Set("prompt", cfg.Prompt)
+	}
+	if cfg.Provider.ResponseMode != "" {
+		values.Set("response_mode", cfg.Provider.ResponseMode)
+	}
+	for name, value := range cfg.Provider.AuthParams {
+		values.Set(name, value)
+	}
 
 	// set and store the state param
-	values.Set("state", randStringBytes(15))
-	http.SetCookie(w, &http.Cookie{
-		Name:     stateCookieName,
-		MaxAge:   60 * 10, // 10 minutes
-		Value:    values.Get("state"),
-		HttpOnly: true,
-	})
+	state := cfg.State
+	if state == "" {
+		state = randStringBytes(15)
+	}
+	values.Set("state", state)
+	http.SetCookie(w, flowCookie(cfg, stateCookieName, values.Get("state")))
+
+	if cfg.PKCE {
+		if cfg.CodeVerifier == "" {
+			// the verifier has to be stored with the state for the token exchange
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		values.Set("code_challenge", codeChallenge(cfg.CodeVerifier))
+		values.Set("code_challenge_method", "S256")
+	}
 
 	targetURL := cfg.AuthURL + "?" + values.Encode()
 	w.Header().Set("Location", targetURL)
@@ -105,24 +151,81 @@ func Authenticate(cfg Config, r *http.Request) (TokenInfo, error) {
 	if code == "" {
 		return TokenInfo{}, fmt.Errorf("error: no auth code provided")
 	}
-	return getAccessToken(cfg, state, code)
+
+	var codeVerifier string
+	if cfg.PKCE {
+		if cfg.CodeVerifier == "" {
+			return TokenInfo{}, fmt.Errorf("error: no oauth code verifier found")
+		}
+		codeVerifier = cfg.CodeVerifier
+	}
+	return getAccessToken(r.Context(), cfg, state, code, codeVerifier)
+}
+
+// flowCookie returns a cookie, which holds a value of the flow until the callback
+func flowCookie(cfg Config, name, value string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		MaxAge:   60 * 10, // 10 minutes
+		Value:    value,
+		HttpOnly: true,
+	}
+	if cfg.Provider.ResponseMode == "form_post" {
+		// the callback is a cross site POST request, which only contains the cookie with SameSite=None
+		cookie.SameSite = http.SameSiteNoneMode
+		cookie.Secure = true
+	}
+	return cookie
+}
+
+// newCodeVerifier returns a random PKCE code verifier with 256 bits of entropy
+func newCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func getAccessToken(cfg Config, state, code string) (TokenInfo, error) {
+// codeChallenge returns the S256 challenge of the code verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getAccessToken(ctx context.Context, cfg Config, state, code, codeVerifier string) (TokenInfo, error) {
+	clientSecret := cfg.ClientSecret
+	if cfg.Provider.ClientSecret != nil {
+		var err error
+		clientSecret, err = cfg.Provider.ClientSecret(cfg.Opts)
+		if err != nil {
+			return TokenInfo{}, fmt.Errorf("error creating client secret: %v", err)
+		}
+	}
+
 	values := url.Values{}
 	values.Set("client_id", cfg.ClientID)
-	values.Set("client_secret", cfg.ClientSecret)
+	if clientSecret != "" && !cfg.Provider.TokenBasicAuth {
+		// public clients authenticate by the code verifier only
+		values.Set("client_secret", clientSecret)
+	}
 	values.Set("code", code)
+	if codeVerifier != "" {
+		values.Set("code_verifier", codeVerifier)
+	}
 	values.Set("redirect_uri", cfg.RedirectURI)
 	values.Set("grant_type", "authorization_code")
 
-	r, _ := http.NewRequest("POST", cfg.TokenURL, strings.NewReader(values.Encode()))
-	cntx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	cntx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
-	r.WithContext(cntx)
+	r, _ := http.NewRequest("POST", cfg.TokenURL, strings.NewReader(values.Encode()))
+	r = r.WithContext(cntx)
 	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	r.Header.Set("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(r)
+	if clientSecret != "" && cfg.Provider.TokenBasicAuth {
+		r.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(clientSecret))
+	}
+	resp, err := tokenClient.Do(r)
 	if err != nil {
 		return TokenInfo{}, err
 	}
@@ -154,6 +257,31 @@ func getAccessToken(cfg Config, state, code string) (TokenInfo, error) {
 	return tokenInfo, nil
 }
 
+var validPromptValues = map[string]bool{
+	"none":           true,
+	"login":          true,
+	"consent":        true,
+	"select_account": true,
+}
+
+// ValidatePrompt checks a space separated list of prompt values.
+// The value 'none' can not be combined with other values.
+func ValidatePrompt(prompt string) error {
+	if prompt == "" {
+		return nil
+	}
+	values := strings.Fields(prompt)
+	for _, v := range values {
+		if !validPromptValues[v] {
+			return fmt.Errorf("invalid oauth prompt value %q", v)
+		}
+		if v == "none" && len(values) > 1 {
+			return fmt.Errorf("oauth prompt value 'none' can not be combined with other values")
+		}
+	}
+	return nil
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
 func randStringBytes(n int) string {
@@ -42,6 +42,98 @@ func Test_StartFlow(t *testing.T) {
 	Equal(t, expectedLocation, resp.Header().Get("Location"))
 }
 
+func Test_StartFlow_Prompt(t *testing.T) {
+	resp := httptest.NewRecorder()
+	cfg := testConfig
+	cfg.Prompt = "login consent"
+	StartFlow(cfg, resp)
+
+	location, err := url.Parse(resp.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, "login consent", location.Query().Get("prompt"))
+}
+
+func Test_StartFlow_AuthParams(t *testing.T) {
+	resp := httptest.NewRecorder()
+	cfg := testConfig
+	cfg.Provider.AuthParams = map[string]string{"hd": "example.com"}
+	StartFlow(cfg, resp)
+
+	location, err := url.Parse(resp.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, "example.com", location.Query().Get("hd"))
+}
+
+func Test_StartFlow_PKCE(t *testing.T) {
+	resp := httptest.NewRecorder()
+	cfg := testConfig
+	cfg.PKCE = true
+	cfg.CodeVerifier = "theVerifier"
+	StartFlow(cfg, resp)
+
+	// the verifier is kept with the state on the server, not in a cookie
+	for _, c := range (&http.Response{Header: resp.Header()}).Cookies() {
+		NotContains(t, c.Value, cfg.CodeVerifier)
+	}
+
+	location, err := url.Parse(resp.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, "S256", location.Query().Get("code_challenge_method"))
+	Equal(t, codeChallenge(cfg.CodeVerifier), location.Query().Get("code_challenge"))
+	NotContains(t, location.RawQuery, cfg.CodeVerifier)
+
+	// a flow without verifier is not started
+	resp = httptest.NewRecorder()
+	cfg.CodeVerifier = ""
+	StartFlow(cfg, resp)
+	Equal(t, 500, resp.Code)
+}
+
+func Test_CodeChallenge(t *testing.T) {
+	// example of RFC 7636, appendix B
+	Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", codeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func Test_Authenticate_PKCE(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		Equal(t, "client_id=client42&code=theCode&code_verifier=theVerifier&grant_type=authorization_code&redirect_uri=http%3A%2F%2Flocalhost%2Fcallback", string(body))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"e72e16c7e42f292c6912e7710c838347ae178b4a"}`))
+	}))
+	defer server.Close()
+
+	// a public client without client secret
+	cfg := testConfig
+	cfg.ClientSecret = ""
+	cfg.PKCE = true
+	cfg.CodeVerifier = "theVerifier"
+	cfg.TokenURL = server.URL
+
+	request, _ := http.NewRequest("GET", "http://localhost/callback?code=theCode&state=theState", nil)
+	request.Header.Set("Cookie", "oauthState=theState")
+
+	tokenInfo, err := Authenticate(cfg, request)
+	NoError(t, err)
+	Equal(t, "e72e16c7e42f292c6912e7710c838347ae178b4a", tokenInfo.AccessToken)
+
+	// the code verifier is required
+	cfg.CodeVerifier = ""
+	_, err = Authenticate(cfg, request)
+	EqualError(t, err, "error: no oauth code verifier found")
+}
+
+func Test_ValidatePrompt(t *testing.T) {
+	NoError(t, ValidatePrompt(""))
+	NoError(t, ValidatePrompt("none"))
+	NoError(t, ValidatePrompt("consent"))
+	NoError(t, ValidatePrompt("select_account"))
+	NoError(t, ValidatePrompt("login consent"))
+	Error(t, ValidatePrompt("none consent"))
+	Error(t, ValidatePrompt("foo"))
+}
+
 func Test_Authenticate(t *testing.T) {
 	// mock a server for token exchange
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -72,6 +164,33 @@ func Test_Authenticate(t *testing.T) {
 	Equal(t, "bearer", tokenInfo.TokenType)
 }
 
+func Test_Authenticate_TokenBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, ok := r.BasicAuth()
+		True(t, ok)
+		Equal(t, "client42", clientID)
+		Equal(t, "secret", clientSecret)
+
+		body, _ := ioutil.ReadAll(r.Body)
+		Equal(t, "client_id=client42&code=theCode&grant_type=authorization_code&redirect_uri=http%3A%2F%2Flocalhost%2Fcallback", string(body))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"e72e16c7e42f292c6912e7710c838347ae178b4a"}`))
+	}))
+	defer server.Close()
+
+	cfg := testConfig
+	cfg.TokenURL = server.URL
+	cfg.Provider.TokenBasicAuth = true
+
+	request, _ := http.NewRequest("GET", "http://localhost/callback?code=theCode&state=theState", nil)
+	request.Header.Set("Cookie", "oauthState=theState")
+
+	tokenInfo, err := Authenticate(cfg, request)
+	NoError(t, err)
+	Equal(t, "e72e16c7e42f292c6912e7710c838347ae178b4a", tokenInfo.AccessToken)
+}
+
 func Test_Authenticate_CodeExchangeError(t *testing.T) {
 	var testReturnCode int
 	testResponseJSON := `{"error":"bad_verification_code","error_description":"The code passed is incorrect or expired.","error_uri":"https://developer.github.com/v3/oauth/#bad-verification-code"}`
@@ -0,0 +1,117 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// an okta domain is the org domain, e.g. dev-123456.okta.com, or a custom domain
+var oktaDomainPattern = regexp.MustCompile(`^[a-zA-Z0-9.-]+(:[0-9]+)?$`)
+
+// an authorization server id is 'default' or the id of a custom authorization server
+var oktaAuthorizationServerPattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+func init() {
+	providerOkta.NewFromOpts = newOktaProvider
+	RegisterProvider(providerOkta)
+}
+
+// oktaUser is used for parsing the okta userinfo response
+type oktaUser struct {
+	Sub               string   `json:"sub"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Groups            []string `json:"groups"`
+}
+
+// providerOkta has no urls, because every okta organisation has its own domain.
+// They are set by newOktaProvider from the domain option.
+var providerOkta = Provider{
+	Name:          "okta",
+	DefaultScopes: "openid profile email",
+}
+
+// newOktaProvider creates an okta provider for the domain and authorization server from the options.
+// Without an authorization_server option, the org authorization server is used.
+func newOktaProvider(opts map[string]string) (Provider, error) {
+	domain, exist := opts["domain"]
+	if !exist {
+		return Provider{}, fmt.Errorf("missing parameter domain for okta provider")
+	}
+	if !oktaDomainPattern.MatchString(domain) {
+		return Provider{}, fmt.Errorf("invalid parameter value %q in \"domain\" for okta provider", domain)
+	}
+
+	baseURL := "https://" + domain + "/oauth2"
+	if server, exist := opts["authorization_server"]; exist {
+		if !oktaAuthorizationServerPattern.MatchString(server) {
+			return Provider{}, fmt.Errorf("invalid parameter value %q in \"authorization_server\" for okta provider", server)
+		}
+		baseURL += "/" + server
+	}
+
+	p := providerOkta
+	p.AuthURL = baseURL + "/v1/authorize"
+	p.TokenURL = baseURL + "/v1/token"
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		return getOktaUserInfo(baseURL+"/v1/userinfo", token)
+	}
+	return p, nil
+}
+
+func getOktaUserInfo(userinfoURL string, token TokenInfo) (model.UserInfo, string, error) {
+	ou := oktaUser{}
+
+	req, _ := http.NewRequest("GET", userinfoURL, nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return model.UserInfo{}, "", fmt.Errorf("wrong content-type on okta get user info: %v", resp.Header.Get("Content-Type"))
+	}
+
+	if resp.StatusCode != 200 {
+		return model.UserInfo{}, "", fmt.Errorf("got http status %v on okta get user info", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error reading okta get user info: %v", err)
+	}
+
+	err = json.Unmarshal(b, &ou)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error parsing okta get user info: %v", err)
+	}
+
+	if ou.Sub == "" {
+		return model.UserInfo{}, "", &MissingFieldError{Provider: "okta", Field: "sub"}
+	}
+
+	name := ou.Name
+	if name == "" {
+		name = ou.PreferredUsername
+	}
+
+	return model.UserInfo{
+		Sub:      ou.Sub,
+		Name:     name,
+		Email:    ou.Email,
+		Groups:   ou.Groups,
+		Origin:   "okta",
+		Verified: ou.EmailVerified,
+	}, string(b), nil
+}
@@ -0,0 +1,113 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var oktaTestUserResponse = `{
+  "sub": "00uid4BxXw6I6TV4m0g3",
+  "name": "John Doe",
+  "nickname": "Jimmy",
+  "given_name": "John",
+  "family_name": "Doe",
+  "preferred_username": "john.doe@example.com",
+  "email": "john.doe@example.com",
+  "email_verified": true,
+  "zoneinfo": "America/Los_Angeles",
+  "updated_at": 1311280970,
+  "groups": ["Everyone", "Admins"]
+}`
+
+func Test_Okta_getUserInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "/oauth2/default/v1/userinfo", r.URL.Path)
+		Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Write([]byte(oktaTestUserResponse))
+	}))
+	defer server.Close()
+
+	u, rawJSON, err := getOktaUserInfo(server.URL+"/oauth2/default/v1/userinfo", TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:      "00uid4BxXw6I6TV4m0g3",
+		Name:     "John Doe",
+		Email:    "john.doe@example.com",
+		Groups:   []string{"Everyone", "Admins"},
+		Origin:   "okta",
+		Verified: true,
+	}, u)
+	Equal(t, oktaTestUserResponse, rawJSON)
+}
+
+func Test_Okta_getUserInfo_Errors(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		status      int
+		body        string
+	}{
+		{"text/html", 200, oktaTestUserResponse},
+		{"application/json", 401, `{"error": "invalid_token"}`},
+		{"application/json", 200, `{"name": "John Doe"}`},
+		{"application/json", 200, `not json`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		_, _, err := getOktaUserInfo(server.URL, TokenInfo{AccessToken: "secret"})
+		Error(t, err)
+		server.Close()
+	}
+}
+
+func Test_Okta_Domain(t *testing.T) {
+	m := NewManager()
+	NoError(t, m.AddConfig("okta", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"domain":        "dev-123456.okta.com",
+	}))
+	cfg := m.GetConfigs()["okta"]
+	Equal(t, "https://dev-123456.okta.com/oauth2/v1/authorize", cfg.AuthURL)
+	Equal(t, "https://dev-123456.okta.com/oauth2/v1/token", cfg.TokenURL)
+	Equal(t, "openid profile email", cfg.Scope)
+
+	NoError(t, m.AddConfig("okta", map[string]string{
+		"client_id":            "client42",
+		"client_secret":        "secret",
+		"domain":               "login.example.com",
+		"authorization_server": "default",
+	}))
+	cfg = m.GetConfigs()["okta"]
+	Equal(t, "https://login.example.com/oauth2/default/v1/authorize", cfg.AuthURL)
+	Equal(t, "https://login.example.com/oauth2/default/v1/token", cfg.TokenURL)
+
+	err := m.AddConfig("okta", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+	})
+	EqualError(t, err, "missing parameter domain for okta provider")
+
+	err = m.AddConfig("okta", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"domain":        "evil.com/x?",
+	})
+	EqualError(t, err, `invalid parameter value "evil.com/x?" in "domain" for okta provider`)
+
+	err = m.AddConfig("okta", map[string]string{
+		"client_id":            "client42",
+		"client_secret":        "secret",
+		"domain":               "dev-123456.okta.com",
+		"authorization_server": "../evil",
+	})
+	EqualError(t, err, `invalid parameter value "../evil" in "authorization_server" for okta provider`)
+}
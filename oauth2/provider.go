@@ -1,10 +1,15 @@
 package oauth2
 
 import (
+	"fmt"
+	"regexp"
+
 	"github.com/afdecastro879/loginsrv/model"
 )
 
-// Provider is the description of an oauth provider adapter
+// Provider is the description of an oauth provider adapter.
+// Providers of other packages, e.g. for an internal identity provider, are added with RegisterProvider.
+// The fields are stable: new fields are optional, so that these providers keep working.
 type Provider struct {
 	// The name to access the provider in the configuration
 	Name string
@@ -19,6 +24,25 @@ type Provider struct {
 	// This list can be overwritten by configuration.
 	DefaultScopes string
 
+	// ResponseMode is passed as response_mode parameter to the authorization url, if set.
+	// With 'form_post' the provider sends the callback as POST request.
+	ResponseMode string
+
+	// AuthParams are additional parameters of the authorization url, e.g. to restrict the selectable accounts.
+	AuthParams map[string]string
+
+	// TokenBasicAuth sends the client credentials as http basic auth on the token exchange,
+	// instead of the client_secret parameter. Some providers only accept this method for confidential clients.
+	TokenBasicAuth bool
+
+	// ClientSecret generates the client secret out of the provider options for each token exchange.
+	// It is used by providers, which do not have a static client_secret.
+	ClientSecret func(opts map[string]string) (string, error)
+
+	// NewFromOpts creates a copy of the provider, customized by the provider options,
+	// e.g. with the urls of a self-hosted instance. It is optional.
+	NewFromOpts func(opts map[string]string) (Provider, error)
+
 	// GetUserInfo is a provider specific Implementation
 	// for fetching the user information.
 	// Possible keys in the returned map are:
@@ -26,13 +50,57 @@ type Provider struct {
 	GetUserInfo func(token TokenInfo) (u model.UserInfo, rawUserJson string, err error)
 }
 
+// MissingFieldError is returned, if a required field is missing in the response of a provider.
+type MissingFieldError struct {
+	Provider string
+	Field    string
+}
+
+func (e *MissingFieldError) Error() string {
+	return fmt.Sprintf("invalid %v response: missing field %q", e.Provider, e.Field)
+}
+
+// AccessDeniedError is returned by GetUserInfo, if the user is authenticated by the provider,
+// but not allowed to login by the provider options, e.g. because of the domain or organization.
+type AccessDeniedError struct {
+	Provider string
+	Reason   string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return fmt.Sprintf("access denied by %v provider options: %v", e.Provider, e.Reason)
+}
+
 var provider = map[string]Provider{}
 
-// RegisterProvider an Oauth provider
+// providerNamePattern restricts the names to valid flag names and url path segments
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// RegisterProvider adds an oauth provider, which is configured by an option with its name,
+// e.g. -myidp client_id=..,client_secret=.. and is started at /login/myidp.
+// It should be called in the init function of the package of the provider and panics,
+// like sql.Register, if the provider is invalid or if a provider with the same name is already registered.
+// The name must also differ from the login backends and the other options of loginsrv.
 func RegisterProvider(p Provider) {
+	if err := validateProvider(p); err != nil {
+		panic(err)
+	}
 	provider[p.Name] = p
 }
 
+func validateProvider(p Provider) error {
+	if !providerNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("oauth2: invalid provider name %q, expected lower case letters, digits, '-' and '_'", p.Name)
+	}
+	if _, exist := provider[p.Name]; exist {
+		return fmt.Errorf("oauth2: provider %v is registered twice", p.Name)
+	}
+	if p.GetUserInfo == nil && p.NewFromOpts == nil {
+		return fmt.Errorf("oauth2: provider %v has no GetUserInfo", p.Name)
+	}
+	return nil
+}
+
 // UnRegisterProvider removes a provider
 func UnRegisterProvider(name string) {
 	delete(provider, name)
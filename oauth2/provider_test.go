@@ -3,6 +3,7 @@ package oauth2
 import (
 	"testing"
 
+	"github.com/afdecastro879/loginsrv/model"
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -27,11 +28,67 @@ func Test_ProviderRegistration(t *testing.T) {
 	NotNil(t, gitlab)
 	True(t, exist)
 
+	apple, exist := GetProvider("apple")
+	NotNil(t, apple)
+	True(t, exist)
+
+	microsoft, exist := GetProvider("microsoft")
+	NotNil(t, microsoft)
+	True(t, exist)
+
+	okta, exist := GetProvider("okta")
+	NotNil(t, okta)
+	True(t, exist)
+
+	auth0, exist := GetProvider("auth0")
+	NotNil(t, auth0)
+	True(t, exist)
+
+	slack, exist := GetProvider("slack")
+	NotNil(t, slack)
+	True(t, exist)
+
+	amazon, exist := GetProvider("amazon")
+	NotNil(t, amazon)
+	True(t, exist)
+
+	twitter, exist := GetProvider("twitter")
+	NotNil(t, twitter)
+	True(t, exist)
+
+	keycloak, exist := GetProvider("keycloak")
+	NotNil(t, keycloak)
+	True(t, exist)
+
 	list := ProviderList()
-	Equal(t, 5, len(list))
+	Equal(t, 13, len(list))
 	Contains(t, list, "github")
 	Contains(t, list, "google")
 	Contains(t, list, "bitbucket")
 	Contains(t, list, "facebook")
 	Contains(t, list, "gitlab")
+	Contains(t, list, "apple")
+	Contains(t, list, "microsoft")
+	Contains(t, list, "okta")
+	Contains(t, list, "auth0")
+	Contains(t, list, "slack")
+	Contains(t, list, "amazon")
+	Contains(t, list, "twitter")
+	Contains(t, list, "keycloak")
+}
+
+func Test_ProviderRegistrationValidation(t *testing.T) {
+	getUserInfo := func(token TokenInfo) (model.UserInfo, string, error) {
+		return model.UserInfo{}, "", nil
+	}
+
+	Panics(t, func() { RegisterProvider(Provider{Name: "github", GetUserInfo: getUserInfo}) })
+	Panics(t, func() { RegisterProvider(Provider{Name: "", GetUserInfo: getUserInfo}) })
+	Panics(t, func() { RegisterProvider(Provider{Name: "My IdP", GetUserInfo: getUserInfo}) })
+	Panics(t, func() { RegisterProvider(Provider{Name: "myidp"}) })
+
+	NotPanics(t, func() { RegisterProvider(Provider{Name: "my-idp_2", GetUserInfo: getUserInfo}) })
+	defer UnRegisterProvider("my-idp_2")
+	_, exist := GetProvider("my-idp_2")
+	True(t, exist)
 }
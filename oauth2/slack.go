@@ -0,0 +1,127 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+var slackUserinfoEndpoint = "https://slack.com/api/openid.connect.userInfo"
+
+func init() {
+	providerSlack.NewFromOpts = newSlackProvider
+	RegisterProvider(providerSlack)
+}
+
+// slackUser is used for parsing the slack openid connect userinfo response
+type slackUser struct {
+	OK            bool   `json:"ok"`
+	Error         string `json:"error"`
+	Sub           string `json:"sub"`
+	UserID        string `json:"https://slack.com/user_id"`
+	TeamID        string `json:"https://slack.com/team_id"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+var providerSlack = Provider{
+	Name:          "slack",
+	AuthURL:       "https://slack.com/openid/connect/authorize",
+	TokenURL:      "https://slack.com/api/openid.connect.token",
+	DefaultScopes: "openid profile email",
+	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+		su := slackUser{}
+
+		req, _ := http.NewRequest("GET", slackUserinfoEndpoint, nil)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+		defer resp.Body.Close()
+
+		if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on slack get user info: %v", resp.Header.Get("Content-Type"))
+		}
+
+		if resp.StatusCode != 200 {
+			return model.UserInfo{}, "", fmt.Errorf("got http status %v on slack get user info", resp.StatusCode)
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("error reading slack get user info: %v", err)
+		}
+
+		err = json.Unmarshal(b, &su)
+		if err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("error parsing slack get user info: %v", err)
+		}
+
+		// the slack web api reports errors with status 200 and ok false
+		if !su.OK {
+			return model.UserInfo{}, "", fmt.Errorf("got error %q on slack get user info", su.Error)
+		}
+
+		userID := su.UserID
+		if userID == "" {
+			userID = su.Sub
+		}
+		if userID == "" {
+			return model.UserInfo{}, "", &MissingFieldError{Provider: "slack", Field: "sub"}
+		}
+
+		return model.UserInfo{
+			Sub:      userID,
+			Picture:  su.Picture,
+			Name:     su.Name,
+			Email:    su.Email,
+			Origin:   "slack",
+			Verified: su.EmailVerified,
+			Domain:   su.TeamID,
+		}, string(b), nil
+	},
+}
+
+// newSlackProvider creates a slack provider, which only accepts users of the workspaces from the options.
+// The team ids of the workspaces are separated by ';'. Without a team option, users of all workspaces are accepted.
+func newSlackProvider(opts map[string]string) (Provider, error) {
+	teamOpt, exist := opts["team"]
+	if !exist {
+		return providerSlack, nil
+	}
+
+	teams := map[string]bool{}
+	for _, team := range strings.Split(teamOpt, ";") {
+		if team == "" {
+			return Provider{}, fmt.Errorf("invalid parameter value %q in \"team\" for slack provider", teamOpt)
+		}
+		teams[team] = true
+	}
+
+	p := providerSlack
+	if len(teams) == 1 {
+		// the team parameter skips the workspace selection, the team is verified with the user info
+		p.AuthParams = map[string]string{"team": teamOpt}
+	}
+	p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+		u, rawJSON, err := providerSlack.GetUserInfo(token)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+		if !teams[u.Domain] {
+			return model.UserInfo{}, "", &AccessDeniedError{
+				Provider: "slack",
+				Reason:   fmt.Sprintf("workspace %q of %v is not allowed", u.Domain, u.Sub),
+			}
+		}
+		return u, rawJSON, nil
+	}
+	return p, nil
+}
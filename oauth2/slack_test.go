@@ -0,0 +1,117 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var slackTestUserResponse = `{
+  "ok": true,
+  "sub": "U0R7JM",
+  "https://slack.com/user_id": "U0R7JM",
+  "https://slack.com/team_id": "T0R7GR",
+  "email": "krane@slack-corp.com",
+  "email_verified": true,
+  "date_email_verified": 1622128723,
+  "name": "krane",
+  "picture": "https://secure.gravatar.com/avatar/2f4d.jpg",
+  "given_name": "Bront",
+  "family_name": "Labradoodle",
+  "locale": "en-US",
+  "https://slack.com/team_name": "kraneflannel",
+  "https://slack.com/team_domain": "kraneflannel"
+}`
+
+func Test_Slack_getUserInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(slackTestUserResponse))
+	}))
+	defer server.Close()
+	slackUserinfoEndpoint = server.URL
+
+	u, rawJSON, err := providerSlack.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:      "U0R7JM",
+		Picture:  "https://secure.gravatar.com/avatar/2f4d.jpg",
+		Name:     "krane",
+		Email:    "krane@slack-corp.com",
+		Origin:   "slack",
+		Verified: true,
+		Domain:   "T0R7GR",
+	}, u)
+	Equal(t, slackTestUserResponse, rawJSON)
+}
+
+func Test_Slack_getUserInfo_Errors(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		status      int
+		body        string
+	}{
+		{"text/html", 200, slackTestUserResponse},
+		{"application/json", 500, `{"ok": false}`},
+		{"application/json", 200, `{"ok": false, "error": "invalid_auth"}`},
+		{"application/json", 200, `{"ok": true, "name": "krane"}`},
+		{"application/json", 200, `not json`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		slackUserinfoEndpoint = server.URL
+
+		_, _, err := providerSlack.GetUserInfo(TokenInfo{AccessToken: "secret"})
+		Error(t, err)
+		server.Close()
+	}
+}
+
+func Test_Slack_Team(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(slackTestUserResponse))
+	}))
+	defer server.Close()
+	slackUserinfoEndpoint = server.URL
+
+	m := NewManager()
+	NoError(t, m.AddConfig("slack", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"team":          "T0R7GR",
+	}))
+	cfg := m.GetConfigs()["slack"]
+	Equal(t, "openid profile email", cfg.Scope)
+	Equal(t, map[string]string{"team": "T0R7GR"}, cfg.Provider.AuthParams)
+
+	u, _, err := cfg.Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "T0R7GR", u.Domain)
+
+	NoError(t, m.AddConfig("slack", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"team":          "T1111;T2222",
+	}))
+	cfg = m.GetConfigs()["slack"]
+	Nil(t, cfg.Provider.AuthParams)
+
+	_, _, err = cfg.Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	IsType(t, &AccessDeniedError{}, err)
+	EqualError(t, err, `access denied by slack provider options: workspace "T0R7GR" of U0R7JM is not allowed`)
+
+	err = m.AddConfig("slack", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"team":          ";",
+	})
+	Error(t, err)
+}
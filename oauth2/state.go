@@ -0,0 +1,231 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// StateStoreMemory is the value of the oauth2-state-store option for the in memory store
+const StateStoreMemory = "memory"
+
+// stateExpiry is the time, a user has for the login at the provider
+const stateExpiry = 10 * time.Minute
+
+const redisStatePrefix = "loginsrv:oauth-state:"
+
+// maxMemoryStates is the maximum number of started flows in the memory store,
+// so that the unauthenticated starts of flows can't fill the memory within the stateExpiry
+const maxMemoryStates = 10000
+
+// FlowState is the server side state of a started oauth flow.
+type FlowState struct {
+	// Provider is the name of the provider, the flow was started for.
+	Provider string `json:"provider"`
+	// UserAgent is the hash of the user agent, which started the flow.
+	UserAgent string `json:"user_agent"`
+	// RedirectTarget is the redirect target of the login at the start of the flow.
+	RedirectTarget string `json:"redirect_target,omitempty"`
+	// CodeVerifier is the PKCE code verifier, which is sent on the token exchange.
+	CodeVerifier string `json:"code_verifier,omitempty"`
+}
+
+// StateStore holds the state parameters of the started oauth flows until the callback.
+type StateStore interface {
+	// Save stores the state of the flow until the expiry.
+	Save(state string, flowState FlowState, expiry time.Time) error
+
+	// Take returns and removes the state of the flow, so that every state can only be used once.
+	// It returns false, if the state does not exist or has expired.
+	Take(state string) (FlowState, bool, error)
+}
+
+// NewStateStore creates the store for the oauth2-state-store option:
+// memory or a redis url like redis://localhost:6379/0. The memory store is the default, if the option is empty.
+func NewStateStore(store string) (StateStore, error) {
+	switch {
+	case store == "" || store == StateStoreMemory:
+		return newMemoryStateStore(), nil
+	case strings.HasPrefix(store, "redis://"):
+		return newRedisStateStore(store)
+	default:
+		return nil, fmt.Errorf("unsupported oauth state store %q, expected %q or a redis:// url", store, StateStoreMemory)
+	}
+}
+
+type memoryFlowState struct {
+	flowState FlowState
+	expiry    time.Time
+}
+
+// memoryStateStore is a StateStore backed by a map.
+// The states are not shared between multiple instances, so the callback has to reach the instance, which started the flow.
+type memoryStateStore struct {
+	mutex  sync.Mutex
+	states map[string]memoryFlowState
+	// limit is the maximum number of states, the state, which expires first, is removed for a new one
+	limit int
+}
+
+func newMemoryStateStore() *memoryStateStore {
+	return &memoryStateStore{states: map[string]memoryFlowState{}, limit: maxMemoryStates}
+}
+
+func (s *memoryStateStore) Save(state string, flowState FlowState, expiry time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// remove the states of the abandoned flows
+	now := time.Now()
+	for st, entry := range s.states {
+		if now.After(entry.expiry) {
+			delete(s.states, st)
+		}
+	}
+	for len(s.states) >= s.limit {
+		s.removeFirstExpiry()
+	}
+	s.states[state] = memoryFlowState{flowState: flowState, expiry: expiry}
+	return nil
+}
+
+// removeFirstExpiry removes the state, which expires first, which is the oldest flow.
+func (s *memoryStateStore) removeFirstExpiry() {
+	first := ""
+	for st, entry := range s.states {
+		if first == "" || entry.expiry.Before(s.states[first].expiry) {
+			first = st
+		}
+	}
+	delete(s.states, first)
+}
+
+func (s *memoryStateStore) Take(state string) (FlowState, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, exist := s.states[state]
+	delete(s.states, state)
+	if !exist || time.Now().After(entry.expiry) {
+		return FlowState{}, false, nil
+	}
+	return entry.flowState, true, nil
+}
+
+// redisStateStore is a StateStore backed by redis, which can be shared between multiple instances.
+type redisStateStore struct {
+	client *redis.Client
+}
+
+func newRedisStateStore(redisURL string) (*redisStateStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid redis url for the oauth state store")
+	}
+	return &redisStateStore{client: redis.NewClient(opts)}, nil
+}
+
+func (s *redisStateStore) Save(state string, flowState FlowState, expiry time.Time) error {
+	ttl := time.Until(expiry)
+	if ttl <= 0 {
+		return nil
+	}
+	b, err := json.Marshal(flowState)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(redisStatePrefix+state, b, ttl).Err()
+}
+
+func (s *redisStateStore) Take(state string) (FlowState, bool, error) {
+	// get and delete in one transaction, so that concurrent callbacks can't use the same state
+	pipe := s.client.TxPipeline()
+	get := pipe.Get(redisStatePrefix + state)
+	pipe.Del(redisStatePrefix + state)
+	_, err := pipe.Exec()
+	if err == redis.Nil {
+		return FlowState{}, false, nil
+	}
+	if err != nil {
+		return FlowState{}, false, err
+	}
+	flowState := FlowState{}
+	if err := json.Unmarshal([]byte(get.Val()), &flowState); err != nil {
+		return FlowState{}, false, err
+	}
+	return flowState, true, nil
+}
+
+type redirectTargetKey struct{}
+
+// WithRedirectTarget returns a context with the redirect target of the login,
+// which is bound to the state of the oauth flow.
+func WithRedirectTarget(ctx context.Context, target string) context.Context {
+	return context.WithValue(ctx, redirectTargetKey{}, target)
+}
+
+func redirectTarget(ctx context.Context) string {
+	target, _ := ctx.Value(redirectTargetKey{}).(string)
+	return target
+}
+
+// userAgentHash returns the hash of the user agent, so that the user agent is not stored in clear text.
+func userAgentHash(r *http.Request) string {
+	sum := sha256.Sum256([]byte(r.UserAgent()))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// newFlowState creates and stores the state parameter and, with PKCE, the code verifier for a new flow.
+func (manager *Manager) newFlowState(cfg *Config, r *http.Request) error {
+	state, err := newCodeVerifier()
+	if err != nil {
+		return err
+	}
+	flowState := FlowState{
+		Provider:       cfg.Provider.Name,
+		UserAgent:      userAgentHash(r),
+		RedirectTarget: redirectTarget(r.Context()),
+	}
+	if cfg.PKCE {
+		if flowState.CodeVerifier, err = newCodeVerifier(); err != nil {
+			return err
+		}
+	}
+	if err := manager.StateStore.Save(state, flowState, time.Now().Add(stateExpiry)); err != nil {
+		return err
+	}
+	cfg.State, cfg.CodeVerifier = state, flowState.CodeVerifier
+	return nil
+}
+
+// verifyFlowState checks the state parameter of the callback against the stored state of the flow
+// and sets the code verifier of the flow. The state is removed, so a replay of the callback is rejected.
+func (manager *Manager) verifyFlowState(cfg *Config, r *http.Request) error {
+	flowState, exist, err := manager.StateStore.Take(r.FormValue("state"))
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("error: oauth state is unknown, expired or was already used")
+	}
+	if flowState.Provider != cfg.Provider.Name {
+		return fmt.Errorf("error: oauth state was issued for another provider")
+	}
+	if flowState.UserAgent != userAgentHash(r) {
+		return fmt.Errorf("error: oauth state was issued for another user agent")
+	}
+	// the redirect cookie may be missing on a cross site callback, but it must not be changed during the flow
+	if target := redirectTarget(r.Context()); target != "" && target != flowState.RedirectTarget {
+		return fmt.Errorf("error: redirect target changed during the oauth flow")
+	}
+	cfg.CodeVerifier = flowState.CodeVerifier
+	return nil
+}
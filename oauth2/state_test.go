@@ -0,0 +1,195 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_Manager_FlowState(t *testing.T) {
+	exampleProvider := Provider{
+		Name:     "example",
+		AuthURL:  "https://example.com/login/oauth/authorize",
+		TokenURL: "https://example.com/login/oauth/access_token",
+		GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+			return model.UserInfo{Sub: "the-username"}, "", nil
+		},
+	}
+	RegisterProvider(exampleProvider)
+	defer UnRegisterProvider(exampleProvider.Name)
+
+	m := NewManager()
+	NoError(t, m.AddConfig(exampleProvider.Name, map[string]string{"client_id": "client42", "client_secret": "secret"}))
+	NoError(t, m.AddConfig("github", map[string]string{"client_id": "client42", "client_secret": "secret"}))
+	m.authenticate = func(cfg Config, r *http.Request) (TokenInfo, error) {
+		return TokenInfo{AccessToken: "the-access-token"}, nil
+	}
+
+	startFlow := func(provider, userAgent, target string) string {
+		r, _ := http.NewRequest("GET", "http://example.com/login/"+provider, nil)
+		r.Header.Set("User-Agent", userAgent)
+		r = r.WithContext(WithRedirectTarget(r.Context(), target))
+		w := httptest.NewRecorder()
+		startedFlow, _, _, err := m.Handle(w, r)
+		NoError(t, err)
+		True(t, startedFlow)
+		location, err := w.Result().Location()
+		NoError(t, err)
+		return location.Query().Get("state")
+	}
+	callback := func(state, userAgent, target string) error {
+		r, _ := http.NewRequest("GET", "http://example.com/login/example?code=xyz&state="+state, nil)
+		r.Header.Set("User-Agent", userAgent)
+		r.Header.Set("Cookie", "oauthState="+state)
+		r = r.WithContext(WithRedirectTarget(r.Context(), target))
+		_, _, _, err := m.Handle(httptest.NewRecorder(), r)
+		return err
+	}
+
+	state := startFlow("example", "browser", "/app")
+	NoError(t, callback(state, "browser", "/app"))
+	// a replay of the callback is rejected
+	EqualError(t, callback(state, "browser", "/app"), "error: oauth state is unknown, expired or was already used")
+
+	EqualError(t, callback("unknown", "browser", ""), "error: oauth state is unknown, expired or was already used")
+
+	state = startFlow("example", "browser", "/app")
+	EqualError(t, callback(state, "another browser", "/app"), "error: oauth state was issued for another user agent")
+
+	state = startFlow("github", "browser", "")
+	EqualError(t, callback(state, "browser", ""), "error: oauth state was issued for another provider")
+
+	state = startFlow("example", "browser", "/app")
+	EqualError(t, callback(state, "browser", "/evil"), "error: redirect target changed during the oauth flow")
+
+	// the redirect cookie may be missing on a cross site callback
+	state = startFlow("example", "browser", "/app")
+	NoError(t, callback(state, "browser", ""))
+}
+
+func Test_Manager_FlowState_PKCE(t *testing.T) {
+	exampleProvider := Provider{
+		Name:     "example",
+		AuthURL:  "https://example.com/login/oauth/authorize",
+		TokenURL: "https://example.com/login/oauth/access_token",
+		GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+			return model.UserInfo{Sub: "the-username"}, "", nil
+		},
+	}
+	RegisterProvider(exampleProvider)
+	defer UnRegisterProvider(exampleProvider.Name)
+
+	m := NewManager()
+	NoError(t, m.AddConfig(exampleProvider.Name, map[string]string{"client_id": "client42", "public_client": "true"}))
+	var verifier string
+	m.authenticate = func(cfg Config, r *http.Request) (TokenInfo, error) {
+		verifier = cfg.CodeVerifier
+		return TokenInfo{AccessToken: "the-access-token"}, nil
+	}
+
+	r, _ := http.NewRequest("GET", "http://example.com/login/example", nil)
+	w := httptest.NewRecorder()
+	_, _, _, err := m.Handle(w, r)
+	NoError(t, err)
+	location, err := w.Result().Location()
+	NoError(t, err)
+	state := location.Query().Get("state")
+	// only the state cookie is set, the verifier is stored with the state
+	Equal(t, 1, len(w.Result().Cookies()))
+
+	r, _ = http.NewRequest("GET", "http://example.com/login/example?code=xyz&state="+state, nil)
+	r.Header.Set("Cookie", "oauthState="+state)
+	_, _, _, err = m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	Equal(t, 43, len(verifier))
+	Equal(t, codeChallenge(verifier), location.Query().Get("code_challenge"))
+}
+
+func Test_MemoryStateStore(t *testing.T) {
+	s := newMemoryStateStore()
+	NoError(t, s.Save("a", FlowState{Provider: "github"}, time.Now().Add(time.Minute)))
+	NoError(t, s.Save("b", FlowState{Provider: "github"}, time.Now().Add(-time.Second)))
+
+	flowState, exist, err := s.Take("a")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, FlowState{Provider: "github"}, flowState)
+
+	_, exist, _ = s.Take("a")
+	False(t, exist)
+	_, exist, _ = s.Take("b")
+	False(t, exist)
+
+	// expired states are removed
+	NoError(t, s.Save("c", FlowState{}, time.Now().Add(-time.Second)))
+	NoError(t, s.Save("d", FlowState{}, time.Now().Add(time.Minute)))
+	Equal(t, 1, len(s.states))
+
+	// the oldest flow is removed, if the limit is reached
+	s.limit = 2
+	NoError(t, s.Save("e", FlowState{}, time.Now().Add(2*time.Minute)))
+	NoError(t, s.Save("f", FlowState{}, time.Now().Add(3*time.Minute)))
+	Equal(t, 2, len(s.states))
+	_, exist, _ = s.Take("d")
+	False(t, exist)
+	_, exist, _ = s.Take("e")
+	True(t, exist)
+	_, exist, _ = s.Take("f")
+	True(t, exist)
+}
+
+func Test_RedisStateStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	NoError(t, err)
+	defer mr.Close()
+
+	store, err := NewStateStore("redis://" + mr.Addr() + "/0")
+	NoError(t, err)
+	s := store.(*redisStateStore)
+
+	flowState := FlowState{Provider: "github", UserAgent: "ua", RedirectTarget: "/app", CodeVerifier: "theVerifier"}
+	NoError(t, s.Save("a", flowState, time.Now().Add(time.Minute)))
+	NoError(t, s.Save("b", flowState, time.Now().Add(-time.Second)))
+	InDelta(t, time.Minute.Seconds(), mr.TTL("loginsrv:oauth-state:a").Seconds(), 2)
+	False(t, mr.Exists("loginsrv:oauth-state:b"))
+
+	result, exist, err := s.Take("a")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, flowState, result)
+
+	_, exist, err = s.Take("a")
+	NoError(t, err)
+	False(t, exist)
+
+	mr.Close()
+	_, _, err = s.Take("a")
+	Error(t, err)
+}
+
+func Test_NewStateStore(t *testing.T) {
+	s, err := NewStateStore("")
+	NoError(t, err)
+	IsType(t, &memoryStateStore{}, s)
+
+	s, err = NewStateStore("memory")
+	NoError(t, err)
+	IsType(t, &memoryStateStore{}, s)
+
+	_, err = NewStateStore("redis://localhost:6379/foo")
+	Error(t, err)
+
+	_, err = NewStateStore("file")
+	Error(t, err)
+}
+
+func Test_redirectTarget(t *testing.T) {
+	Equal(t, "", redirectTarget(context.Background()))
+	Equal(t, "/app", redirectTarget(WithRedirectTarget(context.Background(), "/app")))
+}
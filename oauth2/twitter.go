@@ -0,0 +1,115 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+var twitterAPI = "https://api.twitter.com/2"
+
+func init() {
+	providerTwitter.NewFromOpts = newTwitterProvider
+	RegisterProvider(providerTwitter)
+}
+
+// twitterUser is used for parsing the twitter /2/users/me response
+type twitterUser struct {
+	Data struct {
+		ID              string `json:"id"`
+		Name            string `json:"name"`
+		Username        string `json:"username"`
+		ProfileImageURL string `json:"profile_image_url"`
+		ConfirmedEmail  string `json:"confirmed_email"`
+	} `json:"data"`
+}
+
+// providerTwitter uses the OAuth 2.0 user context flow of the twitter api v2.
+// Confidential clients authenticate with http basic auth on the token exchange.
+var providerTwitter = Provider{
+	Name:           "twitter",
+	AuthURL:        "https://twitter.com/i/oauth2/authorize",
+	TokenURL:       "https://api.twitter.com/2/oauth2/token",
+	DefaultScopes:  "users.read tweet.read",
+	TokenBasicAuth: true,
+	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+		return getTwitterUserInfo(token, false)
+	},
+}
+
+// newTwitterProvider checks, that PKCE is not disabled, because twitter requires it.
+// If the scope contains users.email, the confirmed email address of the user is requested.
+func newTwitterProvider(opts map[string]string) (Provider, error) {
+	if pkce, exist := opts["pkce"]; exist {
+		if enabled, err := strconv.ParseBool(pkce); err == nil && !enabled {
+			return Provider{}, fmt.Errorf("the twitter provider requires pkce")
+		}
+	}
+
+	p := providerTwitter
+	if scope, exist := opts["scope"]; exist {
+		withEmail := false
+		for _, s := range strings.Fields(scope) {
+			withEmail = withEmail || s == "users.email"
+		}
+		p.GetUserInfo = func(token TokenInfo) (model.UserInfo, string, error) {
+			return getTwitterUserInfo(token, withEmail)
+		}
+	}
+	return p, nil
+}
+
+// getTwitterUserInfo reads the authenticated user. Twitter only returns an email address
+// with the users.email scope, so the email is optional.
+func getTwitterUserInfo(token TokenInfo, withEmail bool) (model.UserInfo, string, error) {
+	tu := twitterUser{}
+
+	fields := "profile_image_url"
+	if withEmail {
+		fields += ",confirmed_email"
+	}
+	req, _ := http.NewRequest("GET", twitterAPI+"/users/me?user.fields="+fields, nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return model.UserInfo{}, "", fmt.Errorf("wrong content-type on twitter get user info: %v", resp.Header.Get("Content-Type"))
+	}
+
+	if resp.StatusCode != 200 {
+		return model.UserInfo{}, "", fmt.Errorf("got http status %v on twitter get user info", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error reading twitter get user info: %v", err)
+	}
+
+	err = json.Unmarshal(b, &tu)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error parsing twitter get user info: %v", err)
+	}
+
+	if tu.Data.Username == "" {
+		return model.UserInfo{}, "", &MissingFieldError{Provider: "twitter", Field: "username"}
+	}
+
+	return model.UserInfo{
+		Sub:     tu.Data.Username,
+		Picture: tu.Data.ProfileImageURL,
+		Name:    tu.Data.Name,
+		Email:   tu.Data.ConfirmedEmail,
+		Origin:  "twitter",
+		// twitter does not return a verification status of the account
+		Verified: true,
+	}, string(b), nil
+}
@@ -0,0 +1,102 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var twitterTestUserResponse = `{
+  "data": {
+    "id": "2244994945",
+    "name": "Twitter Dev",
+    "username": "TwitterDev",
+    "profile_image_url": "https://pbs.twimg.com/profile_images/1445764922474827784/W2zEPN7U_normal.jpg"
+  }
+}`
+
+func Test_Twitter_getUserInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "/users/me", r.URL.Path)
+		Equal(t, "profile_image_url", r.FormValue("user.fields"))
+		Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(twitterTestUserResponse))
+	}))
+	defer server.Close()
+	twitterAPI = server.URL
+
+	u, rawJSON, err := providerTwitter.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:      "TwitterDev",
+		Picture:  "https://pbs.twimg.com/profile_images/1445764922474827784/W2zEPN7U_normal.jpg",
+		Name:     "Twitter Dev",
+		Origin:   "twitter",
+		Verified: true,
+	}, u)
+	Equal(t, twitterTestUserResponse, rawJSON)
+}
+
+func Test_Twitter_getUserInfo_Email(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "profile_image_url,confirmed_email", r.FormValue("user.fields"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"data": {"id": "2244994945", "username": "TwitterDev", "confirmed_email": "dev@example.com"}}`))
+	}))
+	defer server.Close()
+	twitterAPI = server.URL
+
+	m := NewManager()
+	NoError(t, m.AddConfig("twitter", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"scope":         "users.read tweet.read users.email",
+	}))
+	u, _, err := m.GetConfigs()["twitter"].Provider.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "dev@example.com", u.Email)
+}
+
+func Test_Twitter_getUserInfo_Errors(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		status      int
+		body        string
+	}{
+		{"text/html", 200, twitterTestUserResponse},
+		{"application/json", 401, `{"title": "Unauthorized", "status": 401}`},
+		{"application/json", 200, `{"errors": [{"title": "Not Found Error"}]}`},
+		{"application/json", 200, `not json`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		twitterAPI = server.URL
+
+		_, _, err := providerTwitter.GetUserInfo(TokenInfo{AccessToken: "secret"})
+		Error(t, err)
+		server.Close()
+	}
+}
+
+func Test_Twitter_RequiresPKCE(t *testing.T) {
+	m := NewManager()
+	NoError(t, m.AddConfig("twitter", map[string]string{"client_id": "client42", "public_client": "true"}))
+	cfg := m.GetConfigs()["twitter"]
+	True(t, cfg.PKCE)
+	True(t, cfg.Provider.TokenBasicAuth)
+	Equal(t, "users.read tweet.read", cfg.Scope)
+
+	err := m.AddConfig("twitter", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"pkce":          "false",
+	})
+	EqualError(t, err, "the twitter provider requires pkce")
+}
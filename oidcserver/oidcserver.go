@@ -0,0 +1,293 @@
+// Package oidcserver implements the authorization code flow of an OpenID Connect provider,
+// so that other applications can use loginsrv as their identity provider.
+// The users are authenticated by loginsrv. This package validates the requests of the clients,
+// issues the authorization codes and redeems them at the token endpoint.
+package oidcserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ScopeOpenID is the scope, which every authorization request of OpenID Connect has to contain
+const ScopeOpenID = "openid"
+
+const (
+	codeExpiry = time.Minute
+
+	// codeChallengeS256 is the only supported method of PKCE, because the plain method sends the verifier as challenge
+	codeChallengeS256 = "S256"
+)
+
+// Client is an application, which uses loginsrv as identity provider.
+// Clients without a secret are public clients, which have to use PKCE.
+type Client struct {
+	ID           string   `yaml:"id"`
+	Secret       string   `yaml:"secret"`
+	RedirectURIs []string `yaml:"redirect_uris"`
+	// RequirePKCE rejects the authorization requests of a confidential client without code challenge
+	RequirePKCE bool `yaml:"require_pkce"`
+}
+
+// ReadClients reads the clients from a YAML file,
+// which contains a list of clients with the keys id, secret, redirect_uris and require_pkce.
+func ReadClients(file string) ([]Client, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't read oidc clients file %v: %v", file, err)
+	}
+	clients := []Client{}
+	if err := yaml.UnmarshalStrict(b, &clients); err != nil {
+		return nil, fmt.Errorf("can't parse oidc clients file %v: %v", file, err)
+	}
+	return clients, nil
+}
+
+// Error is an error response of OAuth 2.0 (RFC 6749, section 4.1.2.1 and 5.2)
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func errorf(code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Description: fmt.Sprintf(format, a...)}
+}
+
+// AuthorizationRequest is a request of a client to the authorization endpoint
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// ErrorURL returns the redirect uri of the client with the error response.
+func (req *AuthorizationRequest) ErrorURL(err *Error) string {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	return req.redirect(params)
+}
+
+func (req *AuthorizationRequest) redirect(params url.Values) string {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	separator := "?"
+	if strings.Contains(req.RedirectURI, "?") {
+		separator = "&"
+	}
+	return req.RedirectURI + separator + params.Encode()
+}
+
+// Grant is the result of a redeemed code: the user, who authorized the client.
+type Grant struct {
+	UserInfo model.UserInfo
+	ClientID string
+	Scope    string
+	Nonce    string
+}
+
+// HasScope returns true, if the client requested the scope
+func (g Grant) HasScope(scope string) bool {
+	return contains(strings.Fields(g.Scope), scope)
+}
+
+type authorization struct {
+	request  AuthorizationRequest
+	userInfo model.UserInfo
+	expiry   time.Time
+}
+
+// Server holds the clients and the issued codes.
+// The codes are kept in memory, so they are not shared between multiple instances.
+type Server struct {
+	clients map[string]Client
+	mutex   sync.Mutex
+	codes   map[string]*authorization
+}
+
+// NewServer creates the server for the clients.
+func NewServer(clients []Client) (*Server, error) {
+	s := &Server{
+		clients: map[string]Client{},
+		codes:   map[string]*authorization{},
+	}
+	for _, c := range clients {
+		if c.ID == "" {
+			return nil, errors.New("missing id of an oidc client")
+		}
+		if _, exist := s.clients[c.ID]; exist {
+			return nil, fmt.Errorf("duplicate oidc client %q", c.ID)
+		}
+		if len(c.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("missing redirect_uris of oidc client %q", c.ID)
+		}
+		for _, redirectURI := range c.RedirectURIs {
+			u, err := url.Parse(redirectURI)
+			if err != nil || !u.IsAbs() || u.Fragment != "" {
+				return nil, fmt.Errorf("invalid redirect uri %q of oidc client %q, expected an absolute url without fragment", redirectURI, c.ID)
+			}
+		}
+		s.clients[c.ID] = c
+	}
+	return s, nil
+}
+
+// ParseAuthorizationRequest validates the query of the authorization endpoint.
+// If the client or the redirect uri is not valid, no request is returned,
+// because the user must not be redirected to an unknown uri.
+// For all other errors, the request is returned, so that the error can be sent to the client by ErrorURL.
+func (s *Server) ParseAuthorizationRequest(query url.Values) (*AuthorizationRequest, *Error) {
+	req := &AuthorizationRequest{
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	client, exist := s.clients[req.ClientID]
+	if !exist {
+		return nil, errorf("invalid_request", "unknown client %q", req.ClientID)
+	}
+	if !contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, errorf("invalid_request", "redirect uri %q is not registered for the client", req.RedirectURI)
+	}
+
+	if responseType := query.Get("response_type"); responseType != "code" {
+		return req, errorf("unsupported_response_type", "only the response type code is supported")
+	}
+	if !contains(strings.Fields(req.Scope), ScopeOpenID) {
+		return req, errorf("invalid_scope", "the scope %v is required", ScopeOpenID)
+	}
+	if (req.CodeChallenge != "" || req.CodeChallengeMethod != "") && req.CodeChallengeMethod != codeChallengeS256 {
+		return req, errorf("invalid_request", "unsupported code challenge method %q, only %v is supported", req.CodeChallengeMethod, codeChallengeS256)
+	}
+	if client.Secret == "" && req.CodeChallenge == "" {
+		return req, errorf("invalid_request", "public clients have to send a code challenge")
+	}
+	if client.RequirePKCE && req.CodeChallenge == "" {
+		return req, errorf("invalid_request", "the client has to send a code challenge")
+	}
+	return req, nil
+}
+
+// Authorize issues a code for the user and returns the redirect uri of the client with the code.
+func (s *Server) Authorize(req *AuthorizationRequest, userInfo model.UserInfo) (string, error) {
+	code, err := randomCode()
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// remove the expired codes, which can't be used anyway
+	now := time.Now()
+	for c, a := range s.codes {
+		if now.After(a.expiry) {
+			delete(s.codes, c)
+		}
+	}
+	s.codes[code] = &authorization{request: *req, userInfo: userInfo, expiry: now.Add(codeExpiry)}
+
+	return req.redirect(url.Values{"code": {code}}), nil
+}
+
+// Exchange redeems the code of a token request. The client authenticates by basic auth
+// or by the form parameters client_id and client_secret. Every code can only be used once.
+func (s *Server) Exchange(r *http.Request) (Grant, *Error) {
+	r.ParseForm()
+	if grantType := r.PostForm.Get("grant_type"); grantType != "authorization_code" {
+		return Grant{}, errorf("unsupported_grant_type", "only the grant type authorization_code is supported")
+	}
+
+	clientID, clientSecret, hasBasicAuth := r.BasicAuth()
+	if !hasBasicAuth {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, exist := s.clients[clientID]
+	if !exist || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1 {
+		return Grant{}, errorf("invalid_client", "client authentication failed")
+	}
+
+	s.mutex.Lock()
+	a, exist := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mutex.Unlock()
+
+	switch {
+	case !exist || time.Now().After(a.expiry):
+		return Grant{}, errorf("invalid_grant", "unknown or expired code")
+	case a.request.ClientID != clientID:
+		return Grant{}, errorf("invalid_grant", "the code was issued to another client")
+	case a.request.RedirectURI != r.PostForm.Get("redirect_uri"):
+		return Grant{}, errorf("invalid_grant", "the redirect uri does not match the authorization request")
+	case !verifyCodeChallenge(a.request, r.PostForm.Get("code_verifier")):
+		return Grant{}, errorf("invalid_grant", "invalid code verifier")
+	}
+
+	return Grant{
+		UserInfo: a.userInfo,
+		ClientID: clientID,
+		Scope:    a.request.Scope,
+		Nonce:    a.request.Nonce,
+	}, nil
+}
+
+// verifyCodeChallenge checks the code verifier of PKCE (RFC 7636)
+func verifyCodeChallenge(req AuthorizationRequest, verifier string) bool {
+	switch req.CodeChallengeMethod {
+	case "":
+		return true
+	case codeChallengeS256:
+		sum := sha256.Sum256([]byte(verifier))
+		return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(req.CodeChallenge)) == 1
+	default:
+		return false
+	}
+}
+
+func randomCode() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
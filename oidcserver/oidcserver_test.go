@@ -0,0 +1,221 @@
+package oidcserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var testClients = []Client{
+	{ID: "wiki", Secret: "s3cr3t", RedirectURIs: []string{"https://wiki.example.com/callback"}},
+	{ID: "cli", RedirectURIs: []string{"http://localhost:8000/callback?app=cli"}},
+	{ID: "dashboard", Secret: "s3cr3t", RedirectURIs: []string{"https://dashboard.example.com/callback"}, RequirePKCE: true},
+}
+
+func TestReadClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oidcserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "clients.yml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`
+- id: wiki
+  secret: s3cr3t
+  redirect_uris:
+    - https://wiki.example.com/callback
+- id: dashboard
+  secret: s3cr3t
+  redirect_uris:
+    - https://dashboard.example.com/callback
+  require_pkce: true
+`), 0600))
+	clients, err := ReadClients(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []Client{testClients[0], testClients[2]}, clients)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte("- id: wiki\n  redirect_url: https://wiki.example.com\n"), 0600))
+	_, err = ReadClients(file)
+	assert.Error(t, err)
+
+	_, err = ReadClients(filepath.Join(dir, "does-not-exist.yml"))
+	assert.Error(t, err)
+}
+
+func TestNewServer_Errors(t *testing.T) {
+	for _, clients := range [][]Client{
+		{{RedirectURIs: []string{"https://example.com/callback"}}},
+		{{ID: "wiki"}},
+		{{ID: "wiki", RedirectURIs: []string{"/callback"}}},
+		{{ID: "wiki", RedirectURIs: []string{"https://example.com/callback#fragment"}}},
+		{testClients[0], testClients[0]},
+	} {
+		_, err := NewServer(clients)
+		assert.Error(t, err)
+	}
+}
+
+func TestServer_ParseAuthorizationRequest(t *testing.T) {
+	s, err := NewServer(testClients)
+	assert.NoError(t, err)
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"wiki"},
+		"redirect_uri":  {"https://wiki.example.com/callback"},
+		"scope":         {"openid email"},
+		"state":         {"xyz"},
+		"nonce":         {"n-0S6"},
+	}
+	req, oidcErr := s.ParseAuthorizationRequest(query)
+	assert.Nil(t, oidcErr)
+	assert.Equal(t, &AuthorizationRequest{
+		ClientID:    "wiki",
+		RedirectURI: "https://wiki.example.com/callback",
+		Scope:       "openid email",
+		State:       "xyz",
+		Nonce:       "n-0S6",
+	}, req)
+
+	testCases := []struct {
+		param       string
+		value       string
+		code        string
+		withRequest bool
+	}{
+		{"client_id", "unknown", "invalid_request", false},
+		{"redirect_uri", "https://evil.example.com/callback", "invalid_request", false},
+		{"response_type", "token", "unsupported_response_type", true},
+		{"scope", "email", "invalid_scope", true},
+		{"code_challenge_method", "S512", "invalid_request", true},
+		{"code_challenge_method", "plain", "invalid_request", true},
+	}
+	for _, test := range testCases {
+		t.Run(test.param, func(t *testing.T) {
+			q := url.Values{}
+			for k, v := range query {
+				q[k] = v
+			}
+			q.Set(test.param, test.value)
+			req, oidcErr := s.ParseAuthorizationRequest(q)
+			assert.Equal(t, test.code, oidcErr.Code)
+			assert.Equal(t, test.withRequest, req != nil)
+		})
+	}
+
+	// public clients have to use PKCE
+	query.Set("client_id", "cli")
+	query.Set("redirect_uri", "http://localhost:8000/callback?app=cli")
+	req, oidcErr = s.ParseAuthorizationRequest(query)
+	assert.Equal(t, "invalid_request", oidcErr.Code)
+	assert.Equal(t, "http://localhost:8000/callback?app=cli&error=invalid_request&error_description=public+clients+have+to+send+a+code+challenge&state=xyz",
+		req.ErrorURL(oidcErr))
+
+	// the plain method, which is the default without a method, is not supported
+	query.Set("code_challenge", "challenge")
+	_, oidcErr = s.ParseAuthorizationRequest(query)
+	assert.Equal(t, "invalid_request", oidcErr.Code)
+
+	query.Set("code_challenge_method", "S256")
+	req, oidcErr = s.ParseAuthorizationRequest(query)
+	assert.Nil(t, oidcErr)
+	assert.Equal(t, "S256", req.CodeChallengeMethod)
+
+	// confidential clients with require_pkce have to use PKCE as well
+	query.Set("client_id", "dashboard")
+	query.Set("redirect_uri", "https://dashboard.example.com/callback")
+	query.Del("code_challenge")
+	query.Del("code_challenge_method")
+	req, oidcErr = s.ParseAuthorizationRequest(query)
+	assert.Equal(t, "invalid_request", oidcErr.Code)
+	assert.Equal(t, "https://dashboard.example.com/callback?error=invalid_request&error_description=the+client+has+to+send+a+code+challenge&state=xyz",
+		req.ErrorURL(oidcErr))
+
+	query.Set("code_challenge", "challenge")
+	query.Set("code_challenge_method", "S256")
+	_, oidcErr = s.ParseAuthorizationRequest(query)
+	assert.Nil(t, oidcErr)
+}
+
+func TestServer_Exchange(t *testing.T) {
+	s, err := NewServer(testClients)
+	assert.NoError(t, err)
+
+	req := &AuthorizationRequest{ClientID: "wiki", RedirectURI: "https://wiki.example.com/callback", Scope: "openid email", State: "xyz", Nonce: "n-0S6"}
+	code := authorize(t, s, req)
+
+	// wrong secret
+	r := tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {req.RedirectURI}})
+	r.SetBasicAuth("wiki", "wrong")
+	_, oidcErr := s.Exchange(r)
+	assert.Equal(t, "invalid_client", oidcErr.Code)
+
+	r = tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {req.RedirectURI}})
+	r.SetBasicAuth("wiki", "s3cr3t")
+	grant, oidcErr := s.Exchange(r)
+	assert.Nil(t, oidcErr)
+	assert.Equal(t, Grant{UserInfo: model.UserInfo{Sub: "marvin"}, ClientID: "wiki", Scope: "openid email", Nonce: "n-0S6"}, grant)
+	assert.True(t, grant.HasScope("email"))
+	assert.False(t, grant.HasScope("profile"))
+
+	// the code can only be used once
+	r = tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {req.RedirectURI},
+		"client_id": {"wiki"}, "client_secret": {"s3cr3t"}})
+	_, oidcErr = s.Exchange(r)
+	assert.Equal(t, "invalid_grant", oidcErr.Code)
+
+	// the redirect uri has to match
+	code = authorize(t, s, req)
+	r = tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://wiki.example.com/other"},
+		"client_id": {"wiki"}, "client_secret": {"s3cr3t"}})
+	_, oidcErr = s.Exchange(r)
+	assert.Equal(t, "invalid_grant", oidcErr.Code)
+
+	r = tokenRequest(url.Values{"grant_type": {"password"}})
+	_, oidcErr = s.Exchange(r)
+	assert.Equal(t, "unsupported_grant_type", oidcErr.Code)
+}
+
+func TestServer_Exchange_PKCE(t *testing.T) {
+	s, err := NewServer(testClients)
+	assert.NoError(t, err)
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	req := &AuthorizationRequest{ClientID: "cli", RedirectURI: "http://localhost:8000/callback?app=cli", Scope: "openid",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]), CodeChallengeMethod: "S256"}
+
+	code := authorize(t, s, req)
+	_, oidcErr := s.Exchange(tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {req.RedirectURI},
+		"client_id": {"cli"}, "code_verifier": {"wrong"}}))
+	assert.Equal(t, "invalid_grant", oidcErr.Code)
+
+	code = authorize(t, s, req)
+	grant, oidcErr := s.Exchange(tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {req.RedirectURI},
+		"client_id": {"cli"}, "code_verifier": {verifier}}))
+	assert.Nil(t, oidcErr)
+	assert.Equal(t, "cli", grant.ClientID)
+}
+
+func authorize(t *testing.T, s *Server, req *AuthorizationRequest) string {
+	redirectURL, err := s.Authorize(req, model.UserInfo{Sub: "marvin"})
+	assert.NoError(t, err)
+	u, err := url.Parse(redirectURL)
+	assert.NoError(t, err)
+	assert.Equal(t, req.State, u.Query().Get("state"))
+	return u.Query().Get("code")
+}
+
+func tokenRequest(form url.Values) *http.Request {
+	r, _ := http.NewRequest("POST", "https://login.example.com/login/oidc/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
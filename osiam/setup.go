@@ -13,6 +13,14 @@ func init() {
 		&login.ProviderDescription{
 			Name:     OsiamProviderName,
 			HelpText: "Osiam login backend opts: endpoint=..,client_id=..,client_secret=..",
+			Options: []login.OptionDescription{
+				{Name: "endpoint", Type: login.OptionURL, Required: true},
+				{Name: "client_id"},
+				{Name: "client_secret"},
+				// deprecated names of client_id and client_secret
+				{Name: "clientId"},
+				{Name: "clientSecret"},
+			},
 		},
 		func(config map[string]string) (login.Backend, error) {
 			if config["clientId"] != "" {
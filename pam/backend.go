@@ -0,0 +1,134 @@
+package pam
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// ProviderName const
+const ProviderName = "pam"
+
+const defaultService = "login"
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "PAM login backend opts (needs a build with cgo and -tags pam): [service=..][,groups=true]",
+			Options: []login.OptionDescription{
+				{Name: "service"},
+				{Name: "groups", Type: login.OptionBool},
+			},
+		},
+		BackendFactory)
+}
+
+// Config of the pam backend
+type Config struct {
+	// Service is the name of the pam configuration in /etc/pam.d
+	Service string
+	// Groups adds the unix groups of the user to the user info
+	Groups bool
+}
+
+// BackendFactory creates a pam backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg, err := parseConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		return nil, errors.New("pam provider is not available, loginsrv has to be built with cgo and -tags pam")
+	}
+	return NewBackend(cfg), nil
+}
+
+func parseConfig(opts map[string]string) (Config, error) {
+	cfg := Config{
+		Service: defaultService,
+	}
+	if v, exist := opts["service"]; exist {
+		if v == "" || strings.ContainsAny(v, "/\x00") {
+			return cfg, fmt.Errorf(`invalid parameter value "%s" in "service" for pam provider`, v)
+		}
+		cfg.Service = v
+	}
+	if v, exist := opts["groups"]; exist {
+		var err error
+		if cfg.Groups, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf(`invalid parameter value "%s" in "groups" for pam provider: %v`, v, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Backend is the pam authentication backend.
+type Backend struct {
+	config       Config
+	authenticate func(service, username, password string) (bool, error)
+	lookupUser   func(username string) (*user.User, error)
+}
+
+// NewBackend creates a new pam Backend.
+func NewBackend(cfg Config) *Backend {
+	return &Backend{
+		config:       cfg,
+		authenticate: authenticate,
+		lookupUser:   user.Lookup,
+	}
+}
+
+// Authenticate the user by the authentication and the account management of the pam service,
+// so that locked and expired accounts are rejected.
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if username == "" || password == "" {
+		return false, model.UserInfo{}, nil
+	}
+
+	authenticated, err := b.authenticate(b.config.Service, username, password)
+	if !authenticated || err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	userInfo := model.UserInfo{
+		Sub:    username,
+		Origin: ProviderName,
+	}
+	// a pam module may know users, which are not in the user database
+	u, err := b.lookupUser(username)
+	if err != nil {
+		return true, userInfo, nil
+	}
+	// the gecos field may contain the room and phone numbers after the name
+	userInfo.Name = strings.Split(u.Name, ",")[0]
+	if b.config.Groups {
+		if userInfo.Groups, err = groups(u); err != nil {
+			return false, model.UserInfo{}, err
+		}
+	}
+	return true, userInfo, nil
+}
+
+// groups returns the names of the unix groups of the user
+func groups(u *user.User) ([]string, error) {
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("can not read the groups of %v: %v", u.Username, err)
+	}
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			// a group without name
+			continue
+		}
+		names = append(names, g.Name)
+	}
+	return names, nil
+}
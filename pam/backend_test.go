@@ -0,0 +1,101 @@
+package pam
+
+import (
+	"errors"
+	"os/user"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{"service": "loginsrv", "groups": "true"})
+	if !supported {
+		Error(t, err)
+		return
+	}
+	NoError(t, err)
+	Equal(t, Config{Service: "loginsrv", Groups: true}, backend.(*Backend).config)
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig(map[string]string{})
+	NoError(t, err)
+	Equal(t, Config{Service: defaultService}, cfg)
+
+	cfg, err = parseConfig(map[string]string{"service": "loginsrv", "groups": "true"})
+	NoError(t, err)
+	Equal(t, Config{Service: "loginsrv", Groups: true}, cfg)
+
+	_, err = parseConfig(map[string]string{"service": "../shadow"})
+	Error(t, err)
+
+	_, err = parseConfig(map[string]string{"groups": "foo"})
+	Error(t, err)
+}
+
+func testBackend(cfg Config) *Backend {
+	b := NewBackend(cfg)
+	b.authenticate = func(service, username, password string) (bool, error) {
+		if service != cfg.Service {
+			return false, errors.New("unknown service")
+		}
+		return password == "secret", nil
+	}
+	b.lookupUser = func(username string) (*user.User, error) {
+		if username != "bob" {
+			return nil, user.UnknownUserError(username)
+		}
+		return &user.User{Username: "bob", Name: "Bob Builder,,,", Uid: "1000", Gid: "1000"}, nil
+	}
+	return b
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	b := testBackend(Config{Service: "loginsrv"})
+
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Sub: "bob", Name: "Bob Builder", Origin: ProviderName}, userInfo)
+
+	// users of pam modules, which are not in the user database
+	authenticated, userInfo, err = b.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Sub: "alice", Origin: ProviderName}, userInfo)
+
+	authenticated, _, err = b.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_Error(t *testing.T) {
+	b := testBackend(Config{Service: "loginsrv"})
+	b.config.Service = "other"
+
+	authenticated, _, err := b.Authenticate("bob", "secret")
+	Error(t, err)
+	False(t, authenticated)
+}
+
+func TestGroups(t *testing.T) {
+	u, err := user.Current()
+	NoError(t, err)
+	g, err := user.LookupGroupId(u.Gid)
+	NoError(t, err)
+
+	names, err := groups(u)
+	NoError(t, err)
+	Contains(t, names, g.Name)
+}
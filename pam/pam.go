@@ -0,0 +1,99 @@
+//go:build pam && cgo
+// +build pam,cgo
+
+package pam
+
+/*
+#cgo LDFLAGS: -lpam
+
+#include <security/pam_appl.h>
+#include <stdlib.h>
+#include <string.h>
+
+// conv answers the password prompts of the pam modules with the password in appdata.
+// Informational messages are ignored, other prompts can not be answered by a form login.
+static int conv(int n, const struct pam_message **msg, struct pam_response **resp, void *appdata) {
+	if (n <= 0 || n > PAM_MAX_NUM_MSG) {
+		return PAM_CONV_ERR;
+	}
+	struct pam_response *r = calloc(n, sizeof(struct pam_response));
+	if (r == NULL) {
+		return PAM_BUF_ERR;
+	}
+	for (int i = 0; i < n; i++) {
+		switch (msg[i]->msg_style) {
+		case PAM_PROMPT_ECHO_OFF:
+			r[i].resp = strdup((const char *)appdata);
+			if (r[i].resp == NULL) {
+				goto fail;
+			}
+			break;
+		case PAM_ERROR_MSG:
+		case PAM_TEXT_INFO:
+			break;
+		default:
+			goto fail;
+		}
+	}
+	*resp = r;
+	return PAM_SUCCESS;
+
+fail:
+	for (int i = 0; i < n; i++) {
+		if (r[i].resp != NULL) {
+			memset(r[i].resp, 0, strlen(r[i].resp));
+			free(r[i].resp);
+		}
+	}
+	free(r);
+	return PAM_CONV_ERR;
+}
+
+static int loginsrv_pam_authenticate(const char *service, const char *user, const char *password) {
+	struct pam_conv c = { conv, (void *)password };
+	pam_handle_t *h = NULL;
+	int rc = pam_start(service, user, &c, &h);
+	if (rc != PAM_SUCCESS) {
+		return rc;
+	}
+	rc = pam_authenticate(h, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	if (rc == PAM_SUCCESS) {
+		rc = pam_acct_mgmt(h, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	}
+	pam_end(h, rc);
+	return rc;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// supported is true, because the build is linked with libpam
+const supported = true
+
+// authenticate runs the authentication and the account management of the pam service.
+// A wrong password, an unknown user and a locked or expired account return false, the other pam errors an error.
+func authenticate(service, username, password string) (bool, error) {
+	cService := C.CString(service)
+	defer C.free(unsafe.Pointer(cService))
+	cUser := C.CString(username)
+	defer C.free(unsafe.Pointer(cUser))
+	cPassword := C.CString(password)
+	defer func() {
+		C.memset(unsafe.Pointer(cPassword), 0, C.size_t(len(password)))
+		C.free(unsafe.Pointer(cPassword))
+	}()
+
+	rc := C.loginsrv_pam_authenticate(cService, cUser, cPassword)
+	switch rc {
+	case C.PAM_SUCCESS:
+		return true, nil
+	case C.PAM_AUTH_ERR, C.PAM_USER_UNKNOWN, C.PAM_MAXTRIES, C.PAM_CRED_INSUFFICIENT,
+		C.PAM_ACCT_EXPIRED, C.PAM_NEW_AUTHTOK_REQD, C.PAM_PERM_DENIED:
+		return false, nil
+	}
+	return false, fmt.Errorf("pam service %v: %v", service, C.GoString(C.pam_strerror(nil, rc)))
+}
@@ -0,0 +1,14 @@
+//go:build !pam || !cgo
+// +build !pam !cgo
+
+package pam
+
+import "errors"
+
+// supported is false, because the build has no binding of libpam
+const supported = false
+
+// authenticate is never called, because BackendFactory fails
+func authenticate(service, username, password string) (bool, error) {
+	return false, errors.New("pam is not supported by this build")
+}
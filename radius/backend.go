@@ -0,0 +1,215 @@
+package radius
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+// ProviderName const
+const ProviderName = "radius"
+
+// The authentication methods of the radius backend
+const (
+	// MethodPAP sends the password, hidden with the shared secret
+	MethodPAP = "pap"
+	// MethodCHAP sends a hash of the password and a random challenge
+	MethodCHAP = "chap"
+)
+
+const (
+	defaultPort    = "1812"
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 2
+)
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "RADIUS login backend opts: server=host[:port],secret=..[,method=pap|chap][,timeout=..][,retries=..][,nas_identifier=..]",
+			Options: []login.OptionDescription{
+				{Name: "server", Required: true},
+				{Name: "secret", Required: true},
+				{Name: "method"},
+				{Name: "timeout", Type: login.OptionDuration},
+				{Name: "retries", Type: login.OptionInt},
+				{Name: "nas_identifier"},
+			},
+		},
+		BackendFactory)
+}
+
+// Config of the radius backend
+type Config struct {
+	// Server is the address of the radius server, host:port
+	Server string
+	// Secret is the shared secret of loginsrv and the server
+	Secret string
+	// Method is MethodPAP or MethodCHAP
+	Method string
+	// Timeout of each attempt
+	Timeout time.Duration
+	// Retries are the attempts after a timeout, the request is sent again over udp
+	Retries int
+	// NASIdentifier identifies loginsrv at the server (optional)
+	NASIdentifier string
+}
+
+// BackendFactory creates a radius backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg := Config{
+		Server:        opts["server"],
+		Secret:        opts["secret"],
+		Method:        MethodPAP,
+		Timeout:       defaultTimeout,
+		Retries:       defaultRetries,
+		NASIdentifier: opts["nas_identifier"],
+	}
+
+	if cfg.Server == "" {
+		return nil, errors.New(`missing parameter "server" for radius provider`)
+	}
+	if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+		cfg.Server = net.JoinHostPort(cfg.Server, defaultPort)
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New(`missing parameter "secret" for radius provider`)
+	}
+
+	if v, exist := opts["method"]; exist {
+		if v != MethodPAP && v != MethodCHAP {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "method" for radius provider, expected pap or chap`, v)
+		}
+		cfg.Method = v
+	}
+
+	var err error
+	if v, exist := opts["timeout"]; exist {
+		if cfg.Timeout, err = time.ParseDuration(v); err != nil || cfg.Timeout <= 0 {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "timeout" for radius provider`, v)
+		}
+	}
+	if v, exist := opts["retries"]; exist {
+		if cfg.Retries, err = strconv.Atoi(v); err != nil || cfg.Retries < 0 {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "retries" for radius provider`, v)
+		}
+	}
+
+	return NewBackend(cfg), nil
+}
+
+// Backend is the radius authentication backend.
+type Backend struct {
+	config Config
+}
+
+// NewBackend creates a new radius Backend.
+func NewBackend(cfg Config) *Backend {
+	return &Backend{config: cfg}
+}
+
+// Authenticate the user by an Access-Request.
+// The Filter-Id attributes of the Access-Accept are taken as groups of the user.
+// An Access-Challenge, e.g. for a second factor, is treated as rejection.
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if username == "" || password == "" || len(password) > maxPasswordLength {
+		return false, model.UserInfo{}, nil
+	}
+
+	request, err := b.accessRequest(username, password)
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	response, err := b.exchange(request)
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	if response.code != codeAccessAccept {
+		return false, model.UserInfo{}, nil
+	}
+	userInfo := model.UserInfo{
+		Sub:    username,
+		Origin: ProviderName,
+	}
+	if groups := response.values(attrFilterID); len(groups) > 0 {
+		userInfo.Groups = groups
+	}
+	return true, userInfo, nil
+}
+
+// accessRequest creates the Access-Request with the credentials and a Message-Authenticator,
+// which most servers require against forged responses (BlastRADIUS).
+func (b *Backend) accessRequest(username, password string) (*packet, error) {
+	random := make([]byte, 17)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	p := &packet{code: codeAccessRequest, identifier: random[16]}
+	copy(p.authenticator[:], random[:16])
+
+	p.add(attrUserName, []byte(username))
+	secret := []byte(b.config.Secret)
+	if b.config.Method == MethodCHAP {
+		// the request authenticator is random and can be the challenge
+		p.add(attrCHAPPassword, chapPassword(p.identifier, []byte(password), p.authenticator[:]))
+		p.add(attrCHAPChallenge, p.authenticator[:])
+	} else {
+		p.add(attrUserPassword, encryptPassword([]byte(password), secret, p.authenticator))
+	}
+	if b.config.NASIdentifier != "" {
+		p.add(attrNASIdentifier, []byte(b.config.NASIdentifier))
+	}
+	p.add(attrMessageAuthenticator, make([]byte, 16))
+	return p, nil
+}
+
+// exchange sends the request to the server and waits for the response, which matches the request.
+// The request is sent again after a timeout for the configured retries.
+func (b *Backend) exchange(request *packet) (*packet, error) {
+	secret := []byte(b.config.Secret)
+	data, err := request.encode()
+	if err != nil {
+		return nil, err
+	}
+	if err := signMessage(data, secret); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("udp", b.config.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, maxPacketLength)
+	for attempt := 0; attempt <= b.config.Retries; attempt++ {
+		if _, err := conn.Write(data); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(b.config.Timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			response, err := decode(buf[:n])
+			// ignore responses to other requests and forged responses
+			if err != nil || response.identifier != request.identifier || !verifyResponse(buf[:n], secret, request.authenticator) {
+				continue
+			}
+			return response, nil
+		}
+	}
+	return nil, fmt.Errorf("no response of radius server %v", b.config.Server)
+}
@@ -0,0 +1,271 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/md5"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+const testSecret = "shared-secret"
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{
+		"server":         "radius.example.com:1645",
+		"secret":         "secret",
+		"method":         "chap",
+		"timeout":        "2s",
+		"retries":        "0",
+		"nas_identifier": "loginsrv",
+	})
+	NoError(t, err)
+	Equal(t, Config{
+		Server:        "radius.example.com:1645",
+		Secret:        "secret",
+		Method:        MethodCHAP,
+		Timeout:       2 * time.Second,
+		Retries:       0,
+		NASIdentifier: "loginsrv",
+	}, backend.(*Backend).config)
+}
+
+func TestSetup_Default(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+	backend, err := p(map[string]string{"server": "radius.example.com", "secret": "secret"})
+	NoError(t, err)
+	Equal(t, Config{
+		Server:  "radius.example.com:1812",
+		Secret:  "secret",
+		Method:  MethodPAP,
+		Timeout: defaultTimeout,
+		Retries: defaultRetries,
+	}, backend.(*Backend).config)
+}
+
+func TestSetup_Error(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	for _, opts := range []map[string]string{
+		{"secret": "secret"},
+		{"server": "radius.example.com"},
+		{"server": "radius.example.com", "secret": "secret", "method": "mschap"},
+		{"server": "radius.example.com", "secret": "secret", "timeout": "foo"},
+		{"server": "radius.example.com", "secret": "secret", "retries": "-1"},
+	} {
+		_, err := p(opts)
+		Error(t, err, opts)
+	}
+}
+
+func TestBackend_Authenticate_PAP(t *testing.T) {
+	server := newTestServer(t, false)
+	defer server.Close()
+	b := NewBackend(Config{Server: server.addr(), Secret: testSecret, Method: MethodPAP, Timeout: time.Second})
+
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Sub: "bob", Origin: ProviderName, Groups: []string{"admin", "dev"}}, userInfo)
+
+	// a password longer than one block
+	authenticated, _, err = b.Authenticate("alice", "a-password-with-more-than-16-bytes")
+	NoError(t, err)
+	True(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_CHAP(t *testing.T) {
+	server := newTestServer(t, false)
+	defer server.Close()
+	b := NewBackend(Config{Server: server.addr(), Secret: testSecret, Method: MethodCHAP, Timeout: time.Second})
+
+	authenticated, _, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	authenticated, _, err = b.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_Challenge(t *testing.T) {
+	server := newTestServer(t, false)
+	defer server.Close()
+	b := NewBackend(Config{Server: server.addr(), Secret: testSecret, Timeout: time.Second})
+
+	authenticated, _, err := b.Authenticate("otp-user", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_WrongSecret(t *testing.T) {
+	server := newTestServer(t, false)
+	defer server.Close()
+	b := NewBackend(Config{Server: server.addr(), Secret: "other-secret", Timeout: 100 * time.Millisecond, Retries: 1})
+
+	// the server drops requests with a wrong message authenticator
+	_, _, err := b.Authenticate("bob", "secret")
+	Error(t, err)
+	Equal(t, int32(2), atomic.LoadInt32(&server.requests))
+}
+
+func TestBackend_Authenticate_ForgedResponse(t *testing.T) {
+	server := newTestServer(t, true)
+	defer server.Close()
+	b := NewBackend(Config{Server: server.addr(), Secret: testSecret, Timeout: 100 * time.Millisecond})
+
+	_, _, err := b.Authenticate("bob", "secret")
+	Error(t, err)
+}
+
+func TestBackend_Authenticate_Unreachable(t *testing.T) {
+	b := NewBackend(Config{Server: "localhost:0", Secret: testSecret, Timeout: 100 * time.Millisecond})
+	_, _, err := b.Authenticate("bob", "secret")
+	Error(t, err)
+}
+
+func TestPacket_Decode_Invalid(t *testing.T) {
+	_, err := decode([]byte{1, 2, 3})
+	Error(t, err)
+
+	p := &packet{code: codeAccessRequest, identifier: 1}
+	p.add(attrUserName, []byte("bob"))
+	b, err := p.encode()
+	NoError(t, err)
+
+	_, err = decode(b[:len(b)-1])
+	Error(t, err)
+
+	b[21] = 30
+	_, err = decode(b)
+	Error(t, err)
+}
+
+// testServer is a radius server with the users bob, alice and otp-user.
+type testServer struct {
+	t        *testing.T
+	conn     net.PacketConn
+	requests int32
+	// forge signs the responses with another secret
+	forge bool
+}
+
+var testUsers = map[string]string{
+	"bob":      "secret",
+	"alice":    "a-password-with-more-than-16-bytes",
+	"otp-user": "secret",
+}
+
+func newTestServer(t *testing.T, forge bool) *testServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	s := &testServer{t: t, conn: conn, forge: forge}
+	go s.serve()
+	return s
+}
+
+func (s *testServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *testServer) Close() {
+	s.conn.Close()
+}
+
+func (s *testServer) serve() {
+	buf := make([]byte, maxPacketLength)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.requests, 1)
+		if response := s.respond(buf[:n]); response != nil {
+			s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+func (s *testServer) respond(b []byte) []byte {
+	request, err := decode(b)
+	if err != nil || request.code != codeAccessRequest {
+		return nil
+	}
+	secret := []byte(testSecret)
+
+	// drop requests without a valid message authenticator
+	signed := make([]byte, len(b))
+	copy(signed, b)
+	offset, ok := messageAuthenticatorOffset(signed)
+	if !ok {
+		return nil
+	}
+	copy(signed[offset:offset+16], make([]byte, 16))
+	if signMessage(signed, secret) != nil || !bytes.Equal(signed, b) {
+		return nil
+	}
+
+	username, _ := request.get(attrUserName)
+	password, exist := testUsers[string(username)]
+	code := byte(codeAccessReject)
+	if exist && s.checkPassword(request, password) {
+		code = codeAccessAccept
+		if string(username) == "otp-user" {
+			code = codeAccessChallenge
+		}
+	}
+
+	response := &packet{code: code, identifier: request.identifier, authenticator: request.authenticator}
+	if code == codeAccessAccept && string(username) == "bob" {
+		response.add(attrFilterID, []byte("admin"))
+		response.add(attrFilterID, []byte("dev"))
+	}
+	response.add(attrMessageAuthenticator, make([]byte, 16))
+	if s.forge {
+		secret = []byte("forged")
+	}
+	data, err := response.encode()
+	NoError(s.t, err)
+	NoError(s.t, signMessage(data, secret))
+	copy(data[4:20], responseAuthenticator(data, secret, request.authenticator))
+	return data
+}
+
+func (s *testServer) checkPassword(request *packet, password string) bool {
+	if chap, ok := request.get(attrCHAPPassword); ok {
+		challenge, ok := request.get(attrCHAPChallenge)
+		return ok && len(chap) == 17 && bytes.Equal(chap, chapPassword(chap[0], []byte(password), challenge))
+	}
+	hidden, ok := request.get(attrUserPassword)
+	if !ok || len(hidden)%16 != 0 {
+		return false
+	}
+	// the decryption xors the same md5 chain, which is computed over the hidden blocks
+	plain := make([]byte, len(hidden))
+	last := request.authenticator[:]
+	for i := 0; i < len(hidden); i += 16 {
+		sum := md5.Sum(append([]byte(testSecret), last...))
+		for j := 0; j < 16; j++ {
+			plain[i+j] = hidden[i+j] ^ sum[j]
+		}
+		last = hidden[i : i+16]
+	}
+	return string(bytes.TrimRight(plain, "\x00")) == password
+}
@@ -0,0 +1,198 @@
+package radius
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The codes of the radius packets (RFC 2865)
+const (
+	codeAccessRequest   = 1
+	codeAccessAccept    = 2
+	codeAccessReject    = 3
+	codeAccessChallenge = 11
+)
+
+// The types of the used attributes (RFC 2865, RFC 3579)
+const (
+	attrUserName             = 1
+	attrUserPassword         = 2
+	attrCHAPPassword         = 3
+	attrFilterID             = 11
+	attrNASIdentifier        = 32
+	attrCHAPChallenge        = 60
+	attrMessageAuthenticator = 80
+)
+
+const (
+	headerLength    = 20
+	maxPacketLength = 4096
+	// maxPasswordLength is the limit of the User-Password attribute
+	maxPasswordLength = 128
+)
+
+type attribute struct {
+	typ   byte
+	value []byte
+}
+
+// packet is a radius packet with its attributes in the order of the wire format.
+type packet struct {
+	code          byte
+	identifier    byte
+	authenticator [16]byte
+	attributes    []attribute
+}
+
+func (p *packet) add(typ byte, value []byte) {
+	p.attributes = append(p.attributes, attribute{typ: typ, value: value})
+}
+
+// get returns the value of the first attribute of the type
+func (p *packet) get(typ byte) ([]byte, bool) {
+	for _, a := range p.attributes {
+		if a.typ == typ {
+			return a.value, true
+		}
+	}
+	return nil, false
+}
+
+// values returns the values of all attributes of the type
+func (p *packet) values(typ byte) []string {
+	values := []string{}
+	for _, a := range p.attributes {
+		if a.typ == typ {
+			values = append(values, string(a.value))
+		}
+	}
+	return values
+}
+
+// encode returns the wire format of the packet.
+func (p *packet) encode() ([]byte, error) {
+	b := make([]byte, headerLength, maxPacketLength)
+	b[0] = p.code
+	b[1] = p.identifier
+	copy(b[4:20], p.authenticator[:])
+	for _, a := range p.attributes {
+		if len(a.value) > 253 {
+			return nil, fmt.Errorf("radius attribute %v is too long", a.typ)
+		}
+		b = append(b, a.typ, byte(len(a.value)+2))
+		b = append(b, a.value...)
+	}
+	if len(b) > maxPacketLength {
+		return nil, errors.New("radius packet is too long")
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b, nil
+}
+
+// decode parses the wire format of a packet.
+func decode(b []byte) (*packet, error) {
+	if len(b) < headerLength {
+		return nil, errors.New("radius packet is too short")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < headerLength || length > len(b) || length > maxPacketLength {
+		return nil, errors.New("invalid length of radius packet")
+	}
+	p := &packet{code: b[0], identifier: b[1]}
+	copy(p.authenticator[:], b[4:20])
+	for rest := b[headerLength:length]; len(rest) > 0; {
+		if len(rest) < 2 || int(rest[1]) < 2 || int(rest[1]) > len(rest) {
+			return nil, errors.New("invalid attribute in radius packet")
+		}
+		p.add(rest[0], rest[2:rest[1]])
+		rest = rest[rest[1]:]
+	}
+	return p, nil
+}
+
+// encryptPassword hides the password in the User-Password attribute (RFC 2865, 5.2).
+func encryptPassword(password []byte, secret []byte, authenticator [16]byte) []byte {
+	length := (len(password) + 15) / 16 * 16
+	if length == 0 {
+		length = 16
+	}
+	result := make([]byte, length)
+	copy(result, password)
+	last := authenticator[:]
+	for i := 0; i < length; i += 16 {
+		hash := md5.New()
+		hash.Write(secret)
+		hash.Write(last)
+		b := hash.Sum(nil)
+		for j := range b {
+			result[i+j] ^= b[j]
+		}
+		last = result[i : i+16]
+	}
+	return result
+}
+
+// chapPassword returns the CHAP-Password attribute of the password: the chap id and md5(id, password, challenge).
+func chapPassword(id byte, password []byte, challenge []byte) []byte {
+	hash := md5.New()
+	hash.Write([]byte{id})
+	hash.Write(password)
+	hash.Write(challenge)
+	return append([]byte{id}, hash.Sum(nil)...)
+}
+
+// signMessage sets the Message-Authenticator of the encoded packet, which has to contain the attribute with zeros.
+// The authenticator of the encoded packet is the one of the request.
+func signMessage(b []byte, secret []byte) error {
+	offset, ok := messageAuthenticatorOffset(b)
+	if !ok {
+		return errors.New("missing Message-Authenticator in radius packet")
+	}
+	mac := hmac.New(md5.New, secret)
+	mac.Write(b)
+	copy(b[offset:offset+16], mac.Sum(nil))
+	return nil
+}
+
+// messageAuthenticatorOffset returns the offset of the value of the Message-Authenticator attribute
+func messageAuthenticatorOffset(b []byte) (int, bool) {
+	for i := headerLength; i+2 <= len(b) && b[i+1] >= 2; i += int(b[i+1]) {
+		if b[i] == attrMessageAuthenticator && b[i+1] == 18 && i+18 <= len(b) {
+			return i + 2, true
+		}
+	}
+	return 0, false
+}
+
+// responseAuthenticator returns the Response Authenticator of an encoded response to the request authenticator.
+func responseAuthenticator(b []byte, secret []byte, requestAuthenticator [16]byte) []byte {
+	hash := md5.New()
+	hash.Write(b[:4])
+	hash.Write(requestAuthenticator[:])
+	hash.Write(b[headerLength:])
+	hash.Write(secret)
+	return hash.Sum(nil)
+}
+
+// verifyResponse checks the Response Authenticator and, if present, the Message-Authenticator of an encoded response.
+func verifyResponse(b []byte, secret []byte, requestAuthenticator [16]byte) bool {
+	b = b[:binary.BigEndian.Uint16(b[2:4])]
+	if !hmac.Equal(responseAuthenticator(b, secret, requestAuthenticator), b[4:20]) {
+		return false
+	}
+
+	offset, ok := messageAuthenticatorOffset(b)
+	if !ok {
+		return true
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	copy(c[4:20], requestAuthenticator[:])
+	copy(c[offset:offset+16], make([]byte, 16))
+	mac := hmac.New(md5.New, secret)
+	mac.Write(c)
+	return hmac.Equal(mac.Sum(nil), b[offset:offset+16])
+}
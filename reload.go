@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/login"
+)
+
+// reloadableHandler serves the requests with the latest login handler
+type reloadableHandler struct {
+	handler atomic.Value
+	// gracePeriod is the maximum time to wait for the requests of a replaced handler, before its backends are closed
+	gracePeriod time.Duration
+}
+
+// servingHandler counts the requests in flight of a login handler, so that it is closed after its last request
+type servingHandler struct {
+	*login.Handler
+	mu       sync.Mutex
+	requests int
+	replaced bool
+	idle     chan struct{}
+}
+
+func newReloadableHandler(h *login.Handler, gracePeriod time.Duration) *reloadableHandler {
+	rh := &reloadableHandler{gracePeriod: gracePeriod}
+	rh.handler.Store(newServingHandler(h))
+	return rh
+}
+
+func newServingHandler(h *login.Handler) *servingHandler {
+	return &servingHandler{Handler: h, idle: make(chan struct{})}
+}
+
+func (rh *reloadableHandler) current() *login.Handler {
+	return rh.handler.Load().(*servingHandler).Handler
+}
+
+func (rh *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.serve(func(h *login.Handler) {
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serve calls the function with the latest handler, which is not closed before the function returns
+func (rh *reloadableHandler) serve(f func(h *login.Handler)) {
+	s := rh.acquire()
+	defer s.release()
+	f(s.Handler)
+}
+
+// acquire returns the latest handler and counts the request.
+// A handler, which was replaced after it was loaded, is skipped, because it may already be closed.
+func (rh *reloadableHandler) acquire() *servingHandler {
+	for {
+		s := rh.handler.Load().(*servingHandler)
+		s.mu.Lock()
+		if !s.replaced {
+			s.requests++
+			s.mu.Unlock()
+			return s
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *servingHandler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests--
+	if s.replaced && s.requests == 0 {
+		close(s.idle)
+	}
+}
+
+// replace marks the handler as replaced and returns a channel, which is closed after the last request of the handler
+func (s *servingHandler) replace() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaced = true
+	if s.requests == 0 {
+		close(s.idle)
+	}
+	return s.idle
+}
+
+// reload reads the config again and replaces the handler. On errors, the current handler is kept.
+// The backends of the replaced handler are closed after its requests are finished or the grace period has passed.
+func (rh *reloadableHandler) reload(args []string) error {
+	config, err := login.ParseConfig(args)
+	if err != nil {
+		return err
+	}
+	current := rh.handler.Load().(*servingHandler)
+	h, err := current.Reload(config)
+	if err != nil {
+		return err
+	}
+	rh.handler.Store(newServingHandler(h))
+
+	select {
+	case <-current.replace():
+	case <-time.After(rh.gracePeriod):
+		logging.Logger.Warn("grace period exceeded, closing the backends of the replaced configuration")
+	}
+	return current.CloseReplaced(h)
+}
+
+// reloadOnSignal reloads the handler for every signal of the channel
+func reloadOnSignal(rh *reloadableHandler, signals <-chan os.Signal, args []string) {
+	for range signals {
+		if err := rh.reload(args); err != nil {
+			logging.Logger.WithError(err).Error("can't reload the configuration, keeping the current configuration")
+			continue
+		}
+		logging.Logger.Info("reloaded the configuration")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_ReloadOnSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv-reload")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "loginsrv.yaml")
+	NoError(t, ioutil.WriteFile(configFile, []byte("simple: {bob: secret}"), 0600))
+
+	args := []string{"-config", configFile}
+	config, err := login.ParseConfig(args)
+	NoError(t, err)
+	h, err := login.NewHandler(config)
+	NoError(t, err)
+	rh := newReloadableHandler(h, time.Second)
+
+	signals := make(chan os.Signal)
+	go reloadOnSignal(rh, signals, args)
+	defer close(signals)
+
+	status := func(username string) int {
+		r := httptest.NewRequest("POST", "/login", strings.NewReader("username="+username+"&password=secret"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Accept", "application/jwt")
+		w := httptest.NewRecorder()
+		rh.ServeHTTP(w, r)
+		return w.Code
+	}
+	Equal(t, http.StatusOK, status("bob"))
+	Equal(t, http.StatusForbidden, status("alice"))
+
+	NoError(t, ioutil.WriteFile(configFile, []byte("simple: {alice: secret}"), 0600))
+	signals <- syscall.SIGHUP
+	// the second signal is received after the first reload is done
+	signals <- syscall.SIGHUP
+	Equal(t, http.StatusForbidden, status("bob"))
+	Equal(t, http.StatusOK, status("alice"))
+
+	// an invalid config keeps the current handler
+	NoError(t, ioutil.WriteFile(configFile, []byte("no-such-option: 1"), 0600))
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGHUP
+	Equal(t, http.StatusOK, status("alice"))
+}
+
+func Test_ReloadWaitsForRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv-reload")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "loginsrv.yaml")
+	NoError(t, ioutil.WriteFile(configFile, []byte("simple: {bob: secret}"), 0600))
+
+	args := []string{"-config", configFile}
+	config, err := login.ParseConfig(args)
+	NoError(t, err)
+	h, err := login.NewHandler(config)
+	NoError(t, err)
+	rh := newReloadableHandler(h, time.Minute)
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	var served *login.Handler
+	go rh.serve(func(h *login.Handler) {
+		served = h
+		close(started)
+		<-finish
+	})
+	<-started
+
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- rh.reload(args)
+	}()
+
+	// the new requests are served by the reloaded handler, while the replaced handler is not closed
+	for rh.current() == served {
+		time.Sleep(time.Millisecond)
+	}
+	rh.serve(func(h *login.Handler) {
+		NotEqual(t, served, h)
+	})
+	select {
+	case <-reloaded:
+		t.Fatal("the replaced handler was closed before its request was finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(finish)
+	NoError(t, <-reloaded)
+
+	// the replaced handler is closed after the grace period, even if a request is not finished
+	rh.gracePeriod = 10 * time.Millisecond
+	finish = make(chan struct{})
+	defer close(finish)
+	started = make(chan struct{})
+	go rh.serve(func(h *login.Handler) {
+		close(started)
+		<-finish
+	})
+	<-started
+	NoError(t, rh.reload(args))
+}
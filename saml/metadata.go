@@ -0,0 +1,125 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	dsigNS          = "http://www.w3.org/2000/09/xmldsig#"
+	protocolNS      = "urn:oasis:names:tc:SAML:2.0:protocol"
+	assertionNS     = "urn:oasis:names:tc:SAML:2.0:assertion"
+	redirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	postBinding     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+)
+
+// entityDescriptor is used for parsing the metadata of the identity provider
+type entityDescriptor struct {
+	XMLName          xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID         string            `xml:"entityID,attr"`
+	IDPSSODescriptor *idpSSODescriptor `xml:"IDPSSODescriptor"`
+}
+
+type idpSSODescriptor struct {
+	KeyDescriptors      []keyDescriptor `xml:"KeyDescriptor"`
+	SingleSignOnService []endpoint      `xml:"SingleSignOnService"`
+}
+
+type keyDescriptor struct {
+	Use              string   `xml:"use,attr"`
+	X509Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+}
+
+type endpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+// readIDPMetadata reads the entity id, the sso url and the signing certificates from the metadata file of the identity provider.
+func readIDPMetadata(file string) (entityID string, ssoURL string, certs []*x509.Certificate, err error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("can't read saml idp metadata: %v", err)
+	}
+
+	ed := entityDescriptor{}
+	if err := xml.Unmarshal(b, &ed); err != nil {
+		return "", "", nil, fmt.Errorf("error parsing saml idp metadata %v: %v", file, err)
+	}
+	if ed.IDPSSODescriptor == nil {
+		return "", "", nil, fmt.Errorf("no IDPSSODescriptor in saml idp metadata %v", file)
+	}
+
+	for _, sso := range ed.IDPSSODescriptor.SingleSignOnService {
+		if sso.Binding == redirectBinding {
+			ssoURL = sso.Location
+		}
+	}
+	if ssoURL == "" {
+		return "", "", nil, fmt.Errorf("no SingleSignOnService with HTTP-Redirect binding in saml idp metadata %v", file)
+	}
+
+	for _, kd := range ed.IDPSSODescriptor.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+		for _, data := range kd.X509Certificates {
+			cert, err := parseCertificate(data)
+			if err != nil {
+				return "", "", nil, fmt.Errorf("error parsing certificate in saml idp metadata %v: %v", file, err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		return "", "", nil, fmt.Errorf("no signing certificate in saml idp metadata %v", file)
+	}
+
+	return ed.EntityID, ssoURL, certs, nil
+}
+
+// parseCertificate parses the base64 encoded certificate of a metadata document
+func parseCertificate(data string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// spEntityDescriptor is the metadata of loginsrv as service provider
+type spEntityDescriptor struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat               string `xml:"NameIDFormat,omitempty"`
+		AssertionConsumerService   struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+			Index    int    `xml:"index,attr"`
+		}
+	}
+}
+
+// Metadata returns the metadata of the service provider for the configuration of the identity provider.
+func (sp *ServiceProvider) Metadata(acsURL string) ([]byte, error) {
+	ed := spEntityDescriptor{EntityID: sp.config.EntityID}
+	ed.SPSSODescriptor.WantAssertionsSigned = true
+	ed.SPSSODescriptor.ProtocolSupportEnumeration = protocolNS
+	ed.SPSSODescriptor.NameIDFormat = sp.config.NameIDFormat
+	ed.SPSSODescriptor.AssertionConsumerService.Binding = postBinding
+	ed.SPSSODescriptor.AssertionConsumerService.Location = sp.acsURL(acsURL)
+
+	b, err := xml.MarshalIndent(ed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
@@ -0,0 +1,480 @@
+// Package saml implements the SP-initiated login with a SAML 2.0 identity provider.
+// The AuthnRequest is sent with the HTTP-Redirect binding and the response is expected with the HTTP-POST binding.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+// ProviderName is the origin of the users, logged in by saml
+const ProviderName = "saml"
+
+const (
+	statusSuccess    = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	bearerMethod     = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	timeFormat       = "2006-01-02T15:04:05Z"
+	requestExpiry    = 10 * time.Minute
+	allowedClockSkew = 3 * time.Minute
+)
+
+// Config of the service provider
+type Config struct {
+	// EntityID of loginsrv as service provider
+	EntityID string
+	// ACSURL is the url of the assertion consumer service.
+	// If it is empty, the url is taken from the request.
+	ACSURL       string
+	NameIDFormat string
+
+	IDPEntityID     string
+	IDPSSOURL       string
+	IDPCertificates []*x509.Certificate
+
+	NameAttribute   string
+	EmailAttribute  string
+	GroupsAttribute string
+}
+
+// NewConfigFromOpts creates the configuration from the options of the saml flag.
+// The identity provider is configured by its metadata file or by the options idp_sso_url and idp_certificate.
+func NewConfigFromOpts(opts map[string]string) (Config, error) {
+	cfg := Config{
+		EntityID:        opts["entity_id"],
+		ACSURL:          opts["acs_url"],
+		NameIDFormat:    opts["name_id_format"],
+		IDPEntityID:     opts["idp_entity_id"],
+		IDPSSOURL:       opts["idp_sso_url"],
+		NameAttribute:   "displayName",
+		EmailAttribute:  "email",
+		GroupsAttribute: "groups",
+	}
+
+	if cfg.EntityID == "" {
+		return Config{}, errors.New(`missing parameter "entity_id" for saml`)
+	}
+
+	if file, exist := opts["idp_metadata"]; exist {
+		entityID, ssoURL, certs, err := readIDPMetadata(file)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.IDPEntityID, cfg.IDPSSOURL, cfg.IDPCertificates = entityID, ssoURL, certs
+	} else {
+		if cfg.IDPSSOURL == "" {
+			return Config{}, errors.New(`missing parameter "idp_metadata" or "idp_sso_url" for saml`)
+		}
+		file, exist := opts["idp_certificate"]
+		if !exist {
+			return Config{}, errors.New(`missing parameter "idp_certificate" for saml`)
+		}
+		certs, err := readCertificates(file)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.IDPCertificates = certs
+	}
+
+	for key, target := range map[string]*string{
+		"name_attribute":   &cfg.NameAttribute,
+		"email_attribute":  &cfg.EmailAttribute,
+		"groups_attribute": &cfg.GroupsAttribute,
+	} {
+		if v, exist := opts[key]; exist {
+			*target = v
+		}
+	}
+	return cfg, nil
+}
+
+// ServiceProvider does the login with the identity provider.
+type ServiceProvider struct {
+	config Config
+
+	mutex sync.Mutex
+	// requests holds the ids of the pending AuthnRequests with their expiry,
+	// so that only responses to own requests are accepted and each only once.
+	requests map[string]time.Time
+}
+
+// NewServiceProvider creates a service provider for the configuration.
+func NewServiceProvider(config Config) *ServiceProvider {
+	return &ServiceProvider{
+		config:   config,
+		requests: map[string]time.Time{},
+	}
+}
+
+type authnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      struct {
+		XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Value   string   `xml:",chardata"`
+	}
+	NameIDPolicy struct {
+		Format      string `xml:"Format,attr,omitempty"`
+		AllowCreate bool   `xml:"AllowCreate,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+// AuthnRequestURL returns the url of the identity provider with a new AuthnRequest.
+// The acsURL from the request is used, if no url is configured.
+func (sp *ServiceProvider) AuthnRequestURL(acsURL string) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	req := authnRequest{
+		ID:                          id,
+		Version:                     "2.0",
+		IssueInstant:                time.Now().UTC().Format(timeFormat),
+		Destination:                 sp.config.IDPSSOURL,
+		AssertionConsumerServiceURL: sp.acsURL(acsURL),
+		ProtocolBinding:             postBinding,
+	}
+	req.Issuer.Value = sp.config.EntityID
+	req.NameIDPolicy.Format = sp.config.NameIDFormat
+	req.NameIDPolicy.AllowCreate = true
+
+	b, err := xml.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	// the redirect binding uses a raw deflate stream
+	deflated := &bytes.Buffer{}
+	w, _ := flate.NewWriter(deflated, flate.BestCompression)
+	w.Write(b)
+	w.Close()
+
+	u, err := url.Parse(sp.config.IDPSSOURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	u.RawQuery = q.Encode()
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	now := time.Now()
+	for id, expiry := range sp.requests {
+		if now.After(expiry) {
+			delete(sp.requests, id)
+		}
+	}
+	sp.requests[req.ID] = now.Add(requestExpiry)
+
+	return u.String(), nil
+}
+
+// ParseResponse verifies the base64 encoded SAMLResponse, which was posted to the acsURL, and returns the user of the assertion.
+// Errors in the verification are returned as *VerificationError.
+func (sp *ServiceProvider) ParseResponse(samlResponse string, acsURL string) (model.UserInfo, error) {
+	acsURL = sp.acsURL(acsURL)
+
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(samlResponse), ""))
+	if err != nil {
+		return model.UserInfo{}, verificationErrorf("invalid base64 encoding of the response")
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return model.UserInfo{}, verificationErrorf("invalid xml in the response: %v", err)
+	}
+	response := doc.Root()
+	if response == nil || response.Tag != "Response" || response.NamespaceURI() != protocolNS {
+		return model.UserInfo{}, verificationErrorf("the document is no saml response")
+	}
+
+	if destination := response.SelectAttrValue("Destination", ""); destination != "" && destination != acsURL {
+		return model.UserInfo{}, verificationErrorf("wrong destination %v, expected %v", destination, acsURL)
+	}
+	if status := statusCode(response); status != statusSuccess {
+		return model.UserInfo{}, verificationErrorf("the identity provider responded with status %v", status)
+	}
+
+	assertionEl, err := sp.verifiedAssertion(response)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+
+	a := assertion{}
+	if err := unmarshalElement(assertionEl, &a); err != nil {
+		return model.UserInfo{}, verificationErrorf("invalid assertion: %v", err)
+	}
+
+	requestID, err := sp.checkAssertion(a, acsURL, time.Now())
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	if inResponseTo := response.SelectAttrValue("InResponseTo", ""); inResponseTo != "" && inResponseTo != requestID {
+		return model.UserInfo{}, verificationErrorf("the response and the assertion belong to different requests")
+	}
+	if !sp.takeRequest(requestID) {
+		return model.UserInfo{}, verificationErrorf("unknown or already used request id %v", requestID)
+	}
+
+	return model.UserInfo{
+		Sub:      a.Subject.NameID,
+		Name:     a.attribute(sp.config.NameAttribute),
+		Email:    a.attribute(sp.config.EmailAttribute),
+		Groups:   a.attributeValues(sp.config.GroupsAttribute),
+		Origin:   ProviderName,
+		Verified: true,
+	}, nil
+}
+
+// verifiedAssertion returns the assertion, which is signed itself or as part of the signed response.
+// Only the content covered by the signature is returned, so that nothing can be injected into the document.
+func (sp *ServiceProvider) verifiedAssertion(response *etree.Element) (*etree.Element, error) {
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: sp.config.IDPCertificates})
+	ctx.IdAttribute = "ID"
+
+	signedResponse := false
+	if hasSignature(response) {
+		verified, err := ctx.Validate(response)
+		if err != nil {
+			return nil, verificationErrorf("invalid signature of the response: %v", err)
+		}
+		response = verified
+		signedResponse = true
+	}
+
+	if response.FindElement("./EncryptedAssertion") != nil {
+		return nil, verificationErrorf("encrypted assertions are not supported")
+	}
+	assertions := childElements(response, assertionNS, "Assertion")
+	if len(assertions) != 1 {
+		return nil, verificationErrorf("expected exactly one assertion, but got %v", len(assertions))
+	}
+	assertionEl := assertions[0]
+
+	if hasSignature(assertionEl) {
+		// the assertion may use namespaces, which are declared in the response
+		nsCtx, err := etreeutils.NSBuildParentContext(assertionEl)
+		if err != nil {
+			return nil, verificationErrorf("invalid namespaces in the assertion: %v", err)
+		}
+		detached, err := etreeutils.NSDetatch(nsCtx, assertionEl)
+		if err != nil {
+			return nil, verificationErrorf("invalid namespaces in the assertion: %v", err)
+		}
+		verified, err := ctx.Validate(detached)
+		if err != nil {
+			return nil, verificationErrorf("invalid signature of the assertion: %v", err)
+		}
+		return verified, nil
+	}
+
+	if !signedResponse {
+		return nil, verificationErrorf("neither the response nor the assertion is signed")
+	}
+	return assertionEl, nil
+}
+
+// checkAssertion checks the issuer, the conditions and the subject confirmation of the assertion.
+// It returns the id of the request, the assertion is the response to.
+func (sp *ServiceProvider) checkAssertion(a assertion, acsURL string, now time.Time) (string, error) {
+	if sp.config.IDPEntityID != "" && a.Issuer != sp.config.IDPEntityID {
+		return "", verificationErrorf("wrong issuer %v, expected %v", a.Issuer, sp.config.IDPEntityID)
+	}
+
+	if a.Conditions == nil {
+		return "", verificationErrorf("missing conditions in the assertion")
+	}
+	if !a.Conditions.NotBefore.IsZero() && now.Add(allowedClockSkew).Before(a.Conditions.NotBefore) {
+		return "", verificationErrorf("the assertion is not valid before %v", a.Conditions.NotBefore)
+	}
+	if !a.Conditions.NotOnOrAfter.IsZero() && !now.Add(-allowedClockSkew).Before(a.Conditions.NotOnOrAfter) {
+		return "", verificationErrorf("the assertion expired at %v", a.Conditions.NotOnOrAfter)
+	}
+	if !contains(a.Conditions.Audiences, sp.config.EntityID) {
+		return "", verificationErrorf("the assertion is not issued for the audience %v", sp.config.EntityID)
+	}
+
+	if a.Subject.NameID == "" {
+		return "", verificationErrorf("missing NameID in the assertion")
+	}
+	for _, sc := range a.Subject.SubjectConfirmations {
+		data := sc.Data
+		if sc.Method != bearerMethod || data.Recipient != acsURL || data.InResponseTo == "" {
+			continue
+		}
+		if data.NotOnOrAfter.IsZero() || !now.Add(-allowedClockSkew).Before(data.NotOnOrAfter) {
+			continue
+		}
+		return data.InResponseTo, nil
+	}
+	return "", verificationErrorf("no valid bearer subject confirmation for %v in the assertion", acsURL)
+}
+
+func (sp *ServiceProvider) takeRequest(id string) bool {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	expiry, exist := sp.requests[id]
+	delete(sp.requests, id)
+	return exist && time.Now().Before(expiry)
+}
+
+func (sp *ServiceProvider) acsURL(fromRequest string) string {
+	if sp.config.ACSURL != "" {
+		return sp.config.ACSURL
+	}
+	return fromRequest
+}
+
+// VerificationError is returned, if a response is not accepted.
+type VerificationError struct {
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return "saml response not accepted: " + e.Reason
+}
+
+func verificationErrorf(format string, a ...interface{}) error {
+	return &VerificationError{Reason: fmt.Sprintf(format, a...)}
+}
+
+type assertion struct {
+	Issuer  string `xml:"Issuer"`
+	Subject struct {
+		NameID               string `xml:"NameID"`
+		SubjectConfirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				Recipient    string    `xml:"Recipient,attr"`
+				InResponseTo string    `xml:"InResponseTo,attr"`
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions *struct {
+		NotBefore    time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+		Audiences    []string  `xml:"AudienceRestriction>Audience"`
+	} `xml:"Conditions"`
+	Attributes []struct {
+		Name         string   `xml:"Name,attr"`
+		FriendlyName string   `xml:"FriendlyName,attr"`
+		Values       []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+}
+
+// attributeValues returns the values of the attribute by its name or friendly name
+func (a assertion) attributeValues(name string) []string {
+	for _, attr := range a.Attributes {
+		if name != "" && (attr.Name == name || attr.FriendlyName == name) {
+			return attr.Values
+		}
+	}
+	return nil
+}
+
+func (a assertion) attribute(name string) string {
+	if values := a.attributeValues(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func statusCode(response *etree.Element) string {
+	for _, status := range childElements(response, protocolNS, "Status") {
+		for _, code := range childElements(status, protocolNS, "StatusCode") {
+			return code.SelectAttrValue("Value", "")
+		}
+	}
+	return ""
+}
+
+func hasSignature(el *etree.Element) bool {
+	return len(childElements(el, dsigNS, "Signature")) > 0
+}
+
+func childElements(el *etree.Element, namespace, tag string) []*etree.Element {
+	var children []*etree.Element
+	for _, child := range el.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == namespace {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func unmarshalElement(el *etree.Element, v interface{}) error {
+	doc := etree.NewDocument()
+	doc.SetRoot(el.Copy())
+	b, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, v)
+}
+
+func readCertificates(file string) ([]*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't read saml idp certificate: %v", err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing saml idp certificate %v: %v", file, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate in saml idp certificate file %v", file)
+	}
+	return certs, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// an xml id must not start with a digit
+	return "id-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,355 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	. "github.com/stretchr/testify/assert"
+)
+
+const testACSURL = "https://login.example.com/login/saml/acs"
+
+func TestServiceProvider_Login(t *testing.T) {
+	idp := newTestIDP(t)
+	sp := NewServiceProvider(idp.config())
+
+	requestID := idp.authnRequest(t, sp)
+	response := idp.response(t, responseData{InResponseTo: requestID}, false, true)
+
+	userInfo, err := sp.ParseResponse(response, testACSURL)
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:      "bob@example.com",
+		Name:     "Bob",
+		Email:    "bob@example.com",
+		Groups:   []string{"admins", "users"},
+		Origin:   "saml",
+		Verified: true,
+	}, userInfo)
+
+	// a response can only be used once
+	_, err = sp.ParseResponse(response, testACSURL)
+	IsType(t, &VerificationError{}, err)
+}
+
+func TestServiceProvider_LoginWithSignedResponse(t *testing.T) {
+	idp := newTestIDP(t)
+	sp := NewServiceProvider(idp.config())
+
+	requestID := idp.authnRequest(t, sp)
+	userInfo, err := sp.ParseResponse(idp.response(t, responseData{InResponseTo: requestID}, true, false), testACSURL)
+	NoError(t, err)
+	Equal(t, "bob@example.com", userInfo.Sub)
+}
+
+func TestServiceProvider_ConfiguredACSURL(t *testing.T) {
+	idp := newTestIDP(t)
+	cfg := idp.config()
+	cfg.ACSURL = testACSURL
+	sp := NewServiceProvider(cfg)
+
+	requestID := idp.authnRequest(t, sp)
+	_, err := sp.ParseResponse(idp.response(t, responseData{InResponseTo: requestID}, false, true), "http://internal:6789/login/saml/acs")
+	NoError(t, err)
+}
+
+func TestServiceProvider_RejectResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *responseData)
+		signR  bool
+		signA  bool
+	}{
+		{"unsigned", func(d *responseData) {}, false, false},
+		{"unknown request", func(d *responseData) { d.InResponseTo = "id-unknown" }, false, true},
+		{"wrong audience", func(d *responseData) { d.Audience = "https://other.example.com" }, false, true},
+		{"wrong recipient", func(d *responseData) { d.Recipient = "https://other.example.com/acs" }, false, true},
+		{"wrong destination", func(d *responseData) { d.Destination = "https://other.example.com/acs" }, false, true},
+		{"wrong issuer", func(d *responseData) { d.Issuer = "https://other-idp.example.com" }, false, true},
+		{"expired", func(d *responseData) { d.Now = time.Now().Add(-time.Hour) }, false, true},
+		{"not yet valid", func(d *responseData) { d.Now = time.Now().Add(time.Hour) }, false, true},
+		{"failure status", func(d *responseData) { d.Status = "urn:oasis:names:tc:SAML:2.0:status:Requester" }, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idp := newTestIDP(t)
+			sp := NewServiceProvider(idp.config())
+
+			d := responseData{InResponseTo: idp.authnRequest(t, sp)}
+			test.modify(&d)
+			_, err := sp.ParseResponse(idp.response(t, d, test.signR, test.signA), testACSURL)
+			IsType(t, &VerificationError{}, err)
+		})
+	}
+}
+
+func TestServiceProvider_RejectTamperedAssertion(t *testing.T) {
+	idp := newTestIDP(t)
+	sp := NewServiceProvider(idp.config())
+
+	requestID := idp.authnRequest(t, sp)
+	raw, _ := base64.StdEncoding.DecodeString(idp.response(t, responseData{InResponseTo: requestID}, false, true))
+	tampered := strings.Replace(string(raw), "bob@example.com</saml:NameID>", "admin@example.com</saml:NameID>", 1)
+	NotEqual(t, string(raw), tampered)
+
+	_, err := sp.ParseResponse(base64.StdEncoding.EncodeToString([]byte(tampered)), testACSURL)
+	IsType(t, &VerificationError{}, err)
+}
+
+func TestServiceProvider_RejectOtherCertificate(t *testing.T) {
+	idp := newTestIDP(t)
+	other := newTestIDP(t)
+	sp := NewServiceProvider(idp.config())
+
+	requestID := idp.authnRequest(t, sp)
+	_, err := sp.ParseResponse(other.response(t, responseData{InResponseTo: requestID}, false, true), testACSURL)
+	IsType(t, &VerificationError{}, err)
+}
+
+func TestServiceProvider_RejectInvalidEncoding(t *testing.T) {
+	sp := NewServiceProvider(newTestIDP(t).config())
+
+	_, err := sp.ParseResponse("%%%", testACSURL)
+	IsType(t, &VerificationError{}, err)
+
+	_, err = sp.ParseResponse(base64.StdEncoding.EncodeToString([]byte("<foo/>")), testACSURL)
+	IsType(t, &VerificationError{}, err)
+}
+
+func TestServiceProvider_Metadata(t *testing.T) {
+	sp := NewServiceProvider(newTestIDP(t).config())
+
+	b, err := sp.Metadata(testACSURL)
+	NoError(t, err)
+
+	doc := etree.NewDocument()
+	NoError(t, doc.ReadFromBytes(b))
+	Equal(t, "https://login.example.com", doc.Root().SelectAttrValue("entityID", ""))
+	acs := doc.FindElement("//AssertionConsumerService")
+	NotNil(t, acs)
+	Equal(t, testACSURL, acs.SelectAttrValue("Location", ""))
+	Equal(t, postBinding, acs.SelectAttrValue("Binding", ""))
+}
+
+func TestNewConfigFromOpts(t *testing.T) {
+	idp := newTestIDP(t)
+
+	certFile := writeTempFile(t, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: idp.cert.Raw})))
+	defer os.Remove(certFile)
+
+	cfg, err := NewConfigFromOpts(map[string]string{
+		"entity_id":       "https://login.example.com",
+		"idp_sso_url":     "https://idp.example.com/sso",
+		"idp_entity_id":   "https://idp.example.com",
+		"idp_certificate": certFile,
+		"name_attribute":  "cn",
+	})
+	NoError(t, err)
+	Equal(t, "https://idp.example.com/sso", cfg.IDPSSOURL)
+	Equal(t, "https://idp.example.com", cfg.IDPEntityID)
+	Equal(t, []*x509.Certificate{idp.cert}, cfg.IDPCertificates)
+	Equal(t, "cn", cfg.NameAttribute)
+	Equal(t, "email", cfg.EmailAttribute)
+	Equal(t, "groups", cfg.GroupsAttribute)
+
+	_, err = NewConfigFromOpts(map[string]string{"idp_sso_url": "https://idp.example.com/sso", "idp_certificate": certFile})
+	Error(t, err)
+
+	_, err = NewConfigFromOpts(map[string]string{"entity_id": "https://login.example.com", "idp_sso_url": "https://idp.example.com/sso"})
+	Error(t, err)
+
+	_, err = NewConfigFromOpts(map[string]string{"entity_id": "https://login.example.com", "idp_certificate": certFile})
+	Error(t, err)
+
+	_, err = NewConfigFromOpts(map[string]string{"entity_id": "https://login.example.com", "idp_sso_url": "https://idp.example.com/sso", "idp_certificate": "does-not-exist.pem"})
+	Error(t, err)
+}
+
+func TestNewConfigFromOpts_IDPMetadata(t *testing.T) {
+	idp := newTestIDP(t)
+
+	metadataFile := writeTempFile(t, fmt.Sprintf(`<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" entityID="https://idp.example.com">
+  <md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo><ds:X509Data><ds:X509Certificate>
+        %v
+      </ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://idp.example.com/sso/post"/>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso/redirect"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`, base64.StdEncoding.EncodeToString(idp.cert.Raw)))
+	defer os.Remove(metadataFile)
+
+	cfg, err := NewConfigFromOpts(map[string]string{
+		"entity_id":    "https://login.example.com",
+		"idp_metadata": metadataFile,
+	})
+	NoError(t, err)
+	Equal(t, "https://idp.example.com", cfg.IDPEntityID)
+	Equal(t, "https://idp.example.com/sso/redirect", cfg.IDPSSOURL)
+	Equal(t, []*x509.Certificate{idp.cert}, cfg.IDPCertificates)
+
+	invalidFile := writeTempFile(t, `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com"/>`)
+	defer os.Remove(invalidFile)
+	_, err = NewConfigFromOpts(map[string]string{
+		"entity_id":    "https://login.example.com",
+		"idp_metadata": invalidFile,
+	})
+	Error(t, err)
+}
+
+type testIDP struct {
+	keyStore dsig.X509KeyStore
+	cert     *x509.Certificate
+}
+
+func newTestIDP(t *testing.T) *testIDP {
+	ks := dsig.RandomKeyStoreForTest()
+	_, certDER, err := ks.GetKeyPair()
+	NoError(t, err)
+	cert, err := x509.ParseCertificate(certDER)
+	NoError(t, err)
+	return &testIDP{keyStore: ks, cert: cert}
+}
+
+func (idp *testIDP) config() Config {
+	return Config{
+		EntityID:        "https://login.example.com",
+		IDPEntityID:     "https://idp.example.com",
+		IDPSSOURL:       "https://idp.example.com/sso?tenant=example",
+		IDPCertificates: []*x509.Certificate{idp.cert},
+		NameAttribute:   "displayName",
+		EmailAttribute:  "urn:oid:0.9.2342.19200300.100.1.3",
+		GroupsAttribute: "groups",
+	}
+}
+
+// authnRequest does the redirect to the identity provider and returns the id of the request
+func (idp *testIDP) authnRequest(t *testing.T, sp *ServiceProvider) string {
+	authnURL, err := sp.AuthnRequestURL(testACSURL)
+	NoError(t, err)
+
+	u, err := url.Parse(authnURL)
+	NoError(t, err)
+	Equal(t, "idp.example.com", u.Host)
+	Equal(t, "example", u.Query().Get("tenant"))
+
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	NoError(t, err)
+	b, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	NoError(t, err)
+
+	req := authnRequest{}
+	NoError(t, xml.Unmarshal(b, &req))
+	Equal(t, "https://login.example.com", req.Issuer.Value)
+	Equal(t, testACSURL, req.AssertionConsumerServiceURL)
+	Equal(t, postBinding, req.ProtocolBinding)
+	True(t, strings.HasPrefix(req.ID, "id-"))
+	return req.ID
+}
+
+type responseData struct {
+	InResponseTo string
+	Issuer       string
+	Audience     string
+	Recipient    string
+	Destination  string
+	Status       string
+	Now          time.Time
+}
+
+// response returns the base64 encoded response, where the response and the assertion are signed as requested
+func (idp *testIDP) response(t *testing.T, d responseData, signResponse, signAssertion bool) string {
+	if d.Issuer == "" {
+		d.Issuer = "https://idp.example.com"
+	}
+	if d.Audience == "" {
+		d.Audience = "https://login.example.com"
+	}
+	if d.Recipient == "" {
+		d.Recipient = testACSURL
+	}
+	if d.Destination == "" {
+		d.Destination = testACSURL
+	}
+	if d.Status == "" {
+		d.Status = statusSuccess
+	}
+	if d.Now.IsZero() {
+		d.Now = time.Now()
+	}
+	instant := func(d time.Duration) string {
+		return time.Now().Add(d).UTC().Format(timeFormat)
+	}
+	offset := d.Now.Sub(time.Now())
+
+	doc := etree.NewDocument()
+	NoError(t, doc.ReadFromString(fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="id-response" Version="2.0" IssueInstant="%[1]v" Destination="%[2]v" InResponseTo="%[3]v">
+  <saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">%[4]v</saml:Issuer>
+  <samlp:Status><samlp:StatusCode Value="%[5]v"/></samlp:Status>
+  <saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-assertion" Version="2.0" IssueInstant="%[1]v">
+    <saml:Issuer>%[4]v</saml:Issuer>
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">bob@example.com</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData InResponseTo="%[3]v" NotOnOrAfter="%[6]v" Recipient="%[7]v"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="%[8]v" NotOnOrAfter="%[6]v">
+      <saml:AudienceRestriction><saml:Audience>%[9]v</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="displayName"><saml:AttributeValue>Bob</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="urn:oid:0.9.2342.19200300.100.1.3" FriendlyName="mail"><saml:AttributeValue>bob@example.com</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="groups"><saml:AttributeValue>admins</saml:AttributeValue><saml:AttributeValue>users</saml:AttributeValue></saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`,
+		instant(offset), d.Destination, d.InResponseTo, d.Issuer, d.Status,
+		instant(offset+5*time.Minute), d.Recipient, instant(offset-5*time.Minute), d.Audience)))
+
+	ctx := dsig.NewDefaultSigningContext(idp.keyStore)
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	if signAssertion {
+		response := doc.Root()
+		assertion := response.FindElement("./Assertion")
+		signed, err := ctx.SignEnveloped(assertion)
+		NoError(t, err)
+		response.RemoveChild(assertion)
+		response.AddChild(signed)
+	}
+	if signResponse {
+		signed, err := ctx.SignEnveloped(doc.Root())
+		NoError(t, err)
+		doc.SetRoot(signed)
+	}
+
+	b, err := doc.WriteToBytes()
+	NoError(t, err)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "loginsrv-saml")
+	NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(content)
+	NoError(t, err)
+	return f.Name()
+}
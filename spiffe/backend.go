@@ -0,0 +1,141 @@
+package spiffe
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ProviderName const
+const ProviderName = "spiffe"
+
+const defaultTimeout = 5 * time.Second
+
+var allowedAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "SPIFFE JWT-SVID login backend opts: bundle_endpoint=...,audience=...,trust_domain=...,timeout=...",
+			Options: []login.OptionDescription{
+				// the socket of the workload api is not supported, the backend factory explains the alternative
+				{Name: "socket"},
+				{Name: "bundle_endpoint", Type: login.OptionURL, Required: true},
+				{Name: "audience"},
+				{Name: "trust_domain"},
+				{Name: "timeout", Type: login.OptionDuration},
+			},
+		},
+		BackendFactory)
+}
+
+// BackendFactory creates a spiffe backend
+func BackendFactory(config map[string]string) (login.Backend, error) {
+	if _, exist := config["socket"]; exist {
+		return nil, errors.New(`the spiffe workload api socket is not supported, please use "bundle_endpoint"`)
+	}
+
+	endpoint, exist := config["bundle_endpoint"]
+	if !exist {
+		return nil, errors.New(`missing parameter "bundle_endpoint" for spiffe provider`)
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf(`invalid parameter value "%s" in "bundle_endpoint" spiffe provider: %v`, endpoint, err)
+	}
+
+	timeout := defaultTimeout
+	if ts, exist := config["timeout"]; exist {
+		var err error
+		timeout, err = time.ParseDuration(ts)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "timeout" spiffe provider: %v`, ts, err)
+		}
+	}
+
+	return NewBackend(NewBundle(endpoint, timeout), config["audience"], config["trust_domain"]), nil
+}
+
+// Backend authenticates workloads by their JWT-SVID.
+// The SPIFFE ID is expected as username and the JWT-SVID as password.
+type Backend struct {
+	bundle      *Bundle
+	audience    string
+	trustDomain string
+}
+
+// NewBackend creates a new spiffe Backend.
+func NewBackend(bundle *Bundle, audience, trustDomain string) *Backend {
+	return &Backend{
+		bundle:      bundle,
+		audience:    audience,
+		trustDomain: trustDomain,
+	}
+}
+
+// Authenticate the workload
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	var bundleErr error
+	parser := &jwt.Parser{ValidMethods: allowedAlgorithms}
+	token, err := parser.ParseWithClaims(password, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := b.bundle.Key(kid)
+		bundleErr = err
+		return key, err
+	})
+	if bundleErr != nil {
+		return false, model.UserInfo{}, bundleErr
+	}
+	if err != nil || !token.Valid {
+		return false, model.UserInfo{}, nil
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	sub, _ := claims["sub"].(string)
+	if !b.validClaims(claims, sub) || sub != username {
+		return false, model.UserInfo{}, nil
+	}
+
+	return true, model.UserInfo{
+		Origin: ProviderName,
+		Sub:    sub,
+	}, nil
+}
+
+func (b *Backend) validClaims(claims jwt.MapClaims, sub string) bool {
+	// JWT-SVIDs must always expire
+	if _, exist := claims["exp"]; !exist {
+		return false
+	}
+	if b.audience != "" && !containsAudience(claims["aud"], b.audience) {
+		return false
+	}
+	if !strings.HasPrefix(sub, "spiffe://") {
+		return false
+	}
+	if b.trustDomain != "" && !strings.HasPrefix(sub, "spiffe://"+b.trustDomain+"/") {
+		return false
+	}
+	return true
+}
+
+// containsAudience checks the aud claim, which may be a single string or a list.
+func containsAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
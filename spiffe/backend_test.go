@@ -0,0 +1,140 @@
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+const testID = "spiffe://example.org/ns/default/sa/api"
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{
+		"bundle_endpoint": "https://spire.example.org/bundle",
+		"audience":        "loginsrv",
+		"trust_domain":    "example.org",
+		"timeout":         "1s",
+	})
+	NoError(t, err)
+	Equal(t, "loginsrv", backend.(*Backend).audience)
+	Equal(t, "example.org", backend.(*Backend).trustDomain)
+	Equal(t, time.Second, backend.(*Backend).bundle.httpClient.Timeout)
+}
+
+func TestSetup_Errors(t *testing.T) {
+	_, err := BackendFactory(map[string]string{})
+	Error(t, err)
+
+	_, err = BackendFactory(map[string]string{"socket": "unix:///run/spire/sockets/agent.sock"})
+	Error(t, err)
+
+	_, err = BackendFactory(map[string]string{"bundle_endpoint": "https://spire.example.org/bundle", "timeout": "foo"})
+	Error(t, err)
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	key, server := bundleServer(t)
+	defer server.Close()
+	backend := NewBackend(NewBundle(server.URL, time.Second), "loginsrv", "example.org")
+
+	svid := signSVID(t, key, "key-1", jwt.MapClaims{"sub": testID, "aud": []string{"loginsrv"}, "exp": time.Now().Add(time.Minute).Unix()})
+	authenticated, userInfo, err := backend.Authenticate(testID, svid)
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, testID, userInfo.Sub)
+	Equal(t, ProviderName, userInfo.Origin)
+}
+
+func TestBackend_Authenticate_Failures(t *testing.T) {
+	key, server := bundleServer(t)
+	defer server.Close()
+	backend := NewBackend(NewBundle(server.URL, time.Second), "loginsrv", "example.org")
+	exp := time.Now().Add(time.Minute).Unix()
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+
+	tests := []struct {
+		name     string
+		username string
+		svid     string
+	}{
+		{"wrong username", "spiffe://example.org/other", signSVID(t, key, "key-1", jwt.MapClaims{"sub": testID, "aud": "loginsrv", "exp": exp})},
+		{"expired", testID, signSVID(t, key, "key-1", jwt.MapClaims{"sub": testID, "aud": "loginsrv", "exp": time.Now().Add(-time.Minute).Unix()})},
+		{"no expiry", testID, signSVID(t, key, "key-1", jwt.MapClaims{"sub": testID, "aud": "loginsrv"})},
+		{"wrong audience", testID, signSVID(t, key, "key-1", jwt.MapClaims{"sub": testID, "aud": "other", "exp": exp})},
+		{"wrong trust domain", "spiffe://evil.org/api", signSVID(t, key, "key-1", jwt.MapClaims{"sub": "spiffe://evil.org/api", "aud": "loginsrv", "exp": exp})},
+		{"no spiffe id", "bob", signSVID(t, key, "key-1", jwt.MapClaims{"sub": "bob", "aud": "loginsrv", "exp": exp})},
+		{"wrong key", testID, signSVID(t, otherKey, "key-1", jwt.MapClaims{"sub": testID, "aud": "loginsrv", "exp": exp})},
+		{"hmac", testID, hmacSVID(t, jwt.MapClaims{"sub": testID, "aud": "loginsrv", "exp": exp})},
+		{"garbage", testID, "foo.bar.baz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticated, _, err := backend.Authenticate(test.username, test.svid)
+			NoError(t, err)
+			False(t, authenticated)
+		})
+	}
+}
+
+func TestBackend_Authenticate_UnknownKid(t *testing.T) {
+	key, server := bundleServer(t)
+	defer server.Close()
+	backend := NewBackend(NewBundle(server.URL, time.Second), "", "")
+
+	svid := signSVID(t, key, "unknown", jwt.MapClaims{"sub": testID, "exp": time.Now().Add(time.Minute).Unix()})
+	authenticated, _, err := backend.Authenticate(testID, svid)
+	Error(t, err)
+	False(t, authenticated)
+}
+
+func bundleServer(t *testing.T) (*ecdsa.PrivateKey, *httptest.Server) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+
+	enc := base64.RawURLEncoding
+	bundle := fmt.Sprintf(`{
+  "keys": [
+    {"use": "x509-svid", "kty": "EC", "crv": "P-256", "x": "%v", "y": "%v"},
+    {"use": "jwt-svid", "kty": "EC", "kid": "key-1", "crv": "P-256", "x": "%v", "y": "%v"}
+  ],
+  "spiffe_refresh_hint": 60
+}`, enc.EncodeToString(key.X.Bytes()), enc.EncodeToString(key.Y.Bytes()), enc.EncodeToString(key.X.Bytes()), enc.EncodeToString(key.Y.Bytes()))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(bundle))
+	}))
+	return key, server
+}
+
+func signSVID(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	NoError(t, err)
+	return s
+}
+
+func hmacSVID(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "key-1"
+	s, err := token.SignedString([]byte("secret"))
+	NoError(t, err)
+	return s
+}
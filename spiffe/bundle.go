@@ -0,0 +1,169 @@
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultRefreshInterval = 5 * time.Minute
+
+// jwk is a single key of a SPIFFE bundle in JWK format.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// bundleDocument is the SPIFFE trust bundle as returned by the bundle endpoint.
+type bundleDocument struct {
+	Keys        []jwk `json:"keys"`
+	RefreshHint int   `json:"spiffe_refresh_hint"`
+}
+
+// Bundle fetches and caches the JWT-SVID signing keys from a SPIFFE bundle endpoint.
+type Bundle struct {
+	endpoint   string
+	httpClient *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	refresh   time.Duration
+}
+
+// NewBundle creates a bundle for the supplied bundle endpoint url.
+func NewBundle(endpoint string, timeout time.Duration) *Bundle {
+	return &Bundle{
+		endpoint:   endpoint,
+		httpClient: &http.Client{Timeout: timeout},
+		refresh:    defaultRefreshInterval,
+	}
+}
+
+// Key returns the public key for the supplied key id.
+// The bundle is refetched, if it is outdated or the key id is unknown.
+func (b *Bundle) Key(kid string) (interface{}, error) {
+	b.mu.RLock()
+	key, found := b.keys[kid]
+	outdated := time.Since(b.fetchedAt) > b.refresh
+	b.mu.RUnlock()
+
+	if found && !outdated {
+		return key, nil
+	}
+
+	if err := b.fetch(); err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	key, found = b.keys[kid]
+	if !found {
+		return nil, fmt.Errorf("no key with kid %q in spiffe bundle", kid)
+	}
+	return key, nil
+}
+
+func (b *Bundle) fetch() error {
+	resp, err := b.httpClient.Get(b.endpoint)
+	if err != nil {
+		return fmt.Errorf("error fetching spiffe bundle: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got http status %v on fetching spiffe bundle", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading spiffe bundle: %v", err)
+	}
+
+	doc := bundleDocument{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("error parsing spiffe bundle: %v", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range doc.Keys {
+		// x509-svid keys are not used to sign tokens
+		if k.Use != "" && k.Use != "jwt-svid" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return err
+		}
+		keys[k.Kid] = key
+	}
+
+	b.mu.Lock()
+	b.keys = keys
+	b.fetchedAt = time.Now()
+	if doc.RefreshHint > 0 {
+		b.refresh = time.Duration(doc.RefreshHint) * time.Second
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q for key %q in spiffe bundle", k.Crv, k.Kid)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q for key %q in spiffe bundle", k.Kty, k.Kid)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter in spiffe bundle: %v", err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/clientcert"
+	"github.com/afdecastro879/loginsrv/login"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTLSConfig returns the TLS configuration of the server by a certificate file or by ACME.
+// If TLS is disabled, the configuration is nil.
+// The handler is for the redirect address: it answers the ACME http-01 challenges
+// and redirects all other requests to https.
+func newTLSConfig(config *login.Config) (*tls.Config, http.Handler, error) {
+	redirect := httpsRedirect(config.Port)
+
+	switch {
+	case config.TLSCert != "" && config.ACMEDomains != "":
+		return nil, nil, errors.New("tls-cert and acme-domains can not be used together")
+
+	case config.TLSCert != "" || config.TLSKey != "":
+		if config.TLSCert == "" || config.TLSKey == "" {
+			return nil, nil, errors.New("tls-cert and tls-key have to be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		if err := requestClientCertificates(tlsConfig, config); err != nil {
+			return nil, nil, err
+		}
+		return tlsConfig, redirect, nil
+
+	case config.ACMEDomains != "":
+		if config.ACMECacheDir == "" {
+			return nil, nil, errors.New("acme-cache-dir is required, otherwise the certificates are requested again on every start")
+		}
+		domains := []string{}
+		for _, domain := range strings.Split(config.ACMEDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(config.ACMECacheDir),
+			Email:      config.ACMEEmail,
+		}
+		if config.ACMEDirectory != "" {
+			manager.Client = &acme.Client{DirectoryURL: config.ACMEDirectory}
+		}
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		if err := requestClientCertificates(tlsConfig, config); err != nil {
+			return nil, nil, err
+		}
+		return tlsConfig, manager.HTTPHandler(redirect), nil
+	}
+
+	if config.TLSRedirectAddress != "" {
+		return nil, nil, errors.New("tls-redirect-address requires tls-cert or acme-domains")
+	}
+	return nil, nil, nil
+}
+
+// requestClientCertificates asks the browsers for a client certificate of the x509 CAs, if the x509 login is configured.
+// The certificate is optional and verified by the login handler, so that users without a valid certificate get the login form.
+func requestClientCertificates(tlsConfig *tls.Config, config *login.Config) error {
+	if len(config.X509) == 0 {
+		return nil
+	}
+	cfg, err := clientcert.NewConfigFromOpts(config.X509)
+	if err != nil {
+		return err
+	}
+	pool, err := clientcert.LoadCertPool(cfg.CA)
+	if err != nil {
+		return err
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequestClientCert
+	return nil
+}
+
+// httpsRedirect redirects the requests to the https server on the port
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "use https", http.StatusBadRequest)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
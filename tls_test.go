@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/login"
+	. "github.com/stretchr/testify/assert"
+)
+
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "login.example.com"},
+		DNSNames:     []string{"login.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	NoError(t, err)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func Test_NewTLSConfig_Disabled(t *testing.T) {
+	tlsConfig, handler, err := newTLSConfig(login.DefaultConfig())
+	NoError(t, err)
+	Nil(t, tlsConfig)
+	Nil(t, handler)
+}
+
+func Test_NewTLSConfig_CertFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv-tls")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	config := login.DefaultConfig()
+	config.TLSCert = certFile
+	config.TLSKey = keyFile
+	tlsConfig, handler, err := newTLSConfig(config)
+	NoError(t, err)
+	Equal(t, 1, len(tlsConfig.Certificates))
+	Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	NotNil(t, handler)
+
+	config.TLSKey = filepath.Join(dir, "missing.pem")
+	_, _, err = newTLSConfig(config)
+	Error(t, err)
+}
+
+func Test_NewTLSConfig_ClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv-tls")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	config := login.DefaultConfig()
+	config.TLSCert = certFile
+	config.TLSKey = keyFile
+	config.X509 = map[string]string{"ca": certFile}
+	tlsConfig, _, err := newTLSConfig(config)
+	NoError(t, err)
+	Equal(t, tls.RequestClientCert, tlsConfig.ClientAuth)
+	NotNil(t, tlsConfig.ClientCAs)
+
+	config.X509 = map[string]string{"ca": keyFile}
+	_, _, err = newTLSConfig(config)
+	Error(t, err)
+}
+
+func Test_NewTLSConfig_ACME(t *testing.T) {
+	config := login.DefaultConfig()
+	config.ACMEDomains = "login.example.com, auth.example.com"
+	config.ACMEDirectory = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	tlsConfig, handler, err := newTLSConfig(config)
+	NoError(t, err)
+	NotNil(t, tlsConfig.GetCertificate)
+	Contains(t, tlsConfig.NextProtos, "acme-tls/1")
+
+	// the challenges are answered by the handler and the other requests redirected
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://login.example.com/login?foo=bar", nil))
+	Equal(t, 301, recorder.Code)
+	Equal(t, "https://login.example.com:6789/login?foo=bar", recorder.Header().Get("Location"))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://login.example.com/.well-known/acme-challenge/token", nil))
+	Equal(t, 404, recorder.Code)
+}
+
+func Test_NewTLSConfig_Invalid(t *testing.T) {
+	for _, modify := range []func(c *login.Config){
+		func(c *login.Config) { c.TLSCert = "cert.pem" },
+		func(c *login.Config) { c.TLSKey = "key.pem" },
+		func(c *login.Config) { c.TLSCert, c.TLSKey, c.ACMEDomains = "cert.pem", "key.pem", "example.com" },
+		func(c *login.Config) { c.ACMEDomains, c.ACMECacheDir = "example.com", "" },
+		func(c *login.Config) { c.TLSRedirectAddress = ":80" },
+	} {
+		config := login.DefaultConfig()
+		modify(config)
+		_, _, err := newTLSConfig(config)
+		Error(t, err)
+	}
+}
+
+func Test_HTTPSRedirect(t *testing.T) {
+	for _, test := range []struct {
+		port, url, location string
+	}{
+		{"443", "http://example.com/login", "https://example.com/login"},
+		{"", "http://example.com:80/login?a=b", "https://example.com/login?a=b"},
+		{"8443", "http://example.com:8080/", "https://example.com:8443/"},
+	} {
+		recorder := httptest.NewRecorder()
+		httpsRedirect(test.port).ServeHTTP(recorder, httptest.NewRequest("GET", test.url, nil))
+		Equal(t, 301, recorder.Code)
+		Equal(t, test.location, recorder.Header().Get("Location"))
+	}
+
+	recorder := httptest.NewRecorder()
+	httpsRedirect("443").ServeHTTP(recorder, httptest.NewRequest("POST", "http://example.com/login", nil))
+	Equal(t, 400, recorder.Code)
+}
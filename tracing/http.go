@@ -0,0 +1,145 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/logging"
+)
+
+const traceparentHeader = "Traceparent"
+
+// Extract returns the span context of the W3C traceparent header
+func Extract(h http.Header) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h.Get(traceparentHeader)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	// version 00 has exactly 4 parts, future versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	sc := SpanContext{}
+	flags := make([]byte, 1)
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags, []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Inject sets the W3C traceparent header of the span context
+func Inject(sc SpanContext, h http.Header) {
+	if !sc.IsValid() {
+		return
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	h.Set(traceparentHeader, fmt.Sprintf("00-%v-%v-%v", sc.TraceID, sc.SpanID, flags))
+}
+
+// StartServer starts the server span of an incoming request as child of the traceparent of the caller.
+// The returned request has the span in its context and the returned writer records the status code.
+// If tracing is disabled, w and r are returned unchanged.
+func StartServer(w http.ResponseWriter, r *http.Request, name string) (http.ResponseWriter, *http.Request, *Span) {
+	if !Enabled() {
+		return w, r, nil
+	}
+	ctx := r.Context()
+	if sc, ok := Extract(r.Header); ok {
+		ctx = ContextWithRemoteSpanContext(ctx, sc)
+	}
+	ctx, span := Start(ctx, name, KindServer)
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+	span.SetAttribute("server.address", r.Host)
+	span.SetAttribute("user_agent.original", r.UserAgent())
+	if id := logging.GetCorrelationId(r.Header); id != "" {
+		span.SetAttribute("loginsrv.correlation_id", id)
+	}
+	return &statusWriter{ResponseWriter: w, span: span}, r.WithContext(ctx), span
+}
+
+// statusWriter records the status code of the response in the server span
+type statusWriter struct {
+	http.ResponseWriter
+	span        *Span
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.span.SetAttribute("http.response.status_code", statusCode)
+		if statusCode >= 500 {
+			w.span.SetError(fmt.Errorf("%v %v", statusCode, http.StatusText(statusCode)))
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Transport creates client spans for outgoing requests and propagates them by the traceparent header.
+// Requests without a span in their context are not traced.
+type Transport struct {
+	// Base is the transport of the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+}
+
+// RoundTrip executes the request within a client span
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if SpanFromContext(r.Context()) == nil {
+		return base.RoundTrip(r)
+	}
+
+	_, span := Start(r.Context(), r.Method, KindClient)
+	defer span.End()
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("server.address", r.URL.Hostname())
+	span.SetAttribute("url.full", redactURL(r))
+
+	// the request must not be modified by a RoundTripper
+	outgoing := r.Clone(r.Context())
+	Inject(span.SpanContext(), outgoing.Header)
+
+	resp, err := base.RoundTrip(outgoing)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.SetError(fmt.Errorf("%v %v", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	}
+	return resp, nil
+}
+
+// redactURL returns the url without the query and credentials, which may contain secrets or tokens
+func redactURL(r *http.Request) string {
+	u := *r.URL
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
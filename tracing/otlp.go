@@ -0,0 +1,402 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/logging"
+)
+
+const (
+	defaultEndpoint    = "http://localhost:4318"
+	tracesPath         = "/v1/traces"
+	defaultTimeout     = 10 * time.Second
+	scheduleDelay      = 5 * time.Second
+	maxQueueSize       = 2048
+	maxExportBatchSize = 512
+	instrumentationLib = "github.com/afdecastro879/loginsrv"
+)
+
+// exporterConfig of the OTLP/HTTP exporter
+type exporterConfig struct {
+	endpoint           string
+	headers            map[string]string
+	timeout            time.Duration
+	resourceAttributes map[string]interface{}
+}
+
+// FromEnv creates a tracer by the standard OpenTelemetry environment variables.
+// Tracing is enabled, if OTEL_TRACES_EXPORTER is otlp or an OTLP endpoint is set:
+//
+//	OTEL_SDK_DISABLED                    true disables tracing
+//	OTEL_TRACES_EXPORTER                 otlp or none
+//	OTEL_EXPORTER_OTLP_ENDPOINT          base url of the collector, default http://localhost:4318
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT   full url of the traces, default <base url>/v1/traces
+//	OTEL_EXPORTER_OTLP_(TRACES_)HEADERS  comma separated key=value pairs
+//	OTEL_EXPORTER_OTLP_(TRACES_)TIMEOUT  in milliseconds, default 10000
+//	OTEL_EXPORTER_OTLP_(TRACES_)PROTOCOL only http/json is supported
+//	OTEL_SERVICE_NAME                    default is the supplied service name
+//	OTEL_RESOURCE_ATTRIBUTES             comma separated key=value pairs
+//	OTEL_TRACES_SAMPLER(_ARG)            default parentbased_always_on
+//
+// A nil tracer is returned, if tracing is not enabled.
+func FromEnv(serviceName string) (*Tracer, error) {
+	return fromEnv(os.Getenv, serviceName)
+}
+
+func fromEnv(getenv func(string) string, serviceName string) (*Tracer, error) {
+	if strings.EqualFold(getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil, nil
+	}
+	switch getenv("OTEL_TRACES_EXPORTER") {
+	case "none":
+		return nil, nil
+	case "":
+		if getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+			return nil, nil
+		}
+	case "otlp":
+	default:
+		return nil, fmt.Errorf("unsupported traces exporter %q, only otlp is supported", getenv("OTEL_TRACES_EXPORTER"))
+	}
+
+	protocol := signalEnv(getenv, "PROTOCOL")
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("unsupported otlp protocol %q, only http/json is supported", protocol)
+	}
+
+	cfg := exporterConfig{
+		endpoint:           getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+		timeout:            defaultTimeout,
+		resourceAttributes: map[string]interface{}{"service.name": serviceName},
+	}
+	if cfg.endpoint == "" {
+		base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			base = defaultEndpoint
+		}
+		cfg.endpoint = strings.TrimSuffix(base, "/") + tracesPath
+	}
+	if _, err := url.ParseRequestURI(cfg.endpoint); err != nil {
+		return nil, fmt.Errorf("invalid otlp endpoint %q: %v", cfg.endpoint, err)
+	}
+
+	headers, err := parseKeyValues(getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, err
+	}
+	traceHeaders, err := parseKeyValues(getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range traceHeaders {
+		headers[k] = v
+	}
+	cfg.headers = headers
+
+	if timeout := signalEnv(getenv, "TIMEOUT"); timeout != "" {
+		ms, err := strconv.Atoi(timeout)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("invalid otlp timeout %q", timeout)
+		}
+		cfg.timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	resourceAttributes, err := parseKeyValues(getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range resourceAttributes {
+		cfg.resourceAttributes[k] = v
+	}
+	if name := getenv("OTEL_SERVICE_NAME"); name != "" {
+		cfg.resourceAttributes["service.name"] = name
+	}
+
+	s, err := newSampler(getenv("OTEL_TRACES_SAMPLER"), getenv("OTEL_TRACES_SAMPLER_ARG"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tracer{
+		sampler:   s,
+		processor: newBatchProcessor(newExporter(cfg), scheduleDelay),
+	}, nil
+}
+
+// signalEnv returns the traces specific or the general OTLP variable
+func signalEnv(getenv func(string) string, name string) string {
+	if v := getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); v != "" {
+		return v
+	}
+	return getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// parseKeyValues parses the W3C baggage like format of the headers and resource attributes
+func parseKeyValues(s string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid key value pair %q", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %q: %v", kv[0], err)
+		}
+		result[strings.TrimSpace(kv[0])] = value
+	}
+	return result, nil
+}
+
+// exporter sends the spans to an OTLP/HTTP endpoint with JSON encoding
+type exporter struct {
+	config exporterConfig
+	client *http.Client
+}
+
+func newExporter(cfg exporterConfig) *exporter {
+	return &exporter{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.timeout},
+	}
+}
+
+func (e *exporter) export(spans []*Span) error {
+	b, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest("POST", e.config.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range e.config.headers {
+		r.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp endpoint returned status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// The types of the OTLP JSON encoding
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// The OTLP status codes
+const (
+	statusError = 2
+)
+
+func (e *exporter) request(spans []*Span) otlpRequest {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mutex.Lock()
+		span := otlpSpan{
+			TraceID:           s.context.TraceID.String(),
+			SpanID:            s.context.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attributes),
+		}
+		if s.parentID.IsValid() {
+			span.ParentSpanID = s.parentID.String()
+		}
+		if s.statusError {
+			span.Status = otlpStatus{Code: statusError, Message: s.statusMessage}
+		}
+		s.mutex.Unlock()
+		otlpSpans = append(otlpSpans, span)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: attributes(e.config.resourceAttributes)},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: instrumentationLib},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+}
+
+// attributes converts the attributes to OTLP key values, sorted by key
+func attributes(m map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]otlpKeyValue, 0, len(m))
+	for _, k := range keys {
+		kv := otlpKeyValue{Key: k}
+		switch v := m[k].(type) {
+		case string:
+			kv.Value.StringValue = &v
+		case bool:
+			kv.Value.BoolValue = &v
+		case int:
+			s := strconv.Itoa(v)
+			kv.Value.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			kv.Value.IntValue = &s
+		case float64:
+			kv.Value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			kv.Value.StringValue = &s
+		}
+		result = append(result, kv)
+	}
+	return result
+}
+
+// batchProcessor collects the ended spans and exports them in batches in the background,
+// so the requests are not delayed by the collector.
+type batchProcessor struct {
+	exporter interface {
+		export(spans []*Span) error
+	}
+	queue    chan *Span
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newBatchProcessor(e *exporter, delay time.Duration) *batchProcessor {
+	p := &batchProcessor{
+		exporter: e,
+		queue:    make(chan *Span, maxQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run(delay)
+	return p
+}
+
+// add queues the span. If the queue is full, the span is dropped.
+func (p *batchProcessor) add(s *Span) {
+	select {
+	case p.queue <- s:
+	default:
+		logging.Logger.Warn("tracing queue is full, dropping span")
+	}
+}
+
+func (p *batchProcessor) run(delay time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(delay)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxExportBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.exporter.export(batch); err != nil {
+			logging.Logger.WithError(err).Warnf("can't export %v spans", len(batch))
+		}
+		batch = make([]*Span, 0, maxExportBatchSize)
+	}
+
+	for {
+		select {
+		case s := <-p.queue:
+			batch = append(batch, s)
+			if len(batch) >= maxExportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-p.stop:
+			for {
+				select {
+				case s := <-p.queue:
+					batch = append(batch, s)
+					if len(batch) >= maxExportBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// shutdown exports the queued spans and waits for the export
+func (p *batchProcessor) shutdown() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	<-p.done
+}
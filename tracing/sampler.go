@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// sampler decides, if a new span is recorded
+type sampler func(parent SpanContext, traceID TraceID) bool
+
+func alwaysOn(parent SpanContext, traceID TraceID) bool {
+	return true
+}
+
+func alwaysOff(parent SpanContext, traceID TraceID) bool {
+	return false
+}
+
+// traceIDRatio samples the fraction of the traces by the lower bytes of the trace id,
+// like the other OpenTelemetry implementations, so a trace is sampled consistently.
+func traceIDRatio(fraction float64) sampler {
+	if fraction >= 1 {
+		return alwaysOn
+	}
+	if fraction <= 0 {
+		return alwaysOff
+	}
+	upperBound := uint64(fraction * (1 << 63))
+	return func(parent SpanContext, traceID TraceID) bool {
+		return binary.BigEndian.Uint64(traceID[8:16])>>1 < upperBound
+	}
+}
+
+// parentBased follows the decision of the parent and uses the root sampler for new traces
+func parentBased(root sampler) sampler {
+	return func(parent SpanContext, traceID TraceID) bool {
+		if parent.IsValid() {
+			return parent.Sampled
+		}
+		return root(parent, traceID)
+	}
+}
+
+// newSampler creates a sampler of OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG
+func newSampler(name, arg string) (sampler, error) {
+	ratio := func() (float64, error) {
+		if arg == "" {
+			return 1, nil
+		}
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid sampler argument %q: %v", arg, err)
+		}
+		return f, nil
+	}
+
+	switch name {
+	case "always_on":
+		return alwaysOn, nil
+	case "always_off":
+		return alwaysOff, nil
+	case "traceidratio":
+		f, err := ratio()
+		return traceIDRatio(f), err
+	case "parentbased_always_on", "":
+		return parentBased(alwaysOn), nil
+	case "parentbased_always_off":
+		return parentBased(alwaysOff), nil
+	case "parentbased_traceidratio":
+		f, err := ratio()
+		return parentBased(traceIDRatio(f)), err
+	}
+	return nil, fmt.Errorf("unsupported sampler %q", name)
+}
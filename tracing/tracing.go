@@ -0,0 +1,220 @@
+// Package tracing provides OpenTelemetry compatible tracing of loginsrv.
+//
+// The spans are propagated by the W3C Trace Context headers and exported
+// by OTLP over HTTP with JSON encoding. The exporter is configured by the
+// standard OTEL_* environment variables, see FromEnv.
+// Without a Tracer, all functions are no-ops and return nil spans,
+// which can be used like real ones.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID is the id of a trace
+type TraceID [16]byte
+
+// IsValid returns true, if the id is not zero
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID is the id of a span
+type SpanID [8]byte
+
+// IsValid returns true, if the id is not zero
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span within its trace
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid returns true, if trace and span id are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind is the role of a span, with the values of OTLP
+type SpanKind int
+
+// The supported span kinds
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Span is a timed operation of a trace.
+// All methods can be called on a nil span, which is returned if tracing is disabled.
+type Span struct {
+	tracer        *Tracer
+	name          string
+	kind          SpanKind
+	context       SpanContext
+	parentID      SpanID
+	start         time.Time
+	mutex         sync.Mutex
+	end           time.Time
+	attributes    map[string]interface{}
+	statusError   bool
+	statusMessage string
+}
+
+// SpanContext returns the ids of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute sets an attribute of the span.
+// The value should be a string, bool, int, int64 or float64. Other values are converted to strings.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed, if err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.statusError = true
+	s.statusMessage = err.Error()
+}
+
+// End finishes the span and hands it over to the exporter, if it is sampled.
+// Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if !s.end.IsZero() {
+		s.mutex.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mutex.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.processor.add(s)
+	}
+}
+
+// Tracer creates the spans and exports them
+type Tracer struct {
+	sampler   sampler
+	processor *batchProcessor
+}
+
+// Shutdown exports the remaining spans and stops the exporter
+func (t *Tracer) Shutdown() {
+	if t != nil {
+		t.processor.shutdown()
+	}
+}
+
+var (
+	globalMutex  sync.RWMutex
+	globalTracer *Tracer
+)
+
+// SetTracer sets the tracer of the package functions. Nil disables tracing.
+func SetTracer(t *Tracer) {
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+	globalTracer = t
+}
+
+func tracer() *Tracer {
+	globalMutex.RLock()
+	defer globalMutex.RUnlock()
+	return globalTracer
+}
+
+// Enabled returns true, if a tracer is set
+func Enabled() bool {
+	return tracer() != nil
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the current span of the context or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a context with the span context of a caller as parent
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Start creates a child span of the current or remote span of the context, or a new trace.
+// The returned context contains the new span.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	t := tracer()
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanFromContext(ctx).SpanContext()
+	if !parent.IsValid() {
+		parent, _ = ctx.Value(remoteKey{}).(SpanContext)
+	}
+
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+	} else {
+		sc.TraceID = newTraceID()
+	}
+	sc.Sampled = t.sampler(parent, sc.TraceID)
+
+	span := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		context:    sc,
+		parentID:   parent.SpanID,
+		start:      time.Now(),
+		attributes: map[string]interface{}{},
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return id
+}
@@ -0,0 +1,332 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// testCollector receives the spans of the OTLP requests
+type testCollector struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	headers http.Header
+	request otlpRequest
+}
+
+func newTestCollector() *testCollector {
+	c := &testCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.headers = r.Header
+		received := otlpRequest{}
+		json.NewDecoder(r.Body).Decode(&received)
+		if len(c.request.ResourceSpans) == 0 {
+			c.request = received
+		} else {
+			spans := &c.request.ResourceSpans[0].ScopeSpans[0].Spans
+			*spans = append(*spans, received.ResourceSpans[0].ScopeSpans[0].Spans...)
+		}
+	}))
+	return c
+}
+
+func (c *testCollector) spans() []otlpSpan {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.request.ResourceSpans) == 0 {
+		return nil
+	}
+	return c.request.ResourceSpans[0].ScopeSpans[0].Spans
+}
+
+func testTracer(t *testing.T, collector *testCollector, env map[string]string) *Tracer {
+	getenv := func(name string) string {
+		if name == "OTEL_EXPORTER_OTLP_ENDPOINT" {
+			return collector.URL
+		}
+		return env[name]
+	}
+	tracer, err := fromEnv(getenv, "loginsrv")
+	NoError(t, err)
+	NotNil(t, tracer)
+	SetTracer(tracer)
+	return tracer
+}
+
+func TestStart_Disabled(t *testing.T) {
+	SetTracer(nil)
+	ctx := context.Background()
+	newCtx, span := Start(ctx, "test", KindInternal)
+	Nil(t, span)
+	Equal(t, ctx, newCtx)
+
+	// the nil span can be used
+	span.SetAttribute("foo", "bar")
+	span.SetError(errors.New("oops"))
+	span.End()
+	False(t, span.SpanContext().IsValid())
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/login", nil)
+	w2, r2, span := StartServer(w, r, "GET /login")
+	Nil(t, span)
+	Equal(t, w, w2)
+	Equal(t, r, r2)
+}
+
+func TestTracer_Export(t *testing.T) {
+	collector := newTestCollector()
+	defer collector.Close()
+	tracer := testTracer(t, collector, map[string]string{
+		"OTEL_EXPORTER_OTLP_HEADERS": "Authorization=Bearer%20secret",
+		"OTEL_RESOURCE_ATTRIBUTES":   "deployment.environment=test",
+	})
+	defer SetTracer(nil)
+
+	ctx, parent := Start(context.Background(), "parent", KindServer)
+	_, child := Start(ctx, "child", KindInternal)
+	child.SetAttribute("string", "value")
+	child.SetAttribute("bool", true)
+	child.SetAttribute("int", 42)
+	child.SetAttribute("float", 1.5)
+	child.SetError(errors.New("oops"))
+	child.End()
+	child.End()
+	parent.End()
+	tracer.Shutdown()
+
+	Equal(t, "Bearer secret", collector.headers.Get("Authorization"))
+	Equal(t, "application/json", collector.headers.Get("Content-Type"))
+
+	resource := collector.request.ResourceSpans[0].Resource.Attributes
+	Equal(t, 2, len(resource))
+	Equal(t, "deployment.environment", resource[0].Key)
+	Equal(t, "service.name", resource[1].Key)
+	Equal(t, "loginsrv", *resource[1].Value.StringValue)
+
+	spans := collector.spans()
+	Equal(t, 2, len(spans))
+	c, p := spans[0], spans[1]
+	Equal(t, "child", c.Name)
+	Equal(t, KindInternal, c.Kind)
+	Equal(t, p.TraceID, c.TraceID)
+	Equal(t, p.SpanID, c.ParentSpanID)
+	Equal(t, parent.SpanContext().TraceID.String(), p.TraceID)
+	Equal(t, "", p.ParentSpanID)
+	Equal(t, KindServer, p.Kind)
+	Equal(t, otlpStatus{Code: statusError, Message: "oops"}, c.Status)
+	Equal(t, otlpStatus{}, p.Status)
+
+	Equal(t, 4, len(c.Attributes))
+	Equal(t, "bool", c.Attributes[0].Key)
+	True(t, *c.Attributes[0].Value.BoolValue)
+	Equal(t, 1.5, *c.Attributes[1].Value.DoubleValue)
+	Equal(t, "42", *c.Attributes[2].Value.IntValue)
+	Equal(t, "value", *c.Attributes[3].Value.StringValue)
+}
+
+func TestTracer_NotSampled(t *testing.T) {
+	collector := newTestCollector()
+	defer collector.Close()
+	tracer := testTracer(t, collector, map[string]string{"OTEL_TRACES_SAMPLER": "always_off"})
+	defer SetTracer(nil)
+
+	_, span := Start(context.Background(), "test", KindInternal)
+	NotNil(t, span)
+	True(t, span.SpanContext().IsValid())
+	False(t, span.SpanContext().Sampled)
+	span.End()
+	tracer.Shutdown()
+	Equal(t, 0, len(collector.spans()))
+}
+
+func TestStartServer_And_Transport(t *testing.T) {
+	collector := newTestCollector()
+	defer collector.Close()
+	tracer := testTracer(t, collector, nil)
+	defer SetTracer(nil)
+
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(404)
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		outgoing, _ := http.NewRequest("GET", upstream.URL+"/user?access_token=secret", nil)
+		resp, err := client.Do(outgoing.WithContext(r.Context()))
+		NoError(t, err)
+		resp.Body.Close()
+		w.WriteHeader(503)
+	}
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w, r, span := StartServer(httptest.NewRecorder(), r, "POST /login")
+	handler(w, r)
+	span.End()
+
+	// without a span in the context, the call is not traced
+	outgoing, _ := http.NewRequest("GET", upstream.URL, nil)
+	resp, err := client.Do(outgoing)
+	NoError(t, err)
+	resp.Body.Close()
+	Equal(t, "", traceparent)
+
+	tracer.Shutdown()
+
+	spans := collector.spans()
+	Equal(t, 2, len(spans))
+	clientSpan, serverSpan := spans[0], spans[1]
+	Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.TraceID)
+	Equal(t, "00f067aa0ba902b7", serverSpan.ParentSpanID)
+	Equal(t, KindServer, serverSpan.Kind)
+	Equal(t, statusError, serverSpan.Status.Code)
+
+	Equal(t, serverSpan.SpanID, clientSpan.ParentSpanID)
+	Equal(t, KindClient, clientSpan.Kind)
+	Equal(t, statusError, clientSpan.Status.Code)
+	for _, a := range clientSpan.Attributes {
+		if a.Key == "url.full" {
+			Equal(t, upstream.URL+"/user", *a.Value.StringValue)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	sc := SpanContext{
+		TraceID: TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		Sampled: true,
+	}
+	h := http.Header{}
+	Inject(sc, h)
+	Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", h.Get("traceparent"))
+
+	extracted, ok := Extract(h)
+	True(t, ok)
+	Equal(t, sc, extracted)
+
+	sc.Sampled = false
+	Inject(sc, h)
+	Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", h.Get("traceparent"))
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		_, ok := Extract(http.Header{"Traceparent": {invalid}})
+		False(t, ok, invalid)
+	}
+
+	// future versions may have more fields
+	_, ok = Extract(http.Header{"Traceparent": {"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"}})
+	True(t, ok)
+}
+
+func TestSampler(t *testing.T) {
+	sampled := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}, Sampled: true}
+	notSampled := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}}
+	low := TraceID{15: 1}
+	high := TraceID{8: 0xff, 15: 0xff}
+
+	s, err := newSampler("", "")
+	NoError(t, err)
+	True(t, s(SpanContext{}, low))
+	True(t, s(sampled, low))
+	False(t, s(notSampled, low))
+
+	s, err = newSampler("traceidratio", "0.5")
+	NoError(t, err)
+	True(t, s(notSampled, low))
+	False(t, s(sampled, high))
+
+	s, err = newSampler("parentbased_traceidratio", "0.5")
+	NoError(t, err)
+	True(t, s(SpanContext{}, low))
+	False(t, s(SpanContext{}, high))
+	True(t, s(sampled, high))
+
+	s, err = newSampler("always_off", "")
+	NoError(t, err)
+	False(t, s(sampled, low))
+
+	_, err = newSampler("traceidratio", "foo")
+	Error(t, err)
+	_, err = newSampler("jaeger_remote", "")
+	Error(t, err)
+}
+
+func TestFromEnv(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(name string) string {
+			return vars[name]
+		}
+	}
+
+	// disabled
+	for _, vars := range []map[string]string{
+		{},
+		{"OTEL_TRACES_EXPORTER": "none", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"},
+		{"OTEL_SDK_DISABLED": "true", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"},
+	} {
+		tracer, err := fromEnv(env(vars), "loginsrv")
+		NoError(t, err)
+		Nil(t, tracer, vars)
+	}
+
+	tracer, err := fromEnv(env(map[string]string{"OTEL_TRACES_EXPORTER": "otlp"}), "loginsrv")
+	NoError(t, err)
+	Equal(t, "http://localhost:4318/v1/traces", tracer.processor.exporter.(*exporter).config.endpoint)
+	tracer.Shutdown()
+
+	tracer, err = fromEnv(env(map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT":       "http://collector:4318/",
+		"OTEL_EXPORTER_OTLP_HEADERS":        "a=1,b=2",
+		"OTEL_EXPORTER_OTLP_TRACES_HEADERS": "b=3",
+		"OTEL_EXPORTER_OTLP_TIMEOUT":        "2000",
+		"OTEL_SERVICE_NAME":                 "login",
+		"OTEL_RESOURCE_ATTRIBUTES":          "service.name=other,service.version=1.0",
+	}), "loginsrv")
+	NoError(t, err)
+	cfg := tracer.processor.exporter.(*exporter).config
+	Equal(t, "http://collector:4318/v1/traces", cfg.endpoint)
+	Equal(t, map[string]string{"a": "1", "b": "3"}, cfg.headers)
+	Equal(t, 2*time.Second, cfg.timeout)
+	Equal(t, map[string]interface{}{"service.name": "login", "service.version": "1.0"}, cfg.resourceAttributes)
+	tracer.Shutdown()
+
+	tracer, err = fromEnv(env(map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "https://collector/traces"}), "loginsrv")
+	NoError(t, err)
+	Equal(t, "https://collector/traces", tracer.processor.exporter.(*exporter).config.endpoint)
+	tracer.Shutdown()
+
+	for _, vars := range []map[string]string{
+		{"OTEL_TRACES_EXPORTER": "zipkin"},
+		{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_PROTOCOL": "grpc"},
+		{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL": "http/protobuf"},
+		{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_HEADERS": "foo"},
+		{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_TIMEOUT": "soon"},
+		{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_TRACES_SAMPLER": "foo"},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT": "::"},
+	} {
+		_, err := fromEnv(env(vars), "loginsrv")
+		Error(t, err, vars)
+	}
+}
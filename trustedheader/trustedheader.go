@@ -0,0 +1,108 @@
+// Package trustedheader implements the login by the identity headers of an upstream SSO proxy, e.g. oauth2-proxy.
+// The headers are only accepted from the trusted proxies, because they can be set by any other client.
+package trustedheader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/afdecastro879/loginsrv/trustedproxy"
+)
+
+// ProviderName is the origin of the users, logged in by the headers of a trusted proxy
+const ProviderName = "trustedheader"
+
+// Config of the trusted header login
+type Config struct {
+	// User is the header of the username. The email address is used, if it is empty.
+	User string
+	// Email, Name and Groups are the optional headers of the email, name and groups claims.
+	// The groups are separated by comma.
+	Email          string
+	Name           string
+	Groups         string
+	TrustedProxies []string
+}
+
+// NewConfigFromOpts creates the configuration from the options of the trusted-header flag.
+func NewConfigFromOpts(opts map[string]string) (Config, error) {
+	cfg := Config{
+		User:   "X-Forwarded-User",
+		Email:  opts["email"],
+		Name:   opts["name"],
+		Groups: opts["groups"],
+	}
+	for key, value := range opts {
+		switch key {
+		case "email", "name", "groups":
+		case "user":
+			cfg.User = value
+		case "trusted_proxies":
+			cfg.TrustedProxies = strings.Split(value, ";")
+			if _, err := trustedproxy.Parse(cfg.TrustedProxies); err != nil {
+				return Config{}, fmt.Errorf(`invalid parameter value "%v" in "trusted_proxies" for trusted-header: %v`, value, err)
+			}
+		default:
+			return Config{}, fmt.Errorf(`unknown parameter "%v" for trusted-header`, key)
+		}
+	}
+	if len(cfg.TrustedProxies) == 0 {
+		return Config{}, errors.New(`missing parameter "trusted_proxies" for trusted-header`)
+	}
+	if cfg.User == "" && cfg.Email == "" {
+		return Config{}, errors.New(`parameter "user" or "email" is required for trusted-header`)
+	}
+	return cfg, nil
+}
+
+// Service reads the identity of the users from the headers of the trusted proxies.
+type Service struct {
+	config  Config
+	proxies trustedproxy.Networks
+}
+
+// NewService creates the service.
+func NewService(cfg Config) (*Service, error) {
+	proxies, err := trustedproxy.Parse(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{config: cfg, proxies: proxies}, nil
+}
+
+// Authenticate returns the user of the headers.
+// The bool return parameter is false, if the request has no user header.
+// Requests with a user header from other addresses than the trusted proxies return an error.
+func (s *Service) Authenticate(r *http.Request) (model.UserInfo, bool, error) {
+	userInfo := model.UserInfo{
+		Sub:    s.header(r, s.config.User),
+		Email:  s.header(r, s.config.Email),
+		Name:   s.header(r, s.config.Name),
+		Origin: ProviderName,
+	}
+	if userInfo.Sub == "" {
+		userInfo.Sub = userInfo.Email
+	}
+	if userInfo.Sub == "" {
+		return model.UserInfo{}, false, nil
+	}
+	if !s.proxies.Contains(r.RemoteAddr) {
+		return model.UserInfo{}, true, fmt.Errorf("identity header of %v from the untrusted address %v", userInfo.Sub, r.RemoteAddr)
+	}
+	for _, group := range strings.Split(s.header(r, s.config.Groups), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			userInfo.Groups = append(userInfo.Groups, group)
+		}
+	}
+	return userInfo, true, nil
+}
+
+func (s *Service) header(r *http.Request, name string) string {
+	if name == "" {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(name))
+}
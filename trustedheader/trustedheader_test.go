@@ -0,0 +1,91 @@
+package trustedheader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestNewConfigFromOpts(t *testing.T) {
+	cfg, err := NewConfigFromOpts(map[string]string{"trusted_proxies": "127.0.0.1"})
+	NoError(t, err)
+	Equal(t, Config{User: "X-Forwarded-User", TrustedProxies: []string{"127.0.0.1"}}, cfg)
+
+	cfg, err = NewConfigFromOpts(map[string]string{
+		"user":            "",
+		"email":           "X-Auth-Request-Email",
+		"name":            "X-Auth-Request-Preferred-Username",
+		"groups":          "X-Auth-Request-Groups",
+		"trusted_proxies": "127.0.0.1;10.0.0.0/8",
+	})
+	NoError(t, err)
+	Equal(t, Config{
+		Email:          "X-Auth-Request-Email",
+		Name:           "X-Auth-Request-Preferred-Username",
+		Groups:         "X-Auth-Request-Groups",
+		TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8"},
+	}, cfg)
+
+	for _, opts := range []map[string]string{
+		{},
+		{"trusted_proxies": "localhost"},
+		{"trusted_proxies": "127.0.0.1", "user": ""},
+		{"trusted_proxies": "127.0.0.1", "foo": "bar"},
+	} {
+		_, err := NewConfigFromOpts(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func testRequest(remoteAddr string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	return r
+}
+
+func TestService_Authenticate(t *testing.T) {
+	s, err := NewService(Config{
+		User:           "X-Forwarded-User",
+		Email:          "X-Forwarded-Email",
+		Groups:         "X-Forwarded-Groups",
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	NoError(t, err)
+
+	userInfo, present, err := s.Authenticate(testRequest("10.1.2.3:4711", map[string]string{
+		"X-Forwarded-User":   "bob",
+		"X-Forwarded-Email":  "bob@example.com",
+		"X-Forwarded-Groups": "admin, dev,",
+	}))
+	NoError(t, err)
+	True(t, present)
+	Equal(t, model.UserInfo{Sub: "bob", Email: "bob@example.com", Groups: []string{"admin", "dev"}, Origin: ProviderName}, userInfo)
+
+	// the email address, if the proxy sends no username
+	userInfo, _, err = s.Authenticate(testRequest("10.1.2.3:4711", map[string]string{"X-Forwarded-Email": "alice@example.com"}))
+	NoError(t, err)
+	Equal(t, "alice@example.com", userInfo.Sub)
+
+	_, present, err = s.Authenticate(testRequest("10.1.2.3:4711", nil))
+	NoError(t, err)
+	False(t, present)
+}
+
+func TestService_Authenticate_Untrusted(t *testing.T) {
+	s, err := NewService(Config{User: "X-Forwarded-User", TrustedProxies: []string{"10.0.0.0/8"}})
+	NoError(t, err)
+
+	_, present, err := s.Authenticate(testRequest("192.0.2.1:4711", map[string]string{"X-Forwarded-User": "bob"}))
+	Error(t, err)
+	True(t, present)
+
+	_, present, err = s.Authenticate(testRequest("192.0.2.1:4711", nil))
+	NoError(t, err)
+	False(t, present)
+}
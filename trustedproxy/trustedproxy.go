@@ -0,0 +1,52 @@
+// Package trustedproxy matches the clients of the requests against the reverse proxies, whose headers are trusted.
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Networks are the addresses of the trusted proxies
+type Networks []*net.IPNet
+
+// Parse parses the trusted proxies, given as IP addresses or CIDR networks
+func Parse(values []string) (Networks, error) {
+	networks := make(Networks, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address %v", value)
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains returns true, if the remote address of a request is one of the trusted proxies.
+// Forwarding headers are ignored, because they can be set by the client.
+func (n Networks) Contains(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package trustedproxy
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	networks, err := Parse([]string{"192.0.2.1", " 10.0.0.0/8", "::1"})
+	NoError(t, err)
+	Equal(t, 3, len(networks))
+
+	_, err = Parse([]string{"localhost"})
+	Error(t, err)
+
+	_, err = Parse([]string{"10.0.0.0/33"})
+	Error(t, err)
+}
+
+func TestNetworks_Contains(t *testing.T) {
+	networks, err := Parse([]string{"192.0.2.1", "10.0.0.0/8", "::1"})
+	NoError(t, err)
+
+	True(t, networks.Contains("192.0.2.1:4711"))
+	True(t, networks.Contains("10.1.2.3:4711"))
+	True(t, networks.Contains("[::1]:4711"))
+	True(t, networks.Contains("10.1.2.3"))
+	False(t, networks.Contains("192.0.2.2:4711"))
+	False(t, networks.Contains("@"))
+	False(t, networks.Contains(""))
+	False(t, Networks{}.Contains("10.1.2.3:4711"))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/afdecastro879/loginsrv/logging"
+	"github.com/afdecastro879/loginsrv/login"
+)
+
+// validateConfigCommand is the subcommand, which checks the configuration without starting the server
+const validateConfigCommand = "validate-config"
+
+// runValidateConfig reads the configuration of the args and returns the exit code
+func runValidateConfig(args []string) int {
+	config := login.ReadConfigArgs(args)
+	if err := validateConfig(config); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		return 1
+	}
+	fmt.Println("configuration is valid")
+	return 0
+}
+
+// validateConfig does the setup of the server, but neither listens nor serves
+func validateConfig(config *login.Config) error {
+	if err := logging.Configure(logging.Options{
+		Level:           config.LogLevel,
+		Format:          config.LogFormat,
+		ComponentLevels: config.LogComponentLevels,
+		Redact:          config.LogRedact,
+	}); err != nil {
+		return err
+	}
+	if _, _, err := newTLSConfig(config); err != nil {
+		return err
+	}
+	if _, err := newGRPCServer(config, nil); err != nil {
+		return err
+	}
+	h, err := login.NewHandler(config)
+	if err != nil {
+		return err
+	}
+	return h.Close()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/login"
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_ValidateConfig(t *testing.T) {
+	config := login.DefaultConfig()
+	config.Backends = login.Options{"simple": {"bob": "secret"}}
+	NoError(t, validateConfig(config))
+
+	config.LogLevel = "nonsense"
+	Error(t, validateConfig(config))
+
+	config = login.DefaultConfig()
+	config.TLSCert = "does-not-exist.pem"
+	config.TLSKey = "does-not-exist.key"
+	Error(t, validateConfig(config))
+
+	config = login.DefaultConfig()
+	Error(t, validateConfig(config), "no login backend is configured")
+}
@@ -0,0 +1,92 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE key parameters (RFC 8152)
+const (
+	coseKeyType   = 1
+	coseAlg       = 3
+	coseEC2Curve  = -1
+	coseEC2X      = -2
+	coseEC2Y      = -3
+	coseRSAN      = -1
+	coseRSAE      = -2
+	coseKtyEC2    = 2
+	coseKtyRSA    = 3
+	coseCurveP256 = 1
+)
+
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parseCOSEKey parses an ES256 or RS256 public key in COSE format.
+func parseCOSEKey(data []byte) (coseKey, error) {
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return coseKey{}, fmt.Errorf("invalid credential public key: %v", err)
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return coseKey{}, errors.New("invalid credential public key: not a map")
+	}
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == algES256:
+		crv, _ := m[int64(coseEC2Curve)].(int64)
+		x, _ := m[int64(coseEC2X)].([]byte)
+		y, _ := m[int64(coseEC2Y)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return coseKey{}, errors.New("invalid ES256 credential public key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return coseKey{}, errors.New("invalid ES256 credential public key: point not on curve")
+		}
+		return coseKey{alg: alg, key: key}, nil
+	case kty == coseKtyRSA && alg == algRS256:
+		n, _ := m[int64(coseRSAN)].([]byte)
+		e, _ := m[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return coseKey{}, errors.New("invalid RS256 credential public key")
+		}
+		return coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	default:
+		return coseKey{}, fmt.Errorf("unsupported credential public key: kty %v, alg %v", kty, alg)
+	}
+}
+
+func (k coseKey) verify(data, signature []byte) error {
+	digest := sha256.Sum256(data)
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		sig := struct{ R, S *big.Int }{}
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) != 0 {
+			return errors.New("invalid ES256 signature encoding")
+		}
+		if !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return errors.New("unsupported key")
+	}
+}
@@ -0,0 +1,90 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var credentialsBucket = []byte("webauthn_credentials")
+
+// CredentialStore persists the registered credentials.
+type CredentialStore interface {
+	// Get returns the credential with the id and false, if there is none.
+	Get(id []byte) (Credential, bool, error)
+
+	// Save stores the credential or updates an existing one.
+	Save(cred Credential) error
+
+	// ForUser returns all credentials of the user.
+	ForUser(userInfo model.UserInfo) ([]Credential, error)
+}
+
+// BoltCredentialStore is a CredentialStore backed by a BoltDB file.
+type BoltCredentialStore struct {
+	db *bolt.DB
+}
+
+// NewBoltCredentialStore opens or creates the BoltDB file.
+func NewBoltCredentialStore(file string) (*BoltCredentialStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open webauthn credentials file %v", file)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(credentialsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "can't initialize webauthn credentials file %v", file)
+	}
+	return &BoltCredentialStore{db: db}, nil
+}
+
+// Get returns the credential with the id
+func (s *BoltCredentialStore) Get(id []byte) (cred Credential, exist bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(credentialsBucket).Get(id)
+		if v == nil {
+			return nil
+		}
+		exist = true
+		return json.Unmarshal(v, &cred)
+	})
+	return cred, exist, err
+}
+
+// Save stores the credential by its id
+func (s *BoltCredentialStore) Save(cred Credential) error {
+	b, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(credentialsBucket).Put(cred.ID, b)
+	})
+}
+
+// ForUser returns the credentials of the user by a scan of all credentials
+func (s *BoltCredentialStore) ForUser(userInfo model.UserInfo) ([]Credential, error) {
+	creds := []Credential{}
+	handle := userHandle(userInfo)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(credentialsBucket).ForEach(func(k, v []byte) error {
+			cred := Credential{}
+			if err := json.Unmarshal(v, &cred); err != nil {
+				return err
+			}
+			if bytes.Equal(userHandle(cred.UserInfo), handle) {
+				creds = append(creds, cred)
+			}
+			return nil
+		})
+	})
+	return creds, err
+}
@@ -0,0 +1,428 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afdecastro879/loginsrv/model"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagAttestedData = 0x40
+
+	challengeLength = 32
+)
+
+// COSE algorithm identifiers
+const (
+	algES256 = -7
+	algRS256 = -257
+)
+
+// Config of the relying party
+type Config struct {
+	// RPID is the domain of the relying party, e.g. example.com
+	RPID   string
+	RPName string
+	// Origin is the origin of the login page, e.g. https://login.example.com
+	Origin  string
+	Timeout time.Duration
+}
+
+// VerificationError is returned, if the response of the authenticator is not valid.
+type VerificationError struct {
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return "webauthn verification failed: " + e.Reason
+}
+
+func verificationErrorf(format string, a ...interface{}) error {
+	return &VerificationError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// URLEncodedBytes are bytes, which are encoded as base64url in json.
+type URLEncodedBytes []byte
+
+// MarshalJSON encodes the bytes as base64url without padding
+func (b URLEncodedBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes base64url with or without padding
+func (b *URLEncodedBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// Credential is a registered public key credential of a user.
+type Credential struct {
+	ID URLEncodedBytes `json:"id"`
+	// PublicKey is the COSE encoded public key
+	PublicKey URLEncodedBytes `json:"public_key"`
+	SignCount uint32          `json:"sign_count"`
+	UserInfo  model.UserInfo  `json:"user"`
+	CreatedAt int64           `json:"created_at"`
+}
+
+type challenge struct {
+	expiry       time.Time
+	registration bool
+	userInfo     model.UserInfo
+}
+
+// RelyingParty does the registration and authentication of the credentials.
+// The challenges are kept in memory until they are used or expired.
+type RelyingParty struct {
+	config Config
+	store  CredentialStore
+
+	mutex      sync.Mutex
+	challenges map[string]challenge
+}
+
+// NewRelyingParty creates a RelyingParty
+func NewRelyingParty(config Config, store CredentialStore) *RelyingParty {
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Minute
+	}
+	return &RelyingParty{
+		config:     config,
+		store:      store,
+		challenges: map[string]challenge{},
+	}
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          URLEncodedBytes `json:"id"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string          `json:"type"`
+	ID   URLEncodedBytes `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the options for navigator.credentials.create()
+type CreationOptions struct {
+	PublicKey struct {
+		Challenge              URLEncodedBytes        `json:"challenge"`
+		RP                     rpEntity               `json:"rp"`
+		User                   userEntity             `json:"user"`
+		PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                  `json:"timeout"`
+		Attestation            string                 `json:"attestation"`
+		ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	} `json:"publicKey"`
+}
+
+// RequestOptions are the options for navigator.credentials.get()
+type RequestOptions struct {
+	PublicKey struct {
+		Challenge        URLEncodedBytes `json:"challenge"`
+		RPID             string          `json:"rpId"`
+		Timeout          int64           `json:"timeout"`
+		UserVerification string          `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+// credentialResponse is the json encoded PublicKeyCredential of the browser
+type credentialResponse struct {
+	ID       string          `json:"id"`
+	RawID    URLEncodedBytes `json:"rawId"`
+	Type     string          `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+		AttestationObject URLEncodedBytes `json:"attestationObject"`
+		AuthenticatorData URLEncodedBytes `json:"authenticatorData"`
+		Signature         URLEncodedBytes `json:"signature"`
+		UserHandle        URLEncodedBytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string          `json:"type"`
+	Challenge URLEncodedBytes `json:"challenge"`
+	Origin    string          `json:"origin"`
+}
+
+// BeginRegistration creates the options to register a new credential for the user.
+func (rp *RelyingParty) BeginRegistration(userInfo model.UserInfo) (CreationOptions, error) {
+	options := CreationOptions{}
+	c, err := rp.newChallenge(challenge{registration: true, userInfo: userInfo})
+	if err != nil {
+		return options, err
+	}
+
+	existing, err := rp.store.ForUser(userInfo)
+	if err != nil {
+		return options, err
+	}
+
+	displayName := userInfo.Name
+	if displayName == "" {
+		displayName = userInfo.Sub
+	}
+
+	pk := &options.PublicKey
+	pk.Challenge = c
+	pk.RP = rpEntity{ID: rp.config.RPID, Name: rp.config.RPName}
+	pk.User = userEntity{ID: userHandle(userInfo), Name: userInfo.Sub, DisplayName: displayName}
+	pk.PubKeyCredParams = []credentialParameter{{"public-key", algES256}, {"public-key", algRS256}}
+	pk.Timeout = int64(rp.config.Timeout / time.Millisecond)
+	pk.Attestation = "none"
+	pk.ExcludeCredentials = []credentialDescriptor{}
+	for _, cred := range existing {
+		pk.ExcludeCredentials = append(pk.ExcludeCredentials, credentialDescriptor{Type: "public-key", ID: cred.ID})
+	}
+	pk.AuthenticatorSelection = authenticatorSelection{ResidentKey: "preferred", UserVerification: "preferred"}
+	return options, nil
+}
+
+// FinishRegistration verifies the response of navigator.credentials.create() and stores the new credential.
+// The attestation statement is not verified, so any authenticator is accepted.
+func (rp *RelyingParty) FinishRegistration(response []byte) (Credential, error) {
+	resp := credentialResponse{}
+	if err := json.Unmarshal(response, &resp); err != nil {
+		return Credential{}, verificationErrorf("invalid response: %v", err)
+	}
+
+	c, err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		return Credential{}, err
+	}
+
+	attestation, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, verificationErrorf("invalid attestation object: %v", err)
+	}
+	attestationMap, _ := attestation.(map[interface{}]interface{})
+	rawAuthData, _ := attestationMap["authData"].([]byte)
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.credentialID == nil {
+		return Credential{}, verificationErrorf("no attested credential data")
+	}
+	if !bytes.Equal(authData.credentialID, resp.RawID) {
+		return Credential{}, verificationErrorf("credential id does not match")
+	}
+	if _, err := parseCOSEKey(authData.publicKey); err != nil {
+		return Credential{}, verificationErrorf("%v", err)
+	}
+
+	if _, exist, err := rp.store.Get(authData.credentialID); err != nil {
+		return Credential{}, err
+	} else if exist {
+		return Credential{}, verificationErrorf("credential is already registered")
+	}
+
+	cred := Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+		UserInfo:  c.userInfo,
+		CreatedAt: time.Now().Unix(),
+	}
+	return cred, rp.store.Save(cred)
+}
+
+// BeginLogin creates the options for the authentication with a discoverable credential.
+func (rp *RelyingParty) BeginLogin() (RequestOptions, error) {
+	options := RequestOptions{}
+	c, err := rp.newChallenge(challenge{})
+	if err != nil {
+		return options, err
+	}
+	options.PublicKey.Challenge = c
+	options.PublicKey.RPID = rp.config.RPID
+	options.PublicKey.Timeout = int64(rp.config.Timeout / time.Millisecond)
+	options.PublicKey.UserVerification = "preferred"
+	return options, nil
+}
+
+// FinishLogin verifies the response of navigator.credentials.get() and returns the user of the credential.
+func (rp *RelyingParty) FinishLogin(response []byte) (model.UserInfo, error) {
+	resp := credentialResponse{}
+	if err := json.Unmarshal(response, &resp); err != nil {
+		return model.UserInfo{}, verificationErrorf("invalid response: %v", err)
+	}
+
+	if _, err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get"); err != nil {
+		return model.UserInfo{}, err
+	}
+
+	cred, exist, err := rp.store.Get(resp.RawID)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	if !exist {
+		return model.UserInfo{}, verificationErrorf("unknown credential")
+	}
+	if len(resp.Response.UserHandle) > 0 && !bytes.Equal(resp.Response.UserHandle, userHandle(cred.UserInfo)) {
+		return model.UserInfo{}, verificationErrorf("user handle does not match the credential")
+	}
+
+	authData, err := rp.parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+
+	key, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := key.verify(signed, resp.Response.Signature); err != nil {
+		return model.UserInfo{}, verificationErrorf("%v", err)
+	}
+
+	// authenticators without a counter always send 0
+	if (authData.signCount != 0 || cred.SignCount != 0) && authData.signCount <= cred.SignCount {
+		return model.UserInfo{}, verificationErrorf("signature counter did not increase, the authenticator may be cloned")
+	}
+	cred.SignCount = authData.signCount
+	if err := rp.store.Save(cred); err != nil {
+		return model.UserInfo{}, err
+	}
+	return cred.UserInfo, nil
+}
+
+func (rp *RelyingParty) newChallenge(c challenge) ([]byte, error) {
+	b := make([]byte, challengeLength)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	now := time.Now()
+	for key, existing := range rp.challenges {
+		if now.After(existing.expiry) {
+			delete(rp.challenges, key)
+		}
+	}
+	c.expiry = now.Add(rp.config.Timeout)
+	rp.challenges[string(b)] = c
+	return b, nil
+}
+
+// takeChallenge returns and removes the challenge, so that every challenge can be used once.
+func (rp *RelyingParty) takeChallenge(b []byte) (challenge, bool) {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	c, exist := rp.challenges[string(b)]
+	delete(rp.challenges, string(b))
+	if !exist || time.Now().After(c.expiry) {
+		return challenge{}, false
+	}
+	return c, true
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, expectedType string) (challenge, error) {
+	cd := clientData{}
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return challenge{}, verificationErrorf("invalid client data: %v", err)
+	}
+	if cd.Type != expectedType {
+		return challenge{}, verificationErrorf("unexpected client data type %q", cd.Type)
+	}
+	if cd.Origin != rp.config.Origin {
+		return challenge{}, verificationErrorf("unexpected origin %q", cd.Origin)
+	}
+	c, exist := rp.takeChallenge(cd.Challenge)
+	if !exist || c.registration != (expectedType == "webauthn.create") {
+		return challenge{}, verificationErrorf("unknown or expired challenge")
+	}
+	return c, nil
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData parses and checks the authenticator data:
+// rpIdHash (32) | flags (1) | signCount (4) | [aaguid (16) | credentialIdLength (2) | credentialId | publicKey]
+func (rp *RelyingParty) parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, verificationErrorf("authenticator data too short")
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.config.RPID))
+	if subtle.ConstantTimeCompare(rpIDHash[:], data[:32]) != 1 {
+		return authenticatorData{}, verificationErrorf("authenticator data is for another relying party")
+	}
+
+	authData := authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return authenticatorData{}, verificationErrorf("user was not present")
+	}
+
+	if authData.flags&flagAttestedData != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return authenticatorData{}, verificationErrorf("attested credential data too short")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < 18+idLength {
+			return authenticatorData{}, verificationErrorf("attested credential data too short")
+		}
+		authData.credentialID = rest[18 : 18+idLength]
+		_, keyLength, err := decodeCBOR(rest[18+idLength:])
+		if err != nil {
+			return authenticatorData{}, verificationErrorf("invalid credential public key: %v", err)
+		}
+		authData.publicKey = rest[18+idLength : 18+idLength+keyLength]
+	}
+	return authData, nil
+}
+
+// userHandle is the user id of the credential, which is unique per origin and subject.
+// It is hashed, because the user handle must not contain personal information.
+func userHandle(userInfo model.UserInfo) []byte {
+	h := sha256.Sum256([]byte(userInfo.Origin + ":" + userInfo.Sub))
+	return h[:]
+}
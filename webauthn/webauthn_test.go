@@ -0,0 +1,284 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/afdecastro879/loginsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+var testConfig = Config{RPID: "example.com", RPName: "Example", Origin: "https://login.example.com"}
+
+var testUser = model.UserInfo{Sub: "bob", Name: "Bob", Origin: "htpasswd"}
+
+func TestRelyingParty_RegisterAndLogin(t *testing.T) {
+	rp := NewRelyingParty(testConfig, newMemoryStore())
+	a := newTestAuthenticator(t)
+
+	options, err := rp.BeginRegistration(testUser)
+	NoError(t, err)
+	Equal(t, "example.com", options.PublicKey.RP.ID)
+	Equal(t, "bob", options.PublicKey.User.Name)
+	Equal(t, 32, len(options.PublicKey.User.ID))
+
+	cred, err := rp.FinishRegistration(a.create(t, options.PublicKey.Challenge, testConfig.Origin))
+	NoError(t, err)
+	Equal(t, a.id, []byte(cred.ID))
+	Equal(t, testUser, cred.UserInfo)
+
+	// the same credential can not be registered twice
+	options, _ = rp.BeginRegistration(testUser)
+	Equal(t, 1, len(options.PublicKey.ExcludeCredentials))
+	_, err = rp.FinishRegistration(a.create(t, options.PublicKey.Challenge, testConfig.Origin))
+	IsType(t, &VerificationError{}, err)
+
+	requestOptions, err := rp.BeginLogin()
+	NoError(t, err)
+	response := a.get(t, requestOptions.PublicKey.Challenge, testConfig.Origin, 1)
+	userInfo, err := rp.FinishLogin(response)
+	NoError(t, err)
+	Equal(t, testUser, userInfo)
+
+	// a challenge can only be used once
+	_, err = rp.FinishLogin(response)
+	IsType(t, &VerificationError{}, err)
+
+	// the signature counter has to increase
+	requestOptions, _ = rp.BeginLogin()
+	_, err = rp.FinishLogin(a.get(t, requestOptions.PublicKey.Challenge, testConfig.Origin, 1))
+	IsType(t, &VerificationError{}, err)
+
+	requestOptions, _ = rp.BeginLogin()
+	_, err = rp.FinishLogin(a.get(t, requestOptions.PublicKey.Challenge, testConfig.Origin, 2))
+	NoError(t, err)
+}
+
+func TestRelyingParty_Errors(t *testing.T) {
+	rp := NewRelyingParty(testConfig, newMemoryStore())
+	a := newTestAuthenticator(t)
+
+	// wrong origin
+	options, _ := rp.BeginRegistration(testUser)
+	_, err := rp.FinishRegistration(a.create(t, options.PublicKey.Challenge, "https://evil.example.org"))
+	IsType(t, &VerificationError{}, err)
+
+	// unknown challenge
+	_, err = rp.FinishRegistration(a.create(t, []byte("unknown"), testConfig.Origin))
+	IsType(t, &VerificationError{}, err)
+
+	// a login challenge can not be used for the registration
+	requestOptions, _ := rp.BeginLogin()
+	_, err = rp.FinishRegistration(a.create(t, requestOptions.PublicKey.Challenge, testConfig.Origin))
+	IsType(t, &VerificationError{}, err)
+
+	// unknown credential
+	requestOptions, _ = rp.BeginLogin()
+	_, err = rp.FinishLogin(a.get(t, requestOptions.PublicKey.Challenge, testConfig.Origin, 1))
+	IsType(t, &VerificationError{}, err)
+
+	// invalid signature
+	options, _ = rp.BeginRegistration(testUser)
+	_, err = rp.FinishRegistration(a.create(t, options.PublicKey.Challenge, testConfig.Origin))
+	NoError(t, err)
+	other := newTestAuthenticator(t)
+	other.id = a.id
+	requestOptions, _ = rp.BeginLogin()
+	_, err = rp.FinishLogin(other.get(t, requestOptions.PublicKey.Challenge, testConfig.Origin, 1))
+	IsType(t, &VerificationError{}, err)
+
+	_, err = rp.FinishLogin([]byte("foo"))
+	IsType(t, &VerificationError{}, err)
+}
+
+func TestBoltCredentialStore(t *testing.T) {
+	f, err := ioutil.TempFile("", "loginsrv_webauthn")
+	NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	s, err := NewBoltCredentialStore(f.Name())
+	NoError(t, err)
+	cred := Credential{ID: []byte("id"), PublicKey: []byte("key"), SignCount: 3, UserInfo: testUser}
+	NoError(t, s.Save(cred))
+
+	stored, exist, err := s.Get([]byte("id"))
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, cred, stored)
+
+	creds, err := s.ForUser(testUser)
+	NoError(t, err)
+	Equal(t, []Credential{cred}, creds)
+	creds, err = s.ForUser(model.UserInfo{Sub: "alice"})
+	NoError(t, err)
+	Empty(t, creds)
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	// {"a": 1, -2: h'0102', "c": [true, null]}
+	v, n, err := decodeCBOR([]byte{0xa3, 0x61, 'a', 0x01, 0x21, 0x42, 0x01, 0x02, 0x61, 'c', 0x82, 0xf5, 0xf6, 0xff})
+	NoError(t, err)
+	Equal(t, 13, n)
+	Equal(t, map[interface{}]interface{}{"a": int64(1), int64(-2): []byte{1, 2}, "c": []interface{}{true, nil}}, v)
+
+	for _, invalid := range [][]byte{{}, {0x42, 0x01}, {0x9f}, {0xa1, 0x80, 0x01}, {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}} {
+		_, _, err := decodeCBOR(invalid)
+		Error(t, err, "%x", invalid)
+	}
+}
+
+type testAuthenticator struct {
+	id  []byte
+	key *ecdsa.PrivateKey
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{id: id, key: key}
+}
+
+func (a *testAuthenticator) create(t *testing.T, challenge []byte, origin string) []byte {
+	coseKey := encodeCBOR(map[interface{}]interface{}{
+		1: 2, 3: -7, -1: 1,
+		-2: padTo32(a.key.X.Bytes()),
+		-3: padTo32(a.key.Y.Bytes()),
+	})
+	authData := a.authData(flagUserPresent|flagAttestedData, 0)
+	authData = append(authData, make([]byte, 16)...)
+	authData = append(authData, byte(len(a.id)>>8), byte(len(a.id)))
+	authData = append(authData, a.id...)
+	authData = append(authData, coseKey...)
+
+	attestationObject := encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": authData,
+	})
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(clientDataJSON(t, "webauthn.create", challenge, origin)),
+		"attestationObject": b64(attestationObject),
+	})
+}
+
+func (a *testAuthenticator) get(t *testing.T, challenge []byte, origin string, signCount uint32) []byte {
+	authData := a.authData(flagUserPresent, signCount)
+	cd := clientDataJSON(t, "webauthn.get", challenge, origin)
+	cdHash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), cdHash[:]...))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	NoError(t, err)
+	sig, err := asn1.Marshal(struct{ R, S interface{} }{r, s})
+	NoError(t, err)
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(cd),
+		"authenticatorData": b64(authData),
+		"signature":         b64(sig),
+		"userHandle":        b64(userHandle(testUser)),
+	})
+}
+
+func (a *testAuthenticator) authData(flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(testConfig.RPID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], signCount)
+	return data
+}
+
+func (a *testAuthenticator) response(t *testing.T, response map[string]string) []byte {
+	b, err := json.Marshal(map[string]interface{}{
+		"id":       b64(a.id),
+		"rawId":    b64(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	NoError(t, err)
+	return b
+}
+
+func clientDataJSON(t *testing.T, typ string, challenge []byte, origin string) []byte {
+	b, err := json.Marshal(map[string]string{"type": typ, "challenge": b64(challenge), "origin": origin})
+	NoError(t, err)
+	return b
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func padTo32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+// encodeCBOR encodes the subset of CBOR, which is needed to build test responses
+func encodeCBOR(v interface{}) []byte {
+	header := func(major byte, n int) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return header(1, -1-v)
+		}
+		return header(0, v)
+	case string:
+		return append(header(3, len(v)), v...)
+	case []byte:
+		return append(header(2, len(v)), v...)
+	case map[interface{}]interface{}:
+		b := header(5, len(v))
+		for key, value := range v {
+			b = append(b, encodeCBOR(key)...)
+			b = append(b, encodeCBOR(value)...)
+		}
+		return b
+	}
+	panic("unsupported type")
+}
+
+type memoryStore map[string]Credential
+
+func newMemoryStore() memoryStore {
+	return memoryStore{}
+}
+
+func (s memoryStore) Get(id []byte) (Credential, bool, error) {
+	cred, exist := s[string(id)]
+	return cred, exist, nil
+}
+
+func (s memoryStore) Save(cred Credential) error {
+	s[string(cred.ID)] = cred
+	return nil
+}
+
+func (s memoryStore) ForUser(userInfo model.UserInfo) ([]Credential, error) {
+	creds := []Credential{}
+	for _, cred := range s {
+		if cred.UserInfo.Origin == userInfo.Origin && cred.UserInfo.Sub == userInfo.Sub {
+			creds = append(creds, cred)
+		}
+	}
+	return creds, nil
+}